@@ -13,52 +13,127 @@ import (
 
 type UserOption struct {
 	appendArgs           string              // not exported, content decoded into ExtraArgs
+	AddService           string              // adds a new service to the workspace's services-overrides.json
+	Api                  bool                // serves a rest api on localhost to control services, with --daemon
+	ApiIndex             bool                // serves a page linking to the openapi specs of the running services
+	ApiPort              int                 // the port --api listens on
 	AutoComplete         bool                // generates an autocomplete response
+	BuildAndRun          string              // builds a service from a local checkout with sbt publishLocal and runs what it built
+	Capture              string              // comma separated services to record the requests made to, with --start
+	CaptureBodies        bool                // records the request and response bodies too, with --capture
 	CheckPorts           bool                // finds duplicate ports
+	CiOutput             string              // the json file --ci-start writes the ports and versions of the services to
+	CiStart              bool                // starts services for a ci job, waiting for them to be healthy
+	CiStop               bool                // stops the services started by --ci-start
 	Clean                bool                // used with --start to force re-downloading
 	CompWordCount        int                 // used with --autocomplete number of words in completion
 	CompPreviousWord     string              // used with --autocomplete previous of word in completion
+	CompWords            string              // used with --autocomplete the words before the one being completed
 	Config               string              // uses a different service-manager-config folder
+	ConfigDiff           bool                // shows how the services in effect differ from the shared config
+	ConfigGet            string              // prints a value from the user's defaults file
+	ConfigSet            string              // KEY=VALUE, checks and writes a value to the user's defaults file
+	Cors                 string              // comma separated origins the reverse proxy adds cors headers for
+	Daemon               bool                // runs sm2 as a long running agent, recording health checks and keeping services running
 	Debug                string              // debug info about a service, used to determine why it failed to start
+	Dedupe               bool                // links identical jars in the installed services to one copy, to save disk space
+	DiskUsage            bool                // shows the disk space used by each installed service, and what could be reclaimed
 	Diagnostic           bool                // runs tests to determine if there are problems with the install
+	Docker               bool                // runs services that have a docker image as containers
+	DynamicPorts         bool                // starts services on a free port instead of the one in services.json
+	ExportCompose        string              // writes a docker-compose file for the given services and profiles
+	ExportIntellij       bool                // writes .run configurations for the given services and profiles
+	ExportLaunchd        string              // prints a LaunchAgent that runs the daemon for a profile at login
+	ExportPorts          string              // prints the ports to forward in a devcontainer for the given services
+	ExportProxy          string              // prints nginx or caddy config that routes to the running services
+	ExportSystemd        string              // prints a systemd user unit that starts the service with sm2
+	ExportVscode         bool                // writes .vscode tasks and launch configs for the given services and profiles
 	ExtraArgs            map[string][]string // parsed from content of AppendArgs
 	ExtraServices        []string            // ids of services to start
 	FromSource           bool                // used with --start to run from source rather than bin
+	Format               string              // go template used to format each line of --status output
 	FormatPlain          bool                // flag for setting enabling machine friendly/undecorated output
 	GenerateAutoComplete bool                // generates an autocomplete script
+	Grpc                 bool                // serves a grpc api on localhost to control services and stream events, with --daemon
+	GrpcPort             int                 // the port --grpc listens on
+	HealthReport         bool                // shows health check latency and flakiness recorded by --daemon
+	HostsSync            bool                // adds the services' proxyHosts to /etc/hosts, removing ones that are no longer used
+	Https                bool                // serves the reverse proxy over https with a locally generated certificate
+	ImportV1             string              // converts a services.json from the original service-manager
+	Init                 bool                // sets up the workspace, config and defaults for a new install
+	Jdwp                 bool                // starts services with the jvm debugger listening on their debug port
 	Latest               bool                // used in conjunction with --restart to check for latest version of service(s) being restarted
+	LintConfig           bool                // checks for duplicate ports, names and artifacts and broken profiles
+	Machine              bool                // for jenkins and scripts: no prompts, progress or colour, json events on stdout and documented exit codes
 	List                 bool                // lists all the services
 	Logs                 string              // prints the logs of a service, running or otherwise
 	NoPortCheck          bool                // stops the `lsof` port check
 	NoProgress           bool                // hides the animated download progress meter
 	NoVpnCheck           bool                // skips checking if vpn is connected before starting a service
 	Offline              bool                // prints downloaded services, used with --start bypasses download and uses local copy
+	Open                 string              // opens a running service in the browser
+	Overlay              string              // comma separated names of overlays (from overlays.json) to apply to the services
+	PinConfig            string              // checks out a specific ref of service-manager-config and stops --update-config changing it
 	Port                 int                 // overrides service port, only works with the first service when starting multiple
 	Ports                bool                // prints all the ports
-	Prune                bool                // deletes .state files of services with a status of FAIL
+	ProfileUrl           string              // downloads a shared profile to use instead of one from profiles.json
+	Prune                bool                // deletes .state files of services with a status of FAIL, and installs that aren't in the config
+	Refresh              bool                // looks up the latest versions in artifactory again, rather than using ones cached in the workspace
 	Release              string              // specify a version when starting one service. unlikely old sm, cannot be used without a version
+	Remote               string              // runs --start, --stop etc on an sm2 daemon elsewhere, e.g. in a devcontainer, over ssh or http
+	Repo                 string              // the checkout --build-and-run builds, the current dir by default
 	Restart              bool                // restarts a service or profile
 	ReverseProxy         bool                // starts a reverse-proxy on 3000 (override with --port)
 	Search               string              // searches for services/profiles
+	ServeStub            string              // runs a stub server from a stub config file, used by --stub
+	SetSecret            string              // stores a secret in the encrypted secrets file, reading the value from stdin
+	ServeCapture         string              // runs a capture proxy from a capture config file, used by --capture
+	Settings             map[string]string   // workspace settings from the user's defaults file
 	Start                bool                // starts a service, multiple services or a profile(s)
 	Status               bool                // shows status of everything that's running
 	StatusShort          bool                // same as --status but is the -s short version of the cmd
 	StopAll              bool                // stops all the services that are running
 	Stop                 bool                // stops a service, multiple services or profile(s)
+	Stub                 string              // comma separated services to start as stubs instead of running them
+	Timings              bool                // with --start, prints how long each service took to resolve, download, extract, start and pass its health check
+	Traffic              string              // prints the requests recorded for a service started with --capture
+	Ui                   bool                // a full screen terminal ui to start, stop and watch services, `sm2 ui`
 	Update               bool                // update sm2 if a newer version is available
 	UpdateConfig         bool                // pulls the latest copy of service-manager-config
+	UseWorkspace         string              // switches to a named workspace, NAME or NAME=PATH
+	ValidateConfig       bool                // checks services.json and profiles.json for mistakes
 	Verbose              bool                // shows extra logging
 	Version              bool                // prints sm2 version number
 	Verify               bool                // checks if a given service or profile is running
+	Watch                bool                // with --status, redraws the status every second
 	Wait                 int                 // waits given number of secs after starting services for then to respond to pings
+	WhyFailed            string              // post-mortem of a service that failed to start
 	Workers              int                 // sets the number of concurrent downloads/service starts
+	Workspaces           bool                // lists the named workspaces
 	DelaySeconds         int                 // sets the pause in seconds between starting services
 }
 
 func Parse(args []string) (*UserOption, error) {
+	return ParseWithDefaults(args, nil)
+}
+
+// parses the command line over the top of the user's defaults (if they have any)
+func ParseWithDefaults(args []string, defaults *Defaults) (*UserOption, error) {
 
 	opts := new(UserOption)
 	flagset := BuildFlagSet(opts)
+	if defaults != nil {
+		if err := defaults.apply(flagset); err != nil {
+			return nil, fmt.Errorf("problem with defaults in %s: %s", DefaultsFile(), err)
+		}
+	}
+
+	// sm2 ui, which can't be a service as their ids are upper case
+	if len(args) > 0 && args[0] == "ui" {
+		opts.Ui = true
+		args = args[1:]
+	}
+
 	flagset.Parse(fixupInvalidFlags(args))
 
 	if opts.Workers <= 0 {
@@ -90,6 +165,22 @@ func Parse(args []string) (*UserOption, error) {
 		}
 		opts.ExtraArgs = args
 	}
+
+	if defaults != nil {
+		opts.Settings = defaults.Settings
+	}
+
+	// default args are only used for services that weren't given args on the command line
+	if defaults != nil && len(defaults.AppendArgs) > 0 {
+		if opts.ExtraArgs == nil {
+			opts.ExtraArgs = map[string][]string{}
+		}
+		for service, args := range defaults.AppendArgs {
+			if _, ok := opts.ExtraArgs[service]; !ok {
+				opts.ExtraArgs[service] = args
+			}
+		}
+	}
 	return opts, nil
 }
 
@@ -162,43 +253,100 @@ func BuildFlagSet(opts *UserOption) *flag.FlagSet {
 	flagset := flag.NewFlagSet("servicemanager", flag.ExitOnError)
 	setUsage(flagset)
 	flagset.StringVar(&opts.appendArgs, "appendArgs", "", "A map of args to append for services you are starting. i.e. '{\"SERVICE_NAME\":[\"-DFoo=Bar\",\"SOMETHING\"],\"SERVICE_TWO\":[\"APPEND_THIS\"]}'")
+	flagset.StringVar(&opts.AddService, "add-service", "", "adds a new `service` to your services-overrides.json, asking for anything not given as name=, group=, artifact=, port=, health= or args=")
+	flagset.BoolVar(&opts.Api, "api", false, "serves a REST api on localhost to list, start, stop and check services, with a token (use with --daemon)")
+	flagset.BoolVar(&opts.ApiIndex, "api-index", false, "serves a page on localhost:8997 linking to the openapi spec of every running service (use --port to change the port)")
+	flagset.IntVar(&opts.ApiPort, "api-port", 0, "the `port` --api listens on (default 8999)")
 	flagset.BoolVar(&opts.AutoComplete, "autocomplete", false, "generates bash completions response (used by bash-completions)")
+	flagset.StringVar(&opts.BuildAndRun, "build-and-run", "", "builds a `service` from a local checkout with sbt publishLocal, then starts (or restarts) it running exactly that build (use --repo to say where it's checked out)")
+	flagset.StringVar(&opts.Capture, "capture", "", "records the requests made to the given `services` (comma separated) through a proxy on their port, see them with --traffic (use with --start)")
+	flagset.BoolVar(&opts.CaptureBodies, "capture-bodies", false, "records the start of request and response bodies as well as their headers (use with --capture)")
 	flagset.BoolVar(&opts.CheckPorts, "checkports", false, "finds services using the same port number")
+	flagset.StringVar(&opts.CiOutput, "ci-output", "", "writes the port and version of each service to a json `file` (use with --ci-start)")
+	flagset.BoolVar(&opts.CiStart, "ci-start", false, "starts services and profiles for a ci job without prompting, printing progress as json and waiting for them to be healthy (--wait secs, default 300)")
+	flagset.BoolVar(&opts.CiStop, "ci-stop", false, "stops the services started by --ci-start, for a step that always runs at the end of a ci job")
 	flagset.BoolVar(&opts.Clean, "clean", false, "forces reinstall of service (use with --start)")
 	flagset.StringVar(&opts.CompPreviousWord, "comp-pword", "", "used with --autocomplete by script generated using --generate-autocomplete")
 	flagset.IntVar(&opts.CompWordCount, "comp-cword", 1, "used with --autocomplete by script generated using --generate-autocomplete")
+	flagset.StringVar(&opts.CompWords, "comp-words", "", "used with --autocomplete by script generated using --generate-autocomplete")
 	flagset.StringVar(&opts.Config, "config", "", "sets an alternate directory for service-manager-config")
+	flagset.BoolVar(&opts.ConfigDiff, "config-diff", false, "shows how your overrides and overlays change the services from the shared service-manager-config")
+	flagset.StringVar(&opts.ConfigGet, "config-get", "", "prints the value of an option or workspace `setting` from your defaults in $WORKSPACE/config")
+	flagset.StringVar(&opts.ConfigSet, "config-set", "", "sets a default option or workspace setting in $WORKSPACE/config, e.g. --config-set timeout=30")
+	flagset.StringVar(&opts.Cors, "cors", "", "adds permissive cors headers for the given `origins` (comma separated, or *) to responses from the reverse proxy (use with --reverse-proxy)")
+	flagset.BoolVar(&opts.Daemon, "daemon", false, "runs sm2 in the foreground as an agent that records the health of running services, and keeps any services or profiles given running")
 	flagset.StringVar(&opts.Debug, "debug", "", "infomation on why a given `service` may not have started")
+	flagset.BoolVar(&opts.Dedupe, "dedupe", false, "saves disk space by linking the identical jars in every installed service to one copy")
+	flagset.BoolVar(&opts.DiskUsage, "du", false, "shows the disk space used by each installed service and its logs, and what -prune and -dedupe would reclaim")
 	flagset.BoolVar(&opts.Diagnostic, "diagnostic", false, "a suite of checks to debug issues with service manager")
+	flagset.BoolVar(&opts.Docker, "docker", false, "runs services that have a docker image as containers, rather than downloading them (use with --start)")
+	flagset.BoolVar(&opts.DynamicPorts, "dynamic-ports", false, "starts services on a free port rather than their default port (use with --start)")
+	flagset.StringVar(&opts.ExportCompose, "export-compose", "", "writes a docker-compose `file` (or - for stdout) for the given services and profiles, for the ones that have a docker image")
+	flagset.BoolVar(&opts.ExportIntellij, "export-intellij", false, "writes IntelliJ run configurations to start, stop and attach a debugger to the given services and profiles to .run")
+	flagset.StringVar(&opts.ExportLaunchd, "export-launchd", "", "prints a LaunchAgent that starts a `profile` (or service) at login and keeps it running, using --daemon (macOS)")
+	flagset.StringVar(&opts.ExportPorts, "export-ports", "", "prints the ports to forward for the given services and profiles (or the running ones) as `devcontainer` json, for devcontainer.json or Codespaces")
+	flagset.StringVar(&opts.ExportProxy, "export-proxy", "", "prints `nginx` or caddy config that routes to the running services, like --reverse-proxy (use --port to change the port it listens on)")
+	flagset.StringVar(&opts.ExportSystemd, "export-systemd", "", "prints a systemd user unit that starts a `service` with sm2, to keep it running across reboots (linux)")
+	flagset.BoolVar(&opts.ExportVscode, "export-vscode", false, "writes start/stop/status tasks and debugger attach configs for the given services and profiles to .vscode/tasks.json and launch.json")
 	flagset.BoolVar(&opts.FromSource, "src", false, "run service from source (use with --start)")
+	flagset.StringVar(&opts.Format, "format", "", "formats each service in --status using a go `template`, e.g. '{{.Name}} {{.Port}} {{.Version}}'")
 	flagset.BoolVar(&opts.FormatPlain, "format-plain", false, "list services without formatting")
-	flagset.BoolVar(&opts.GenerateAutoComplete, "generate-autocomplete", false, "generates bash completions script")
+	flagset.BoolVar(&opts.GenerateAutoComplete, "generate-autocomplete", false, "generates a bash completions script, or zsh or fish if it's given after the flag")
+	flagset.BoolVar(&opts.Grpc, "grpc", false, "serves a grpc api on localhost to control services and stream their events and logs, with a token (use with --daemon)")
+	flagset.IntVar(&opts.GrpcPort, "grpc-port", 0, "the `port` --grpc listens on (default 8998)")
+	flagset.BoolVar(&opts.HealthReport, "health-report", false, "shows health check response times and flags services that are slow or intermittently failing (recorded by --daemon)")
+	flagset.BoolVar(&opts.HostsSync, "hosts-sync", false, "points the proxyHosts of every service at 127.0.0.1 in a block of /etc/hosts managed by sm2, using sudo if needed")
+	flagset.BoolVar(&opts.Https, "https", false, "serves the reverse proxy over https, using a certificate from a local CA created in $WORKSPACE/certs (use with --reverse-proxy)")
+	flagset.StringVar(&opts.ImportV1, "import-v1", "", "converts a services.json or profiles.json `file` from the original service-manager to sm2's format, printing it to stdout")
+	flagset.BoolVar(&opts.Init, "init", false, "sets up a new workspace: clones service-manager-config, writes your defaults and checks artifactory can be reached")
+	flagset.BoolVar(&opts.Jdwp, "jdwp", false, "starts services with the jvm debugger listening on their debugPort, or their port + 10000 (use with --start)")
 	flagset.BoolVar(&opts.Latest, "latest", false, "used in conjunction with -restart to check for latest version of service(s) being restarted")
+	flagset.BoolVar(&opts.LintConfig, "lint-config", false, "checks for services with the same name, port or artifact, and profiles that refer to services that don't exist")
+	flagset.BoolVar(&opts.Machine, "machine", false, "for jenkins and scripts: never prompts or shows progress, writes json events to stdout (other output goes to stderr) and exits with documented codes")
 	flagset.BoolVar(&opts.List, "list", false, "lists all available services and profiles")
 	flagset.StringVar(&opts.Logs, "logs", "", "shows the stdout logs for a service")
 	flagset.BoolVar(&opts.NoPortCheck, "no-port-check", false, "prevents port collision detection (use with --status)")
 	flagset.BoolVar(&opts.NoProgress, "noprogress", false, "prevents download progress being shown (use with --start)")
 	flagset.BoolVar(&opts.NoVpnCheck, "no-vpn-check", defaultVpnCheck(), "disables checking if the vpn is connected")
 	flagset.BoolVar(&opts.Offline, "offline", false, "starts a service in offline mode (use with --start or standalone to list available services)")
+	flagset.StringVar(&opts.Open, "open", "", "opens a running `service` in your browser, at its landingPath (e.g. its swagger ui) or its first proxyPath")
+	flagset.StringVar(&opts.Overlay, "overlay", "", "applies the named `overlays` from overlays.json (comma separated) to the services being run, e.g. stub-mode")
+	flagset.StringVar(&opts.PinConfig, "pin-config", "", "pins service-manager-config to a git `ref` (branch, tag or commit), use 'none' to unpin")
 	flagset.IntVar(&opts.Port, "port", -1, "overrides the default port for a service (use with --start)")
 	flagset.BoolVar(&opts.Ports, "ports", false, "shows which ports services use")
-	flagset.BoolVar(&opts.Prune, "prune", false, "cleans up services with a status of FAIL")
+	flagset.StringVar(&opts.ProfileUrl, "profile-url", "", "downloads and uses a profile from a `url` (use with --start, --stop etc)")
+	flagset.BoolVar(&opts.Prune, "prune", false, "cleans up services with a status of FAIL, and removes the installs of services that are no longer in the config")
+	flagset.BoolVar(&opts.Refresh, "refresh", false, "looks up the latest versions of services again, rather than using the ones found in the last few minutes")
 	flagset.StringVar(&opts.Release, "r", "", "sets which `version` to run (use with --start)")
+	flagset.StringVar(&opts.Remote, "remote", "", "runs --start, --stop, --restart, --stop-all, --status and --logs on the sm2 daemon at a `url`, e.g. ssh://me@devbox or http://localhost:8999")
+	flagset.StringVar(&opts.Repo, "repo", "", "the `dir` of the sbt project --build-and-run builds (default the current dir)")
 	flagset.BoolVar(&opts.Restart, "restart", false, "restarts one or more services")
 	flagset.BoolVar(&opts.ReverseProxy, "reverse-proxy", false, "starts a reverse proxy to all services on port :3000")
 	flagset.StringVar(&opts.Search, "search", "", "searches for services and profiles that match a given `regex`")
+	flagset.StringVar(&opts.ServeCapture, "serve-capture", "", "runs the capture proxy described in a capture config `file` until it's stopped (used by --capture)")
+	flagset.StringVar(&opts.ServeStub, "serve-stub", "", "runs the stub server described in a stub config `file` until it's stopped (used by --stub)")
+	flagset.StringVar(&opts.SetSecret, "set-secret", "", "stores a secret (read from stdin) in the encrypted secrets file under the given `key`")
 	flagset.BoolVar(&opts.Start, "start", false, "starts one or more service, for a single service use -r to specify version")
 	flagset.BoolVar(&opts.Status, "status", false, "shows which services are running")
 	flagset.BoolVar(&opts.StatusShort, "s", false, "shows which services are running")
 	flagset.BoolVar(&opts.StopAll, "stop-all", false, "stops all services")
 	flagset.BoolVar(&opts.Stop, "stop", false, "stops one or more services")
-	flagset.BoolVar(&opts.Update, "update", false, "updates sm2 to the latest available version")
+	flagset.StringVar(&opts.Stub, "stub", "", "starts the given `services` (comma separated) as stubs that answer health checks and their canned responses, instead of running them (use with --start)")
+	flagset.BoolVar(&opts.Timings, "timings", false, "prints how long each service spent in each phase of starting, and waits to see when it's healthy (use with --start)")
+	flagset.StringVar(&opts.Traffic, "traffic", "", "prints the requests recorded for a `service` started with --capture, with -v for headers and bodies")
+	flagset.BoolVar(&opts.Ui, "ui", false, "shows the services full screen, to start, stop, restart and tail them with a key (also sm2 ui)")
+	flagset.BoolVar(&opts.Update, "update", false, "updates sm2 to the latest available version, e.g. sm2 --update beta to include pre-releases")
 	flagset.BoolVar(&opts.UpdateConfig, "update-config", false, "pulls the latest version of service-manager-config")
+	flagset.StringVar(&opts.UseWorkspace, "use-workspace", "", "switches to a named workspace, creating it if needed. Use `NAME=PATH` to add an existing workspace, or 'default' for ~/.sm2")
+	flagset.BoolVar(&opts.ValidateConfig, "validate-config", false, "checks services.json and profiles.json for unknown fields, missing fields, bad types and duplicates")
 	flagset.BoolVar(&opts.Verbose, "v", false, "enable verbose output")
 	flagset.BoolVar(&opts.Version, "version", false, "show the version of service-manager")
 	flagset.BoolVar(&opts.Verify, "verify", false, "for scripts, checks if a service/profile is running")
+	flagset.BoolVar(&opts.Watch, "watch", false, "redraws the status every second, only checking the health of services that have changed (use with --status)")
 	flagset.IntVar(&opts.Wait, "wait", 0, "used with --start, waits a specified number of seconds for the services to become available before exiting (use with --start)")
+	flagset.StringVar(&opts.WhyFailed, "why-failed", "", "shows the version, command, exit status and last log lines of a `service` that failed to start")
 	flagset.IntVar(&opts.Workers, "workers", defaultWorkers(), "how many services should be downloaded at the same time (use with --start)")
+	flagset.BoolVar(&opts.Workspaces, "workspaces", false, "lists the named workspaces, marking the one in use")
 	flagset.IntVar(&opts.DelaySeconds, "delay-seconds", 0, "how long to pause, in seconds, after starting a service before starting another")
 
 	return flagset
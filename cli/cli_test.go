@@ -2,6 +2,7 @@ package cli
 
 import (
 	"os"
+	"path"
 	"reflect"
 	"testing"
 )
@@ -25,6 +26,20 @@ func TestSimpleOneService(t *testing.T) {
 	}
 }
 
+func TestUi(t *testing.T) {
+	for _, args := range [][]string{{"ui"}, {"-ui"}, {"ui", "-v"}} {
+		result, err := Parse(args)
+		if err != nil {
+			t.Errorf("parse failed %s", err)
+		} else if !result.Ui || len(result.ExtraServices) != 0 {
+			t.Errorf("expected %v to be the ui, got %v %v", args, result.Ui, result.ExtraServices)
+		}
+	}
+	if result, _ := Parse([]string{"--start", "ui"}); result.Ui {
+		t.Error("expected ui to only be the ui when it's the command")
+	}
+}
+
 func TestComplexOneService(t *testing.T) {
 	args := []string{
 		"-v",
@@ -370,3 +385,192 @@ func TestRemovalOfMinusRFlag(t *testing.T) {
 	}
 
 }
+
+func TestDefaultsAreOverriddenByCommandLine(t *testing.T) {
+	file, err := os.CreateTemp(os.TempDir(), "sm2-defaults*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`{"wait": 60, "noprogress": true, "format": "{{.Name}}", "appendArgs": {"FOO": ["-Dfoo=default"], "BAR": ["-Dbar=default"]}}`)
+	file.Close()
+
+	defaults, err := LoadDefaults(file.Name())
+	if err != nil {
+		t.Fatalf("failed to load defaults %s", err)
+	}
+
+	result, err := ParseWithDefaults([]string{"--start", "FOO", "BAR", "--wait", "10", "--appendArgs", `{"FOO":["-Dfoo=cli"]}`}, defaults)
+	if err != nil {
+		t.Fatalf("parse failed %s", err)
+	}
+
+	if result.Wait != 10 {
+		t.Errorf("Expected wait from the command line to win, got %d", result.Wait)
+	}
+	if !result.NoProgress || result.Format != "{{.Name}}" {
+		t.Errorf("Expected noprogress and format to be set from defaults")
+	}
+	expectedArgs := map[string][]string{"FOO": {"-Dfoo=cli"}, "BAR": {"-Dbar=default"}}
+	if !reflect.DeepEqual(result.ExtraArgs, expectedArgs) {
+		t.Errorf("Expected ExtraArgs to be %v, got %v", expectedArgs, result.ExtraArgs)
+	}
+}
+
+func TestDefaultsRejectUnknownOptions(t *testing.T) {
+	defaults := &Defaults{Flags: map[string]string{"wiat": "60"}}
+	if _, err := ParseWithDefaults([]string{"-s"}, defaults); err == nil {
+		t.Errorf("Expected an error for an unknown option")
+	}
+}
+
+func TestSetAndGetDefaults(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "sm2-defaults*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := dir + "/config"
+
+	valid := map[string]string{
+		"wait":                 "60",
+		"noprogress":           "true",
+		"format":               "{{.Name}}",
+		"timeout":              "30",
+		"artifactoryUrl":       "https://artifactory.example.com/releases",
+		"appendArgs":           `{"FOO":["-Dfoo=bar"]}`,
+		"scalaVersions":        "3,2.13",
+		"scalaSuffixes":        "3,2.*",
+		"portRanges":           "8000-9999,12345",
+		"portCollision":        "shift",
+		"bindAddress":          "0.0.0.0",
+		"artifactoryProxy":     "direct",
+		"artifactoryPingUrl":   "s3://acme-artifacts/maven",
+		"awsProfile":           "acme-dev",
+		"awsRegion":            "eu-west-2",
+		"metadataCacheTtl":     "60",
+		"http2":                "false",
+		"httpConnsPerHost":     "16",
+		"httpIdleConns":        "64",
+		"httpIdleTimeout":      "30",
+		"metadataQuiet":        "22-7",
+		"metadataRefresh":      "off",
+		"dedupeInstalls":       "true",
+		"workspaceLockTimeout": "120",
+	}
+	for key, value := range valid {
+		if err := SetDefault(file, key, value); err != nil {
+			t.Errorf("failed to set %s: %s", key, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"wait":                 "sixty",
+		"timeout":              "-1",
+		"artifactoryUrl":       "not a url",
+		"appendArgs":           "FOO",
+		"scalaVersions":        "3,_2.13",
+		"scalaSuffixes":        "3,2.x",
+		"portRanges":           "9999-8000",
+		"portCollision":        "ignore",
+		"bindAddress":          "lan",
+		"artifactoryProxy":     "proxy.example.com",
+		"artifactoryPingUrl":   "ftp://acme-artifacts/maven",
+		"awsProfile":           "acme dev",
+		"awsRegion":            "london",
+		"metadataCacheTtl":     "five minutes",
+		"http2":                "maybe",
+		"httpConnsPerHost":     "0",
+		"httpIdleConns":        "lots",
+		"httpIdleTimeout":      "-30",
+		"metadataQuiet":        "night",
+		"metadataRefresh":      "never",
+		"dedupeInstalls":       "yes please",
+		"workspaceLockTimeout": "forever",
+		"nonsense":             "1",
+	}
+	for key, value := range invalid {
+		if err := SetDefault(file, key, value); err == nil {
+			t.Errorf("expected %s=%s to be rejected", key, value)
+		}
+	}
+
+	if value, _ := GetDefault(file, "wait"); value != "60" {
+		t.Errorf("expected wait to be stored as a number, got %s", value)
+	}
+	if value, _ := GetDefault(file, "noprogress"); value != "true" {
+		t.Errorf("expected noprogress to be stored as a bool, got %s", value)
+	}
+
+	defaults, err := LoadDefaults(file)
+	if err != nil {
+		t.Fatalf("failed to load defaults: %s", err)
+	}
+	if defaults.Settings["timeout"] != "30" || defaults.Settings["artifactoryUrl"] != "https://artifactory.example.com/releases" {
+		t.Errorf("expected settings to be loaded separately from options, got %v", defaults.Settings)
+	}
+	if _, ok := defaults.Flags["timeout"]; ok {
+		t.Errorf("expected timeout not to be treated as an option")
+	}
+
+	SetDefault(file, "wait", "")
+	if _, err := GetDefault(file, "wait"); err == nil {
+		t.Errorf("expected wait to be removed")
+	}
+}
+
+func TestUseWorkspace(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "sm2-workspaces*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := dir + "/workspaces.json"
+
+	acme, err := UseWorkspace(file, "acme", "")
+	if err != nil || acme != dir+"/workspaces/acme" {
+		t.Errorf("expected a new workspace to be created in %s/workspaces, got %s %v", dir, acme, err)
+	}
+
+	if _, err := UseWorkspace(file, "legacy", dir+"/legacy"); err != nil {
+		t.Errorf("failed to add a workspace with a path: %s", err)
+	}
+	if _, err := UseWorkspace(file, "relative", "legacy"); err == nil {
+		t.Errorf("expected a relative path to be rejected")
+	}
+	if _, err := UseWorkspace(file, "not/valid", ""); err == nil {
+		t.Errorf("expected an invalid name to be rejected")
+	}
+
+	// switching back to an existing workspace keeps its path
+	if again, _ := UseWorkspace(file, "acme", ""); again != acme {
+		t.Errorf("expected to switch back to %s, got %s", acme, again)
+	}
+
+	workspaces, err := LoadWorkspaces(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspaces.Current != "acme" {
+		t.Errorf("expected acme to be the current workspace, got %s", workspaces.Current)
+	}
+	if names := workspaces.Names(); !reflect.DeepEqual(names, []string{"default", "acme", "legacy"}) {
+		t.Errorf("unexpected workspace names %v", names)
+	}
+
+	if home, _ := UseWorkspace(file, DefaultWorkspaceName, ""); home != dir {
+		t.Errorf("expected the default workspace to be %s, got %s", dir, home)
+	}
+}
+
+func TestLoadDefaultsOnlyIgnoresAMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	if defaults, err := LoadDefaults(path.Join(dir, "config")); defaults != nil || err != nil {
+		t.Errorf("expected no defaults without a file, got %v %v", defaults, err)
+	}
+	// e.g. it's a dir, or can't be read
+	if _, err := LoadDefaults(dir); err == nil {
+		t.Errorf("expected a defaults file that can't be read to be an error")
+	}
+}
@@ -0,0 +1,434 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Per-user default options, loaded from $WORKSPACE/config (i.e. ~/.sm2/config), e.g.
+//
+//	{
+//	  "wait": 60,
+//	  "noprogress": true,
+//	  "format": "{{.Name}} {{.Port}}",
+//	  "appendArgs": {"SERVICE_NAME": ["-Dlogger.root=DEBUG"]}
+//	}
+//
+// Keys are the names of command line flags, or one of the workspace settings below. Defaults are
+// applied before the command line, so anything passed on the command line wins.
+type Defaults struct {
+	Flags      map[string]string
+	AppendArgs map[string][]string
+	Settings   map[string]string
+}
+
+// Workspace settings that aren't command line options, and how to check their values
+var settings = map[string]func(string) error{
+	"artifactoryUrl":       validateRepoUrl,       // overrides the repo url from config.json, can be codeartifact or s3://bucket/prefix
+	"artifactoryPingUrl":   validateRepoUrl,       // overrides the ping url from config.json
+	"artifactoryProxy":     validateProxy,         // the proxy for artifactory: auto (the default), direct or a proxy url
+	"awsProfile":           validateAwsProfile,    // the aws profile used for codeartifact and s3, rather than the default
+	"awsRegion":            validateAwsRegion,     // the region of s3:// buckets, rather than $AWS_REGION
+	"bindAddress":          validateIp,            // the address services listen on, e.g. 0.0.0.0 to expose them on the lan
+	"dedupeInstalls":       validateBool,          // true to link each service's jars to identical ones as it's installed, like -dedupe
+	"http2":                validateBool,          // false to download over a connection each rather than sharing one with http/2
+	"httpConnsPerHost":     validateCount,         // the most connections open to artifactory at once, unlimited by default
+	"httpIdleConns":        validateCount,         // how many connections to artifactory are kept open between downloads, 32 by default
+	"httpIdleTimeout":      validateSeconds,       // how long unused connections are kept open for, 90 secs by default
+	"metadataCacheTtl":     validateSeconds,       // how long the latest versions found in artifactory are reused for, 300 secs by default
+	"metadataQuiet":        validateQuietHours,    // hours the daemon doesn't look up the latest versions in, e.g. 22-7
+	"metadataRefresh":      validateSecondsOrOff,  // how often the daemon looks up the latest versions of installed services, 240 secs by default, or off
+	"portCollision":        validatePortCollision, // what to do when a service's port is taken: fail, shift or prompt
+	"portRanges":           validatePortRanges,    // the ports services are allowed to use, e.g. 8000-9999,12000-12999
+	"scalaSuffixes":        validateScalaSuffixes, // the scala versions artifacts can end in, e.g. 3,2.*, _3 and _2.xx by default
+	"scalaVersions":        validateScalaVersions, // the order to try scala versions in for _%% artifacts, e.g. 3,2.13
+	"serviceRegistry":      validateRegistry,      // registers started services with consul or etcd, e.g. consul://127.0.0.1:8500
+	"timeout":              validateSeconds,       // timeout for short requests (vpn check, metadata etc), SM_TIMEOUT takes precedence
+	"updateChannel":        validateUpdateChannel, // the releases -update installs: stable (the default) or beta
+	"updateUrl":            validateUrl,           // a mirror of the sm2 releases for -update to use instead of github
+	"workspaceLockTimeout": validateSeconds,       // how long to wait for another sm2 using the same workspace, 60 secs by default
+}
+
+func validateUrl(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s is not a valid http(s) url", value)
+	}
+	return nil
+}
+
+// artifactory, or an s3 bucket in the maven layout
+func validateRepoUrl(value string) error {
+	if u, err := url.Parse(value); err == nil && u.Scheme == "s3" && u.Host != "" {
+		return nil
+	}
+	if validateUrl(value) != nil {
+		return fmt.Errorf("%s is not a valid http(s) or s3:// url", value)
+	}
+	return nil
+}
+
+var awsProfile = regexp.MustCompile(`^[A-Za-z0-9_.@+-]+$`)
+
+func validateAwsProfile(value string) error {
+	if !awsProfile.MatchString(value) {
+		return fmt.Errorf("%s is not a valid aws profile name", value)
+	}
+	return nil
+}
+
+var awsRegion = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+
+func validateAwsRegion(value string) error {
+	if !awsRegion.MatchString(value) {
+		return fmt.Errorf("%s is not an aws region, e.g. eu-west-2", value)
+	}
+	return nil
+}
+
+func validateProxy(value string) error {
+	if value == "auto" || value == "direct" {
+		return nil
+	}
+	if validateUrl(value) != nil {
+		return fmt.Errorf("%s should be auto, direct or a proxy url, e.g. http://proxy.example.com:8080", value)
+	}
+	return nil
+}
+
+func validateIp(value string) error {
+	if net.ParseIP(value) == nil {
+		return fmt.Errorf("%s is not an ip address", value)
+	}
+	return nil
+}
+
+var scalaVersion = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+func validateScalaVersions(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if !scalaVersion.MatchString(v) {
+			return fmt.Errorf("%s should be a comma separated list of scala versions, e.g. 3,2.13", value)
+		}
+	}
+	return nil
+}
+
+var scalaSuffix = regexp.MustCompile(`^\d+(\.(\d+|\*))?$`)
+
+func validateScalaSuffixes(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if !scalaSuffix.MatchString(s) {
+			return fmt.Errorf("%s should be a comma separated list of scala versions, e.g. 3,2.*", value)
+		}
+	}
+	return nil
+}
+
+var PortCollisionPolicies = []string{"fail", "shift", "prompt"}
+
+func validatePortCollision(value string) error {
+	for _, p := range PortCollisionPolicies {
+		if value == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s should be one of %s", value, strings.Join(PortCollisionPolicies, ", "))
+}
+
+func validateRegistry(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "consul" && u.Scheme != "etcd") || u.Host == "" {
+		return fmt.Errorf("%s should be the address of consul or etcd, e.g. consul://127.0.0.1:8500 or etcd://127.0.0.1:2379", value)
+	}
+	return nil
+}
+
+var UpdateChannels = []string{"stable", "beta"}
+
+func validateUpdateChannel(value string) error {
+	for _, c := range UpdateChannels {
+		if value == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s should be one of %s", value, strings.Join(UpdateChannels, ", "))
+}
+
+// An inclusive range of ports, e.g. 8000-9999
+type PortRange struct {
+	From int
+	To   int
+}
+
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// parses a comma separated list of port ranges, a single port is a range of one
+func ParsePortRanges(value string) ([]PortRange, error) {
+	ranges := []PortRange{}
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+		from, err1 := strconv.Atoi(bounds[0])
+		to, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("%s should be a comma separated list of port ranges, e.g. 8000-9999,12000-12999", value)
+		}
+		ranges = append(ranges, PortRange{from, to})
+	}
+	return ranges, nil
+}
+
+func validatePortRanges(value string) error {
+	_, err := ParsePortRanges(value)
+	return err
+}
+
+// A range of hours in the day, which can run past midnight, e.g. 22-7
+type QuietHours struct {
+	From int
+	To   int
+}
+
+func (q QuietHours) Contains(hour int) bool {
+	if q.From <= q.To {
+		return hour >= q.From && hour < q.To
+	}
+	return hour >= q.From || hour < q.To
+}
+
+func ParseQuietHours(value string) (QuietHours, error) {
+	from, to, found := strings.Cut(strings.TrimSpace(value), "-")
+	f, err1 := strconv.Atoi(from)
+	t, err2 := strconv.Atoi(to)
+	if !found || err1 != nil || err2 != nil || f < 0 || f > 23 || t < 0 || t > 24 {
+		return QuietHours{}, fmt.Errorf("%s should be a range of hours, e.g. 22-7", value)
+	}
+	return QuietHours{f, t}, nil
+}
+
+func validateQuietHours(value string) error {
+	_, err := ParseQuietHours(value)
+	return err
+}
+
+func validateSeconds(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return fmt.Errorf("%s should be a whole number of seconds", value)
+	}
+	return nil
+}
+
+func validateSecondsOrOff(value string) error {
+	if value == "off" {
+		return nil
+	}
+	return validateSeconds(value)
+}
+
+func validateCount(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return fmt.Errorf("%s should be a whole number more than 0", value)
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("%s should be true or false", value)
+	}
+	return nil
+}
+
+// the defaults live in the workspace, so they follow $WORKSPACE or the current named workspace
+func DefaultsFile() string {
+	workspace, _ := Workspace()
+	if workspace == "" {
+		return ""
+	}
+	return path.Join(workspace, "config")
+}
+
+// returns nil if there's no defaults file. One that's there but can't be read is an error, rather than
+// quietly dropping the user's defaults
+func LoadDefaults(file string) (*Defaults, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	defaults := Defaults{
+		Flags:      map[string]string{},
+		AppendArgs: map[string][]string{},
+		Settings:   map[string]string{},
+	}
+
+	for name, value := range raw {
+		if name == "appendArgs" {
+			if err := json.Unmarshal(value, &defaults.AppendArgs); err != nil {
+				return nil, fmt.Errorf("appendArgs should be a map of service names to lists of args: %s", err)
+			}
+			continue
+		}
+
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		decoder.Decode(&v)
+
+		target := defaults.Flags
+		if _, isSetting := settings[name]; isSetting {
+			target = defaults.Settings
+		}
+
+		switch v := v.(type) {
+		case string:
+			target[name] = v
+		case bool:
+			target[name] = fmt.Sprint(v)
+		case json.Number:
+			target[name] = v.String()
+		default:
+			return nil, fmt.Errorf("%s should be a string, number or true/false", name)
+		}
+	}
+
+	for name, value := range defaults.Settings {
+		if err := settings[name](value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return &defaults, nil
+}
+
+// sets the flags in the order they're named so any errors are consistent
+func (d *Defaults) apply(flagset *flag.FlagSet) error {
+	names := []string{}
+	for name := range d.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flagset.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %s", name)
+		}
+		if err := flagset.Set(name, d.Flags[name]); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns a value from the defaults file, as its written in the file
+func GetDefault(file string, key string) (string, error) {
+	values, err := readDefaultsFile(file)
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("%s is not set in %s", key, file)
+	}
+	return string(value), nil
+}
+
+// Checks and writes a single value to the defaults file, an empty value removes it
+func SetDefault(file string, key string, value string) error {
+	values, err := readDefaultsFile(file)
+	if err != nil {
+		return err
+	}
+
+	if value == "" {
+		delete(values, key)
+	} else {
+		encoded, err := encodeDefault(key, value)
+		if err != nil {
+			return err
+		}
+		values[key] = encoded
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// validates a value and converts it to json of the right type
+func encodeDefault(key string, value string) (json.RawMessage, error) {
+	if key == "appendArgs" {
+		if _, err := parseAppendArgs(value); err != nil {
+			return nil, fmt.Errorf("appendArgs should be a json map of service names to lists of args: %s", err)
+		}
+		return json.RawMessage(value), nil
+	}
+
+	if validate, isSetting := settings[key]; isSetting {
+		if err := validate(value); err != nil {
+			return nil, err
+		}
+		if n, err := strconv.Atoi(value); err == nil {
+			return json.Marshal(n)
+		}
+		return json.Marshal(value)
+	}
+
+	// check its a real option by setting it, which also gives us the value with the right type
+	flagset := BuildFlagSet(new(UserOption))
+	f := flagset.Lookup(key)
+	if f == nil {
+		return nil, fmt.Errorf("%s is not an option or setting, see --help for the list of options", key)
+	}
+	if err := flagset.Set(key, value); err != nil {
+		return nil, fmt.Errorf("invalid value for %s: %s", key, err)
+	}
+	return json.Marshal(f.Value.(flag.Getter).Get())
+}
+
+func readDefaultsFile(file string) (map[string]json.RawMessage, error) {
+	values := map[string]json.RawMessage{}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return values, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s is not valid json: %s", file, err)
+	}
+	return values, nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"sm2/platform"
+)
+
+// Named workspaces let one user switch between several sets of config, installs and state, e.g. for
+// different organisations. Each has its own workspace dir, which by default is ~/.sm2/workspaces/NAME.
+// Which one is in use is kept in ~/.sm2/workspaces.json:
+//
+//	{
+//	  "current": "acme",
+//	  "paths": {"acme": "/home/me/.sm2/workspaces/acme", "legacy": "/home/me/.servicemanager"}
+//	}
+//
+// $WORKSPACE always takes precedence, and "default" is the normal ~/.sm2 workspace.
+type Workspaces struct {
+	Current string            `json:"current"`
+	Paths   map[string]string `json:"paths"`
+}
+
+const DefaultWorkspaceName = "default"
+
+var workspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// the folder that holds the default workspace and the list of named ones
+func smHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return path.Join(home, ".sm2")
+}
+
+func WorkspacesFile() string {
+	return path.Join(smHome(), "workspaces.json")
+}
+
+// Returns the workspace dir to use and whether it was chosen (by $WORKSPACE or a named workspace) rather
+// than being the default.
+func Workspace() (string, bool) {
+	if workspace, isSet := os.LookupEnv("WORKSPACE"); isSet {
+		// under wsl, a windows path like C:\Users\me\sm2 is the same as /mnt/c/Users/me/sm2
+		return platform.DetectWsl().LinuxPath(workspace), true
+	}
+	if workspaces, err := LoadWorkspaces(WorkspacesFile()); err == nil && workspaces.Current != DefaultWorkspaceName {
+		if dir, ok := workspaces.Paths[workspaces.Current]; ok {
+			return dir, true
+		}
+	}
+	return smHome(), false
+}
+
+// returns an empty list if there's no workspaces file
+func LoadWorkspaces(file string) (*Workspaces, error) {
+	workspaces := Workspaces{Current: DefaultWorkspaceName, Paths: map[string]string{}}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &workspaces, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &workspaces); err != nil {
+		return nil, fmt.Errorf("%s is not valid json: %s", file, err)
+	}
+	if workspaces.Paths == nil {
+		workspaces.Paths = map[string]string{}
+	}
+	return &workspaces, nil
+}
+
+// Makes a named workspace the current one, adding it if its new. dir is only needed to add a workspace
+// that isn't in the usual place. Returns the workspace's dir.
+func UseWorkspace(file string, name string, dir string) (string, error) {
+	if !workspaceNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%s is not a valid workspace name, only letters, numbers, '.', '_' and '-' can be used", name)
+	}
+
+	workspaces, err := LoadWorkspaces(file)
+	if err != nil {
+		return "", err
+	}
+
+	if name == DefaultWorkspaceName {
+		if dir != "" {
+			return "", fmt.Errorf("the default workspace is always %s", path.Dir(file))
+		}
+		dir = path.Dir(file)
+	} else if dir != "" {
+		if !path.IsAbs(dir) {
+			return "", fmt.Errorf("%s must be an absolute path", dir)
+		}
+		workspaces.Paths[name] = dir
+	} else if existing, ok := workspaces.Paths[name]; ok {
+		dir = existing
+	} else {
+		dir = path.Join(path.Dir(file), "workspaces", name)
+		workspaces.Paths[name] = dir
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	workspaces.Current = name
+	data, err := json.MarshalIndent(workspaces, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// the names of the named workspaces, including the default one, in alphabetical order
+func (w *Workspaces) Names() []string {
+	names := []string{DefaultWorkspaceName}
+	for name := range w.Paths {
+		if name != DefaultWorkspaceName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
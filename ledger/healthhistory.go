@@ -0,0 +1,54 @@
+package ledger
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+const healthHistoryFileName = ".health_history"
+
+type HealthSample struct {
+	Time    time.Time
+	Latency time.Duration
+	Healthy bool
+}
+
+// health check results, keyed by service id
+type HealthHistory map[string][]HealthSample
+
+func saveHealthHistory(installDir string, history HealthHistory) error {
+	file, err := os.Create(path.Join(installDir, healthHistoryFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	utc := HealthHistory{}
+	for service, samples := range history {
+		for _, sample := range samples {
+			sample.Time = sample.Time.UTC()
+			utc[service] = append(utc[service], sample)
+		}
+	}
+	encoder := json.NewEncoder(file)
+	return encoder.Encode(utc)
+}
+
+// returns an empty history if one hasn't been recorded yet
+func loadHealthHistory(installDir string) (HealthHistory, error) {
+	history := HealthHistory{}
+
+	file, err := os.Open(path.Join(installDir, healthHistoryFileName))
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return history, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&history)
+	return history, err
+}
@@ -14,6 +14,7 @@ type InstallFile struct {
 	Artifact string
 	Version  string
 	Path     string
+	Url      string
 	Md5Sum   string
 	Created  time.Time
 }
@@ -24,6 +25,7 @@ func saveInstallFile(installDir string, install InstallFile) error {
 		return err
 	}
 	defer file.Close()
+	install.Created = install.Created.UTC()
 	encoder := json.NewEncoder(file)
 	return encoder.Encode(install)
 }
@@ -0,0 +1,57 @@
+package ledger
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+)
+
+const installIndexFileName = ".install_index"
+
+// where each installed version is, so a service can be started without checking artifactory
+type InstallIndexEntry struct {
+	Group    string
+	Artifact string
+	Version  string
+	Dir      string // the service's install dir, which holds its .install file
+}
+
+// installed versions, keyed by group:artifact:version
+type InstallIndex map[string]InstallIndexEntry
+
+func InstallIndexKey(group string, artifact string, version string) string {
+	return group + ":" + artifact + ":" + version
+}
+
+// written to a temp file first, as services are installed by more than one sm2 at a time
+func saveInstallIndex(baseDir string, index InstallIndex) error {
+	file, err := os.CreateTemp(baseDir, installIndexFileName+".*")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(file).Encode(index)
+	file.Close()
+	if err == nil {
+		err = os.Rename(file.Name(), path.Join(baseDir, installIndexFileName))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// returns an empty index if nothing's been installed yet
+func loadInstallIndex(baseDir string) (InstallIndex, error) {
+	index := InstallIndex{}
+
+	file, err := os.Open(path.Join(baseDir, installIndexFileName))
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return index, err
+	}
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&index)
+	return index, err
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+)
+
+// The install dirs with a state file are listed in the base dir's .inventory, which is kept up to date as state
+// files are saved and cleared, so finding the running services reads those few state files rather than
+// looking in every install dir. Without an inventory, e.g. the first time, every dir is looked in and one is
+// written. It's locked while it's changed, as more than one sm2 can be starting services at once.
+
+const (
+	inventoryFileName     = ".inventory"
+	inventoryLockFileName = ".inventory.lock"
+)
+
+// the names of the install dirs with state files
+type inventory []string
+
+// adds or removes an install dir, once its state file has been saved or cleared. If it can't be updated the
+// inventory is removed, so it's written from scratch rather than missing a service
+func updateInventory(installDir string, hasState bool) {
+	baseDir, name := path.Split(path.Clean(installDir))
+	err := withInventoryLock(baseDir, func() error {
+		dirs, err := loadInventory(baseDir)
+		if os.IsNotExist(err) {
+			// it's written from scratch the next time it's needed
+			return nil
+		} else if err != nil {
+			return err
+		}
+		updated := inventory{}
+		for _, dir := range dirs {
+			if dir != name {
+				updated = append(updated, dir)
+			}
+		}
+		if hasState {
+			updated = append(updated, name)
+		}
+		return saveInventory(baseDir, updated)
+	})
+	if err != nil {
+		os.Remove(path.Join(baseDir, inventoryFileName))
+	}
+}
+
+// the state files of the dirs in the inventory, or of every dir if there isn't one yet
+func findAllInInventory(baseDir string) ([]StateFile, error) {
+	dirs, err := loadInventory(baseDir)
+	if err != nil {
+		err = withInventoryLock(baseDir, func() error {
+			if dirs, err = scanForStateFiles(baseDir); err != nil {
+				return err
+			}
+			return saveInventory(baseDir, dirs)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := []StateFile{}
+	for _, dir := range dirs {
+		// a dir can be deleted along with its state file, e.g. when a new version's installed
+		if state, err := loadStateFile(path.Join(baseDir, dir)); err == nil {
+			matches = append(matches, state)
+		}
+	}
+	return matches, nil
+}
+
+func scanForStateFiles(baseDir string) (inventory, error) {
+	files, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := inventory{}
+	for _, file := range files {
+		if file.IsDir() {
+			if _, err := os.Stat(path.Join(baseDir, file.Name(), stateFileName)); err == nil {
+				dirs = append(dirs, file.Name())
+			}
+		}
+	}
+	return dirs, nil
+}
+
+func loadInventory(baseDir string) (inventory, error) {
+	data, err := os.ReadFile(path.Join(baseDir, inventoryFileName))
+	if err != nil {
+		return nil, err
+	}
+	dirs := inventory{}
+	return dirs, json.Unmarshal(data, &dirs)
+}
+
+func saveInventory(baseDir string, dirs inventory) error {
+	sort.Strings(dirs)
+	return saveJson(baseDir, inventoryFileName, dirs)
+}
+
+func withInventoryLock(baseDir string, f func() error) error {
+	lock, err := os.OpenFile(path.Join(baseDir, inventoryLockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return f()
+}
@@ -10,6 +10,10 @@ type Ledger struct {
 	ClearProxyState   func(string) error
 	SaveInstallFile   func(string, InstallFile) error
 	LoadInstallFile   func(string) (InstallFile, error)
+	SaveInstallIndex  func(string, InstallIndex) error
+	LoadInstallIndex  func(string) (InstallIndex, error)
+	SaveHealthHistory func(string, HealthHistory) error
+	LoadHealthHistory func(string) (HealthHistory, error)
 }
 
 func NewLedger() Ledger {
@@ -25,5 +29,11 @@ func NewLedger() Ledger {
 
 		SaveInstallFile: saveInstallFile,
 		LoadInstallFile: loadInstallFile,
+
+		SaveInstallIndex: saveInstallIndex,
+		LoadInstallIndex: loadInstallIndex,
+
+		SaveHealthHistory: saveHealthHistory,
+		LoadHealthHistory: loadHealthHistory,
 	}
 }
@@ -1,8 +1,10 @@
 package ledger
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"os"
 	"path"
 	"time"
@@ -11,6 +13,20 @@ import (
 const stateFileName = ".state"
 const proxyStateFileName = ".proxy_state"
 
+// A state file is the only record of a running service, so it's written with a checksum that's checked when it's
+// read, and the one it replaces is kept as .state.bak. A state file that's been truncated or corrupted (e.g. by
+// a full disk) is recovered from its backup, rather than the service being forgotten while it's still running.
+// State files from before the checksum are just the state, and are still read.
+
+const stateBackupFileName = ".state.bak"
+const stateFileVersion = 1
+
+type stateEnvelope struct {
+	Version  int
+	Checksum string          // sha256 of State
+	State    json.RawMessage `json:",omitempty"`
+}
+
 type StateFile struct {
 	Service        string
 	Artifact       string
@@ -19,8 +35,14 @@ type StateFile struct {
 	Started        time.Time
 	Pid            int
 	Port           int
+	BindAddress    string
 	Args           []string
 	HealthcheckUrl string
+	Cmd            string
+	Env            []string
+	Runtime        string // docker for containers, empty for processes
+	DebugPort      int    // the port the jvm debugger listens on, when started with -jdwp
+	CapturePid     int    // the proxy recording requests to the service, when started with -capture
 }
 
 type ProxyState struct {
@@ -30,61 +52,119 @@ type ProxyState struct {
 }
 
 func saveStateFile(installDir string, ledger StateFile) error {
-	file, err := os.Create(path.Join(installDir, stateFileName))
-	if err != nil {
+	// the current one's kept, as long as it's intact
+	if data, err := os.ReadFile(path.Join(installDir, stateFileName)); err == nil {
+		if _, err := parseStateFile(data); err == nil {
+			writeAtomically(installDir, stateBackupFileName, data)
+		}
+	}
+	if err := writeStateFile(installDir, ledger); err != nil {
 		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(ledger)
+	updateInventory(installDir, true)
+	return nil
 }
 
-func loadStateFile(installDir string) (StateFile, error) {
-	state := StateFile{}
-
-	file, err := os.OpenFile(path.Join(installDir, stateFileName), os.O_RDONLY, 0755)
+func writeStateFile(installDir string, state StateFile) error {
+	// times are saved in utc so they mean the same whatever timezone the machine's in when they're read,
+	// and are only shown in the local one
+	state.Started = state.Started.UTC()
+	data, err := json.Marshal(state)
 	if err != nil {
-		return state, err
+		return err
 	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&state)
-	return state, err
+	return saveJson(installDir, stateFileName, stateEnvelope{Version: stateFileVersion, Checksum: checksum(data), State: data})
 }
 
-func clearStateFile(installDir string) error {
-	return clearFile(installDir, stateFileName)
+// written to a temp file first, so another sm2 never reads it half written
+func saveJson(dir string, fileName string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeAtomically(dir, fileName, append(data, '\n'))
 }
 
-func findAll(baseDir string) ([]StateFile, error) {
-	files, err := ioutil.ReadDir(baseDir)
+func writeAtomically(dir string, fileName string, data []byte) error {
+	file, err := os.CreateTemp(dir, fileName+".*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	file.Close()
+	if err == nil {
+		err = os.Rename(file.Name(), path.Join(dir, fileName))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
 
+func loadStateFile(installDir string) (StateFile, error) {
+	data, err := os.ReadFile(path.Join(installDir, stateFileName))
 	if err != nil {
-		return nil, err
+		return StateFile{}, err
+	}
+	state, err := parseStateFile(data)
+	if err == nil {
+		return state, nil
 	}
 
-	matches := []StateFile{}
-	for _, file := range files {
-		if file.IsDir() {
-			if state, err := loadStateFile(path.Join(baseDir, file.Name())); err == nil {
-				matches = append(matches, state)
-			}
-		}
+	backup, backupErr := os.ReadFile(path.Join(installDir, stateBackupFileName))
+	if backupErr != nil {
+		return StateFile{}, fmt.Errorf("%s is corrupt (%s) and there's no backup of it", path.Join(installDir, stateFileName), err)
+	}
+	state, backupErr = parseStateFile(backup)
+	if backupErr != nil {
+		return StateFile{}, fmt.Errorf("%s and its backup are corrupt: %s", path.Join(installDir, stateFileName), err)
 	}
-	return matches, nil
+	// put back, so it's only recovered once
+	writeStateFile(installDir, state)
+	return state, nil
 }
 
-func saveProxyState(installDir string, ledger ProxyState) error {
-	file, err := os.Create(path.Join(installDir, proxyStateFileName))
-	if err != nil {
+func parseStateFile(data []byte) (StateFile, error) {
+	state := StateFile{}
+	envelope := stateEnvelope{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return state, err
+	}
+	// from before there was a checksum
+	if envelope.State == nil {
+		return state, json.Unmarshal(data, &state)
+	}
+	if envelope.Version > stateFileVersion {
+		return state, fmt.Errorf("it was written by a newer version of sm2")
+	}
+	if checksum(envelope.State) != envelope.Checksum {
+		return state, fmt.Errorf("its checksum doesn't match")
+	}
+	return state, json.Unmarshal(envelope.State, &state)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func clearStateFile(installDir string) error {
+	if err := clearFile(installDir, stateFileName); err != nil {
 		return err
 	}
-	defer file.Close()
+	// nor is there anything to recover
+	clearFile(installDir, stateBackupFileName)
+	updateInventory(installDir, false)
+	return nil
+}
+
+func findAll(baseDir string) ([]StateFile, error) {
+	return findAllInInventory(baseDir)
+}
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(ledger)
+func saveProxyState(installDir string, ledger ProxyState) error {
+	ledger.Started = ledger.Started.UTC()
+	return saveJson(installDir, proxyStateFileName, ledger)
 }
 
 func loadProxyState(installDir string) ProxyState {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
@@ -18,14 +19,20 @@ type Platform struct {
 	PidLookupByService func(string) (bool, []int)
 	PortPidLookup      func() map[int]int
 	GetTerminalSize    func() (int, int)
+	SecretLookup       func(string) (string, error)
+	SystemProxy        func() ProxySettings
+	OpenBrowser        func(string) error
+	Wsl                func() Wsl
+	ProcessInfo        func(int) (ProcessInfo, error)
+	ParentPidLookup    func() map[int]int
 }
 
 func DetectPlatform() Platform {
 	switch runtime.GOOS {
 	case "darwin":
-		return Platform{uptimeDarwin, processLookupUnix, processLookupByServiceName, portPidLookup, GetTerminalSize}
+		return Platform{uptimeDarwin, processLookupUnix, processLookupByServiceName, portPidLookup, GetTerminalSize, secretLookupDarwin, systemProxyDarwin, openBrowserDarwin, noWsl, processInfoUnix, parentPidLookupUnix}
 	case "linux":
-		return Platform{uptimeLinux, processLookupUnix, processLookupByServiceName, portPidLookup, GetTerminalSize}
+		return Platform{uptimeLinux, processLookupUnix, processLookupByServiceName, portPidLookup, GetTerminalSize, secretLookupLinux, systemProxyLinux, openBrowserLinux, DetectWsl, processInfoUnix, parentPidLookupUnix}
 	case "windows":
 		log.Fatal("windows is not supported yet!")
 	default:
@@ -41,7 +48,20 @@ type ServiceProcess struct {
 	Port    int
 }
 
+// when a process was started, and what with
+type ProcessInfo struct {
+	Started time.Time
+	Args    string
+}
+
+// the kernel's boot time is in seconds since the epoch, so unlike uptime -s it doesn't depend on the timezone
 func uptimeLinux() time.Time {
+	if stat, err := os.ReadFile("/proc/stat"); err == nil {
+		if boot, ok := ParseBootTime(string(stat)); ok {
+			return boot
+		}
+	}
+
 	cmd := exec.Command("uptime", "-s")
 	output, err := cmd.Output()
 	if err != nil {
@@ -57,6 +77,20 @@ func uptimeLinux() time.Time {
 	return uptime
 }
 
+// ParseBootTime reads the btime line of /proc/stat
+func ParseBootTime(stat string) (time.Time, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(stat))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			if secs, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return time.Unix(secs, 0), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
 // OSX doesnt support the -s flag on uptime!
 // so we use sysctl and get the epoc seconds
 func uptimeDarwin() time.Time {
@@ -130,6 +164,53 @@ func processLookupByServiceName(service string) (bool, []int) {
 	return len(pids) > 0, pids
 }
 
+// Returns a map of every process id running on the system to its parent's
+func parentPidLookupUnix() map[int]int {
+	parents := map[int]int{}
+	output, err := exec.Command("ps", "-eo", "pid=,ppid=").Output()
+	if err != nil {
+		return parents
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil {
+			parents[pid] = ppid
+		}
+	}
+	return parents
+}
+
+// Looks up when a process was started and its command line. It's an error if it isn't running
+func processInfoUnix(pid int) (ProcessInfo, error) {
+	cmd := exec.Command("ps", "-o", "lstart=", "-o", "args=", "-p", strconv.Itoa(pid))
+	// lstart is in the locale's format otherwise
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return ProcessInfo{}, fmt.Errorf("pid %d isn't running", pid)
+	}
+	return ParseProcessInfo(string(output), time.Local)
+}
+
+// ParseProcessInfo reads the output of ps -o lstart= -o args=, e.g. `Fri Oct 16 09:30:01 2026 java -jar ...`
+func ParseProcessInfo(output string, loc *time.Location) (ProcessInfo, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 5 {
+		return ProcessInfo{}, fmt.Errorf("unexpected output from ps: %s", output)
+	}
+	started, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(fields[:5], " "), loc)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	return ProcessInfo{Started: started, Args: strings.Join(fields[5:], " ")}, nil
+}
+
 // Returns a map of all the open TCP listening ports and their Pid
 func portPidLookup() map[int]int {
 
@@ -159,3 +240,37 @@ func portPidLookup() map[int]int {
 
 	return portPid
 }
+
+// Secrets are stored in the keychain under the service name sm2, e.g.
+//
+//	security add-generic-password -s sm2 -a MY_API_KEY -w
+func secretLookupDarwin(key string) (string, error) {
+	output, err := exec.Command("security", "find-generic-password", "-s", "sm2", "-a", key, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not in the keychain", key)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// Secrets are stored using the freedesktop secret service (gnome keyring, kwallet etc), e.g.
+//
+//	secret-tool store --label "sm2 MY_API_KEY" service sm2 key MY_API_KEY
+func secretLookupLinux(key string) (string, error) {
+	output, err := exec.Command("secret-tool", "lookup", "service", "sm2", "key", key).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not in the keyring", key)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+func openBrowserDarwin(url string) error {
+	return exec.Command("open", url).Start()
+}
+
+// xdg-open uses the desktop's default browser
+func openBrowserLinux(url string) error {
+	if err := exec.Command("xdg-open", url).Start(); err != nil {
+		return fmt.Errorf("unable to open a browser, xdg-open isn't installed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package platform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProcessInfo(t *testing.T) {
+	info, err := ParseProcessInfo("Fri Oct  2 09:30:01 2026 java -Dservice.manager.serviceName=AUTH  -cp lib/*\n", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2026, 10, 2, 9, 30, 1, 0, time.UTC); !info.Started.Equal(expected) {
+		t.Errorf("expected it to have started at %s, got %s", expected, info.Started)
+	}
+	if info.Args != "java -Dservice.manager.serviceName=AUTH -cp lib/*" {
+		t.Errorf("unexpected args %q", info.Args)
+	}
+
+	if _, err := ParseProcessInfo("", time.UTC); err == nil {
+		t.Errorf("expected nothing from ps to be an error")
+	}
+}
+
+func TestParseBootTime(t *testing.T) {
+	stat := "cpu  2255 34 2290 22625563 6290 127 456\nintr 114930548 113199788 3\nctxt 1990473\nbtime 1792142230\nprocesses 2915\n"
+	boot, ok := ParseBootTime(stat)
+	if !ok || !boot.Equal(time.Date(2026, 10, 16, 9, 17, 10, 0, time.UTC)) {
+		t.Errorf("expected the boot time to be read, got %s %v", boot, ok)
+	}
+	if _, ok := ParseBootTime("cpu  2255 34 2290\n"); ok {
+		t.Errorf("expected no boot time without a btime line")
+	}
+}
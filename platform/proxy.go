@@ -0,0 +1,130 @@
+package platform
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+)
+
+// The proxy the os is set up to use, usually by a corporate vpn client or mdm profile
+type ProxySettings struct {
+	HttpsProxy string   // host:port
+	HttpProxy  string   // host:port
+	Exceptions []string // hosts that don't go through the proxy, e.g. *.local
+	PacUrl     string   // the proxy auto-config file, when the proxy is chosen by one
+	Source     string   // where the settings came from, for the diagnostic
+}
+
+func (p ProxySettings) IsSet() bool {
+	return p.HttpsProxy != "" || p.HttpProxy != "" || p.PacUrl != ""
+}
+
+// macOS keeps the proxy settings of the active network service in the system configuration, e.g.
+//
+//	<dictionary> {
+//	  ExceptionsList : <array> {
+//	    0 : *.local
+//	  }
+//	  HTTPSEnable : 1
+//	  HTTPSPort : 8080
+//	  HTTPSProxy : proxy.example.com
+//	  ProxyAutoConfigEnable : 1
+//	  ProxyAutoConfigURLString : http://wpad/wpad.dat
+//	}
+func systemProxyDarwin() ProxySettings {
+	output, err := exec.Command("scutil", "--proxy").Output()
+	if err != nil {
+		return ProxySettings{}
+	}
+	return ParseScutilProxy(string(output))
+}
+
+func ParseScutilProxy(output string) ProxySettings {
+	values := map[string]string{}
+	exceptions := []string{}
+	inExceptions := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "}" {
+			inExceptions = false
+			continue
+		}
+		key, value, found := strings.Cut(line, " : ")
+		if !found {
+			continue
+		}
+		if inExceptions {
+			exceptions = append(exceptions, value)
+		} else if key == "ExceptionsList" {
+			inExceptions = true
+		} else {
+			values[key] = value
+		}
+	}
+
+	settings := ProxySettings{Source: "the macOS network settings"}
+	if values["HTTPSEnable"] == "1" && values["HTTPSProxy"] != "" {
+		settings.HttpsProxy = values["HTTPSProxy"] + ":" + values["HTTPSPort"]
+	}
+	if values["HTTPEnable"] == "1" && values["HTTPProxy"] != "" {
+		settings.HttpProxy = values["HTTPProxy"] + ":" + values["HTTPPort"]
+	}
+	if values["ProxyAutoConfigEnable"] == "1" {
+		settings.PacUrl = values["ProxyAutoConfigURLString"]
+	}
+	if settings.IsSet() {
+		settings.Exceptions = exceptions
+	}
+	return settings
+}
+
+// gnome (and most desktops that follow it) keep the proxy settings in gsettings. there's
+// nothing to find on a machine without a desktop, which is fine since there's no setting to honour.
+func systemProxyLinux() ProxySettings {
+	gsettings := func(schema, key string) string {
+		output, err := exec.Command("gsettings", "get", schema, key).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	settings := ProxySettings{Source: "the gnome proxy settings"}
+	switch unquoteGsetting(gsettings("org.gnome.system.proxy", "mode")) {
+	case "manual":
+		settings.HttpsProxy = gsettingsProxy(gsettings("org.gnome.system.proxy.https", "host"), gsettings("org.gnome.system.proxy.https", "port"))
+		settings.HttpProxy = gsettingsProxy(gsettings("org.gnome.system.proxy.http", "host"), gsettings("org.gnome.system.proxy.http", "port"))
+	case "auto":
+		settings.PacUrl = unquoteGsetting(gsettings("org.gnome.system.proxy", "autoconfig-url"))
+	default:
+		return ProxySettings{}
+	}
+	settings.Exceptions = ParseGsettingsList(gsettings("org.gnome.system.proxy", "ignore-hosts"))
+	return settings
+}
+
+func gsettingsProxy(host, port string) string {
+	host = unquoteGsetting(host)
+	if host == "" || port == "" || port == "0" {
+		return ""
+	}
+	return host + ":" + port
+}
+
+func unquoteGsetting(value string) string {
+	return strings.Trim(value, "'")
+}
+
+// parses a gvariant string array, e.g. ['localhost', '127.0.0.0/8']
+func ParseGsettingsList(value string) []string {
+	list := []string{}
+	value = strings.TrimPrefix(value, "@as ")
+	for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+		if item = unquoteGsetting(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,45 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScutilProxy(t *testing.T) {
+	output := `<dictionary> {
+  ExceptionsList : <array> {
+    0 : *.local
+    1 : 169.254/16
+  }
+  FTPPassive : 1
+  HTTPEnable : 0
+  HTTPSEnable : 1
+  HTTPSPort : 8080
+  HTTPSProxy : proxy.example.com
+  ProxyAutoConfigEnable : 1
+  ProxyAutoConfigURLString : http://wpad/wpad.dat
+}
+`
+	expected := ProxySettings{
+		HttpsProxy: "proxy.example.com:8080",
+		Exceptions: []string{"*.local", "169.254/16"},
+		PacUrl:     "http://wpad/wpad.dat",
+		Source:     "the macOS network settings",
+	}
+	if settings := ParseScutilProxy(output); !reflect.DeepEqual(settings, expected) {
+		t.Errorf("expected %+v got %+v", expected, settings)
+	}
+
+	if settings := ParseScutilProxy("<dictionary> {\n  FTPPassive : 1\n}\n"); settings.IsSet() {
+		t.Errorf("expected no proxy, got %+v", settings)
+	}
+}
+
+func TestParseGsettingsList(t *testing.T) {
+	if list := ParseGsettingsList("['localhost', '127.0.0.0/8', '::1']"); !reflect.DeepEqual(list, []string{"localhost", "127.0.0.0/8", "::1"}) {
+		t.Errorf("unexpected list %v", list)
+	}
+	if list := ParseGsettingsList("@as []"); len(list) != 0 {
+		t.Errorf("expected an empty list, got %v", list)
+	}
+}
@@ -2,6 +2,8 @@ package platform
 
 import (
 	"os"
+	"os/exec"
+	"strings"
 	"syscall"
 	"unsafe"
 )
@@ -30,3 +32,21 @@ func GetTerminalSize() (int, int) {
 
 	return int(ts.Cols), int(ts.Rows)
 }
+
+// RawTerminal turns off line buffering, echo and signals (so ctrl-c is read as a key) on the terminal, so keys
+// can be read as they're pressed. The func it returns puts the terminal back how it was
+func RawTerminal() (func(), error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(string(saved))) }, nil
+}
@@ -0,0 +1,208 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Wsl is what sm2 knows about the windows subsystem for linux it's running in, if it is. Under WSL 2 the
+// linux side is a vm with its own network, unless networking is mirrored, so windows and linux can each
+// have something listening on the same port, and windows only forwards localhost to linux when nothing
+// on windows has the port.
+type Wsl struct {
+	Version    int    // 1 or 2, or 0 outside of wsl
+	Distro     string // e.g. Ubuntu
+	Networking string // nat or mirrored, for wsl 2
+	HostIp     string // the windows host as seen from linux, with nat networking
+	MountRoot  string // where the windows drives are mounted, /mnt/ by default
+}
+
+var (
+	wsl     Wsl
+	wslOnce sync.Once
+)
+
+// DetectWsl looks for wsl once, since the answer can't change while sm2 is running
+func DetectWsl() Wsl {
+	wslOnce.Do(func() {
+		wsl = detectWsl()
+	})
+	return wsl
+}
+
+func noWsl() Wsl {
+	return Wsl{}
+}
+
+func detectWsl() Wsl {
+	osrelease, _ := os.ReadFile("/proc/sys/kernel/osrelease")
+	w := Wsl{Version: ParseWslVersion(string(osrelease)), Distro: os.Getenv("WSL_DISTRO_NAME")}
+	if w.Version == 0 {
+		return w
+	}
+
+	wslConf, _ := os.ReadFile("/etc/wsl.conf")
+	w.MountRoot = ParseAutomountRoot(string(wslConf))
+	if w.Version == 1 {
+		return w
+	}
+
+	// wslinfo only comes with newer versions of wsl, which are also the only ones that can mirror
+	w.Networking = "nat"
+	if output, err := exec.Command("wslinfo", "--networking-mode").Output(); err == nil {
+		w.Networking = strings.TrimSpace(string(output))
+	}
+	if w.Networking == "nat" {
+		routes, _ := os.ReadFile("/proc/net/route")
+		w.HostIp = ParseDefaultGateway(string(routes))
+	}
+	return w
+}
+
+func (w Wsl) IsWsl() bool {
+	return w.Version > 0
+}
+
+// true when windows has its own ports, separate from the ones sm2 can see
+func (w Wsl) SeparateNetwork() bool {
+	return w.Version == 2 && w.Networking != "mirrored"
+}
+
+// ParseWslVersion reads the kernel release, which is e.g. 5.15.153.1-microsoft-standard-WSL2 under wsl 2,
+// and 4.4.0-19041-Microsoft under wsl 1
+func ParseWslVersion(osrelease string) int {
+	osrelease = strings.TrimSpace(osrelease)
+	if strings.Contains(osrelease, "WSL2") {
+		return 2
+	}
+	if strings.Contains(strings.ToLower(osrelease), "microsoft") {
+		return 1
+	}
+	return 0
+}
+
+// ParseAutomountRoot finds where the windows drives are mounted, from the [automount] section of /etc/wsl.conf
+func ParseAutomountRoot(wslConf string) string {
+	root := "/mnt/"
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(wslConf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if found && section == "automount" && strings.TrimSpace(key) == "root" {
+			root = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return root
+}
+
+// ParseDefaultGateway finds the default route in /proc/net/route, where addresses are little endian hex, e.g.
+//
+//	Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+//	eth0	00000000	0100A8C0	0003	0	0	0	00000000	0	0	0
+func ParseDefaultGateway(routes string) string {
+	scanner := bufio.NewScanner(strings.NewReader(routes))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(gateway))
+		return ip.String()
+	}
+	return ""
+}
+
+var (
+	windowsDrivePath = regexp.MustCompile(`^([A-Za-z]):[\\/](.*)$`)
+	wslSharePath     = regexp.MustCompile(`^\\\\wsl(?:\$|\.localhost)\\[^\\]+(\\.*)?$`)
+)
+
+// IsWindowsPath is true for paths like C:\Users\me and \\wsl$\Ubuntu\home\me
+func IsWindowsPath(p string) bool {
+	return windowsDrivePath.MatchString(p) || wslSharePath.MatchString(p)
+}
+
+// LinuxPath translates a path copied from windows into the one linux sees, e.g. C:\Users\me to /mnt/c/Users/me,
+// or \\wsl.localhost\Ubuntu\home\me to /home/me. Other paths are returned as they are.
+func (w Wsl) LinuxPath(p string) string {
+	if !w.IsWsl() {
+		return p
+	}
+	if m := windowsDrivePath.FindStringSubmatch(p); m != nil {
+		return path.Join(w.MountRoot, strings.ToLower(m[1]), strings.ReplaceAll(m[2], `\`, "/"))
+	}
+	if m := wslSharePath.FindStringSubmatch(p); m != nil {
+		return path.Join("/", strings.ReplaceAll(m[1], `\`, "/"))
+	}
+	return p
+}
+
+// true for paths on a windows drive, e.g. /mnt/c/Users/me
+func (w Wsl) OnWindowsDrive(p string) bool {
+	if !w.IsWsl() || !strings.HasPrefix(p, w.MountRoot) {
+		return false
+	}
+	drive, _, _ := strings.Cut(strings.TrimPrefix(p, w.MountRoot), "/")
+	return len(drive) == 1
+}
+
+// WindowsListeners lists the ports something on windows is listening on, using windows' own netstat through
+// wsl's interop, as they can't be seen from linux with nat networking
+func (w Wsl) WindowsListeners() (map[int]bool, error) {
+	netstat, err := exec.LookPath("netstat.exe")
+	if err != nil {
+		netstat = path.Join(w.MountRoot, "c/Windows/System32/netstat.exe")
+	}
+	output, err := exec.Command(netstat, "-an", "-p", "TCP").Output()
+	if err != nil {
+		return nil, err
+	}
+	return ParseNetstatListeners(string(output)), nil
+}
+
+// ParseNetstatListeners reads the output of windows' netstat -an. The state is translated on non-english
+// windows, so listeners are the sockets without a remote address, e.g.
+//
+//	Proto  Local Address          Foreign Address        State
+//	TCP    127.0.0.1:9050         0.0.0.0:0              LISTENING
+//	TCP    [::]:135               [::]:0                 LISTENING
+func ParseNetstatListeners(output string) map[int]bool {
+	ports := map[int]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "TCP" {
+			continue
+		}
+		if fields[2] != "0.0.0.0:0" && fields[2] != "[::]:0" {
+			continue
+		}
+		local := fields[1]
+		if port, err := strconv.Atoi(local[strings.LastIndex(local, ":")+1:]); err == nil {
+			ports[port] = true
+		}
+	}
+	return ports
+}
@@ -0,0 +1,80 @@
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWslVersion(t *testing.T) {
+	for osrelease, expected := range map[string]int{
+		"5.15.153.1-microsoft-standard-WSL2\n": 2,
+		"4.4.0-19041-Microsoft":                1,
+		"6.8.0-45-generic":                     0,
+	} {
+		if version := ParseWslVersion(osrelease); version != expected {
+			t.Errorf("expected %s to be wsl %d, got %d", osrelease, expected, version)
+		}
+	}
+}
+
+func TestParseAutomountRoot(t *testing.T) {
+	if root := ParseAutomountRoot(""); root != "/mnt/" {
+		t.Errorf("expected the default mount root, got %s", root)
+	}
+	conf := "[boot]\nsystemd=true\n\n[automount]\nenabled = true\nroot = /\n"
+	if root := ParseAutomountRoot(conf); root != "/" {
+		t.Errorf("expected the mount root from wsl.conf, got %s", root)
+	}
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	routes := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\t0170A8AC\t0003\t0\t0\t0\t00000000\t0\t0\t0\n" +
+		"eth0\t0070A8AC\t00000000\t0001\t0\t0\t0\t00F0FFFF\t0\t0\t0\n"
+	if ip := ParseDefaultGateway(routes); ip != "172.168.112.1" {
+		t.Errorf("expected the default gateway, got %s", ip)
+	}
+	if ip := ParseDefaultGateway(""); ip != "" {
+		t.Errorf("expected no gateway, got %s", ip)
+	}
+}
+
+func TestLinuxPath(t *testing.T) {
+	wsl := Wsl{Version: 2, MountRoot: "/mnt/"}
+	for p, expected := range map[string]string{
+		`C:\Users\me\sm2`:                     "/mnt/c/Users/me/sm2",
+		`d:/code/auth`:                        "/mnt/d/code/auth",
+		`\\wsl.localhost\Ubuntu\home\me\.sm2`: "/home/me/.sm2",
+		`\\wsl$\Ubuntu`:                       "/",
+		"/home/me/.sm2":                       "/home/me/.sm2",
+	} {
+		if linux := wsl.LinuxPath(p); linux != expected {
+			t.Errorf("expected %s to be %s, got %s", p, expected, linux)
+		}
+	}
+	if p := (Wsl{}).LinuxPath(`C:\Users\me`); p != `C:\Users\me` {
+		t.Errorf("expected paths not to be translated outside of wsl, got %s", p)
+	}
+}
+
+func TestOnWindowsDrive(t *testing.T) {
+	wsl := Wsl{Version: 2, MountRoot: "/mnt/"}
+	if !wsl.OnWindowsDrive("/mnt/c/Users/me") || wsl.OnWindowsDrive("/mnt/data/sm2") || wsl.OnWindowsDrive("/home/me") {
+		t.Errorf("expected only /mnt/c to be a windows drive")
+	}
+}
+
+func TestParseNetstatListeners(t *testing.T) {
+	output := `
+Active Connections
+
+  Proto  Local Address          Foreign Address        State
+  TCP    0.0.0.0:135            0.0.0.0:0              LISTENING
+  TCP    127.0.0.1:9050         0.0.0.0:0              ABHÖREN
+  TCP    127.0.0.1:50432        127.0.0.1:9050         ESTABLISHED
+  TCP    [::]:8080              [::]:0                 LISTENING
+`
+	if ports := ParseNetstatListeners(output); !reflect.DeepEqual(ports, map[int]bool{135: true, 9050: true, 8080: true}) {
+		t.Errorf("unexpected listeners %v", ports)
+	}
+}
@@ -0,0 +1,203 @@
+package servicemanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var serviceIdPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// the values that can be given to --add-service as KEY=VALUE, in the order they're asked for
+var addServiceFields = []string{"name", "group", "artifact", "port", "health", "args"}
+
+// Creates a new service entry in the workspace's services-overrides.json, e.g.
+//
+//	sm2 --add-service MY_SERVICE group=uk.gov.hmrc artifact=my-service_%% port=9999 health=/ping/ping args="-Dfoo=bar"
+//
+// Anything not given on the command line is asked for, if sm2 is being run interactively.
+func (sm *ServiceManager) AddService(id string, values []string) error {
+	given, err := parseKeyValues(values, addServiceFields)
+	if err != nil {
+		return err
+	}
+
+	var prompt *bufio.Reader
+	if isInteractive() {
+		prompt = bufio.NewReader(os.Stdin)
+	}
+
+	entry, err := sm.buildServiceEntry(id, given, prompt, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	file, err := findConfigFile(sm.Config.WorkspaceDir, workspaceOverridesFile)
+	if err != nil {
+		return err
+	}
+	if isYamlFile(file) {
+		return fmt.Errorf("%s is yaml, sm2 can only add services to json. Add this to it instead:\n%s", file, formatEntry(id, entry))
+	}
+
+	if err := addToOverrides(file, id, entry); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s to %s:\n%s\n", id, file, formatEntry(id, entry))
+	return nil
+}
+
+// builds a services.json entry from the given values, asking for anything missing if prompt isn't nil
+func (sm *ServiceManager) buildServiceEntry(id string, given map[string]string, prompt *bufio.Reader, out io.Writer) (map[string]interface{}, error) {
+	if !serviceIdPattern.MatchString(id) {
+		return nil, fmt.Errorf("%s is not a valid service id, ids should be upper case, e.g. MY_SERVICE", id)
+	}
+	if _, exists := sm.Services[id]; exists {
+		return nil, fmt.Errorf("%s already exists", id)
+	}
+
+	defaultName := strings.ToLower(strings.ReplaceAll(id, "_", "-"))
+	defaults := map[string]string{
+		"name":     defaultName,
+		"artifact": defaultName + "_%%",
+		"health":   "/ping/ping",
+	}
+
+	values := map[string]string{}
+	for _, field := range addServiceFields {
+		value, ok := given[field]
+		if !ok && prompt != nil {
+			var err error
+			if value, err = ask(prompt, out, field, defaults[field]); err != nil {
+				return nil, err
+			}
+		} else if !ok {
+			value = defaults[field]
+		}
+		values[field] = value
+	}
+
+	if values["group"] == "" {
+		return nil, fmt.Errorf("group is required, e.g. group=uk.gov.hmrc")
+	}
+	if values["artifact"] == "" {
+		return nil, fmt.Errorf("artifact is required, e.g. artifact=my-service_%%%%")
+	}
+	if !strings.HasPrefix(values["health"], "/") {
+		return nil, fmt.Errorf("health should be the path of the health check, e.g. /ping/ping")
+	}
+
+	port, err := strconv.Atoi(values["port"])
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("port should be a number between 1 and 65535, e.g. port=9999")
+	}
+	for _, s := range sm.Services {
+		if s.DefaultPort == port {
+			return nil, fmt.Errorf("port %d is already used by %s", port, s.Id)
+		}
+	}
+
+	// the start script is named after the artifact without its scala version
+	script := scalaSuffix.ReplaceAllString(values["artifact"], "")
+	args, err := splitArgs(values["args"])
+	if err != nil {
+		return nil, fmt.Errorf("args should be a list of args, quoted like a shell's, %s", err)
+	}
+	cmd := append([]string{fmt.Sprintf("./%s/bin/%s", script, script)}, args...)
+
+	entry := map[string]interface{}{
+		"name":        values["name"],
+		"defaultPort": port,
+		"binary": map[string]interface{}{
+			"artifact": values["artifact"],
+			"groupId":  values["group"],
+			"cmd":      cmd,
+		},
+	}
+	if values["health"] != defaults["health"] {
+		entry["healthcheck"] = map[string]interface{}{
+			"url": "http://localhost:${port}" + values["health"],
+		}
+	}
+	return entry, nil
+}
+
+func ask(prompt *bufio.Reader, out io.Writer, field string, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(out, "%s [%s]: ", field, defaultValue)
+	} else {
+		fmt.Fprintf(out, "%s: ", field)
+	}
+	answer, err := prompt.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("no value given for %s", field)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultValue, nil
+	}
+	return answer, nil
+}
+
+// parses KEY=VALUE args, rejecting any keys that aren't allowed
+func parseKeyValues(args []string, allowed []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %s", arg)
+		}
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == parts[0]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s, expected one of %s", parts[0], strings.Join(allowed, ", "))
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values, nil
+}
+
+// adds a service to an overrides file, keeping whatever else is in it
+func addToOverrides(file string, id string, entry map[string]interface{}) error {
+	overrides := map[string]interface{}{}
+	if Exists(file) {
+		var err error
+		if overrides, err = readJsonObject(file); err != nil {
+			return fmt.Errorf("unable to read %s: %s", file, err)
+		}
+	}
+	overrides[id] = entry
+
+	data, err := json.MarshalIndent(overrides, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+func formatEntry(id string, entry map[string]interface{}) string {
+	data, _ := json.MarshalIndent(map[string]interface{}{id: entry}, "", "    ")
+	return string(data)
+}
+
+// true if there's a person to prompt, i.e. stdin is a terminal rather than a pipe or file, and it's not -machine
+func isInteractive() bool {
+	return !nonInteractive && stdinIsTerminal()
+}
+
+func stdinIsTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
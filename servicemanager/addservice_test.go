@@ -0,0 +1,93 @@
+package servicemanager
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestBuildServiceEntryFromValues(t *testing.T) {
+	sm := ServiceManager{Services: Services{"EXISTING": {Id: "EXISTING", DefaultPort: 9000}}}
+
+	entry, err := sm.buildServiceEntry("MY_SERVICE", map[string]string{
+		"group":  "uk.gov.hmrc",
+		"port":   "9999",
+		"health": "/health",
+		"args":   "-Dfoo=bar -J-Xmx256m",
+	}, nil, &bytes.Buffer{})
+	AssertNotErr(t, err)
+
+	expected := map[string]interface{}{
+		"name":        "my-service",
+		"defaultPort": 9999,
+		"binary": map[string]interface{}{
+			"artifact": "my-service_%%",
+			"groupId":  "uk.gov.hmrc",
+			"cmd":      []string{"./my-service/bin/my-service", "-Dfoo=bar", "-J-Xmx256m"},
+		},
+		"healthcheck": map[string]interface{}{"url": "http://localhost:${port}/health"},
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Errorf("expected %v, got %v", expected, entry)
+	}
+}
+
+func TestBuildServiceEntryChecksUniqueness(t *testing.T) {
+	sm := ServiceManager{Services: Services{"EXISTING": {Id: "EXISTING", DefaultPort: 9000}}}
+
+	tests := map[string]map[string]string{
+		"EXISTING":    {"group": "g", "port": "9001"},
+		"PORT_IN_USE": {"group": "g", "port": "9000"},
+		"NO_GROUP":    {"port": "9001"},
+		"lower_case":  {"group": "g", "port": "9001"},
+		"BAD_PORT":    {"group": "g", "port": "99999"},
+	}
+	for id, values := range tests {
+		if _, err := sm.buildServiceEntry(id, values, nil, &bytes.Buffer{}); err == nil {
+			t.Errorf("expected %s %v to be rejected", id, values)
+		}
+	}
+}
+
+func TestBuildServiceEntryPromptsForMissingValues(t *testing.T) {
+	sm := ServiceManager{Services: Services{}}
+	prompt := bufio.NewReader(strings.NewReader("\ncom.example\nfoo_2.13\n8123\n\n\n"))
+	out := bytes.Buffer{}
+
+	entry, err := sm.buildServiceEntry("FOO", map[string]string{}, prompt, &out)
+	AssertNotErr(t, err)
+
+	binary := entry["binary"].(map[string]interface{})
+	if entry["name"] != "foo" || entry["defaultPort"] != 8123 || binary["groupId"] != "com.example" || binary["artifact"] != "foo_2.13" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	if !reflect.DeepEqual(binary["cmd"], []string{"./foo/bin/foo"}) {
+		t.Errorf("expected the scala version to be removed from the start script, got %v", binary["cmd"])
+	}
+	if !strings.Contains(out.String(), "name [foo]: ") {
+		t.Errorf("expected to be asked for the name with a default, got %s", out.String())
+	}
+}
+
+func TestAddToOverridesKeepsExistingEntries(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-add-service*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "services-overrides.json")
+	os.WriteFile(file, []byte(`{"FOO": {"defaultPort": 8001}}`), 0644)
+
+	AssertNotErr(t, addToOverrides(file, "BAR", map[string]interface{}{"name": "bar"}))
+
+	services, err := loadServicesFromFile(file, nil)
+	AssertNotErr(t, err)
+	if (*services)["FOO"].DefaultPort != 8001 || (*services)["BAR"].Name != "bar" {
+		t.Errorf("expected both FOO and BAR, got %v", *services)
+	}
+}
@@ -0,0 +1,474 @@
+package servicemanager
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -api the daemon serves a REST API on localhost, so dashboards and editor plugins can control services:
+//
+//	GET  /api/services                 the running services
+//	GET  /api/services/ID              one service, running or not
+//	POST /api/services/ID/start        starts it, ?version= picks the version
+//	POST /api/services/ID/stop
+//	POST /api/services/ID/restart
+//	GET  /api/services/ID/health       checks it now, along with its recent health history
+//	GET  /api/services/ID/logs?lines=N the end of its log
+//	GET  /api/profiles/NAME            the services in a profile
+//	GET  /api/events                   services starting, stopping and changing health, as server-sent events
+//
+// Every request needs the token the daemon writes to api.json in the install dir, as an
+// "Authorization: Bearer" header, or a token= parameter for an EventSource, which can't set headers.
+
+const (
+	defaultApiPort  = 8999
+	apiStateFile    = "api.json"
+	defaultLogLines = 200
+)
+
+type adminApi struct {
+	sm    *ServiceManager
+	token string
+	lock  sync.Mutex // held while the api or the daemon is using the services, which the daemon reloads
+
+	eventsLock  sync.Mutex
+	subscribers map[chan apiEvent]bool
+	seen        map[string]string // the health last reported for each running service, empty until it's known
+}
+
+type apiEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // running, stopped, healthy, unhealthy or failed
+	Service string    `json:"service"`
+	Message string    `json:"message,omitempty"`
+}
+
+type apiService struct {
+	Service     string `json:"service"`
+	Name        string `json:"name,omitempty"`
+	Running     bool   `json:"running"`
+	Version     string `json:"version,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Pid         int    `json:"pid,omitempty"`
+	Health      string `json:"health,omitempty"`
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+type apiHealth struct {
+	Service   string  `json:"service"`
+	Running   bool    `json:"running"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs int64   `json:"latencyMs"`
+	Verdict   string  `json:"verdict"`
+	Samples   int     `json:"samples"`
+	Failures  int     `json:"failures"`
+	P50Ms     int64   `json:"p50Ms"`
+	P95Ms     int64   `json:"p95Ms"`
+	Uptime    float64 `json:"uptimeSeconds,omitempty"`
+}
+
+func newAdminApi(sm *ServiceManager, token string) *adminApi {
+	return &adminApi{
+		sm:          sm,
+		token:       token,
+		subscribers: map[chan apiEvent]bool{},
+		seen:        map[string]string{},
+	}
+}
+
+// starts serving the rest api and/or the grpc api in the background, and writes where they are, and the
+// token they both need, to api.json
+func (sm *ServiceManager) startApi(out io.Writer) (*adminApi, error) {
+	token := os.Getenv("SM2_API_TOKEN")
+	if token == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(b)
+	}
+	api := newAdminApi(sm, token)
+	state := map[string]interface{}{"token": token, "pid": os.Getpid()}
+
+	if sm.Commands.Api {
+		port := sm.Commands.ApiPort
+		if port == 0 {
+			port = defaultApiPort
+		}
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return nil, err
+		}
+		state["url"] = fmt.Sprintf("http://localhost:%d/api", port)
+		go func() {
+			if err := http.Serve(listener, api.handler()); err != nil {
+				fmt.Fprintf(out, "The api stopped: %s\n", err)
+			}
+		}()
+		fmt.Fprintf(out, "Serving the api on %s\n", state["url"])
+	}
+
+	if sm.Commands.Grpc {
+		address, err := api.serveGrpc(sm.Commands.GrpcPort, out)
+		if err != nil {
+			return nil, err
+		}
+		state["grpc"] = address
+		fmt.Fprintf(out, "Serving the grpc api on %s\n", address)
+	}
+
+	data, _ := json.MarshalIndent(state, "", "  ")
+	stateFile := path.Join(sm.Config.TmpDir, apiStateFile)
+	if err := os.WriteFile(stateFile, data, 0600); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "The token is in %s\n", stateFile)
+	return api, nil
+}
+
+func (api *adminApi) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/services", api.listServices)
+	mux.HandleFunc("/api/services/", api.serviceAction)
+	mux.HandleFunc("/api/profiles/", api.profile)
+	mux.HandleFunc("/api/events", api.streamEvents)
+	return api.authorize(mux)
+}
+
+// checks the token, and that the request was meant for localhost, so a web page can't get at the api by
+// pointing its own hostname at 127.0.0.1
+func (api *adminApi) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != "localhost" && host != "127.0.0.1" {
+			apiError(w, http.StatusForbidden, "the api is only served on localhost")
+			return
+		}
+
+		// the token is what protects the api, so any page that has it may use it
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if req.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = req.URL.Query().Get("token")
+		}
+		if !api.validToken(token) {
+			apiError(w, http.StatusUnauthorized, "a valid token is needed, it's in "+path.Join(api.sm.Config.TmpDir, apiStateFile))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (api *adminApi) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) == 1
+}
+
+func (api *adminApi) listServices(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, req.Method+" isn't supported")
+		return
+	}
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	services := []apiService{}
+	for _, status := range api.sm.findStatuses() {
+		services = append(services, api.toApiService(status))
+	}
+	writeJson(w, http.StatusOK, services)
+}
+
+func (api *adminApi) serviceAction(w http.ResponseWriter, req *http.Request) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/api/services/"), "/")
+	id = api.sm.renamedService(id)
+	if _, ok := api.sm.Services[id]; !ok {
+		apiError(w, http.StatusNotFound, id+" is not a service")
+		return
+	}
+
+	method := http.MethodGet
+	if action == "start" || action == "stop" || action == "restart" {
+		method = http.MethodPost
+	}
+	if req.Method != method {
+		apiError(w, http.StatusMethodNotAllowed, req.Method+" isn't supported")
+		return
+	}
+
+	switch action {
+	case "":
+		writeJson(w, http.StatusOK, api.findService(id))
+	case "start", "stop", "restart":
+		service, err := api.change(action, ServiceAndVersion{service: id, version: req.URL.Query().Get("version")})
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, service)
+	case "health":
+		writeJson(w, http.StatusOK, api.health(id))
+	case "logs":
+		api.logs(w, req, id)
+	default:
+		apiError(w, http.StatusNotFound, "unknown action "+action)
+	}
+}
+
+func (api *adminApi) profile(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, req.Method+" isn't supported")
+		return
+	}
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	name := strings.TrimPrefix(req.URL.Path, "/api/profiles/")
+	if _, ok := api.sm.Profiles[name]; !ok {
+		apiError(w, http.StatusNotFound, name+" is not a profile")
+		return
+	}
+	services := []string{}
+	for _, id := range api.sm.expandProfile(name) {
+		services = append(services, api.sm.renamedService(id))
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"profile": name, "services": services})
+}
+
+func (api *adminApi) toApiService(status serviceStatus) apiService {
+	return apiService{
+		Service:     status.service,
+		Name:        api.sm.Services[status.service].Name,
+		Running:     true,
+		Version:     status.version,
+		Port:        status.port,
+		Pid:         status.pid,
+		Health:      string(status.health),
+		BindAddress: status.bindAddress,
+	}
+}
+
+func (api *adminApi) findService(id string) apiService {
+	for _, status := range api.sm.findStatuses() {
+		if status.service == id {
+			return api.toApiService(status)
+		}
+	}
+	return apiService{Service: id, Name: api.sm.Services[id].Name}
+}
+
+// starts, stops or restarts a service, and returns how it is now. The caller holds the lock.
+func (api *adminApi) change(action string, sv ServiceAndVersion) (apiService, error) {
+	var err error
+	switch action {
+	case "start":
+		err = api.sm.StartService(sv)
+	case "stop":
+		err = api.sm.StopService(sv.service)
+	case "restart":
+		err = api.sm.Restart(ServiceAndVersion{service: sv.service})
+	default:
+		err = fmt.Errorf("unknown action %s", action)
+	}
+	// so it's health checked again, rather than its status from before being used
+	api.sm.statuses.forget(sv.service)
+	if err != nil {
+		api.publish(apiEvent{Time: time.Now().UTC(), Type: "failed", Service: sv.service, Message: err.Error()})
+		return apiService{}, err
+	}
+	api.publishChanges()
+	return api.findService(sv.service), nil
+}
+
+func (api *adminApi) health(id string) apiHealth {
+	result := apiHealth{Service: id, Verdict: VERDICT_DOWN}
+	if history, err := api.sm.Ledger.LoadHealthHistory(api.sm.Config.TmpDir); err == nil {
+		summary := summarizeHealth(id, history[id])
+		result.Verdict = summary.verdict
+		result.Samples = summary.samples
+		result.Failures = summary.failures
+		result.P50Ms = summary.p50.Milliseconds()
+		result.P95Ms = summary.p95.Milliseconds()
+	}
+
+	installDir, err := api.sm.findInstallDirOfService(id)
+	if err != nil {
+		return result
+	}
+	state, err := api.sm.Ledger.LoadStateFile(installDir)
+	if err != nil {
+		return result
+	}
+	if _, running := api.sm.Platform.PidLookup()[state.Pid]; !running {
+		return result
+	}
+
+	url := state.HealthcheckUrl
+	if url == "" {
+		url = defaultHealthcheckUrl(state.Port)
+	}
+	healthy, latency := api.sm.checkHealthTimed(url)
+	result.Running = true
+	result.Healthy = healthy
+	result.LatencyMs = latency.Milliseconds()
+	result.Uptime = time.Since(state.Started).Round(time.Second).Seconds()
+	return result
+}
+
+func (api *adminApi) logs(w http.ResponseWriter, req *http.Request, id string) {
+	lines := defaultLogLines
+	if n := req.URL.Query().Get("lines"); n != "" {
+		parsed, err := strconv.Atoi(n)
+		if err != nil || parsed < 1 {
+			apiError(w, http.StatusBadRequest, "lines should be a number above 0")
+			return
+		}
+		lines = parsed
+	}
+
+	logFile, err := api.sm.findLogFile(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	tail, err := tailFile(logFile, lines)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range tail {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func (api *adminApi) streamEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, "streaming isn't supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := api.subscribe()
+	defer api.unsubscribe(events)
+	for {
+		select {
+		case event := <-events:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (api *adminApi) subscribe() chan apiEvent {
+	api.eventsLock.Lock()
+	defer api.eventsLock.Unlock()
+	events := make(chan apiEvent, 100)
+	api.subscribers[events] = true
+	return events
+}
+
+func (api *adminApi) unsubscribe(events chan apiEvent) {
+	api.eventsLock.Lock()
+	defer api.eventsLock.Unlock()
+	delete(api.subscribers, events)
+}
+
+// a subscriber that's fallen behind misses events rather than holding everything else up
+func (api *adminApi) publish(event apiEvent) {
+	api.eventsLock.Lock()
+	defer api.eventsLock.Unlock()
+	for events := range api.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// publishes an event for each service that's started or stopped, or whose health has changed, since it was last called
+func (api *adminApi) publishChanges() {
+	running := api.sm.runningServicePorts()
+	history, err := api.sm.Ledger.LoadHealthHistory(api.sm.Config.TmpDir)
+	if err != nil {
+		history = nil
+	}
+
+	ids := []string{}
+	for id := range running {
+		ids = append(ids, id)
+	}
+	for id := range api.seen {
+		if _, ok := running[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		previous, seen := api.seen[id]
+		if _, ok := running[id]; !ok {
+			delete(api.seen, id)
+			api.publish(apiEvent{Time: now, Type: "stopped", Service: id})
+			continue
+		}
+		if !seen {
+			api.publish(apiEvent{Time: now, Type: "running", Service: id, Message: fmt.Sprintf("on port %d", running[id])})
+		}
+
+		health := previous
+		if samples := history[id]; len(samples) > 0 {
+			health = "unhealthy"
+			if samples[len(samples)-1].Healthy {
+				health = "healthy"
+			}
+		}
+		if health != previous {
+			api.publish(apiEvent{Time: now, Type: health, Service: id})
+		}
+		api.seen[id] = health
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
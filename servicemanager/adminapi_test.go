@@ -0,0 +1,142 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestAdminApi(t *testing.T) {
+	tmp := t.TempDir()
+	logDir := path.Join(tmp, "AUTH", "logs")
+	AssertNotErr(t, os.MkdirAll(logDir, 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(logDir, "stdout.log"), []byte("one\ntwo\nthree\n"), 0644))
+
+	sm := ServiceManager{
+		Client:   &http.Client{Timeout: 100 * time.Millisecond},
+		Config:   ServiceManagerConfig{TmpDir: tmp},
+		Services: Services{"AUTH": {Id: "AUTH", Name: "Auth", DefaultPort: 1}, "EMAIL": {Id: "EMAIL", DefaultPort: 2}},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+				return []ledger.StateFile{{Service: "AUTH", Version: "1.0.0", Port: 1, Pid: 1, Started: time.Now()}}, nil
+			},
+			LoadInstallFile: func(string) (ledger.InstallFile, error) {
+				return ledger.InstallFile{Path: path.Join(tmp, "AUTH")}, nil
+			},
+		},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{1: 1} },
+			Uptime:    func() time.Time { return time.Now().Add(-time.Hour) },
+		},
+	}
+	api := newAdminApi(&sm, "secret")
+	handler := api.handler()
+
+	get := func(url string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost:8999"+url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := get("/api/services", ""); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without a token to be refused, got %d", res.Code)
+	}
+	if res := get("/api/services", "wrong"); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request with the wrong token to be refused, got %d", res.Code)
+	}
+	if res := get("/api/services?token=secret", ""); res.Code != http.StatusOK {
+		t.Errorf("expected the token to be accepted as a parameter, got %d", res.Code)
+	}
+
+	req := httptest.NewRequest("GET", "http://evil.example.com/api/services", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusForbidden {
+		t.Errorf("expected a request for another host to be refused, got %d", res.Code)
+	}
+
+	services := []apiService{}
+	AssertNotErr(t, json.Unmarshal(get("/api/services", "secret").Body.Bytes(), &services))
+	if len(services) != 1 || services[0].Service != "AUTH" || services[0].Name != "Auth" || services[0].Version != "1.0.0" || !services[0].Running {
+		t.Errorf("unexpected services %+v", services)
+	}
+
+	service := apiService{}
+	AssertNotErr(t, json.Unmarshal(get("/api/services/EMAIL", "secret").Body.Bytes(), &service))
+	if service.Service != "EMAIL" || service.Running {
+		t.Errorf("expected EMAIL not to be running, got %+v", service)
+	}
+
+	if res := get("/api/services/NOPE", "secret"); res.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown service to be a 404, got %d", res.Code)
+	}
+	if res := get("/api/services/AUTH/start", "secret"); res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected start to need a POST, got %d", res.Code)
+	}
+
+	res = get("/api/services/AUTH/logs?lines=2", "secret")
+	if res.Code != http.StatusOK || res.Body.String() != "two\nthree\n" {
+		t.Errorf("expected the last two lines of the log, got %d %q", res.Code, res.Body.String())
+	}
+}
+
+func TestAdminApiPublishesChanges(t *testing.T) {
+	states := []ledger.StateFile{{Service: "AUTH", Port: 8500, Pid: 1}}
+	history := ledger.HealthHistory{}
+	sm := ServiceManager{
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(string) ([]ledger.StateFile, error) { return states, nil },
+			LoadHealthHistory: func(string) (ledger.HealthHistory, error) { return history, nil },
+		},
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{1: 1} }},
+	}
+	api := newAdminApi(&sm, "secret")
+	events := api.subscribe()
+	defer api.unsubscribe(events)
+
+	received := func() string {
+		found := []string{}
+		for {
+			select {
+			case event := <-events:
+				found = append(found, fmt.Sprintf("%s %s", event.Type, event.Service))
+			default:
+				return strings.Join(found, ", ")
+			}
+		}
+	}
+
+	api.publishChanges()
+	if got := received(); got != "running AUTH" {
+		t.Errorf("expected AUTH to be running, got %s", got)
+	}
+
+	history["AUTH"] = []ledger.HealthSample{{Healthy: true}}
+	api.publishChanges()
+	api.publishChanges()
+	if got := received(); got != "healthy AUTH" {
+		t.Errorf("expected AUTH to be healthy once, got %s", got)
+	}
+
+	history["AUTH"] = append(history["AUTH"], ledger.HealthSample{Healthy: false})
+	states = nil
+	api.publishChanges()
+	if got := received(); got != "stopped AUTH" {
+		t.Errorf("expected AUTH to have stopped, got %s", got)
+	}
+}
@@ -0,0 +1,177 @@
+package servicemanager
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -api-index serves a page on localhost that links to the openapi spec of every running service, so
+// exploring the apis of a local environment starts at one url. Services are checked for a spec each time
+// the page is loaded, at their openApiPath, or else the paths specs are usually found at, e.g.
+//
+//	"AUTH": {..., "openApiPath": "/api/docs/openapi.yaml"}
+//
+// The specs are served from the index too, so tools that load them don't run into cors.
+
+const defaultApiIndexPort = 8997
+
+// where services usually serve their spec, tried in order when there's no openApiPath
+var openApiPaths = []string{
+	"/api/conf/1.0/application.yaml",
+	"/openapi.json",
+	"/openapi.yaml",
+	"/v3/api-docs",
+	"/swagger.json",
+	"/api-docs",
+}
+
+// how long a service has to answer with its spec before it's left out
+const openApiProbeTimeout = 2 * time.Second
+
+type apiSpec struct {
+	Service string
+	Name    string
+	Url     string // where the service serves it
+}
+
+func (sm *ServiceManager) ServeApiIndex() {
+	port := defaultApiIndexPort
+	if sm.Commands.Port > 0 {
+		port = sm.Commands.Port
+	}
+
+	log.Printf("ApiIndex: serving the openapi specs of the running services on http://localhost:%d\n", port)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", port),
+		Handler: sm.apiIndexHandler(),
+	}
+	log.Fatal(server.ListenAndServe())
+}
+
+func (sm *ServiceManager) apiIndexHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		apiIndexTemplate.Execute(w, sm.findApiSpecs())
+	})
+	mux.HandleFunc("/specs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/specs/")
+		for _, spec := range sm.findApiSpecs() {
+			if spec.Service == id {
+				sm.copySpec(w, r, spec)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("%s isn't running, or doesn't have an openapi spec", id), http.StatusNotFound)
+	})
+	return mux
+}
+
+// the specs of the running services that have one, checking them all at once
+func (sm *ServiceManager) findApiSpecs() []apiSpec {
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return nil
+	}
+	pids := sm.Platform.PidLookup()
+
+	specs := []apiSpec{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, state := range states {
+		service, ok := sm.Services[state.Service]
+		if _, running := pids[state.Pid]; !running || !ok || isInfra(service) {
+			continue
+		}
+
+		paths := openApiPaths
+		if service.OpenApiPath != "" {
+			paths = []string{service.OpenApiPath}
+		}
+		base := "http://" + stateAddress(state)
+		name := service.Name
+		if name == "" {
+			name = service.Id
+		}
+
+		wg.Add(1)
+		go func(id string, name string) {
+			defer wg.Done()
+			for _, p := range paths {
+				if sm.probeSpec(base + p) {
+					lock.Lock()
+					specs = append(specs, apiSpec{Service: id, Name: name, Url: base + p})
+					lock.Unlock()
+					return
+				}
+			}
+		}(service.Id, name)
+	}
+	wg.Wait()
+
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Service < specs[j].Service })
+	return specs
+}
+
+func (sm *ServiceManager) probeSpec(url string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), openApiProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false
+	}
+	res, err := sm.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	// services that don't have one tend to answer with a 404 page or a redirect to a login page
+	return res.StatusCode == http.StatusOK && !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html")
+}
+
+func (sm *ServiceManager) copySpec(w http.ResponseWriter, r *http.Request, spec apiSpec) {
+	req, err := http.NewRequestWithContext(r.Context(), "GET", spec.Url, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := sm.Client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+var apiIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>sm2 api index</title>
+<style>body { font-family: sans-serif; margin: 2em } td { padding: 0.3em 1em 0.3em 0 }</style>
+</head>
+<body>
+<h1>APIs of the running services</h1>
+{{if .}}<table>
+{{range .}}<tr><td><b>{{.Service}}</b></td><td>{{.Name}}</td><td><a href="/specs/{{.Service}}">spec</a></td><td><a href="{{.Url}}">{{.Url}}</a></td></tr>
+{{end}}</table>
+{{else}}<p>None of the running services have an openapi spec at their openApiPath, or the paths specs are usually found at.</p>
+{{end}}</body>
+</html>
+`))
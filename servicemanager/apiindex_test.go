@@ -0,0 +1,76 @@
+package servicemanager
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestApiIndex(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/api-docs":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"openapi": "3.0.1"}`))
+		case "/docs/auth.yaml":
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write([]byte("openapi: 3.0.1\n"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer service.Close()
+	_, p, _ := net.SplitHostPort(strings.TrimPrefix(service.URL, "http://"))
+	port, _ := strconv.Atoi(p)
+
+	sm := ServiceManager{
+		Client: &http.Client{Timeout: time.Second},
+		Services: Services{
+			"AUTH":     {Id: "AUTH", Name: "Auth", OpenApiPath: "/docs/auth.yaml"},
+			"PAYMENTS": {Id: "PAYMENTS"},
+			"EMAIL":    {Id: "EMAIL", OpenApiPath: "/nope"},
+			"STOPPED":  {Id: "STOPPED"},
+		},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{
+				{Service: "PAYMENTS", Port: port, Pid: 1},
+				{Service: "AUTH", Port: port, Pid: 1},
+				{Service: "EMAIL", Port: port, Pid: 1},
+				{Service: "STOPPED", Port: port, Pid: 2},
+			}, nil
+		}},
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{1: 1} }},
+	}
+
+	specs := sm.findApiSpecs()
+	if len(specs) != 2 || specs[0].Service != "AUTH" || specs[0].Url != "http://localhost:"+p+"/docs/auth.yaml" || specs[1].Url != "http://localhost:"+p+"/v3/api-docs" {
+		t.Fatalf("expected AUTH and PAYMENTS to have specs, got %+v", specs)
+	}
+
+	handler := sm.apiIndexHandler()
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(res.Body.String(), `<a href="/specs/PAYMENTS">`) || strings.Contains(res.Body.String(), "EMAIL") {
+		t.Errorf("expected the index to link the specs, got %s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/specs/AUTH", nil))
+	if res.Code != http.StatusOK || res.Body.String() != "openapi: 3.0.1\n" || res.Header().Get("Content-Type") != "application/yaml" {
+		t.Errorf("expected the spec to be served, got %d %s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/specs/EMAIL", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected a service without a spec to be a 404, got %d", res.Code)
+	}
+}
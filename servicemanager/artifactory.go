@@ -7,7 +7,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path"
@@ -37,8 +36,6 @@ func (m MavenMetadata) ContainsVersion(version string) bool {
 	return false
 }
 
-var scalaSuffix *regexp.Regexp = regexp.MustCompile(`_(2\.\d{2}|3|%%)$`)
-
 var latestVersionScalaVersionSuffix *regexp.Regexp = regexp.MustCompile(`_%%$`)
 
 var userAgent = fmt.Sprintf("sm2/%s (%s %s)", version.Version, runtime.GOOS, runtime.GOARCH)
@@ -84,13 +81,29 @@ const (
 	ScalaVersion_Any  = "_%%"
 )
 
+// the scala versions tried for _%% artifacts when config.json or the workspace doesn't say otherwise
+var DefaultScalaVersions = []string{"3", "2.13", "2.12", "2.11"}
+
+// the suffixes to try for _%% artifacts, in order
+func (sm *ServiceManager) scalaVersions() []string {
+	versions := sm.Config.ScalaVersions
+	if len(versions) == 0 {
+		versions = DefaultScalaVersions
+	}
+	suffixes := []string{}
+	for _, v := range versions {
+		suffixes = append(suffixes, "_"+v)
+	}
+	return suffixes
+}
+
 func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersion string, suppliedServiceVersion string) (MavenMetadata, error) {
-	scalaVersions := []string{ScalaVersion_3, ScalaVersion_2_13, ScalaVersion_2_12, ScalaVersion_2_11}
+	scalaVersions := sm.scalaVersions()
 
 	// honours supplied Scala version
 	if suppliedScalaVersion != "" {
 		artifact := scalaSuffix.ReplaceAllLiteralString(s.Artifact, "_"+suppliedScalaVersion)
-		metadata, err := sm.getLatestVersion(s.GroupId, artifact)
+		metadata, err := sm.getLatestVersion(sm.repoUrl(s), s.GroupId, artifact)
 
 		return metadata, err
 	}
@@ -103,7 +116,7 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 		for _, v := range scalaVersions {
 			// tries all Scala versions to find which artifact contains the latest version
 			artifact := strings.Replace(s.Artifact, ScalaVersion_Any, v, 1)
-			metadata, err := sm.getLatestVersion(s.GroupId, artifact)
+			metadata, err := sm.getLatestVersion(sm.repoUrl(s), s.GroupId, artifact)
 
 			if err != nil {
 				continue
@@ -129,7 +142,7 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 	}
 
 	// uses Scala version set in config and for non Scala services
-	metadata, err := sm.getLatestVersion(s.GroupId, s.Artifact)
+	metadata, err := sm.getLatestVersion(sm.repoUrl(s), s.GroupId, s.Artifact)
 	if err != nil {
 		return metadata, fmt.Errorf("failed to find maven-metadata.xml for %s", s.Artifact)
 	}
@@ -137,14 +150,30 @@ func (sm *ServiceManager) GetLatestVersions(s ServiceBinary, suppliedScalaVersio
 	return metadata, err
 }
 
+// the repository to download a service from, services can override it to use e.g. a fork's artifacts
+func (sm *ServiceManager) repoUrl(s ServiceBinary) string {
+	if s.Repo != "" {
+		return strings.TrimSuffix(s.Repo, "/")
+	}
+	return sm.Config.ArtifactoryRepoUrl
+}
+
 // Connects to artifactory and parses maven metadata to get the latest release
-func (sm *ServiceManager) getLatestVersion(group string, artifact string) (MavenMetadata, error) {
+func (sm *ServiceManager) getLatestVersion(repoUrl string, group string, artifact string) (MavenMetadata, error) {
 
-	// build url
-	url := sm.Config.ArtifactoryRepoUrl + path.Join("/", group, artifact, "maven-metadata.xml")
+	// build url, the group can be in either the maven (uk.gov.hmrc) or path (uk/gov/hmrc) style
+	url := repoUrl + path.Join("/", strings.ReplaceAll(group, ".", "/"), artifact, "maven-metadata.xml")
+	if metadata, ok := sm.latest.get(url); ok {
+		return metadata, nil
+	}
+	if metadata, ok := sm.metadata.get(url); ok {
+		sm.latest.put(url, metadata)
+		return metadata, nil
+	}
 
 	// download metadata
-	ctx := sm.NewShortContext()
+	ctx, cancel := sm.NewShortContext()
+	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return MavenMetadata{}, err
@@ -162,21 +191,26 @@ func (sm *ServiceManager) getLatestVersion(group string, artifact string) (Maven
 	if resp.StatusCode != 200 {
 		return MavenMetadata{}, fmt.Errorf("failed to find maven-metadata.xml at %s", url)
 	}
-	return ParseMetadataXml(resp.Body)
+	metadata, err := ParseMetadataXml(resp.Body)
+	if err == nil {
+		sm.latest.put(url, metadata)
+		sm.metadata.put(url, metadata)
+	}
+	return metadata, err
 }
 
 // downloads a url and attempt to decompress it to a folder
 // assumes the target is a .tgz file
 // this could return the install(service) dir, would remove need to look it up later
-func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, progressWriter *ProgressWriter) (string, error) {
+func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, runCmd string, progressWriter *ProgressWriter, parent *Span) (serviceDir string, err error) {
 
 	// ensure base dir and logs dir exist
-	if err := os.MkdirAll(outdir, 0755); err != nil {
+	if err = os.MkdirAll(outdir, 0755); err != nil {
 		return "", err
 	}
 
 	// use default timeout. limiting by ctx works if its < client's timeout but not longer...
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(sm.context(), "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -184,17 +218,31 @@ func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, progr
 	// overrider header so we can track usage in artifactory
 	req.Header.Set("User-Agent", userAgent)
 
+	// only covers waiting for the response headers, the body is read in the "download" span below
+	requestSpan := sm.tracer.StartSpan("request", parent, "http.url", url)
 	resp, err := sm.Client.Do(req)
 	if err != nil {
+		requestSpan.End(err)
 		return "", err
 	}
 	defer resp.Body.Close()
+	requestSpan.SetAttr("http.status_code", fmt.Sprint(resp.StatusCode))
+	requestSpan.End(nil)
 
 	//TODO: follow redirect, more status codes etc
 	if resp.StatusCode != 200 {
 		return "", fmt.Errorf("http GET %s failed with status %s, expected 200", url, resp.Status)
 	}
 
+	// rather than running out part way through extracting it, see diskspace.go
+	if err := checkDiskSpace(outdir, resp.ContentLength, freeSpace); err != nil {
+		return "", err
+	}
+
+	// the body is streamed straight into the tar reader, so this covers both reading it and writing the files
+	downloadSpan := sm.tracer.StartSpan("download", parent, "http.response_content_length", fmt.Sprint(resp.ContentLength))
+	defer func() { downloadSpan.End(err) }()
+
 	md5Hasher := md5.New()
 	expectedHash, hasMd5 := resp.Header["X-Checksum-Md5"]
 
@@ -202,75 +250,288 @@ func (sm *ServiceManager) downloadAndDecompress(url string, outdir string, progr
 	tee := io.TeeReader(resp.Body, progressWriter) // split off to progress tracker
 	body := io.TeeReader(tee, md5Hasher)           // split off to calculate the checksum
 
-	gz, err := gzip.NewReader(body)
+	// extracted next to where it's going, and only moved into place once it's all there and the checksum
+	// matches, so an interrupted download never looks like it's installed
+	tmpDir, err := os.MkdirTemp(outdir, extractingPrefix)
 	if err != nil {
 		return "", err
 	}
-	defer gz.Close()
+	defer os.RemoveAll(tmpDir)
 
-	// used to determin the serviceDir
-	dirsSeen := map[string]uint8{}
+	serviceDir, err = extractTgz(body, tmpDir, runCmd)
+	if err != nil {
+		return "", err
+	}
 
-	tarReader := tar.NewReader(gz)
+	// check checksum and fail if it doesnt match
+	if hasMd5 {
+		actualHash := fmt.Sprintf("%x", md5Hasher.Sum(nil))
+		if actualHash != expectedHash[0] {
+			return "", fmt.Errorf("md5 did not match, %s != %s", actualHash, expectedHash[0])
+		}
+		// todo: do we need to return the hash? once validated its not much use tbh!
+	}
+
+	return moveExtracted(tmpDir, outdir, serviceDir)
+}
+
+// the temp dirs tgzs are extracted into, inside the install dir so they're on the same filesystem
+const extractingPrefix = ".extracting-"
+
+// moves everything extracted into tmpDir into outdir, returning where the service dir is now
+func moveExtracted(tmpDir string, outdir string, serviceDir string) (string, error) {
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(path.Join(tmpDir, entry.Name()), path.Join(outdir, entry.Name())); err != nil {
+			return "", err
+		}
+	}
+	if serviceDir == "" {
+		return "", nil
+	}
+	return path.Join(outdir, path.Base(serviceDir)), nil
+}
+
+// unpacks a service's tgz into outdir, returning the dir the service is in: the one with bin/runCmd in
+// it, see findServiceDir
+func extractTgz(r io.Reader, outdir string, runCmd string) (string, error) {
+	if err := unpackTgz(r, outdir); err != nil {
+		return "", err
+	}
+	return findServiceDir(outdir, runCmd)
+}
+
+// unpacks a tgz into outdir. The download, gunzip and writing the files overlap, see pipeline.go
+func unpackTgz(r io.Reader, outdir string) (err error) {
+	compressed := newReadAhead(r)
+	defer compressed.Close()
+	gz, err := gzip.NewReader(compressed)
+	if err != nil {
+		return err
+	}
+	uncompressed := newReadAhead(gz)
+	// when it's failed part way through, the goroutine reading ahead can be blocked reading from gz, and from
+	// compressed under that, so they're let go of and it's waited for before gz is closed
+	defer func() {
+		compressed.Close()
+		uncompressed.stop()
+		gz.Close()
+	}()
+
+	// hard links and the dirs' times are done once all the files have been written
+	links := []*tar.Header{}
+	dirs := []*tar.Header{}
+	symlinks := map[string]string{}
+	names := map[string]bool{}
+	files := newFileWriters()
+	defer func() {
+		if waitErr := files.wait(); err == nil {
+			err = waitErr
+		}
+		if err == nil {
+			err = finishExtract(outdir, links, dirs, symlinks)
+		}
+	}()
+
+	tarReader := tar.NewReader(uncompressed)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return err
+		}
+		// a broken (or malicious) tgz could otherwise write anywhere, e.g. ../../.bashrc
+		if !insideDir(outdir, path.Join(outdir, header.Name)) {
+			return fmt.Errorf("%s is outside of the install dir", header.Name)
+		}
+		// or write through a symlink it's already extracted, e.g. a/b -> .. then a/b/c -> .. then a/b/c/evil
+		name := path.Clean(header.Name)
+		if link := throughSymlink(symlinks, name); link != "" {
+			return fmt.Errorf("%s is inside of the symlink %s", header.Name, link)
+		}
+		seen := names[name]
+		names[name] = true
 
+		// pax and gnu long names, and the other extended headers, are already applied to the header by
+		// the tar reader. Anything else (devices, fifos etc) isn't something a service needs
 		switch header.Typeflag {
 
 		case tar.TypeDir:
 			// TODO: track dirs created so we can determin where exactly the app is
 			if err := os.MkdirAll(path.Join(outdir, header.Name), 0755); err != nil {
-				log.Fatalf("ExtractTarGz: Mkdir() failed: %s", err.Error())
+				return fmt.Errorf("failed to create dir %s: %s", header.Name, err)
 			}
+			dirs = append(dirs, header)
 
-		case tar.TypeReg:
-			// create folder if required
+		case tar.TypeSymlink:
 			dir, _ := path.Split(header.Name)
+			if path.IsAbs(header.Linkname) || !insideDir(outdir, path.Join(outdir, dir, header.Linkname)) {
+				return fmt.Errorf("%s links to %s, outside of the install dir", header.Name, header.Linkname)
+			}
 			if err := os.MkdirAll(path.Join(outdir, dir), 0755); err != nil {
-				log.Fatalf("Failed to created dir %s: %s", dir, err)
+				return fmt.Errorf("failed to create dir %s: %s", dir, err)
 			}
+			// a file with the same name could still be waiting to be written, and would be written through it
+			if seen {
+				return fmt.Errorf("%s is in the archive more than once", header.Name)
+			}
+			if err := os.Symlink(header.Linkname, path.Join(outdir, header.Name)); err != nil {
+				return fmt.Errorf("failed to create link %s: %s", header.Name, err)
+			}
+			symlinks[name] = header.Linkname
 
-			rootDir := strings.SplitN(path.Clean(dir), "/", 2)[0]
-			dirsSeen[rootDir] = 1
+		case tar.TypeLink:
+			if !insideDir(outdir, path.Join(outdir, header.Linkname)) {
+				return fmt.Errorf("%s links to %s, outside of the install dir", header.Name, header.Linkname)
+			}
+			links = append(links, header)
 
-			// write the file
-			outfile, err := os.Create(path.Join(outdir, header.Name))
-			if err != nil {
-				log.Fatalf("\nfailed to write to file %s\n%s", path.Join(outdir, header.Name), err)
+		case tar.TypeReg:
+			// create folder if required
+			dir, _ := path.Split(header.Name)
+			if err := os.MkdirAll(path.Join(outdir, dir), 0755); err != nil {
+				return fmt.Errorf("failed to create dir %s: %s", dir, err)
 			}
 
-			_, err = io.Copy(outfile, tarReader)
+			// write the file, small ones on the pool of writers
+			name, mode := path.Join(outdir, header.Name), header.FileInfo().Mode()
+			if header.Size > maxQueuedFileSize {
+				err = copyFile(name, mode, header.ModTime, tarReader)
+			} else {
+				data := make([]byte, header.Size)
+				if _, err = io.ReadFull(tarReader, data); err == nil {
+					err = files.write(name, mode, header.ModTime, data)
+				}
+			}
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("failed to write to file %s: %s", name, err)
 			}
-			// fix up the permissions
-			outfile.Chmod(header.FileInfo().Mode())
-			outfile.Close()
 		}
 	}
 
-	// check checksum and fail if it doesnt match
-	if hasMd5 {
-		actualHash := fmt.Sprintf("%x", md5Hasher.Sum(nil))
-		if actualHash != expectedHash[0] {
-			return "", fmt.Errorf("md5 did not match, %s != %s", actualHash, expectedHash[0])
+	// read to the end of the download, so the whole of it is checksummed
+	uncompressed.stop()
+	if _, err := io.Copy(io.Discard, compressed); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// the top level dir of an archive with bin/runCmd in it, which is where the service is run from. An archive
+// laid out any other way is an error, rather than guessing. Without a runCmd, it's the only top level dir
+func findServiceDir(outdir string, runCmd string) (string, error) {
+	entries, err := os.ReadDir(outdir)
+	if err != nil {
+		return "", err
+	}
+	dirs, found := []string{}, []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dirs = append(dirs, entry.Name())
+		if runCmd == "" {
+			found = append(found, entry.Name())
+		} else if info, err := os.Stat(path.Join(outdir, entry.Name(), "bin", runCmd)); err == nil && !info.IsDir() {
+			found = append(found, entry.Name())
 		}
-		// todo: do we need to return the hash? once validated its not much use tbh!
 	}
 
-	// based on the directories we've had to make, figure out which one the service is in
-	// we're assuming theres only one, this could be better
-	var serviceDir string
+	switch {
+	case len(found) == 1:
+		return path.Join(outdir, found[0]), nil
+	case runCmd == "":
+		return "", fmt.Errorf("expected the archive to have one top level dir, it has %d: %s", len(dirs), strings.Join(dirs, ", "))
+	case len(found) == 0:
+		return "", fmt.Errorf("expected the archive to have a dir with bin/%s in it, it has: %s", runCmd, strings.Join(dirs, ", "))
+	default:
+		return "", fmt.Errorf("expected the archive to have one dir with bin/%s in it, it has %d: %s", runCmd, len(found), strings.Join(found, ", "))
+	}
+}
 
-	delete(dirsSeen, ".")
-	for k := range dirsSeen {
-		// TODO: regex it or something? maybe inc the count every times its seen and go with the largest?
-		//       if we know what the bin dir is (from services.json) we could use that too
-		serviceDir = path.Join(outdir, k)
+// creates the hard links, now the files they link to have been written, and sets the dirs' times, which
+// writing their files changed
+func finishExtract(outdir string, links []*tar.Header, dirs []*tar.Header, symlinks map[string]string) error {
+	// a symlink that looked fine when it was extracted can go outside of the install dir through one extracted
+	// after it, so they're checked again now they're all there
+	for name, target := range symlinks {
+		if _, ok := resolveSymlinks(symlinks, path.Dir(name)+"/"+target); !ok || path.IsAbs(target) {
+			return fmt.Errorf("%s links to %s, outside of the install dir", name, target)
+		}
 	}
+	for _, link := range links {
+		if symlink := throughSymlink(symlinks, path.Clean(link.Name)); symlink != "" {
+			return fmt.Errorf("%s is inside of the symlink %s", link.Name, symlink)
+		}
+		target, ok := resolveSymlinks(symlinks, link.Linkname)
+		if !ok {
+			return fmt.Errorf("%s links to %s, outside of the install dir", link.Name, link.Linkname)
+		}
+		name := path.Join(outdir, link.Name)
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			return fmt.Errorf("failed to create dir %s: %s", path.Dir(link.Name), err)
+		}
+		if err := os.Link(path.Join(outdir, target), name); err != nil {
+			return fmt.Errorf("failed to create link %s: %s", link.Name, err)
+		}
+	}
+	for _, dir := range dirs {
+		if err := setModTime(path.Join(outdir, dir.Name), dir.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the symlink that name, or one of the dirs it's in, is, if any
+func throughSymlink(symlinks map[string]string, name string) string {
+	for dir := name; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := symlinks[dir]; ok {
+			return dir
+		}
+	}
+	return ""
+}
+
+// where name in the archive really is, following the symlinks extracted from it, like filepath.EvalSymlinks
+// would, but without needing the file to be there yet. False when it's outside of the archive
+func resolveSymlinks(symlinks map[string]string, name string) (string, bool) {
+	resolved := []string{}
+	parts := strings.Split(name, "/")
+	for followed := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+		case "..":
+			if len(resolved) == 0 {
+				return "", false
+			}
+			resolved = resolved[:len(resolved)-1]
+		default:
+			resolved = append(resolved, part)
+			target, ok := symlinks[strings.Join(resolved, "/")]
+			if !ok {
+				continue
+			}
+			// links to links to themselves never end
+			if followed++; followed > 40 || path.IsAbs(target) {
+				return "", false
+			}
+			resolved = resolved[:len(resolved)-1]
+			parts = append(strings.Split(target, "/"), parts...)
+		}
+	}
+	return strings.Join(resolved, "/"), true
+}
 
-	return serviceDir, nil
+// true when file is dir, or is in it
+func insideDir(dir string, file string) bool {
+	return strings.HasPrefix(path.Clean(file)+"/", path.Clean(dir)+"/")
 }
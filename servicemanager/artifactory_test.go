@@ -122,6 +122,81 @@ func TestGetLatestVersionForAllScalaVersions(t *testing.T) {
 	}
 }
 
+func TestGetLatestVersionOnlyTriesConfiguredScalaVersions(t *testing.T) {
+	requested := []string{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, path.Base(path.Dir(r.URL.Path)))
+		if r.URL.Path == "/foo/bar/foo_2.12/maven-metadata.xml" {
+			fmt.Fprint(w, mavenMetadata212Downgrade)
+		} else if r.URL.Path == "/foo/bar/foo_2.11/maven-metadata.xml" {
+			fmt.Fprint(w, mavenMetadata211Downgrade)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+	defer svr.Close()
+
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{
+			ArtifactoryRepoUrl: svr.URL,
+			ScalaVersions:      []string{"2.12", "3"},
+		},
+	}
+
+	sb := ServiceBinary{
+		GroupId:  "foo/bar/",
+		Artifact: "foo_%%",
+	}
+
+	meta, err := sm.GetLatestVersions(sb, "", "")
+	AssertNotErr(t, err)
+
+	// 2.11 has a later version, but isn't one of the versions to try
+	if meta.Latest != "3.45.0" {
+		t.Errorf("latest version was not 3.45.0, it was %s", meta.Latest)
+	}
+	if strings.Join(requested, ",") != "foo_2.12,foo_3" {
+		t.Errorf("expected foo_2.12 then foo_3 to be tried, got %v", requested)
+	}
+}
+
+func TestGetLatestVersionFromServicesRepo(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fork-releases/com/fork/foo_2.13/maven-metadata.xml" {
+			fmt.Fprint(w, mavenMetadata213)
+		} else {
+			w.WriteHeader(404)
+		}
+	}))
+	defer svr.Close()
+
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{
+			ArtifactoryRepoUrl: svr.URL + "/releases",
+		},
+	}
+
+	sb := ServiceBinary{
+		GroupId:  "com.fork",
+		Artifact: "foo_2.13",
+		Repo:     svr.URL + "/fork-releases/",
+	}
+
+	meta, err := sm.GetLatestVersions(sb, "", "")
+	AssertNotErr(t, err)
+
+	if meta.Latest != "3.44.0" {
+		t.Errorf("latest version was not 3.44.0, it was %s", meta.Latest)
+	}
+
+	sb.Repo = ""
+	if _, err := sm.GetLatestVersions(sb, "", ""); err == nil {
+		t.Error("expected the metadata to be missing from the default repo")
+	}
+}
+
 func TestGetLatestVersionGetsArtifactScalaVersion212(t *testing.T) {
 	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/foo/bar/foo_2.13/maven-metadata.xml" {
@@ -478,7 +553,7 @@ func TestDownloadAndDecompress(t *testing.T) {
 	}
 
 	// download the mock tgz
-	serviceDir, err := sm.downloadAndDecompress(svr.URL, outdir, &progress)
+	serviceDir, err := sm.downloadAndDecompress(svr.URL, outdir, "playtest", &progress, nil)
 
 	AssertNotErr(t, err)
 
@@ -496,3 +571,56 @@ func TestDownloadAndDecompress(t *testing.T) {
 		t.Errorf("progress tracker read 0 bytes, expected > 0")
 	}
 }
+
+func TestDownloadAndDecompressOnlyInstallsAVerifiedDownload(t *testing.T) {
+	outdir := t.TempDir()
+	progress := ProgressWriter{renderer: &ProgressRenderer{noProgress: true}}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Checksum-Md5", "0123456789abcdef0123456789abcdef")
+		f, err := os.Open("../testing/testdata/playtest-1.0.0.tgz")
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+		defer f.Close()
+		io.Copy(w, f)
+	}))
+	defer svr.Close()
+
+	sm := ServiceManager{Client: &http.Client{}}
+	if _, err := sm.downloadAndDecompress(svr.URL, outdir, "playtest", &progress, nil); err == nil {
+		t.Fatalf("expected a download that doesn't match its checksum to fail")
+	}
+
+	// neither the service nor the dir it was extracted into are left behind
+	files, err := os.ReadDir(outdir)
+	AssertNotErr(t, err)
+	if len(files) != 0 {
+		t.Errorf("expected nothing to be installed, found %v", files)
+	}
+}
+
+func TestFindServiceDir(t *testing.T) {
+	outdir := t.TempDir()
+	for _, file := range []string{"auth-1.0.0/bin/auth", "auth-1.0.0/lib/auth.jar", "docs/README.md", "licenses/bin/auth/LICENSE"} {
+		AssertNotErr(t, os.MkdirAll(path.Join(outdir, path.Dir(file)), 0755))
+		AssertNotErr(t, os.WriteFile(path.Join(outdir, file), nil, 0644))
+	}
+
+	if serviceDir, err := findServiceDir(outdir, "auth"); err != nil || serviceDir != path.Join(outdir, "auth-1.0.0") {
+		t.Errorf("expected the dir with bin/auth in it, got %s %v", serviceDir, err)
+	}
+	if _, err := findServiceDir(outdir, "frontend"); err == nil || !strings.Contains(err.Error(), "auth-1.0.0, docs, licenses") {
+		t.Errorf("expected an error listing the dirs the archive has, got %v", err)
+	}
+	if _, err := findServiceDir(outdir, ""); err == nil {
+		t.Errorf("expected more than one dir to be an error without a command to look for")
+	}
+
+	AssertNotErr(t, os.MkdirAll(path.Join(outdir, "auth-1.0.1/bin"), 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(outdir, "auth-1.0.1/bin/auth"), nil, 0755))
+	if _, err := findServiceDir(outdir, "auth"); err == nil {
+		t.Errorf("expected two dirs with bin/auth in them to be an error")
+	}
+}
@@ -8,27 +8,68 @@ import (
 	"strings"
 )
 
-// Print a valid bash autocomplete config to stdout
+// Print a valid bash, zsh or fish autocomplete config to stdout
 // intended use would be to pipe it into a file in the os's autocomplete folder
-func GenerateAutoCompletionScript() {
-	fmt.Println("# Below is a bash completion script for tab completion")
-	fmt.Println(
-		`_serv_words()
+func GenerateAutoCompletionScript(shell string) error {
+	switch shell {
+	case "", "bash":
+		fmt.Println("# Below is a bash completion script for tab completion")
+		fmt.Println(
+			`_serv_words()
 {
 	local count cur
 	cur=${COMP_WORDS[COMP_CWORD]}
 	prev=${COMP_WORDS[COMP_CWORD-1]}
 	count=${COMP_CWORD}
-	words=$(sm2 --autocomplete --comp-cword $count --comp-pword \"$prev\" )
+	words=$(sm2 --autocomplete --comp-cword $count --comp-pword \"$prev\" --comp-words "${COMP_WORDS[*]:1:COMP_CWORD-1}" )
 	COMPREPLY=( $(compgen -W "$words" -- $cur) )
 	return 0
 }
 complete -F _serv_words sm2`)
+	case "zsh":
+		fmt.Println(
+			`#compdef sm2
+# Below is a zsh completion script for tab completion
+_sm2() {
+	local -a completions
+	completions=(${=$(sm2 --autocomplete --comp-cword $((CURRENT-1)) --comp-pword "${words[CURRENT-1]}" --comp-words "${words[2,CURRENT-1]}")})
+	compadd -a completions
+}
+if [ "$funcstack[1]" = "_sm2" ]; then
+	_sm2 "$@"
+else
+	compdef _sm2 sm2
+fi`)
+	case "fish":
+		fmt.Println(
+			`# Below is a fish completion script for tab completion
+function __sm2_complete
+	set -l tokens (commandline -opc)
+	sm2 --autocomplete --comp-cword (count $tokens) --comp-pword "$tokens[-1]" --comp-words "$tokens[2..-1]" | string split -n ' '
+end
+complete -c sm2 -f -a '(__sm2_complete)'`)
+	default:
+		return fmt.Errorf("there's no completion script for %s, use bash, zsh or fish", shell)
+	}
+	return nil
 }
 
 func (sm *ServiceManager) GenerateAutocompleteResponse() string {
 	count := sm.Commands.CompWordCount
 	prev := strings.ReplaceAll(sm.Commands.CompPreviousWord, "\"", "")
+
+	// flags that are given a service
+	switch strings.ReplaceAll(prev, "--", "-") {
+	case "-debug", "-logs", "-traffic", "-why-failed":
+		return strings.Join(sm.startedServiceNames(false), " ")
+	case "-open":
+		return strings.Join(sm.startedServiceNames(true), " ")
+	case "-build-and-run", "-export-systemd":
+		return strings.Join(sm.serviceNames(false), " ")
+	case "-export-launchd":
+		return strings.Join(sm.serviceNames(true), " ")
+	}
+
 	if dontComplete(prev) {
 		return ""
 	}
@@ -44,38 +85,94 @@ func (sm *ServiceManager) GenerateAutocompleteResponse() string {
 	})
 
 	if count >= 2 {
-		keys := make([]string, len(sm.Services)+len(sm.Profiles))
-		i := 0
-		for k := range sm.Services {
-			keys[i] = k
-			i++
+		// only running services can be stopped or restarted
+		if stopsServices(strings.Fields(sm.Commands.CompWords)) {
+			words.WriteString(strings.Join(sm.startedServiceNames(true), " "))
+		} else {
+			words.WriteString(strings.Join(sm.serviceNames(true), " "))
+		}
+	}
+	return words.String()
+}
 
+func stopsServices(words []string) bool {
+	for _, word := range words {
+		switch strings.ReplaceAll(word, "--", "-") {
+		case "-stop", "-restart":
+			return true
 		}
+	}
+	return false
+}
 
+// the ids of every service, and optionally profile, in the config
+func (sm *ServiceManager) serviceNames(withProfiles bool) []string {
+	keys := []string{}
+	for k := range sm.Services {
+		keys = append(keys, k)
+	}
+	if withProfiles {
 		for k := range sm.Profiles {
-			keys[i] = k
-			i++
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
 
+// the services sm2 has started, from their state files, optionally only the ones that are still running
+func (sm *ServiceManager) startedServiceNames(running bool) []string {
+	keys := []string{}
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return keys
+	}
+	pids := map[int]int{}
+	if running {
+		pids = sm.Platform.PidLookup()
+	}
+	for _, state := range states {
+		if _, ok := pids[state.Pid]; ok || !running {
+			keys = append(keys, state.Service)
 		}
-		sort.Strings(keys)
-		words.WriteString(strings.Join(keys, " "))
 	}
-	return words.String()
+	sort.Strings(keys)
+	return keys
 }
 
 // Non boolean arguments can't be autocompleted
 func dontComplete(previousWord string) bool {
 	switch strings.ReplaceAll(previousWord, "--", "-") {
 	case
+		"-add-service",
+		"-api-port",
 		"-appendArgs",
+		"-ci-output",
 		"-comp-cword",
 		"-comp-pword",
+		"-comp-words",
 		"-config",
-		"-debug",
-		"-logs",
+		"-config-get",
+		"-config-set",
+		"-cors",
+		"-export-compose",
+		"-export-ports",
+		"-export-proxy",
+		"-format",
+		"-grpc-port",
+		"-import-v1",
+		"-overlay",
+		"-pin-config",
 		"-port",
 		"-ports",
+		"-profile-url",
+		"-remote",
+		"-repo",
 		"-search",
+		"-serve-capture",
+		"-serve-stub",
+		"-set-secret",
+		"-use-workspace",
 		"-wait",
 		"-workers",
 		"-delay-seconds":
@@ -0,0 +1,66 @@
+package servicemanager
+
+import (
+	"strings"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestAutocompleteResponse(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{"AUTH": {Id: "AUTH"}, "EMAIL": {Id: "EMAIL"}, "PAYMENTS": {Id: "PAYMENTS"}},
+		Profiles: Profiles{"CORE": {"AUTH", "PAYMENTS"}},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{{Service: "PAYMENTS", Pid: 1}, {Service: "EMAIL", Pid: 2}}, nil
+		}},
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{1: 1} }},
+	}
+
+	complete := func(count int, words string) []string {
+		fields := strings.Fields(words)
+		sm.Commands = cli.UserOption{CompWordCount: count, CompPreviousWord: fields[len(fields)-1], CompWords: words}
+		names := []string{}
+		for _, word := range strings.Fields(sm.GenerateAutocompleteResponse()) {
+			if !strings.HasPrefix(word, "-") {
+				names = append(names, word)
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		words    string
+		expected string
+	}{
+		{"-start", "AUTH CORE EMAIL PAYMENTS"},
+		{"-start AUTH", "AUTH CORE EMAIL PAYMENTS"},
+		{"-stop", "PAYMENTS"},
+		{"--restart PAYMENTS", "PAYMENTS"},
+		{"-logs", "EMAIL PAYMENTS"},
+		{"-why-failed", "EMAIL PAYMENTS"},
+		{"-open", "PAYMENTS"},
+		{"-export-systemd", "AUTH EMAIL PAYMENTS"},
+		{"-build-and-run", "AUTH EMAIL PAYMENTS"},
+		{"-repo", ""},
+		{"-port", ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(complete(len(strings.Fields(test.words))+1, test.words), " "); got != test.expected {
+			t.Errorf("completing %s, expected %q got %q", test.words, test.expected, got)
+		}
+	}
+}
+
+func TestGenerateAutoCompletionScript(t *testing.T) {
+	for _, shell := range []string{"", "bash", "zsh", "fish"} {
+		if err := GenerateAutoCompletionScript(shell); err != nil {
+			t.Errorf("expected a script for %q, got %s", shell, err)
+		}
+	}
+	if err := GenerateAutoCompletionScript("powershell"); err == nil {
+		t.Errorf("expected an error for an unknown shell")
+	}
+}
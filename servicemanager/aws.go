@@ -0,0 +1,320 @@
+package servicemanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Artifacts can be kept in AWS rather than artifactory, either in CodeArtifact or an S3 bucket in the maven
+// layout, by using its url as the artifactoryUrl setting or a service's binary.repo, e.g.
+//
+//	sm2 -config-set artifactoryUrl=https://acme-123456789012.d.codeartifact.eu-west-2.amazonaws.com/maven/releases
+//	sm2 -config-set artifactoryUrl=s3://acme-artifacts/maven
+//
+// Requests to S3 are signed with AWS signature version 4, and CodeArtifact is sent an authorization token,
+// fetched with a signed request. The credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or
+// otherwise the aws cli (aws configure export-credentials), which covers profiles, SSO and roles. The awsProfile
+// setting picks the profile, and awsRegion the region of s3:// buckets. Credentials and tokens are refreshed
+// shortly before they expire, so a long batch of downloads doesn't fail part way through.
+
+const (
+	// how long before they expire credentials and tokens are replaced
+	awsRefreshMargin = 5 * time.Minute
+	// the sha256 of an empty body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var (
+	codeArtifactHost = regexp.MustCompile(`^(.+)-(\d{12})\.d\.codeartifact\.([a-z0-9-]+)\.amazonaws\.com$`)
+	s3Host           = regexp.MustCompile(`^(?:.+\.)?s3[.-]([a-z0-9-]+)\.amazonaws\.com$`)
+)
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time // zero if they don't expire
+}
+
+type codeArtifactToken struct {
+	token   string
+	expires time.Time
+}
+
+// signs requests to S3 and adds tokens to requests to CodeArtifact, passing everything else straight through
+type awsTransport struct {
+	next    http.RoundTripper
+	profile string
+	region  string // for s3:// urls
+
+	credentialSource func(profile string) (awsCredentials, error)
+	s3Endpoint       func(bucket, region string) string
+	tokenEndpoint    func(region string) string
+	now              func() time.Time
+
+	lock        sync.Mutex
+	credentials *awsCredentials
+	tokens      map[string]codeArtifactToken // by domain and owner
+}
+
+// wraps the client's transport, so AWS hosted artifacts can be used anywhere artifactory can
+func (sm *ServiceManager) configureAws() {
+	if sm.Client == nil {
+		return
+	}
+	next := sm.Client.Transport
+	if throttled, ok := next.(*throttledTransport); ok {
+		// it's wrapped again, see configureThrottling
+		next = throttled.next
+	}
+	if aws, ok := next.(*awsTransport); ok {
+		// the config's been reloaded
+		next = aws.next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	region := sm.Commands.Settings["awsRegion"]
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	sm.Client.Transport = newAwsTransport(next, sm.Commands.Settings["awsProfile"], region)
+}
+
+func newAwsTransport(next http.RoundTripper, profile string, region string) *awsTransport {
+	return &awsTransport{
+		next:             next,
+		profile:          profile,
+		region:           region,
+		credentialSource: awsCredentialChain,
+		s3Endpoint: func(bucket, region string) string {
+			return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+		},
+		tokenEndpoint: func(region string) string {
+			return fmt.Sprintf("https://codeartifact.%s.amazonaws.com", region)
+		},
+		now:    time.Now,
+		tokens: map[string]codeArtifactToken{},
+	}
+}
+
+func (t *awsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "s3" {
+		endpoint, err := url.Parse(t.s3Endpoint(req.URL.Host, t.region))
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host, req.Host = endpoint.Scheme, endpoint.Host, ""
+		return t.signed(req, "s3", t.region)
+	}
+
+	host := req.URL.Hostname()
+	if m := s3Host.FindStringSubmatch(host); m != nil {
+		return t.signed(req.Clone(req.Context()), "s3", m[1])
+	}
+	if m := codeArtifactHost.FindStringSubmatch(host); m != nil {
+		token, err := t.codeArtifactToken(m[1], m[2], m[3])
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.SetBasicAuth("aws", token)
+		return t.next.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// artifacts are only ever downloaded, so the body is always empty
+func (t *awsTransport) signed(req *http.Request, service string, region string) (*http.Response, error) {
+	creds, err := t.currentCredentials()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	signV4(req, creds, region, service, emptyPayloadHash, t.now())
+	return t.next.RoundTrip(req)
+}
+
+func (t *awsTransport) currentCredentials() (awsCredentials, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.credentials != nil && (t.credentials.Expiration.IsZero() || t.now().Add(awsRefreshMargin).Before(t.credentials.Expiration)) {
+		return *t.credentials, nil
+	}
+	creds, err := t.credentialSource(t.profile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	t.credentials = &creds
+	return creds, nil
+}
+
+// fetches an authorization token for a CodeArtifact domain, or reuses the last one until it's about to expire
+func (t *awsTransport) codeArtifactToken(domain, owner, region string) (string, error) {
+	key := domain + "-" + owner
+	t.lock.Lock()
+	cached, ok := t.tokens[key]
+	t.lock.Unlock()
+	if ok && t.now().Add(awsRefreshMargin).Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	query := url.Values{"domain": {domain}, "domain-owner": {owner}}
+	req, err := http.NewRequest("POST", t.tokenEndpoint(region)+"/v1/authorization-token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.signed(req, "codeartifact", region)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get a CodeArtifact token for %s: %s %s", domain, resp.Status, strings.TrimSpace(string(body)))
+	}
+	result := struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		Expiration         float64 `json:"expiration"` // seconds since the epoch
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("unable to read the CodeArtifact token for %s: %s", domain, err)
+	}
+
+	token := codeArtifactToken{result.AuthorizationToken, time.Unix(int64(result.Expiration), 0)}
+	t.lock.Lock()
+	t.tokens[key] = token
+	t.lock.Unlock()
+	return token.token, nil
+}
+
+// the environment, then whatever the aws cli would use, which includes SSO
+func awsCredentialChain(profile string) (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return awsCredentials{AccessKeyId: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	args := []string{"configure", "export-credentials", "--format", "process"}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+	cmd := exec.Command("aws", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return awsCredentials{}, fmt.Errorf("unable to get AWS credentials from the aws cli, you may need to run `aws sso login`: %s", err)
+	}
+	return parseProcessCredentials(output)
+}
+
+// reads the credential_process format, e.g.
+//
+//	{"Version": 1, "AccessKeyId": "ASIA...", "SecretAccessKey": "...", "SessionToken": "...", "Expiration": "2024-05-01T12:00:00+00:00"}
+func parseProcessCredentials(data []byte) (awsCredentials, error) {
+	process := struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      string
+	}{}
+	if err := json.Unmarshal(data, &process); err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to read the AWS credentials: %s", err)
+	}
+	if process.AccessKeyId == "" || process.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials were found")
+	}
+	creds := awsCredentials{AccessKeyId: process.AccessKeyId, SecretAccessKey: process.SecretAccessKey, SessionToken: process.SessionToken}
+	if process.Expiration != "" {
+		expiration, err := time.Parse(time.RFC3339, process.Expiration)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("the AWS credentials have an invalid expiration %s", process.Expiration)
+		}
+		creds.Expiration = expiration
+	}
+	return creds, nil
+}
+
+// signs a request with AWS signature version 4, covering the host and any x-amz- headers
+func signV4(req *http.Request, creds awsCredentials, region string, service string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{req.Method, uri, query, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSha256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
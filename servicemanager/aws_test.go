@@ -0,0 +1,133 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func okResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}
+}
+
+// the get-vanilla case from the aws signature version 4 test suite
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := awsCredentials{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, creds, "us-east-1", "service", emptyPayloadHash, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("expected %s\ngot %s", expected, auth)
+	}
+}
+
+func TestS3Urls(t *testing.T) {
+	requests := []*http.Request{}
+	transport := newAwsTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+		return okResponse("<metadata/>"), nil
+	}), "", "eu-west-2")
+	transport.credentialSource = func(string) (awsCredentials, error) {
+		return awsCredentials{AccessKeyId: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}, nil
+	}
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get("s3://acme-artifacts/maven/uk/gov/auth/maven-metadata.xml")
+	AssertNotErr(t, err)
+	_, err = client.Get("http://artifactory.example.com/uk/gov/auth/maven-metadata.xml")
+	AssertNotErr(t, err)
+
+	s3 := requests[0]
+	if s3.URL.String() != "https://acme-artifacts.s3.eu-west-2.amazonaws.com/maven/uk/gov/auth/maven-metadata.xml" {
+		t.Errorf("expected the bucket's url, got %s", s3.URL)
+	}
+	if auth := s3.Header.Get("Authorization"); !strings.Contains(auth, "Credential=ASIAEXAMPLE/") || !strings.Contains(auth, "/eu-west-2/s3/aws4_request") {
+		t.Errorf("expected the request to be signed, got %s", auth)
+	}
+	if s3.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Errorf("expected the session token to be sent")
+	}
+	if other := requests[1]; other.Header.Get("Authorization") != "" {
+		t.Errorf("expected other requests not to be signed, got %s", other.Header.Get("Authorization"))
+	}
+}
+
+func TestCodeArtifactTokensAreRefreshed(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tokens, credentials := 0, 0
+	downloads := []*http.Request{}
+	transport := newAwsTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/v1/authorization-token" {
+			tokens++
+			if req.URL.Query().Get("domain") != "acme" || req.URL.Query().Get("domain-owner") != "123456789012" || req.Header.Get("Authorization") == "" {
+				t.Errorf("unexpected token request %s %v", req.URL, req.Header)
+			}
+			return okResponse(fmt.Sprintf(`{"authorizationToken": "token-%d", "expiration": %d}`, tokens, now.Add(time.Hour).Unix())), nil
+		}
+		downloads = append(downloads, req)
+		return okResponse(""), nil
+	}), "", "us-east-1")
+	transport.now = func() time.Time { return now }
+	transport.credentialSource = func(string) (awsCredentials, error) {
+		credentials++
+		return awsCredentials{AccessKeyId: "ASIAEXAMPLE", SecretAccessKey: "secret", Expiration: now.Add(30 * time.Minute)}, nil
+	}
+	client := &http.Client{Transport: transport}
+	repo := "https://acme-123456789012.d.codeartifact.eu-west-2.amazonaws.com/maven/releases"
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(repo + "/uk/gov/auth/maven-metadata.xml")
+		AssertNotErr(t, err)
+	}
+	if tokens != 1 || credentials != 1 {
+		t.Errorf("expected the token and credentials to be reused, got %d tokens and %d credentials", tokens, credentials)
+	}
+	if _, password, _ := downloads[1].BasicAuth(); password != "token-1" {
+		t.Errorf("expected the token to be sent, got %s", password)
+	}
+
+	// nearly an hour into a long batch of downloads
+	now = now.Add(56 * time.Minute)
+	_, err := client.Get(repo + "/uk/gov/auth/1.0.0/auth-1.0.0.tgz")
+	AssertNotErr(t, err)
+	if tokens != 2 || credentials != 2 {
+		t.Errorf("expected the token and credentials to be refreshed, got %d tokens and %d credentials", tokens, credentials)
+	}
+	if _, password, _ := downloads[2].BasicAuth(); password != "token-2" {
+		t.Errorf("expected the new token to be sent, got %s", password)
+	}
+}
+
+func TestParseProcessCredentials(t *testing.T) {
+	creds, err := parseProcessCredentials([]byte(`{"Version": 1, "AccessKeyId": "ASIAEXAMPLE", "SecretAccessKey": "secret", "SessionToken": "session", "Expiration": "2024-05-01T12:00:00+00:00"}`))
+	AssertNotErr(t, err)
+	if creds.SessionToken != "session" || !creds.Expiration.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+	if _, err := parseProcessCredentials([]byte(`{"Version": 1}`)); err == nil {
+		t.Errorf("expected missing credentials to be an error")
+	}
+}
+
+func TestAwsCredentialsFromTheEnvironment(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	creds, err := awsCredentialChain("")
+	AssertNotErr(t, err)
+	if creds.AccessKeyId != "AKIAEXAMPLE" || !creds.Expiration.IsZero() {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
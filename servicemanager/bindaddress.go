@@ -0,0 +1,115 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Services only listen on the loopback address unless told otherwise, either for every service with the
+// bindAddress workspace setting or for a single service with "bindAddress" in its config (usually in an
+// overrides file), e.g. "0.0.0.0" to let a phone or a colleague's machine on the same network reach it.
+
+const DefaultBindAddress = "127.0.0.1"
+
+// the address families a healthcheck can be limited to, by default localhost is used and either works
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// the address a service should listen on
+func (sm *ServiceManager) bindAddress(service Service) string {
+	if service.BindAddress != "" {
+		return service.BindAddress
+	}
+	if sm.Config.BindAddress != "" {
+		return sm.Config.BindAddress
+	}
+	return DefaultBindAddress
+}
+
+// the healthcheck url for a service, pointed at the address it's listening on
+func (sm *ServiceManager) serviceHealthcheckUrl(service Service, port int) string {
+	url := findHealthcheckUrl(service, port)
+	if (service.Type == TunnelType || service.Type == PactType || isInfra(service)) && service.Healthcheck.Url == "" {
+		url = tcpHealthcheckUrl(port)
+	}
+	if service.Type == WiremockType && service.Healthcheck.Url == "" {
+		url = wiremockHealthcheckUrl(port)
+	}
+	return bindHealthcheckUrl(url, sm.bindAddress(service), service.Healthcheck.Family)
+}
+
+// localhost can resolve to either 127.0.0.1 or ::1, so services that only listen on one of them are probed
+// on that address, as are services with a healthcheck family of ipv4 or ipv6
+func bindHealthcheckUrl(url string, bindAddress string, family string) string {
+	host := ""
+	switch {
+	case !isLocalAddress(bindAddress):
+		host = bindAddress
+	case family == FamilyIPv4:
+		host = "127.0.0.1"
+	case family == FamilyIPv6 || bindAddress == "::1":
+		host = "::1"
+	default:
+		return url
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return strings.Replace(url, "//localhost:", "//"+host+":", 1)
+}
+
+// true if the address includes localhost, i.e. loopback or every interface
+func isLocalAddress(address string) bool {
+	if address == "" || address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+func isExposed(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && !ip.IsLoopback()
+}
+
+// lists the services that can be reached from other machines, and where from
+func printExposedServices(statuses []serviceStatus, out io.Writer) {
+	exposed := []string{}
+	for _, s := range statuses {
+		if isExposed(s.bindAddress) {
+			host := s.bindAddress
+			if net.ParseIP(host).IsUnspecified() {
+				host = lanAddress()
+			}
+			exposed = append(exposed, fmt.Sprintf("  %s listens on %s, try http://%s\n", s.service, net.JoinHostPort(s.bindAddress, fmt.Sprint(s.port)), net.JoinHostPort(host, fmt.Sprint(s.port))))
+		}
+	}
+	if len(exposed) == 0 {
+		return
+	}
+
+	sort.Strings(exposed)
+	fmt.Fprint(out, "\n\033[33mThe following services can be reached from other machines on your network:\n")
+	for _, e := range exposed {
+		fmt.Fprint(out, e)
+	}
+	fmt.Fprint(out, "\033[0m")
+}
+
+// the first non-loopback ipv4 address of this machine, which is usually the one on the lan
+func lanAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return "localhost"
+}
@@ -0,0 +1,56 @@
+package servicemanager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBindAddress(t *testing.T) {
+	sm := ServiceManager{}
+	exposed := Service{Id: "FOO", BindAddress: "0.0.0.0"}
+	if address := sm.bindAddress(Service{Id: "BAR"}); address != DefaultBindAddress {
+		t.Errorf("expected services to listen on loopback by default, got %s", address)
+	}
+
+	sm.Config.BindAddress = "192.168.1.20"
+	if address := sm.bindAddress(Service{Id: "BAR"}); address != "192.168.1.20" {
+		t.Errorf("expected the workspace setting to be used, got %s", address)
+	}
+	if address := sm.bindAddress(exposed); address != "0.0.0.0" {
+		t.Errorf("expected the service's own address to win, got %s", address)
+	}
+
+	for address, expected := range map[string]string{
+		"127.0.0.1":    "http://localhost:9000/ping/ping",
+		"0.0.0.0":      "http://localhost:9000/ping/ping",
+		"::1":          "http://[::1]:9000/ping/ping",
+		"192.168.1.20": "http://192.168.1.20:9000/ping/ping",
+		"fe80::1":      "http://[fe80::1]:9000/ping/ping",
+	} {
+		if url := bindHealthcheckUrl(defaultHealthcheckUrl(9000), address, ""); url != expected {
+			t.Errorf("expected %s for %s, got %s", expected, address, url)
+		}
+	}
+
+	// the family picks which loopback address to probe
+	if url := bindHealthcheckUrl(defaultHealthcheckUrl(9000), "0.0.0.0", FamilyIPv6); url != "http://[::1]:9000/ping/ping" {
+		t.Errorf("expected the ipv6 loopback address, got %s", url)
+	}
+	if url := bindHealthcheckUrl(tcpHealthcheckUrl(9000), DefaultBindAddress, FamilyIPv4); url != "tcp://127.0.0.1:9000" {
+		t.Errorf("expected the ipv4 loopback address, got %s", url)
+	}
+}
+
+func TestPrintExposedServices(t *testing.T) {
+	out := bytes.Buffer{}
+	printExposedServices([]serviceStatus{{service: "FOO", port: 9000, bindAddress: "127.0.0.1"}, {service: "OLD", port: 9001}}, &out)
+	if out.Len() > 0 {
+		t.Errorf("expected nothing to be printed for services on loopback, got %s", out.String())
+	}
+
+	printExposedServices([]serviceStatus{{service: "FOO", port: 9000, bindAddress: "192.168.1.20"}}, &out)
+	if !strings.Contains(out.String(), "FOO listens on 192.168.1.20:9000, try http://192.168.1.20:9000") {
+		t.Errorf("expected FOO to be listed as exposed, got %s", out.String())
+	}
+}
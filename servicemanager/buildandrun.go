@@ -0,0 +1,171 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+)
+
+// sm2 -build-and-run runs a service from a local checkout, as it would run from artifactory, for trying out
+// changes without publishing them, e.g.
+//
+//	sm2 -build-and-run AUTH -repo ~/code/auth
+//
+// sbt publishLocal is run in the repo (the current dir without -repo), then the tgz it published to
+// ~/.ivy2/local for the service's artifact is installed and started as that version, e.g. 1.3.0-SNAPSHOT.
+// If the service is already running it's stopped first, so running the same command again picks up
+// the next change.
+//
+// Local builds are kept until the service is started with another version, so -restart runs the same build.
+
+// the url recorded in the install file of a local build
+const localBuildScheme = "file://"
+
+type localBuild struct {
+	file     string // the tgz sbt published
+	artifact string // e.g. auth_2.13
+	version  string
+}
+
+func (sm *ServiceManager) BuildAndRun(name string, repo string) error {
+	id := sm.renamedService(name)
+	service, ok := sm.Services[id]
+	if !ok {
+		return fmt.Errorf("%s is not a valid service", name)
+	}
+	if err := retiredServiceError(id, service); err != nil {
+		return err
+	}
+	if service.Type != "" || service.Binary.Artifact == "" {
+		return fmt.Errorf("%s isn't run from a binary, so it can't be built locally", id)
+	}
+
+	if repo == "" {
+		repo, _ = os.Getwd()
+	}
+	repo = sm.wsl().LinuxPath(repo)
+	if !Exists(path.Join(repo, "build.sbt")) {
+		return fmt.Errorf("%s isn't an sbt project, use -repo to say where %s is checked out", repo, id)
+	}
+
+	started := time.Now()
+	fmt.Printf("Building %s in %s...\n", id, repo)
+	cmd := exec.Command("sbt", "publishLocal")
+	cmd.Dir = repo
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sbt publishLocal failed: %s", err)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	build, err := findLocalBuild(path.Join(home, ".ivy2", "local"), service.Binary, started)
+	if err != nil {
+		return err
+	}
+
+	if sm.isAlreadyRunning(service) {
+		if err := sm.StopService(id); err != nil {
+			return err
+		}
+	}
+
+	installDir, err := sm.findInstallDirOfService(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Installing %s %s...\n", build.artifact, build.version)
+	if _, err := sm.installLocalBuild(installDir, id, build); err != nil {
+		return err
+	}
+
+	sm.asyncStart([]ServiceAndVersion{{service: id, version: build.version}})
+	return nil
+}
+
+// finds the newest tgz of the service's artifact published to ivyLocal since the build started
+func findLocalBuild(ivyLocal string, binary ServiceBinary, since time.Time) (localBuild, error) {
+	// ivy's local layout is group/artifact/version/type/file, with the group as it is, dots and all
+	matches, err := filepath.Glob(path.Join(ivyLocal, binary.GroupId, "*", "*", "*", "*.tgz"))
+	if err != nil {
+		return localBuild{}, err
+	}
+
+	newest := localBuild{}
+	var newestTime time.Time
+	for _, file := range matches {
+		versionDir := path.Dir(path.Dir(file))
+		artifact := path.Base(path.Dir(versionDir))
+		if !matchesArtifact(binary.Artifact, artifact) {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().Before(since) || info.ModTime().Before(newestTime) {
+			continue
+		}
+		newest = localBuild{file: file, artifact: artifact, version: path.Base(versionDir)}
+		newestTime = info.ModTime()
+	}
+
+	if newest.file == "" {
+		return newest, fmt.Errorf("the build didn't publish a tgz of %s to %s, check it packages the service when it's published", binary.Artifact, path.Join(ivyLocal, binary.GroupId))
+	}
+	return newest, nil
+}
+
+// artifacts ending _%% match any scala version
+func matchesArtifact(configured string, published string) bool {
+	if strings.HasSuffix(configured, "_%%") {
+		return scalaSuffix.ReplaceAllString(published, "_%%") == configured
+	}
+	return configured == published
+}
+
+func (sm *ServiceManager) installLocalBuild(installDir string, serviceId string, build localBuild) (ledger.InstallFile, error) {
+	if err := removeExistingVersions(installDir); err != nil {
+		return ledger.InstallFile{}, err
+	}
+
+	tgz, err := os.Open(build.file)
+	if err != nil {
+		return ledger.InstallFile{}, err
+	}
+	defer tgz.Close()
+	tmpDir, err := os.MkdirTemp(installDir, extractingPrefix)
+	if err != nil {
+		return ledger.InstallFile{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+	serviceDir, err := extractTgz(tgz, tmpDir, runCmdOf(sm.Services[serviceId]))
+	if err == nil {
+		serviceDir, err = moveExtracted(tmpDir, installDir, serviceDir)
+	}
+	if err != nil {
+		removeExistingVersions(installDir)
+		return ledger.InstallFile{}, err
+	}
+
+	installFile := ledger.InstallFile{
+		Service:  serviceId,
+		Artifact: build.artifact,
+		Version:  build.version,
+		Path:     serviceDir,
+		Url:      localBuildScheme + build.file,
+		Created:  time.Now(),
+	}
+	return installFile, sm.Ledger.SaveInstallFile(installDir, installFile)
+}
+
+// local builds aren't in artifactory, so they're only run when asked for by their version
+func isLocalBuild(installFile ledger.InstallFile, version string) bool {
+	return strings.HasPrefix(installFile.Url, localBuildScheme) && installFile.Version == version && Exists(installFile.Path)
+}
@@ -0,0 +1,95 @@
+package servicemanager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func publish(t *testing.T, ivyLocal string, artifact string, version string, modified time.Time) string {
+	dir := path.Join(ivyLocal, "uk.gov.hmrc", artifact, version, "tgzs")
+	AssertNotErr(t, os.MkdirAll(dir, 0755))
+	file := path.Join(dir, artifact+".tgz")
+
+	f, err := os.Create(file)
+	AssertNotErr(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	content := []byte("#!/bin/sh\n")
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Name: "auth-" + version + "/bin/auth", Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	tw.Write(content)
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	AssertNotErr(t, os.Chtimes(file, modified, modified))
+	return file
+}
+
+func TestFindLocalBuild(t *testing.T) {
+	ivyLocal := t.TempDir()
+	since := time.Now().Add(-time.Minute)
+	publish(t, ivyLocal, "auth_2.13", "1.2.0-SNAPSHOT", since.Add(-time.Hour))
+	publish(t, ivyLocal, "auth-frontend_2.13", "1.4.0-SNAPSHOT", since.Add(20*time.Second))
+	file := publish(t, ivyLocal, "auth_2.13", "1.3.0-SNAPSHOT", since.Add(10*time.Second))
+
+	build, err := findLocalBuild(ivyLocal, ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "auth_%%"}, since)
+	AssertNotErr(t, err)
+	if build.file != file || build.artifact != "auth_2.13" || build.version != "1.3.0-SNAPSHOT" {
+		t.Errorf("expected the build of auth that was just published, got %+v", build)
+	}
+
+	if _, err := findLocalBuild(ivyLocal, ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "auth_%%"}, time.Now()); err == nil {
+		t.Errorf("expected older builds not to be used")
+	}
+}
+
+func TestMatchesArtifact(t *testing.T) {
+	for _, tc := range []struct {
+		configured string
+		published  string
+		matches    bool
+	}{
+		{"auth_%%", "auth_2.13", true},
+		{"auth_%%", "auth_3", true},
+		{"auth_%%", "auth-frontend_2.13", false},
+		{"auth_2.13", "auth_2.13", true},
+		{"auth_2.13", "auth_3", false},
+		{"auth", "auth", true},
+	} {
+		if matchesArtifact(tc.configured, tc.published) != tc.matches {
+			t.Errorf("expected %s matching %s to be %v", tc.configured, tc.published, tc.matches)
+		}
+	}
+}
+
+func TestLocalBuildIsRunAsInstalled(t *testing.T) {
+	ivyLocal := t.TempDir()
+	installDir := t.TempDir()
+	file := publish(t, ivyLocal, "auth_2.13", "1.3.0-SNAPSHOT", time.Now())
+
+	sm := ServiceManager{Ledger: ledger.NewLedger()}
+	build := localBuild{file: file, artifact: "auth_2.13", version: "1.3.0-SNAPSHOT"}
+	installFile, err := sm.installLocalBuild(installDir, "AUTH", build)
+	AssertNotErr(t, err)
+	if installFile.Path != path.Join(installDir, "auth-1.3.0-SNAPSHOT") || !Exists(path.Join(installFile.Path, "bin", "auth")) {
+		t.Errorf("expected the build to be unpacked into the install dir, got %+v", installFile)
+	}
+
+	// no vpn or artifactory needed to run it
+	installed, version, err := sm.installVersion(Service{Id: "AUTH"}, ServiceAndVersion{service: "AUTH", version: "1.3.0-SNAPSHOT"}, installDir, nil)
+	AssertNotErr(t, err)
+	if version != "1.3.0-SNAPSHOT" || installed.Url != "file://"+file {
+		t.Errorf("expected the local build to be run, got %s %+v", version, installed)
+	}
+
+	if isLocalBuild(installed, "1.2.0") {
+		t.Errorf("expected other versions to be installed from artifactory")
+	}
+}
@@ -0,0 +1,342 @@
+package servicemanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services named by -capture have the requests made to them recorded, to debug how services talk to each
+// other, e.g.
+//
+//	sm2 -start MY_PROFILE -capture AUTH,PAYMENTS
+//	sm2 -traffic AUTH
+//
+// A captured service is started on a free port, and a proxy (sm2 itself, run in the background with
+// -serve-capture) listens on its usual port, so everything that calls it goes through the proxy. The method,
+// path, status, timing and headers of each request, and with -capture-bodies the start of the bodies, are
+// appended to traffic.jsonl in the service's install dir. Health checks aren't recorded.
+//
+// The proxy is stopped along with the service. Restarting a service starts it without the proxy.
+
+// written to the service's install dir for the capture proxy
+type captureConfig struct {
+	Service string `json:"service"`
+	Address string `json:"address"` // where the proxy listens, the service's usual port
+	Target  string `json:"target"`  // where the service is really running
+	Traffic string `json:"traffic"` // the file requests are recorded in
+	Bodies  bool   `json:"bodies"`
+	Ignore  string `json:"ignore"` // the health check path
+}
+
+const (
+	captureConfigFile = "capture.json"
+	captureLogFile    = "capture.log"
+	trafficFile       = "traffic.jsonl"
+	// bodies are cut short after this many bytes
+	captureBodyLimit = 64 * 1024
+)
+
+// one request and its response
+type capturedRequest struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Url             string      `json:"url"`
+	Status          int         `json:"status"`
+	DurationMillis  int64       `json:"durationMillis"`
+	RequestHeaders  http.Header `json:"requestHeaders"`
+	ResponseHeaders http.Header `json:"responseHeaders"`
+	RequestSize     int64       `json:"requestSize"`
+	ResponseSize    int64       `json:"responseSize"`
+	RequestBody     string      `json:"requestBody,omitempty"`
+	ResponseBody    string      `json:"responseBody,omitempty"`
+	Error           string      `json:"error,omitempty"` // when the service couldn't be reached
+}
+
+// the services named by -capture, which only works for services that speak http
+func (sm *ServiceManager) isCaptured(service Service) bool {
+	if service.Type != "" {
+		return false
+	}
+	for _, name := range strings.Split(sm.Commands.Capture, ",") {
+		if strings.TrimSpace(name) == service.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// starts the proxy in front of a service that's been started on servicePort, and updates its state
+// file with the proxy, so it's found on its usual port and stopped with it
+func (sm *ServiceManager) startCapture(service Service, port int, servicePort int) error {
+	installDir, err := sm.findInstallDirOfService(service.Id)
+	if err != nil {
+		return err
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil {
+		return err
+	}
+
+	bindAddress := sm.bindAddress(service)
+	ignore := ""
+	if u, err := url.Parse(state.HealthcheckUrl); err == nil {
+		ignore = u.Path
+	}
+	config := captureConfig{
+		Service: service.Id,
+		Address: net.JoinHostPort(bindAddress, fmt.Sprint(port)),
+		Target:  stateAddress(state),
+		Traffic: path.Join(installDir, trafficFile),
+		Bodies:  sm.Commands.CaptureBodies,
+		Ignore:  ignore,
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	configFile := path.Join(installDir, captureConfigFile)
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		return err
+	}
+	// each start records from scratch
+	if err := os.WriteFile(config.Traffic, nil, 0644); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	log, err := os.Create(path.Join(installDir, captureLogFile))
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, "-serve-capture", configFile)
+	cmd.Dir = installDir
+	cmd.Stdout = log
+	cmd.Stderr = log
+	// otherwise ctrl-c while sm2 is waiting for services to start would stop the proxy too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	return sm.updateState(installDir, func(state *ledger.StateFile) error {
+		state.Port = port
+		state.CapturePid = cmd.Process.Pid
+		return nil
+	})
+}
+
+// runs the capture proxy described by the file until it's stopped, used by -serve-capture
+func ServeCapture(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	config := captureConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s is not a valid capture config: %s", file, err)
+	}
+
+	traffic, err := os.OpenFile(config.Traffic, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer traffic.Close()
+
+	fmt.Printf("capturing traffic to %s on %s, forwarding to %s\n", config.Service, config.Address, config.Target)
+	return http.ListenAndServe(config.Address, captureHandler(config, traffic))
+}
+
+func captureHandler(config captureConfig, traffic io.Writer) http.Handler {
+	target := &url.URL{Scheme: "http", Host: config.Target}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if recorder, ok := w.(*captureWriter); ok {
+			recorder.err = err
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	lock := sync.Mutex{}
+	encoder := json.NewEncoder(traffic)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == config.Ignore {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		captured := capturedRequest{
+			Time:           time.Now().UTC(),
+			Method:         r.Method,
+			Url:            r.URL.RequestURI(),
+			RequestHeaders: r.Header.Clone(),
+		}
+		requestBody := &limitedBuffer{capture: config.Bodies}
+		if r.Body != nil {
+			r.Body = readCloser{io.TeeReader(r.Body, requestBody), r.Body}
+		}
+		recorder := &captureWriter{ResponseWriter: w, status: http.StatusOK, body: limitedBuffer{capture: config.Bodies}}
+
+		proxy.ServeHTTP(recorder, r)
+
+		captured.Status = recorder.status
+		captured.DurationMillis = time.Since(captured.Time).Milliseconds()
+		captured.ResponseHeaders = recorder.Header().Clone()
+		captured.RequestSize = requestBody.size
+		captured.ResponseSize = recorder.body.size
+		captured.RequestBody = requestBody.String()
+		captured.ResponseBody = recorder.body.String()
+		if recorder.err != nil {
+			captured.Error = recorder.err.Error()
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		encoder.Encode(captured)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// counts everything written to it, keeping the start of it when capturing bodies
+type limitedBuffer struct {
+	bytes.Buffer
+	capture bool
+	size    int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+	if b.capture && b.Len() < captureBodyLimit {
+		keep := p
+		if len(keep) > captureBodyLimit-b.Len() {
+			keep = keep[:captureBodyLimit-b.Len()]
+		}
+		b.Buffer.Write(keep)
+	}
+	return len(p), nil
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   limitedBuffer
+	err    error // why the service couldn't be reached
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// server sent events etc are passed on as they arrive
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// prints the requests captured for a service, with their headers and bodies when verbose
+func (sm *ServiceManager) PrintTraffic(name string, out io.Writer) error {
+	id := sm.renamedService(name)
+	installDir, err := sm.findInstallDirOfService(id)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path.Join(installDir, trafficFile))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no traffic has been captured for %s, start it with sm2 -start %s -capture %s", id, id, id)
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*captureBodyLimit)
+	for scanner.Scan() {
+		captured := capturedRequest{}
+		if err := json.Unmarshal(scanner.Bytes(), &captured); err != nil {
+			continue
+		}
+		count++
+		printCapturedRequest(captured, sm.Commands.Verbose, out)
+	}
+	if count == 0 {
+		fmt.Fprintf(out, "No requests have been made to %s yet\n", id)
+	}
+	return scanner.Err()
+}
+
+func printCapturedRequest(captured capturedRequest, verbose bool, out io.Writer) {
+	status := fmt.Sprint(captured.Status)
+	if captured.Error != "" {
+		status = "ERR"
+	}
+	fmt.Fprintf(out, "%s  %-7s %-4s %6dms  %s\n", captured.Time.Local().Format("15:04:05.000"), captured.Method, status, captured.DurationMillis, captured.Url)
+	if captured.Error != "" {
+		fmt.Fprintf(out, "    %s\n", captured.Error)
+	}
+	if !verbose {
+		return
+	}
+
+	printHeaders := func(prefix string, headers http.Header) {
+		names := []string{}
+		for name := range headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range headers[name] {
+				fmt.Fprintf(out, "    %s %s: %s\n", prefix, name, value)
+			}
+		}
+	}
+	printHeaders(">", captured.RequestHeaders)
+	if captured.RequestBody != "" {
+		fmt.Fprintf(out, "    > %s\n", indentBody(captured.RequestBody, captured.RequestSize))
+	}
+	printHeaders("<", captured.ResponseHeaders)
+	if captured.ResponseBody != "" {
+		fmt.Fprintf(out, "    < %s\n", indentBody(captured.ResponseBody, captured.ResponseSize))
+	}
+	fmt.Fprintln(out)
+}
+
+func indentBody(body string, size int64) string {
+	if int64(len(body)) < size {
+		body += fmt.Sprintf("... (%d bytes)", size)
+	}
+	return strings.ReplaceAll(strings.TrimRight(body, "\n"), "\n", "\n      ")
+}
@@ -0,0 +1,114 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestCaptureHandler(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"received": "` + string(body) + `"}`))
+	}))
+	defer service.Close()
+
+	traffic := bytes.Buffer{}
+	config := captureConfig{Service: "AUTH", Target: strings.TrimPrefix(service.URL, "http://"), Bodies: true, Ignore: "/ping/ping"}
+	handler := captureHandler(config, &traffic)
+
+	req := httptest.NewRequest("POST", "/auth/sessions?user=1", strings.NewReader("hello"))
+	req.Header.Set("X-Request-Id", "abc")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated || res.Body.String() != `{"received": "hello"}` {
+		t.Fatalf("expected the request to be passed on, got %d %s", res.Code, res.Body.String())
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping/ping", nil))
+
+	captured := capturedRequest{}
+	AssertNotErr(t, json.Unmarshal(traffic.Bytes(), &captured))
+	if captured.Method != "POST" || captured.Url != "/auth/sessions?user=1" || captured.Status != http.StatusCreated {
+		t.Errorf("unexpected request %+v", captured)
+	}
+	if captured.RequestHeaders.Get("X-Request-Id") != "abc" || captured.ResponseHeaders.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the headers to be recorded, got %v %v", captured.RequestHeaders, captured.ResponseHeaders)
+	}
+	if captured.RequestBody != "hello" || captured.ResponseBody != `{"received": "hello"}` || captured.RequestSize != 5 {
+		t.Errorf("expected the bodies to be recorded, got %+v", captured)
+	}
+	if strings.Count(traffic.String(), "\n") != 1 {
+		t.Errorf("expected the health check not to be recorded, got %s", traffic.String())
+	}
+}
+
+func TestCaptureHandlerWhenTheServiceIsDown(t *testing.T) {
+	traffic := bytes.Buffer{}
+	handler := captureHandler(captureConfig{Service: "AUTH", Target: "127.0.0.1:1"}, &traffic)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/auth", nil))
+
+	captured := capturedRequest{}
+	AssertNotErr(t, json.Unmarshal(traffic.Bytes(), &captured))
+	if res.Code != http.StatusBadGateway || captured.Error == "" {
+		t.Errorf("expected the failure to be recorded, got %d %+v", res.Code, captured)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := limitedBuffer{capture: true}
+	b.Write(bytes.Repeat([]byte("a"), captureBodyLimit-1))
+	b.Write([]byte("bcd"))
+	if b.Len() != captureBodyLimit || b.size != captureBodyLimit+2 || !strings.HasSuffix(b.String(), "ab") {
+		t.Errorf("expected the body to be cut short, got %d of %d", b.Len(), b.size)
+	}
+
+	b = limitedBuffer{}
+	b.Write([]byte("abc"))
+	if b.Len() != 0 || b.size != 3 {
+		t.Errorf("expected only the size to be kept without bodies")
+	}
+}
+
+func TestPrintTraffic(t *testing.T) {
+	tmp := t.TempDir()
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: tmp},
+		Services: Services{"AUTH": {Id: "AUTH", Binary: ServiceBinary{DestinationSubdir: "auth"}}},
+	}
+
+	out := bytes.Buffer{}
+	if err := sm.PrintTraffic("AUTH", &out); err == nil || !strings.Contains(err.Error(), "-capture AUTH") {
+		t.Errorf("expected to be told how to capture traffic, got %v", err)
+	}
+
+	AssertNotErr(t, os.MkdirAll(path.Join(tmp, "auth"), 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(tmp, "auth", trafficFile), []byte(
+		`{"time":"2024-05-01T10:15:30.25Z","method":"GET","url":"/auth/sessions","status":200,"durationMillis":12,"requestHeaders":{"Accept":["*/*"]}}`+"\n"+
+			`{"time":"2024-05-01T10:15:31Z","method":"POST","url":"/auth/login","status":502,"durationMillis":1,"error":"connection refused"}`+"\n"), 0644))
+
+	AssertNotErr(t, sm.PrintTraffic("AUTH", &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "GET     200      12ms  /auth/sessions") || !strings.Contains(lines[1], "POST    ERR") || strings.TrimSpace(lines[2]) != "connection refused" {
+		t.Errorf("unexpected traffic:\n%s", out.String())
+	}
+
+	out.Reset()
+	sm.Commands = cli.UserOption{Verbose: true}
+	AssertNotErr(t, sm.PrintTraffic("AUTH", &out))
+	if !strings.Contains(out.String(), "    > Accept: */*\n") {
+		t.Errorf("expected the headers when verbose, got:\n%s", out.String())
+	}
+}
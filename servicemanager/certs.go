@@ -0,0 +1,147 @@
+package servicemanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+// With --https the reverse proxy serves https using a certificate signed by a local certificate authority,
+// which is created in $WORKSPACE/certs the first time it's needed. Once the CA is trusted (see
+// printTrustInstructions) browsers accept the proxy's certificate, so frontends and oauth flows that
+// insist on https work locally.
+
+const (
+	certsDir       = "certs"
+	caCertFileName = "sm2-ca.pem"
+	caKeyFileName  = "sm2-ca-key.pem"
+)
+
+// a certificate for the proxy, valid for localhost and the given hosts
+func (sm *ServiceManager) proxyCertificate(hosts []string, out io.Writer) (tls.Certificate, error) {
+	dir := path.Join(sm.Config.WorkspaceDir, certsDir)
+	ca, caKey, created, err := loadOrCreateCA(dir)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to set up the local certificate authority in %s: %s", dir, err)
+	}
+	if created {
+		printTrustInstructions(path.Join(dir, caCertFileName), out)
+	}
+	return newLeafCertificate(ca, caKey, append([]string{"localhost", "127.0.0.1", "::1"}, hosts...))
+}
+
+func loadOrCreateCA(dir string) (*x509.Certificate, crypto.Signer, bool, error) {
+	certFile := path.Join(dir, caCertFileName)
+	keyFile := path.Join(dir, caKeyFileName)
+
+	if Exists(certFile) && Exists(keyFile) {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return ca, pair.PrivateKey.(crypto.Signer), false, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{Organization: []string{"sm2 development CA"}, CommonName: "sm2 CA " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, false, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return nil, nil, false, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, false, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, true, err
+}
+
+// leaf certificates are short lived and only kept in memory, a new one is made each time the proxy starts
+func newLeafCertificate(ca *x509.Certificate, caKey crypto.Signer, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{Organization: []string{"sm2 development certificate"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, 30),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.Raw}, PrivateKey: key}, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func printTrustInstructions(caFile string, out io.Writer) {
+	fmt.Fprintf(out, "Created a local certificate authority in %s\n", caFile)
+	fmt.Fprintln(out, "To stop browsers warning about the proxy's certificate, trust it once with:")
+	switch runtime.GOOS {
+	case "darwin":
+		fmt.Fprintf(out, "  sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s\n", caFile)
+	case "linux":
+		fmt.Fprintf(out, "  sudo cp %s /usr/local/share/ca-certificates/sm2-ca.crt && sudo update-ca-certificates\n", caFile)
+		fmt.Fprintln(out, "  (firefox and chrome on linux use their own stores, import it in their certificate settings)")
+	default:
+		fmt.Fprintf(out, "  import %s into your trusted root certificates\n", caFile)
+	}
+}
@@ -0,0 +1,49 @@
+package servicemanager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestProxyCertificate(t *testing.T) {
+	workspace, err := os.MkdirTemp(os.TempDir(), "test-certs*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(workspace)
+
+	sm := ServiceManager{Config: ServiceManagerConfig{WorkspaceDir: workspace}}
+	out := bytes.Buffer{}
+	cert, err := sm.proxyCertificate([]string{"account.localhost"}, &out)
+	AssertNotErr(t, err)
+	if !strings.Contains(out.String(), "Created a local certificate authority") {
+		t.Errorf("expected instructions for trusting the new CA, got %s", out.String())
+	}
+
+	// the CA is reused from then on
+	out.Reset()
+	_, err = sm.proxyCertificate(nil, &out)
+	AssertNotErr(t, err)
+	if out.Len() > 0 {
+		t.Errorf("expected the existing CA to be used without any output, got %s", out.String())
+	}
+
+	ca, _, created, err := loadOrCreateCA(workspace + "/" + certsDir)
+	AssertNotErr(t, err)
+	if created {
+		t.Errorf("expected the CA to be loaded, not created again")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	AssertNotErr(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, host := range []string{"localhost", "account.localhost", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Errorf("expected the certificate to be valid for %s: %s", host, err)
+		}
+	}
+}
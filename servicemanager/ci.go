@@ -0,0 +1,294 @@
+package servicemanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -ci-start is --start for ci jobs, like a GitHub Actions workflow:
+//
+//	- run: sm2 -ci-start MY_PROFILE -ci-output services.json
+//	  id: sm2
+//	- run: ./integration-tests.sh --auth-port ${{ steps.sm2.outputs.AUTH_port }}
+//	- run: sm2 -ci-stop
+//	  if: always()
+//
+// It never prompts or draws progress bars, printing what it's doing as one json object per line instead,
+// and doesn't finish until every service is healthy (or -wait secs have passed). The port and version of
+// each service are written to $GITHUB_OUTPUT, when it's set, and to the -ci-output file.
+//
+// The services it starts are recorded, so -ci-stop only stops those, and not anything that was already
+// running. If the job is cancelled while it's running, it stops them itself before exiting.
+
+// how long -ci-start waits for services to be healthy if there's no -wait
+const ciDefaultWait = 300
+
+// lists the services started by -ci-start that haven't been stopped by -ci-stop
+const ciStateFile = "ci-services.json"
+
+// one line of -ci-start's output
+type ciEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"` // starting, started, failed, waiting, ready, cancelled
+	Service string    `json:"service,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// what's written to the -ci-output file (and as json to $GITHUB_OUTPUT) for each service
+type ciService struct {
+	Version string `json:"version"`
+	Port    int    `json:"port"`
+}
+
+type ciRun struct {
+	sm      *ServiceManager
+	out     io.Writer
+	lock    sync.Mutex
+	started []string
+}
+
+func (run *ciRun) event(event ciEvent) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	event.Time = time.Now().UTC()
+	json.NewEncoder(run.out).Encode(event)
+}
+
+// records a service before it's started, so it's stopped even if sm2 is killed part way through starting it
+func (run *ciRun) record(service string) error {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	for _, s := range run.started {
+		if s == service {
+			return nil
+		}
+	}
+	run.started = append(run.started, service)
+	return saveCiServices(run.sm.Config.TmpDir, run.started)
+}
+
+// CiStart starts the services and profiles given, waits for them to be healthy and writes out their ports and
+// versions. It stops what it started if ctx is cancelled.
+func (sm *ServiceManager) CiStart(ctx context.Context, out io.Writer) error {
+	services := sm.requestedServicesAndProfiles()
+	if len(services) == 0 {
+		return fmt.Errorf("no services or profiles to start, e.g. sm2 -ci-start MY_PROFILE")
+	}
+	for _, sv := range services {
+		if _, ok := sm.Services[sv.service]; !ok {
+			return fmt.Errorf("%s is not a service or a profile", sv.service)
+		}
+	}
+
+	started, err := loadCiServices(sm.Config.TmpDir)
+	if err != nil {
+		return err
+	}
+	run := &ciRun{sm: sm, out: out, started: started}
+	sm.progress.noProgress = true
+	sm.planPorts(services, nil, io.Discard)
+
+	done := make(chan error, 1)
+	go func() { done <- run.startAll(services) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return run.cancel()
+	}
+
+	wait := sm.Commands.Wait
+	if wait <= 0 {
+		wait = ciDefaultWait
+	}
+	run.event(ciEvent{Event: "waiting", Message: fmt.Sprintf("waiting up to %d secs for the services to be healthy", wait)})
+	awaitCtx, cancel := context.WithTimeout(ctx, time.Duration(wait)*time.Second)
+	defer cancel()
+	ids := []string{}
+	for _, sv := range services {
+		ids = append(ids, sv.service)
+	}
+	if err := sm.AwaitHealthy(awaitCtx, ids...); err != nil {
+		if ctx.Err() != nil {
+			return run.cancel()
+		}
+		run.event(ciEvent{Event: "failed", Message: err.Error()})
+		return err
+	}
+
+	running := map[string]ciService{}
+	for _, status := range sm.findStatuses() {
+		for _, id := range ids {
+			if status.service == id {
+				running[id] = ciService{Version: status.version, Port: status.port}
+			}
+		}
+	}
+	if file := os.Getenv("GITHUB_OUTPUT"); file != "" {
+		if err := writeGithubOutput(file, running); err != nil {
+			return fmt.Errorf("unable to write to $GITHUB_OUTPUT: %s", err)
+		}
+	}
+	if sm.Commands.CiOutput != "" {
+		if err := writeCiOutput(sm.Commands.CiOutput, running); err != nil {
+			return fmt.Errorf("unable to write %s: %s", sm.Commands.CiOutput, err)
+		}
+	}
+	run.event(ciEvent{Event: "ready", Message: fmt.Sprintf("%d services are healthy", len(running))})
+	return nil
+}
+
+// starts the services on --workers workers, like --start
+func (run *ciRun) startAll(services []ServiceAndVersion) error {
+	defer run.sm.resolveLatestVersions(services)()
+
+	tasks := make(chan ServiceAndVersion, len(services))
+	for _, sv := range services {
+		tasks <- sv
+	}
+	close(tasks)
+
+	failed := []string{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < run.sm.Commands.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sv := range tasks {
+				if err := run.start(sv); err != nil {
+					lock.Lock()
+					failed = append(failed, sv.service)
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to start %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (run *ciRun) start(sv ServiceAndVersion) error {
+	if service, ok := run.sm.Services[sv.service]; ok && run.sm.isAlreadyRunning(service) {
+		run.event(ciEvent{Event: "started", Service: sv.service, Message: "already running"})
+		return nil
+	}
+	if err := run.record(sv.service); err != nil {
+		run.event(ciEvent{Event: "failed", Service: sv.service, Message: err.Error()})
+		return err
+	}
+
+	run.event(ciEvent{Event: "starting", Service: sv.service})
+	if err := run.sm.StartService(sv); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+		run.event(ciEvent{Event: "failed", Service: sv.service, Message: err.Error()})
+		return err
+	}
+	run.event(ciEvent{Event: "started", Service: sv.service})
+	return nil
+}
+
+// stops everything that was started when the job's cancelled
+func (run *ciRun) cancel() error {
+	run.event(ciEvent{Event: "cancelled", Message: "stopping the services that were started"})
+	if err := run.sm.CiStop(run.out); err != nil {
+		return err
+	}
+	return fmt.Errorf("cancelled")
+}
+
+// CiStop stops the services started by -ci-start, it's fine to run it when nothing was started
+func (sm *ServiceManager) CiStop(out io.Writer) error {
+	started, err := loadCiServices(sm.Config.TmpDir)
+	if err != nil {
+		return err
+	}
+	if len(started) == 0 {
+		fmt.Fprintln(out, "No services were started by -ci-start")
+		return nil
+	}
+
+	running := map[string]serviceStatus{}
+	for _, status := range sm.findStatuses() {
+		running[status.service] = status
+	}
+	for _, service := range started {
+		if status, ok := running[service]; ok {
+			sm.stop(status)
+		}
+	}
+	return os.Remove(path.Join(sm.Config.TmpDir, ciStateFile))
+}
+
+func loadCiServices(tmpDir string) ([]string, error) {
+	data, err := os.ReadFile(path.Join(tmpDir, ciStateFile))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	started := []string{}
+	if err := json.Unmarshal(data, &started); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", ciStateFile, err)
+	}
+	return started, nil
+}
+
+func saveCiServices(tmpDir string, started []string) error {
+	data, err := json.Marshal(started)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(tmpDir, ciStateFile), data, 0644)
+}
+
+// adds SERVICE_port and SERVICE_version step outputs for each service, and services as json
+func writeGithubOutput(file string, services map[string]ciService) error {
+	ids := []string{}
+	for id := range services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	outputs := strings.Builder{}
+	for _, id := range ids {
+		fmt.Fprintf(&outputs, "%s_port=%d\n", id, services[id].Port)
+		fmt.Fprintf(&outputs, "%s_version=%s\n", id, services[id].Version)
+	}
+	all, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&outputs, "services=%s\n", all)
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(outputs.String())
+	return err
+}
+
+func writeCiOutput(file string, services map[string]ciService) error {
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,68 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestWriteGithubOutput(t *testing.T) {
+	file := path.Join(t.TempDir(), "github_output")
+	AssertNotErr(t, os.WriteFile(file, []byte("earlier=step\n"), 0644))
+
+	services := map[string]ciService{
+		"EMAIL": {Version: "2.0.0", Port: 8300},
+		"AUTH":  {Version: "1.0.0", Port: 8500},
+	}
+	AssertNotErr(t, writeGithubOutput(file, services))
+
+	data, err := os.ReadFile(file)
+	AssertNotErr(t, err)
+	expected := "earlier=step\n" +
+		"AUTH_port=8500\nAUTH_version=1.0.0\n" +
+		"EMAIL_port=8300\nEMAIL_version=2.0.0\n" +
+		`services={"AUTH":{"version":"1.0.0","port":8500},"EMAIL":{"version":"2.0.0","port":8300}}` + "\n"
+	if string(data) != expected {
+		t.Errorf("unexpected outputs:\n%s", data)
+	}
+}
+
+func TestCiStop(t *testing.T) {
+	tmp := t.TempDir()
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{TmpDir: tmp},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{}, nil
+		}},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{} },
+			Uptime:    func() time.Time { return time.Now().Add(-time.Hour) },
+		},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.CiStop(&out))
+	if out.String() != "No services were started by -ci-start\n" {
+		t.Errorf("expected nothing to stop, got %q", out.String())
+	}
+
+	run := ciRun{sm: &sm}
+	AssertNotErr(t, run.record("AUTH"))
+	AssertNotErr(t, run.record("AUTH"))
+	started, err := loadCiServices(tmp)
+	AssertNotErr(t, err)
+	if len(started) != 1 || started[0] != "AUTH" {
+		t.Errorf("expected AUTH to be recorded once, got %v", started)
+	}
+
+	AssertNotErr(t, sm.CiStop(&out))
+	if Exists(path.Join(tmp, ciStateFile)) {
+		t.Errorf("expected %s to be removed", ciStateFile)
+	}
+}
@@ -1,10 +1,13 @@
 package servicemanager
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"regexp"
+	"os/signal"
+	"sm2/cli"
 	"sm2/version"
+	"syscall"
 )
 
 type ServiceAndVersion struct {
@@ -13,8 +16,6 @@ type ServiceAndVersion struct {
 	scalaVersion string
 }
 
-var serviceAndVersionRegex *regexp.Regexp = regexp.MustCompile(`(.*?)(_(2\.\d{2}|3))?(:(.*))?$`)
-
 func parseServiceAndVersion(serviceDescriptor string) ServiceAndVersion {
 	matches := serviceAndVersionRegex.FindStringSubmatch(serviceDescriptor)
 
@@ -32,8 +33,13 @@ func (sm *ServiceManager) Run() {
 
 	var err error
 
-	if sm.Commands.UpdateConfig {
-		err := updateConfig(sm.Config.ConfigDir)
+	if sm.Commands.UpdateConfig || sm.Commands.PinConfig != "" {
+		var err error
+		if sm.Commands.PinConfig != "" {
+			err = pinConfig(sm.Config.ConfigDir, sm.Commands.PinConfig)
+		} else {
+			err = updateConfig(sm.Config.ConfigDir)
+		}
 		if err != nil {
 			fmt.Println(err)
 			fmt.Println("Continuing with current config...")
@@ -43,24 +49,63 @@ func (sm *ServiceManager) Run() {
 			fmt.Print(err)
 			os.Exit(1)
 		}
+		// warn about typos etc in the new config that would otherwise be silently ignored. too slow to do on every command
+		if problems, err := sm.findConfigProblems(); err == nil && len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: found %d problem(s) in %s, run `sm2 --validate-config` for details.\n", len(problems), sm.Config.ConfigDir)
+		}
 	}
 
-	if sm.Commands.Status || sm.Commands.StatusShort {
+	if (sm.Commands.Status || sm.Commands.StatusShort) && sm.events != nil {
+		// a status event for each running service
+		sm.emitStatuses(sm.findStatuses())
+	} else if (sm.Commands.Status || sm.Commands.StatusShort) && sm.Commands.Watch {
+		// keeps redrawing the table of running services
+		sm.WatchStatus()
+	} else if sm.Commands.Ui {
+		// full screen view of the services, see tui.go
+		err = sm.RunTui()
+	} else if sm.Commands.Status || sm.Commands.StatusShort {
 		// prints table of running services
 		sm.PrintStatus()
+	} else if sm.Commands.DiskUsage {
+		// shows how much disk each installed service uses
+		err = sm.PrintDiskUsage()
+	} else if sm.Commands.Dedupe {
+		// links identical jars in the installed services together
+		err = sm.DedupeInstalls()
 	} else if sm.Commands.Prune {
-		// cleans up state files for services with a status of FAIL
+		// cleans up state files for services with a status of FAIL, and installs that aren't in the config
 		sm.cleanupFailedServices()
+		sm.pruneOrphanedInstalls()
 	} else if sm.Commands.Start {
 		// starts service(s) or profile(s)
 		services := sm.requestedServicesAndProfiles()
 		sm.asyncStart(services)
+		sm.exitIfServicesFailed()
 	} else if sm.Commands.Stop {
 		// stops a specific service or profile
 		services := sm.requestedServicesAndProfiles()
 		for _, s := range services {
 			err = sm.StopService(s.service)
 		}
+	} else if sm.Commands.CiStart {
+		// starts services for a ci job, stopping them again if the job is cancelled
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := sm.CiStart(ctx, os.Stdout)
+		stop()
+		if err != nil {
+			fmt.Println(err)
+			if ctx.Err() != nil {
+				os.Exit(ExitInterrupted)
+			}
+			os.Exit(1)
+		}
+	} else if sm.Commands.CiStop {
+		// tears down what --ci-start started
+		if err := sm.CiStop(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	} else if sm.Commands.StopAll {
 		// stops all managed services
 		sm.StopAll()
@@ -73,6 +118,7 @@ func (sm *ServiceManager) Run() {
 		}
 
 		sm.asyncStart(services)
+		sm.exitIfServicesFailed()
 	} else if sm.Commands.Restart {
 		// restarts service(s) or profile(s)
 		services := sm.requestedServicesAndProfiles()
@@ -80,11 +126,14 @@ func (sm *ServiceManager) Run() {
 		for _, s := range services {
 			if err := sm.Restart(s); err != nil {
 				failed = append(failed, s)
+			} else {
+				sm.emit(machineEvent{Event: "restarted", Service: s.service})
 			}
 		}
 		// try and start the failed services (which are probably just not running)
 		if len(failed) > 0 {
 			sm.asyncStart(failed)
+			sm.exitIfServicesFailed()
 		}
 	} else if sm.Commands.Ports {
 		// prints all port numbers to stdout
@@ -100,18 +149,135 @@ func (sm *ServiceManager) Run() {
 	} else if sm.Commands.Logs != "" {
 		// dumps stdout.log to stdout
 		sm.PrintLogsForService(sm.Commands.Logs)
+	} else if sm.Commands.Open != "" {
+		// saves looking up which port a service is on
+		if err := sm.OpenService(sm.Commands.Open); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.BuildAndRun != "" {
+		// the edit, build, restart loop for a service checked out locally
+		if err := sm.BuildAndRun(sm.Commands.BuildAndRun, sm.Commands.Repo); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.Traffic != "" {
+		// what's been sent to a service started with --capture
+		if err := sm.PrintTraffic(sm.Commands.Traffic, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	} else if sm.Commands.ReverseProxy {
 		// starts a reverse proxy for frontend services
 		sm.StartProxy()
+	} else if sm.Commands.ApiIndex {
+		// one place to find the apis of every running service
+		sm.ServeApiIndex()
+	} else if sm.Commands.ExportCompose != "" {
+		// hands an environment to someone without sm2
+		if err := sm.ExportCompose(sm.Commands.ExportCompose, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportPorts != "" {
+		// prints the ports a devcontainer needs to forward
+		if err := sm.ExportPorts(sm.Commands.ExportPorts, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportProxy != "" {
+		// prints config for an existing nginx or caddy to route to the running services
+		if err := sm.ExportProxyConfig(sm.Commands.ExportProxy, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportLaunchd != "" {
+		// keeps a profile warm on macOS
+		if err := sm.ExportLaunchd(sm.Commands.ExportLaunchd, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportSystemd != "" {
+		// keeps a service running across reboots
+		if err := sm.ExportSystemd(sm.Commands.ExportSystemd, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportIntellij {
+		// lets intellij start, stop and debug services
+		if err := sm.ExportIntellij(".run", os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ExportVscode {
+		// lets vs code start, stop and debug services
+		if err := sm.ExportVscode(".vscode", os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.HostsSync {
+		// keeps /etc/hosts in step with the proxyHosts in the config
+		if err := sm.SyncHosts(hostsFile, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	} else if sm.Commands.Offline {
 		// used by itself, offline will list available services
 		sm.ListServicesAvailableOffline()
 	} else if sm.Commands.Diagnostic {
 		// checks if system can run sm2
-		RunDiagnostics(sm.Config)
+		RunDiagnostics(sm.Config, sm.proxy, sm.wsl())
 	} else if sm.Commands.Debug != "" {
 		// `--debug SERVICE` dumps as much info as it can find about the service
 		sm.showDebug(sm.Commands.Debug)
+	} else if sm.Commands.WhyFailed != "" {
+		// `--why-failed SERVICE` one screen summary of how a service was started and how it died
+		sm.showWhyFailed(sm.Commands.WhyFailed)
+	} else if sm.Commands.Daemon {
+		// runs until interrupted, recording the health of running services
+		sm.RunDaemon()
+	} else if sm.Commands.HealthReport {
+		// summary of the health checks recorded by the daemon
+		sm.PrintHealthReport()
+	} else if sm.Commands.ValidateConfig {
+		// reports mistakes in services.json and profiles.json
+		if !sm.ValidateConfig(os.Stdout) {
+			os.Exit(1)
+		}
+	} else if sm.Commands.LintConfig {
+		// reports clashing ports, names and artifacts and broken profiles
+		if !sm.LintConfig(os.Stdout) {
+			os.Exit(1)
+		}
+	} else if sm.Commands.AddService != "" {
+		// creates a new service entry in services-overrides.json
+		if err := sm.AddService(sm.Commands.AddService, sm.Commands.ExtraServices); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ConfigGet != "" {
+		// prints a value from ~/.sm2/config
+		value, err := cli.GetDefault(cli.DefaultsFile(), sm.Commands.ConfigGet)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	} else if sm.Commands.ConfigSet != "" {
+		// validates and writes a value to ~/.sm2/config
+		if err := sm.setDefault(sm.Commands.ConfigSet); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.SetSecret != "" {
+		// encrypts a secret into $WORKSPACE/secrets.enc for use as ${secret:KEY} in args
+		if err := sm.SetSecret(sm.Commands.SetSecret); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if sm.Commands.ConfigDiff {
+		// shows what overrides & overlays have changed
+		sm.PrintConfigDiff()
 	} else if sm.Commands.Version {
 		// show version and build
 		version.PrintVersion()
@@ -122,14 +288,22 @@ func (sm *ServiceManager) Run() {
 			os.Exit(13)
 		}
 	} else if sm.Commands.Update {
-		err = update(sm.Config.TmpDir)
+		channel := ""
+		if len(sm.Commands.ExtraServices) > 0 {
+			channel = sm.Commands.ExtraServices[0]
+		}
+		err = sm.update(channel)
 	} else if sm.Commands.GenerateAutoComplete {
-		GenerateAutoCompletionScript()
+		var shell string
+		if len(sm.Commands.ExtraServices) > 0 {
+			shell = sm.Commands.ExtraServices[0]
+		}
+		err = GenerateAutoCompletionScript(shell)
 	} else if sm.Commands.AutoComplete {
 		fmt.Println(sm.GenerateAutocompleteResponse())
 	} else {
 		// show help if they're not using --update-config with another command
-		if !sm.Commands.UpdateConfig {
+		if !sm.Commands.UpdateConfig && sm.Commands.PinConfig == "" {
 			fmt.Print(helptext)
 		}
 	}
@@ -138,6 +312,9 @@ func (sm *ServiceManager) Run() {
 		fmt.Println(err)
 	}
 
+	if err := sm.tracer.Flush(); err != nil {
+		sm.PrintVerbose("failed to export traces: %s\n", err)
+	}
 }
 
 // get a list of service names to use in the command.
@@ -147,15 +324,16 @@ func (sm *ServiceManager) requestedServicesAndProfiles() []ServiceAndVersion {
 	output := []ServiceAndVersion{}
 
 	for i, s := range sm.Commands.ExtraServices {
-		if profileServices, ok := sm.Profiles[s]; ok {
-			for _, ps := range profileServices {
-				output = append(output, ServiceAndVersion{ps, "", ""})
+		if _, ok := sm.Profiles[s]; ok {
+			for _, ps := range sm.expandProfile(s) {
+				output = append(output, ServiceAndVersion{sm.renamedService(ps), "", ""})
 			}
 		} else {
 			serviceAndVersion := parseServiceAndVersion(s)
 			if i == 0 && sm.Commands.Release != "" {
 				serviceAndVersion.version = sm.Commands.Release
 			}
+			serviceAndVersion.service = sm.renamedService(serviceAndVersion.service)
 			output = append(output, serviceAndVersion)
 		}
 	}
@@ -0,0 +1,168 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Writes a docker-compose file for the services and profiles given on the command line, e.g.
+//
+//	sm2 -export-compose docker-compose.yml MY_PROFILE
+//
+// so an environment can be handed to someone without sm2. Only services with a docker image can be
+// exported, the rest are listed in a comment at the top of the file. Secrets become compose variables,
+// so ${secret:API_KEY} is ${API_KEY}, to be set in the environment or a .env file. Use - to print to stdout.
+
+type composeService struct {
+	name          string
+	image         string
+	port          int
+	containerPort int
+	env           map[string]string
+	command       []string
+	healthcheck   string
+}
+
+func (sm *ServiceManager) ExportCompose(file string, out io.Writer) error {
+	requested := sm.requestedServicesAndProfiles()
+	if len(requested) == 0 {
+		return fmt.Errorf("name the services or profiles to export, e.g. sm2 -export-compose docker-compose.yml MY_PROFILE")
+	}
+
+	services := []composeService{}
+	leftOut := []string{}
+	seen := map[string]bool{}
+	for _, sv := range requested {
+		service, ok := sm.Services[sv.service]
+		if !ok {
+			return fmt.Errorf("%s is not a service or a profile", sv.service)
+		}
+		if seen[sv.service] {
+			continue
+		}
+		seen[sv.service] = true
+
+		if service.Docker.Image == "" {
+			leftOut = append(leftOut, sv.service)
+			continue
+		}
+		services = append(services, composeServiceFor(service, sv.version))
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("none of %s have a docker image to export", strings.Join(sm.Commands.ExtraServices, ", "))
+	}
+
+	if file == "-" {
+		return writeCompose(services, leftOut, out)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeCompose(services, leftOut, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %d service(s) to %s\n", len(services), file)
+	if len(leftOut) > 0 {
+		fmt.Fprintf(out, "Left out %s, they have no docker image\n", strings.Join(leftOut, ", "))
+	}
+	return nil
+}
+
+func composeServiceFor(service Service, version string) composeService {
+	containerPort := service.Docker.ContainerPort
+	if containerPort == 0 {
+		containerPort = service.DefaultPort
+	}
+
+	env := map[string]string{}
+	for name, value := range service.Docker.Env {
+		env[name] = composeVariables(value)
+	}
+	command := []string{}
+	for _, arg := range fillPortArgs(service.Docker.Args, service.DefaultPort) {
+		command = append(command, composeVariables(arg))
+	}
+
+	// probed from inside the container, so it's on the container's port
+	healthcheck := ""
+	if containerPort > 0 {
+		if u, err := url.Parse(findHealthcheckUrl(service, containerPort)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			u.Host = fmt.Sprintf("localhost:%d", containerPort)
+			healthcheck = u.String()
+		}
+	}
+
+	return composeService{
+		name:          strings.ToLower(service.Id),
+		image:         dockerImage(service, version),
+		port:          service.DefaultPort,
+		containerPort: containerPort,
+		env:           env,
+		command:       command,
+		healthcheck:   healthcheck,
+	}
+}
+
+// secrets are looked up by sm2, which whoever runs the compose file won't have
+func composeVariables(value string) string {
+	return secretPlaceholder.ReplaceAllString(value, "$${${1}}")
+}
+
+// json strings are valid yaml, which saves working out what needs quoting
+func writeCompose(services []composeService, leftOut []string, out io.Writer) error {
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].name < services[j].name
+	})
+	quote := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+	list := func(items []string) string {
+		data, _ := json.Marshal(items)
+		return strings.ReplaceAll(string(data), `","`, `", "`)
+	}
+
+	b := strings.Builder{}
+	b.WriteString("# generated by sm2 -export-compose\n")
+	if len(leftOut) > 0 {
+		fmt.Fprintf(&b, "# left out, they have no docker image: %s\n", strings.Join(leftOut, ", "))
+	}
+	b.WriteString("services:\n")
+	for _, s := range services {
+		fmt.Fprintf(&b, "  %s:\n", s.name)
+		fmt.Fprintf(&b, "    image: %s\n", quote(s.image))
+		if s.port > 0 && s.containerPort > 0 {
+			fmt.Fprintf(&b, "    ports:\n      - %s\n", quote(fmt.Sprintf("%d:%d", s.port, s.containerPort)))
+		}
+		if len(s.env) > 0 {
+			b.WriteString("    environment:\n")
+			names := []string{}
+			for name := range s.env {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(&b, "      %s: %s\n", name, quote(s.env[name]))
+			}
+		}
+		if len(s.command) > 0 {
+			fmt.Fprintf(&b, "    command: %s\n", list(s.command))
+		}
+		if s.healthcheck != "" {
+			// not every image has curl, so wget is tried too
+			test := fmt.Sprintf("curl -fsS %[1]s || wget -qO- %[1]s || exit 1", s.healthcheck)
+			fmt.Fprintf(&b, "    healthcheck:\n      test: %s\n      interval: 10s\n      timeout: 5s\n      retries: 12\n", list([]string{"CMD-SHELL", test}))
+		}
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
@@ -0,0 +1,86 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestExportCompose(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{
+			"PAYMENTS": {
+				Id:          "PAYMENTS",
+				DefaultPort: 9050,
+				Healthcheck: Healthcheck{Url: "http://localhost:${port}/health"},
+				Docker: Docker{
+					Image:         "ghcr.io/example/payments:1.4.0",
+					ContainerPort: 9000,
+					Env:           map[string]string{"MODE": "dev", "API_KEY": "${secret:PAYMENTS_KEY}"},
+					Args:          []string{"-Dhttp.port=${port}"},
+				},
+			},
+			"EMAIL": {Id: "EMAIL", DefaultPort: 9060, Docker: Docker{Image: "email"}},
+			"AUTH":  {Id: "AUTH", DefaultPort: 9070},
+		},
+		Profiles: Profiles{"WEB": {"PAYMENTS", "EMAIL", "AUTH"}},
+		Commands: cli.UserOption{ExtraServices: []string{"WEB", "PAYMENTS:1.5.0"}},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportCompose("-", &out))
+	expected := `# generated by sm2 -export-compose
+# left out, they have no docker image: AUTH
+services:
+  email:
+    image: "email:latest"
+    ports:
+      - "9060:9060"
+    healthcheck:
+      test: ["CMD-SHELL", "curl -fsS http://localhost:9060/ping/ping || wget -qO- http://localhost:9060/ping/ping || exit 1"]
+      interval: 10s
+      timeout: 5s
+      retries: 12
+  payments:
+    image: "ghcr.io/example/payments:1.4.0"
+    ports:
+      - "9050:9000"
+    environment:
+      API_KEY: "${PAYMENTS_KEY}"
+      MODE: "dev"
+    command: ["-Dhttp.port=9050"]
+    healthcheck:
+      test: ["CMD-SHELL", "curl -fsS http://localhost:9000/health || wget -qO- http://localhost:9000/health || exit 1"]
+      interval: 10s
+      timeout: 5s
+      retries: 12
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	// written to a file, with the version from the command line
+	dir := t.TempDir()
+	file := path.Join(dir, "docker-compose.yml")
+	sm.Commands.ExtraServices = []string{"PAYMENTS:1.5.0", "AUTH"}
+	out.Reset()
+	AssertNotErr(t, sm.ExportCompose(file, &out))
+	data, err := os.ReadFile(file)
+	AssertNotErr(t, err)
+	if !strings.Contains(string(data), `image: "ghcr.io/example/payments:1.5.0"`) {
+		t.Errorf("expected the requested tag, got %s", data)
+	}
+	if out.String() != "Wrote 1 service(s) to "+file+"\nLeft out AUTH, they have no docker image\n" {
+		t.Errorf("unexpected output %s", out.String())
+	}
+
+	sm.Commands.ExtraServices = []string{"AUTH"}
+	if err := sm.ExportCompose("-", &out); err == nil {
+		t.Errorf("expected an error when nothing can be exported")
+	}
+}
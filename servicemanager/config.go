@@ -1,9 +1,12 @@
 package servicemanager
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
 )
 
 type ArtifactoryUrls struct {
@@ -20,17 +23,28 @@ var DefaultArtifactoryUrls = ArtifactoryUrls{
 type Services map[string]Service
 type Profiles map[string][]string
 
-func loadServicesFromFile(serviceFile string) (*Services, error) {
+// loads services.json, with any override files merged over the top of it and any templates and platform specific values expanded
+func loadServicesFromFile(serviceFile string, templates Templates, overrideFiles ...string) (*Services, error) {
 	services := make(Services, 1600)
 
-	file, err := os.Open(serviceFile)
+	data, err := readConfigFile(serviceFile)
 	if err != nil {
 		return nil, err
 	}
 
-	defer file.Close()
+	data, err = mergeOverrides(data, overrideFiles)
+	if err != nil {
+		return nil, err
+	}
 
-	err = json.NewDecoder(file).Decode(&services)
+	// only worth re-encoding everything if there's something to expand
+	if len(templates) > 0 || bytes.Contains(data, []byte(`"platforms"`)) || bytes.Contains(data, []byte(`"vars"`)) || bytes.Contains(data, []byte("${vars.")) {
+		if data, err = expandServices(data, templates, currentPlatforms()); err != nil {
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(data, &services)
 	if err != nil {
 		return nil, err
 	}
@@ -46,20 +60,186 @@ func loadServicesFromFile(serviceFile string) (*Services, error) {
 
 // @speed do we need to cache the whole thing? we only ever look up 1 profile
 //  maybe just load, find the profile and discard the rest?
-func loadProfilesFromFile(profileFileName string) (*Profiles, error) {
+func loadProfilesFromFile(profileFileName string, includeFiles ...string) (*Profiles, error) {
 	profiles := make(Profiles, 600)
 
-	file, err := os.Open(profileFileName)
+	data, err := readConfigFile(profileFileName)
 	if err != nil {
 		return nil, err
 	}
 
-	defer file.Close()
+	data, err = mergeOverrides(data, includeFiles)
+	if err != nil {
+		return nil, err
+	}
 
-	err = json.NewDecoder(file).Decode(&profiles)
+	err = json.Unmarshal(data, &profiles)
 	return &profiles, err
 }
 
+// Reads the list of other config dirs to include from config.json, e.g.
+//
+//	"include": ["teams/payments", "/home/me/my-team-config"]
+//
+// Relative paths are relative to the config dir. Each included dir can have its own services.json
+// and profiles.json, which are merged over the main ones in order, so later dirs win.
+func loadIncludes(configFileName string, configDir string) ([]string, error) {
+	type includeConfig struct {
+		Include []string `json:"include"`
+	}
+
+	data, err := os.ReadFile(configFileName)
+	if err != nil {
+		// no config.json means nothing to include
+		return []string{}, nil
+	}
+
+	config := includeConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, dir := range config.Include {
+		if !path.IsAbs(dir) {
+			dir = path.Join(configDir, dir)
+		}
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			return nil, fmt.Errorf("included config dir %s does not exist", dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+var scalaVersionPattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+type scalaConfig struct {
+	ScalaVersions []string `json:"scalaVersions"`
+	ScalaSuffixes []string `json:"scalaSuffixes"`
+}
+
+func readScalaConfig(configFileName string) (scalaConfig, error) {
+	config := scalaConfig{}
+	data, err := os.ReadFile(configFileName)
+	if err != nil {
+		return config, nil
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// loads the order to try scala versions in from config.json, e.g. "scalaVersions": ["3", "2.13"].
+// returns nil if it isn't set, so the defaults are used
+func loadScalaVersions(configFileName string) ([]string, error) {
+	config, err := readScalaConfig(configFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range config.ScalaVersions {
+		if !scalaVersionPattern.MatchString(v) {
+			return nil, fmt.Errorf("%s is not a scala version, expected something like 3 or 2.13", v)
+		}
+	}
+	return config.ScalaVersions, nil
+}
+
+var scalaSuffixPattern = regexp.MustCompile(`^\d+(\.(\d+|\*))?$`)
+
+// loads the scala versions artifacts can end in from config.json, e.g. "scalaSuffixes": ["3", "2.*"].
+// returns nil if it isn't set, so the defaults are used
+func loadScalaSuffixes(configFileName string) ([]string, error) {
+	config, err := readScalaConfig(configFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range config.ScalaSuffixes {
+		if !scalaSuffixPattern.MatchString(s) {
+			return nil, fmt.Errorf("%s is not a scala version suffix, expected something like 3, 2.13 or 2.*", s)
+		}
+	}
+	return config.ScalaSuffixes, nil
+}
+
+// returns the copies of a config file that exist in the included dirs, in order
+func includedFiles(includeDirs []string, name string) ([]string, error) {
+	files := []string{}
+	for _, dir := range includeDirs {
+		file, err := findConfigFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if Exists(file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// returns the main copy of a config file and the copies in any included dirs
+func (sm *ServiceManager) configSources(name string) (string, []string, error) {
+	main, err := findConfigFile(sm.Config.ConfigDir, name)
+	if err != nil {
+		return "", nil, err
+	}
+	includes, err := includedFiles(sm.Config.IncludeDirs, name)
+	return main, includes, err
+}
+
+// Reads a config file that doesn't have to exist (like overlays.json), merged with any copies in the
+// included dirs. Returns an empty object if there aren't any.
+func (sm *ServiceManager) readOptionalConfig(name string) ([]byte, error) {
+	main, includes, err := sm.configSources(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte("{}")
+	if Exists(main) {
+		if data, err = readConfigFile(main); err != nil {
+			return nil, fmt.Errorf("%s: %s", main, err)
+		}
+	}
+	return mergeOverrides(data, includes)
+}
+
+// Config files can be written in json or yaml, e.g. services.json or services.yaml.
+// Returns whichever exists, or the json one if neither do.
+func findConfigFile(dir string, name string) (string, error) {
+	found := []string{}
+	for _, ext := range []string{".json", ".yaml", ".yml"} {
+		if file := path.Join(dir, name+ext); Exists(file) {
+			found = append(found, file)
+		}
+	}
+
+	if len(found) > 1 {
+		return "", fmt.Errorf("found both %s and %s, only one of them should exist", found[0], found[1])
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	return path.Join(dir, name+".json"), nil
+}
+
+// reads a config file, converting it to json if its yaml
+func readConfigFile(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return configToJson(file, data)
+}
+
+func configToJson(file string, data []byte) ([]byte, error) {
+	if !isYamlFile(file) {
+		return data, nil
+	}
+	return yamlToJson(data)
+}
+
 // loads config.json which contains repo urls etc
 func loadRepoConfig(configFileName string) (ArtifactoryUrls, error) {
 
@@ -0,0 +1,105 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestIncludedConfigIsMergedInOrder(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-includes*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	os.MkdirAll(path.Join(configDir, "teams", "payments"), 0755)
+	os.MkdirAll(path.Join(configDir, "teams", "core"), 0755)
+
+	os.WriteFile(path.Join(configDir, "config.json"), []byte(`{"include": ["teams/payments", "teams/core"]}`), 0644)
+	os.WriteFile(path.Join(configDir, "services.json"), []byte(`{"FOO": {"name": "foo", "defaultPort": 8000}}`), 0644)
+	os.WriteFile(path.Join(configDir, "profiles.json"), []byte(`{"ALL": ["FOO"]}`), 0644)
+	os.WriteFile(path.Join(configDir, "teams", "payments", "services.json"), []byte(`{"PAY": {"name": "pay", "defaultPort": 8100}, "FOO": {"defaultPort": 8001}}`), 0644)
+	os.WriteFile(path.Join(configDir, "teams", "payments", "profiles.json"), []byte(`{"PAYMENTS": ["PAY"], "ALL": ["FOO", "PAY"]}`), 0644)
+	os.WriteFile(path.Join(configDir, "teams", "core", "services.json"), []byte(`{"FOO": {"defaultPort": 8002}}`), 0644)
+
+	includes, err := loadIncludes(path.Join(configDir, "config.json"), configDir)
+	AssertNotErr(t, err)
+
+	if len(includes) != 2 || includes[0] != path.Join(configDir, "teams", "payments") {
+		t.Fatalf("expected includes to be resolved relative to the config dir, got %v", includes)
+	}
+
+	serviceIncludes, err := includedFiles(includes, "services")
+	AssertNotErr(t, err)
+	services, err := loadServicesFromFile(path.Join(configDir, "services.json"), nil, serviceIncludes...)
+	AssertNotErr(t, err)
+
+	if (*services)["FOO"].DefaultPort != 8002 {
+		t.Errorf("expected the last include to win, got port %d", (*services)["FOO"].DefaultPort)
+	}
+	if (*services)["PAY"].Name != "pay" {
+		t.Errorf("expected PAY to be included")
+	}
+
+	profileIncludes, err := includedFiles(includes, "profiles")
+	AssertNotErr(t, err)
+	profiles, err := loadProfilesFromFile(path.Join(configDir, "profiles.json"), profileIncludes...)
+	AssertNotErr(t, err)
+
+	if !reflect.DeepEqual((*profiles)["ALL"], []string{"FOO", "PAY"}) {
+		t.Errorf("expected ALL to be replaced by the include, got %v", (*profiles)["ALL"])
+	}
+	if !reflect.DeepEqual((*profiles)["PAYMENTS"], []string{"PAY"}) {
+		t.Errorf("expected PAYMENTS to be included, got %v", (*profiles)["PAYMENTS"])
+	}
+}
+
+func TestMissingIncludeIsAnError(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-includes*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	os.WriteFile(path.Join(configDir, "config.json"), []byte(`{"include": ["does-not-exist"]}`), 0644)
+
+	if _, err := loadIncludes(path.Join(configDir, "config.json"), configDir); err == nil {
+		t.Errorf("expected an error for a missing include")
+	}
+}
+
+func TestInvalidConfigJsonIsAnError(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "config.json")
+	AssertNotErr(t, os.WriteFile(configFile, []byte(`{"include": [`), 0644))
+
+	if _, err := loadRepoConfig(configFile); err == nil {
+		t.Errorf("expected an error for the artifactory urls in a config.json that isn't valid")
+	}
+	if _, err := loadIncludes(configFile, path.Dir(configFile)); err == nil {
+		t.Errorf("expected an error for the includes in a config.json that isn't valid")
+	}
+}
+
+func TestLoadScalaVersions(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-scala-versions*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+	configFile := path.Join(configDir, "config.json")
+
+	if versions, err := loadScalaVersions(configFile); err != nil || versions != nil {
+		t.Errorf("expected no versions without a config.json, got %v %v", versions, err)
+	}
+
+	os.WriteFile(configFile, []byte(`{"scalaVersions": ["2.12", "3"]}`), 0644)
+	versions, err := loadScalaVersions(configFile)
+	AssertNotErr(t, err)
+	if strings.Join(versions, ",") != "2.12,3" {
+		t.Errorf("expected 2.12,3 got %v", versions)
+	}
+
+	os.WriteFile(configFile, []byte(`{"scalaVersions": ["_2.13"]}`), 0644)
+	if _, err := loadScalaVersions(configFile); err == nil {
+		t.Errorf("expected an invalid scala version to be an error")
+	}
+}
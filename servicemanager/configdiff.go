@@ -0,0 +1,163 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type serviceDiff struct {
+	service string
+	change  string   // +, - or ~
+	fields  []string // for changed services, each field that differs
+}
+
+// Shows how the services in effect differ from the shared config, i.e. the changes made by
+// services-overrides.json, .sm2/overrides.json and any --overlay.
+func (sm *ServiceManager) PrintConfigDiff() {
+	serviceFile, includes, err := sm.configSources("services")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	templates, err := sm.loadTemplates()
+	if err != nil {
+		fmt.Printf("Unable to load templates: %s\n", err)
+		return
+	}
+
+	upstream, err := loadServicesFromFile(serviceFile, templates, includes...)
+	if err != nil {
+		fmt.Printf("Unable to load %s: %s\n", serviceFile, err)
+		return
+	}
+	setupInfra(*upstream)
+
+	diffs, err := diffServices(*upstream, sm.Services)
+	if err != nil {
+		fmt.Printf("Unable to compare config: %s\n", err)
+		return
+	}
+
+	printConfigDiff(diffs, sm.localChangeSources(), sm.Config.ConfigDir, os.Stdout)
+}
+
+// describes where any local changes could have come from
+func (sm *ServiceManager) localChangeSources() []string {
+	sources := append([]string{}, sm.Config.OverrideFiles...)
+	if sm.Commands.Overlay != "" {
+		sources = append(sources, "overlay "+sm.Commands.Overlay)
+	}
+	return sources
+}
+
+func printConfigDiff(diffs []serviceDiff, sources []string, configDir string, out io.Writer) {
+	if len(diffs) == 0 {
+		fmt.Fprintf(out, "No local changes, the services in effect match %s\n", configDir)
+		return
+	}
+
+	fmt.Fprintf(out, "The services in effect differ from %s\n", configDir)
+	if len(sources) > 0 {
+		fmt.Fprintf(out, "Changes come from: %s\n", strings.Join(sources, ", "))
+	}
+	fmt.Fprintln(out)
+
+	for _, d := range diffs {
+		switch d.change {
+		case "+":
+			fmt.Fprintf(out, "+ %s (only in local config)\n", d.service)
+		case "-":
+			fmt.Fprintf(out, "- %s (removed locally)\n", d.service)
+		default:
+			fmt.Fprintf(out, "~ %s\n", d.service)
+			for _, f := range d.fields {
+				fmt.Fprintf(out, "    %s\n", f)
+			}
+		}
+	}
+}
+
+func diffServices(upstream Services, local Services) ([]serviceDiff, error) {
+	diffs := []serviceDiff{}
+
+	for id, service := range local {
+		upstreamService, ok := upstream[id]
+		if !ok {
+			diffs = append(diffs, serviceDiff{service: id, change: "+"})
+			continue
+		}
+
+		fields, err := diffService(upstreamService, service)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, serviceDiff{service: id, change: "~", fields: fields})
+		}
+	}
+
+	for id := range upstream {
+		if _, ok := local[id]; !ok {
+			diffs = append(diffs, serviceDiff{service: id, change: "-"})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].service < diffs[j].service
+	})
+	return diffs, nil
+}
+
+// compares two services field by field, returning a line for each field that differs
+func diffService(upstream Service, local Service) ([]string, error) {
+	a, err := toJsonObject(upstream)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toJsonObject(local)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+	diffJsonValues("", a, b, &fields)
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func toJsonObject(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJsonObject(data)
+}
+
+func diffJsonValues(path string, a interface{}, b interface{}, out *[]string) {
+	aObj, aIsObj := a.(map[string]interface{})
+	bObj, bIsObj := b.(map[string]interface{})
+	if aIsObj && bIsObj {
+		keys := map[string]bool{}
+		for k := range aObj {
+			keys[k] = true
+		}
+		for k := range bObj {
+			keys[k] = true
+		}
+		for k := range keys {
+			diffJsonValues(joinPath(path, k), aObj[k], bObj[k], out)
+		}
+		return
+	}
+
+	aJson, _ := json.Marshal(a)
+	bJson, _ := json.Marshal(b)
+	if string(aJson) != string(bJson) {
+		*out = append(*out, fmt.Sprintf("%s: %s -> %s", path, aJson, bJson))
+	}
+}
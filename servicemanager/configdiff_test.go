@@ -0,0 +1,52 @@
+package servicemanager
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffServices(t *testing.T) {
+	upstream := Services{
+		"FOO": {Id: "FOO", Name: "foo", DefaultPort: 8000, Binary: ServiceBinary{Cmd: []string{"./foo"}}},
+		"BAR": {Id: "BAR", Name: "bar", DefaultPort: 9000},
+		"OLD": {Id: "OLD"},
+	}
+	local := Services{
+		"FOO": {Id: "FOO", Name: "foo", DefaultPort: 8001, Binary: ServiceBinary{Cmd: []string{"./foo", "-Dx=y"}}},
+		"BAR": {Id: "BAR", Name: "bar", DefaultPort: 9000},
+		"NEW": {Id: "NEW"},
+	}
+
+	diffs, err := diffServices(upstream, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []serviceDiff{
+		{service: "FOO", change: "~", fields: []string{`binary.cmd: ["./foo"] -> ["./foo","-Dx=y"]`, "defaultPort: 8000 -> 8001"}},
+		{service: "NEW", change: "+"},
+		{service: "OLD", change: "-"},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, diffs)
+	}
+
+	out := bytes.Buffer{}
+	printConfigDiff(diffs, []string{"/home/me/.sm2/services-overrides.json"}, "/config", &out)
+	if !strings.Contains(out.String(), "~ FOO\n    binary.cmd") || !strings.Contains(out.String(), "services-overrides.json") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestNoDiff(t *testing.T) {
+	services := Services{"FOO": {Id: "FOO", DefaultPort: 8000}}
+	diffs, _ := diffServices(services, services)
+
+	out := bytes.Buffer{}
+	printConfigDiff(diffs, nil, "/config", &out)
+	if !strings.HasPrefix(out.String(), "No local changes") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
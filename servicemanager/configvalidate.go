@@ -0,0 +1,441 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fields that must be present, keyed by the type they belong to
+var requiredFields = map[reflect.Type][]string{
+	reflect.TypeOf(ServiceBinary{}): {"artifact", "groupId", "cmd"},
+	reflect.TypeOf(Tunnel{}):        {"host"},
+	reflect.TypeOf(Docker{}):        {"image"},
+	reflect.TypeOf(Wiremock{}):      {"mappings"},
+	reflect.TypeOf(Pact{}):          {"broker", "provider"},
+}
+
+type configProblem struct {
+	file    string
+	line    int
+	path    string
+	message string
+}
+
+func (p configProblem) String() string {
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.file, p.path, p.message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", p.file, p.line, p.path, p.message)
+}
+
+// Walks a json document checking it against the type it will be decoded into, reporting
+// unknown fields, missing required fields, wrong types and duplicate keys along with the
+// line they're on. encoding/json silently ignores/overwrites most of these.
+type configValidator struct {
+	file     string
+	data     []byte
+	decoder  *json.Decoder
+	partial  bool // override files only contain the fields being changed, so nothing is required
+	problems []configProblem
+}
+
+// problems are reported against a file's path relative to the config dir
+func relativeToConfig(file string, configDir string) string {
+	if rel, err := filepath.Rel(configDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+func validateOverrideFile(file string, into reflect.Type) ([]configProblem, error) {
+	return validateFile(file, file, true, into)
+}
+
+func validateFile(file string, name string, partial bool, into reflect.Type) ([]configProblem, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isYamlFile(file) {
+		v := newConfigValidator(name, data)
+		v.partial = partial
+		return v.run(into), nil
+	}
+
+	// yaml is validated after its been converted to json, so problems can only be tied to a line if the yaml itself is invalid
+	data, err = yamlToJson(data)
+	if yamlErr, ok := err.(*yamlError); ok {
+		return []configProblem{{name, yamlErr.line, "", "invalid yaml: " + yamlErr.msg}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	v := newConfigValidator(name, data)
+	v.partial = partial
+	problems := v.run(into)
+	for i := range problems {
+		problems[i].line = 0
+	}
+	return problems, nil
+}
+
+func validateConfig(file string, data []byte, into reflect.Type) []configProblem {
+	v := newConfigValidator(file, data)
+	return v.run(into)
+}
+
+func newConfigValidator(file string, data []byte) *configValidator {
+	v := &configValidator{
+		file:    file,
+		data:    data,
+		decoder: json.NewDecoder(bytes.NewReader(data)),
+	}
+	v.decoder.UseNumber()
+	return v
+}
+
+func (v *configValidator) run(into reflect.Type) []configProblem {
+	if err := v.validate(into, ""); err != nil {
+		v.report("", fmt.Sprintf("invalid json: %s", err))
+	}
+	return v.problems
+}
+
+// converts the decoders current position into a line number
+func (v *configValidator) line() int {
+	offset := v.decoder.InputOffset()
+	if offset > int64(len(v.data)) {
+		offset = int64(len(v.data))
+	}
+	return bytes.Count(v.data[:offset], []byte("\n")) + 1
+}
+
+func (v *configValidator) report(path string, message string) {
+	v.reportAt(v.line(), path, message)
+}
+
+func (v *configValidator) reportAt(line int, path string, message string) {
+	v.problems = append(v.problems, configProblem{v.file, line, path, message})
+}
+
+func (v *configValidator) validate(t reflect.Type, path string) error {
+	tok, err := v.decoder.Token()
+	if err != nil {
+		return err
+	}
+	return v.validateToken(tok, t, path)
+}
+
+func (v *configValidator) validateToken(tok json.Token, t reflect.Type, path string) error {
+	if tok == nil {
+		// null is treated as if the field wasn't set
+		return nil
+	}
+	if s, ok := tok.(string); ok && isVarReference(s) {
+		// could be any type, it's checked once the vars have been filled in
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if tok != json.Delim('{') {
+			v.report(path, fmt.Sprintf("expected an object, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+		return v.validateStruct(t, path)
+
+	case reflect.Map:
+		if tok != json.Delim('{') {
+			v.report(path, fmt.Sprintf("expected an object, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+		return v.validateMap(t, path)
+
+	case reflect.Slice:
+		if tok != json.Delim('[') {
+			v.report(path, fmt.Sprintf("expected a list, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+		for i := 0; v.decoder.More(); i++ {
+			if err := v.validate(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err := v.decoder.Token() // closing ]
+		return err
+
+	case reflect.Interface:
+		// anything goes, e.g. the values of vars
+		return v.skip(tok)
+
+	case reflect.String:
+		if _, ok := tok.(string); !ok {
+			v.report(path, fmt.Sprintf("expected a string, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+
+	case reflect.Int:
+		n, ok := tok.(json.Number)
+		if !ok {
+			v.report(path, fmt.Sprintf("expected a number, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+		if _, err := n.Int64(); err != nil {
+			v.report(path, fmt.Sprintf("expected a whole number, got %s", n))
+		}
+
+	case reflect.Bool:
+		if _, ok := tok.(bool); !ok {
+			v.report(path, fmt.Sprintf("expected true or false, got %s", describeToken(tok)))
+			return v.skip(tok)
+		}
+	}
+	return nil
+}
+
+func (v *configValidator) validateStruct(t reflect.Type, path string) error {
+	startLine := v.line()
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+
+	firstProblem := len(v.problems)
+	seen := map[string]bool{}
+	for v.decoder.More() {
+		tok, err := v.decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		fieldPath := joinPath(path, key)
+
+		if seen[key] {
+			v.report(fieldPath, "duplicate field, only the last value will be used")
+		}
+		seen[key] = true
+
+		fieldType, ok := fields[key]
+		if !ok {
+			v.report(fieldPath, unknownFieldMessage(key, fields))
+			if err := v.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := v.validate(fieldType, fieldPath); err != nil {
+			return err
+		}
+	}
+
+	for _, required := range requiredFields[t] {
+		if !seen[required] && !v.partial {
+			v.reportAt(startLine, path, fmt.Sprintf("missing required field %s", required))
+		}
+	}
+
+	// anything missing from a service that extends a template should come from the template,
+	// and renamed services don't need anything other than the new name
+	if _, isService := fields["extends"]; isService && (seen["extends"] || seen["renamedTo"]) {
+		kept := v.problems[:firstProblem]
+		for _, p := range v.problems[firstProblem:] {
+			if !strings.HasPrefix(p.message, "missing required field") {
+				kept = append(kept, p)
+			}
+		}
+		v.problems = kept
+	}
+
+	_, err := v.decoder.Token() // closing }
+	return err
+}
+
+func (v *configValidator) validateMap(t reflect.Type, path string) error {
+	isPlatforms := t == reflect.TypeOf(Platforms{})
+	if isPlatforms {
+		// platform specific values only contain what's different, so nothing is required
+		defer func(partial bool) { v.partial = partial }(v.partial)
+		v.partial = true
+	}
+
+	seen := map[string]bool{}
+	for v.decoder.More() {
+		tok, err := v.decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		keyPath := joinPath(path, key)
+		if seen[key] {
+			v.report(keyPath, "defined more than once, only the last definition will be used")
+		}
+		seen[key] = true
+
+		if isPlatforms && !isKnownPlatform(key) {
+			v.report(keyPath, "unknown platform, expected an os or os/arch, e.g. linux or darwin/arm64")
+		}
+
+		if t == reflect.TypeOf(Services{}) && key == varsKey {
+			if err := v.validate(reflect.TypeOf(Vars{}), keyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := v.validate(t.Elem(), keyPath); err != nil {
+			return err
+		}
+	}
+	_, err := v.decoder.Token() // closing }
+	return err
+}
+
+// skips over the rest of a value we've already read the first token of
+func (v *configValidator) skip(tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	depth := 1
+	for depth > 0 {
+		tok, err := v.decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+func (v *configValidator) skipValue() error {
+	tok, err := v.decoder.Token()
+	if err != nil {
+		return err
+	}
+	return v.skip(tok)
+}
+
+func unknownFieldMessage(key string, fields map[string]reflect.Type) string {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Sprintf("unknown field, did you mean %s?", name)
+		}
+	}
+	return "unknown field"
+}
+
+func describeToken(tok json.Token) string {
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return "an object"
+		}
+		return "a list"
+	case string:
+		return fmt.Sprintf("the string %q", t)
+	case json.Number:
+		return fmt.Sprintf("the number %s", t)
+	case bool:
+		return fmt.Sprint(t)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(tok)
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// validates services.json, profiles.json, overlays.json, templates.json, any included config and any override files
+func (sm *ServiceManager) findConfigProblems() ([]configProblem, error) {
+	problems := []configProblem{}
+
+	add := func(fileProblems []configProblem, err error) error {
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(fileProblems, func(i, j int) bool {
+			return fileProblems[i].line < fileProblems[j].line
+		})
+		problems = append(problems, fileProblems...)
+		return nil
+	}
+
+	sources := []struct {
+		name     string
+		into     reflect.Type
+		partial  bool // only contains changes to services, so nothing is required
+		optional bool
+	}{
+		{"services", reflect.TypeOf(Services{}), false, false},
+		{"profiles", reflect.TypeOf(Profiles{}), false, false},
+		{"overlays", reflect.TypeOf(map[string]Overlay{}), true, true},
+		{"templates", reflect.TypeOf(map[string]Service{}), true, true},
+	}
+
+	for _, source := range sources {
+		main, includes, err := sm.configSources(source.name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range append([]string{main}, includes...) {
+			if source.optional && !Exists(f) {
+				continue
+			}
+			if err := add(validateFile(f, relativeToConfig(f, sm.Config.ConfigDir), source.partial, source.into)); err != nil {
+				return nil, err
+			}
+		}
+
+		if source.name == "services" {
+			for _, f := range sm.Config.OverrideFiles {
+				if err := add(validateOverrideFile(f, source.into)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// prints any problems with the config, returns false if there were any
+func (sm *ServiceManager) ValidateConfig(out io.Writer) bool {
+	problems, err := sm.findConfigProblems()
+	if err != nil {
+		fmt.Fprintf(out, "Unable to validate config: %s\n", err)
+		return false
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "Config in %s is valid.\n", sm.Config.ConfigDir)
+		return true
+	}
+	fmt.Fprintf(out, "\nFound %d problem(s) in %s\n", len(problems), sm.Config.ConfigDir)
+	return false
+}
@@ -0,0 +1,88 @@
+package servicemanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+const validServicesJson = `{
+    "FOO": {
+        "name": "foo",
+        "defaultPort": 8080,
+        "frontend": true,
+        "binary": {
+            "artifact": "foo_%%",
+            "groupId": "uk/gov/foo",
+            "cmd": ["./foo/bin/foo", "-J-Xmx256m"]
+        },
+        "healthcheck": null
+    }
+}`
+
+const invalidServicesJson = `{
+    "FOO": {
+        "name": "foo",
+        "defaultPort": "8080",
+        "binary": {
+            "artifact": "foo_%%",
+            "cmd": ["./foo/bin/foo", 123]
+        }
+    },
+    "BAR": {
+        "name": "bar",
+        "DefaultPort": 9090,
+        "proxyPath": ["/bar"],
+        "binary": {
+            "artifact": "bar",
+            "groupId": "uk/gov/bar",
+            "cmd": ["./bar/bin/bar"]
+        }
+    },
+    "FOO": {
+        "name": "foo again",
+        "frontend": true,
+        "frontend": false,
+        "binary": {
+            "artifact": "foo",
+            "groupId": "uk/gov/foo",
+            "cmd": ["./foo/bin/foo"]
+        }
+    }
+}`
+
+func TestValidateConfigAcceptsValidServices(t *testing.T) {
+	problems := validateConfig("services.json", []byte(validServicesJson), reflect.TypeOf(Services{}))
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConfigFindsProblems(t *testing.T) {
+	problems := validateConfig("services.json", []byte(invalidServicesJson), reflect.TypeOf(Services{}))
+
+	expected := []string{
+		`services.json:4: FOO.defaultPort: expected a number, got the string "8080"`,
+		`services.json:7: FOO.binary.cmd[1]: expected a string, got the number 123`,
+		`services.json:5: FOO.binary: missing required field groupId`,
+		`services.json:12: BAR.DefaultPort: unknown field, did you mean defaultPort?`,
+		`services.json:13: BAR.proxyPath: unknown field`,
+		`services.json:20: FOO: defined more than once, only the last definition will be used`,
+		`services.json:23: FOO.frontend: duplicate field, only the last value will be used`,
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, p := range problems {
+		if p.String() != expected[i] {
+			t.Errorf("problem %d\nexpected: %s\ngot:      %s", i, expected[i], p)
+		}
+	}
+}
+
+func TestValidateConfigReportsInvalidJson(t *testing.T) {
+	problems := validateConfig("profiles.json", []byte(`{"FOO": ["A", "B"`), reflect.TypeOf(Profiles{}))
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+}
@@ -0,0 +1,107 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// With --cors the reverse proxy adds permissive CORS headers to responses for requests from the given
+// origins, and answers preflight requests itself, so a frontend running on its own dev server (e.g.
+// http://localhost:5173) can call the local backend services without changing their config, e.g.
+//
+//	sm2 -reverse-proxy -cors http://localhost:5173,http://localhost:4200
+//
+// "*" allows any origin. The headers are only for local development, they let any allowed origin make
+// credentialed requests with any method or header.
+
+const corsMaxAge = "600"
+
+type corsPolicy struct {
+	origins   map[string]bool
+	anyOrigin bool
+}
+
+// parses a comma separated list of origins, an empty list turns cors off
+func parseCorsOrigins(value string) (corsPolicy, error) {
+	policy := corsPolicy{origins: map[string]bool{}}
+	if value == "" {
+		return policy, nil
+	}
+
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			policy.anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			return policy, fmt.Errorf("%s is not an origin, expected something like http://localhost:5173 or *", origin)
+		}
+		policy.origins[strings.ToLower(origin)] = true
+	}
+	return policy, nil
+}
+
+func (c corsPolicy) enabled() bool {
+	return c.anyOrigin || len(c.origins) > 0
+}
+
+func (c corsPolicy) allows(origin string) bool {
+	return origin != "" && (c.anyOrigin || c.origins[strings.ToLower(origin)])
+}
+
+// answers preflight requests from allowed origins without troubling the service
+func (c corsPolicy) handler(next http.Handler) http.Handler {
+	if !c.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" || !c.allows(origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h := w.Header()
+		c.setOriginHeaders(h, origin)
+		h.Set("Access-Control-Allow-Methods", req.Header.Get("Access-Control-Request-Method"))
+		if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		h.Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// used as the proxy's ModifyResponse, replacing whatever cors headers the service sent
+func (c corsPolicy) modifyResponse(resp *http.Response) error {
+	origin := resp.Request.Header.Get("Origin")
+	if !c.enabled() || !c.allows(origin) {
+		return nil
+	}
+
+	exposed := []string{}
+	for name := range resp.Header {
+		if !strings.HasPrefix(name, "Access-Control-") {
+			exposed = append(exposed, name)
+		}
+	}
+	sort.Strings(exposed)
+
+	c.setOriginHeaders(resp.Header, origin)
+	if len(exposed) > 0 {
+		resp.Header.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+	return nil
+}
+
+func (c corsPolicy) setOriginHeaders(h http.Header, origin string) {
+	// echoing the origin rather than sending * allows credentials
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	h.Add("Vary", "Origin")
+}
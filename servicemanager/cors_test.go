@@ -0,0 +1,64 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestCorsProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://www.example.com")
+		w.Header().Set("X-Request-Id", "123")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	cors, err := parseCorsOrigins("http://localhost:5173/, http://localhost:4200")
+	AssertNotErr(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = cors.modifyResponse
+	server := httptest.NewServer(cors.handler(proxy))
+	defer server.Close()
+
+	request := func(method string, origin string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/api", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type, Csrf-Token")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		AssertNotErr(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := request(http.MethodGet, "http://localhost:5173")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:5173" || resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("expected the allowed origin to replace the service's, got %v", resp.Header)
+	}
+	if resp.Header.Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("expected the response headers to be exposed")
+	}
+
+	resp = request(http.MethodOptions, "http://localhost:4200")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Methods") != "PUT" || resp.Header.Get("Access-Control-Allow-Headers") != "Content-Type, Csrf-Token" {
+		t.Errorf("expected the preflight to be answered by the proxy, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// other origins are passed through untouched
+	resp = request(http.MethodGet, "http://evil.example.com")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://www.example.com" {
+		t.Errorf("expected the service's own headers, got %v", resp.Header)
+	}
+
+	if _, err := parseCorsOrigins("localhost:5173"); err == nil {
+		t.Errorf("expected an origin without a scheme to be rejected")
+	}
+}
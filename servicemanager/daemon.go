@@ -0,0 +1,93 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const DEFAULT_DAEMON_INTERVAL = 10 * time.Second
+
+// how long to leave a service that failed to start before trying again
+const keepWarmRetry = time.Minute
+
+// Runs sm2 in the foreground as a long running agent, periodically carrying out
+// background tasks (recording health checks etc) until it is interrupted.
+// Any services or profiles given are started, and started again if they stop.
+func (sm *ServiceManager) RunDaemon() {
+	interval := DEFAULT_DAEMON_INTERVAL
+
+	fmt.Printf("sm2 daemon running with pid %d, checking services every %v. Press Ctrl-C to stop.\n", os.Getpid(), interval)
+
+	sm.configWatcher = sm.newConfigWatcher()
+	sm.warmAttempts = map[string]time.Time{}
+	sm.statuses = newStatusCache(statusCacheTtl)
+	sm.refresher = sm.newMetadataRefresher()
+	// there's no terminal to draw progress bars on when it's run by launchd etc
+	sm.progress.noProgress = true
+	if sm.Commands.Api || sm.Commands.Grpc {
+		api, err := sm.startApi(os.Stdout)
+		if err != nil {
+			fmt.Printf("Failed to start the api: %s\n", err)
+			os.Exit(1)
+		}
+		sm.api = api
+	}
+	for {
+		sm.daemonTick()
+		time.Sleep(interval)
+	}
+}
+
+// the work the daemon does on each interval
+func (sm *ServiceManager) daemonTick() {
+	if sm.api != nil {
+		sm.api.lock.Lock()
+		defer sm.api.lock.Unlock()
+	}
+
+	if sm.configWatcher != nil {
+		sm.reloadIfChanged(sm.configWatcher, os.Stdout)
+	}
+
+	sm.keepWarm(sm.StartService, os.Stdout)
+	sm.refreshMetadata(os.Stdout)
+
+	if err := sm.recordHealth(); err != nil {
+		fmt.Printf("Failed to record health checks: %s\n", err)
+	}
+
+	if sm.api != nil {
+		sm.api.publishChanges()
+	}
+}
+
+// starts the services and profiles given on the command line that aren't running
+func (sm *ServiceManager) keepWarm(start func(ServiceAndVersion) error, out io.Writer) {
+	services := sm.requestedServicesAndProfiles()
+	if len(services) == 0 {
+		return
+	}
+
+	running := sm.runningServicePorts()
+	for _, sv := range services {
+		if _, ok := running[sv.service]; ok {
+			continue
+		}
+		if attempted, ok := sm.warmAttempts[sv.service]; ok && time.Since(attempted) < keepWarmRetry {
+			continue
+		}
+		if service, ok := sm.Services[sv.service]; ok && sm.isAlreadyRunning(service) {
+			continue
+		}
+
+		if sm.warmAttempts != nil {
+			sm.warmAttempts[sv.service] = time.Now()
+		}
+		fmt.Fprintf(out, "%s is not running, starting it\n", sv.service)
+		if err := start(sv); err != nil {
+			fmt.Fprintf(out, "Failed to start %s: %s\n", sv.service, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package servicemanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestKeepWarm(t *testing.T) {
+	sm := ServiceManager{
+		Client:   &http.Client{Timeout: 100 * time.Millisecond},
+		Services: Services{"AUTH": {Id: "AUTH", DefaultPort: 1}, "PAYMENTS": {Id: "PAYMENTS", DefaultPort: 2}, "EMAIL": {Id: "EMAIL", DefaultPort: 3}},
+		Profiles: Profiles{"CORE": {"AUTH", "PAYMENTS", "EMAIL"}},
+		Commands: cli.UserOption{ExtraServices: []string{"CORE"}},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{{Service: "AUTH", Port: 1, Pid: 1}}, nil
+		}},
+		Platform:     platform.Platform{PidLookup: func() map[int]int { return map[int]int{1: 1} }},
+		warmAttempts: map[string]time.Time{},
+	}
+
+	started := []string{}
+	start := func(sv ServiceAndVersion) error {
+		started = append(started, sv.service)
+		if sv.service == "EMAIL" {
+			return fmt.Errorf("download failed")
+		}
+		return nil
+	}
+
+	out := bytes.Buffer{}
+	sm.keepWarm(start, &out)
+	if strings.Join(started, ",") != "PAYMENTS,EMAIL" {
+		t.Errorf("expected the services that aren't running to be started, got %v", started)
+	}
+	if !strings.Contains(out.String(), "Failed to start EMAIL: download failed") {
+		t.Errorf("expected the failure to be logged, got %s", out.String())
+	}
+
+	// they aren't tried again straight away
+	started = nil
+	sm.keepWarm(start, &out)
+	if len(started) != 0 {
+		t.Errorf("expected nothing to be started, got %v", started)
+	}
+
+	sm.warmAttempts["EMAIL"] = time.Now().Add(-2 * keepWarmRetry)
+	sm.keepWarm(start, &out)
+	if strings.Join(started, ",") != "EMAIL" {
+		t.Errorf("expected EMAIL to be retried, got %v", started)
+	}
+}
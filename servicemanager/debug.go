@@ -3,12 +3,11 @@ package servicemanager
 import (
 	"fmt"
 	"io/ioutil"
-	"path"
 )
 
 func (sm *ServiceManager) showDebug(serviceName string) {
 
-	_, ok := sm.Services[serviceName]
+	service, ok := sm.Services[serviceName]
 	if !ok {
 		fmt.Printf("Service %s is not in config!\n", serviceName)
 		return
@@ -33,7 +32,7 @@ func (sm *ServiceManager) showDebug(serviceName string) {
 		return
 	}
 
-	fmt.Printf("%s: version %s\n Installed at %s on %s\n", installFile.Service, installFile.Version, installFile.Path, installFile.Created)
+	fmt.Printf("%s: version %s\n Installed at %s on %s\n", installFile.Service, installFile.Version, installFile.Path, installFile.Created.Local())
 
 	// check state file
 	fmt.Println("Checking .state file...")
@@ -44,7 +43,7 @@ func (sm *ServiceManager) showDebug(serviceName string) {
 	}
 
 	// print out the interesting bits of state
-	fmt.Printf("The .state file says %s version %s was started on %s with PID %d\n", stateFile.Service, stateFile.Version, stateFile.Started, stateFile.Pid)
+	fmt.Printf("The .state file says %s version %s was started on %s with PID %d\n", stateFile.Service, stateFile.Version, stateFile.Started.Local(), stateFile.Pid)
 
 	// check if it was started prior to the last reboot
 	if stateFile.Started.Before(sm.Platform.Uptime()) {
@@ -76,7 +75,7 @@ func (sm *ServiceManager) showDebug(serviceName string) {
 		fmt.Printf("Service did not respond on [%s]... check the log files\n", stateFile.HealthcheckUrl)
 	}
 	// show what logs we have
-	logDir := path.Join(installFile.Path, "logs")
+	logDir := service.Output.logDir(serviceName, installFile.Path)
 	files, err := ioutil.ReadDir(logDir)
 	if err != nil {
 		fmt.Printf("unable to read log dir: %s\n%s\n", logDir, err)
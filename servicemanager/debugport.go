@@ -0,0 +1,36 @@
+package servicemanager
+
+import (
+	"fmt"
+)
+
+// -jdwp starts services with the jvm debugger listening, so an IDE can attach to them. Each service listens
+// on its debugPort, or its port + 10000 if it doesn't have one, e.g. 19000 for a service on 9000.
+// The debugger only ever listens on loopback, whatever the service's bind address.
+
+const debugPortOffset = 10000
+
+func debugPort(service Service, port int) int {
+	if service.DebugPort > 0 {
+		return service.DebugPort
+	}
+	if port > 0 && port+debugPortOffset <= 65535 {
+		return port + debugPortOffset
+	}
+	return 0
+}
+
+// the start scripts pass -J args on to the jvm
+func jdwpArg(debugPort int) string {
+	return fmt.Sprintf("-J-agentlib:jdwp=transport=dt_socket,server=y,suspend=n,address=127.0.0.1:%d", debugPort)
+}
+
+// the debug port of a running service, or the one it would use when started with -jdwp
+func (sm *ServiceManager) findDebugPort(service Service) int {
+	if installDir, err := sm.findInstallDirOfService(service.Id); err == nil && sm.Ledger.LoadStateFile != nil {
+		if state, err := sm.Ledger.LoadStateFile(installDir); err == nil && state.DebugPort > 0 {
+			return state.DebugPort
+		}
+	}
+	return debugPort(service, sm.findPort(service))
+}
@@ -0,0 +1,33 @@
+package servicemanager
+
+import (
+	"testing"
+
+	"sm2/ledger"
+)
+
+func TestDebugPort(t *testing.T) {
+	if port := debugPort(Service{DefaultPort: 9000}, 9000); port != 19000 {
+		t.Errorf("expected the port + 10000, got %d", port)
+	}
+	if port := debugPort(Service{DefaultPort: 9000, DebugPort: 5005}, 9000); port != 5005 {
+		t.Errorf("expected the configured debug port, got %d", port)
+	}
+	if port := debugPort(Service{DefaultPort: 60000}, 60000); port != 0 {
+		t.Errorf("expected no debug port when the offset is out of range, got %d", port)
+	}
+	if arg := jdwpArg(19000); arg != "-J-agentlib:jdwp=transport=dt_socket,server=y,suspend=n,address=127.0.0.1:19000" {
+		t.Errorf("unexpected arg %s", arg)
+	}
+
+	// running services use the port they were started with
+	sm := ServiceManager{
+		Services: Services{"AUTH": {Id: "AUTH", DefaultPort: 8500}},
+		Ledger: ledger.Ledger{LoadStateFile: func(string) (ledger.StateFile, error) {
+			return ledger.StateFile{Service: "AUTH", Port: 41000, DebugPort: 51000}, nil
+		}},
+	}
+	if port := sm.findDebugPort(sm.Services["AUTH"]); port != 51000 {
+		t.Errorf("expected the running service's debug port, got %d", port)
+	}
+}
@@ -0,0 +1,166 @@
+package servicemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Most services ship the same jars (scala, play, akka etc), so a workspace with a lot of them installed keeps
+// many copies of each. sm2 -dedupe hard links the identical jars in every install dir to one copy kept in
+// install/.jars, and with the dedupeInstalls setting each service is deduped as it's installed. A linked jar
+// is removed along with the install that uses it, and a stored copy nothing links to any more is cleaned up.
+// Only jars are linked, as nothing writes to them once they're installed.
+
+const dedupeStoreDir = ".jars"
+
+func (sm *ServiceManager) dedupeStore() string {
+	return path.Join(sm.Config.TmpDir, dedupeStoreDir)
+}
+
+func (sm *ServiceManager) dedupeOnInstall() bool {
+	dedupe, _ := strconv.ParseBool(sm.Commands.Settings["dedupeInstalls"])
+	return dedupe
+}
+
+// dedupes every service that's installed, and prints how much space it saved
+func (sm *ServiceManager) DedupeInstalls() error {
+	files, err := os.ReadDir(sm.Config.TmpDir)
+	if err != nil {
+		return err
+	}
+
+	linked, saved := 0, int64(0)
+	for _, file := range files {
+		if !file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		n, size, err := dedupeDir(sm.dedupeStore(), path.Join(sm.Config.TmpDir, file.Name()))
+		linked += n
+		saved += size
+		if err != nil {
+			return fmt.Errorf("unable to dedupe %s: %s", file.Name(), err)
+		}
+	}
+	pruned, err := pruneDedupeStore(sm.dedupeStore())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Linked %d identical jars, saving %.1f MB", linked, float64(saved)/(1024*1024))
+	if pruned > 0 {
+		fmt.Printf(", and removed %d jars no longer used", pruned)
+	}
+	fmt.Println()
+	return nil
+}
+
+// links the jars in dir to identical ones in the store, returning how many were linked and the bytes saved
+func dedupeDir(store string, dir string) (int, int64, error) {
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return 0, 0, err
+	}
+	linked, saved := 0, int64(0)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(file, ".jar") {
+			return nil
+		}
+		size, err := dedupeFile(store, file)
+		if size > 0 {
+			linked++
+			saved += size
+		}
+		return err
+	})
+	return linked, saved, err
+}
+
+// replaces the file with a link to the stored copy of it, or stores it if it's the first. Returns the
+// bytes saved, which is 0 when it was stored or was already linked
+func dedupeFile(store string, file string) (int64, error) {
+	hash, err := hashFile(file)
+	if err != nil {
+		return 0, err
+	}
+	stored := path.Join(store, hash+".jar")
+
+	err = os.Link(file, stored)
+	if err == nil {
+		return 0, nil
+	}
+	// another install (or worker) stored it first
+	if !os.IsExist(err) {
+		return 0, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	storedInfo, err := os.Stat(stored)
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(info, storedInfo) {
+		return 0, nil
+	}
+
+	// linked under a temp name first, so the jar is never missing
+	tmp := file + ".dedupe"
+	if err := os.Link(stored, tmp); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// removes the stored jars that are only linked from the store, returning how many were removed
+func pruneDedupeStore(store string) (int, error) {
+	files, err := os.ReadDir(store)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink <= 1 {
+			if os.Remove(path.Join(store, file.Name())) == nil {
+				pruned++
+			}
+		}
+	}
+	return pruned, nil
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
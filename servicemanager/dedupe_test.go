@@ -0,0 +1,58 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestDedupeLinksIdenticalJars(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(file string, content string) {
+		AssertNotErr(t, os.MkdirAll(path.Dir(path.Join(tmpDir, file)), 0755))
+		AssertNotErr(t, os.WriteFile(path.Join(tmpDir, file), []byte(content), 0644))
+	}
+	write("auth/auth-1.0.0/lib/scala-library.jar", "scala")
+	write("auth/auth-1.0.0/lib/auth.jar", "auth")
+	write("auth/auth-1.0.0/conf/application.conf", "same")
+	write("email/email-2.0.0/lib/scala-library.jar", "scala")
+	write("email/email-2.0.0/lib/email.jar", "email")
+	write("email/email-2.0.0/conf/application.conf", "same")
+
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: tmpDir}}
+	AssertNotErr(t, sm.DedupeInstalls())
+
+	stat := func(file string) os.FileInfo {
+		info, err := os.Stat(path.Join(tmpDir, file))
+		AssertNotErr(t, err)
+		return info
+	}
+	if !os.SameFile(stat("auth/auth-1.0.0/lib/scala-library.jar"), stat("email/email-2.0.0/lib/scala-library.jar")) {
+		t.Error("expected the identical jars to be linked")
+	}
+	if os.SameFile(stat("auth/auth-1.0.0/conf/application.conf"), stat("email/email-2.0.0/conf/application.conf")) {
+		t.Error("expected only jars to be linked")
+	}
+	if data, _ := os.ReadFile(path.Join(tmpDir, "email/email-2.0.0/lib/email.jar")); string(data) != "email" {
+		t.Errorf("expected email.jar to be unchanged, got %s", data)
+	}
+
+	// deduping again changes nothing
+	n, saved, err := dedupeDir(sm.dedupeStore(), path.Join(tmpDir, "email"))
+	AssertNotErr(t, err)
+	if n != 0 || saved != 0 {
+		t.Errorf("expected nothing more to be linked, got %d jars, %d bytes", n, saved)
+	}
+
+	// once every install of a jar has gone, so has the stored copy
+	stored, _ := os.ReadDir(sm.dedupeStore())
+	AssertNotErr(t, os.RemoveAll(path.Join(tmpDir, "auth")))
+	pruned, err := pruneDedupeStore(sm.dedupeStore())
+	AssertNotErr(t, err)
+	remaining, _ := os.ReadDir(sm.dedupeStore())
+	if len(stored) != 3 || pruned != 1 || len(remaining) != 2 {
+		t.Errorf("expected auth.jar to be removed from the store, had %d, pruned %d, left %d", len(stored), pruned, len(remaining))
+	}
+}
@@ -10,18 +10,21 @@ import (
 	"regexp"
 	"runtime"
 
+	"sm2/platform"
 	"sm2/version"
 )
 
-func RunDiagnostics(config ServiceManagerConfig) {
+func RunDiagnostics(config ServiceManagerConfig, proxy *proxyResolver, wsl platform.Wsl) {
 
 	version.PrintVersion()
 	checkOS()
+	checkWsl(config, wsl)
 	checkJava()
 	checkGit()
 	checkWorkspace(config)
 	checkConfigRevision(config)
-	checkNetwork(config)
+	checkProxy(proxy)
+	checkNetwork(config, proxy)
 
 }
 
@@ -78,7 +81,7 @@ func checkJava() {
 
 func javaPath() string {
 	javaHome, javaHomeDefined := os.LookupEnv("JAVA_HOME")
-	if javaHomeDefined {
+	if javaHomeDefined && !windowsJavaHome(javaHome) {
 		return javaHome + "/bin/java"
 	} else {
 		return "java"
@@ -105,7 +108,7 @@ func checkOS() {
 	}
 }
 
-func checkNetwork(config ServiceManagerConfig) {
+func checkNetwork(config ServiceManagerConfig, proxy *proxyResolver) {
 	artifactoryUrl, err := url.Parse(config.ArtifactoryPingUrl)
 	if err != nil {
 		fmt.Print("VPN:\t\t artifactory url not valid!\n")
@@ -122,6 +125,9 @@ func checkNetwork(config ServiceManagerConfig) {
 	}
 
 	client := &http.Client{}
+	if proxy != nil {
+		client.Transport = proxy.transport()
+	}
 
 	if ok, err := checkVpn(client, config); ok {
 		fmt.Printf("VPN:\t\t OK (%s responds to ping)\n", artifactoryUrl)
@@ -0,0 +1,38 @@
+package servicemanager
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// A disk that fills up part way through extracting a service fails it with ENOSPC, having used up what space
+// there was. So before a download's extracted, the free space where it's going is compared to its size, times
+// extractExpansion (the most a service's tgz grows by once it's unpacked), and it's not downloaded if it
+// won't fit. Downloads of unknown size aren't checked.
+
+const extractExpansion = 3
+
+// an error if there isn't room in dir for a download of size bytes, once it's extracted
+func checkDiskSpace(dir string, size int64, free func(string) (int64, error)) error {
+	if size <= 0 {
+		return nil
+	}
+	available, err := free(dir)
+	if err != nil {
+		// it's not worth failing the install over
+		return nil
+	}
+	if needed := size * extractExpansion; needed > available {
+		return fmt.Errorf("not enough disk space, it needs up to %s in %s and only %s is free", formatSize(needed), dir, formatSize(available))
+	}
+	return nil
+}
+
+// the bytes free for sm2 to use on the volume dir is on
+func freeSpace(dir string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,32 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	free := func(available int64) func(string) (int64, error) {
+		return func(string) (int64, error) { return available, nil }
+	}
+	mb := int64(1024 * 1024)
+
+	if err := checkDiskSpace("/install", 100*mb, free(1000*mb)); err != nil {
+		t.Errorf("expected a download that fits to be fine, got %s", err)
+	}
+	err := checkDiskSpace("/install", 100*mb, free(120*mb))
+	if err == nil || !strings.Contains(err.Error(), "it needs up to 300.0 MB in /install and only 120.0 MB is free") {
+		t.Errorf("expected a download that won't fit once extracted to fail, got %v", err)
+	}
+	if err := checkDiskSpace("/install", -1, free(0)); err != nil {
+		t.Errorf("expected a download of unknown size not to be checked, got %s", err)
+	}
+	if err := checkDiskSpace("/install", 100*mb, func(string) (int64, error) { return 0, fmt.Errorf("statfs failed") }); err != nil {
+		t.Errorf("expected not knowing the free space not to fail the install, got %s", err)
+	}
+
+	if available, err := freeSpace(t.TempDir()); err != nil || available <= 0 {
+		t.Errorf("expected the free space of the temp dir, got %d %v", available, err)
+	}
+}
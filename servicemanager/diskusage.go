@@ -0,0 +1,281 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// sm2 -du shows how much disk each installed service takes up, split into the install and its logs, along
+// with what's shared (install/.jars, see dedupe.go) and sm2's own files. The install dirs are scanned a few at
+// a time, as a workspace can have hundreds of them. Files that are hard linked are shared out between
+// their links, so deduped jars aren't counted over and over. It finishes with what could be reclaimed:
+// installs of services that are no longer in the config (which -prune removes), jars -dedupe would link,
+// and the logs of services that aren't running.
+
+const diskUsageWorkers = 8
+
+type installUsage struct {
+	dir     string // the name of the install dir
+	service string // from its .install file, empty if it doesn't have one
+	version string
+	install int64
+	logs    int64
+	orphan  bool // no service in the config is installed here
+	running bool
+}
+
+type diskUsage struct {
+	installs   []installUsage
+	shared     int64 // the deduped jars
+	other      int64 // sm2's own files, locks etc
+	duplicates int   // jars that aren't linked to an identical copy
+	duplicated int64 // and the space they take up
+}
+
+func (u diskUsage) total() int64 {
+	total := u.shared + u.other
+	for _, i := range u.installs {
+		total += i.install + i.logs
+	}
+	return total
+}
+
+// a jar that's seen more than once, by name and size
+type jarKey struct {
+	name string
+	size int64
+}
+
+func (sm *ServiceManager) scanDiskUsage() (diskUsage, error) {
+	usage := diskUsage{}
+	entries, err := os.ReadDir(sm.Config.TmpDir)
+	if err != nil {
+		return usage, err
+	}
+
+	running := map[string]bool{}
+	if states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir); err == nil {
+		for _, state := range states {
+			running[state.Service] = true
+		}
+	}
+
+	inUse := map[string]bool{}
+	for id := range sm.Services {
+		if installDir, err := sm.findInstallDirOfService(id); err == nil {
+			inUse[installDir] = true
+		}
+	}
+
+	lock := sync.Mutex{}
+	jars := map[jarKey]int{}
+	tasks := make(chan string, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				usage.other += info.Size()
+			}
+			continue
+		}
+		tasks <- entry.Name()
+	}
+	close(tasks)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < diskUsageWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range tasks {
+				if dir == dedupeStoreDir {
+					size, _ := dirSize(path.Join(sm.Config.TmpDir, dir), nil)
+					lock.Lock()
+					usage.shared += size
+					lock.Unlock()
+					continue
+				}
+				if strings.HasPrefix(dir, ".") {
+					size, _ := dirSize(path.Join(sm.Config.TmpDir, dir), nil)
+					lock.Lock()
+					usage.other += size
+					lock.Unlock()
+					continue
+				}
+
+				found := map[jarKey]int{}
+				install := sm.scanInstall(dir, running, inUse, found)
+				lock.Lock()
+				usage.installs = append(usage.installs, install)
+				for jar, n := range found {
+					jars[jar] += n
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for jar, n := range jars {
+		if n > 1 {
+			usage.duplicates += n - 1
+			usage.duplicated += int64(n-1) * jar.size
+		}
+	}
+	sort.Slice(usage.installs, func(i, j int) bool {
+		return usage.installs[i].dir < usage.installs[j].dir
+	})
+	return usage, nil
+}
+
+// the size of an install dir and its logs, counting the jars in it that aren't linked to the store
+func (sm *ServiceManager) scanInstall(dir string, running map[string]bool, inUse map[string]bool, jars map[jarKey]int) installUsage {
+	installDir := path.Join(sm.Config.TmpDir, dir)
+	usage := installUsage{dir: dir}
+
+	logDir := ""
+	if installFile, err := sm.Ledger.LoadInstallFile(installDir); err == nil {
+		usage.service, usage.version = installFile.Service, installFile.Version
+		usage.running = running[installFile.Service]
+		usage.orphan = !inUse[installDir]
+		logDir = sm.Services[installFile.Service].Output.logDir(installFile.Service, installFile.Path)
+	}
+
+	usage.install, _ = dirSize(installDir, jars)
+	if logDir != "" {
+		usage.logs, _ = dirSize(logDir, nil)
+		// logs are usually in the install dir, so they're not counted twice
+		if strings.HasPrefix(logDir, installDir+"/") {
+			usage.install -= usage.logs
+		}
+	}
+	return usage
+}
+
+// the size of the files in dir, with a hard linked file's size shared out between its links. Jars that
+// aren't linked are counted in jars, if it's given
+func dirSize(dir string, jars map[jarKey]int) (int64, error) {
+	total := int64(0)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// something unreadable, or deleted while it's scanned, isn't counted
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size := info.Size()
+		links := uint64(1)
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			links = uint64(stat.Nlink)
+		}
+		total += size / int64(links)
+		if jars != nil && links == 1 && strings.HasSuffix(file, ".jar") {
+			jars[jarKey{d.Name(), size}]++
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (sm *ServiceManager) PrintDiskUsage() error {
+	usage, err := sm.scanDiskUsage()
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", sm.Config.TmpDir, err)
+	}
+	printDiskUsage(os.Stdout, usage, sm.Config.TmpDir)
+	return nil
+}
+
+func printDiskUsage(out io.Writer, usage diskUsage, tmpDir string) {
+	fmt.Fprintf(out, " %-40s %-14s %10s %10s\n", "SERVICE", "VERSION", "INSTALL", "LOGS")
+	for _, i := range usage.installs {
+		name := i.service
+		if name == "" {
+			name = i.dir
+		}
+		if i.orphan {
+			name += " (not in config)"
+		}
+		fmt.Fprintf(out, " %-40s %-14s %10s %10s\n", name, i.version, formatSize(i.install), formatSize(i.logs))
+	}
+	if usage.shared > 0 {
+		fmt.Fprintf(out, " %-55s %10s\n", "shared jars, see -dedupe", formatSize(usage.shared))
+	}
+	fmt.Fprintf(out, " %-55s %10s\n", "sm2's own files", formatSize(usage.other))
+	fmt.Fprintf(out, "\n%s in %s\n", formatSize(usage.total()), tmpDir)
+
+	orphans, orphaned, idleLogs := 0, int64(0), int64(0)
+	for _, i := range usage.installs {
+		if i.orphan && !i.running {
+			orphans++
+			orphaned += i.install + i.logs
+		} else if !i.running {
+			idleLogs += i.logs
+		}
+	}
+	if orphans == 0 && usage.duplicates == 0 && idleLogs == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nCould be reclaimed:")
+	if orphans > 0 {
+		fmt.Fprintf(out, "  %10s  %d installs of services that are no longer in the config, which -prune removes\n", formatSize(orphaned), orphans)
+	}
+	if usage.duplicates > 0 {
+		fmt.Fprintf(out, "  %10s  roughly, by linking %d duplicate jars together with -dedupe\n", formatSize(usage.duplicated), usage.duplicates)
+	}
+	if idleLogs > 0 {
+		fmt.Fprintf(out, "  %10s  the logs of services that aren't running\n", formatSize(idleLogs))
+	}
+}
+
+// removes the installs of services that are no longer in the config, and aren't running
+func (sm *ServiceManager) pruneOrphanedInstalls() {
+	usage, err := sm.scanDiskUsage()
+	if err != nil {
+		fmt.Printf("Unable to read %s: %s\n", sm.Config.TmpDir, err)
+		return
+	}
+	for _, i := range usage.installs {
+		if !i.orphan || i.running || i.service == "" {
+			continue
+		}
+		// not while another sm2 is installing into it, see installlock.go
+		installDir := path.Join(sm.Config.TmpDir, i.dir)
+		unlock, _, err := sm.lockInstall(installDir, func() {})
+		if err != nil {
+			fmt.Printf("Unable to lock %s: %s\n", i.dir, err)
+			continue
+		}
+		err = os.RemoveAll(installDir)
+		unlock()
+		if err != nil {
+			fmt.Printf("Unable to remove %s: %s\n", i.dir, err)
+			continue
+		}
+		fmt.Printf("Removed %s %s, which is no longer in the config (%s)\n", i.service, i.version, formatSize(i.install+i.logs))
+	}
+	pruneDedupeStore(sm.dedupeStore())
+}
+
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1024*1024*1024))
+	case bytes >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/1024)
+	}
+}
@@ -0,0 +1,67 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(file string, size int) {
+		AssertNotErr(t, os.MkdirAll(path.Dir(path.Join(tmpDir, file)), 0755))
+		AssertNotErr(t, os.WriteFile(path.Join(tmpDir, file), bytes.Repeat([]byte("x"), size), 0644))
+	}
+	write("auth/auth-1.0.0/lib/scala-library.jar", 4000)
+	write("auth/auth-1.0.0/lib/auth.jar", 1000)
+	write("auth/auth-1.0.0/logs/stdout.txt", 500)
+	write("old/old-2.0.0/lib/scala-library.jar", 4000)
+	write("old/old-2.0.0/lib/old.jar", 2000)
+	write(".jars/shared.jar", 3000)
+	write(".inventory", 10)
+
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: tmpDir},
+		Ledger:   ledger.NewLedger(),
+		Services: Services{"AUTH": {Id: "AUTH", Binary: ServiceBinary{DestinationSubdir: "auth"}}},
+	}
+	AssertNotErr(t, sm.Ledger.SaveInstallFile(path.Join(tmpDir, "auth"), ledger.InstallFile{Service: "AUTH", Version: "1.0.0", Path: path.Join(tmpDir, "auth/auth-1.0.0")}))
+	AssertNotErr(t, sm.Ledger.SaveInstallFile(path.Join(tmpDir, "old"), ledger.InstallFile{Service: "OLD", Version: "2.0.0", Path: path.Join(tmpDir, "old/old-2.0.0")}))
+
+	usage, err := sm.scanDiskUsage()
+	AssertNotErr(t, err)
+	if len(usage.installs) != 2 {
+		t.Fatalf("expected 2 installs, got %+v", usage.installs)
+	}
+	auth, old := usage.installs[0], usage.installs[1]
+	if auth.service != "AUTH" || auth.orphan || auth.logs != 500 || auth.install < 5000 || auth.install > 6000 {
+		t.Errorf("expected auth's jars and logs to be counted separately, got %+v", auth)
+	}
+	if old.service != "OLD" || !old.orphan {
+		t.Errorf("expected OLD to be an orphan, got %+v", old)
+	}
+	if usage.shared != 3000 || usage.duplicates != 1 || usage.duplicated != 4000 {
+		t.Errorf("expected the shared and duplicate jars to be counted, got %+v", usage)
+	}
+
+	out := bytes.Buffer{}
+	printDiskUsage(&out, usage, tmpDir)
+	for _, expected := range []string{"OLD (not in config)", "2.0.0", "shared jars", "1 installs of services that are no longer in the config", "3.9 KB  roughly, by linking 1 duplicate jars"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected the report to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	sm.pruneOrphanedInstalls()
+	if _, err := os.Stat(path.Join(tmpDir, "old")); !os.IsNotExist(err) {
+		t.Errorf("expected OLD to be removed")
+	}
+	if _, err := os.Stat(path.Join(tmpDir, "auth")); err != nil {
+		t.Errorf("expected AUTH to be kept: %s", err)
+	}
+}
@@ -0,0 +1,191 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services with "runtime": "docker" are run as containers rather than downloaded from artifactory, e.g.
+//
+//	"PAYMENTS": {
+//	  "defaultPort": 9050,
+//	  "runtime": "docker",
+//	  "docker": {"image": "ghcr.io/example/payments:1.4.0", "containerPort": 9000, "env": {"MODE": "dev"}}
+//	}
+//
+// -docker runs every service that has a docker image as a container, whatever its runtime.
+// The container's port is published on the service's port, so ports, health checks, logs and status work
+// the same as for any other service. A version given on the command line (sm2 -start PAYMENTS:1.5.0) picks the tag.
+
+const DockerRuntime = "docker"
+
+type Docker struct {
+	Image         string            `json:"image"`         // e.g. ghcr.io/example/payments:1.4.0, the tag defaults to latest
+	ContainerPort int               `json:"containerPort"` // the port the service listens on inside the container, defaults to the service's port
+	Env           map[string]string `json:"env"`
+	Args          []string          `json:"args"` // passed to the container's entrypoint
+}
+
+func (sm *ServiceManager) runsInDocker(service Service) bool {
+	return service.Runtime == DockerRuntime || (sm.Commands.Docker && service.Docker.Image != "")
+}
+
+func containerName(serviceName string) string {
+	return "sm2-" + strings.ToLower(serviceName)
+}
+
+// the image with the requested tag in place of the configured one
+func dockerImage(service Service, version string) string {
+	image := service.Docker.Image
+	// the last : is a tag unless it's part of a registry host:port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		if version == "" {
+			return image
+		}
+		image = image[:i]
+	}
+	if version == "" {
+		version = "latest"
+	}
+	return image + ":" + version
+}
+
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+func (sm *ServiceManager) startContainer(service Service, installDir string, image string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return ledger.StateFile{}, fmt.Errorf("%s runs in docker, which isn't installed", service.Id)
+	}
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return ledger.StateFile{}, err
+	}
+	clearExitStatus(installDir)
+
+	// a container left behind by a crash would stop the new one starting with the same name
+	exec.Command("docker", "rm", "--force", containerName(service.Id)).Run()
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	bindAddress := sm.bindAddress(service)
+	args := dockerRunArgs(service, image, bindAddress, port)
+	// only the placeholders are kept in the state file
+	launchArgs, err := resolveSecrets(args, sm.lookupSecret)
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+
+	// docker run stays attached, so the container's output ends up in the service's logs
+	cmd := exec.Command("docker", launchArgs...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Artifact:       image,
+		Version:        imageTag(image),
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    bindAddress,
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            "docker",
+		Runtime:        DockerRuntime,
+	}, nil
+}
+
+func dockerRunArgs(service Service, image string, bindAddress string, port int) []string {
+	containerPort := service.Docker.ContainerPort
+	if containerPort == 0 {
+		containerPort = service.DefaultPort
+	}
+
+	args := []string{
+		"run", "--rm",
+		"--name", containerName(service.Id),
+		"--label", "sm2.service=" + service.Id,
+		"--publish", fmt.Sprintf("%s:%d", net.JoinHostPort(bindAddress, fmt.Sprint(port)), containerPort),
+	}
+
+	names := []string{}
+	for name := range service.Docker.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--env", name+"="+service.Docker.Env[name])
+	}
+
+	args = append(args, image)
+	args = append(args, service.Docker.Args...)
+	return fillPortArgs(args, port)
+}
+
+// pulls the image unless it's already there, or always when -clean is used
+func pullImage(image string, always bool) error {
+	if !always && exec.Command("docker", "image", "inspect", image).Run() == nil {
+		return nil
+	}
+	if out, err := exec.Command("docker", "pull", image).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to pull %s: %s", image, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// stopping the docker client would leave the container running, so it's stopped by name
+func stopContainer(serviceName string, pid int) {
+	if out, err := exec.Command("docker", "stop", containerName(serviceName)).CombinedOutput(); err != nil {
+		fmt.Printf("Unable to stop container %s, %s.\n", containerName(serviceName), strings.TrimSpace(string(out)))
+		stopPid(pid)
+	}
+}
+
+func (sm *ServiceManager) isContainer(serviceName string) bool {
+	installDir, err := sm.findInstallDirOfService(serviceName)
+	if err != nil {
+		return false
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	return err == nil && state.Runtime == DockerRuntime
+}
+
+// mistakes in docker config that would otherwise only show up when the container fails to start
+func lintDocker(id string, s Service) []string {
+	problems := []string{}
+	if s.Runtime == NativeRuntime && !isInfra(s) {
+		return append(problems, fmt.Sprintf("%s has runtime %s, which is only for mongo, redis and rabbitmq", id, s.Runtime))
+	}
+	if s.Runtime != "" && s.Runtime != DockerRuntime && s.Runtime != NativeRuntime {
+		return append(problems, fmt.Sprintf("%s has an unknown runtime %s", id, s.Runtime))
+	}
+	if s.Runtime == DockerRuntime && s.Docker.Image == "" {
+		problems = append(problems, fmt.Sprintf("%s runs in docker but has no docker.image", id))
+	}
+	return problems
+}
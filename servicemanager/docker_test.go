@@ -0,0 +1,128 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestDockerImage(t *testing.T) {
+	tests := []struct {
+		image    string
+		version  string
+		expected string
+	}{
+		{"ghcr.io/example/payments:1.4.0", "", "ghcr.io/example/payments:1.4.0"},
+		{"ghcr.io/example/payments:1.4.0", "1.5.0", "ghcr.io/example/payments:1.5.0"},
+		{"registry:5000/payments", "", "registry:5000/payments:latest"},
+		{"registry:5000/payments", "2.0.0", "registry:5000/payments:2.0.0"},
+	}
+	for _, test := range tests {
+		image := dockerImage(Service{Docker: Docker{Image: test.image}}, test.version)
+		if image != test.expected {
+			t.Errorf("expected %s got %s", test.expected, image)
+		}
+	}
+	if tag := imageTag("registry:5000/payments"); tag != "latest" {
+		t.Errorf("expected the registry port not to be taken as a tag, got %s", tag)
+	}
+}
+
+func TestRunsInDocker(t *testing.T) {
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: "/tmp/sm2"}}
+	container := Service{Id: "PAYMENTS", Runtime: DockerRuntime, Docker: Docker{Image: "payments"}}
+	both := Service{Id: "EMAIL", Docker: Docker{Image: "email"}, Binary: ServiceBinary{DestinationSubdir: "email"}}
+	sm.Services = Services{"PAYMENTS": container, "EMAIL": both}
+
+	if !sm.runsInDocker(container) || sm.runsInDocker(both) {
+		t.Errorf("expected only the docker runtime to run in docker")
+	}
+	sm.Commands = cli.UserOption{Docker: true}
+	if !sm.runsInDocker(both) || sm.runsInDocker(Service{Id: "AUTH"}) {
+		t.Errorf("expected -docker to run services with an image in docker")
+	}
+
+	if dir, _ := sm.findInstallDirOfService("PAYMENTS"); dir != "/tmp/sm2/payments-docker" {
+		t.Errorf("expected a directory of its own, got %s", dir)
+	}
+	if dir, _ := sm.findInstallDirOfService("EMAIL"); dir != "/tmp/sm2/email" {
+		t.Errorf("expected the usual directory, got %s", dir)
+	}
+
+	problems := append(lintDocker("PAYMENTS", Service{Runtime: DockerRuntime}), lintDocker("EMAIL", Service{Runtime: "podman"})...)
+	expected := []string{"PAYMENTS runs in docker but has no docker.image", "EMAIL has an unknown runtime podman"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected %v got %v", expected, problems)
+	}
+}
+
+func TestDockerRunArgs(t *testing.T) {
+	service := Service{
+		Id:          "PAYMENTS",
+		DefaultPort: 9050,
+		Docker: Docker{
+			Image:         "payments:1.4.0",
+			ContainerPort: 9000,
+			Env:           map[string]string{"MODE": "dev", "API_KEY": "${secret:PAYMENTS_KEY}"},
+			Args:          []string{"-Dhttp.port=9000"},
+		},
+	}
+	args := dockerRunArgs(service, "payments:1.4.0", DefaultBindAddress, 41000)
+	expected := []string{
+		"run", "--rm",
+		"--name", "sm2-payments",
+		"--label", "sm2.service=PAYMENTS",
+		"--publish", "127.0.0.1:41000:9000",
+		"--env", "API_KEY=${secret:PAYMENTS_KEY}",
+		"--env", "MODE=dev",
+		"payments:1.4.0",
+		"-Dhttp.port=9000",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v\ngot %v", expected, args)
+	}
+
+	service.Docker.ContainerPort = 0
+	args = dockerRunArgs(service, "payments:1.4.0", "::1", 9050)
+	if !strings.Contains(strings.Join(args, " "), "--publish [::1]:9050:9050") {
+		t.Errorf("expected the container port to default to the service's port, got %v", args)
+	}
+}
+
+func TestStartContainer(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-docker*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	// a fake docker that logs what it was asked to do
+	bin := path.Join(dir, "bin")
+	AssertNotErr(t, os.MkdirAll(bin, 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(bin, "docker"), []byte("#!/bin/sh\necho fake docker $*\n"), 0755))
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: dir}}
+	service := Service{Id: "PAYMENTS", Runtime: DockerRuntime, DefaultPort: 9050, Docker: Docker{Image: "payments:1.4.0"}}
+	installDir := path.Join(dir, "payments-docker")
+
+	state, err := sm.startContainer(service, installDir, "payments:1.4.0", 9050, defaultHealthcheckUrl(9050))
+	AssertNotErr(t, err)
+	if state.Version != "1.4.0" || state.Artifact != "payments:1.4.0" || state.Runtime != DockerRuntime {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	logs := ""
+	for i := 0; i < 20 && !strings.Contains(logs, "fake docker run"); i++ {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := os.ReadFile(service.Output.stdoutFile(service.Output.logDir(service.Id, installDir)))
+		logs = string(data)
+	}
+	if !strings.Contains(logs, "fake docker run --rm --name sm2-payments") {
+		t.Errorf("expected the container's output in the logs, got %s", logs)
+	}
+}
@@ -0,0 +1,88 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// With --dynamic-ports each service is started on a free port rather than the one in services.json, so
+// copies of the same services (e.g. from two workspaces) can run side by side. The port is recorded in
+// the service's state file, which is what status, health checks and stop use. Args and healthcheck urls
+// can refer to the port as ${PORT} (or ${port}), e.g. "-Dmetrics.port=${PORT}".
+
+// the port a service should be started on
+func (sm *ServiceManager) assignPort(service Service) (int, error) {
+	port, err := sm.choosePort(service)
+	if err != nil {
+		return port, err
+	}
+	// so another sm2 in the workspace doesn't give it to a different service, see workspacelock.go
+	err = sm.claimPort(service.Id, port)
+	for attempt := 0; err != nil && sm.isDynamic() && attempt < 10; attempt++ {
+		if port, err = freePort(); err == nil {
+			err = sm.claimPort(service.Id, port)
+		}
+	}
+	return port, err
+}
+
+func (sm *ServiceManager) choosePort(service Service) (int, error) {
+	if planned, ok := sm.portPlan[service.Id]; ok {
+		return planned.port, planned.err
+	}
+	if sm.isDynamic() {
+		return freePort()
+	}
+	return sm.findPort(service), nil
+}
+
+func (sm *ServiceManager) isDynamic() bool {
+	return sm.Commands.DynamicPorts && sm.Commands.Port <= 0
+}
+
+// asks the os for a port nothing is listening on, over ipv4 or ipv6
+func freePort() (int, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, fmt.Errorf("unable to find a free port: %s", err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		if !portInUse(port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to find a port that's free on both 127.0.0.1 and ::1")
+}
+
+// true if the service is already up, either on its usual port or, with --dynamic-ports, the one it was last started on
+func (sm *ServiceManager) isAlreadyRunning(service Service) bool {
+	if !sm.Commands.DynamicPorts {
+		return sm.CheckHealth(sm.serviceHealthcheckUrl(service, sm.findPort(service)))
+	}
+
+	installDir, err := sm.findInstallDirOfService(service.Id)
+	if err != nil {
+		return false
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil || state.HealthcheckUrl == "" {
+		return false
+	}
+	return sm.CheckHealth(state.HealthcheckUrl)
+}
+
+func fillPort(value string, port int) string {
+	value = strings.ReplaceAll(value, "${PORT}", fmt.Sprint(port))
+	return strings.ReplaceAll(value, "${port}", fmt.Sprint(port))
+}
+
+func fillPortArgs(args []string, port int) []string {
+	filled := make([]string, len(args))
+	for i, arg := range args {
+		filled[i] = fillPort(arg, port)
+	}
+	return filled
+}
@@ -0,0 +1,47 @@
+package servicemanager
+
+import (
+	"reflect"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestAssignPort(t *testing.T) {
+	service := Service{Id: "FOO", DefaultPort: 9000}
+
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: t.TempDir()}}
+	if port, _ := sm.assignPort(service); port != 9000 {
+		t.Errorf("expected the default port, got %d", port)
+	}
+
+	sm.Commands = cli.UserOption{DynamicPorts: true}
+	port, err := sm.assignPort(service)
+	AssertNotErr(t, err)
+	if port <= 0 || port == 9000 {
+		t.Errorf("expected a free port, got %d", port)
+	}
+
+	// an explicit --port still wins
+	sm.Commands = cli.UserOption{DynamicPorts: true, Port: 1234}
+	if port, _ := sm.assignPort(service); port != 1234 {
+		t.Errorf("expected the port from --port, got %d", port)
+	}
+}
+
+func TestFillPort(t *testing.T) {
+	args := []string{"-Dmetrics.port=${PORT}", "-Dadmin.url=http://localhost:${port}/admin", "-Dfoo=bar"}
+	expected := []string{"-Dmetrics.port=8123", "-Dadmin.url=http://localhost:8123/admin", "-Dfoo=bar"}
+	if filled := fillPortArgs(args, 8123); !reflect.DeepEqual(filled, expected) {
+		t.Errorf("expected %v got %v", expected, filled)
+	}
+	if args[0] != "-Dmetrics.port=${PORT}" {
+		t.Errorf("expected the original args to be unchanged")
+	}
+
+	service := Service{Healthcheck: Healthcheck{Url: "http://localhost:${PORT}/ping"}}
+	if url := findHealthcheckUrl(service, 8123); url != "http://localhost:8123/ping" {
+		t.Errorf("wrong healthcheck url: %s", url)
+	}
+}
@@ -0,0 +1,98 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Some start scripts daemonise the service, forking it and exiting, so the pid sm2 recorded dies straight away
+// while the service carries on. When a service's pid has gone its real one is looked for, first in the
+// RUNNING_PID file play writes to the service's dir, then by the -Dservice.manager.serviceName arg sm2 starts
+// every release with. A process only counts if it has that arg and was started after the service was. The one
+// that's found is adopted, i.e. saved in the state file, so status, stop etc use it from then on.
+
+// the process the service forked, or false when there isn't one (it's died)
+func (sm *ServiceManager) findForkedPid(state ledger.StateFile, pids map[int]int) (int, time.Time, bool) {
+	if state.Runtime != "" || state.Version == SOURCE || sm.Platform.ProcessInfo == nil {
+		return 0, time.Time{}, false
+	}
+	marker := "-Dservice.manager.serviceName=" + state.Service
+	hasMarker := false
+	for _, arg := range state.Args {
+		hasMarker = hasMarker || arg == marker
+	}
+	if !hasMarker {
+		return 0, time.Time{}, false
+	}
+
+	isService := func(pid int) (time.Time, bool) {
+		if _, running := pids[pid]; !running || pid == state.Pid {
+			return time.Time{}, false
+		}
+		info, err := sm.Platform.ProcessInfo(pid)
+		if err != nil || !strings.Contains(info.Args, marker) || info.Started.Before(state.Started.Add(-pidStartSlack)) {
+			return time.Time{}, false
+		}
+		return info.Started, true
+	}
+
+	if pid, ok := readRunningPid(state.Path); ok {
+		if started, ok := isService(pid); ok {
+			return pid, started, true
+		}
+	}
+	if sm.Platform.PidLookupByService == nil {
+		return 0, time.Time{}, false
+	}
+	_, byService := sm.Platform.PidLookupByService(state.Service)
+
+	// when the script leaves more than one process (e.g. a wrapper and the jvm), the first started is the service's
+	best, bestStarted := 0, time.Time{}
+	for _, pid := range byService {
+		if started, ok := isService(pid); ok && (best == 0 || started.Before(bestStarted)) {
+			best, bestStarted = pid, started
+		}
+	}
+	return best, bestStarted, best != 0
+}
+
+// takes over the forked process as the service's, updating its state file
+func (sm *ServiceManager) adoptForkedPid(state ledger.StateFile, pid int, started time.Time) ledger.StateFile {
+	previous := state.Pid
+	state.Pid = pid
+	// so it's not mistaken for a reused pid, see stalepid.go
+	if started.After(state.Started) {
+		state.Started = started
+	}
+	if sm.Ledger.SaveStateFile != nil && sm.Ledger.LoadStateFile != nil {
+		if installDir, err := sm.findInstallDirOfService(state.Service); err == nil {
+			sm.updateState(installDir, func(saved *ledger.StateFile) error {
+				// unless it's been restarted since
+				if saved.Pid != previous {
+					return fmt.Errorf("%s has been restarted", saved.Service)
+				}
+				saved.Pid, saved.Started = state.Pid, state.Started
+				return nil
+			})
+		}
+	}
+	return state
+}
+
+func readRunningPid(serviceDir string) (int, bool) {
+	if serviceDir == "" {
+		return 0, false
+	}
+	data, err := os.ReadFile(path.Join(serviceDir, "RUNNING_PID"))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil && pid > 0
+}
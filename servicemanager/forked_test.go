@@ -0,0 +1,86 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestStatusFollowsAServiceThatForked(t *testing.T) {
+	started := time.Now().Add(-time.Minute).Truncate(time.Second)
+	processes := map[int]platform.ProcessInfo{
+		200: {Started: started.Add(time.Second), Args: "java -Dservice.manager.serviceName=FOO -cp ..."},
+		201: {Started: started.Add(2 * time.Second), Args: "java -Dservice.manager.serviceName=FOO -cp ..."},
+		300: {Started: started.Add(-time.Hour), Args: "java -Dservice.manager.serviceName=FOO -cp ..."},
+	}
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: t.TempDir()},
+		Services: map[string]Service{"FOO": {Id: "FOO", Binary: ServiceBinary{DestinationSubdir: "foo"}}},
+		Ledger:   ledger.NewLedger(),
+		Platform: platform.Platform{
+			Uptime:    func() time.Time { return started.Add(-time.Hour * 24) },
+			PidLookup: func() map[int]int { return map[int]int{200: 200, 201: 201, 300: 300} },
+			// 300 is a copy left over from before it was started
+			PidLookupByService: func(string) (bool, []int) { return true, []int{300, 201, 200} },
+			ProcessInfo: func(pid int) (platform.ProcessInfo, error) {
+				if info, ok := processes[pid]; ok {
+					return info, nil
+				}
+				return platform.ProcessInfo{}, fmt.Errorf("pid %d isn't running", pid)
+			},
+		},
+	}
+	installDir := path.Join(sm.Config.TmpDir, "foo")
+	serviceDir := path.Join(installDir, "foo-1.0.0")
+	AssertNotErr(t, os.MkdirAll(serviceDir, 0755))
+	state := ledger.StateFile{
+		Service: "FOO",
+		Version: "1.0.0",
+		Path:    serviceDir,
+		Pid:     100, // the start script, which has exited
+		Port:    8500,
+		Started: started,
+		Args:    []string{"-Dservice.manager.serviceName=FOO"},
+	}
+	AssertNotErr(t, sm.Ledger.SaveStateFile(installDir, state))
+
+	pid, _, found := sm.findForkedPid(state, sm.Platform.PidLookup())
+	if !found || pid != 200 {
+		t.Errorf("expected the first process started by the service to be found, got %d %v", pid, found)
+	}
+
+	// play's RUNNING_PID is trusted over the args
+	AssertNotErr(t, os.WriteFile(path.Join(serviceDir, "RUNNING_PID"), []byte("201\n"), 0644))
+	pid, forked, found := sm.findForkedPid(state, sm.Platform.PidLookup())
+	if !found || pid != 201 {
+		t.Errorf("expected the pid in RUNNING_PID to be found, got %d %v", pid, found)
+	}
+
+	adopted := sm.adoptForkedPid(state, pid, forked)
+	saved, err := sm.Ledger.LoadStateFile(installDir)
+	AssertNotErr(t, err)
+	if saved.Pid != 201 || adopted.Pid != 201 || !saved.Started.Equal(forked) {
+		t.Errorf("expected the forked pid to be saved in the state file, got %v", saved)
+	}
+	if err := checkProcess(saved, sm.Platform.ProcessInfo); err != nil {
+		t.Errorf("expected the adopted pid to be stoppable, got %s", err)
+	}
+
+	// another sm2 has restarted it since its state was read
+	sm.adoptForkedPid(state, 200, forked)
+	if saved, _ := sm.Ledger.LoadStateFile(installDir); saved.Pid != 201 {
+		t.Errorf("expected a state file that's changed since it was read to be left alone, got pid %d", saved.Pid)
+	}
+
+	// services that weren't started with their name in their args aren't followed
+	state.Args = nil
+	if _, _, found := sm.findForkedPid(state, sm.Platform.PidLookup()); found {
+		t.Errorf("expected a service without its name in its args not to be followed")
+	}
+}
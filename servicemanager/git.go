@@ -2,7 +2,6 @@ package servicemanager
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -45,14 +44,6 @@ func gitLastCommit(repoDir string) (string, error) {
 	return string(out), err
 }
 
-func gitPull(repoDir string) error {
-	cmd := exec.Command("git", "pull", "--quiet")
-	cmd.Dir = repoDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 func gitFetch(repoDir string, remote string, branch string) error {
 	cmd := exec.Command("git", "fetch", "--quiet", remote, branch)
 	cmd.Dir = repoDir
@@ -81,3 +72,55 @@ func gitShowShortRef(repoDir string, ref string) (string, error) {
 	}
 	return strings.Trim(string(out), "\n "), nil
 }
+
+// returns the full commit id of HEAD
+func gitHead(repoDir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(out), "\n "), nil
+}
+
+// true if the repo was cloned with --depth
+func gitIsShallow(repoDir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	return err == nil && strings.Trim(string(out), "\n ") == "true"
+}
+
+// fetches a ref into FETCH_HEAD, only fetching the latest commit if shallow is set
+func gitFetchRef(repoDir string, ref string, shallow bool) error {
+	args := []string{"fetch", "--quiet"}
+	if shallow {
+		args = append(args, "--depth", "1")
+	}
+	cmd := exec.Command("git", append(args, "origin", ref)...)
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git fetch failed: %s", strings.Trim(string(out), "\n "))
+	}
+	return nil
+}
+
+// runs a git command that changes the working copy, returning its output as the error if it fails
+func gitRun(repoDir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %s", args[0], strings.Trim(string(out), "\n "))
+	}
+	return nil
+}
+
+// returns the contents of a file at a given commit
+func gitShowFile(repoDir string, ref string, file string) ([]byte, error) {
+	cmd := exec.Command("git", "show", ref+":"+file)
+	cmd.Dir = repoDir
+	return cmd.Output()
+}
@@ -0,0 +1,458 @@
+package servicemanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With -grpc the daemon serves the api described in sm2.proto over grpc, so tooling can be pushed events
+// and log lines as they happen rather than polling. sm2 has no external dependencies, so rather than
+// pulling in grpc-go we implement just enough of the protocol: http/2 over tls (using the certificate
+// authority -https creates), uncompressed messages, and the handful of protobuf types sm2.proto uses.
+// It needs the same token as the rest api, e.g.
+//
+//	grpcurl -cacert $WORKSPACE/certs/sm2-ca.pem -proto servicemanager/sm2.proto \
+//	  -H "authorization: Bearer $TOKEN" localhost:8998 sm2.v1.ServiceManager/WatchEvents
+
+const (
+	defaultGrpcPort    = 8998
+	grpcServicePrefix  = "/sm2.v1.ServiceManager/"
+	maxGrpcMessageSize = 1024 * 1024
+	logFollowInterval  = 500 * time.Millisecond
+)
+
+// https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOk              = 0
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+	grpcUnauthenticated = 16
+)
+
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e grpcError) Error() string {
+	return e.message
+}
+
+func (api *adminApi) serveGrpc(port int, out io.Writer) (string, error) {
+	if port == 0 {
+		port = defaultGrpcPort
+	}
+	// grpc clients only speak http/2, which go only serves over tls
+	cert, err := api.sm.proxyCertificate(nil, out)
+	if err != nil {
+		return "", err
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return "", err
+	}
+
+	server := &http.Server{
+		Handler:   api.grpcHandler(),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	go func() {
+		if err := server.ServeTLS(listener, "", ""); err != nil {
+			fmt.Fprintf(out, "The grpc api stopped: %s\n", err)
+		}
+	}()
+	return fmt.Sprintf("localhost:%d", port), nil
+}
+
+func (api *adminApi) grpcHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "this is the sm2 grpc api, see sm2.proto", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+
+		err := api.grpcCall(w, req)
+		code, message := grpcOk, ""
+		if err != nil {
+			code, message = grpcInternal, err.Error()
+			var e grpcError
+			if errors.As(err, &e) {
+				code = e.code
+			}
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+		if message != "" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(message))
+		}
+	})
+}
+
+func (api *adminApi) grpcCall(w http.ResponseWriter, req *http.Request) error {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !api.validToken(token) {
+		return grpcError{grpcUnauthenticated, "a valid token is needed in the authorization metadata"}
+	}
+
+	data, err := readGrpcMessage(req.Body)
+	if err != nil {
+		return grpcError{grpcInvalidArgument, err.Error()}
+	}
+	fields, err := decodeProto(data)
+	if err != nil {
+		return grpcError{grpcInvalidArgument, err.Error()}
+	}
+
+	send := func(message protoMessage) error {
+		if err := writeGrpcMessage(w, message); err != nil {
+			return err
+		}
+		w.(http.Flusher).Flush()
+		return nil
+	}
+
+	method := strings.TrimPrefix(req.URL.Path, grpcServicePrefix)
+	switch method {
+	case "ListServices":
+		api.lock.Lock()
+		defer api.lock.Unlock()
+		list := protoMessage{}
+		for _, status := range api.sm.findStatuses() {
+			list = list.message(1, encodeService(api.toApiService(status)))
+		}
+		return send(list)
+	case "StartService", "StopService", "RestartService":
+		api.lock.Lock()
+		defer api.lock.Unlock()
+		id, err := api.grpcServiceId(fields)
+		if err != nil {
+			return err
+		}
+		action := strings.ToLower(strings.TrimSuffix(method, "Service"))
+		service, err := api.change(action, ServiceAndVersion{service: id, version: fields.string(2)})
+		if err != nil {
+			return err
+		}
+		return send(encodeService(service))
+	case "WatchEvents":
+		return api.grpcWatchEvents(req.Context(), fields.strings(1), send)
+	case "TailLogs":
+		return api.grpcTailLogs(req.Context(), fields, send)
+	}
+	return grpcError{grpcUnimplemented, "unknown method " + req.URL.Path}
+}
+
+func (api *adminApi) grpcServiceId(fields protoFields) (string, error) {
+	id := api.sm.renamedService(fields.string(1))
+	if _, ok := api.sm.Services[id]; !ok {
+		return "", grpcError{grpcNotFound, id + " is not a service"}
+	}
+	return id, nil
+}
+
+func (api *adminApi) grpcWatchEvents(ctx context.Context, services []string, send func(protoMessage) error) error {
+	wanted := map[string]bool{}
+	for _, service := range services {
+		wanted[service] = true
+	}
+
+	events := api.subscribe()
+	defer api.unsubscribe(events)
+	for {
+		select {
+		case event := <-events:
+			if len(wanted) > 0 && !wanted[event.Service] {
+				continue
+			}
+			if err := send(encodeEvent(event)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (api *adminApi) grpcTailLogs(ctx context.Context, fields protoFields, send func(protoMessage) error) error {
+	api.lock.Lock()
+	id, err := api.grpcServiceId(fields)
+	logFile := ""
+	if err == nil {
+		logFile, err = api.sm.findLogFile(id)
+	}
+	api.lock.Unlock()
+	if err != nil {
+		return grpcError{grpcNotFound, err.Error()}
+	}
+
+	lines := int(fields.int(2))
+	if lines <= 0 {
+		lines = defaultLogLines
+	}
+	// anything written while the tail is read is sent twice rather than not at all
+	var offset int64
+	if stat, err := os.Stat(logFile); err == nil {
+		offset = stat.Size()
+	}
+	tail, err := tailFile(logFile, lines)
+	if err != nil {
+		return err
+	}
+	for _, line := range tail {
+		if err := send(protoMessage{}.string(1, line)); err != nil {
+			return err
+		}
+	}
+
+	if !fields.bool(3) {
+		return nil
+	}
+	return followFile(ctx, logFile, offset, logFollowInterval, func(line string) error {
+		return send(protoMessage{}.string(1, line))
+	})
+}
+
+// sends each line added to a file after offset until ctx is done. The file is read from the start again
+// if it gets shorter, as a service's log does when it's restarted.
+func followFile(ctx context.Context, file string, offset int64, interval time.Duration, send func(string) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	partial := []byte{}
+	for {
+		if stat, err := os.Stat(file); err == nil {
+			if stat.Size() < offset {
+				offset = 0
+				partial = partial[:0]
+			}
+			if stat.Size() > offset {
+				data, err := readFrom(file, offset, stat.Size()-offset)
+				if err != nil {
+					return err
+				}
+				offset += int64(len(data))
+				partial = append(partial, data...)
+				for {
+					i := bytes.IndexByte(partial, '\n')
+					if i < 0 {
+						break
+					}
+					if err := send(string(partial[:i])); err != nil {
+						return err
+					}
+					partial = partial[i+1:]
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func readFrom(file string, offset int64, n int64) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(f, n))
+}
+
+func encodeService(s apiService) protoMessage {
+	return protoMessage{}.
+		string(1, s.Service).
+		string(2, s.Name).
+		bool(3, s.Running).
+		string(4, s.Version).
+		varint(5, uint64(s.Port)).
+		varint(6, uint64(s.Pid)).
+		string(7, s.Health).
+		string(8, s.BindAddress)
+}
+
+func encodeEvent(e apiEvent) protoMessage {
+	return protoMessage{}.
+		varint(1, uint64(e.Time.UnixMilli())).
+		string(2, e.Type).
+		string(3, e.Service).
+		string(4, e.Message)
+}
+
+// each message is prefixed with whether it's compressed and its length
+func readGrpcMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("couldn't read the request: %s", err)
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed messages aren't supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxGrpcMessageSize {
+		return nil, fmt.Errorf("the request is too big")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("couldn't read the request: %s", err)
+	}
+	return data, nil
+}
+
+func writeGrpcMessage(w io.Writer, message []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+// grpc-message is percent encoded
+func grpcEscape(message string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// A protobuf message being encoded. Fields with their default value are left out, as proto3 does.
+type protoMessage []byte
+
+func (m protoMessage) varint(field int, v uint64) protoMessage {
+	if v == 0 {
+		return m
+	}
+	m = binary.AppendUvarint(m, uint64(field)<<3)
+	return binary.AppendUvarint(m, v)
+}
+
+func (m protoMessage) bool(field int, v bool) protoMessage {
+	if !v {
+		return m
+	}
+	return m.varint(field, 1)
+}
+
+func (m protoMessage) string(field int, v string) protoMessage {
+	if v == "" {
+		return m
+	}
+	return m.bytes(field, []byte(v))
+}
+
+// an embedded message, which is kept even when it's empty so repeated fields keep their length
+func (m protoMessage) message(field int, v protoMessage) protoMessage {
+	return m.bytes(field, v)
+}
+
+func (m protoMessage) bytes(field int, v []byte) protoMessage {
+	m = binary.AppendUvarint(m, uint64(field)<<3|2)
+	m = binary.AppendUvarint(m, uint64(len(v)))
+	return append(m, v...)
+}
+
+// The fields of a decoded protobuf message by number. Varints are kept as uint64s and length delimited
+// fields as []byte, anything else is skipped.
+type protoFields map[int][]interface{}
+
+func decodeProto(data []byte) (protoFields, error) {
+	fields := protoFields{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid protobuf message")
+		}
+		data = data[n:]
+		field := int(tag >> 3)
+
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", field)
+			}
+			fields[field] = append(fields[field], v)
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("invalid fixed64 in field %d", field)
+			}
+			data = data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, fmt.Errorf("invalid length in field %d", field)
+			}
+			fields[field] = append(fields[field], data[n:n+int(size)])
+			data = data[n+int(size):]
+		case 5:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("invalid fixed32 in field %d", field)
+			}
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", tag&7, field)
+		}
+	}
+	return fields, nil
+}
+
+// the last value of a singular field wins
+func (f protoFields) string(field int) string {
+	values := f[field]
+	if len(values) == 0 {
+		return ""
+	}
+	b, _ := values[len(values)-1].([]byte)
+	return string(b)
+}
+
+func (f protoFields) strings(field int) []string {
+	values := []string{}
+	for _, v := range f[field] {
+		if b, ok := v.([]byte); ok {
+			values = append(values, string(b))
+		}
+	}
+	return values
+}
+
+func (f protoFields) int(field int) int64 {
+	values := f[field]
+	if len(values) == 0 {
+		return 0
+	}
+	v, _ := values[len(values)-1].(uint64)
+	return int64(v)
+}
+
+func (f protoFields) bool(field int) bool {
+	return f.int(field) != 0
+}
@@ -0,0 +1,140 @@
+package servicemanager
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestGrpcApi(t *testing.T) {
+	tmp := t.TempDir()
+	sm := ServiceManager{
+		Client:   &http.Client{Timeout: 100 * time.Millisecond},
+		Config:   ServiceManagerConfig{TmpDir: tmp},
+		Services: Services{"AUTH": {Id: "AUTH", Name: "Auth", DefaultPort: 1}},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{{Service: "AUTH", Version: "1.0.0", Port: 8500, Pid: 1, Started: time.Now()}}, nil
+		}},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{1: 1} },
+			Uptime:    func() time.Time { return time.Now().Add(-time.Hour) },
+		},
+	}
+	api := newAdminApi(&sm, "secret")
+	server := httptest.NewUnstartedServer(api.grpcHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(method string, token string, request protoMessage) ([]protoFields, string, string) {
+		body := bytes.Buffer{}
+		AssertNotErr(t, writeGrpcMessage(&body, request))
+		req, _ := http.NewRequest("POST", server.URL+grpcServicePrefix+method, &body)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := server.Client().Do(req)
+		AssertNotErr(t, err)
+		defer res.Body.Close()
+		if res.ProtoMajor != 2 {
+			t.Fatalf("expected http/2, got %s", res.Proto)
+		}
+
+		messages := []protoFields{}
+		for {
+			data, err := readGrpcMessage(res.Body)
+			if err != nil {
+				break
+			}
+			fields, err := decodeProto(data)
+			AssertNotErr(t, err)
+			messages = append(messages, fields)
+		}
+		io.Copy(io.Discard, res.Body)
+		return messages, res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	}
+
+	if _, status, _ := call("ListServices", "wrong", protoMessage{}); status != "16" {
+		t.Errorf("expected a wrong token to be unauthenticated, got %s", status)
+	}
+
+	messages, status, _ := call("ListServices", "secret", protoMessage{})
+	if status != "0" || len(messages) != 1 {
+		t.Fatalf("expected one response, got %s %v", status, messages)
+	}
+	services := messages[0].strings(1)
+	if len(services) != 1 {
+		t.Fatalf("expected one service, got %v", services)
+	}
+	service, err := decodeProto([]byte(services[0]))
+	AssertNotErr(t, err)
+	if service.string(1) != "AUTH" || service.string(2) != "Auth" || !service.bool(3) || service.string(4) != "1.0.0" || service.int(5) != 8500 {
+		t.Errorf("unexpected service %v", service)
+	}
+
+	if _, status, message := call("StopService", "secret", protoMessage{}.string(1, "NOPE")); status != "5" || message != "NOPE is not a service" {
+		t.Errorf("expected an unknown service not to be found, got %s %s", status, message)
+	}
+	if _, status, _ := call("Nope", "secret", protoMessage{}); status != "12" {
+		t.Errorf("expected an unknown method to be unimplemented, got %s", status)
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	message := protoMessage{}.string(1, "AUTH").varint(2, 300).bool(3, true).string(4, "").string(5, "a").string(5, "b")
+	fields, err := decodeProto(message)
+	AssertNotErr(t, err)
+	if fields.string(1) != "AUTH" || fields.int(2) != 300 || !fields.bool(3) || fields.string(4) != "" || len(fields.strings(5)) != 2 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	if _, err := decodeProto([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Errorf("expected a truncated message to fail")
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "stdout.log")
+	AssertNotErr(t, os.WriteFile(file, []byte("old\n"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan string, 10)
+	done := make(chan error)
+	go func() {
+		done <- followFile(ctx, file, 4, 10*time.Millisecond, func(line string) error {
+			lines <- line
+			return nil
+		})
+	}()
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	AssertNotErr(t, err)
+	f.WriteString("one\ntw")
+	f.Sync()
+	if line := <-lines; line != "one" {
+		t.Errorf("expected one, got %s", line)
+	}
+	f.WriteString("o\n")
+	f.Close()
+	if line := <-lines; line != "two" {
+		t.Errorf("expected two, got %s", line)
+	}
+
+	// a restarted service starts a new log
+	AssertNotErr(t, os.WriteFile(file, []byte("new\n"), 0644))
+	if line := <-lines; line != "new" {
+		t.Errorf("expected new, got %s", line)
+	}
+
+	cancel()
+	AssertNotErr(t, <-done)
+}
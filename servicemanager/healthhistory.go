@@ -0,0 +1,171 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"sm2/ledger"
+)
+
+const (
+	healthHistorySize = 360                     // samples kept per service, an hour's worth at the default interval
+	healthWindow      = 60                      // how many of the most recent samples are used to judge a service
+	slowLatency       = 1000 * time.Millisecond // a p95 above this is considered slow
+	flakyTransitions  = 2                       // times a service has to go from healthy to failing to be flaky
+)
+
+const (
+	VERDICT_OK    = "OK"
+	VERDICT_SLOW  = "SLOW"
+	VERDICT_FLAKY = "FLAKY"
+	VERDICT_DOWN  = "DOWN"
+)
+
+type healthSummary struct {
+	service  string
+	samples  int
+	failures int
+	p50      time.Duration
+	p95      time.Duration
+	verdict  string
+}
+
+// probes every running service and appends the result to the health history. The history of services that
+// have stopped is dropped, otherwise they'd be reported on how they were when they were last running forever
+func (sm *ServiceManager) recordHealth() error {
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return err
+	}
+
+	history, err := sm.Ledger.LoadHealthHistory(sm.Config.TmpDir)
+	if err != nil {
+		// a corrupt history isn't worth failing over, start again
+		history = ledger.HealthHistory{}
+	}
+
+	pids := sm.Platform.PidLookup()
+	running := map[string]bool{}
+	for _, state := range states {
+		if _, ok := pids[state.Pid]; !ok {
+			continue
+		}
+		running[state.Service] = true
+
+		url := state.HealthcheckUrl
+		if url == "" {
+			url = defaultHealthcheckUrl(state.Port)
+		}
+
+		healthy, latency := sm.checkHealthTimed(url)
+		sm.statuses.record(state, url, healthy)
+		history[state.Service] = appendSample(history[state.Service], ledger.HealthSample{
+			Time:    time.Now(),
+			Latency: latency,
+			Healthy: healthy,
+		})
+	}
+	for service := range history {
+		if !running[service] {
+			delete(history, service)
+		}
+	}
+
+	return sm.Ledger.SaveHealthHistory(sm.Config.TmpDir, history)
+}
+
+func appendSample(samples []ledger.HealthSample, sample ledger.HealthSample) []ledger.HealthSample {
+	samples = append(samples, sample)
+	if len(samples) > healthHistorySize {
+		samples = samples[len(samples)-healthHistorySize:]
+	}
+	return samples
+}
+
+// works out if a service is healthy, slow, flaky or down based on its recent health checks
+func summarizeHealth(service string, samples []ledger.HealthSample) healthSummary {
+	if len(samples) > healthWindow {
+		samples = samples[len(samples)-healthWindow:]
+	}
+
+	summary := healthSummary{service: service, samples: len(samples), verdict: VERDICT_OK}
+	if len(samples) == 0 {
+		return summary
+	}
+
+	latencies := []time.Duration{}
+	transitions := 0
+	for i, s := range samples {
+		if s.Healthy {
+			latencies = append(latencies, s.Latency)
+		} else {
+			summary.failures++
+			if i > 0 && samples[i-1].Healthy {
+				transitions++
+			}
+		}
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summary.p50 = latencies[(len(latencies)-1)*50/100]
+		summary.p95 = latencies[(len(latencies)-1)*95/100]
+	}
+
+	if !samples[len(samples)-1].Healthy && transitions < flakyTransitions {
+		summary.verdict = VERDICT_DOWN
+	} else if transitions >= flakyTransitions {
+		summary.verdict = VERDICT_FLAKY
+	} else if summary.p95 > slowLatency {
+		summary.verdict = VERDICT_SLOW
+	}
+
+	return summary
+}
+
+func (sm *ServiceManager) PrintHealthReport() {
+	history, err := sm.Ledger.LoadHealthHistory(sm.Config.TmpDir)
+	if err != nil {
+		fmt.Printf("Unable to read the health history: %s\n", err)
+		return
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No health checks have been recorded yet. Health checks are recorded while `sm2 --daemon` is running.")
+		return
+	}
+
+	summaries := []healthSummary{}
+	for service, samples := range history {
+		summaries = append(summaries, summarizeHealth(service, samples))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].service < summaries[j].service
+	})
+
+	printHealthReport(summaries, os.Stdout)
+}
+
+func printHealthReport(summaries []healthSummary, out io.Writer) {
+	longest := 20
+	for _, s := range summaries {
+		if len(s.service) > longest {
+			longest = len(s.service)
+		}
+	}
+
+	fmt.Fprintf(out, "%s  %7s  %8s  %8s  %8s  %s\n", pad("Service", longest), "Checks", "Failures", "p50", "p95", "Verdict")
+	for _, s := range summaries {
+		fmt.Fprintf(out, "%s  %7d  %8d  %8s  %8s  %s\n", pad(s.service, longest), s.samples, s.failures, formatLatency(s.p50), formatLatency(s.p95), s.verdict)
+	}
+}
+
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
@@ -0,0 +1,101 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func samplesFrom(pattern string, latency time.Duration) []ledger.HealthSample {
+	samples := []ledger.HealthSample{}
+	for _, c := range pattern {
+		samples = append(samples, ledger.HealthSample{Time: time.Now(), Latency: latency, Healthy: c == '+'})
+	}
+	return samples
+}
+
+func TestSummarizeHealthVerdicts(t *testing.T) {
+	tests := map[string]struct {
+		pattern string
+		latency time.Duration
+		verdict string
+	}{
+		"healthy":        {"++++++++", 20 * time.Millisecond, VERDICT_OK},
+		"slow":           {"++++++++", 1500 * time.Millisecond, VERDICT_SLOW},
+		"down":           {"--------", 20 * time.Millisecond, VERDICT_DOWN},
+		"just died":      {"+++++---", 20 * time.Millisecond, VERDICT_DOWN},
+		"booted":         {"---+++++", 20 * time.Millisecond, VERDICT_OK},
+		"flaky":          {"++-++-++", 20 * time.Millisecond, VERDICT_FLAKY},
+		"flaky and down": {"+-+-+--", 20 * time.Millisecond, VERDICT_FLAKY},
+		"no samples":     {"", 0, VERDICT_OK},
+	}
+
+	for name, test := range tests {
+		summary := summarizeHealth("FOO", samplesFrom(test.pattern, test.latency))
+		if summary.verdict != test.verdict {
+			t.Errorf("%s: expected %s got %s", name, test.verdict, summary.verdict)
+		}
+	}
+}
+
+func TestSummarizeHealthOnlyUsesRecentSamples(t *testing.T) {
+	samples := samplesFrom("+-+-+-+-", 20*time.Millisecond)
+	samples = append(samples, samplesFrom(string(make([]byte, healthWindow)), 0)...)
+	for i := range samples[8:] {
+		samples[8+i].Healthy = true
+	}
+
+	summary := summarizeHealth("FOO", samples)
+	if summary.samples != healthWindow || summary.failures != 0 || summary.verdict != VERDICT_OK {
+		t.Errorf("expected old failures to be ignored, got %+v", summary)
+	}
+}
+
+func TestAppendSampleLimitsHistory(t *testing.T) {
+	samples := []ledger.HealthSample{}
+	for i := 0; i < healthHistorySize+10; i++ {
+		samples = appendSample(samples, ledger.HealthSample{Healthy: true})
+	}
+	if len(samples) != healthHistorySize {
+		t.Errorf("expected history to be capped at %d, it was %d", healthHistorySize, len(samples))
+	}
+}
+
+func TestRecordHealthDropsStoppedServices(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer svr.Close()
+
+	history := ledger.HealthHistory{
+		"RUNNING": samplesFrom("+++", time.Millisecond),
+		"CRASHED": samplesFrom("+++", time.Millisecond),
+		"STOPPED": samplesFrom("+++", time.Millisecond),
+	}
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+				return []ledger.StateFile{
+					{Service: "RUNNING", Pid: 100, HealthcheckUrl: svr.URL},
+					{Service: "CRASHED", Pid: 200, HealthcheckUrl: svr.URL},
+				}, nil
+			},
+			LoadHealthHistory: func(string) (ledger.HealthHistory, error) { return history, nil },
+			SaveHealthHistory: func(_ string, saved ledger.HealthHistory) error {
+				history = saved
+				return nil
+			},
+		},
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{100: 100} }},
+	}
+
+	if err := sm.recordHealth(); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || len(history["RUNNING"]) != 4 {
+		t.Errorf("expected only the running service's history to be kept, got %v", history)
+	}
+}
@@ -24,6 +24,7 @@ Show all running services:
 If you're having trouble with service manager or a service isnt starting, try these commands:
     sm2 --diagnostic
     sm2 --debug SERVICE_NAME
+    sm2 --why-failed SERVICE_NAME
     sm2 --logs SERVICE_NAME
 
 To see a list of all the commands:
@@ -0,0 +1,160 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// --hosts-sync keeps a block of /etc/hosts pointing the services' proxyHosts at this machine, for services
+// that route on the hostname and clients that don't resolve *.localhost by themselves. Only the lines between
+// the markers are touched, anything else in the file is left as it is.
+
+const (
+	hostsFile        = "/etc/hosts"
+	hostsBlockStart  = "# BEGIN sm2 managed hosts, changes here are overwritten by sm2 -hosts-sync"
+	hostsBlockEnd    = "# END sm2 managed hosts"
+	hostsFileAddress = "127.0.0.1"
+)
+
+func (sm *ServiceManager) SyncHosts(file string, out io.Writer) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	current := string(data)
+	previous := managedHosts(current)
+	hosts := serviceHostnames(sm.Services)
+	updated := updateHostsBlock(current, hosts)
+
+	added, removed := diffHosts(previous, hosts)
+	if updated == current {
+		fmt.Fprintf(out, "%s is up to date (%d hosts)\n", file, len(hosts))
+		return nil
+	}
+
+	if err := writeHostsFile(file, updated, out); err != nil {
+		return fmt.Errorf("unable to update %s: %s", file, err)
+	}
+	for _, h := range added {
+		fmt.Fprintf(out, "  + %s\n", h)
+	}
+	for _, h := range removed {
+		fmt.Fprintf(out, "  - %s\n", h)
+	}
+	fmt.Fprintf(out, "Updated %s (%d hosts)\n", file, len(hosts))
+	return nil
+}
+
+// every proxyHost in the config, sorted
+func serviceHostnames(services Services) []string {
+	seen := map[string]bool{}
+	hosts := []string{}
+	for _, s := range services {
+		for _, h := range s.ProxyHosts {
+			if h = strings.ToLower(h); !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// the hosts currently in the managed block
+func managedHosts(content string) []string {
+	hosts := []string{}
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case line == hostsBlockStart:
+			inBlock = true
+		case line == hostsBlockEnd:
+			inBlock = false
+		case inBlock:
+			if fields := strings.Fields(line); len(fields) > 1 {
+				hosts = append(hosts, fields[1:]...)
+			}
+		}
+	}
+	return hosts
+}
+
+// replaces the managed block with one for the given hosts, or removes it if there aren't any
+func updateHostsBlock(content string, hosts []string) string {
+	kept := []string{}
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if line == hostsBlockStart {
+			inBlock = true
+		} else if line == hostsBlockEnd {
+			inBlock = false
+		} else if !inBlock {
+			kept = append(kept, line)
+		}
+	}
+
+	// drop the blank line that separated the old block
+	for len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	if len(hosts) > 0 {
+		kept = append(kept, "", hostsBlockStart)
+		for _, h := range hosts {
+			kept = append(kept, hostsFileAddress+" "+h)
+		}
+		kept = append(kept, hostsBlockEnd)
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+func diffHosts(previous []string, current []string) ([]string, []string) {
+	was := map[string]bool{}
+	for _, h := range previous {
+		was[h] = true
+	}
+	added := []string{}
+	for _, h := range current {
+		if !was[h] {
+			added = append(added, h)
+		}
+		delete(was, h)
+	}
+	removed := []string{}
+	for h := range was {
+		removed = append(removed, h)
+	}
+	sort.Strings(removed)
+	return added, removed
+}
+
+// /etc/hosts is usually owned by root, in which case the new version is copied over it with sudo
+func writeHostsFile(file string, content string, out io.Writer) error {
+	err := os.WriteFile(file, []byte(content), 0644)
+	if err == nil || !os.IsPermission(err) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "sm2-hosts*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	fmt.Fprintf(out, "%s is owned by root, using sudo to update it\n", file)
+	cmd := sudo("cp", tmp.Name(), file)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,57 @@
+package servicemanager
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestSyncHosts(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-hosts*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "hosts")
+	original := "127.0.0.1 localhost\n::1 localhost\n"
+	AssertNotErr(t, os.WriteFile(file, []byte(original), 0644))
+
+	sm := ServiceManager{Services: Services{
+		"FRONTEND": {Id: "FRONTEND", ProxyHosts: []string{"Account.test", "www.test"}},
+		"API":      {Id: "API", ProxyHosts: []string{"api.test"}},
+		"OTHER":    {Id: "OTHER"},
+	}}
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.SyncHosts(file, &out))
+
+	data, _ := os.ReadFile(file)
+	expected := original + "\n" + hostsBlockStart + "\n127.0.0.1 account.test\n127.0.0.1 api.test\n127.0.0.1 www.test\n" + hostsBlockEnd + "\n"
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, string(data))
+	}
+
+	// running it again changes nothing
+	out.Reset()
+	AssertNotErr(t, sm.SyncHosts(file, &out))
+	if !strings.Contains(out.String(), "is up to date (3 hosts)") {
+		t.Errorf("expected no changes, got %s", out.String())
+	}
+
+	// hosts that are no longer used are removed, and the block goes once there are none
+	sm.Services["FRONTEND"] = Service{Id: "FRONTEND", ProxyHosts: []string{"www.test"}}
+	out.Reset()
+	AssertNotErr(t, sm.SyncHosts(file, &out))
+	if !strings.Contains(out.String(), "  - account.test\n") {
+		t.Errorf("expected account.test to be removed, got %s", out.String())
+	}
+
+	sm.Services = Services{}
+	AssertNotErr(t, sm.SyncHosts(file, &out))
+	data, _ = os.ReadFile(file)
+	if string(data) != original {
+		t.Errorf("expected the file to be back to how it was, got:\n%s", string(data))
+	}
+}
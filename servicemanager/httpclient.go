@@ -0,0 +1,51 @@
+package servicemanager
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Go's default transport only keeps 2 idle connections per host, so a profile installing on more workers
+// than that keeps opening new connections (and tls handshakes) to artifactory. sm2 keeps more of them
+// open, and these settings tune it further:
+//
+//	httpIdleConns      connections to artifactory kept open between downloads, 32 by default
+//	httpConnsPerHost   the most connections open to artifactory at once, for servers that limit them
+//	httpIdleTimeout    how long unused connections are kept open for, 90 secs by default
+//	http2              false to download over a connection each, rather than sharing one with http/2
+
+const (
+	defaultIdleConnsPerHost = 32
+	defaultIdleTimeout      = 90 * time.Second
+)
+
+func (sm *ServiceManager) tuneTransport(transport *http.Transport) *http.Transport {
+	idle := settingInt(sm.Commands.Settings, "httpIdleConns", defaultIdleConnsPerHost)
+	// never fewer than are downloading at once, so none of them have to reconnect
+	if idle < sm.Commands.Workers {
+		idle = sm.Commands.Workers
+	}
+	transport.MaxIdleConnsPerHost = idle
+	if transport.MaxIdleConns < idle {
+		transport.MaxIdleConns = idle
+	}
+	transport.MaxConnsPerHost = settingInt(sm.Commands.Settings, "httpConnsPerHost", 0)
+	transport.IdleConnTimeout = time.Duration(settingInt(sm.Commands.Settings, "httpIdleTimeout", int(defaultIdleTimeout.Seconds()))) * time.Second
+
+	if enabled, err := strconv.ParseBool(sm.Commands.Settings["http2"]); err == nil && !enabled {
+		transport.ForceAttemptHTTP2 = false
+		// a non-nil empty map is how http/2 is turned off
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// a setting that's a number, which has already been validated when the defaults were loaded
+func settingInt(settings map[string]string, name string, defaultValue int) int {
+	if n, err := strconv.Atoi(settings[name]); err == nil {
+		return n
+	}
+	return defaultValue
+}
@@ -0,0 +1,35 @@
+package servicemanager
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"sm2/cli"
+)
+
+func TestTuneTransport(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{Workers: 2}}
+	transport := sm.tuneTransport(http.DefaultTransport.(*http.Transport).Clone())
+	if transport.MaxIdleConnsPerHost != defaultIdleConnsPerHost || transport.MaxConnsPerHost != 0 || transport.IdleConnTimeout != defaultIdleTimeout {
+		t.Errorf("expected the defaults, got %d idle, %d max, %s", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+	if !transport.ForceAttemptHTTP2 || transport.TLSNextProto != nil {
+		t.Errorf("expected http/2 to be used")
+	}
+
+	sm.Commands.Settings = map[string]string{"httpIdleConns": "8", "httpConnsPerHost": "12", "httpIdleTimeout": "30", "http2": "false"}
+	transport = sm.tuneTransport(http.DefaultTransport.(*http.Transport).Clone())
+	if transport.MaxIdleConnsPerHost != 8 || transport.MaxConnsPerHost != 12 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("expected the settings to be used, got %d idle, %d max, %s", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+	if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil || len(transport.TLSNextProto) != 0 {
+		t.Errorf("expected http/2 to be turned off")
+	}
+
+	// there's always an idle connection for each worker
+	sm.Commands.Workers = 20
+	if transport := sm.tuneTransport(http.DefaultTransport.(*http.Transport).Clone()); transport.MaxIdleConnsPerHost != 20 {
+		t.Errorf("expected 20 idle connections, got %d", transport.MaxIdleConnsPerHost)
+	}
+}
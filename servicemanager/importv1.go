@@ -0,0 +1,134 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Converts services.json or profiles.json from the original (python) service-manager into sm2's format, e.g.
+//
+//	sm2 --import-v1 old-config/services.json > services.json
+//
+// The converted config is written to out and anything that couldn't be converted is listed in report.
+// Profiles are the same in both, so they're only checked.
+
+// fields from service-manager that sm2 doesn't use, and why
+var v1OnlyFields = map[string]string{
+	"pattern":                  "sm2 finds running services by their -Dservice.manager.serviceName arg",
+	"versionEnv":               "use -r to pick a version",
+	"binary.nexus":             "the repository comes from config.json, use binary.repo for a different one",
+	"binary.configurationFile": "dropwizard services aren't supported",
+	"sources.cmd":              "sm2 always runs `sbt start` when running from source",
+}
+
+func ImportV1(file string, out io.Writer, report io.Writer) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	config, err := decodeJsonObject(data)
+	if err != nil {
+		return fmt.Errorf("%s is not valid json: %s", file, err)
+	}
+
+	notes := []string{}
+	if isV1Profiles(config) {
+		notes = append(notes, "profiles are the same in sm2, nothing needed converting")
+	} else {
+		for id, service := range config {
+			s, ok := service.(map[string]interface{})
+			if !ok {
+				notes = append(notes, fmt.Sprintf("%s: not a service, removed", id))
+				delete(config, id)
+				continue
+			}
+			notes = append(notes, convertV1Service(id, s)...)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(config); err != nil {
+		return err
+	}
+
+	sort.Strings(notes)
+	for _, note := range notes {
+		fmt.Fprintln(report, note)
+	}
+	return nil
+}
+
+// profiles.json is a map of lists of service names
+func isV1Profiles(config map[string]interface{}) bool {
+	for _, v := range config {
+		if _, ok := v.([]interface{}); !ok {
+			return false
+		}
+	}
+	return len(config) > 0
+}
+
+// converts a service in place, returning notes about anything that was changed or removed
+func convertV1Service(id string, service map[string]interface{}) []string {
+	notes := []string{}
+
+	// most services were play services with the standard pattern, which is what sm2 expects anyway
+	if t, ok := service["type"].(string); ok {
+		if t != "play" {
+			notes = append(notes, fmt.Sprintf("%s: was a %s service, check it can be run from its binary section", id, t))
+		}
+		delete(service, "type")
+	}
+	if service["pattern"] == "service.manager.serviceName="+id {
+		delete(service, "pattern")
+	}
+
+	// service-manager used paths for the group, sm2 uses the maven style
+	if binary, ok := service["binary"].(map[string]interface{}); ok {
+		if group, ok := binary["groupId"].(string); ok && strings.Contains(group, "/") {
+			binary["groupId"] = strings.ReplaceAll(strings.Trim(group, "/"), "/", ".")
+		}
+	}
+
+	for _, field := range removeUnknownFields(service, reflect.TypeOf(Service{}), "") {
+		if reason, ok := v1OnlyFields[field]; ok {
+			notes = append(notes, fmt.Sprintf("%s: removed %s, %s", id, field, reason))
+		} else {
+			notes = append(notes, fmt.Sprintf("%s: removed %s, it has no equivalent in sm2", id, field))
+		}
+	}
+	return notes
+}
+
+// removes any fields that aren't in the given struct, returning their paths
+func removeUnknownFields(obj map[string]interface{}, t reflect.Type, path string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+
+	removed := []string{}
+	for key, value := range obj {
+		fieldType, ok := fields[key]
+		if !ok {
+			removed = append(removed, joinPath(path, key))
+			delete(obj, key)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && fieldType.Kind() == reflect.Struct {
+			removed = append(removed, removeUnknownFields(nested, fieldType, joinPath(path, key))...)
+		}
+	}
+	return removed
+}
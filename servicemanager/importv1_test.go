@@ -0,0 +1,70 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestImportV1(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-import-v1*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "services.json")
+	os.WriteFile(file, []byte(`{
+		"AUTH": {
+			"name": "Auth",
+			"type": "play",
+			"pattern": "service.manager.serviceName=AUTH",
+			"defaultPort": 8500,
+			"sources": {"repo": "git@github.com:hmrc/auth.git", "cmd": ["sbt", "start"]},
+			"binary": {
+				"artifact": "auth_2.12",
+				"groupId": "uk/gov/hmrc/",
+				"nexus": "/hmrc-releases/uk/gov/hmrc/",
+				"cmd": ["./auth/bin/auth", "-Dsomething=a&b"]
+			},
+			"healthcheck": {"url": "http://localhost:${port}/ping/ping", "response": ""}
+		},
+		"LEGACY": {"name": "Legacy", "type": "dropwizard", "defaultPort": 9000, "hooks": {"pre_start": "x"}}
+	}`), 0644)
+
+	out := bytes.Buffer{}
+	report := bytes.Buffer{}
+	AssertNotErr(t, ImportV1(file, &out, &report))
+
+	services := Services{}
+	AssertNotErr(t, json.Unmarshal(out.Bytes(), &services))
+
+	auth := services["AUTH"]
+	if auth.Binary.GroupId != "uk.gov.hmrc" || auth.DefaultPort != 8500 || auth.Healthcheck.Url != "http://localhost:${port}/ping/ping" {
+		t.Errorf("unexpected conversion of AUTH: %+v", auth)
+	}
+	if !reflect.DeepEqual(auth.Binary.Cmd, []string{"./auth/bin/auth", "-Dsomething=a&b"}) {
+		t.Errorf("expected cmd to be kept as it was, got %v", auth.Binary.Cmd)
+	}
+
+	// the converted config has nothing sm2 doesn't know about
+	for _, p := range validateConfig("services.json", out.Bytes(), reflect.TypeOf(Services{})) {
+		if strings.Contains(p.message, "unknown field") {
+			t.Errorf("expected unknown fields to be removed, got %s", p)
+		}
+	}
+
+	expected := []string{
+		"AUTH: removed binary.nexus, the repository comes from config.json, use binary.repo for a different one",
+		"AUTH: removed sources.cmd, sm2 always runs `sbt start` when running from source",
+		"LEGACY: removed hooks, it has no equivalent in sm2",
+		"LEGACY: was a dropwizard service, check it can be run from its binary section",
+	}
+	if notes := strings.Split(strings.TrimSpace(report.String()), "\n"); !reflect.DeepEqual(notes, expected) {
+		t.Errorf("expected notes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), report.String())
+	}
+}
@@ -0,0 +1,185 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services with "type": "mongo", "redis" or "rabbitmq" run the infrastructure other services depend on,
+// so starting a profile starts its databases too, e.g.
+//
+//	"PAYMENTS_MONGO": {"type": "mongo", "defaultPort": 27018, "docker": {"image": "mongo:6.0"}}
+//
+// They run as a container of the official image when docker is installed, or with the mongod, redis-server
+// or rabbitmq-server already on the path when it isn't (or with "runtime": "native"). Either way they start
+// with no data, and are healthy once their port accepts connections.
+//
+// MONGO, REDIS and RABBITMQ are there out of the box, unless the config has a service with the same id,
+// type or port.
+
+const (
+	NativeRuntime = "native"
+	NATIVE        = "native" // the version shown in status
+)
+
+type infraType struct {
+	id     string // of the built in service
+	name   string
+	image  string // the official image, used unless docker.image is set
+	port   int
+	binary string
+	// the args and environment to run the binary with, keeping its data in dataDir
+	native func(dataDir string, bindAddress string, port int) ([]string, []string)
+}
+
+var infraTypes = map[string]infraType{
+	"mongo": {
+		id: "MONGO", name: "MongoDB", image: "mongo:7.0", port: 27017, binary: "mongod",
+		native: func(dataDir string, bindAddress string, port int) ([]string, []string) {
+			return []string{"--port", fmt.Sprint(port), "--bind_ip", bindAddress, "--dbpath", dataDir}, nil
+		},
+	},
+	"redis": {
+		id: "REDIS", name: "Redis", image: "redis:7", port: 6379, binary: "redis-server",
+		native: func(dataDir string, bindAddress string, port int) ([]string, []string) {
+			return []string{"--port", fmt.Sprint(port), "--bind", bindAddress, "--dir", dataDir}, nil
+		},
+	},
+	"rabbitmq": {
+		id: "RABBITMQ", name: "RabbitMQ", image: "rabbitmq:3-management", port: 5672, binary: "rabbitmq-server",
+		native: func(dataDir string, bindAddress string, port int) ([]string, []string) {
+			return nil, []string{
+				fmt.Sprintf("RABBITMQ_NODE_PORT=%d", port),
+				"RABBITMQ_NODE_IP_ADDRESS=" + bindAddress,
+				"RABBITMQ_MNESIA_BASE=" + path.Join(dataDir, "mnesia"),
+				"RABBITMQ_LOG_BASE=" + path.Join(dataDir, "log"),
+			}
+		},
+	},
+}
+
+func isInfra(service Service) bool {
+	_, ok := infraTypes[service.Type]
+	return ok
+}
+
+// adds the built in infrastructure services that the config doesn't already have something for
+func addInfraServices(services Services) {
+	ports := map[int]bool{}
+	types := map[string]bool{}
+	for _, s := range services {
+		ports[s.DefaultPort] = true
+		types[s.Type] = true
+	}
+
+	for name, infra := range infraTypes {
+		if _, ok := services[infra.id]; ok || types[name] || ports[infra.port] {
+			continue
+		}
+		services[infra.id] = Service{Id: infra.id, Name: infra.name, Type: name}
+	}
+}
+
+// fills in what an infrastructure service doesn't set, choosing to run it in docker if it's installed
+func applyInfraDefaults(services Services, dockerInstalled bool) {
+	for id, s := range services {
+		infra, ok := infraTypes[s.Type]
+		if !ok {
+			continue
+		}
+		if s.DefaultPort == 0 {
+			s.DefaultPort = infra.port
+		}
+		if s.Docker.Image == "" {
+			s.Docker.Image = infra.image
+		}
+		if s.Docker.ContainerPort == 0 {
+			s.Docker.ContainerPort = infra.port
+		}
+		if s.Runtime == "" {
+			s.Runtime = NativeRuntime
+			if dockerInstalled {
+				s.Runtime = DockerRuntime
+			}
+		}
+		services[id] = s
+	}
+}
+
+// adds the built in services and fills in the infrastructure services' defaults
+func setupInfra(services Services) {
+	addInfraServices(services)
+	applyInfraDefaults(services, dockerInstalled())
+}
+
+func dockerInstalled() bool {
+	_, err := exec.LookPath("docker")
+	return err == nil
+}
+
+func (sm *ServiceManager) startNative(service Service, installDir string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	infra := infraTypes[service.Type]
+	binary, err := exec.LookPath(infra.binary)
+	if err != nil {
+		return ledger.StateFile{}, fmt.Errorf("%s needs %s, which isn't installed (or install docker to run it in a container)", service.Id, infra.binary)
+	}
+
+	// like a container, it starts with no data
+	dataDir := path.Join(installDir, "data")
+	if err := os.RemoveAll(dataDir); err != nil {
+		return ledger.StateFile{}, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return ledger.StateFile{}, err
+	}
+	clearExitStatus(installDir)
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	bindAddress := sm.bindAddress(service)
+	args, env := infra.native(dataDir, bindAddress, port)
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), env...)
+	// rabbitmq-server is a script that starts erlang, so the whole group is stopped
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Version:        NATIVE,
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    bindAddress,
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            binary,
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
+		Runtime:        NativeRuntime,
+	}, nil
+}
+
+// databases are given the chance to shut down cleanly
+func stopNative(pid int) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		fmt.Printf("Unable to stop pid %d, %s.\n", pid, err)
+	}
+}
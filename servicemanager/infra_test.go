@@ -0,0 +1,95 @@
+package servicemanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddInfraServices(t *testing.T) {
+	services := Services{
+		"AUTH":           {Id: "AUTH", DefaultPort: 8500},
+		"PAYMENTS_REDIS": {Id: "PAYMENTS_REDIS", Type: "redis"},
+		"LOCAL_RABBIT":   {Id: "LOCAL_RABBIT", DefaultPort: 5672},
+	}
+	addInfraServices(services)
+
+	if s, ok := services["MONGO"]; !ok || s.Type != "mongo" || s.Name != "MongoDB" {
+		t.Errorf("expected MONGO to be added, got %+v", s)
+	}
+	if _, ok := services["REDIS"]; ok {
+		t.Errorf("expected REDIS not to be added when there's already a redis service")
+	}
+	if _, ok := services["RABBITMQ"]; ok {
+		t.Errorf("expected RABBITMQ not to be added when something already uses its port")
+	}
+}
+
+func TestApplyInfraDefaults(t *testing.T) {
+	services := Services{
+		"MONGO":          {Id: "MONGO", Type: "mongo"},
+		"PAYMENTS_REDIS": {Id: "PAYMENTS_REDIS", Type: "redis", DefaultPort: 6380, Docker: Docker{Image: "redis:6"}},
+		"AUTH":           {Id: "AUTH", DefaultPort: 8500},
+	}
+	applyInfraDefaults(services, true)
+
+	mongo := services["MONGO"]
+	if mongo.DefaultPort != 27017 || mongo.Runtime != DockerRuntime || mongo.Docker.Image != "mongo:7.0" || mongo.Docker.ContainerPort != 27017 {
+		t.Errorf("unexpected defaults %+v", mongo)
+	}
+	redis := services["PAYMENTS_REDIS"]
+	if redis.DefaultPort != 6380 || redis.Docker.Image != "redis:6" || redis.Docker.ContainerPort != 6379 {
+		t.Errorf("expected the config to be kept, got %+v", redis)
+	}
+	if services["AUTH"].Runtime != "" {
+		t.Errorf("expected other services to be left alone")
+	}
+
+	services = Services{"MONGO": {Id: "MONGO", Type: "mongo"}}
+	applyInfraDefaults(services, false)
+	if services["MONGO"].Runtime != NativeRuntime {
+		t.Errorf("expected mongod to be used without docker, got %s", services["MONGO"].Runtime)
+	}
+}
+
+func TestInfraHealthcheck(t *testing.T) {
+	sm := ServiceManager{}
+	url := sm.serviceHealthcheckUrl(Service{Type: "redis"}, 6379)
+	if url != "tcp://127.0.0.1:6379" && url != "tcp://localhost:6379" {
+		t.Errorf("expected redis to be checked by connecting to its port, got %s", url)
+	}
+}
+
+func TestNativeArgs(t *testing.T) {
+	args, env := infraTypes["mongo"].native("/tmp/data", "127.0.0.1", 27018)
+	if !reflect.DeepEqual(args, []string{"--port", "27018", "--bind_ip", "127.0.0.1", "--dbpath", "/tmp/data"}) || env != nil {
+		t.Errorf("unexpected mongod args %v %v", args, env)
+	}
+
+	args, env = infraTypes["rabbitmq"].native("/tmp/data", "127.0.0.1", 5673)
+	if len(args) != 0 || env[0] != "RABBITMQ_NODE_PORT=5673" {
+		t.Errorf("expected rabbitmq to be configured by its environment, got %v %v", args, env)
+	}
+}
+
+func TestLintInfra(t *testing.T) {
+	if problems := append(lintTunnel("MONGO", Service{Type: "mongo", Runtime: NativeRuntime}), lintDocker("MONGO", Service{Type: "mongo", Runtime: NativeRuntime})...); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	if problems := lintDocker("AUTH", Service{Runtime: NativeRuntime}); len(problems) != 1 {
+		t.Errorf("expected native to only be allowed for infrastructure, got %v", problems)
+	}
+}
+
+func TestStatusOnlyShowsMongoOnce(t *testing.T) {
+	checkMongo := func() serviceStatus { return serviceStatus{service: "MONGO", port: 27017, health: FAIL} }
+
+	statuses := withMongo([]serviceStatus{{service: "AUTH"}}, checkMongo)
+	if len(statuses) != 2 || statuses[0].service != "MONGO" {
+		t.Errorf("expected mongo to be checked, got %+v", statuses)
+	}
+
+	statuses = withMongo([]serviceStatus{{service: "AUTH"}, {service: "MONGO", pid: 1, health: PASS}}, checkMongo)
+	if len(statuses) != 2 || statuses[1].health != PASS {
+		t.Errorf("expected the MONGO run by sm2 to be shown instead, got %+v", statuses)
+	}
+}
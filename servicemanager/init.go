@@ -0,0 +1,118 @@
+package servicemanager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"sm2/cli"
+)
+
+const defaultConfigRepo = "git@github.com:hmrc/service-manager-config.git"
+
+// the values that can be given to --init as KEY=VALUE
+var initFields = []string{"repo", "artifactoryUrl"}
+
+// Sets up a new workspace: creates the folders, clones service-manager-config, writes the user's
+// defaults file and checks artifactory can be reached, e.g.
+//
+//	sm2 --init
+//	sm2 --init repo=git@github.com:my-org/service-manager-config.git artifactoryUrl=https://artifactory.example.com/releases
+//
+// Anything already set up (like an existing copy of the config) is left alone, so it's safe to run again.
+func (sm *ServiceManager) Init(values []string) error {
+	given, err := parseKeyValues(values, initFields)
+	if err != nil {
+		return err
+	}
+
+	var prompt *bufio.Reader
+	if isInteractive() {
+		prompt = bufio.NewReader(os.Stdin)
+	}
+
+	workspace, _ := cli.Workspace()
+	if !path.IsAbs(workspace) {
+		return fmt.Errorf("WORKSPACE must be an absolute path, e.g. /home/user/.sm2")
+	}
+
+	if err := sm.initWorkspace(workspace, given, prompt, os.Stdout); err != nil {
+		return err
+	}
+
+	if _, isSet := os.LookupEnv("WORKSPACE"); isSet {
+		fmt.Printf("\nDone! Make sure WORKSPACE=%s is exported in your .bashrc and/or .profile\n", workspace)
+	} else {
+		fmt.Println("\nDone! Try `sm2 --list` to see the available services.")
+	}
+	return nil
+}
+
+func (sm *ServiceManager) initWorkspace(workspace string, given map[string]string, prompt *bufio.Reader, out io.Writer) error {
+	if err := os.MkdirAll(path.Join(workspace, "install"), 0755); err != nil {
+		return fmt.Errorf("unable to create the workspace in %s: %s", workspace, err)
+	}
+	fmt.Fprintf(out, "WORKSPACE:\t %s\n", workspace)
+
+	configDir := path.Join(workspace, "service-manager-config")
+	if sm.Commands.Config != "" {
+		configDir = sm.Commands.Config
+	}
+
+	if Exists(configDir) {
+		fmt.Fprintf(out, "CONFIG:\t\t found %s\n", configDir)
+	} else {
+		repo := given["repo"]
+		if repo == "" {
+			repo = defaultConfigRepo
+			if prompt != nil {
+				var err error
+				if repo, err = ask(prompt, out, "service-manager-config repo", defaultConfigRepo); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Fprintf(out, "CONFIG:\t\t cloning %s...\n", repo)
+		if err := gitRun(workspace, "clone", repo, configDir); err != nil {
+			return err
+		}
+	}
+
+	// an artifactory url that's different from the one in config.json is kept in the user's defaults
+	detected, err := loadRepoConfig(path.Join(configDir, "config.json"))
+	if err != nil {
+		detected = DefaultArtifactoryUrls
+	}
+	repoUrl := given["artifactoryUrl"]
+	if repoUrl == "" && prompt != nil {
+		if repoUrl, err = ask(prompt, out, "Artifactory url", detected.RepoUrl); err != nil {
+			return err
+		}
+	}
+
+	defaultsFile := path.Join(workspace, "config") // same as cli.DefaultsFile()
+	if repoUrl != "" && repoUrl != detected.RepoUrl {
+		if err := cli.SetDefault(defaultsFile, "artifactoryUrl", repoUrl); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "DEFAULTS:\t set artifactoryUrl in %s\n", defaultsFile)
+	} else if !Exists(defaultsFile) {
+		if err := os.WriteFile(defaultsFile, []byte("{}\n"), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "DEFAULTS:\t created %s\n", defaultsFile)
+	} else {
+		fmt.Fprintf(out, "DEFAULTS:\t found %s\n", defaultsFile)
+	}
+
+	config := ServiceManagerConfig{ArtifactoryPingUrl: detected.PingUrl, TimeoutShort: DEFAULT_SHORT_TIMEOUT * time.Second}
+	if ok, err := checkVpn(sm.Client, config); !ok {
+		fmt.Fprintf(out, "ARTIFACTORY:\t NOT OK: unable to reach %s, check you're on the VPN (%s)\n", detected.PingUrl, err)
+	} else {
+		fmt.Fprintf(out, "ARTIFACTORY:\t OK (%s)\n", detected.PingUrl)
+	}
+	return nil
+}
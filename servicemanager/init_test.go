@@ -0,0 +1,60 @@
+package servicemanager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestInitWorkspace(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer svr.Close()
+
+	workspace, err := os.MkdirTemp(os.TempDir(), "test-init*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(workspace)
+
+	// an existing copy of the config is used rather than cloning a new one
+	configDir := path.Join(workspace, "service-manager-config")
+	AssertNotErr(t, os.MkdirAll(configDir, 0755))
+	host := strings.TrimPrefix(svr.URL, "http://")
+	os.WriteFile(path.Join(configDir, "config.json"), []byte(fmt.Sprintf(`{
+		"artifactory": {"protocol": "http", "host": "%s", "repoMappings": {"RELEASE": "releases"}, "ping": "ping"}
+	}`, host)), 0644)
+
+	sm := ServiceManager{Client: &http.Client{}}
+	out := bytes.Buffer{}
+	prompt := bufio.NewReader(strings.NewReader("https://artifactory.example.com/mirror\n"))
+	AssertNotErr(t, sm.initWorkspace(workspace, map[string]string{}, prompt, &out))
+
+	if !Exists(path.Join(workspace, "install")) {
+		t.Errorf("expected the install dir to be created")
+	}
+	if !strings.Contains(out.String(), "ARTIFACTORY:\t OK") {
+		t.Errorf("expected artifactory to be reachable, got:\n%s", out.String())
+	}
+
+	url, err := cli.GetDefault(path.Join(workspace, "config"), "artifactoryUrl")
+	AssertNotErr(t, err)
+	if url != `"https://artifactory.example.com/mirror"` {
+		t.Errorf("expected the artifactory url to be saved to the defaults, got %s", url)
+	}
+
+	// running it again with the detected url doesn't change anything
+	out.Reset()
+	AssertNotErr(t, sm.initWorkspace(workspace, map[string]string{"artifactoryUrl": svr.URL + "/releases"}, nil, &out))
+	if !strings.Contains(out.String(), "DEFAULTS:\t found") {
+		t.Errorf("expected the existing defaults to be kept, got:\n%s", out.String())
+	}
+}
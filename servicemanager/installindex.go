@@ -0,0 +1,81 @@
+package servicemanager
+
+import (
+	"strings"
+	"sync"
+
+	"sm2/ledger"
+)
+
+// Installed versions are indexed by group, artifact and version (in install/.install_index), so starting a
+// version that's already installed, e.g. sm2 -start AUTH:1.2.3, doesn't check the vpn or look anything up
+// in artifactory first. The index is only a shortcut, the service's .install file is still checked before
+// it's trusted, and anything that isn't in it is started the usual way.
+
+// workers install services at the same time, and each one updates the index
+var installIndexLock sync.Mutex
+
+// finds the exact version asked for, if it's installed in the service's install dir
+func (sm *ServiceManager) findIndexedInstall(service Service, sv ServiceAndVersion, installDir string) (ledger.InstallFile, bool) {
+	if sv.version == "" || sm.Commands.Clean {
+		return ledger.InstallFile{}, false
+	}
+	index, err := sm.Ledger.LoadInstallIndex(sm.Config.TmpDir)
+	if err != nil {
+		return ledger.InstallFile{}, false
+	}
+
+	group := service.Binary.GroupId
+	for _, artifact := range sm.possibleArtifacts(service.Binary.Artifact, sv.scalaVersion) {
+		entry, ok := index[ledger.InstallIndexKey(group, artifact, sv.version)]
+		if !ok || entry.Dir != installDir {
+			continue
+		}
+		installFile, err := sm.Ledger.LoadInstallFile(installDir)
+		url := artifactUrl(sm.repoUrl(service.Binary), group, artifact, sv.version)
+		if err == nil && verifyInstall(installFile, service.Id, sv.version, url, false) {
+			return installFile, true
+		}
+	}
+	return ledger.InstallFile{}, false
+}
+
+// the artifacts a service could have been installed from, which is one per scala version for _%%
+func (sm *ServiceManager) possibleArtifacts(artifact string, scalaVersion string) []string {
+	if scalaVersion != "" {
+		return []string{scalaSuffix.ReplaceAllLiteralString(artifact, "_"+scalaVersion)}
+	}
+	if !latestVersionScalaVersionSuffix.MatchString(artifact) {
+		return []string{artifact}
+	}
+	artifacts := []string{}
+	for _, v := range sm.scalaVersions() {
+		artifacts = append(artifacts, strings.Replace(artifact, ScalaVersion_Any, v, 1))
+	}
+	return artifacts
+}
+
+// records the version installed in a service's install dir, replacing the one that was there before
+func (sm *ServiceManager) indexInstall(installDir string, group string, artifact string, version string) {
+	installIndexLock.Lock()
+	defer installIndexLock.Unlock()
+
+	index, err := sm.Ledger.LoadInstallIndex(sm.Config.TmpDir)
+	if err != nil {
+		// it's only a shortcut, so a damaged index is started again
+		index = ledger.InstallIndex{}
+	}
+	key := ledger.InstallIndexKey(group, artifact, version)
+	if entry, ok := index[key]; ok && entry.Dir == installDir {
+		return
+	}
+	for k, entry := range index {
+		if entry.Dir == installDir {
+			delete(index, k)
+		}
+	}
+	index[key] = ledger.InstallIndexEntry{Group: group, Artifact: artifact, Version: version, Dir: installDir}
+	if err := sm.Ledger.SaveInstallIndex(sm.Config.TmpDir, index); err != nil {
+		sm.PrintVerbose("unable to update the install index: %s\n", err)
+	}
+}
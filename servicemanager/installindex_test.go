@@ -0,0 +1,83 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestInstalledVersionsStartWithoutArtifactory(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected artifactory not to be used, got a request for %s", r.URL)
+		w.WriteHeader(500)
+	}))
+	defer svr.Close()
+
+	tmpDir := t.TempDir()
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{TmpDir: tmpDir, ArtifactoryRepoUrl: svr.URL, ArtifactoryPingUrl: svr.URL, ScalaVersions: []string{"3", "2.13"}},
+		Ledger: ledger.NewLedger(),
+	}
+	service := Service{Id: "AUTH", Binary: ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "auth_%%", DestinationSubdir: "auth"}}
+	installDir := path.Join(tmpDir, "auth")
+	serviceDir := path.Join(installDir, "auth-1.2.3")
+	AssertNotErr(t, os.MkdirAll(serviceDir, 0755))
+	installed := ledger.InstallFile{
+		Service:  "AUTH",
+		Artifact: "auth_2.13",
+		Version:  "1.2.3",
+		Path:     serviceDir,
+		Url:      artifactUrl(svr.URL, "uk.gov.hmrc", "auth_2.13", "1.2.3"),
+		Created:  time.Now(),
+	}
+	AssertNotErr(t, sm.Ledger.SaveInstallFile(installDir, installed))
+	sm.indexInstall(installDir, "uk.gov.hmrc", "auth_2.13", "1.2.3")
+
+	installFile, version, err := sm.installVersion(service, ServiceAndVersion{service: "AUTH", version: "1.2.3"}, installDir, nil)
+	AssertNotErr(t, err)
+	if version != "1.2.3" || installFile.Path != serviceDir {
+		t.Errorf("expected the installed 1.2.3 to be used, got %s %+v", version, installFile)
+	}
+
+	// other versions, or a copy that's been deleted, aren't in the index
+	if _, ok := sm.findIndexedInstall(service, ServiceAndVersion{service: "AUTH", version: "1.2.4"}, installDir); ok {
+		t.Errorf("expected 1.2.4 not to be installed")
+	}
+	if _, ok := sm.findIndexedInstall(service, ServiceAndVersion{service: "AUTH", version: "1.2.3", scalaVersion: "3"}, installDir); ok {
+		t.Errorf("expected the scala 3 build not to be installed")
+	}
+	AssertNotErr(t, os.RemoveAll(serviceDir))
+	if _, ok := sm.findIndexedInstall(service, ServiceAndVersion{service: "AUTH", version: "1.2.3"}, installDir); ok {
+		t.Errorf("expected a deleted install not to be used")
+	}
+}
+
+func TestIndexInstallReplacesTheLastVersion(t *testing.T) {
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: t.TempDir()}, Ledger: ledger.NewLedger()}
+	sm.indexInstall("/tmp/auth", "uk.gov.hmrc", "auth_2.13", "1.2.3")
+	sm.indexInstall("/tmp/email", "uk.gov.hmrc", "email_2.13", "2.0.0")
+	sm.indexInstall("/tmp/auth", "uk.gov.hmrc", "auth_2.13", "1.2.4")
+
+	index, err := sm.Ledger.LoadInstallIndex(sm.Config.TmpDir)
+	AssertNotErr(t, err)
+	if len(index) != 2 {
+		t.Errorf("expected only the latest install of each dir to be indexed, got %+v", index)
+	}
+	if _, ok := index[ledger.InstallIndexKey("uk.gov.hmrc", "auth_2.13", "1.2.4")]; !ok {
+		t.Errorf("expected auth 1.2.4 to be indexed, got %+v", index)
+	}
+
+	// a damaged index is replaced
+	AssertNotErr(t, os.WriteFile(path.Join(sm.Config.TmpDir, ".install_index"), []byte("{"), 0644))
+	sm.indexInstall("/tmp/auth", "uk.gov.hmrc", "auth_2.13", "1.2.5")
+	if index, err := sm.Ledger.LoadInstallIndex(sm.Config.TmpDir); err != nil || len(index) != 1 {
+		t.Errorf("expected a new index with just auth, got %+v %v", index, err)
+	}
+}
@@ -0,0 +1,48 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"syscall"
+)
+
+// Two sm2s starting the same service at once, e.g. in two terminals or alongside a CI job on the same box,
+// would both download it into the same install dir, each deleting what the other's written. Installing
+// takes a lock on the install dir first (in install/.locks, as the install dir itself is deleted), so one
+// downloads while the other waits, and then uses what was installed rather than downloading it again.
+// The lock is released if sm2 dies, so a crashed install never leaves anything waiting.
+
+const installLocksDir = ".locks"
+
+// locks the install dir against other sm2s installing into it, returning the func that unlocks it and
+// whether it had to wait for another sm2 to finish with it first
+func (sm *ServiceManager) lockInstall(installDir string, waiting func()) (func(), bool, error) {
+	locks := path.Join(sm.Config.TmpDir, installLocksDir)
+	if err := os.MkdirAll(locks, 0755); err != nil {
+		return nil, false, err
+	}
+	file, err := os.OpenFile(path.Join(locks, path.Base(installDir)+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	waited := false
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, false, err
+		}
+		waiting()
+		waited = true
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+			file.Close()
+			return nil, false, err
+		}
+	}
+
+	unlock := func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}
+	return unlock, waited, nil
+}
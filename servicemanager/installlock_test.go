@@ -0,0 +1,105 @@
+package servicemanager
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestLockInstallWaitsForTheOtherInstall(t *testing.T) {
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: t.TempDir()}}
+	installDir := path.Join(sm.Config.TmpDir, "auth")
+
+	unlock, waited, err := sm.lockInstall(installDir, func() { t.Error("expected the first lock not to wait") })
+	AssertNotErr(t, err)
+	if waited {
+		t.Error("expected the first lock not to have waited")
+	}
+
+	locked := make(chan bool)
+	go func() {
+		waiting := false
+		unlock, waited, err := sm.lockInstall(installDir, func() { waiting = true })
+		AssertNotErr(t, err)
+		unlock()
+		locked <- waiting && waited
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("expected the second lock to wait for the first")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if !<-locked {
+		t.Error("expected the second lock to say it waited")
+	}
+}
+
+func TestConcurrentInstallsDownloadOnce(t *testing.T) {
+	downloads := int32(0)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".tgz") {
+			w.WriteHeader(404)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		f, err := os.Open("../testing/testdata/playtest-1.0.0.tgz")
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+		defer f.Close()
+		// slow enough that the other install is waiting
+		time.Sleep(200 * time.Millisecond)
+		io.Copy(w, f)
+	}))
+	defer svr.Close()
+
+	tmpDir := t.TempDir()
+	service := Service{Id: "PLAYTEST", Binary: ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "playtest", DestinationSubdir: "playtest"}}
+	installDir := path.Join(tmpDir, "playtest")
+
+	// two invocations of sm2 sharing a workspace
+	results := make([]ledger.InstallFile, 2)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sm := ServiceManager{
+				Client:   &http.Client{},
+				Config:   ServiceManagerConfig{TmpDir: tmpDir, ArtifactoryRepoUrl: svr.URL},
+				Commands: cli.UserOption{NoVpnCheck: true},
+				Ledger:   ledger.NewLedger(),
+				Services: Services{"PLAYTEST": service},
+			}
+			sm.progress.noProgress = true
+			installFile, _, err := sm.installVersion(service, ServiceAndVersion{service: "PLAYTEST", version: "1.0.0"}, installDir, nil)
+			AssertNotErr(t, err)
+			results[i] = installFile
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("expected the artifact to be downloaded once, got %d downloads", n)
+	}
+	if results[0].Path != results[1].Path || !results[0].Created.Equal(results[1].Created) {
+		t.Errorf("expected both to use the same install, got %+v and %+v", results[0], results[1])
+	}
+	if _, err := os.Stat(results[0].Path); err != nil {
+		t.Errorf("expected the install to be there: %s", err)
+	}
+}
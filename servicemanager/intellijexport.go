@@ -0,0 +1,100 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Writes IntelliJ run configurations to start, stop and check the given services and profiles, and to
+// attach a remote debugger to each of their services, e.g.
+//
+//	sm2 -export-intellij CORE_STACK
+//
+// writes a file for each to .run in the current directory, which IntelliJ picks up by itself. The files sm2
+// wrote before are replaced, so the debug ports stay in step with the config and whatever's running.
+
+const intellijFilePrefix = "sm2_"
+
+const intellijShellConfig = `<component name="ProjectRunConfigurationManager">
+  <configuration default="false" name="%s" type="ShConfigurationType">
+    <option name="SCRIPT_TEXT" value="%s" />
+    <option name="INDEPENDENT_SCRIPT_PATH" value="true" />
+    <option name="SCRIPT_PATH" value="" />
+    <option name="SCRIPT_OPTIONS" value="" />
+    <option name="INDEPENDENT_SCRIPT_WORKING_DIRECTORY" value="true" />
+    <option name="SCRIPT_WORKING_DIRECTORY" value="$PROJECT_DIR$" />
+    <option name="INDEPENDENT_INTERPRETER_PATH" value="true" />
+    <option name="INTERPRETER_PATH" value="/bin/sh" />
+    <option name="INTERPRETER_OPTIONS" value="" />
+    <option name="EXECUTE_IN_TERMINAL" value="true" />
+    <option name="EXECUTE_SCRIPT_FILE" value="false" />
+    <envs />
+    <method v="2" />
+  </configuration>
+</component>
+`
+
+const intellijRemoteConfig = `<component name="ProjectRunConfigurationManager">
+  <configuration default="false" name="%s" type="Remote">
+    <option name="USE_SOCKET_TRANSPORT" value="true" />
+    <option name="SERVER_MODE" value="false" />
+    <option name="SHMEM_ADDRESS" />
+    <option name="HOST" value="localhost" />
+    <option name="PORT" value="%d" />
+    <option name="AUTO_RESTART" value="false" />
+    <RunnerSettings RunnerId="Debug">
+      <option name="DEBUG_PORT" value="%d" />
+      <option name="LOCAL" value="false" />
+    </RunnerSettings>
+    <method v="2" />
+  </configuration>
+</component>
+`
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func (sm *ServiceManager) ExportIntellij(dir string, out io.Writer) error {
+	names, err := sm.ideExportNames("-export-intellij")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// configs for services that have since left the profile would otherwise hang around
+	old, err := filepath.Glob(path.Join(dir, intellijFilePrefix+"*.run.xml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range old {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	files := map[string]string{}
+	for _, task := range ideTasks(names) {
+		files[task.label] = fmt.Sprintf(intellijShellConfig, plistEscape(vscodePrefix+task.label), plistEscape("sm2 "+strings.Join(task.args, " ")))
+	}
+	targets := sm.debugTargets()
+	for _, target := range targets {
+		label := "attach to " + target.service
+		files[label] = fmt.Sprintf(intellijRemoteConfig, plistEscape(vscodePrefix+label), target.port, target.port)
+	}
+
+	for label, content := range files {
+		file := path.Join(dir, intellijFilePrefix+strings.Trim(unsafeFileChars.ReplaceAllString(label, "_"), "_")+".run.xml")
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "Wrote %d run configuration(s) to %s, %d of them to attach a debugger\n", len(files), dir, len(targets))
+	return nil
+}
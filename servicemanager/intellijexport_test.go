@@ -0,0 +1,75 @@
+package servicemanager
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestExportIntellij(t *testing.T) {
+	dir := path.Join(t.TempDir(), ".run")
+	AssertNotErr(t, os.MkdirAll(dir, 0755))
+	// the user's own config, and an old one sm2 wrote
+	AssertNotErr(t, os.WriteFile(path.Join(dir, "build.run.xml"), []byte("<component />"), 0644))
+	AssertNotErr(t, os.WriteFile(path.Join(dir, "sm2_attach_to_OLD.run.xml"), []byte("<component />"), 0644))
+
+	sm := ServiceManager{
+		Services: Services{
+			"AUTH":     {Id: "AUTH", DefaultPort: 8500},
+			"PAYMENTS": {Id: "PAYMENTS", DefaultPort: 9050, DebugPort: 5005},
+			"QA_API":   {Id: "QA_API", DefaultPort: 9100, Type: TunnelType},
+		},
+		Profiles: Profiles{"CORE": {"AUTH", "PAYMENTS", "QA_API"}},
+		Commands: cli.UserOption{ExtraServices: []string{"CORE"}},
+		Ledger: ledger.Ledger{LoadStateFile: func(string) (ledger.StateFile, error) {
+			return ledger.StateFile{}, fmt.Errorf("not running")
+		}},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportIntellij(dir, &out))
+
+	files, err := filepath.Glob(path.Join(dir, "*.xml"))
+	AssertNotErr(t, err)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, path.Base(file))
+	}
+	expected := []string{
+		"build.run.xml",
+		"sm2_attach_to_AUTH.run.xml",
+		"sm2_attach_to_PAYMENTS.run.xml",
+		"sm2_start_CORE.run.xml",
+		"sm2_start_CORE_with_debugger.run.xml",
+		"sm2_status.run.xml",
+		"sm2_stop_CORE.run.xml",
+	}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("expected %v got %v", expected, names)
+	}
+
+	data, err := os.ReadFile(path.Join(dir, "sm2_start_CORE_with_debugger.run.xml"))
+	AssertNotErr(t, err)
+	if !strings.Contains(string(data), `name="sm2: start CORE with debugger" type="ShConfigurationType"`) ||
+		!strings.Contains(string(data), `value="sm2 -start CORE -noprogress -jdwp"`) {
+		t.Errorf("unexpected start config %s", data)
+	}
+
+	data, err = os.ReadFile(path.Join(dir, "sm2_attach_to_PAYMENTS.run.xml"))
+	AssertNotErr(t, err)
+	if !strings.Contains(string(data), `type="Remote"`) || !strings.Contains(string(data), `<option name="PORT" value="5005" />`) {
+		t.Errorf("unexpected debug config %s", data)
+	}
+
+	if !strings.Contains(out.String(), "Wrote 6 run configuration(s) to "+dir+", 2 of them to attach a debugger") {
+		t.Errorf("unexpected output %s", out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package servicemanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Ctrl-c (or a SIGTERM) used to exit sm2 straight away, leaving whatever it was in the middle of installing
+// half downloaded. Now it cancels sm2's context instead, which stops the downloads and metadata lookups in
+// progress and the services that haven't started yet, then waits (for up to interruptGrace) for the installs
+// to remove what they'd extracted and release their locks before it exits. A second ctrl-c exits right away.
+// Either way it exits with ExitInterrupted, so a cancelled job isn't mistaken for one that worked.
+
+const interruptGrace = 10 * time.Second
+
+var ErrInterrupted = errors.New("Interrupted")
+
+type interrupter struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lock     sync.Mutex
+	installs sync.WaitGroup // the installs in progress, which clean up after themselves when cancelled
+}
+
+func newInterrupter() *interrupter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &interrupter{ctx: ctx, cancel: cancel}
+}
+
+// cancels sm's context on ctrl-c, cleans up and exits
+func (sm *ServiceManager) HandleInterrupts() {
+	sm.interrupts = newInterrupter()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		sm.interrupts.interrupt()
+		select {
+		case <-sm.interrupts.finished():
+		case <-signals:
+		case <-time.After(interruptGrace):
+		}
+		sm.exitInterrupted()
+	}()
+}
+
+// exits with ExitInterrupted if sm2 was interrupted, which is also checked once a command has finished, as
+// it can finish before the interrupt handler does
+func (sm *ServiceManager) ExitIfInterrupted() {
+	if sm.interrupts != nil && sm.interrupts.ctx.Err() != nil {
+		sm.exitInterrupted()
+	}
+}
+
+func (sm *ServiceManager) exitInterrupted() {
+	sm.Ledger.ClearProxyState(sm.Config.TmpDir)
+	os.Exit(ExitInterrupted)
+}
+
+// the context everything that can be interrupted runs in
+func (sm *ServiceManager) context() context.Context {
+	if sm.interrupts == nil {
+		return context.Background()
+	}
+	return sm.interrupts.ctx
+}
+
+// registers an install, so it's waited for when sm2 is interrupted. The func it returns is called once
+// it's finished, and cleaned up if it failed
+func (i *interrupter) installing() (func(), error) {
+	if i == nil {
+		return func() {}, nil
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.ctx.Err() != nil {
+		return nil, ErrInterrupted
+	}
+	i.installs.Add(1)
+	return i.installs.Done, nil
+}
+
+func (i *interrupter) interrupt() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.cancel()
+}
+
+// closed once the installs in progress have finished
+func (i *interrupter) finished() chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		i.installs.Wait()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,80 @@
+package servicemanager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestInterruptCancelsAnInstallAndCleansUp(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "foo-1.0.1/lib/foo.jar", Mode: 0644, Size: 100})
+	tw.Write(make([]byte, 100))
+	tw.Flush()
+	gz.Flush()
+
+	// sends the start of the tgz, then hangs until the request's cancelled
+	sent := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes())
+		w.(http.Flusher).Flush()
+		close(sent)
+		<-r.Context().Done()
+	}))
+	defer svr.Close()
+
+	installDir := path.Join(t.TempDir(), "foo")
+	sm := ServiceManager{
+		Client:     &http.Client{},
+		progress:   ProgressRenderer{noProgress: true},
+		interrupts: newInterrupter(),
+	}
+
+	installed, err := sm.interrupts.installing()
+	AssertNotErr(t, err)
+	result := make(chan error)
+	go func() {
+		defer installed()
+		_, err := sm.installService(installDir, "FOO", svr.URL, "foo_2.12", "1.0.1", nil)
+		result <- err
+	}()
+
+	<-sent
+	sm.interrupts.interrupt()
+	finished := sm.interrupts.finished()
+
+	select {
+	case err := <-result:
+		if err != ErrInterrupted {
+			t.Errorf("expected the install to be interrupted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the download to be cancelled")
+	}
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the install to be finished with")
+	}
+
+	files, err := os.ReadDir(installDir)
+	AssertNotErr(t, err)
+	if len(files) != 0 {
+		t.Errorf("expected the partial install to be removed, found %v", files)
+	}
+
+	// and nothing else is installed once it's been interrupted
+	if _, err := sm.interrupts.installing(); err != ErrInterrupted {
+		t.Errorf("expected new installs to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestFindAllStateFilesUsesTheInventory(t *testing.T) {
+	tmpDir := t.TempDir()
+	l := ledger.NewLedger()
+	for _, dir := range []string{"auth", "email", "notes"} {
+		os.Mkdir(path.Join(tmpDir, dir), 0755)
+	}
+	l.SaveStateFile(path.Join(tmpDir, "auth"), ledger.StateFile{Service: "AUTH"})
+	l.SaveStateFile(path.Join(tmpDir, "email"), ledger.StateFile{Service: "EMAIL"})
+
+	services := func() []string {
+		states, err := l.FindAllStateFiles(tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, state := range states {
+			names = append(names, state.Service)
+		}
+		return names
+	}
+
+	if found := services(); len(found) != 2 || found[0] != "AUTH" || found[1] != "EMAIL" {
+		t.Errorf("expected AUTH and EMAIL, got %v", found)
+	}
+	if _, err := os.Stat(path.Join(tmpDir, ".inventory")); err != nil {
+		t.Errorf("expected the inventory to be written: %s", err)
+	}
+
+	// a state file that didn't go through the ledger isn't looked for
+	os.WriteFile(path.Join(tmpDir, "notes", ".state"), []byte(`{"Service": "NOTES"}`), 0644)
+	if found := services(); len(found) != 2 {
+		t.Errorf("expected only the services in the inventory, got %v", found)
+	}
+
+	l.ClearStateFile(path.Join(tmpDir, "auth"))
+	if found := services(); len(found) != 1 || found[0] != "EMAIL" {
+		t.Errorf("expected AUTH to be removed from the inventory, got %v", found)
+	}
+
+	// a dir removed along with its state file is skipped
+	os.RemoveAll(path.Join(tmpDir, "email"))
+	if found := services(); len(found) != 0 {
+		t.Errorf("expected nothing, got %v", found)
+	}
+
+	// without an inventory, every dir is looked in again
+	os.WriteFile(path.Join(tmpDir, ".inventory"), []byte("not json"), 0644)
+	if found := services(); len(found) != 1 || found[0] != "NOTES" {
+		t.Errorf("expected the dirs to be scanned, got %v", found)
+	}
+}
+
+func TestFindStatusLoadsOnlyThatService(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer svr.Close()
+
+	tmpDir := t.TempDir()
+	l := ledger.NewLedger()
+	l.FindAllStateFiles = func(string) ([]ledger.StateFile, error) {
+		t.Error("expected the other services' state files not to be read")
+		return nil, nil
+	}
+	os.Mkdir(path.Join(tmpDir, "auth"), 0755)
+	l.SaveStateFile(path.Join(tmpDir, "auth"), ledger.StateFile{Service: "AUTH", Pid: 1001, Port: 8500, Started: time.Now().Add(-time.Hour), HealthcheckUrl: svr.URL})
+
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		Platform: platform.Platform{Uptime: mockUptime, PidLookup: func() map[int]int { return map[int]int{1001: 1001} }},
+		Ledger:   l,
+		Services: map[string]Service{
+			"AUTH":  {Id: "AUTH", Binary: ServiceBinary{DestinationSubdir: "auth"}},
+			"EMAIL": {Id: "EMAIL", Binary: ServiceBinary{DestinationSubdir: "email"}},
+		},
+	}
+	sm.Config.TmpDir = tmpDir
+
+	status, ok := sm.findStatus("AUTH")
+	if !ok || status.pid != 1001 || status.health != PASS {
+		t.Errorf("expected AUTH to be running and healthy, got %v %+v", ok, status)
+	}
+	if _, ok := sm.findStatus("EMAIL"); ok {
+		t.Error("expected EMAIL not to be found")
+	}
+}
@@ -0,0 +1,112 @@
+package servicemanager
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Working out the latest version of a service takes a request to artifactory, or one per scala version for
+// _%% artifacts, so a large profile used to spend tens of seconds looking them up a couple at a time while
+// its services were installed. Before a batch is started the latest versions are looked up together instead,
+// and kept until the batch has finished, so each service's own lookup is answered straight away.
+
+// how many maven-metadata.xml files are requested at the same time
+const resolveWorkers = 8
+
+// maven metadata by url, for the batch of services being started
+type latestCache struct {
+	lock     sync.Mutex
+	metadata map[string]MavenMetadata
+}
+
+func (c *latestCache) get(url string) (MavenMetadata, bool) {
+	if c == nil {
+		return MavenMetadata{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	metadata, ok := c.metadata[url]
+	return metadata, ok
+}
+
+func (c *latestCache) put(url string, metadata MavenMetadata) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metadata[url] = metadata
+}
+
+// looks up the versions the services will need, using a bounded number of workers. Failures are left for
+// the service's start to retry and report, and the cache is dropped by the returned func.
+func (sm *ServiceManager) resolveLatestVersions(services []ServiceAndVersion) func() {
+	sm.latest = &latestCache{metadata: map[string]MavenMetadata{}}
+	done := func() { sm.latest = nil }
+
+	if sm.Commands.Offline || sm.Commands.FromSource {
+		return done
+	}
+	toResolve := []ServiceAndVersion{}
+	for _, sv := range services {
+		if sm.needsLatestVersion(sv) {
+			toResolve = append(toResolve, sv)
+		}
+	}
+	if len(toResolve) < 2 {
+		return done
+	}
+
+	span := sm.tracer.StartSpan("resolve versions", nil, "sm2.services", fmt.Sprint(len(toResolve)))
+	defer span.End(nil)
+
+	sm.lookUpLatestVersions(toResolve)
+	return done
+}
+
+// looks up the versions of the services on resolveWorkers at a time, returning how many couldn't be found
+func (sm *ServiceManager) lookUpLatestVersions(services []ServiceAndVersion) int {
+	tasks := make(chan ServiceAndVersion, len(services))
+	for _, sv := range services {
+		tasks <- sv
+	}
+	close(tasks)
+
+	workers := resolveWorkers
+	if len(services) < workers {
+		workers = len(services)
+	}
+	failed := int32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sv := range tasks {
+				if _, _, _, err := whatVersionToRun(sm.Services[sv.service], sv, false, sm.GetLatestVersions); err != nil {
+					sm.PrintVerbose("unable to resolve the latest version of %s: %s\n", sv.service, err)
+					atomic.AddInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(failed)
+}
+
+// true for services installed from artifactory that will look up a version there
+func (sm *ServiceManager) needsLatestVersion(sv ServiceAndVersion) bool {
+	service, ok := sm.Services[sv.service]
+	if !ok || service.Binary.Artifact == "" {
+		return false
+	}
+	if service.Type == TunnelType || service.Type == PactType || service.Type == WiremockType || service.Runtime == NativeRuntime {
+		return false
+	}
+	if sm.isStubbed(service.Id) || sm.runsInDocker(service) {
+		return false
+	}
+	// a given version only needs a look up to find its scala version
+	return sv.version == "" || latestVersionScalaVersionSuffix.MatchString(service.Binary.Artifact)
+}
@@ -0,0 +1,76 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestResolveLatestVersionsConcurrently(t *testing.T) {
+	lock := sync.Mutex{}
+	requests, inFlight, mostInFlight := 0, 0, 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		inFlight++
+		if inFlight > mostInFlight {
+			mostInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		fmt.Fprint(w, mavenMetadata)
+	}))
+	defer svr.Close()
+
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		Config:   ServiceManagerConfig{ArtifactoryRepoUrl: svr.URL},
+		Services: Services{},
+	}
+	services := []ServiceAndVersion{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("SERVICE_%d", i)
+		sm.Services[id] = Service{Id: id, Binary: ServiceBinary{GroupId: "foo.bar", Artifact: fmt.Sprintf("foo-%d_2.12", i)}}
+		services = append(services, ServiceAndVersion{service: id})
+	}
+	// neither of these need a look up
+	sm.Services["PINNED"] = Service{Id: "PINNED", Binary: ServiceBinary{GroupId: "foo.bar", Artifact: "pinned_2.12"}}
+	sm.Services["TUNNEL"] = Service{Id: "TUNNEL", Type: TunnelType}
+	services = append(services, ServiceAndVersion{service: "PINNED", version: "1.0.0"}, ServiceAndVersion{service: "TUNNEL"})
+
+	done := sm.resolveLatestVersions(services)
+	if requests != 20 {
+		t.Errorf("expected 20 versions to be looked up, got %d", requests)
+	}
+	if mostInFlight < 2 || mostInFlight > resolveWorkers {
+		t.Errorf("expected between 2 and %d look ups at a time, got %d", resolveWorkers, mostInFlight)
+	}
+
+	// starting the services uses what was looked up
+	_, _, version, err := whatVersionToRun(sm.Services["SERVICE_3"], services[3], false, sm.GetLatestVersions)
+	AssertNotErr(t, err)
+	if version != "2.33.0" || requests != 20 {
+		t.Errorf("expected 2.33.0 without another look up, got %s after %d requests", version, requests)
+	}
+
+	// and once the batch has finished, versions are looked up again
+	done()
+	if _, err := sm.GetLatestVersions(sm.Services["SERVICE_3"].Binary, "", ""); err != nil || requests != 21 {
+		t.Errorf("expected the version to be looked up again, got %d requests, %v", requests, err)
+	}
+}
+
+func TestResolveLatestVersionsOffline(t *testing.T) {
+	sm := ServiceManager{Services: Services{"AUTH": {Id: "AUTH", Binary: ServiceBinary{Artifact: "auth"}}, "EMAIL": {Id: "EMAIL", Binary: ServiceBinary{Artifact: "email"}}}}
+	sm.Commands.Offline = true
+	// there's no client, so a look up would panic
+	sm.resolveLatestVersions([]ServiceAndVersion{{service: "AUTH"}, {service: "EMAIL"}})()
+}
@@ -0,0 +1,88 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Prints a LaunchAgent that runs the daemon for a profile (or service) whenever you log in on macOS, so
+// the profile is started and kept running, e.g.
+//
+//	sm2 -export-launchd MY_PROFILE > ~/Library/LaunchAgents/sm2.my-profile.plist
+//	launchctl load ~/Library/LaunchAgents/sm2.my-profile.plist
+
+func (sm *ServiceManager) ExportLaunchd(name string, out io.Writer) error {
+	name = sm.renamedService(name)
+	if _, isProfile := sm.Profiles[name]; !isProfile {
+		if _, isService := sm.Services[parseServiceAndVersion(name).service]; !isService {
+			return fmt.Errorf("%s is not a service or a profile", name)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	label := launchdLabel(name)
+	args := []string{exe, "-daemon", name}
+	if sm.Commands.Config != "" {
+		args = append(args, "-config", sm.Commands.Config)
+	}
+	// launchd starts agents with a bare environment, so sm2 needs telling where things are
+	env := [][2]string{{"WORKSPACE", sm.Config.WorkspaceDir}}
+	for _, key := range []string{"PATH", "JAVA_HOME"} {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, [2]string{key, value})
+		}
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	fmt.Fprintf(&b, "<!-- generated by sm2 -export-launchd %s, save it as ~/Library/LaunchAgents/%s.plist then run:\n", plistEscape(name), label)
+	fmt.Fprintf(&b, "     launchctl load ~/Library/LaunchAgents/%s.plist -->\n", label)
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", label)
+	b.WriteString("  <key>ProgramArguments</key>\n  <array>\n")
+	for _, arg := range args {
+		fmt.Fprintf(&b, "    <string>%s</string>\n", plistEscape(arg))
+	}
+	b.WriteString("  </array>\n")
+	b.WriteString("  <key>EnvironmentVariables</key>\n  <dict>\n")
+	for _, kv := range env {
+		fmt.Fprintf(&b, "    <key>%s</key>\n    <string>%s</string>\n", kv[0], plistEscape(kv[1]))
+	}
+	b.WriteString("  </dict>\n")
+	b.WriteString("  <key>RunAtLoad</key>\n  <true/>\n")
+	// the daemon runs until it's stopped, so if it exits something went wrong
+	b.WriteString("  <key>KeepAlive</key>\n  <true/>\n")
+	b.WriteString("  <key>ThrottleInterval</key>\n  <integer>60</integer>\n")
+	logFile := path.Join(home, "Library", "Logs", label+".log")
+	fmt.Fprintf(&b, "  <key>StandardOutPath</key>\n  <string>%s</string>\n", plistEscape(logFile))
+	fmt.Fprintf(&b, "  <key>StandardErrorPath</key>\n  <string>%s</string>\n", plistEscape(logFile))
+	b.WriteString("</dict>\n</plist>\n")
+
+	_, err = out.Write(b.Bytes())
+	return err
+}
+
+func launchdLabel(name string) string {
+	name = strings.NewReplacer("_", "-", ":", "-").Replace(strings.ToLower(name))
+	return "sm2." + name
+}
+
+func plistEscape(value string) string {
+	b := strings.Builder{}
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestExportLaunchd(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{"AUTH": {Id: "AUTH"}},
+		Profiles: Profiles{"CORE_STACK": {"AUTH"}},
+		Config:   ServiceManagerConfig{WorkspaceDir: "/Users/me/sm2 & co"},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportLaunchd("CORE_STACK", &out))
+	plist := out.String()
+
+	for _, expected := range []string{
+		"save it as ~/Library/LaunchAgents/sm2.core-stack.plist",
+		"<key>Label</key>\n  <string>sm2.core-stack</string>",
+		"<string>-daemon</string>\n    <string>CORE_STACK</string>\n  </array>",
+		"<key>WORKSPACE</key>\n    <string>/Users/me/sm2 &amp; co</string>",
+		"<key>RunAtLoad</key>\n  <true/>",
+		"Library/Logs/sm2.core-stack.log</string>",
+	} {
+		if !strings.Contains(plist, expected) {
+			t.Errorf("expected the plist to contain %q, got\n%s", expected, plist)
+		}
+	}
+
+	// it has to be valid xml for launchd to load it
+	decoder := xml.NewDecoder(strings.NewReader(plist))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			if err.Error() != "EOF" {
+				t.Errorf("invalid xml: %s", err)
+			}
+			break
+		}
+	}
+
+	AssertNotErr(t, sm.ExportLaunchd("AUTH", &out))
+	if err := sm.ExportLaunchd("UNKNOWN", &out); err == nil {
+		t.Errorf("expected an unknown profile to be rejected")
+	}
+}
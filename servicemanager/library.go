@@ -0,0 +1,268 @@
+package servicemanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+// sm2 can be used as a library, so Go integration tests can start the services they depend on without
+// shelling out to the binary, e.g.
+//
+//	sm, err := servicemanager.New(servicemanager.Options{NoVpnCheck: true})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := sm.Start("AUTH", "PAYMENTS:1.4.0"); err != nil {
+//		t.Fatal(err)
+//	}
+//	defer sm.Stop("AUTH", "PAYMENTS")
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+//	defer cancel()
+//	if err := sm.AwaitHealthy(ctx, "AUTH", "PAYMENTS"); err != nil {
+//		t.Fatal(err)
+//	}
+//	port, err := sm.Port("PAYMENTS")
+//
+// Anywhere a service is named, a profile or SERVICE:VERSION can be used too. The workspace, config and
+// personal defaults are the same ones the command line uses, and what sm2 is doing is still printed to
+// stdout, but there are no progress bars.
+
+// Options for New, the equivalent of the command line flags a library user is likely to want
+type Options struct {
+	Workspace    string // the workspace dir, $WORKSPACE (or the workspace chosen with -use-workspace) if it's empty
+	Config       string // a service-manager-config dir other than the workspace's, like -config
+	Offline      bool   // only runs services that are already installed, like -offline
+	Clean        bool   // installs services again even if they're already installed, like -clean
+	NoVpnCheck   bool   // doesn't check artifactory can be reached before installing, like -no-vpn-check
+	DynamicPorts bool   // starts services on free ports, so they don't clash with anything already running, like -dynamic-ports
+}
+
+// A service resolved to the version that would be installed
+type ResolvedService struct {
+	Service  string
+	Group    string
+	Artifact string // the artifact, or the docker image
+	Version  string // empty for tunnels and native databases etc, which aren't installed
+}
+
+// New loads the config, ready to manage services
+func New(options Options) (*ServiceManager, error) {
+	workspace, isSet := options.Workspace, true
+	if workspace == "" {
+		workspace, isSet = cli.Workspace()
+	}
+	defaultsFile := path.Join(workspace, "config")
+	defaults, err := cli.LoadDefaults(defaultsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read your defaults in %s: %s", defaultsFile, err)
+	}
+	settings := map[string]string{}
+	if defaults != nil {
+		settings = defaults.Settings
+	}
+
+	sm := &ServiceManager{
+		Client: &http.Client{Timeout: 30 * time.Minute},
+		Commands: cli.UserOption{
+			Config:       options.Config,
+			Offline:      options.Offline,
+			Clean:        options.Clean,
+			NoVpnCheck:   options.NoVpnCheck,
+			DynamicPorts: options.DynamicPorts,
+			NoProgress:   true,
+			Settings:     settings,
+		},
+		Platform: platform.DetectPlatform(),
+		Ledger:   ledger.NewLedger(),
+	}
+	sm.progress.noProgress = true
+
+	if err := sm.loadConfig(workspace, isSet); err != nil {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(err.Error()))
+	}
+	return sm, nil
+}
+
+// the services named, with profiles expanded, which all have to exist
+func (sm *ServiceManager) expandNames(names []string) ([]ServiceAndVersion, error) {
+	services := []ServiceAndVersion{}
+	for _, name := range names {
+		if _, ok := sm.Profiles[name]; ok {
+			for _, id := range sm.expandProfile(name) {
+				services = append(services, ServiceAndVersion{service: sm.renamedService(id)})
+			}
+			continue
+		}
+		sv := parseServiceAndVersion(name)
+		sv.service = sm.renamedService(sv.service)
+		if _, ok := sm.Services[sv.service]; !ok {
+			return nil, fmt.Errorf("%s is not a service or a profile", name)
+		}
+		services = append(services, sv)
+	}
+	return services, nil
+}
+
+// Resolve works out which version of each service would be installed, looking up the latest version
+// in artifactory unless one is given
+func (sm *ServiceManager) Resolve(names ...string) ([]ResolvedService, error) {
+	services, err := sm.expandNames(names)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := []ResolvedService{}
+	for _, sv := range services {
+		service := sm.Services[sv.service]
+		switch {
+		case service.Type == TunnelType || service.Type == PactType || service.Runtime == NativeRuntime:
+			resolved = append(resolved, ResolvedService{Service: sv.service})
+		case service.Type == WiremockType:
+			resolved = append(resolved, ResolvedService{Service: sv.service, Artifact: "wiremock-standalone", Version: wiremockVersion(service)})
+		case sm.runsInDocker(service):
+			image := dockerImage(service, sv.version)
+			resolved = append(resolved, ResolvedService{Service: sv.service, Artifact: image, Version: imageTag(image)})
+		default:
+			group, artifact, version, err := whatVersionToRun(service, sv, sm.Commands.Offline, sm.GetLatestVersions)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve %s: %s", sv.service, err)
+			}
+			resolved = append(resolved, ResolvedService{Service: sv.service, Group: group, Artifact: artifact, Version: version})
+		}
+	}
+	return resolved, nil
+}
+
+// Install downloads the services, or pulls their images, without starting them
+func (sm *ServiceManager) Install(names ...string) error {
+	services, err := sm.expandNames(names)
+	if err != nil {
+		return err
+	}
+
+	for _, sv := range services {
+		service := sm.Services[sv.service]
+		switch {
+		case service.Type == TunnelType || service.Runtime == NativeRuntime:
+			continue
+		case service.Type == WiremockType:
+			if _, err := sm.wiremockJar(service); err != nil {
+				return err
+			}
+		case service.Type == PactType:
+			if _, err := sm.pactStubServer(); err != nil {
+				return err
+			}
+		case sm.runsInDocker(service):
+			if sm.Commands.Offline {
+				continue
+			}
+			if err := pullImage(dockerImage(service, sv.version), sm.Commands.Clean); err != nil {
+				return err
+			}
+		default:
+			installDir, err := sm.findInstallDirOfService(sv.service)
+			if err != nil {
+				return err
+			}
+			if _, _, err := sm.installVersion(service, sv, installDir, nil); err != nil {
+				return fmt.Errorf("unable to install %s: %s", sv.service, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Start installs the services if they need to be and starts them, one at a time. Services that are
+// already running are left alone. It doesn't wait for them to be healthy, see AwaitHealthy.
+func (sm *ServiceManager) Start(names ...string) error {
+	services, err := sm.expandNames(names)
+	if err != nil {
+		return err
+	}
+
+	sm.planPorts(services, nil, os.Stdout)
+	for _, sv := range services {
+		if err := sm.StartService(sv); err != nil && err != ErrAlreadyRunning {
+			return fmt.Errorf("unable to start %s: %s", sv.service, err)
+		}
+	}
+	return nil
+}
+
+// how often AwaitHealthy checks the services
+const awaitHealthyInterval = time.Second
+
+// AwaitHealthy waits until every service passes its health check, failing straight away if one of them
+// stops, or when ctx is done
+func (sm *ServiceManager) AwaitHealthy(ctx context.Context, names ...string) error {
+	services, err := sm.expandNames(names)
+	if err != nil {
+		return err
+	}
+
+	for {
+		statuses := map[string]serviceStatus{}
+		for _, status := range sm.findStatuses() {
+			statuses[status.service] = status
+		}
+		pids := sm.Platform.PidLookup()
+
+		waiting := []string{}
+		for _, sv := range services {
+			status, started := statuses[sv.service]
+			if !started {
+				return fmt.Errorf("%s hasn't been started", sv.service)
+			}
+			if _, running := pids[status.pid]; !running && status.version != SOURCE {
+				return fmt.Errorf("%s has stopped, run sm2 -why-failed %s to find out why", sv.service, sv.service)
+			}
+			if status.health != PASS {
+				waiting = append(waiting, sv.service)
+			}
+		}
+		if len(waiting) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s didn't become healthy: %s", strings.Join(waiting, ", "), ctx.Err())
+		case <-time.After(awaitHealthyInterval):
+		}
+	}
+}
+
+// Stop stops the services that are running
+func (sm *ServiceManager) Stop(names ...string) error {
+	services, err := sm.expandNames(names)
+	if err != nil {
+		return err
+	}
+	for _, sv := range services {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Port returns the port a running service is listening on, which is only its default port if it wasn't
+// started with dynamic ports and there was no clash
+func (sm *ServiceManager) Port(name string) (int, error) {
+	id := sm.renamedService(parseServiceAndVersion(name).service)
+	if port, ok := sm.runningServicePorts()[id]; ok {
+		return port, nil
+	}
+	return 0, fmt.Errorf("%s isn't running", id)
+}
@@ -0,0 +1,98 @@
+package servicemanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestNewLoadsTheWorkspace(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	workspace := t.TempDir()
+	configDir := path.Join(workspace, "service-manager-config")
+	AssertNotErr(t, os.MkdirAll(configDir, 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(configDir, "services.json"), []byte(`{
+		"AUTH": {"defaultPort": 8500, "binary": {"groupId": "uk.gov.example", "artifact": "auth", "cmd": ["./auth/bin/auth"]}},
+		"PAYMENTS": {"defaultPort": 9050, "runtime": "docker", "docker": {"image": "ghcr.io/example/payments:1.4.0"}}
+	}`), 0644))
+	AssertNotErr(t, os.WriteFile(path.Join(configDir, "profiles.json"), []byte(`{"CORE": ["AUTH", "PAYMENTS"]}`), 0644))
+
+	sm, err := New(Options{Workspace: workspace, Offline: true})
+	AssertNotErr(t, err)
+	if len(sm.Services) != 2+len(infraTypes) || sm.Config.TmpDir != path.Join(workspace, "install") {
+		t.Fatalf("expected the workspace's config to be loaded, got %d services in %s", len(sm.Services), sm.Config.TmpDir)
+	}
+
+	resolved, err := sm.Resolve("CORE", "AUTH:1.2.0")
+	AssertNotErr(t, err)
+	if len(resolved) != 3 {
+		t.Fatalf("expected the profile to be expanded, got %+v", resolved)
+	}
+	if resolved[1].Artifact != "ghcr.io/example/payments:1.4.0" || resolved[1].Version != "1.4.0" {
+		t.Errorf("expected the docker image, got %+v", resolved[1])
+	}
+	if resolved[2].Group != "uk.gov.example" || resolved[2].Artifact != "auth" || resolved[2].Version != "1.2.0" {
+		t.Errorf("expected the version given, got %+v", resolved[2])
+	}
+
+	if _, err := sm.Resolve("NOPE"); err == nil {
+		t.Errorf("expected an unknown service to fail")
+	}
+	if err := sm.Install("AUTH:1.2.0"); err == nil || !strings.Contains(err.Error(), "Not available offline") {
+		t.Errorf("expected an uninstalled service not to be available offline, got %v", err)
+	}
+
+	if _, err := New(Options{Workspace: t.TempDir()}); err == nil {
+		t.Errorf("expected a workspace without config to fail")
+	}
+}
+
+func TestAwaitHealthy(t *testing.T) {
+	healthy := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	state := ledger.StateFile{Service: "AUTH", Port: 8500, Pid: 1, Started: time.Now(), HealthcheckUrl: server.URL}
+	pids := map[int]int{1: 1}
+	sm := ServiceManager{
+		Client:   &http.Client{Timeout: time.Second},
+		Services: Services{"AUTH": {Id: "AUTH", DefaultPort: 8500}},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{state}, nil
+		}},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return pids },
+			Uptime:    func() time.Time { return time.Now().Add(-time.Hour) },
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sm.AwaitHealthy(ctx, "AUTH"); err == nil || !strings.Contains(err.Error(), "AUTH didn't become healthy") {
+		t.Errorf("expected AUTH not to become healthy, got %v", err)
+	}
+
+	healthy = true
+	AssertNotErr(t, sm.AwaitHealthy(context.Background(), "AUTH"))
+	if port, err := sm.Port("AUTH"); err != nil || port != 8500 {
+		t.Errorf("expected AUTH's port, got %d %v", port, err)
+	}
+
+	delete(pids, 1)
+	if err := sm.AwaitHealthy(context.Background(), "AUTH"); err == nil || !strings.Contains(err.Error(), "AUTH has stopped") {
+		t.Errorf("expected AUTH to have stopped, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Finds mistakes that are valid json but only show up as confusing failures at runtime:
+// services with the same name, port or artifact, and profiles that refer to things that don't exist.
+func lintConfig(services Services, profiles Profiles) []string {
+	problems := []string{}
+
+	byName := map[string][]string{}
+	byArtifact := map[string][]string{}
+
+	for id, s := range services {
+		// renamed and deprecated services are expected to look like the service that replaced them
+		if s.RenamedTo != "" || s.Deprecated {
+			continue
+		}
+		if s.Name != "" {
+			name := strings.ToLower(s.Name)
+			byName[name] = append(byName[name], id)
+		}
+		problems = append(problems, lintTunnel(id, s)...)
+		problems = append(problems, lintDocker(id, s)...)
+		problems = append(problems, lintWiremock(id, s)...)
+		problems = append(problems, lintPact(id, s)...)
+		if f := s.Healthcheck.Family; f != "" && f != FamilyIPv4 && f != FamilyIPv6 {
+			problems = append(problems, fmt.Sprintf("%s has an unknown healthcheck family %s, expected %s or %s", id, f, FamilyIPv4, FamilyIPv6))
+		}
+		if s.Binary.Artifact != "" {
+			coords := strings.ReplaceAll(strings.Trim(s.Binary.GroupId, "/"), "/", ".") + ":" + s.Binary.Artifact
+			byArtifact[coords] = append(byArtifact[coords], id)
+		}
+	}
+
+	problems = append(problems, duplicates(byName, "have the same name")...)
+	problems = append(problems, portClashes(services)...)
+	problems = append(problems, duplicates(byArtifact, "use the same artifact")...)
+
+	for profile, ids := range profiles {
+		for _, id := range ids {
+			_, isService := services[id]
+			_, isProfile := profiles[id]
+			if !isService && !isProfile {
+				problems = append(problems, fmt.Sprintf("profile %s: %s is not a service or a profile", profile, id))
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+func portClashes(services Services) []string {
+	byPort := map[string][]string{}
+	for id, s := range services {
+		if s.DefaultPort != 0 && s.RenamedTo == "" && !s.Deprecated {
+			port := fmt.Sprint(s.DefaultPort)
+			byPort[port] = append(byPort[port], id)
+		}
+	}
+	return duplicates(byPort, "use the same default port")
+}
+
+func duplicates(groups map[string][]string, problem string) []string {
+	found := []string{}
+	for value, ids := range groups {
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			found = append(found, fmt.Sprintf("%s %s (%s)", strings.Join(ids, ", "), problem, value))
+		}
+	}
+	return found
+}
+
+// prints any problems found by lintConfig, returns false if there were any
+func (sm *ServiceManager) LintConfig(out io.Writer) bool {
+	problems := lintConfig(sm.Services, sm.Profiles)
+	if sm.portPolicyEnabled() {
+		problems = append(problems, portRangeProblems(sm.Services, sm.Config.PortRanges)...)
+		sort.Strings(problems)
+	}
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "No problems found in %d services and %d profiles.\n", len(sm.Services), len(sm.Profiles))
+		return true
+	}
+	fmt.Fprintf(out, "\nFound %d problem(s) in %s\n", len(problems), sm.Config.ConfigDir)
+	return false
+}
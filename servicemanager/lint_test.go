@@ -0,0 +1,30 @@
+package servicemanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintConfig(t *testing.T) {
+	services := Services{
+		"AUTH":     {Name: "Auth", DefaultPort: 8500, Binary: ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "auth_%%"}},
+		"AUTH_V2":  {Name: "auth", DefaultPort: 8501, Binary: ServiceBinary{GroupId: "uk/gov/hmrc/", Artifact: "auth_%%"}},
+		"USERS":    {Name: "Users", DefaultPort: 8500, Binary: ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "users_%%"}},
+		"OLD_AUTH": {RenamedTo: "AUTH"},
+		"PAY":      {Name: "Pay", DefaultPort: 8600, Binary: ServiceBinary{GroupId: "uk.gov.hmrc", Artifact: "pay_%%"}},
+	}
+	profiles := Profiles{
+		"CORE": {"AUTH", "USERS"},
+		"ALL":  {"CORE", "PAY", "PAYMENTS"},
+	}
+
+	expected := []string{
+		"AUTH, AUTH_V2 have the same name (auth)",
+		"AUTH, AUTH_V2 use the same artifact (uk.gov.hmrc:auth_%%)",
+		"AUTH, USERS use the same default port (8500)",
+		"profile ALL: PAYMENTS is not a service or a profile",
+	}
+	if problems := lintConfig(services, profiles); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, problems)
+	}
+}
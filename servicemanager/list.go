@@ -44,9 +44,9 @@ func (sm *ServiceManager) ListPorts() {
 func (sm *ServiceManager) ListServices(filter string, formatPlain bool) {
 
 	// check if its a profile, list services and exit
-	if profile, ok := sm.Profiles[strings.ToUpper(filter)]; ok {
+	if _, ok := sm.Profiles[strings.ToUpper(filter)]; ok {
 		fmt.Printf("Profile %s has these services:\n", strings.ToUpper(filter))
-		for _, p := range profile {
+		for _, p := range sm.expandProfile(strings.ToUpper(filter)) {
 			fmt.Printf("  - %s\n", p)
 		}
 		return
@@ -101,7 +101,8 @@ func (sm *ServiceManager) printServiceListFormatted(keys []string, searchTerm st
 		if service, ok := sm.Services[k]; ok {
 			fmt.Printf("[SERVICE] %s -> %s\n", pad(service.Id, longestKey), service.Name)
 		}
-		if profile, ok := sm.Profiles[k]; ok {
+		if _, ok := sm.Profiles[k]; ok {
+			profile := sm.expandProfile(k)
 			fmt.Printf("[PROFILE] %s -> (%d services)\n", pad(k, longestKey), len(profile))
 			for _, profileService := range profile {
 				fmt.Printf("  - %s\n", profileService)
@@ -23,34 +23,45 @@ func initLogDir(serviceDir string) (string, error) {
 
 func (sm *ServiceManager) PrintLogsForService(serviceName string) {
 
-	installDir, err := sm.findInstallDirOfService(serviceName)
+	pathToLog, err := sm.findLogFile(serviceName)
 	if err != nil {
-		fmt.Printf("Couldn't find the logs for %s\n", serviceName)
+		fmt.Println(err)
 		return
 	}
 
-	installFile, err := sm.Ledger.LoadInstallFile(installDir)
+	file, err := os.Open(pathToLog)
 	if err != nil {
-		fmt.Printf("Unable to find installation of service in %s\n\t%s", installDir, err)
+		fmt.Printf("Failed to open logfile %s: %s\n", pathToLog, err)
 		return
 	}
 
-	logDir := path.Join(installFile.Path, "logs")
+	defer file.Close()
 
-	if !Exists(logDir) {
-		fmt.Printf("Couldn't find the logs for %s\n", serviceName)
-		return
-	}
+	io.Copy(os.Stdout, file)
+}
 
-	pathToLog := path.Join(logDir, "stdout.log")
+// the file a service's stdout is written to
+func (sm *ServiceManager) findLogFile(serviceName string) (string, error) {
 
-	file, err := os.Open(pathToLog)
+	installDir, err := sm.findInstallDirOfService(serviceName)
 	if err != nil {
-		fmt.Printf("Failed to open logfile %s: %s\n", pathToLog, err)
-		return
+		return "", fmt.Errorf("Couldn't find the logs for %s", serviceName)
 	}
 
-	defer file.Close()
+	installFile, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find installation of service in %s\n\t%s", installDir, err)
+	}
 
-	io.Copy(os.Stdout, file)
+	logDir := sm.Services[serviceName].Output.logDir(serviceName, installFile.Path)
+
+	if !Exists(logDir) {
+		return "", fmt.Errorf("Couldn't find the logs for %s", serviceName)
+	}
+
+	pathToLog := sm.Services[serviceName].Output.stdoutFile(logDir)
+	if pathToLog == "" {
+		return "", fmt.Errorf("%s is configured to send its output to %s, not a log file", serviceName, sm.Services[serviceName].Output.Stdout)
+	}
+	return pathToLog, nil
 }
@@ -0,0 +1,140 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	OUTPUT_DISCARD = "discard"
+	OUTPUT_SYSLOG  = "syslog"
+)
+
+const defaultLogFile = "stdout.log"
+
+// Where a service's stdout/stderr should go, configured per service in services.json, e.g.
+//
+//	"output": { "stdout": "discard", "stderr": "stderr.log" }
+//
+// Each stream can be:
+//
+//	""         the default, written to logs/stdout.log (stdout & stderr combined)
+//	"discard"  thrown away
+//	"syslog"   sent to syslog (or journald on systemd) tagged with the service id, via `logger`
+//	otherwise  a file name, relative to the service's logs dir unless its absolute
+//
+// The logs dir is normally inside the installed version of the service, so it's replaced when a new
+// version is installed. "dir" puts it somewhere else instead, e.g. "~/projects/my-service/logs" or
+// "/tmp/sm2-logs/${id}". Relative dirs are relative to the usual logs dir.
+type Output struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Dir    string `json:"dir"`
+}
+
+// the dir the service's log files are written to, serviceDir is where the service was installed or cloned to
+func (o Output) logDir(serviceId string, serviceDir string) string {
+	defaultDir := path.Join(serviceDir, "logs")
+	dir := strings.ReplaceAll(o.Dir, "${id}", serviceId)
+	switch {
+	case dir == "":
+		return defaultDir
+	case dir == "~" || strings.HasPrefix(dir, "~/"):
+		if home, err := os.UserHomeDir(); err == nil {
+			return path.Join(home, dir[1:])
+		}
+	case !path.IsAbs(dir):
+		return path.Join(defaultDir, dir)
+	}
+	return path.Clean(dir)
+}
+
+// returns the file a stream is written to or "" if its not written to a file
+func outputFile(destination string, logDir string) string {
+	switch destination {
+	case OUTPUT_DISCARD, OUTPUT_SYSLOG:
+		return ""
+	case "":
+		return path.Join(logDir, defaultLogFile)
+	}
+	if path.IsAbs(destination) {
+		return destination
+	}
+	return path.Join(logDir, destination)
+}
+
+// the file that contains the service's stdout, used by --logs etc
+func (o Output) stdoutFile(logDir string) string {
+	return outputFile(o.Stdout, logDir)
+}
+
+// opens the stdout and stderr destinations for a service. Streams going to the same file share the same
+// handle so the output is interleaved as it would be in a terminal.
+// The returned files should be closed once the process has started, the child keeps its own copy.
+func (o Output) open(serviceId string, logDir string) (*os.File, *os.File, error) {
+	stdout, err := openOutput(o.Stdout, serviceId, logDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// compared by where they end up, as "" and "stdout.log" (or "x.log" and "./x.log") are the same file
+	if file := outputFile(o.Stdout, logDir); o.Stderr == o.Stdout || (file != "" && file == outputFile(o.Stderr, logDir)) {
+		return stdout, stdout, nil
+	}
+
+	stderr, err := openOutput(o.Stderr, serviceId, logDir)
+	if err != nil {
+		closeOutputs(stdout, nil)
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+func openOutput(destination string, serviceId string, logDir string) (*os.File, error) {
+	switch destination {
+	case OUTPUT_DISCARD:
+		return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	case OUTPUT_SYSLOG:
+		return syslogPipe(serviceId)
+	}
+
+	file := outputFile(destination, logDir)
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	// appended to, so if the streams still end up in the same file (e.g. through a symlink) neither overwrites
+	// the other
+	return os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0666)
+}
+
+// Pipes output into syslog via the `logger` cli. Using a separate process rather than writing to syslog
+// from sm2 means the logs keep flowing after sm2 has exited.
+func syslogPipe(serviceId string) (*os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	logger := exec.Command("logger", "-t", serviceId)
+	logger.Stdin = reader
+	if err := logger.Start(); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("unable to send %s output to syslog: %s", serviceId, err)
+	}
+	// it exits once the service does, and is reaped so a long running sm2 (e.g. -daemon) doesn't collect zombies
+	go logger.Wait()
+	return writer, nil
+}
+
+func closeOutputs(stdout *os.File, stderr *os.File) {
+	if stdout != nil {
+		stdout.Close()
+	}
+	if stderr != nil && stderr != stdout {
+		stderr.Close()
+	}
+}
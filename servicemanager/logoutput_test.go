@@ -0,0 +1,117 @@
+package servicemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	tests := map[string]string{
+		"":             "/tmp/foo/logs/stdout.log",
+		"discard":      "",
+		"syslog":       "",
+		"stderr.log":   "/tmp/foo/logs/stderr.log",
+		"/var/foo.log": "/var/foo.log",
+	}
+
+	for destination, expected := range tests {
+		if res := outputFile(destination, "/tmp/foo/logs"); res != expected {
+			t.Errorf("destination [%s] expected %s got %s", destination, expected, res)
+		}
+	}
+}
+
+func TestOutputLogDir(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	tests := map[string]string{
+		"":                    "/tmp/foo-1.0.0/logs",
+		"/tmp/sm2-logs/${id}": "/tmp/sm2-logs/FOO",
+		"~/projects/foo/logs": path.Join(home, "projects/foo/logs"),
+		"archive":             "/tmp/foo-1.0.0/logs/archive",
+	}
+
+	for dir, expected := range tests {
+		if res := (Output{Dir: dir}).logDir("FOO", "/tmp/foo-1.0.0"); res != expected {
+			t.Errorf("dir [%s] expected %s got %s", dir, expected, res)
+		}
+	}
+}
+
+func TestOutputOpenSeparateFiles(t *testing.T) {
+	logDir, err := ioutil.TempDir(os.TempDir(), "test-output*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(logDir)
+
+	output := Output{Stderr: "stderr.log"}
+	stdout, stderr, err := output.open("FOO", logDir)
+	AssertNotErr(t, err)
+	defer closeOutputs(stdout, stderr)
+
+	if stdout == stderr {
+		t.Errorf("expected stdout and stderr to be different files")
+	}
+	AssertFileExists(t, path.Join(logDir, "stdout.log"))
+	AssertFileExists(t, path.Join(logDir, "stderr.log"))
+}
+
+func TestOutputOpenSharedFile(t *testing.T) {
+	logDir, err := ioutil.TempDir(os.TempDir(), "test-output*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(logDir)
+
+	stdout, stderr, err := Output{}.open("FOO", logDir)
+	AssertNotErr(t, err)
+	defer closeOutputs(stdout, stderr)
+
+	if stdout != stderr {
+		t.Errorf("expected stdout and stderr to share the same file")
+	}
+
+	// named differently, but the same file
+	for _, output := range []Output{{Stderr: "stdout.log"}, {Stdout: "x.log", Stderr: "./x.log"}} {
+		stdout, stderr, err := output.open("FOO", logDir)
+		AssertNotErr(t, err)
+		if stdout != stderr {
+			t.Errorf("expected %q and %q to share the same file", output.Stdout, output.Stderr)
+		}
+		closeOutputs(stdout, stderr)
+	}
+}
+
+func TestOutputOpenFilesAreAppendedTo(t *testing.T) {
+	logDir := t.TempDir()
+	AssertNotErr(t, os.WriteFile(path.Join(logDir, "stdout.log"), []byte("from the last time it ran\n"), 0644))
+	AssertNotErr(t, os.Symlink("stdout.log", path.Join(logDir, "stderr.log")))
+
+	// stderr.log is stdout.log, but that can't be told from their names
+	stdout, stderr, err := Output{Stderr: "stderr.log"}.open("FOO", logDir)
+	AssertNotErr(t, err)
+	stdout.WriteString("out\n")
+	stderr.WriteString("err\n")
+	closeOutputs(stdout, stderr)
+
+	data, err := os.ReadFile(path.Join(logDir, "stdout.log"))
+	AssertNotErr(t, err)
+	if string(data) != "out\nerr\n" {
+		t.Errorf("expected the log to be emptied, then both streams written to it, got %q", data)
+	}
+}
+
+func TestOutputOpenDiscard(t *testing.T) {
+	logDir, err := ioutil.TempDir(os.TempDir(), "test-output*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(logDir)
+
+	stdout, stderr, err := Output{Stdout: "discard", Stderr: "discard"}.open("FOO", logDir)
+	AssertNotErr(t, err)
+	defer closeOutputs(stdout, stderr)
+
+	if stdout.Name() != os.DevNull {
+		t.Errorf("expected output to be discarded, got %s", stdout.Name())
+	}
+	AssertFileNotExists(t, path.Join(logDir, "stdout.log"))
+}
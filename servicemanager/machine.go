@@ -0,0 +1,123 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// With -machine sm2 is being run by jenkins or a script rather than a person. Nothing prompts or waits on
+// stdin (sudo included), there's no progress animation or colour, and what happens to each service is
+// written to stdout as a line of json, while everything sm2 usually prints goes to stderr, e.g.
+//
+//	{"time":"2024-05-01T12:00:00Z","event":"started","service":"AUTH","version":"1.2.3","port":8500}
+//	{"time":"2024-05-01T12:00:01Z","event":"failed","service":"EMAIL","error":"no version found"}
+//
+// Events are started, already-running, failed, stopped, not-running, restarted and status (one per running
+// service with -s). The exit codes are below.
+
+const (
+	ExitOk             = 0
+	ExitError          = 1   // anything not covered below
+	ExitUsage          = 2   // the options given aren't valid
+	ExitConfig         = 3   // the workspace or service-manager-config couldn't be loaded
+	ExitServicesFailed = 4   // with -machine, one or more services failed to start or restart
+	ExitInterrupted    = 130 // stopped part way through by ctrl-c or a SIGTERM, e.g. the jenkins job was cancelled
+)
+
+// set by -machine, for the places that would prompt that don't have a ServiceManager to hand
+var nonInteractive bool
+
+type machineEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Service string    `json:"service,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Port    int       `json:"port,omitempty"`
+	Pid     int       `json:"pid,omitempty"`
+	Health  string    `json:"health,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// services are started concurrently, so lines are written one at a time
+type eventWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// StartMachineMode turns off anything interactive, and writes events to out
+func (sm *ServiceManager) StartMachineMode(out io.Writer) {
+	nonInteractive = true
+	sm.Commands.NoProgress = true
+	sm.events = &eventWriter{out: out}
+}
+
+func (sm *ServiceManager) emit(event machineEvent) {
+	if sm.events == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	data, _ := json.Marshal(event)
+	sm.events.lock.Lock()
+	defer sm.events.lock.Unlock()
+	sm.events.out.Write(append(data, '\n'))
+}
+
+// the outcome of starting a service, with the version and port it's running on
+func (sm *ServiceManager) emitStarted(sv ServiceAndVersion, err error) {
+	if sm.events == nil {
+		return
+	}
+	switch {
+	case err == ErrAlreadyRunning:
+		sm.emit(machineEvent{Event: "already-running", Service: sv.service})
+	case err != nil:
+		sm.emit(machineEvent{Event: "failed", Service: sv.service, Error: err.Error()})
+	default:
+		event := machineEvent{Event: "started", Service: sv.service, Version: sv.version}
+		if installDir, err := sm.findInstallDirOfService(sv.service); err == nil {
+			if state, err := sm.Ledger.LoadStateFile(installDir); err == nil {
+				event.Version, event.Port, event.Pid = state.Version, state.Port, state.Pid
+			}
+		}
+		sm.emit(event)
+	}
+}
+
+func (sm *ServiceManager) emitStatuses(statuses []serviceStatus) {
+	for _, s := range statuses {
+		sm.emit(machineEvent{Event: "status", Service: s.service, Version: s.version, Port: s.port, Pid: s.pid, Health: string(s.health)})
+	}
+}
+
+// how many services failed to start in the last batch, not counting those that were already running
+func (sm *ServiceManager) failedStarts() int {
+	failed := 0
+	for _, err := range sm.progress.errors {
+		if err != ErrAlreadyRunning {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (sm *ServiceManager) exitIfServicesFailed() {
+	// the services that didn't start because it was cancelled haven't failed, but nor did it work
+	if sm.context().Err() != nil {
+		os.Exit(ExitInterrupted)
+	}
+	if sm.events != nil && sm.failedStarts() > 0 {
+		os.Exit(ExitServicesFailed)
+	}
+}
+
+// sudo, which fails rather than asking for a password with -machine
+func sudo(args ...string) *exec.Cmd {
+	if nonInteractive {
+		args = append([]string{"-n"}, args...)
+	}
+	return exec.Command("sudo", args...)
+}
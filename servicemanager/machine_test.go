@@ -0,0 +1,79 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestMachineEvents(t *testing.T) {
+	out := bytes.Buffer{}
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: t.TempDir()},
+		Services: Services{"AUTH": {Id: "AUTH", Binary: ServiceBinary{DestinationSubdir: "auth"}}},
+		Ledger: ledger.Ledger{LoadStateFile: func(string) (ledger.StateFile, error) {
+			return ledger.StateFile{Service: "AUTH", Version: "1.2.3", Port: 8500, Pid: 42}, nil
+		}},
+	}
+	sm.StartMachineMode(&out)
+	defer func() { nonInteractive = false }()
+
+	sm.emitStarted(ServiceAndVersion{service: "AUTH"}, nil)
+	sm.emitStarted(ServiceAndVersion{service: "EMAIL"}, ErrAlreadyRunning)
+	sm.emitStarted(ServiceAndVersion{service: "PAYMENTS"}, errors.New("no version found"))
+	sm.emitStatuses([]serviceStatus{{service: "AUTH", version: "1.2.3", port: 8500, pid: 42, health: PASS}})
+
+	events := []machineEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		event := machineEvent{}
+		AssertNotErr(t, json.Unmarshal([]byte(line), &event))
+		if event.Time.IsZero() {
+			t.Errorf("expected each event to have a time, got %s", line)
+		}
+		event.Time = event.Time.AddDate(-event.Time.Year()+1, 0, 0)
+		events = append(events, machineEvent{Event: event.Event, Service: event.Service, Version: event.Version, Port: event.Port, Pid: event.Pid, Health: event.Health, Error: event.Error})
+	}
+	expected := []machineEvent{
+		{Event: "started", Service: "AUTH", Version: "1.2.3", Port: 8500, Pid: 42},
+		{Event: "already-running", Service: "EMAIL"},
+		{Event: "failed", Service: "PAYMENTS", Error: "no version found"},
+		{Event: "status", Service: "AUTH", Version: "1.2.3", Port: 8500, Pid: 42, Health: string(PASS)},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %+v\ngot %+v", expected, events)
+	}
+	if !sm.Commands.NoProgress {
+		t.Errorf("expected the progress to be turned off")
+	}
+}
+
+func TestNoEventsWithoutMachineMode(t *testing.T) {
+	sm := ServiceManager{}
+	sm.emit(machineEvent{Event: "started", Service: "AUTH"})
+	sm.emitStarted(ServiceAndVersion{service: "AUTH"}, nil)
+}
+
+func TestFailedStarts(t *testing.T) {
+	sm := ServiceManager{}
+	sm.progress.errors = map[string]error{"AUTH": ErrAlreadyRunning, "EMAIL": errors.New("no version found")}
+	if failed := sm.failedStarts(); failed != 1 {
+		t.Errorf("expected services that were already running not to count as failures, got %d", failed)
+	}
+}
+
+func TestNothingPromptsInMachineMode(t *testing.T) {
+	nonInteractive = true
+	defer func() { nonInteractive = false }()
+	if isInteractive() {
+		t.Errorf("expected nothing to prompt")
+	}
+	if cmd := sudo("cp", "a", "b"); !reflect.DeepEqual(cmd.Args, []string{"sudo", "-n", "cp", "a", "b"}) {
+		t.Errorf("expected sudo not to ask for a password, got %v", cmd.Args)
+	}
+}
@@ -0,0 +1,119 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The latest versions found in artifactory are kept in the workspace for a few minutes (metadataCacheTtl
+// secs, 300 by default), so running sm2 a few times in a row, e.g. -start then -restart, doesn't look them
+// up over and over. -refresh (and -restart -latest) looks them up again, and updates the cache with what it finds.
+// Versions the daemon keeps up to date (see metadatarefresh.go) are recent enough for -latest too.
+
+const (
+	metadataCacheFile       = "metadata-cache.json"
+	defaultMetadataCacheTtl = 5 * time.Minute
+)
+
+type cachedMetadata struct {
+	Fetched  time.Time     `json:"fetched"`
+	Metadata MavenMetadata `json:"metadata"`
+	Daemon   bool          `json:"daemon,omitempty"` // looked up by the daemon's background refresh
+}
+
+// maven metadata by url, loaded from the workspace the first time it's needed
+type metadataCache struct {
+	file    string
+	ttl     time.Duration
+	refresh bool // always looks them up again
+	latest  bool // only uses the versions the daemon keeps up to date
+	daemon  bool // the daemon's own cache, which marks what it saves as kept up to date
+	now     func() time.Time
+
+	lock    sync.Mutex
+	loaded  bool
+	entries map[string]cachedMetadata
+}
+
+func (sm *ServiceManager) newMetadataCache() *metadataCache {
+	ttl := defaultMetadataCacheTtl
+	if secs, err := strconv.Atoi(sm.Commands.Settings["metadataCacheTtl"]); err == nil {
+		ttl = time.Duration(secs) * time.Second
+	}
+	return &metadataCache{
+		file:    path.Join(sm.Config.WorkspaceDir, metadataCacheFile),
+		ttl:     ttl,
+		refresh: sm.Commands.Refresh,
+		latest:  sm.Commands.Latest,
+		now:     time.Now,
+	}
+}
+
+func (c *metadataCache) get(url string) (MavenMetadata, bool) {
+	if c == nil || c.refresh {
+		return MavenMetadata{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	entry, ok := c.entries[url]
+	if !ok || c.now().Sub(entry.Fetched) > c.ttl || (c.latest && !entry.Daemon) {
+		return MavenMetadata{}, false
+	}
+	return entry.Metadata, true
+}
+
+// saves the metadata, along with anything other invocations have cached since it was loaded
+func (c *metadataCache) put(url string, metadata MavenMetadata) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.loaded = false
+	c.load()
+	c.entries[url] = cachedMetadata{Fetched: c.now(), Metadata: metadata, Daemon: c.daemon}
+
+	// expired entries are dropped, so the file doesn't grow with every service ever started
+	for u, entry := range c.entries {
+		if c.now().Sub(entry.Fetched) > c.ttl {
+			delete(c.entries, u)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	// written to a temp file first, so another sm2 never reads half a file
+	tmp, err := os.CreateTemp(path.Dir(c.file), metadataCacheFile+".*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// a missing or unreadable file is an empty cache
+func (c *metadataCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.entries = map[string]cachedMetadata{}
+	if data, err := os.ReadFile(c.file); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	if c.entries == nil {
+		c.entries = map[string]cachedMetadata{}
+	}
+}
@@ -0,0 +1,93 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestMetadataCacheIsSharedBetweenInvocations(t *testing.T) {
+	requests := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, mavenMetadata)
+	}))
+	defer svr.Close()
+
+	workspace := t.TempDir()
+	invoke := func(opts cli.UserOption) (MavenMetadata, error) {
+		sm := ServiceManager{Client: &http.Client{}, Config: ServiceManagerConfig{WorkspaceDir: workspace, ArtifactoryRepoUrl: svr.URL}, Commands: opts}
+		sm.metadata = sm.newMetadataCache()
+		return sm.GetLatestVersions(ServiceBinary{GroupId: "foo.bar", Artifact: "foo_2.12"}, "", "")
+	}
+
+	metadata, err := invoke(cli.UserOption{})
+	AssertNotErr(t, err)
+	if _, err := invoke(cli.UserOption{}); err != nil || requests != 1 {
+		t.Errorf("expected the second invocation to use the cache, got %d requests, %v", requests, err)
+	}
+	if cached, _ := invoke(cli.UserOption{}); cached.Latest != metadata.Latest || len(cached.Versions) != len(metadata.Versions) {
+		t.Errorf("expected %+v from the cache, got %+v", metadata, cached)
+	}
+
+	invoke(cli.UserOption{Refresh: true})
+	if requests != 2 {
+		t.Errorf("expected -refresh to look the version up again, got %d requests", requests)
+	}
+	invoke(cli.UserOption{Restart: true, Latest: true})
+	if requests != 3 {
+		t.Errorf("expected -restart -latest to look the version up again, got %d requests", requests)
+	}
+}
+
+func TestMetadataCacheExpires(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := &metadataCache{file: path.Join(t.TempDir(), metadataCacheFile), ttl: 5 * time.Minute, now: func() time.Time { return now }}
+
+	cache.put("http://artifactory/foo/maven-metadata.xml", MavenMetadata{Latest: "1.0.0"})
+	now = now.Add(4 * time.Minute)
+	if metadata, ok := cache.get("http://artifactory/foo/maven-metadata.xml"); !ok || metadata.Latest != "1.0.0" {
+		t.Errorf("expected 1.0.0 to still be cached, got %+v", metadata)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.get("http://artifactory/foo/maven-metadata.xml"); ok {
+		t.Errorf("expected the metadata to have expired")
+	}
+
+	// and is dropped from the file when something else is cached
+	cache.put("http://artifactory/bar/maven-metadata.xml", MavenMetadata{Latest: "2.0.0"})
+	cache.loaded = false
+	cache.load()
+	if len(cache.entries) != 1 {
+		t.Errorf("expected only bar to be left in the file, got %+v", cache.entries)
+	}
+}
+
+func TestMetadataCacheIgnoresABadFile(t *testing.T) {
+	file := path.Join(t.TempDir(), metadataCacheFile)
+	AssertNotErr(t, os.WriteFile(file, []byte("{not json"), 0644))
+	cache := &metadataCache{file: file, ttl: time.Minute, now: time.Now}
+
+	if _, ok := cache.get("http://artifactory/foo/maven-metadata.xml"); ok {
+		t.Errorf("expected nothing to be cached")
+	}
+	cache.put("http://artifactory/foo/maven-metadata.xml", MavenMetadata{Latest: "1.0.0"})
+	if _, ok := cache.get("http://artifactory/foo/maven-metadata.xml"); !ok {
+		t.Errorf("expected the bad file to be replaced")
+	}
+}
+
+func TestMetadataCacheTtlSetting(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{Settings: map[string]string{"metadataCacheTtl": "30"}}}
+	if ttl := sm.newMetadataCache().ttl; ttl != 30*time.Second {
+		t.Errorf("expected a ttl of 30s, got %s", ttl)
+	}
+}
@@ -0,0 +1,116 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"sm2/cli"
+)
+
+// While the daemon's running it looks up the latest versions of the installed services (and the ones it's
+// keeping running) every few minutes in the background, saving them to the workspace's metadata cache, so
+// starting them doesn't wait on artifactory, even with -latest. The metadataRefresh setting changes how
+// often (or turns it off), and metadataQuiet sets hours it's left alone, e.g. 22-7 while the vpn's down.
+
+// a little under the metadata cache's ttl, so what the daemon finds hasn't expired before it's looked up again
+const defaultMetadataRefresh = 4 * time.Minute
+
+type metadataRefresher struct {
+	interval time.Duration
+	quiet    *cli.QuietHours
+	now      func() time.Time
+	last     time.Time
+	running  int32 // 1 while a refresh is in progress
+}
+
+// nil when it's been turned off
+func (sm *ServiceManager) newMetadataRefresher() *metadataRefresher {
+	r := &metadataRefresher{interval: defaultMetadataRefresh, now: time.Now}
+	if value, ok := sm.Commands.Settings["metadataRefresh"]; ok {
+		if value == "off" {
+			return nil
+		}
+		if secs, err := strconv.Atoi(value); err == nil {
+			r.interval = time.Duration(secs) * time.Second
+		}
+	}
+	if value, ok := sm.Commands.Settings["metadataQuiet"]; ok {
+		if quiet, err := cli.ParseQuietHours(value); err == nil {
+			r.quiet = &quiet
+		}
+	}
+	return r
+}
+
+// true when it's time to look them up again, and it isn't quiet hours
+func (r *metadataRefresher) due() bool {
+	if r == nil || atomic.LoadInt32(&r.running) == 1 {
+		return false
+	}
+	now := r.now()
+	if r.quiet != nil && r.quiet.Contains(now.Hour()) {
+		return false
+	}
+	return now.Sub(r.last) >= r.interval
+}
+
+// starts looking up the latest versions in the background, if they're due
+func (sm *ServiceManager) refreshMetadata(out io.Writer) {
+	r := sm.refresher
+	if sm.Commands.Offline || !r.due() {
+		return
+	}
+	r.last = r.now()
+	services := sm.servicesToRefresh()
+	if len(services) == 0 {
+		return
+	}
+	atomic.StoreInt32(&r.running, 1)
+
+	// a copy of sm, so the lookups don't see the config being reloaded, that always goes to artifactory and
+	// marks what it finds as kept up to date
+	refresh := *sm
+	refresh.latest = nil
+	refresh.metadata = sm.newMetadataCache()
+	refresh.metadata.refresh = true
+	refresh.metadata.latest = false
+	refresh.metadata.daemon = true
+
+	go func() {
+		defer atomic.StoreInt32(&r.running, 0)
+		if failed := refresh.lookUpLatestVersions(services); failed > 0 {
+			fmt.Fprintf(out, "Unable to look up the latest versions of %d of %d services\n", failed, len(services))
+		}
+	}()
+}
+
+// the installed services, along with the ones the daemon was asked to keep running
+func (sm *ServiceManager) servicesToRefresh() []ServiceAndVersion {
+	services := []ServiceAndVersion{}
+	seen := map[string]bool{}
+	add := func(sv ServiceAndVersion) {
+		if !seen[sv.service] && sm.needsLatestVersion(sv) {
+			seen[sv.service] = true
+			services = append(services, sv)
+		}
+	}
+
+	for _, sv := range sm.requestedServicesAndProfiles() {
+		if sv.version == "" {
+			add(sv)
+		}
+	}
+	for id := range sm.Services {
+		installDir, err := sm.findInstallDirOfService(id)
+		if err != nil {
+			continue
+		}
+		if _, err := sm.Ledger.LoadInstallFile(installDir); err == nil {
+			add(ServiceAndVersion{service: id})
+		}
+	}
+	return services
+}
@@ -0,0 +1,121 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestMetadataRefresherIsDueEveryIntervalOutsideQuietHours(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	quiet, err := cli.ParseQuietHours("22-7")
+	AssertNotErr(t, err)
+	r := &metadataRefresher{interval: 4 * time.Minute, quiet: &quiet, now: func() time.Time { return now }}
+
+	if !r.due() {
+		t.Error("expected the first refresh to be due")
+	}
+	r.last = now
+	now = now.Add(time.Minute)
+	if r.due() {
+		t.Error("expected the next refresh not to be due for another 3 minutes")
+	}
+	now = now.Add(3 * time.Minute)
+	if !r.due() {
+		t.Error("expected the next refresh to be due")
+	}
+	r.running = 1
+	if r.due() {
+		t.Error("expected nothing to be due while a refresh is running")
+	}
+	r.running = 0
+
+	for _, hour := range []int{22, 23, 0, 6} {
+		now = time.Date(2024, 5, 2, hour, 30, 0, 0, time.UTC)
+		if r.due() {
+			t.Errorf("expected %d:30 to be quiet", hour)
+		}
+	}
+	now = time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC)
+	if !r.due() {
+		t.Error("expected quiet hours to end at 7")
+	}
+
+	var off *metadataRefresher
+	if off.due() {
+		t.Error("expected nothing to be due when it's turned off")
+	}
+	sm := ServiceManager{Commands: cli.UserOption{Settings: map[string]string{"metadataRefresh": "off"}}}
+	if sm.newMetadataRefresher() != nil {
+		t.Error("expected metadataRefresh=off to turn it off")
+	}
+}
+
+func TestRefreshMetadataKeepsInstalledServicesUpToDate(t *testing.T) {
+	requests := int32(0)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, mavenMetadata)
+	}))
+	defer svr.Close()
+
+	workspace := t.TempDir()
+	tmpDir := path.Join(workspace, "install")
+	os.MkdirAll(path.Join(tmpDir, "auth"), 0755)
+	l := ledger.NewLedger()
+	AssertNotErr(t, l.SaveInstallFile(path.Join(tmpDir, "auth"), ledger.InstallFile{Service: "AUTH", Version: "1.0.0"}))
+
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{WorkspaceDir: workspace, TmpDir: tmpDir, ArtifactoryRepoUrl: svr.URL},
+		Ledger: l,
+		Services: map[string]Service{
+			"AUTH":  {Id: "AUTH", Binary: ServiceBinary{GroupId: "foo.bar", Artifact: "auth_2.12", DestinationSubdir: "auth"}},
+			"EMAIL": {Id: "EMAIL", Binary: ServiceBinary{GroupId: "foo.bar", Artifact: "email_2.12", DestinationSubdir: "email"}},
+		},
+		refresher: &metadataRefresher{interval: time.Minute, now: time.Now},
+	}
+
+	sm.refreshMetadata(io.Discard)
+	for i := 0; i < 100 && atomic.LoadInt32(&sm.refresher.running) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected only the installed service to be looked up, got %d requests", n)
+	}
+
+	// starting it with -latest uses what the daemon found
+	latest := ServiceManager{Client: &http.Client{}, Config: sm.Config, Commands: cli.UserOption{Restart: true, Latest: true}}
+	latest.metadata = latest.newMetadataCache()
+	metadata, err := latest.GetLatestVersions(sm.Services["AUTH"].Binary, "", "")
+	AssertNotErr(t, err)
+	if n := atomic.LoadInt32(&requests); n != 1 || metadata.Latest != "2.33.0" {
+		t.Errorf("expected -latest to use the daemon's lookup, got %s and %d requests", metadata.Latest, n)
+	}
+
+	// but not if it's been looked up since by something else
+	sm.Commands.Refresh = true
+	sm.metadata = sm.newMetadataCache()
+	sm.GetLatestVersions(sm.Services["AUTH"].Binary, "", "")
+	latest.metadata = latest.newMetadataCache()
+	latest.GetLatestVersions(sm.Services["AUTH"].Binary, "", "")
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected -latest to look it up again, got %d requests", n)
+	}
+
+	// and it isn't looked up again until the interval's passed
+	sm.refreshMetadata(io.Discard)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected the refresh not to be due, got %d requests", n)
+	}
+}
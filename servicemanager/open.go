@@ -0,0 +1,71 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"sm2/ledger"
+)
+
+// -open SERVICE opens a running service in the browser, at its landingPath (e.g. its swagger ui), or
+// else the first of its proxyPaths, or /. The port comes from its state file, so it's the port the
+// service is actually running on, even with -dynamic-ports.
+
+func (sm *ServiceManager) OpenService(name string) error {
+	id := sm.renamedService(name)
+	service, ok := sm.Services[id]
+	if !ok {
+		return fmt.Errorf("%s is not a service", id)
+	}
+	if isInfra(service) {
+		return fmt.Errorf("%s is a %s, it doesn't have a page to open", id, service.Type)
+	}
+
+	url, err := sm.serviceUrl(service)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Opening %s\n", url)
+	return sm.Platform.OpenBrowser(url)
+}
+
+// the url of the landing page of a running service
+func (sm *ServiceManager) serviceUrl(service Service) (string, error) {
+	notRunning := fmt.Errorf("%s isn't running, start it with sm2 -start %s", service.Id, service.Id)
+	installDir, err := sm.findInstallDirOfService(service.Id)
+	if err != nil {
+		return "", err
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil {
+		return "", notRunning
+	}
+	if _, running := sm.Platform.PidLookup()[state.Pid]; !running {
+		return "", notRunning
+	}
+
+	return "http://" + stateAddress(state) + landingPath(service), nil
+}
+
+// the host:port to reach a service on, which is localhost unless it's bound to a particular address
+func stateAddress(state ledger.StateFile) string {
+	host := "localhost"
+	if !isLocalAddress(state.BindAddress) {
+		host = state.BindAddress
+	}
+	return net.JoinHostPort(host, fmt.Sprint(state.Port))
+}
+
+func landingPath(service Service) string {
+	p := "/"
+	if service.LandingPath != "" {
+		p = service.LandingPath
+	} else if len(service.ProxyPaths) > 0 {
+		p = service.ProxyPaths[0]
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
@@ -0,0 +1,51 @@
+package servicemanager
+
+import (
+	"testing"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestOpenService(t *testing.T) {
+	states := map[string]ledger.StateFile{
+		"/tmp/auth":     {Service: "AUTH", Port: 8500, Pid: 1},
+		"/tmp/frontend": {Service: "FRONTEND", Port: 9001, Pid: 2, BindAddress: "192.168.1.20"},
+		"/tmp/payments": {Service: "PAYMENTS", Port: 9050, Pid: 3},
+	}
+	opened := ""
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{TmpDir: "/tmp"},
+		Services: Services{
+			"AUTH":     {Id: "AUTH", Binary: ServiceBinary{DestinationSubdir: "auth"}, LandingPath: "docs/swagger-ui"},
+			"FRONTEND": {Id: "FRONTEND", Binary: ServiceBinary{DestinationSubdir: "frontend"}, ProxyPaths: []string{"/frontend", "/assets"}},
+			"PAYMENTS": {Id: "PAYMENTS", Binary: ServiceBinary{DestinationSubdir: "payments"}},
+			"EMAIL":    {Id: "EMAIL", Binary: ServiceBinary{DestinationSubdir: "email"}},
+		},
+		Ledger: ledger.Ledger{LoadStateFile: func(dir string) (ledger.StateFile, error) {
+			return states[dir], nil
+		}},
+		Platform: platform.Platform{
+			PidLookup:   func() map[int]int { return map[int]int{1: 1, 2: 2} },
+			OpenBrowser: func(url string) error { opened = url; return nil },
+		},
+	}
+
+	AssertNotErr(t, sm.OpenService("AUTH"))
+	if opened != "http://localhost:8500/docs/swagger-ui" {
+		t.Errorf("expected the landing path to be opened, got %s", opened)
+	}
+
+	AssertNotErr(t, sm.OpenService("FRONTEND"))
+	if opened != "http://192.168.1.20:9001/frontend" {
+		t.Errorf("expected the first proxy path on the bind address, got %s", opened)
+	}
+
+	if err := sm.OpenService("PAYMENTS"); err == nil || err.Error() != "PAYMENTS isn't running, start it with sm2 -start PAYMENTS" {
+		t.Errorf("expected a stopped service not to be opened, got %v", err)
+	}
+	if err := sm.OpenService("NOPE"); err == nil {
+		t.Errorf("expected an unknown service not to be opened")
+	}
+}
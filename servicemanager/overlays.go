@@ -0,0 +1,108 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A named set of changes to many services at once, e.g. pointing them all at stubs rather than
+// the real downstream services. Overlays are defined in overlays.json in the config dir:
+//
+//	"stub-mode": {
+//	  "services":   { "AUTH": { "defaultPort": 8585 } },
+//	  "appendArgs": { "PAYMENTS_API": ["-Dmicroservice.services.bank.port=9999"] }
+//	}
+//
+// and selected with --overlay stub-mode. Services are changed the same way as services-overrides.json,
+// appendArgs are added before any args passed with --appendArgs.
+type Overlay struct {
+	Services   Services            `json:"services"`
+	AppendArgs map[string][]string `json:"appendArgs"`
+}
+
+// as above, but with the service changes left as json so only the fields set in the overlay are changed
+type overlayDefinition struct {
+	Services   map[string]json.RawMessage `json:"services"`
+	AppendArgs map[string][]string        `json:"appendArgs"`
+}
+
+// applies the overlays named in --overlay (comma separated) in order, so later overlays win
+func (sm *ServiceManager) applyOverlays(names string) error {
+	data, err := sm.readOptionalConfig("overlays")
+	if err != nil {
+		return err
+	}
+
+	overlays := map[string]overlayDefinition{}
+	if err := json.Unmarshal(data, &overlays); err != nil {
+		return fmt.Errorf("failed to load overlays.json: %s", err)
+	}
+	if len(overlays) == 0 {
+		return fmt.Errorf("no overlays are defined, add them to overlays.json in %s", sm.Config.ConfigDir)
+	}
+
+	overlayArgs := map[string][]string{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		overlay, ok := overlays[name]
+		if !ok {
+			return fmt.Errorf("overlay %s is not defined in overlays.json", name)
+		}
+		if err := sm.applyOverlay(name, overlay); err != nil {
+			return err
+		}
+		for id, args := range overlay.AppendArgs {
+			overlayArgs[id] = append(overlayArgs[id], args...)
+		}
+	}
+
+	// args from the command line go last so they win
+	if len(overlayArgs) > 0 && sm.Commands.ExtraArgs == nil {
+		sm.Commands.ExtraArgs = map[string][]string{}
+	}
+	for id, args := range overlayArgs {
+		sm.Commands.ExtraArgs[id] = append(args, sm.Commands.ExtraArgs[id]...)
+	}
+	return nil
+}
+
+func (sm *ServiceManager) applyOverlay(name string, overlay overlayDefinition) error {
+	for id, changes := range overlay.Services {
+		service, ok := sm.Services[id]
+		if !ok {
+			return fmt.Errorf("overlay %s changes %s, which is not a service", name, id)
+		}
+
+		// round trip the service through json so the changes can be deep merged like an override
+		current, err := json.Marshal(service)
+		if err != nil {
+			return err
+		}
+		merged, err := mergeJson(current, changes)
+		if err != nil {
+			return fmt.Errorf("overlay %s has invalid changes for %s: %s", name, id, err)
+		}
+
+		updated := Service{}
+		if err := json.Unmarshal(merged, &updated); err != nil {
+			return fmt.Errorf("overlay %s has invalid changes for %s: %s", name, id, err)
+		}
+		updated.Id = id
+		sm.Services[id] = updated
+	}
+	return nil
+}
+
+// deep merges one json object over another
+func mergeJson(base []byte, override []byte) ([]byte, error) {
+	baseObj, err := decodeJsonObject(base)
+	if err != nil {
+		return nil, err
+	}
+	overrideObj, err := decodeJsonObject(override)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(deepMerge(baseObj, overrideObj))
+}
@@ -0,0 +1,55 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestApplyOverlays(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-overlays*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	os.WriteFile(path.Join(configDir, "overlays.json"), []byte(`{
+		"stub-mode": {
+			"services": {"AUTH": {"defaultPort": 8585, "binary": {"cmd": ["./auth/bin/auth", "-Dstub=true"]}}},
+			"appendArgs": {"PAY": ["-Dbank.port=9999"]}
+		},
+		"debug": {
+			"appendArgs": {"PAY": ["-Dlogger.root=DEBUG"]}
+		}
+	}`), 0644)
+
+	sm := ServiceManager{
+		Config: ServiceManagerConfig{ConfigDir: configDir},
+		Services: Services{
+			"AUTH": {Id: "AUTH", Name: "auth", DefaultPort: 8500, Binary: ServiceBinary{Artifact: "auth", GroupId: "uk.gov.hmrc", Cmd: []string{"./auth/bin/auth"}}},
+			"PAY":  {Id: "PAY", Name: "pay", DefaultPort: 8600},
+		},
+		Commands: cli.UserOption{ExtraArgs: map[string][]string{"PAY": {"-Dfrom.cli=true"}}},
+	}
+
+	AssertNotErr(t, sm.applyOverlays("stub-mode, debug"))
+
+	auth := sm.Services["AUTH"]
+	if auth.DefaultPort != 8585 || auth.Name != "auth" || auth.Id != "AUTH" || auth.Binary.Artifact != "auth" {
+		t.Errorf("expected only the overlay's fields to change, got %+v", auth)
+	}
+	if !reflect.DeepEqual(auth.Binary.Cmd, []string{"./auth/bin/auth", "-Dstub=true"}) {
+		t.Errorf("expected cmd to be replaced, got %v", auth.Binary.Cmd)
+	}
+
+	expectedArgs := []string{"-Dbank.port=9999", "-Dlogger.root=DEBUG", "-Dfrom.cli=true"}
+	if !reflect.DeepEqual(sm.Commands.ExtraArgs["PAY"], expectedArgs) {
+		t.Errorf("expected overlay args before the command line args, got %v", sm.Commands.ExtraArgs["PAY"])
+	}
+
+	if err := sm.applyOverlays("missing"); err == nil {
+		t.Errorf("expected an error for an unknown overlay")
+	}
+}
@@ -0,0 +1,108 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+const (
+	workspaceOverridesFile = "services-overrides" // in the workspace, i.e. ~/.sm2/services-overrides.json
+	projectOverridesFile   = "overrides"          // in .sm2 in the current dir, for per-project tweaks
+)
+
+// Returns the override files that exist, in the order they should be applied.
+// Overrides let people change ports, args etc for themselves without editing the shared services.json.
+func findOverrideFiles(workspacePath string) ([]string, error) {
+	candidates := []string{}
+
+	workspaceOverrides, err := findConfigFile(workspacePath, workspaceOverridesFile)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, workspaceOverrides)
+
+	if cwd, err := os.Getwd(); err == nil {
+		projectOverrides, err := findConfigFile(path.Join(cwd, DEFAULT_WORKSPACE), projectOverridesFile)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, projectOverrides)
+	}
+
+	found := []string{}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if !seen[c] && Exists(c) {
+			found = append(found, c)
+		}
+		seen[c] = true
+	}
+	return found, nil
+}
+
+// reads a json (or yaml) object without losing the precision of numbers
+func readJsonObject(file string) (map[string]interface{}, error) {
+	data, err := readConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJsonObject(data)
+}
+
+func decodeJsonObject(data []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		// the file was just null, or an empty yaml file
+		obj = map[string]interface{}{}
+	}
+	return obj, nil
+}
+
+// Merges override into base. Objects are merged key by key, anything else (including lists)
+// in the override replaces the value in base. A null in the override removes the key.
+func deepMerge(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		baseObj, baseIsObj := base[k].(map[string]interface{})
+		overrideObj, overrideIsObj := v.(map[string]interface{})
+		if baseIsObj && overrideIsObj {
+			base[k] = deepMerge(baseObj, overrideObj)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+// applies each of the override files over the contents of a json config file
+func mergeOverrides(data []byte, overrideFiles []string) ([]byte, error) {
+	if len(overrideFiles) == 0 {
+		return data, nil
+	}
+
+	merged, err := decodeJsonObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range overrideFiles {
+		override, err := readJsonObject(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		merged = deepMerge(merged, override)
+	}
+
+	return json.Marshal(merged)
+}
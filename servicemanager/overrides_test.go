@@ -0,0 +1,88 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestDeepMergeOverridesNestedFields(t *testing.T) {
+	base, _ := decodeJsonObject([]byte(`{
+		"FOO": {"name": "foo", "defaultPort": 8000, "binary": {"artifact": "foo", "groupId": "uk.gov.hmrc", "cmd": ["./foo/bin/foo"]}},
+		"BAR": {"name": "bar", "defaultPort": 9000}
+	}`))
+	override, _ := decodeJsonObject([]byte(`{
+		"FOO": {"defaultPort": 8001, "binary": {"cmd": ["./foo/bin/foo", "-Dfoo=bar"]}},
+		"BAR": null,
+		"BAZ": {"name": "baz"}
+	}`))
+
+	merged := deepMerge(base, override)
+
+	foo := merged["FOO"].(map[string]interface{})
+	if foo["defaultPort"].(interface{ String() string }).String() != "8001" {
+		t.Errorf("expected port to be overridden, got %v", foo["defaultPort"])
+	}
+	if foo["name"] != "foo" {
+		t.Errorf("expected name to be kept, got %v", foo["name"])
+	}
+	binary := foo["binary"].(map[string]interface{})
+	if binary["artifact"] != "foo" || binary["groupId"] != "uk.gov.hmrc" {
+		t.Errorf("expected artifact coordinates to be kept, got %v", binary)
+	}
+	if !reflect.DeepEqual(binary["cmd"], []interface{}{"./foo/bin/foo", "-Dfoo=bar"}) {
+		t.Errorf("expected cmd to be replaced, got %v", binary["cmd"])
+	}
+	if _, ok := merged["BAR"]; ok {
+		t.Errorf("expected BAR to be removed by null")
+	}
+	if _, ok := merged["BAZ"]; !ok {
+		t.Errorf("expected BAZ to be added")
+	}
+}
+
+func TestLoadServicesAppliesOverridesInOrder(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-overrides*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	servicesFile := path.Join(dir, "services.json")
+	first := path.Join(dir, "first.json")
+	second := path.Join(dir, "second.json")
+	os.WriteFile(servicesFile, []byte(`{"FOO": {"name": "foo", "defaultPort": 8000, "binary": {"artifact": "foo", "groupId": "uk.gov.hmrc", "cmd": ["./foo/bin/foo"]}}}`), 0644)
+	os.WriteFile(first, []byte(`{"FOO": {"defaultPort": 8001, "binary": {"groupId": "com.example"}}}`), 0644)
+	os.WriteFile(second, []byte(`{"FOO": {"defaultPort": 8002}}`), 0644)
+
+	services, err := loadServicesFromFile(servicesFile, nil, first, second)
+	AssertNotErr(t, err)
+
+	foo := (*services)["FOO"]
+	if foo.Id != "FOO" {
+		t.Errorf("expected id to be set, got %s", foo.Id)
+	}
+	if foo.DefaultPort != 8002 {
+		t.Errorf("expected the last override to win, got port %d", foo.DefaultPort)
+	}
+	if foo.Binary.GroupId != "com.example" || foo.Binary.Artifact != "foo" {
+		t.Errorf("expected group id to be overridden and artifact kept, got %s:%s", foo.Binary.GroupId, foo.Binary.Artifact)
+	}
+}
+
+func TestOverrideFilesDontNeedRequiredFields(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-overrides*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, workspaceOverridesFile+".json")
+	os.WriteFile(file, []byte(`{"FOO": {"defaultport": 8001, "binary": {"cmd": ["./foo"]}}}`), 0644)
+
+	problems, err := validateOverrideFile(file, reflect.TypeOf(Services{}))
+	AssertNotErr(t, err)
+
+	if len(problems) != 1 || problems[0].message != "unknown field, did you mean defaultPort?" {
+		t.Errorf("expected only the typo to be reported, got %v", problems)
+	}
+}
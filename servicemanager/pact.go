@@ -0,0 +1,272 @@
+package servicemanager
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services with "type": "pact" are stubs of a provider, generated from the pacts its consumers have
+// published, so a consumer can run against something that behaves as its contract says, e.g.
+//
+//	"PAYMENTS_PACT": {
+//	  "type": "pact",
+//	  "defaultPort": 9050,
+//	  "pact": {"broker": "https://pact-broker.example.com", "provider": "payments", "consumer": "checkout-frontend", "token": "${secret:PACT_BROKER_TOKEN}"}
+//	}
+//
+// The latest pacts for the provider (from one consumer, or all of them without "consumer", and optionally
+// only those with a tag) are fetched from the broker each time the stub starts, and served by
+// pact-stub-server. If the broker can't be reached the pacts fetched last time are used.
+//
+// pact-stub-server is used from the path, or downloaded from its github releases the first time it's needed.
+
+const (
+	PactType              = "pact"
+	PACT                  = "pact" // the version shown in status
+	pactStubServerVersion = "0.6.0"
+	pactStubServerUrl     = "https://github.com/pact-foundation/pact-stub-server/releases/download/v%s/pact-stub-server-%s-%s.gz"
+	// shared by all the pact stubs, in the workspace's install dir
+	pactCacheDir = ".pact"
+)
+
+type Pact struct {
+	Broker   string `json:"broker"`   // the pact broker's url
+	Provider string `json:"provider"` // the provider being stubbed
+	Consumer string `json:"consumer"` // only serve the pacts of this consumer, rather than all of them
+	Tag      string `json:"tag"`      // the latest pacts with this tag, e.g. main, rather than the latest of all
+	Token    string `json:"token"`    // a bearer token for the broker, usually a ${secret:...}
+}
+
+// the broker's links to the latest pacts of a provider
+type pactLinks struct {
+	Links struct {
+		Pacts    []pactLink `json:"pb:pacts"`
+		OldPacts []pactLink `json:"pacts"` // brokers before pb: links
+	} `json:"_links"`
+}
+
+type pactLink struct {
+	Href string `json:"href"`
+}
+
+func (p Pact) latestUrl() string {
+	u := strings.TrimSuffix(p.Broker, "/") + "/pacts/provider/" + url.PathEscape(p.Provider)
+	if p.Consumer != "" {
+		u += "/consumer/" + url.PathEscape(p.Consumer)
+	}
+	u += "/latest"
+	if p.Tag != "" {
+		u += "/" + url.PathEscape(p.Tag)
+	}
+	return u
+}
+
+// fetches the latest pacts into pactDir, replacing the ones that were there
+func (sm *ServiceManager) fetchPacts(service Service, pactDir string) error {
+	token := ""
+	if service.Pact.Token != "" {
+		resolved, err := resolveSecrets([]string{service.Pact.Token}, sm.lookupSecret)
+		if err != nil {
+			return err
+		}
+		token = resolved[0]
+	}
+
+	get := func(u string) ([]byte, error) {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/hal+json, application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := sm.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("http GET %s failed with status %s, expected 200", u, resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+
+	pacts := [][]byte{}
+	body, err := get(service.Pact.latestUrl())
+	if err != nil {
+		return err
+	}
+	if service.Pact.Consumer != "" {
+		pacts = append(pacts, body)
+	} else {
+		links := pactLinks{}
+		if err := json.Unmarshal(body, &links); err != nil {
+			return fmt.Errorf("unable to read the pacts of %s: %s", service.Pact.Provider, err)
+		}
+		for _, link := range append(links.Links.Pacts, links.Links.OldPacts...) {
+			pact, err := get(link.Href)
+			if err != nil {
+				return err
+			}
+			pacts = append(pacts, pact)
+		}
+	}
+	if len(pacts) == 0 {
+		return fmt.Errorf("the broker has no pacts for %s", service.Pact.Provider)
+	}
+
+	if err := os.RemoveAll(pactDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(pactDir, 0755); err != nil {
+		return err
+	}
+	for i, pact := range pacts {
+		if err := os.WriteFile(path.Join(pactDir, fmt.Sprintf("pact-%d.json", i+1)), pact, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pact-stub-server from the path, or downloaded if it isn't there
+func (sm *ServiceManager) pactStubServer() (string, error) {
+	if binary, err := exec.LookPath("pact-stub-server"); err == nil {
+		return binary, nil
+	}
+	binary := path.Join(sm.Config.TmpDir, pactCacheDir, "pact-stub-server-"+pactStubServerVersion)
+	if Exists(binary) {
+		return binary, nil
+	}
+	if sm.Commands.Offline {
+		return "", fmt.Errorf("pact-stub-server isn't installed, and can't be downloaded offline")
+	}
+
+	goos, arch := runtime.GOOS, runtime.GOARCH
+	if goos == "darwin" {
+		goos = "osx"
+	}
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm64":
+		arch = "aarch64"
+	}
+	return binary, sm.downloadPactStubServer(fmt.Sprintf(pactStubServerUrl, pactStubServerVersion, goos, arch), binary)
+}
+
+func (sm *ServiceManager) downloadPactStubServer(url string, binary string) error {
+	if err := os.MkdirAll(path.Dir(binary), 0755); err != nil {
+		return err
+	}
+	resp, err := sm.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http GET %s failed with status %s, expected 200", url, resp.Status)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	// written alongside and renamed, as other stubs starting at the same time may want it too
+	tmp, err := os.CreateTemp(path.Dir(binary), "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, gz); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), binary)
+}
+
+func (sm *ServiceManager) startPact(service Service, installDir string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	binary, err := sm.pactStubServer()
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+
+	pactDir := path.Join(installDir, "pacts")
+	if sm.Commands.Offline && Exists(pactDir) {
+		sm.PrintVerbose("%s is using the pacts fetched last time, as sm2 is offline\n", service.Id)
+	} else if err := sm.fetchPacts(service, pactDir); err != nil {
+		if !Exists(pactDir) {
+			return ledger.StateFile{}, err
+		}
+		sm.PrintVerbose("%s is using the pacts fetched last time: %s\n", service.Id, err)
+	}
+	clearExitStatus(installDir)
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	args := []string{"--port", fmt.Sprint(port), "--dir", pactDir}
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Version:        PACT,
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    sm.bindAddress(service),
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            binary,
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
+	}, nil
+}
+
+// mistakes in pact config that would otherwise only show up when the stub starts
+func lintPact(id string, s Service) []string {
+	problems := []string{}
+	if s.Type != PactType {
+		return problems
+	}
+	if s.Pact.Broker == "" || s.Pact.Provider == "" {
+		problems = append(problems, fmt.Sprintf("%s is a pact stub but doesn't have both pact.broker and pact.provider", id))
+	}
+	if s.DefaultPort == 0 {
+		problems = append(problems, fmt.Sprintf("%s is a pact stub but has no defaultPort", id))
+	}
+	return problems
+}
@@ -0,0 +1,106 @@
+package servicemanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestPactLatestUrl(t *testing.T) {
+	for _, tc := range []struct {
+		pact     Pact
+		expected string
+	}{
+		{Pact{Broker: "https://broker/", Provider: "payments"}, "https://broker/pacts/provider/payments/latest"},
+		{Pact{Broker: "https://broker", Provider: "payments", Consumer: "checkout frontend"}, "https://broker/pacts/provider/payments/consumer/checkout%20frontend/latest"},
+		{Pact{Broker: "https://broker", Provider: "payments", Tag: "main"}, "https://broker/pacts/provider/payments/latest/main"},
+	} {
+		if url := tc.pact.latestUrl(); url != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, url)
+		}
+	}
+}
+
+func TestFetchPacts(t *testing.T) {
+	auth := ""
+	var broker *httptest.Server
+	broker = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/pacts/provider/payments/latest":
+			w.Write([]byte(`{"_links": {"pb:pacts": [{"href": "` + broker.URL + `/pacts/checkout"}, {"href": "` + broker.URL + `/pacts/email"}]}}`))
+		case "/pacts/provider/payments/consumer/checkout/latest", "/pacts/checkout":
+			w.Write([]byte(`{"consumer": {"name": "checkout"}}`))
+		case "/pacts/email":
+			w.Write([]byte(`{"consumer": {"name": "email"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer broker.Close()
+
+	sm := ServiceManager{
+		Client:   broker.Client(),
+		Platform: platform.Platform{SecretLookup: func(key string) (string, error) { return "s3cret", nil }},
+	}
+	pactDir := path.Join(t.TempDir(), "pacts")
+
+	service := Service{Id: "PAYMENTS_PACT", Pact: Pact{Broker: broker.URL, Provider: "payments", Token: "${secret:PACT_BROKER_TOKEN}"}}
+	AssertNotErr(t, sm.fetchPacts(service, pactDir))
+	if entries, _ := os.ReadDir(pactDir); len(entries) != 2 {
+		t.Errorf("expected a pact from each consumer, got %d", len(entries))
+	}
+	if auth != "Bearer s3cret" {
+		t.Errorf("expected the token to be sent, got %q", auth)
+	}
+
+	service.Pact.Consumer = "checkout"
+	AssertNotErr(t, sm.fetchPacts(service, pactDir))
+	if data, _ := os.ReadFile(path.Join(pactDir, "pact-1.json")); string(data) != `{"consumer": {"name": "checkout"}}` || Exists(path.Join(pactDir, "pact-2.json")) {
+		t.Errorf("expected only the consumer's pact, got %s", data)
+	}
+
+	service.Pact.Provider = "email"
+	if err := sm.fetchPacts(service, pactDir); err == nil {
+		t.Errorf("expected an error when the broker doesn't have the pacts")
+	}
+	if !Exists(path.Join(pactDir, "pact-1.json")) {
+		t.Errorf("expected the pacts fetched last time to be kept")
+	}
+}
+
+func TestDownloadPactStubServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := bytes.Buffer{}
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte("#!/bin/sh\n"))
+		gz.Close()
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	sm := ServiceManager{Client: server.Client()}
+	binary := path.Join(t.TempDir(), pactCacheDir, "pact-stub-server-0.6.0")
+	AssertNotErr(t, sm.downloadPactStubServer(server.URL+"/pact-stub-server-linux-x86_64.gz", binary))
+	info, err := os.Stat(binary)
+	AssertNotErr(t, err)
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("expected the binary to be executable, got %s", info.Mode())
+	}
+}
+
+func TestLintPact(t *testing.T) {
+	if problems := append(lintTunnel("PAYMENTS_PACT", Service{Type: PactType}), lintPact("PAYMENTS_PACT", Service{Type: PactType})...); len(problems) != 2 {
+		t.Errorf("expected the missing broker and port, got %v", problems)
+	}
+	if problems := lintPact("PAYMENTS_PACT", Service{Type: PactType, DefaultPort: 9050, Pact: Pact{Broker: "https://broker", Provider: "payments"}}); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
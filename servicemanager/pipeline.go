@@ -0,0 +1,199 @@
+package servicemanager
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Installing a service reads the download, gunzips it and writes out the files it contains. Done one after
+// the other on one goroutine each step waits on the others, so they're overlapped instead: the download and
+// the gunzipped tar are each read ahead into a few chunks on goroutines of their own, and small files are
+// written by a pool of writers while the next ones are unpacked.
+
+const (
+	readAheadChunk  = 256 * 1024
+	readAheadChunks = 16 // 4MB read ahead at each step
+
+	extractWriters    = 4
+	maxQueuedFileSize = 1024 * 1024 // larger files are written as they're unpacked, so they're never held in memory
+	maxQueuedFiles    = 32
+	writeBufferSize   = 1024 * 1024
+)
+
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// reads from a reader on a goroutine, keeping a few chunks ahead of whatever's reading from it
+type readAhead struct {
+	chunks  chan chunk
+	current []byte
+	err     error
+	done    chan struct{}
+	once    sync.Once
+	stopped chan struct{} // closed once nothing more will be read
+}
+
+func newReadAhead(r io.Reader) *readAhead {
+	ra := &readAhead{chunks: make(chan chunk, readAheadChunks), done: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(ra.stopped)
+		for {
+			// fills a chunk, keeping whatever error the reader ends with
+			buf := make([]byte, readAheadChunk)
+			n, err := 0, error(nil)
+			for n < len(buf) && err == nil {
+				var read int
+				read, err = r.Read(buf[n:])
+				n += read
+			}
+			select {
+			case ra.chunks <- chunk{buf[:n], err}:
+			case <-ra.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ra
+}
+
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for len(ra.current) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		// once it's closed nothing more is coming, and whatever's reading from it has to be let go of
+		select {
+		case c := <-ra.chunks:
+			ra.current, ra.err = c.data, c.err
+		case <-ra.done:
+			ra.err = io.ErrClosedPipe
+		}
+	}
+	n := copy(p, ra.current)
+	ra.current = ra.current[n:]
+	return n, nil
+}
+
+// stops reading ahead, without waiting for a read that's in progress. Anything reading from it gets
+// io.ErrClosedPipe
+func (ra *readAhead) Close() error {
+	ra.once.Do(func() { close(ra.done) })
+	return nil
+}
+
+// stops reading ahead, and waits until nothing more will be read
+func (ra *readAhead) stop() {
+	ra.Close()
+	<-ra.stopped
+}
+
+type fileJob struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// writes files on a pool of goroutines, keeping the first error
+type fileWriters struct {
+	jobs chan fileJob
+	wg   sync.WaitGroup
+	lock sync.Mutex
+	err  error
+}
+
+func newFileWriters() *fileWriters {
+	w := &fileWriters{jobs: make(chan fileJob, maxQueuedFiles)}
+	for i := 0; i < extractWriters; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for job := range w.jobs {
+				if err := writeFile(job.name, job.mode, job.modTime, job.data); err != nil {
+					w.failed(err)
+				}
+			}
+		}()
+	}
+	return w
+}
+
+func (w *fileWriters) write(name string, mode os.FileMode, modTime time.Time, data []byte) error {
+	if err := w.firstErr(); err != nil {
+		return err
+	}
+	w.jobs <- fileJob{name, mode, modTime, data}
+	return nil
+}
+
+// waits for the queued files to be written
+func (w *fileWriters) wait() error {
+	close(w.jobs)
+	w.wg.Wait()
+	return w.firstErr()
+}
+
+func (w *fileWriters) failed(err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *fileWriters) firstErr() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+func writeFile(name string, mode os.FileMode, modTime time.Time, data []byte) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	// fix up the permissions
+	file.Chmod(mode)
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return setModTime(name, modTime)
+}
+
+// copies a large file out of the archive in big writes, rather than io.Copy's 32KB
+func copyFile(name string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	// hides the file's ReadFrom, which would copy 32KB at a time
+	writer := struct{ io.Writer }{file}
+	if _, err := io.CopyBuffer(writer, r, make([]byte, writeBufferSize)); err != nil {
+		file.Close()
+		return err
+	}
+	file.Chmod(mode)
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return setModTime(name, modTime)
+}
+
+// keeps the time the file was last modified as it was in the archive
+func setModTime(name string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(name, modTime, modTime)
+}
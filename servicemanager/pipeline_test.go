@@ -0,0 +1,308 @@
+package servicemanager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestReadAhead(t *testing.T) {
+	data := make([]byte, 3*readAheadChunk+123)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	read, err := io.ReadAll(newReadAhead(bytes.NewReader(data)))
+	AssertNotErr(t, err)
+	if !bytes.Equal(read, data) {
+		t.Errorf("expected %d bytes to be read as they were, got %d", len(data), len(read))
+	}
+
+	ra := newReadAhead(iotest.TimeoutReader(bytes.NewReader(data)))
+	if _, err := io.ReadAll(ra); err != iotest.ErrTimeout {
+		t.Errorf("expected the reader's error, got %v", err)
+	}
+	ra.Close()
+
+	// a read waiting on a reader that's stuck is let go of when it's closed
+	stuck, _ := io.Pipe()
+	ra = newReadAhead(stuck)
+	readErr := make(chan error)
+	go func() {
+		_, err := ra.Read(make([]byte, 10))
+		readErr <- err
+	}()
+	ra.Close()
+	select {
+	case err := <-readErr:
+		if err != io.ErrClosedPipe {
+			t.Errorf("expected a closed read ahead's reads to fail, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected a read to return once the read ahead is closed")
+	}
+}
+
+func TestExtractTgzStopsReadingWhenItFails(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/../../escaped", Mode: 0644, Size: 4})
+	tw.Write([]byte("oops"))
+	// more than is read ahead, which it's part way through gunzipping when it fails
+	large := make([]byte, 2*readAheadChunk)
+	rand.New(rand.NewSource(3)).Read(large)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/lib/auth.jar", Mode: 0644, Size: int64(len(large))})
+	tw.Write(large)
+	tw.Flush()
+	gz.Flush()
+
+	// the rest of the download never comes, so whatever's reading it would wait forever
+	stuck, writer := io.Pipe()
+	defer writer.Close()
+	before := runtime.NumGoroutine()
+	written := make(chan bool)
+	go func() {
+		writer.Write(archive.Bytes())
+		close(written)
+	}()
+
+	if err := unpackTgz(stuck, t.TempDir()); err == nil {
+		t.Errorf("expected a file outside of the install dir to fail")
+	}
+	<-written
+	// the goroutine reading the download is still waiting on it, but gunzipping has stopped
+	after := runtime.NumGoroutine()
+	for i := 0; i < 100 && after > before+1; i++ {
+		time.Sleep(10 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+	if after > before+1 {
+		t.Errorf("expected the goroutines reading ahead to have stopped, %d were running before and %d after", before, after)
+	}
+}
+
+func TestExtractTgzWritesLargeAndSmallFiles(t *testing.T) {
+	large := make([]byte, 3*maxQueuedFileSize)
+	rand.New(rand.NewSource(2)).Read(large)
+	files := map[string][]byte{"auth-1.0.0/lib/auth.jar": large}
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("auth-1.0.0/lib/dep-%d.jar", i)] = []byte(fmt.Sprintf("dependency %d", i))
+	}
+
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "auth-1.0.0/", Mode: 0755}))
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/bin/auth", Mode: 0755, Size: 9}))
+	tw.Write([]byte("#!/bin/sh"))
+	for name, data := range files {
+		AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}))
+		tw.Write(data)
+	}
+	tw.Close()
+	gz.Close()
+	// archives are often padded after the end of the tar
+	archive.Write(make([]byte, 1024))
+
+	outdir := t.TempDir()
+	hasher := md5.New()
+	serviceDir, err := extractTgz(io.TeeReader(bytes.NewReader(archive.Bytes()), hasher), outdir, "auth")
+	AssertNotErr(t, err)
+	if serviceDir != path.Join(outdir, "auth-1.0.0") {
+		t.Errorf("expected the service to be in auth-1.0.0, got %s", serviceDir)
+	}
+	for name, data := range files {
+		written, err := os.ReadFile(path.Join(outdir, name))
+		if err != nil || !bytes.Equal(written, data) {
+			t.Errorf("expected %s to be written, got %d bytes, %v", name, len(written), err)
+		}
+	}
+	if info, err := os.Stat(path.Join(serviceDir, "bin", "auth")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("expected bin/auth to be executable, got %v %v", info, err)
+	}
+	if fmt.Sprintf("%x", hasher.Sum(nil)) != fmt.Sprintf("%x", md5.Sum(archive.Bytes())) {
+		t.Errorf("expected the whole download to be read, so it's checksummed")
+	}
+}
+
+func TestExtractTgzFailsOnABrokenArchive(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/bin/auth", Mode: 0755, Size: 1000})
+	tw.Write(make([]byte, 1000))
+	tw.Flush()
+	gz.Close()
+
+	// cut off part way through the file
+	if _, err := extractTgz(bytes.NewReader(archive.Bytes()[:archive.Len()/2]), t.TempDir(), ""); err == nil {
+		t.Errorf("expected a truncated download to fail")
+	}
+}
+
+func TestExtractTgzWontWriteOutsideOutdir(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/../../escaped", Mode: 0644, Size: 4})
+	tw.Write([]byte("oops"))
+	tw.Close()
+	gz.Close()
+
+	parent := t.TempDir()
+	outdir := path.Join(parent, "install")
+	AssertNotErr(t, os.Mkdir(outdir, 0755))
+	if _, err := extractTgz(bytes.NewReader(archive.Bytes()), outdir, ""); err == nil {
+		t.Errorf("expected a file outside of the install dir to fail")
+	}
+	if _, err := os.Stat(path.Join(parent, "escaped")); err == nil {
+		t.Errorf("expected nothing to be written outside of the install dir")
+	}
+}
+
+func TestExtractTgzHandlesLongNamesLinksAndTimes(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	longName := "auth-1.0.0/conf/" + strings.Repeat("nested/", 20) + "application.conf"
+
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "auth-1.0.0/", Mode: 0755, ModTime: modTime}))
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "auth-1.0.0/lib/auth.jar", Mode: 0644, Size: 4, ModTime: modTime}))
+	tw.Write([]byte("auth"))
+	// long enough that it needs a pax or gnu header
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: longName, Mode: 0644, Size: 4, ModTime: modTime, Format: tar.FormatPAX}))
+	tw.Write([]byte("conf"))
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "auth-1.0.0/lib/current.jar", Linkname: "auth.jar"}))
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "auth-1.0.0/lib/copy.jar", Linkname: "auth-1.0.0/lib/auth.jar"}))
+	tw.Close()
+	gz.Close()
+
+	outdir := t.TempDir()
+	_, err := extractTgz(bytes.NewReader(archive.Bytes()), outdir, "")
+	AssertNotErr(t, err)
+
+	if data, err := os.ReadFile(path.Join(outdir, longName)); err != nil || string(data) != "conf" {
+		t.Errorf("expected the file with the long name to be written, got %q %v", data, err)
+	}
+	if target, err := os.Readlink(path.Join(outdir, "auth-1.0.0/lib/current.jar")); err != nil || target != "auth.jar" {
+		t.Errorf("expected a symlink to auth.jar, got %q %v", target, err)
+	}
+	if data, err := os.ReadFile(path.Join(outdir, "auth-1.0.0/lib/copy.jar")); err != nil || string(data) != "auth" {
+		t.Errorf("expected the hard link to be created, got %q %v", data, err)
+	}
+	for _, name := range []string{"auth-1.0.0", "auth-1.0.0/lib/auth.jar"} {
+		if info, err := os.Stat(path.Join(outdir, name)); err != nil || !info.ModTime().Equal(modTime) {
+			t.Errorf("expected %s to keep its time from the archive, got %v %v", name, info, err)
+		}
+	}
+}
+
+func TestExtractTgzWontLinkOutsideOutdir(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "auth-1.0.0/passwd", Linkname: "../../../etc/passwd"})
+	tw.Close()
+	gz.Close()
+
+	if _, err := extractTgz(bytes.NewReader(archive.Bytes()), t.TempDir(), ""); err == nil {
+		t.Errorf("expected a link outside of the install dir to fail")
+	}
+}
+
+func TestExtractTgzWontWriteThroughChainedSymlinks(t *testing.T) {
+	// each link on its own stays inside of the install dir, but followed one after another they go outside of it
+	archives := map[string][]*tar.Header{
+		"through an extracted link": {
+			{Typeflag: tar.TypeSymlink, Name: "a/b", Linkname: ".."},
+			{Typeflag: tar.TypeSymlink, Name: "a/b/c", Linkname: ".."},
+			{Typeflag: tar.TypeReg, Name: "a/b/c/evil", Mode: 0644, Size: 4},
+		},
+		"through a link extracted after it": {
+			{Typeflag: tar.TypeSymlink, Name: "a/c", Linkname: "b/../evil"},
+			{Typeflag: tar.TypeSymlink, Name: "a/b", Linkname: ".."},
+		},
+		"replacing a file": {
+			{Typeflag: tar.TypeReg, Name: "a/evil", Mode: 0644, Size: 4},
+			{Typeflag: tar.TypeSymlink, Name: "a/b", Linkname: ".."},
+			{Typeflag: tar.TypeSymlink, Name: "a/evil", Linkname: "b/../evil"},
+		},
+		"hard linking through a link": {
+			{Typeflag: tar.TypeSymlink, Name: "a/b", Linkname: ".."},
+			{Typeflag: tar.TypeSymlink, Name: "a/c", Linkname: "b/.."},
+			{Typeflag: tar.TypeLink, Name: "a/passwd", Linkname: "a/c/../etc/passwd"},
+		},
+	}
+	for name, headers := range archives {
+		archive := bytes.Buffer{}
+		gz := gzip.NewWriter(&archive)
+		tw := tar.NewWriter(gz)
+		for _, header := range headers {
+			AssertNotErr(t, tw.WriteHeader(header))
+			if header.Typeflag == tar.TypeReg {
+				tw.Write([]byte("oops"))
+			}
+		}
+		tw.Close()
+		gz.Close()
+
+		parent := t.TempDir()
+		outdir := path.Join(parent, "install", "tmp")
+		AssertNotErr(t, os.MkdirAll(outdir, 0755))
+		if err := unpackTgz(bytes.NewReader(archive.Bytes()), outdir); err == nil {
+			t.Errorf("%s: expected a link outside of the install dir to fail", name)
+		}
+		for _, escaped := range []string{"evil", "install/evil", "etc/passwd"} {
+			if _, err := os.Stat(path.Join(parent, escaped)); err == nil {
+				t.Errorf("%s: expected nothing to be written outside of the install dir, found %s", name, escaped)
+			}
+		}
+	}
+}
+
+func TestExtractTgzIntoAPathWithSpacesAndAccents(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"zoë's service-1.0.0/bin/zoë service":           "#!/bin/sh\n",
+		"zoë's service-1.0.0/conf/application (ü).conf": "conf",
+		"zoë's service-1.0.0/lib/日本語 utils.jar":         "jar",
+	}
+	for name, content := range files {
+		AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0755, Size: int64(len(content))}))
+		tw.Write([]byte(content))
+	}
+	AssertNotErr(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "zoë's service-1.0.0/lib/current utils.jar", Linkname: "日本語 utils.jar"}))
+	tw.Close()
+	gz.Close()
+
+	outdir := path.Join(t.TempDir(), "Zoë Smith", "my workspace", "install")
+	AssertNotErr(t, os.MkdirAll(outdir, 0755))
+	serviceDir, err := extractTgz(bytes.NewReader(archive.Bytes()), outdir, "zoë service")
+	AssertNotErr(t, err)
+	if serviceDir != path.Join(outdir, "zoë's service-1.0.0") {
+		t.Errorf("expected the service dir to be found, got %s", serviceDir)
+	}
+	for name, content := range files {
+		if data, err := os.ReadFile(path.Join(outdir, name)); err != nil || string(data) != content {
+			t.Errorf("expected %s to be extracted, got %q %v", name, data, err)
+		}
+	}
+	if data, err := os.ReadFile(path.Join(outdir, "zoë's service-1.0.0/lib/current utils.jar")); err != nil || string(data) != "jar" {
+		t.Errorf("expected the link to be followed, got %q %v", data, err)
+	}
+}
@@ -0,0 +1,65 @@
+package servicemanager
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Services can have values that only apply on some platforms, e.g. a bigger heap on linux CI boxes or a
+// different binary on M-series macs:
+//
+//	"platforms": {
+//	  "linux":        { "binary": { "cmd": ["./my-service/bin/my-service", "-J-Xmx1g"] } },
+//	  "darwin/arm64": { "binary": { "artifact": "my-service-aarch64_%%" } }
+//	}
+//
+// They're merged over the service when its loaded, the same way overrides are, with os/arch winning
+// over just the os.
+type Platforms map[string]Service
+
+var knownOperatingSystems = []string{"darwin", "linux", "windows"}
+var knownArchitectures = []string{"amd64", "arm64"}
+
+// the keys in platforms that apply to this machine, least specific first
+func currentPlatforms() []string {
+	return []string{runtime.GOOS, runtime.GOOS + "/" + runtime.GOARCH}
+}
+
+// merges the matching platform values into each service, and removes the platforms section
+func applyPlatforms(services map[string]interface{}, platforms []string) {
+	for id, s := range services {
+		service, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		values, ok := service["platforms"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delete(service, "platforms")
+
+		for _, platform := range platforms {
+			if override, ok := values[platform].(map[string]interface{}); ok {
+				service = deepMerge(service, override)
+			}
+		}
+		services[id] = service
+	}
+}
+
+func isKnownPlatform(platform string) bool {
+	os, arch, hasArch := strings.Cut(platform, "/")
+	if !contains(knownOperatingSystems, os) {
+		return false
+	}
+	return !hasArch || contains(knownArchitectures, arch)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestApplyPlatforms(t *testing.T) {
+	data := []byte(`{
+		"AUTH": {
+			"defaultPort": 8500,
+			"binary": {"artifact": "auth_%%", "cmd": ["./auth/bin/auth", "-J-Xmx256m"]},
+			"platforms": {
+				"linux":        {"binary": {"cmd": ["./auth/bin/auth", "-J-Xmx1g"]}},
+				"darwin":       {"defaultPort": 8501},
+				"darwin/arm64": {"defaultPort": 8502, "binary": {"artifact": "auth-aarch64_%%"}}
+			}
+		}
+	}`)
+
+	tests := map[string]struct {
+		port     int
+		artifact string
+		cmd      []string
+	}{
+		"linux/amd64":  {8500, "auth_%%", []string{"./auth/bin/auth", "-J-Xmx1g"}},
+		"darwin/amd64": {8501, "auth_%%", []string{"./auth/bin/auth", "-J-Xmx256m"}},
+		"darwin/arm64": {8502, "auth-aarch64_%%", []string{"./auth/bin/auth", "-J-Xmx256m"}},
+		"windows/386":  {8500, "auth_%%", []string{"./auth/bin/auth", "-J-Xmx256m"}},
+	}
+
+	for platform, expected := range tests {
+		os, _, _ := strings.Cut(platform, "/")
+		expanded, err := expandServices(data, nil, []string{os, platform})
+		AssertNotErr(t, err)
+
+		services := Services{}
+		AssertNotErr(t, json.Unmarshal(expanded, &services))
+		auth := services["AUTH"]
+
+		if auth.DefaultPort != expected.port || auth.Binary.Artifact != expected.artifact || !reflect.DeepEqual(auth.Binary.Cmd, expected.cmd) {
+			t.Errorf("%s: expected %+v, got port %d, artifact %s, cmd %v", platform, expected, auth.DefaultPort, auth.Binary.Artifact, auth.Binary.Cmd)
+		}
+		if auth.Platforms != nil {
+			t.Errorf("%s: expected the platforms section to be removed", platform)
+		}
+	}
+}
+
+func TestValidatePlatforms(t *testing.T) {
+	problems := validateConfig("services.json", []byte(`{
+		"AUTH": {
+			"binary": {"artifact": "auth", "groupId": "uk.gov.hmrc", "cmd": ["./auth/bin/auth"]},
+			"platforms": {
+				"darwin/arm64": {"binary": {"artifact": "auth-aarch64"}},
+				"macos": {"defaultPort": 1}
+			}
+		}
+	}`), reflect.TypeOf(Services{}))
+
+	if len(problems) != 1 || problems[0].path != "AUTH.platforms.macos" {
+		t.Errorf("expected only the unknown platform to be reported, got %v", problems)
+	}
+}
@@ -0,0 +1,104 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Prints the ports a remote dev environment needs to forward, for the services and profiles given on the
+// command line (or the running services if there aren't any), e.g.
+//
+//	sm2 -export-ports devcontainer PROFILE
+//
+// prints the forwardPorts and portsAttributes to paste into .devcontainer/devcontainer.json, which is
+// also what Codespaces uses.
+
+type devcontainerPorts struct {
+	ForwardPorts    []int                             `json:"forwardPorts"`
+	PortsAttributes map[string]devcontainerPortAttrib `json:"portsAttributes"`
+}
+
+type devcontainerPortAttrib struct {
+	Label         string `json:"label"`
+	Protocol      string `json:"protocol"`
+	OnAutoForward string `json:"onAutoForward"`
+}
+
+type exportedPort struct {
+	service string
+	port    int
+}
+
+func (sm *ServiceManager) ExportPorts(format string, out io.Writer) error {
+	if format != "devcontainer" {
+		return fmt.Errorf("unknown format %s, expected devcontainer", format)
+	}
+
+	ports, err := sm.portsToExport()
+	if err != nil {
+		return err
+	}
+	return writeDevcontainerPorts(ports, out)
+}
+
+// the named services on the port they're running on, or would start on. without any names, the running services
+func (sm *ServiceManager) portsToExport() ([]exportedPort, error) {
+	running := sm.runningServicePorts()
+	ports := []exportedPort{}
+
+	requested := sm.requestedServicesAndProfiles()
+	if len(requested) == 0 {
+		for id, port := range running {
+			ports = append(ports, exportedPort{id, port})
+		}
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("no services are running, name the services or profiles to export the ports of")
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, sv := range requested {
+		service, ok := sm.Services[sv.service]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a service or a profile", sv.service)
+		}
+		if seen[sv.service] {
+			continue
+		}
+		seen[sv.service] = true
+
+		port, isRunning := running[sv.service]
+		if !isRunning {
+			port = sm.findPort(service)
+		}
+		if port > 0 {
+			ports = append(ports, exportedPort{sv.service, port})
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].port < ports[j].port
+	})
+	return ports, nil
+}
+
+func writeDevcontainerPorts(ports []exportedPort, out io.Writer) error {
+	config := devcontainerPorts{
+		ForwardPorts:    []int{},
+		PortsAttributes: map[string]devcontainerPortAttrib{},
+	}
+	for _, p := range ports {
+		config.ForwardPorts = append(config.ForwardPorts, p.port)
+		// forwarding dozens of services would otherwise pop up a notification for each one
+		config.PortsAttributes[fmt.Sprint(p.port)] = devcontainerPortAttrib{Label: p.service, Protocol: "http", OnAutoForward: "silent"}
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
@@ -0,0 +1,55 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestExportPorts(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{
+			"FRONTEND": {Id: "FRONTEND", DefaultPort: 9000},
+			"API":      {Id: "API", DefaultPort: 9001},
+			"OTHER":    {Id: "OTHER", DefaultPort: 9002},
+		},
+		Profiles: Profiles{"WEB": {"FRONTEND", "API"}},
+		Commands: cli.UserOption{ExtraServices: []string{"WEB", "API"}},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return []ledger.StateFile{{Service: "API", Port: 41000, Pid: 1}, {Service: "OTHER", Port: 9002, Pid: 2}}, nil
+		}},
+		Platform: platform.Platform{PidLookup: func() map[int]int { return map[int]int{1: 1, 2: 1} }},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportPorts("devcontainer", &out))
+	exported := devcontainerPorts{}
+	AssertNotErr(t, json.Unmarshal(out.Bytes(), &exported))
+
+	// API is on the port it's running on, OTHER is running but not in the profile
+	if !reflect.DeepEqual(exported.ForwardPorts, []int{9000, 41000}) {
+		t.Errorf("expected the profile's ports, got %v", exported.ForwardPorts)
+	}
+	if exported.PortsAttributes["41000"].Label != "API" || exported.PortsAttributes["9000"].OnAutoForward != "silent" {
+		t.Errorf("unexpected attributes %+v", exported.PortsAttributes)
+	}
+
+	// without any names, the running services
+	sm.Commands = cli.UserOption{}
+	out.Reset()
+	AssertNotErr(t, sm.ExportPorts("devcontainer", &out))
+	AssertNotErr(t, json.Unmarshal(out.Bytes(), &exported))
+	if !reflect.DeepEqual(exported.ForwardPorts, []int{9002, 41000}) {
+		t.Errorf("expected the running services' ports, got %v", exported.ForwardPorts)
+	}
+
+	if err := sm.ExportPorts("compose", &out); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,77 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+
+	"sm2/ledger"
+)
+
+// A service's health check passing only means something answers on its port, which might not be the service:
+// a copy of it sm2 lost track of, or something else that took the port while it was starting. So the services
+// that pass are also checked to be what's listening on their port, i.e. the process sm2 started or one of its
+// children (start scripts run java, sbt forks the app), or for one started with -capture, the proxy recording
+// it, which listens on its port in its place. One that isn't fails, and -status says what has the
+// port. Containers aren't checked, as docker's proxy listens for them, and nor is anything with -no-port-check.
+
+// the pids listening on the ports of the services that aren't their own, by service
+func (sm *ServiceManager) findPortImpostors(states []ledger.StateFile) map[string]int {
+	impostors := map[string]int{}
+	if sm.Commands.NoPortCheck || sm.Platform.PortPidLookup == nil || sm.Platform.ParentPidLookup == nil {
+		return impostors
+	}
+	checked := []ledger.StateFile{}
+	for _, state := range states {
+		if state.Runtime == "" && state.Port > 0 && state.Pid > 0 {
+			checked = append(checked, state)
+		}
+	}
+	if len(checked) == 0 {
+		return impostors
+	}
+
+	ports := sm.Platform.PortPidLookup()
+	parents := sm.Platform.ParentPidLookup()
+	for _, state := range checked {
+		// when it can't be seen, e.g. it's another user's, it's given the benefit of the doubt
+		owner, ok := ports[state.Port]
+		if !ok || isDescendant(owner, state.Pid, parents) {
+			continue
+		}
+		if state.CapturePid > 0 && isDescendant(owner, state.CapturePid, parents) {
+			continue
+		}
+		impostors[state.Service] = owner
+	}
+	return impostors
+}
+
+// true if pid is ancestor, or was started by it
+func isDescendant(pid int, ancestor int, parents map[int]int) bool {
+	seen := map[int]bool{}
+	for pid > 1 && !seen[pid] {
+		if pid == ancestor {
+			return true
+		}
+		seen[pid] = true
+		pid = parents[pid]
+	}
+	return pid == ancestor
+}
+
+func printPortImpostors(statuses []serviceStatus, out io.Writer) {
+	first := true
+	for _, s := range statuses {
+		if s.portPid == 0 {
+			continue
+		}
+		if first {
+			fmt.Fprint(out, "\n\033[31mThe following services failed because something else is answering on their port:\n")
+			first = false
+		}
+		fmt.Fprintf(out, "  %s's port %d is listened on by pid %d, not the service (pid %d)\n", s.service, s.port, s.portPid, s.pid)
+	}
+	if !first {
+		fmt.Fprint(out, "\033[0m")
+	}
+}
@@ -0,0 +1,55 @@
+package servicemanager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestFindPortImpostors(t *testing.T) {
+	sm := ServiceManager{
+		Platform: platform.Platform{
+			PortPidLookup: func() map[int]int {
+				return map[int]int{9000: 100, 9001: 201, 9002: 300, 9004: 400, 9005: 500, 9006: 300}
+			},
+			// 201 is run by the start script, 200, and 500 is CAPTURED's capture proxy
+			ParentPidLookup: func() map[int]int {
+				return map[int]int{100: 1, 200: 1, 201: 200, 300: 1, 400: 1, 500: 1, 550: 1}
+			},
+		},
+	}
+	states := []ledger.StateFile{
+		{Service: "ITSELF", Pid: 100, Port: 9000},
+		{Service: "CHILD", Pid: 200, Port: 9001},
+		{Service: "OTHER", Pid: 250, Port: 9002},
+		{Service: "UNSEEN", Pid: 350, Port: 9003},
+		{Service: "CONTAINER", Pid: 450, Port: 9004, Runtime: "docker"},
+		{Service: "CAPTURED", Pid: 550, Port: 9005, CapturePid: 500},
+		{Service: "CAPTURED_OTHER", Pid: 550, Port: 9006, CapturePid: 500},
+	}
+
+	impostors := sm.findPortImpostors(states)
+	if len(impostors) != 2 || impostors["OTHER"] != 300 || impostors["CAPTURED_OTHER"] != 300 {
+		t.Errorf("expected only OTHER's and CAPTURED_OTHER's ports to be someone else's, got %v", impostors)
+	}
+
+	sm.Commands.NoPortCheck = true
+	if impostors := sm.findPortImpostors(states); len(impostors) != 0 {
+		t.Errorf("expected no ports to be checked with -no-port-check, got %v", impostors)
+	}
+}
+
+func TestPrintPortImpostors(t *testing.T) {
+	out := bytes.Buffer{}
+	printPortImpostors([]serviceStatus{{service: "FOO", port: 9000, pid: 100}}, &out)
+	if out.Len() != 0 {
+		t.Errorf("expected nothing to be printed when the services own their ports, got %s", out.String())
+	}
+	printPortImpostors([]serviceStatus{{service: "FOO", port: 9000, pid: 100, portPid: 300, health: FAIL}}, &out)
+	if !strings.Contains(out.String(), "FOO's port 9000 is listened on by pid 300") {
+		t.Errorf("expected FOO's port to be reported, got %s", out.String())
+	}
+}
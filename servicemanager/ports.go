@@ -0,0 +1,293 @@
+package servicemanager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"sm2/cli"
+)
+
+// Workspaces can limit the ports services use with the portRanges setting, and choose what happens when
+// a service's port is taken (by another service in the same start, something else on the machine, or
+// because it's outside the allowed or os reserved ranges) with portCollision:
+//
+//	fail   - the service fails to start, the default
+//	shift  - the service is started on the next port that's free
+//	prompt - asks which port to use, or fails if there's no terminal to ask on
+//
+// Without either setting, a port that's already in use or used by another service in the same start still
+// fails the service, but nothing else is checked. Every port is checked at once before anything's started,
+// and the services that can't start on theirs are listed together up front.
+
+type plannedPort struct {
+	port int
+	err  error
+}
+
+var privilegedPorts = cli.PortRange{From: 1, To: 1023}
+
+var loopbackAddresses = []string{"127.0.0.1", "::1"}
+
+// how many ports (and health checks of services that may already be running) are checked at the same time
+const portCheckWorkers = 16
+
+// what's found out about a service's port before the batch is planned
+type portCheck struct {
+	running bool // the service is already up, so the port is its own
+	inUse   bool
+}
+
+func (sm *ServiceManager) portPolicyEnabled() bool {
+	return sm.Config.PortCollision != "" || len(sm.Config.PortRanges) > 0
+}
+
+// works out the port for each service before a batch is started, so services in the same batch don't
+// race each other for a port. prompt is nil if there's no terminal to ask on.
+func (sm *ServiceManager) planPorts(services []ServiceAndVersion, prompt *bufio.Reader, out io.Writer) {
+	sm.portPlan = nil
+	if sm.Commands.DynamicPorts || sm.Commands.Port > 0 {
+		return
+	}
+
+	batch := []Service{}
+	for _, sv := range services {
+		if service, ok := sm.Services[sv.service]; ok && service.DefaultPort != 0 {
+			batch = append(batch, service)
+		}
+	}
+	checks := sm.checkBatchPorts(batch)
+
+	sm.portPlan = map[string]plannedPort{}
+	claimed := map[int]string{}
+	failed := []string{}
+	for _, service := range batch {
+		check := checks[service.Id]
+		if check.running {
+			continue
+		}
+
+		port := service.DefaultPort
+		var err error
+		problem := ""
+		if owner, ok := claimed[port]; ok {
+			problem = "is also used by " + owner
+		} else if check.inUse {
+			problem = "is already in use"
+		} else {
+			problem = sm.unclaimedPortProblem(port, false)
+		}
+		if problem != "" {
+			port, err = sm.resolvePortCollision(service.Id, port, problem, claimed, prompt, out)
+		}
+		if err == nil {
+			claimed[port] = service.Id
+		} else {
+			failed = append(failed, fmt.Sprintf("  %s: %s", service.Id, err))
+		}
+		sm.portPlan[service.Id] = plannedPort{port, err}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(out, "%d of %d services can't use their ports, and won't be started:\n%s\n", len(failed), len(batch), strings.Join(failed, "\n"))
+	}
+}
+
+// finds out which services are already running, and which ports are in use, for all of them at once
+func (sm *ServiceManager) checkBatchPorts(services []Service) map[string]portCheck {
+	tasks := make(chan Service, len(services))
+	for _, service := range services {
+		tasks <- service
+	}
+	close(tasks)
+
+	lock := sync.Mutex{}
+	checks := map[string]portCheck{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < portCheckWorkers && i < len(services); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for service := range tasks {
+				check := portCheck{running: sm.isAlreadyRunning(service)}
+				if !check.running {
+					check.inUse = portInUse(service.DefaultPort)
+				}
+				lock.Lock()
+				checks[service.Id] = check
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return checks
+}
+
+func (sm *ServiceManager) resolvePortCollision(id string, port int, problem string, claimed map[int]string, prompt *bufio.Reader, out io.Writer) (int, error) {
+	switch sm.Config.PortCollision {
+	case "shift":
+		next, ok := sm.nextFreePort(port, claimed)
+		if !ok {
+			return 0, fmt.Errorf("port %d %s, and there are no free ports to use instead", port, problem)
+		}
+		fmt.Fprintf(out, "%s: port %d %s, using %d instead\n", id, port, problem, next)
+		return next, nil
+
+	case "prompt":
+		if prompt == nil {
+			return 0, fmt.Errorf("port %d %s, and there's no terminal to ask which port to use instead", port, problem)
+		}
+		suggestion := ""
+		if next, ok := sm.nextFreePort(port, claimed); ok {
+			suggestion = fmt.Sprint(next)
+		}
+		answer, err := ask(prompt, out, fmt.Sprintf("%s: port %d %s, which port should it use instead", id, port, problem), suggestion)
+		if err != nil {
+			return 0, err
+		}
+		chosen, err := strconv.Atoi(answer)
+		if err != nil || chosen < 1 || chosen > 65535 {
+			return 0, fmt.Errorf("%s is not a valid port", answer)
+		}
+		return chosen, nil
+
+	default:
+		return 0, fmt.Errorf("port %d %s", port, problem)
+	}
+}
+
+// why a port can't be used, or "" if it can
+func (sm *ServiceManager) portProblem(port int, claimed map[int]string) string {
+	if owner, ok := claimed[port]; ok {
+		return "is also used by " + owner
+	}
+	return sm.unclaimedPortProblem(port, true)
+}
+
+// why a port no other service has claimed can't be used. The ranges are only checked when the workspace
+// sets them up, and checkInUse is false when it's already been checked
+func (sm *ServiceManager) unclaimedPortProblem(port int, checkInUse bool) string {
+	if sm.portPolicyEnabled() {
+		if !inPortRanges(port, sm.Config.PortRanges) {
+			return "is outside the allowed port ranges (" + formatPortRanges(sm.Config.PortRanges) + ")"
+		}
+		for _, r := range reservedPortRanges() {
+			if r.Contains(port) {
+				return "is in the os reserved range " + r.String()
+			}
+		}
+	}
+	if checkInUse && portInUse(port) {
+		return "is already in use"
+	}
+	if sm.windowsPortInUse(port) {
+		return "is in use on windows, which would keep localhost:" + fmt.Sprint(port) + " for itself"
+	}
+	return ""
+}
+
+// the next port after the given one that can be used, wrapping around to the start of the allowed ranges
+func (sm *ServiceManager) nextFreePort(port int, claimed map[int]string) (int, bool) {
+	ranges := sm.Config.PortRanges
+	if len(ranges) == 0 {
+		ranges = []cli.PortRange{{From: privilegedPorts.To + 1, To: 65535}}
+	}
+
+	candidates := []int{}
+	for _, r := range ranges {
+		for p := r.From; p <= r.To; p++ {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.Ints(candidates)
+	start := sort.SearchInts(candidates, port+1)
+
+	for i := 0; i < len(candidates); i++ {
+		p := candidates[(start+i)%len(candidates)]
+		if p != port && sm.portProblem(p, claimed) == "" {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// config ports that are outside the allowed ranges or in a range the os reserves for itself
+func portRangeProblems(services Services, allowed []cli.PortRange) []string {
+	problems := []string{}
+	reserved := reservedPortRanges()
+	for id, s := range services {
+		if s.DefaultPort == 0 || s.RenamedTo != "" || s.Deprecated {
+			continue
+		}
+		if !inPortRanges(s.DefaultPort, allowed) {
+			problems = append(problems, fmt.Sprintf("%s uses port %d, which is outside the allowed port ranges (%s)", id, s.DefaultPort, formatPortRanges(allowed)))
+		}
+		for _, r := range reserved {
+			if r.Contains(s.DefaultPort) {
+				problems = append(problems, fmt.Sprintf("%s uses port %d, which is in the os reserved range %s", id, s.DefaultPort, r))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// no ranges allows any port
+func inPortRanges(port int, ranges []cli.PortRange) bool {
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return len(ranges) == 0
+}
+
+func formatPortRanges(ranges []cli.PortRange) string {
+	formatted := []string{}
+	for _, r := range ranges {
+		formatted = append(formatted, r.String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// ports that need root, and the range the os hands out for outgoing connections and port 0
+func reservedPortRanges() []cli.PortRange {
+	return []cli.PortRange{privilegedPorts, ephemeralPorts()}
+}
+
+func ephemeralPorts() cli.PortRange {
+	if runtime.GOOS == "linux" {
+		ephemeral := cli.PortRange{From: 32768, To: 60999}
+		if data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range"); err == nil {
+			fmt.Sscan(string(data), &ephemeral.From, &ephemeral.To)
+		}
+		return ephemeral
+	}
+	return cli.PortRange{From: 49152, To: 65535}
+}
+
+// true if something is listening on the port on either loopback address, since a service that only
+// listens on ::1 doesn't stop another listening on 127.0.0.1 but will still clash with it on localhost
+func portInUse(port int) bool {
+	for _, address := range loopbackAddresses {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, fmt.Sprint(port)))
+		if err == nil {
+			listener.Close()
+			continue
+		}
+		// machines without ipv6 can't listen on ::1 at all, which doesn't mean the port's taken
+		if address == "127.0.0.1" || errors.Is(err, syscall.EADDRINUSE) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package servicemanager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+)
+
+func TestPlanPorts(t *testing.T) {
+	sm := ServiceManager{
+		Client: &http.Client{Timeout: 100 * time.Millisecond},
+		Config: ServiceManagerConfig{
+			TmpDir:        t.TempDir(),
+			TimeoutShort:  100 * time.Millisecond,
+			PortRanges:    []cli.PortRange{{From: 18100, To: 18105}},
+			PortCollision: "shift",
+		},
+		Services: Services{
+			"FOO": {Id: "FOO", DefaultPort: 18100},
+			"BAR": {Id: "BAR", DefaultPort: 18100},
+			"BAZ": {Id: "BAZ", DefaultPort: 7000},
+		},
+	}
+	batch := []ServiceAndVersion{{service: "FOO"}, {service: "BAR"}, {service: "BAZ"}}
+
+	out := bytes.Buffer{}
+	sm.planPorts(batch, nil, &out)
+	if sm.portPlan["FOO"].port != 18100 || sm.portPlan["BAR"].port != 18101 || sm.portPlan["BAZ"].port != 18102 {
+		t.Errorf("expected clashing and out of range ports to be shifted, got %+v", sm.portPlan)
+	}
+	if !strings.Contains(out.String(), "BAR: port 18100 is also used by FOO, using 18101 instead") {
+		t.Errorf("expected the shift to be reported, got %s", out.String())
+	}
+	if port, _ := sm.assignPort(sm.Services["BAR"]); port != 18101 {
+		t.Errorf("expected assignPort to use the planned port, got %d", port)
+	}
+
+	sm.Config.PortCollision = "fail"
+	sm.planPorts(batch, nil, &out)
+	if err := sm.portPlan["BAR"].err; err == nil || err.Error() != "port 18100 is also used by FOO" {
+		t.Errorf("expected BAR to fail, got %v", err)
+	}
+	if err := sm.portPlan["BAZ"].err; err == nil || !strings.Contains(err.Error(), "outside the allowed port ranges (18100-18105)") {
+		t.Errorf("expected BAZ to fail, got %v", err)
+	}
+
+	// prompting suggests the next free port, and fails without a terminal
+	sm.Config.PortCollision = "prompt"
+	out.Reset()
+	sm.planPorts(batch, bufio.NewReader(strings.NewReader("\n18105\n")), &out)
+	if sm.portPlan["BAR"].port != 18101 || sm.portPlan["BAZ"].port != 18105 {
+		t.Errorf("expected the answers to be used, got %+v", sm.portPlan)
+	}
+	if !strings.Contains(out.String(), "BAR: port 18100 is also used by FOO, which port should it use instead [18101]: ") {
+		t.Errorf("expected a prompt with a suggestion, got %s", out.String())
+	}
+	sm.planPorts(batch, nil, &out)
+	if sm.portPlan["BAR"].err == nil {
+		t.Errorf("expected prompting without a terminal to fail")
+	}
+
+	// without the settings only clashes fail, and ports are left where they are
+	sm.Config = ServiceManagerConfig{}
+	sm.planPorts(batch, nil, &out)
+	if sm.portPlan["FOO"].port != 18100 || sm.portPlan["BAZ"].port != 7000 || sm.portPlan["BAZ"].err != nil {
+		t.Errorf("expected the default ports, got %+v", sm.portPlan)
+	}
+	if err := sm.portPlan["BAR"].err; err == nil || err.Error() != "port 18100 is also used by FOO" {
+		t.Errorf("expected BAR to fail, got %v", err)
+	}
+}
+
+func TestPlanPortsReportsEveryProblemUpFront(t *testing.T) {
+	taken := []int{}
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		taken = append(taken, listener.Addr().(*net.TCPAddr).Port)
+	}
+	free, err := freePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := ServiceManager{
+		Client: &http.Client{Timeout: 100 * time.Millisecond},
+		Config: ServiceManagerConfig{TmpDir: t.TempDir(), TimeoutShort: 100 * time.Millisecond},
+		Services: Services{
+			"FOO": {Id: "FOO", DefaultPort: taken[0]},
+			"BAR": {Id: "BAR", DefaultPort: taken[1]},
+			"BAZ": {Id: "BAZ", DefaultPort: free},
+			"QUX": {Id: "QUX", DefaultPort: free},
+		},
+	}
+	out := bytes.Buffer{}
+	sm.planPorts([]ServiceAndVersion{{service: "FOO"}, {service: "BAR"}, {service: "BAZ"}, {service: "QUX"}}, nil, &out)
+
+	expected := fmt.Sprintf("3 of 4 services can't use their ports, and won't be started:\n"+
+		"  FOO: port %d is already in use\n"+
+		"  BAR: port %d is already in use\n"+
+		"  QUX: port %d is also used by BAZ\n", taken[0], taken[1], free)
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+	if port, err := sm.assignPort(sm.Services["BAZ"]); port != free || err != nil {
+		t.Errorf("expected BAZ to start on %d, got %d %v", free, port, err)
+	}
+	if _, err := sm.assignPort(sm.Services["FOO"]); err == nil {
+		t.Errorf("expected FOO not to start")
+	}
+}
+
+func TestPortProblems(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if !portInUse(listener.Addr().(*net.TCPAddr).Port) {
+		t.Errorf("expected the port to be in use")
+	}
+
+	// a service only listening on ::1 still takes the port
+	if ipv6, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		defer ipv6.Close()
+		if !portInUse(ipv6.Addr().(*net.TCPAddr).Port) {
+			t.Errorf("expected the port used over ipv6 to be in use")
+		}
+	}
+
+	services := Services{
+		"FOO": {Id: "FOO", DefaultPort: 9000},
+		"BAR": {Id: "BAR", DefaultPort: 80},
+		"OLD": {Id: "OLD", DefaultPort: 80, Deprecated: true},
+	}
+	problems := portRangeProblems(services, []cli.PortRange{{From: 8000, To: 9999}})
+	expected := []string{
+		"BAR uses port 80, which is in the os reserved range 1-1023",
+		"BAR uses port 80, which is outside the allowed port ranges (8000-9999)",
+	}
+	if strings.Join(problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %v got %v", expected, problems)
+	}
+}
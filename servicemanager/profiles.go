@@ -0,0 +1,38 @@
+package servicemanager
+
+// Expands a profile into the services it contains. Profiles can include other profiles, e.g.
+//
+//	"PAYMENTS": ["CORE_PLATFORM", "PAYMENTS_FRONTEND", "PAYMENTS_API"]
+//
+// Services are returned in the order they're first seen, without duplicates. If a name is both a
+// service and a profile its treated as a service, and a profile that includes itself (directly or not)
+// is only expanded once.
+func (sm *ServiceManager) expandProfile(profile string) []string {
+	services := []string{}
+	seenServices := map[string]bool{}
+	seenProfiles := map[string]bool{}
+
+	var expand func(string)
+	expand = func(profile string) {
+		if seenProfiles[profile] {
+			return
+		}
+		seenProfiles[profile] = true
+
+		for _, id := range sm.Profiles[profile] {
+			if _, isService := sm.Services[id]; !isService {
+				if _, isProfile := sm.Profiles[id]; isProfile {
+					expand(id)
+					continue
+				}
+			}
+			if !seenServices[id] {
+				seenServices[id] = true
+				services = append(services, id)
+			}
+		}
+	}
+
+	expand(profile)
+	return services
+}
@@ -0,0 +1,30 @@
+package servicemanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandNestedProfiles(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{"AUTH": {}, "USERS": {}, "PAY_API": {}, "PAY_FRONTEND": {}, "BOTH": {}},
+		Profiles: Profiles{
+			"CORE":     {"AUTH", "USERS"},
+			"PAYMENTS": {"CORE", "PAY_API", "AUTH", "PAY_FRONTEND", "PAYMENTS"},
+			"ALL":      {"PAYMENTS", "CORE", "BOTH"},
+			"BOTH":     {"USERS"},
+		},
+	}
+
+	tests := map[string][]string{
+		"CORE":     {"AUTH", "USERS"},
+		"PAYMENTS": {"AUTH", "USERS", "PAY_API", "PAY_FRONTEND"},
+		"ALL":      {"AUTH", "USERS", "PAY_API", "PAY_FRONTEND", "BOTH"},
+	}
+
+	for profile, expected := range tests {
+		if services := sm.expandProfile(profile); !reflect.DeepEqual(services, expected) {
+			t.Errorf("expected %s to expand to %v, got %v", profile, expected, services)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package servicemanager
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Teams can share profiles without adding them to service-manager-config, e.g.
+//
+//	sm2 --start --profile-url https://example.com/team-profile.json
+//
+// The file can be a list of services, which are started, or in the same format as profiles.json, in
+// which case its profiles can be started by name (or the only profile is started if there's just one).
+// Downloads are cached in the workspace and the cached copy is used when offline or if the download fails.
+
+const profileCacheDir = "profile-cache"
+
+// adds the profiles from a url to sm.Profiles, and works out which of them to start if none are named
+func (sm *ServiceManager) loadProfileUrl(url string) error {
+	data, cached, err := sm.fetchProfile(url)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := sm.parseSharedProfiles(url, data)
+	if err != nil {
+		return err
+	}
+
+	// only valid profiles are cached, so a bad download doesn't replace a good copy
+	if !cached {
+		cacheFile := sm.profileCacheFile(url)
+		if err := os.MkdirAll(path.Dir(cacheFile), 0755); err == nil {
+			os.WriteFile(cacheFile, data, 0644)
+		}
+	}
+
+	names := []string{}
+	for name, services := range profiles {
+		if _, exists := sm.Profiles[name]; exists {
+			fmt.Fprintf(os.Stderr, "Warning: %s from %s replaces the profile with the same name in profiles.json\n", name, url)
+		}
+		sm.Profiles[name] = services
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(sm.Commands.ExtraServices) > 0 {
+		return nil
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("%s has %d profiles (%s), say which to use, e.g. sm2 --start --profile-url %s %s", url, len(names), strings.Join(names, ", "), url, names[0])
+	}
+	sm.Commands.ExtraServices = names
+	return nil
+}
+
+// Checks the profile(s) are valid and only refer to known services and profiles. A list of services is
+// turned into a profile named after the url.
+func (sm *ServiceManager) parseSharedProfiles(url string, data []byte) (Profiles, error) {
+	data, err := configToJson(url, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid: %s", url, err)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		services := []string{}
+		if err := json.Unmarshal(data, &services); err != nil {
+			return nil, fmt.Errorf("%s should be a list of services or a map of profiles: %s", url, err)
+		}
+		return sm.checkSharedProfiles(url, Profiles{url: services})
+	}
+
+	if problems := validateConfig(url, data, reflect.TypeOf(Profiles{})); len(problems) > 0 {
+		return nil, fmt.Errorf("%s is not a valid profile: %s", url, problems[0])
+	}
+
+	profiles := Profiles{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+	return sm.checkSharedProfiles(url, profiles)
+}
+
+func (sm *ServiceManager) checkSharedProfiles(url string, profiles Profiles) (Profiles, error) {
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("%s doesn't contain any profiles", url)
+	}
+
+	unknown := []string{}
+	for _, services := range profiles {
+		for _, id := range services {
+			_, isService := sm.Services[id]
+			_, isProfile := sm.Profiles[id]
+			_, isShared := profiles[id]
+			if !isService && !isProfile && !isShared {
+				unknown = append(unknown, id)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s refers to services that aren't in your config: %s", url, strings.Join(unknown, ", "))
+	}
+	return profiles, nil
+}
+
+func (sm *ServiceManager) profileCacheFile(url string) string {
+	return path.Join(sm.Config.WorkspaceDir, profileCacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+}
+
+// downloads the profile, falling back to the cached copy if it can't be. Returns true if the cached copy was used.
+func (sm *ServiceManager) fetchProfile(url string) ([]byte, bool, error) {
+	if sm.Commands.Offline {
+		data, err := os.ReadFile(sm.profileCacheFile(url))
+		if err != nil {
+			return nil, false, fmt.Errorf("%s has not been downloaded before, so it isn't available offline", url)
+		}
+		return data, true, nil
+	}
+
+	data, err := sm.downloadProfile(url)
+	if err != nil {
+		cached, cacheErr := os.ReadFile(sm.profileCacheFile(url))
+		if cacheErr != nil {
+			return nil, false, err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: unable to download %s (%s), using the copy from the last time it was used\n", url, err)
+		return cached, true, nil
+	}
+	return data, false, nil
+}
+
+func (sm *ServiceManager) downloadProfile(url string) ([]byte, error) {
+	ctx, cancel := sm.NewShortContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := sm.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,96 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestLoadProfileUrl(t *testing.T) {
+	body := `{"TEAM": ["CORE", "PAY_API"]}`
+	status := 200
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer svr.Close()
+
+	workspace, err := os.MkdirTemp(os.TempDir(), "test-profile-url*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(workspace)
+
+	newSm := func() *ServiceManager {
+		return &ServiceManager{
+			Client:   &http.Client{},
+			Config:   ServiceManagerConfig{WorkspaceDir: workspace, TimeoutShort: 4 * time.Second},
+			Services: Services{"AUTH": {}, "PAY_API": {}},
+			Profiles: Profiles{"CORE": {"AUTH"}},
+		}
+	}
+
+	// the only profile is used when none is named
+	sm := newSm()
+	AssertNotErr(t, sm.loadProfileUrl(svr.URL+"/team.json"))
+	if !reflect.DeepEqual(sm.Commands.ExtraServices, []string{"TEAM"}) {
+		t.Errorf("expected TEAM to be started, got %v", sm.Commands.ExtraServices)
+	}
+	if !reflect.DeepEqual(sm.expandProfile("TEAM"), []string{"AUTH", "PAY_API"}) {
+		t.Errorf("expected TEAM to include the CORE profile, got %v", sm.expandProfile("TEAM"))
+	}
+
+	// a failed download falls back to the cached copy
+	status = 500
+	sm = newSm()
+	AssertNotErr(t, sm.loadProfileUrl(svr.URL+"/team.json"))
+	if _, ok := sm.Profiles["TEAM"]; !ok {
+		t.Errorf("expected the cached profile to be used")
+	}
+
+	// as does offline mode
+	status = 200
+	sm = newSm()
+	sm.Commands = cli.UserOption{Offline: true}
+	AssertNotErr(t, sm.loadProfileUrl(svr.URL+"/team.json"))
+
+	// with more than one profile, one has to be named
+	body = `{"A": ["AUTH"], "B": ["PAY_API"]}`
+	if err := newSm().loadProfileUrl(svr.URL + "/many.json"); err == nil || !strings.Contains(err.Error(), "A, B") {
+		t.Errorf("expected to be asked which profile to use, got %v", err)
+	}
+	sm = newSm()
+	sm.Commands.ExtraServices = []string{"B"}
+	AssertNotErr(t, sm.loadProfileUrl(svr.URL+"/many.json"))
+
+	// unknown services are rejected and not cached
+	status = 200
+	body = `["AUTH", "NOT_A_SERVICE"]`
+	sm = newSm()
+	if err := sm.loadProfileUrl(svr.URL + "/list.json"); err == nil || !strings.Contains(err.Error(), "NOT_A_SERVICE") {
+		t.Errorf("expected an error for an unknown service, got %v", err)
+	}
+	status = 500
+	if err := newSm().loadProfileUrl(svr.URL + "/list.json"); err == nil {
+		t.Errorf("expected the invalid profile not to have been cached")
+	}
+}
+
+func TestParseSharedProfiles(t *testing.T) {
+	sm := ServiceManager{Services: Services{"AUTH": {}, "USERS": {}}, Profiles: Profiles{}}
+
+	profiles, err := sm.parseSharedProfiles("https://example.com/list.yaml", []byte("- AUTH\n- USERS\n"))
+	AssertNotErr(t, err)
+	if !reflect.DeepEqual(profiles["https://example.com/list.yaml"], []string{"AUTH", "USERS"}) {
+		t.Errorf("expected a list to become a profile named after the url, got %v", profiles)
+	}
+
+	if _, err := sm.parseSharedProfiles("https://example.com/p.json", []byte(`{"A": "AUTH"}`)); err == nil {
+		t.Errorf("expected a profile that isn't a list to be rejected")
+	}
+}
@@ -3,10 +3,20 @@ package servicemanager
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"sm2/platform"
 )
 
+// Downloads over a fast network call Write thousands of times a second, so the progress bars are only updated
+// once a download has moved on by a percent, and at most every progressInterval. The renderer gathers up the
+// updates that arrive together and redraws at most every redrawInterval, rather than once per update.
+const (
+	progressInterval = 100 * time.Millisecond
+	redrawInterval   = 50 * time.Millisecond
+	progressUnknown  = 1024 * 1024 // how often a download without a content length is marked as progressing
+)
+
 type Progress struct {
 	service string
 	percent float32
@@ -18,18 +28,35 @@ type ProgressWriter struct {
 	service       string
 	contentLength int
 	totalRead     int
-	lastMark      int
+	nextMark      int // nothing is worked out until this much has been read
+	lastUpdate    time.Time
 	renderer      *ProgressRenderer
+	now           func() time.Time
 }
 
 func (pt *ProgressWriter) Write(p []byte) (int, error) {
 	pt.totalRead += len(p)
-	pt.lastMark += len(p)
+	finished := pt.totalRead == pt.contentLength
+	if pt.totalRead < pt.nextMark && !finished {
+		return len(p), nil
+	}
+
+	if pt.contentLength <= 0 {
+		pt.nextMark = pt.totalRead + progressUnknown
+	} else {
+		pt.nextMark = pt.totalRead + pt.contentLength/100
+	}
 
-	// send update every 1mb
-	if pt.lastMark > (1024 * 1024) {
-		pt.lastMark = 0
-		percent := (float32(pt.totalRead) / float32(pt.contentLength)) * 100.0
+	now := time.Now
+	if pt.now != nil {
+		now = pt.now
+	}
+	if t := now(); finished || t.Sub(pt.lastUpdate) >= progressInterval {
+		pt.lastUpdate = t
+		percent := float32(0)
+		if pt.contentLength > 0 {
+			percent = (float32(pt.totalRead) / float32(pt.contentLength)) * 100.0
+		}
 		pt.renderer.update(pt.service, percent, "Install")
 	}
 	return len(p), nil
@@ -40,6 +67,7 @@ type ProgressRenderer struct {
 	state           map[string]Progress
 	errors          map[string]error
 	updateChan      chan Progress
+	flushChan       chan chan struct{}
 	serviceLen      int
 	noProgress      bool
 	getTerminalSize func() (int, int)
@@ -48,6 +76,7 @@ type ProgressRenderer struct {
 func (pr *ProgressRenderer) init(services []ServiceAndVersion) {
 
 	pr.updateChan = make(chan Progress, 2)
+	pr.flushChan = make(chan chan struct{})
 	pr.state = map[string]Progress{}
 	pr.errors = map[string]error{}
 	pr.serviceLen = 14
@@ -67,67 +96,109 @@ func (pr *ProgressRenderer) init(services []ServiceAndVersion) {
 func (pr *ProgressRenderer) renderLoop() {
 
 	linesDrawn := 0
+	lastDrawn := time.Time{}
 
 	for {
 		// block til update
-		u := <-pr.updateChan
-
-		// update the state
-		if _, ok := pr.state[u.service]; ok {
-			pr.state[u.service] = u
+		var flushed chan struct{}
+		select {
+		case u := <-pr.updateChan:
+			pr.apply(u)
+		case flushed = <-pr.flushChan:
 		}
 
-		// clear
-		fmt.Print(strings.Repeat("\033[F\033[2K\r", linesDrawn))
-
-		var MAX_COLS = 40
-		var MAX_ROWS = 22
-		cols, rows := platform.GetTerminalSize()
-		if cols > 80 {
-			MAX_COLS = cols - 40
+		// gathers up whatever else arrives before the next redraw is due, so a burst of updates is drawn once
+		due := time.NewTimer(time.Until(lastDrawn.Add(redrawInterval)))
+	gather:
+		for flushed == nil {
+			select {
+			case u := <-pr.updateChan:
+				pr.apply(u)
+			case flushed = <-pr.flushChan:
+			case <-due.C:
+				break gather
+			}
 		}
-		if rows > 2 {
-			MAX_ROWS = rows - 2
+		due.Stop()
+
+		// anything already sent is drawn before a flush returns
+	drain:
+		for {
+			select {
+			case u := <-pr.updateChan:
+				pr.apply(u)
+			default:
+				break drain
+			}
 		}
 
-		// We only want to draw as many services as will fit into our MAX_ROWS, otherwise we get some
-		// weird scrolling issues on some terminals if we clear past the top of the terminal.
-		// We do this by working out how many services we have, and only drawing a slice that runs from the
-		// first service thats PENDING, dropping DONE services as we need space...
-		// FOO [=======] DONE    <- would be dropped first if we need the space
-		// BAR [==     ] INSTALL
-		// BAZ [       ] PENDING
-		//
-		pendingStartsAt := 0 // position of the first 'Pending' service in service list
-		maxLines := MAX_ROWS // how many services we want to draw
-
-		if maxLines > len(pr.watchlist) {
-			maxLines = len(pr.watchlist)
+		linesDrawn = pr.draw(linesDrawn)
+		lastDrawn = time.Now()
+		if flushed != nil {
+			close(flushed)
 		}
+	}
+}
 
-		for i, service := range pr.watchlist {
-			if p, ok := pr.state[service]; ok && p.state == "Pending" {
-				pendingStartsAt = i
-				break
-			}
-		}
+func (pr *ProgressRenderer) apply(u Progress) {
+	if _, ok := pr.state[u.service]; ok {
+		pr.state[u.service] = u
+	}
+}
+
+// redraws every service's progress, returning how many lines were drawn
+func (pr *ProgressRenderer) draw(linesDrawn int) int {
+	// clear
+	fmt.Print(strings.Repeat("\033[F\033[2K\r", linesDrawn))
+
+	var MAX_COLS = 40
+	var MAX_ROWS = 22
+	cols, rows := platform.GetTerminalSize()
+	if cols > 80 {
+		MAX_COLS = cols - 40
+	}
+	if rows > 2 {
+		MAX_ROWS = rows - 2
+	}
+
+	// We only want to draw as many services as will fit into our MAX_ROWS, otherwise we get some
+	// weird scrolling issues on some terminals if we clear past the top of the terminal.
+	// We do this by working out how many services we have, and only drawing a slice that runs from the
+	// first service thats PENDING, dropping DONE services as we need space...
+	// FOO [=======] DONE    <- would be dropped first if we need the space
+	// BAR [==     ] INSTALL
+	// BAZ [       ] PENDING
+	//
+	pendingStartsAt := 0 // position of the first 'Pending' service in service list
+	maxLines := MAX_ROWS // how many services we want to draw
+
+	if maxLines > len(pr.watchlist) {
+		maxLines = len(pr.watchlist)
+	}
 
-		drawFrom := 0
-		drawTo := maxLines
-		if pendingStartsAt > maxLines {
-			drawFrom = pendingStartsAt - maxLines
-			drawTo = maxLines + drawFrom + 1 // we add 1 here to we see at least 1 pending, it makes the scrolling look more convincing
+	for i, service := range pr.watchlist {
+		if p, ok := pr.state[service]; ok && p.state == "Pending" {
+			pendingStartsAt = i
+			break
 		}
+	}
 
-		// draw all the stuff
-		linesDrawn = 0
-		for _, service := range pr.watchlist[drawFrom:drawTo] {
-			if p, ok := pr.state[service]; ok {
-				fmt.Printf(" %s [%-20s][%3.0f%%] %s\n", crop(pad(p.service, pr.serviceLen), MAX_COLS), strings.Repeat("=", int(p.percent/5)), p.percent, crop(p.state, 8))
-				linesDrawn++
-			}
+	drawFrom := 0
+	drawTo := maxLines
+	if pendingStartsAt > maxLines {
+		drawFrom = pendingStartsAt - maxLines
+		drawTo = maxLines + drawFrom + 1 // we add 1 here to we see at least 1 pending, it makes the scrolling look more convincing
+	}
+
+	// draw all the stuff
+	linesDrawn = 0
+	for _, service := range pr.watchlist[drawFrom:drawTo] {
+		if p, ok := pr.state[service]; ok {
+			fmt.Printf(" %s [%-20s][%3.0f%%] %s\n", crop(pad(p.service, pr.serviceLen), MAX_COLS), strings.Repeat("=", int(p.percent/5)), p.percent, crop(p.state, 8))
+			linesDrawn++
 		}
 	}
+	return linesDrawn
 }
 
 func (pr *ProgressRenderer) update(service string, percent float32, state string) {
@@ -136,6 +207,15 @@ func (pr *ProgressRenderer) update(service string, percent float32, state string
 	}
 }
 
+// waits until everything that's been updated has been drawn
+func (pr *ProgressRenderer) flush() {
+	if !pr.noProgress {
+		flushed := make(chan struct{})
+		pr.flushChan <- flushed
+		<-flushed
+	}
+}
+
 func (pr *ProgressRenderer) error(service string, err error) {
 	pr.errors[service] = err
 }
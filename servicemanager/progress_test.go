@@ -0,0 +1,85 @@
+package servicemanager
+
+import (
+	"testing"
+	"time"
+)
+
+func countProgressUpdates(t *testing.T, pw *ProgressWriter, size int) []Progress {
+	pw.renderer.updateChan = make(chan Progress, 1000)
+	pw.contentLength = size
+	chunk := make([]byte, 32*1024)
+	for written := 0; written < size; written += len(chunk) {
+		if size-written < len(chunk) {
+			chunk = chunk[:size-written]
+		}
+		pw.Write(chunk)
+	}
+	close(pw.renderer.updateChan)
+	updates := []Progress{}
+	for u := range pw.renderer.updateChan {
+		updates = append(updates, u)
+	}
+	return updates
+}
+
+func TestProgressWriterOnlyUpdatesEveryPercent(t *testing.T) {
+	now := time.Now()
+	pw := &ProgressWriter{service: "AUTH", renderer: &ProgressRenderer{}, now: func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}}
+	updates := countProgressUpdates(t, pw, 100*1024*1024)
+
+	if len(updates) < 90 || len(updates) > 102 {
+		t.Errorf("expected about one update per percent, got %d", len(updates))
+	}
+	if last := updates[len(updates)-1]; last.percent != 100 {
+		t.Errorf("expected the last update to be 100%%, got %f", last.percent)
+	}
+}
+
+func TestProgressWriterUpdatesAtMostEveryInterval(t *testing.T) {
+	now := time.Now()
+	pw := &ProgressWriter{service: "AUTH", renderer: &ProgressRenderer{}, now: func() time.Time { return now }}
+	updates := countProgressUpdates(t, pw, 100*1024*1024)
+
+	// the first, and the finish
+	if len(updates) != 2 || updates[1].percent != 100 {
+		t.Errorf("expected only the first and last updates, got %v", updates)
+	}
+}
+
+func TestProgressWriterWithoutContentLength(t *testing.T) {
+	now := time.Now()
+	pw := &ProgressWriter{service: "AUTH", contentLength: -1, renderer: &ProgressRenderer{updateChan: make(chan Progress, 100)}, now: func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}}
+	chunk := make([]byte, 32*1024)
+	for i := 0; i < 320; i++ {
+		pw.Write(chunk)
+	}
+	if n := len(pw.renderer.updateChan); n != 10 {
+		t.Errorf("expected an update every mb, got %d", n)
+	}
+	if u := <-pw.renderer.updateChan; u.percent != 0 {
+		t.Errorf("expected no percentage without a content length, got %f", u.percent)
+	}
+}
+
+func TestProgressRendererFlushDrawsEverythingSent(t *testing.T) {
+	pr := &ProgressRenderer{}
+	pr.init([]ServiceAndVersion{{service: "AUTH"}})
+	go pr.renderLoop()
+
+	for i := 1; i <= 100; i++ {
+		pr.update("AUTH", float32(i), "Install")
+	}
+	pr.update("AUTH", 100, "Done")
+	pr.flush()
+
+	if state := pr.state["AUTH"]; state.state != "Done" {
+		t.Errorf("expected the last update to have been drawn, got %+v", state)
+	}
+}
@@ -0,0 +1,250 @@
+package servicemanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"sm2/platform"
+)
+
+// Artifactory is often only reachable through a corporate proxy. sm2 works out which proxy to use the
+// first time it talks to artifactory, in this order:
+//
+//	the artifactoryProxy setting - a proxy url, direct, or auto (the default) to carry on looking
+//	HTTPS_PROXY/HTTP_PROXY       - the usual environment variables, NO_PROXY is honoured too
+//	the os proxy settings        - macOS network settings or gnome's, including proxy auto-config (PAC) files
+//
+// PAC files are javascript, which sm2 can't run, so instead it tries a direct connection and then each
+// of the proxies the file mentions until one of them reaches the artifactory ping url.
+// Only artifactory requests are affected, everything else (health checks etc) works as it always has.
+
+type proxyRoute struct {
+	proxy      *url.URL // nil connects directly
+	exceptions []string // hosts that skip the proxy
+	fromEnv    bool     // leave it to the environment variables
+	reason     string   // why this route was chosen
+	failed     bool     // nothing that was tried could reach artifactory
+}
+
+func (r proxyRoute) String() string {
+	if r.fromEnv {
+		return r.reason
+	}
+	if r.proxy == nil {
+		return "direct, " + r.reason
+	}
+	return r.proxy.String() + ", " + r.reason
+}
+
+type proxyResolver struct {
+	config      ServiceManagerConfig
+	setting     string
+	systemProxy func() platform.ProxySettings
+
+	once  sync.Once
+	route proxyRoute
+}
+
+var proxyEnvVars = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"}
+
+// sets up the client to use the proxy for artifactory, leaving a client with its own transport alone
+func (sm *ServiceManager) configureProxy() {
+	sm.proxy = &proxyResolver{
+		config:      sm.Config,
+		setting:     sm.Commands.Settings["artifactoryProxy"],
+		systemProxy: sm.Platform.SystemProxy,
+	}
+	if sm.Client != nil && sm.Client.Transport == nil {
+		sm.Client.Transport = sm.tuneTransport(sm.proxy.transport())
+	}
+}
+
+func (r *proxyResolver) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = r.proxyFor
+	return transport
+}
+
+// used as the transport's Proxy func
+func (r *proxyResolver) proxyFor(req *http.Request) (*url.URL, error) {
+	if !r.isArtifactory(req.URL.Hostname()) {
+		return http.ProxyFromEnvironment(req)
+	}
+	route := r.resolve()
+	if route.fromEnv {
+		return http.ProxyFromEnvironment(req)
+	}
+	if route.proxy == nil || isProxyException(req.URL.Hostname(), route.exceptions) {
+		return nil, nil
+	}
+	return route.proxy, nil
+}
+
+func (r *proxyResolver) isArtifactory(host string) bool {
+	for _, artifactoryUrl := range []string{r.config.ArtifactoryRepoUrl, r.config.ArtifactoryPingUrl} {
+		if u, err := url.Parse(artifactoryUrl); err == nil && u.Hostname() != "" && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// works out the route once, since finding it can mean fetching a PAC file and trying each proxy in it
+func (r *proxyResolver) resolve() proxyRoute {
+	r.once.Do(func() {
+		r.route = r.findRoute()
+	})
+	return r.route
+}
+
+func (r *proxyResolver) findRoute() proxyRoute {
+	switch r.setting {
+	case "direct":
+		return proxyRoute{reason: "set by the artifactoryProxy setting"}
+	case "", "auto":
+	default:
+		if proxy, err := url.Parse(r.setting); err == nil {
+			return proxyRoute{proxy: proxy, reason: "set by the artifactoryProxy setting"}
+		}
+	}
+
+	for _, name := range proxyEnvVars {
+		if value := os.Getenv(name); value != "" {
+			return proxyRoute{fromEnv: true, reason: fmt.Sprintf("%s from the %s environment variable", value, name)}
+		}
+	}
+
+	system := platform.ProxySettings{}
+	if r.systemProxy != nil {
+		system = r.systemProxy()
+	}
+	if system.PacUrl != "" {
+		return r.resolvePac(system)
+	}
+
+	hostPort := system.HttpsProxy
+	if hostPort == "" {
+		hostPort = system.HttpProxy
+	}
+	if hostPort == "" {
+		return proxyRoute{reason: "no proxy is configured"}
+	}
+	return proxyRoute{
+		proxy:      &url.URL{Scheme: "http", Host: hostPort},
+		exceptions: system.Exceptions,
+		reason:     "from " + system.Source,
+	}
+}
+
+func (r *proxyResolver) resolvePac(system platform.ProxySettings) proxyRoute {
+	proxies, err := r.fetchPacProxies(system.PacUrl)
+	if err != nil {
+		return proxyRoute{reason: fmt.Sprintf("the proxy auto-config file %s from %s could not be read: %s", system.PacUrl, system.Source, err), failed: true}
+	}
+
+	if ok, _ := r.reachesArtifactory(nil); ok {
+		return proxyRoute{reason: fmt.Sprintf("artifactory is reachable without the proxies in the proxy auto-config file %s from %s", system.PacUrl, system.Source)}
+	}
+	for _, hostPort := range proxies {
+		proxy := &url.URL{Scheme: "http", Host: hostPort}
+		if ok, _ := r.reachesArtifactory(proxy); ok {
+			return proxyRoute{proxy: proxy, exceptions: system.Exceptions, reason: fmt.Sprintf("from the proxy auto-config file %s from %s", system.PacUrl, system.Source)}
+		}
+	}
+
+	if len(proxies) == 0 {
+		return proxyRoute{reason: fmt.Sprintf("the proxy auto-config file %s from %s doesn't name any proxies", system.PacUrl, system.Source), failed: true}
+	}
+	return proxyRoute{reason: fmt.Sprintf("none of the proxies in the proxy auto-config file %s from %s (%s) could reach artifactory", system.PacUrl, system.Source, strings.Join(proxies, ", ")), failed: true}
+}
+
+func (r *proxyResolver) reachesArtifactory(proxy *url.URL) (bool, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy)
+	return checkVpn(&http.Client{Transport: transport}, r.config)
+}
+
+var pacProxy = regexp.MustCompile(`PROXY\s+([\w.-]+:\d+)`)
+
+// the proxies a PAC file could return, in the order they appear
+func (r *proxyResolver) fetchPacProxies(pacUrl string) ([]string, error) {
+	script, err := r.fetchPac(pacUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := []string{}
+	seen := map[string]bool{}
+	for _, match := range pacProxy.FindAllStringSubmatch(script, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			proxies = append(proxies, match[1])
+		}
+	}
+	return proxies, nil
+}
+
+func (r *proxyResolver) fetchPac(pacUrl string) (string, error) {
+	u, err := url.Parse(pacUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "file" {
+		data, err := os.ReadFile(u.Path)
+		return string(data), err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.TimeoutShort)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", pacUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	// the PAC file is on the local network, going through a proxy to get it would be circular
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// proxy exceptions are hostnames, or wildcards like *.local and .example.com
+func isProxyException(host string, exceptions []string) bool {
+	host = strings.ToLower(host)
+	for _, exception := range exceptions {
+		exception = strings.ToLower(exception)
+		if host == exception {
+			return true
+		}
+		if suffix := strings.TrimPrefix(exception, "*"); strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// explains which route artifactory traffic takes, for -diagnostic
+func checkProxy(proxy *proxyResolver) {
+	if proxy == nil {
+		return
+	}
+	route := proxy.resolve()
+	if route.failed {
+		fmt.Printf("PROXY:\t\t WARN (%s)\n", route)
+	} else {
+		fmt.Printf("PROXY:\t\t OK (%s)\n", route)
+	}
+}
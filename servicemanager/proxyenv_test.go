@@ -0,0 +1,122 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/platform"
+)
+
+func clearProxyEnv(t *testing.T) {
+	for _, name := range proxyEnvVars {
+		t.Setenv(name, "")
+	}
+}
+
+func TestProxyRoute(t *testing.T) {
+	clearProxyEnv(t)
+	config := ServiceManagerConfig{
+		ArtifactoryRepoUrl: "https://artifactory.example.com/releases",
+		ArtifactoryPingUrl: "https://artifactory.example.com/ping",
+	}
+	system := platform.ProxySettings{HttpsProxy: "proxy.example.com:8080", Exceptions: []string{"*.internal"}, Source: "the macOS network settings"}
+	resolver := &proxyResolver{config: config, systemProxy: func() platform.ProxySettings { return system }}
+
+	req := httptest.NewRequest("GET", "https://artifactory.example.com/releases/foo.tgz", nil)
+	proxy, _ := resolver.proxyFor(req)
+	if proxy == nil || proxy.String() != "http://proxy.example.com:8080" {
+		t.Errorf("expected the system proxy, got %v", proxy)
+	}
+	if route := resolver.resolve().String(); route != "http://proxy.example.com:8080, from the macOS network settings" {
+		t.Errorf("unexpected explanation %s", route)
+	}
+
+	// anything else is left alone
+	if proxy, _ := resolver.proxyFor(httptest.NewRequest("GET", "http://localhost:9000/ping/ping", nil)); proxy != nil {
+		t.Errorf("expected health checks to connect directly, got %v", proxy)
+	}
+
+	if !isProxyException("artifactory.corp.internal", system.Exceptions) || isProxyException("artifactory.example.com", system.Exceptions) {
+		t.Errorf("expected *.internal to skip the proxy")
+	}
+
+	// the setting wins over everything else
+	resolver = &proxyResolver{config: config, setting: "direct", systemProxy: func() platform.ProxySettings { return system }}
+	if proxy, _ := resolver.proxyFor(req); proxy != nil {
+		t.Errorf("expected a direct connection, got %v", proxy)
+	}
+
+	// then the environment
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	resolver = &proxyResolver{config: config, systemProxy: func() platform.ProxySettings { return system }}
+	if route := resolver.resolve(); !route.fromEnv || !strings.Contains(route.String(), "HTTPS_PROXY") {
+		t.Errorf("expected the environment to be used, got %+v", route)
+	}
+}
+
+func TestProxyRouteFromPac(t *testing.T) {
+	clearProxyEnv(t)
+
+	// stands in for the corporate proxy, answering the ping on artifactory's behalf
+	proxied := 0
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		w.WriteHeader(200)
+	}))
+	defer proxyServer.Close()
+	proxyHost := strings.TrimPrefix(proxyServer.URL, "http://")
+
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `function FindProxyForURL(url, host) {
+  if (shExpMatch(host, "*.local")) return "DIRECT";
+  if (isInNet(host, "10.0.0.0", "255.0.0.0")) return "PROXY 127.0.0.1:1; DIRECT";
+  return "PROXY %s; PROXY 127.0.0.1:1";
+}`, proxyHost)
+	}))
+	defer pacServer.Close()
+
+	config := ServiceManagerConfig{
+		ArtifactoryPingUrl: "http://artifactory.invalid/ping",
+		TimeoutShort:       time.Second,
+	}
+	system := platform.ProxySettings{PacUrl: pacServer.URL, Source: "the gnome proxy settings"}
+	resolver := &proxyResolver{config: config, systemProxy: func() platform.ProxySettings { return system }}
+
+	proxies, err := resolver.fetchPacProxies(pacServer.URL)
+	if err != nil || strings.Join(proxies, ",") != "127.0.0.1:1,"+proxyHost {
+		t.Errorf("expected the proxies in the PAC file, got %v %v", proxies, err)
+	}
+
+	route := resolver.resolve()
+	if route.failed || route.proxy == nil || route.proxy.Host != proxyHost || proxied == 0 {
+		t.Errorf("expected the proxy that reaches artifactory to be picked, got %+v", route)
+	}
+
+	// nothing works
+	system.PacUrl = pacServer.URL + "/missing"
+	pacServer.Config.Handler = http.NotFoundHandler()
+	resolver = &proxyResolver{config: config, systemProxy: func() platform.ProxySettings { return system }}
+	if route := resolver.resolve(); !route.failed || route.proxy != nil {
+		t.Errorf("expected a failed route, got %+v", route)
+	}
+}
+
+func TestConfigureProxy(t *testing.T) {
+	sm := ServiceManager{Client: &http.Client{}}
+	sm.configureProxy()
+	if sm.Client.Transport == nil || sm.proxy == nil {
+		t.Errorf("expected the client to use the proxy resolver")
+	}
+
+	custom := &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: "custom:1"})}
+	sm = ServiceManager{Client: &http.Client{Transport: custom}}
+	sm.configureProxy()
+	if sm.Client.Transport != custom {
+		t.Errorf("expected a client's own transport to be left alone")
+	}
+}
@@ -0,0 +1,136 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Prints a config for an existing nginx or caddy install that routes to the services that are running,
+// the same way -reverse-proxy would, e.g.
+//
+//	sm2 -export-proxy nginx > /usr/local/etc/nginx/servers/sm2.conf
+//	sm2 -export-proxy caddy -port 8080 > Caddyfile
+
+type proxyExportRoute struct {
+	match   string // a path prefix, or a hostname when host is true
+	host    bool
+	service string
+	port    int
+}
+
+func (sm *ServiceManager) ExportProxyConfig(format string, out io.Writer) error {
+	listenPort := defaultProxyPort
+	if sm.Commands.Port > 0 {
+		listenPort = sm.Commands.Port
+	}
+
+	routes, unroutable := proxyExportRoutes(sm.Services, sm.runningServicePorts())
+	if len(routes) == 0 && len(unroutable) == 0 {
+		return fmt.Errorf("no services are running")
+	}
+
+	switch format {
+	case "nginx":
+		writeNginxConfig(routes, unroutable, listenPort, out)
+	case "caddy":
+		writeCaddyConfig(routes, unroutable, listenPort, out)
+	default:
+		return fmt.Errorf("unknown proxy %s, expected nginx or caddy", format)
+	}
+	return nil
+}
+
+// the routes to the running services, along with any running services that aren't in the config
+func proxyExportRoutes(services Services, ports map[string]int) ([]proxyExportRoute, []string) {
+	routes := []proxyExportRoute{}
+	unroutable := []string{}
+	hosts := map[string]bool{}
+
+	for id, port := range ports {
+		s, ok := services[id]
+		if !ok {
+			unroutable = append(unroutable, fmt.Sprintf("%s (port %d)", id, port))
+			continue
+		}
+		for _, p := range s.ProxyPaths {
+			routes = append(routes, proxyExportRoute{match: strings.TrimSuffix(p, "/"), service: id, port: port})
+		}
+		for _, h := range append([]string{serviceHostname(id)}, s.ProxyHosts...) {
+			if h = strings.ToLower(h); !hosts[h] {
+				hosts[h] = true
+				routes = append(routes, proxyExportRoute{match: h, host: true, service: id, port: port})
+			}
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].match < routes[j].match
+	})
+	sort.Strings(unroutable)
+	return routes, unroutable
+}
+
+func writeUnroutable(unroutable []string, out io.Writer) {
+	if len(unroutable) > 0 {
+		fmt.Fprintln(out, "# running, but no longer in services.json:")
+		for _, s := range unroutable {
+			fmt.Fprintf(out, "#   %s\n", s)
+		}
+	}
+}
+
+func writeNginxConfig(routes []proxyExportRoute, unroutable []string, listenPort int, out io.Writer) {
+	fmt.Fprintln(out, "# generated by sm2 -export-proxy nginx")
+	writeUnroutable(unroutable, out)
+
+	location := func(match string, r proxyExportRoute) {
+		fmt.Fprintf(out, "    location %s {\n", match)
+		fmt.Fprintf(out, "        proxy_pass http://localhost:%d;\n", r.port)
+		fmt.Fprintln(out, "        proxy_set_header Host $http_host;")
+		fmt.Fprintln(out, "        proxy_set_header X-Forwarded-Host $http_host;")
+		fmt.Fprintf(out, "        proxy_set_header X-Origin-Host localhost:%d;\n", r.port)
+		fmt.Fprintf(out, "    }\n")
+	}
+
+	fmt.Fprintf(out, "\nserver {\n    listen %d;\n    server_name localhost;\n\n", listenPort)
+	for _, r := range routes {
+		if !r.host {
+			fmt.Fprintf(out, "    # %s\n", r.service)
+			location("= "+r.match, r)
+			location(r.match+"/", r)
+		}
+	}
+	fmt.Fprintf(out, "\n    location / {\n        proxy_pass http://localhost:%d;\n    }\n}\n", rootServicePort)
+
+	for _, r := range routes {
+		if r.host {
+			fmt.Fprintf(out, "\n# %s\nserver {\n    listen %d;\n    server_name %s;\n\n", r.service, listenPort, r.match)
+			location("/", r)
+			fmt.Fprintln(out, "}")
+		}
+	}
+}
+
+func writeCaddyConfig(routes []proxyExportRoute, unroutable []string, listenPort int, out io.Writer) {
+	fmt.Fprintln(out, "# generated by sm2 -export-proxy caddy")
+	writeUnroutable(unroutable, out)
+
+	// caddy tries the most specific path first, the same as -reverse-proxy
+	fmt.Fprintf(out, "\nhttp://localhost:%d {\n", listenPort)
+	for _, r := range routes {
+		if !r.host {
+			fmt.Fprintf(out, "\t# %s\n", r.service)
+			fmt.Fprintf(out, "\treverse_proxy %s localhost:%d\n", r.match, r.port)
+			fmt.Fprintf(out, "\treverse_proxy %s/* localhost:%d\n", r.match, r.port)
+		}
+	}
+	fmt.Fprintf(out, "\treverse_proxy localhost:%d\n}\n", rootServicePort)
+
+	for _, r := range routes {
+		if r.host {
+			fmt.Fprintf(out, "\n# %s\nhttp://%s:%d {\n\treverse_proxy localhost:%d\n}\n", r.service, r.match, listenPort, r.port)
+		}
+	}
+}
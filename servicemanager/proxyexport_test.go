@@ -0,0 +1,53 @@
+package servicemanager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportProxyConfig(t *testing.T) {
+	services := Services{
+		"FRONTEND": {Id: "FRONTEND", DefaultPort: 9000, ProxyPaths: []string{"/account/"}, ProxyHosts: []string{"Account.localhost"}},
+		"API":      {Id: "API", DefaultPort: 9001},
+	}
+	routes, unroutable := proxyExportRoutes(services, map[string]int{"FRONTEND": 41234, "API": 9001, "REMOVED": 9002})
+
+	matches := []string{}
+	for _, r := range routes {
+		matches = append(matches, r.match)
+	}
+	if strings.Join(matches, " ") != "/account account.localhost api.localhost frontend.localhost" || routes[0].port != 41234 {
+		t.Errorf("expected path and host routes to the running ports, got %+v", routes)
+	}
+	if len(unroutable) != 1 || unroutable[0] != "REMOVED (port 9002)" {
+		t.Errorf("expected REMOVED to be listed as unroutable, got %v", unroutable)
+	}
+
+	nginx := bytes.Buffer{}
+	writeNginxConfig(routes, unroutable, 8080, &nginx)
+	for _, expected := range []string{
+		"listen 8080;",
+		"location = /account {\n        proxy_pass http://localhost:41234;",
+		"location /account/ {\n        proxy_pass http://localhost:41234;",
+		"server_name account.localhost;",
+		"server_name api.localhost;",
+		"#   REMOVED (port 9002)",
+	} {
+		if !strings.Contains(nginx.String(), expected) {
+			t.Errorf("expected nginx config to contain %q, got:\n%s", expected, nginx.String())
+		}
+	}
+
+	caddy := bytes.Buffer{}
+	writeCaddyConfig(routes, unroutable, 8080, &caddy)
+	for _, expected := range []string{
+		"http://localhost:8080 {",
+		"reverse_proxy /account/* localhost:41234",
+		"http://account.localhost:8080 {\n\treverse_proxy localhost:41234\n}",
+	} {
+		if !strings.Contains(caddy.String(), expected) {
+			t.Errorf("expected caddy config to contain %q, got:\n%s", expected, caddy.String())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Workspaces are often in a home dir with a space or accents in it (/Users/Zoë Smith/...), and artifacts can
+// contain any file names, so paths mustn't be split up anywhere they're passed along. Processes are started
+// with their args as they are, no shell involved, but a few things do parse a command line: the shell a
+// command from -why-failed is pasted into, the shell ssh runs -remote's commands with, and sbt reading the args
+// to start a service from source with. Args are quoted for each of them here.
+
+// the characters that mean something to a posix shell
+const shellSpecial = " \t\n\"'\\$`&|;<>()*?[]{}#~!"
+
+// quotes an arg, if it needs it, so a posix shell reads it as it is. Single quotes are used as nothing inside
+// them is special, except a single quote
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, shellSpecial) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// quotes an arg, if it needs it, for sbt's command line. sbt only understands double quotes, with \ escapes
+func sbtQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(arg) + `"`
+}
+
+// splits a command line on whitespace, except where it's quoted (with ' or ") or escaped with \, the
+// opposite of shellQuote. Used for args that are typed in as one string
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	current := strings.Builder{}
+	inArg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c in %s", quote, line)
+	}
+	if escaped {
+		return nil, fmt.Errorf("%s ends with a \\", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
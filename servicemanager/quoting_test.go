@@ -0,0 +1,83 @@
+package servicemanager
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+var awkwardArgs = []string{
+	"-Duser.home=/Users/Zoë Smith/.sm2/install/..",
+	"-Dmsg=it's \"quoted\"",
+	"-Dprice=$5 & `free`",
+	"-Dempty=",
+	"",
+	"-Dtabs=a\tb",
+	"-Dhome=~/日本語 dir/*.conf",
+	"plain",
+}
+
+func TestShellQuoteIsReadBackByAShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+
+	quoted := []string{}
+	for _, arg := range awkwardArgs {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	// prints each arg as the shell read them
+	out, err := exec.Command(sh, "-c", "set -- "+strings.Join(quoted, " ")+`; for a in "$@"; do printf '[%s]\n' "$a"; done`).Output()
+	AssertNotErr(t, err)
+	expected := ""
+	for _, arg := range awkwardArgs {
+		expected += "[" + arg + "]\n"
+	}
+	if string(out) != expected {
+		t.Errorf("expected the shell to read\n%s\ngot\n%s", expected, out)
+	}
+
+	if shellQuote("-Dfoo=bar") != "-Dfoo=bar" || shellQuote("/Users/zoë/bin/auth") != "/Users/zoë/bin/auth" {
+		t.Errorf("expected args that don't need quoting to be left alone")
+	}
+}
+
+func TestSbtQuote(t *testing.T) {
+	if q := sbtQuote("-Duser.home=/Users/Zoë Smith/.."); q != `"-Duser.home=/Users/Zoë Smith/.."` {
+		t.Errorf("unexpected %s", q)
+	}
+	if q := sbtQuote(`-Dmsg=say "hi" C:\`); q != `"-Dmsg=say \"hi\" C:\\"` {
+		t.Errorf("unexpected %s", q)
+	}
+	if q := sbtQuote("-Dhttp.port=9000"); q != "-Dhttp.port=9000" {
+		t.Errorf("unexpected %s", q)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`-J-Xmx256m  -Dmsg="hello world" -Dname='Zoë'\''s' -Dpath=/a\ b ""`)
+	AssertNotErr(t, err)
+	expected := []string{"-J-Xmx256m", "-Dmsg=hello world", "-Dname=Zoë's", "-Dpath=/a b", ""}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+
+	// the opposite of shellQuote
+	quoted := []string{}
+	for _, arg := range awkwardArgs {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	args, err = splitArgs(strings.Join(quoted, " "))
+	AssertNotErr(t, err)
+	if !reflect.DeepEqual(args, awkwardArgs) {
+		t.Errorf("expected %q, got %q", awkwardArgs, args)
+	}
+
+	if _, err := splitArgs(`-Dmsg="hello`); err == nil {
+		t.Errorf("expected an unterminated quote to be an error")
+	}
+}
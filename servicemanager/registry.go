@@ -0,0 +1,141 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// For stacks that find each other through service discovery, the serviceRegistry setting registers services
+// with a local Consul agent or etcd when they start, and removes them when they're stopped, e.g.
+//
+//	sm2 -config-set serviceRegistry=consul://127.0.0.1:8500
+//	sm2 -config-set serviceRegistry=etcd://127.0.0.1:2379/services
+//
+// Services are registered by their id in lower case with dashes, e.g. PAYMENTS_FRONTEND as
+// payments-frontend. Consul is given the service's health check to run. In etcd it's a key under the
+// path in the url (/services by default) holding the service's name, address, port and health check as json.
+
+type registration struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Health  string `json:"health"`
+}
+
+func registrationName(serviceId string) string {
+	return strings.ToLower(strings.ReplaceAll(serviceId, "_", "-"))
+}
+
+// registers a service that's been started, using its state file
+func (sm *ServiceManager) register(serviceId string) error {
+	if sm.Config.ServiceRegistry == "" {
+		return nil
+	}
+	installDir, err := sm.findInstallDirOfService(serviceId)
+	if err != nil {
+		return err
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(stateAddress(state))
+	reg := registration{Name: registrationName(serviceId), Address: host, Port: state.Port, Health: state.HealthcheckUrl}
+
+	registry, _ := url.Parse(sm.Config.ServiceRegistry)
+	if registry.Scheme == "consul" {
+		err = sm.registerWithConsul(registry.Host, reg)
+	} else {
+		err = sm.registerWithEtcd(registry.Host, etcdKey(registry, reg.Name), reg)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to register %s with %s: %s", serviceId, registry.Scheme, err)
+	}
+	return nil
+}
+
+func (sm *ServiceManager) deregister(serviceId string) error {
+	if sm.Config.ServiceRegistry == "" {
+		return nil
+	}
+	name := registrationName(serviceId)
+	registry, _ := url.Parse(sm.Config.ServiceRegistry)
+	if registry.Scheme == "consul" {
+		return sm.registryRequest("PUT", "http://"+registry.Host+"/v1/agent/service/deregister/"+url.PathEscape(name), nil)
+	}
+	return sm.registryRequest("POST", "http://"+registry.Host+"/v3/kv/deleterange", map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(etcdKey(registry, name))),
+	})
+}
+
+func (sm *ServiceManager) registerWithConsul(agent string, reg registration) error {
+	check := map[string]string{
+		"Interval":                       "10s",
+		"DeregisterCriticalServiceAfter": "10m",
+	}
+	if strings.HasPrefix(reg.Health, "tcp://") {
+		check["TCP"] = strings.TrimPrefix(reg.Health, "tcp://")
+	} else {
+		check["HTTP"] = reg.Health
+	}
+	return sm.registryRequest("PUT", "http://"+agent+"/v1/agent/service/register", map[string]interface{}{
+		"ID":      reg.Name,
+		"Name":    reg.Name,
+		"Address": reg.Address,
+		"Port":    reg.Port,
+		"Tags":    []string{"sm2"},
+		"Check":   check,
+	})
+}
+
+// uses etcd's json gateway, where keys and values are base64 encoded
+func (sm *ServiceManager) registerWithEtcd(endpoint string, key string, reg registration) error {
+	value, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return sm.registryRequest("POST", "http://"+endpoint+"/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+	})
+}
+
+func etcdKey(registry *url.URL, name string) string {
+	prefix := strings.TrimSuffix(registry.Path, "/")
+	if prefix == "" {
+		prefix = "/services"
+	}
+	return prefix + "/" + name
+}
+
+func (sm *ServiceManager) registryRequest(method string, u string, body interface{}) error {
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := sm.NewShortContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sm.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %s", method, u, resp.Status)
+	}
+	return nil
+}
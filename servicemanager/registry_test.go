@@ -0,0 +1,95 @@
+package servicemanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+type registryRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+func fakeRegistry(t *testing.T) (*httptest.Server, *[]registryRequest) {
+	requests := []registryRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, registryRequest{r.Method, r.URL.Path, body})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func registryServiceManager(registry string) *ServiceManager {
+	return &ServiceManager{
+		Client:   http.DefaultClient,
+		Config:   ServiceManagerConfig{ServiceRegistry: registry},
+		Services: Services{"PAYMENTS_FRONTEND": {Id: "PAYMENTS_FRONTEND"}},
+		Ledger: ledger.Ledger{LoadStateFile: func(string) (ledger.StateFile, error) {
+			return ledger.StateFile{Service: "PAYMENTS_FRONTEND", Port: 9050, BindAddress: "127.0.0.1", HealthcheckUrl: "http://localhost:9050/ping/ping"}, nil
+		}},
+	}
+}
+
+func TestRegisterWithConsul(t *testing.T) {
+	server, requests := fakeRegistry(t)
+	sm := registryServiceManager("consul://" + strings.TrimPrefix(server.URL, "http://"))
+
+	AssertNotErr(t, sm.register("PAYMENTS_FRONTEND"))
+	AssertNotErr(t, sm.deregister("PAYMENTS_FRONTEND"))
+
+	if len(*requests) != 2 {
+		t.Fatalf("expected a register and deregister, got %v", *requests)
+	}
+	register := (*requests)[0]
+	if register.method != "PUT" || register.path != "/v1/agent/service/register" || register.body["Name"] != "payments-frontend" || register.body["Port"] != 9050.0 || register.body["Address"] != "localhost" {
+		t.Errorf("unexpected registration %+v", register)
+	}
+	if check := register.body["Check"].(map[string]interface{}); check["HTTP"] != "http://localhost:9050/ping/ping" {
+		t.Errorf("expected consul to check the service's health, got %v", check)
+	}
+	if deregister := (*requests)[1]; deregister.method != "PUT" || deregister.path != "/v1/agent/service/deregister/payments-frontend" {
+		t.Errorf("unexpected deregistration %+v", deregister)
+	}
+}
+
+func TestRegisterWithEtcd(t *testing.T) {
+	server, requests := fakeRegistry(t)
+	sm := registryServiceManager("etcd://" + strings.TrimPrefix(server.URL, "http://") + "/local/services/")
+
+	AssertNotErr(t, sm.register("PAYMENTS_FRONTEND"))
+	AssertNotErr(t, sm.deregister("PAYMENTS_FRONTEND"))
+
+	decode := func(v interface{}) string {
+		data, _ := base64.StdEncoding.DecodeString(v.(string))
+		return string(data)
+	}
+	put := (*requests)[0]
+	if put.path != "/v3/kv/put" || decode(put.body["key"]) != "/local/services/payments-frontend" {
+		t.Errorf("unexpected put %+v", put)
+	}
+	reg := registration{}
+	AssertNotErr(t, json.Unmarshal([]byte(decode(put.body["value"])), &reg))
+	if reg.Port != 9050 || reg.Address != "localhost" || reg.Health != "http://localhost:9050/ping/ping" {
+		t.Errorf("unexpected registration %+v", reg)
+	}
+	if del := (*requests)[1]; del.path != "/v3/kv/deleterange" || decode(del.body["key"]) != "/local/services/payments-frontend" {
+		t.Errorf("unexpected delete %+v", del)
+	}
+}
+
+func TestNoRegistry(t *testing.T) {
+	sm := registryServiceManager("")
+	sm.Client = nil
+	AssertNotErr(t, sm.register("PAYMENTS_FRONTEND"))
+	AssertNotErr(t, sm.deregister("PAYMENTS_FRONTEND"))
+}
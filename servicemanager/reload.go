@@ -0,0 +1,128 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Keeps track of the config files so the daemon can reload them when they change, without needing
+// to be restarted to pick up new services.
+type configWatcher struct {
+	version  string          // the size and modified time of every config file
+	problems map[string]bool // validation problems in the config that's currently loaded
+}
+
+func (sm *ServiceManager) newConfigWatcher() *configWatcher {
+	w := &configWatcher{version: configVersion(sm.configFiles()), problems: map[string]bool{}}
+	if problems, err := sm.findConfigProblems(); err == nil {
+		for _, p := range problems {
+			w.problems[p.String()] = true
+		}
+	}
+	return w
+}
+
+// every file the services and profiles are loaded from, including ones that don't exist yet
+func (sm *ServiceManager) configFiles() []string {
+	files := []string{path.Join(sm.Config.ConfigDir, "config.json")}
+	for _, name := range []string{"services", "profiles", "overlays", "templates"} {
+		for _, ext := range []string{".json", ".yaml", ".yml"} {
+			files = append(files, path.Join(sm.Config.ConfigDir, name+ext))
+			for _, dir := range sm.Config.IncludeDirs {
+				files = append(files, path.Join(dir, name+ext))
+			}
+		}
+	}
+	cwd, _ := os.Getwd()
+	for _, ext := range []string{".json", ".yaml", ".yml"} {
+		files = append(files, path.Join(sm.Config.WorkspaceDir, workspaceOverridesFile+ext))
+		files = append(files, path.Join(cwd, DEFAULT_WORKSPACE, projectOverridesFile+ext))
+	}
+	return files
+}
+
+func configVersion(files []string) string {
+	version := strings.Builder{}
+	for _, file := range files {
+		if stat, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&version, "%s %d %d\n", file, stat.Size(), stat.ModTime().UnixNano())
+		}
+	}
+	return version.String()
+}
+
+// Reloads the services and profiles if any of the config files have changed. If the new config fails
+// to load, or has problems the current one doesn't, the current one is kept and the problems printed.
+func (sm *ServiceManager) reloadIfChanged(w *configWatcher, out io.Writer) {
+	version := configVersion(sm.configFiles())
+	if version == w.version {
+		return
+	}
+	w.version = version
+
+	problems, err := sm.reloadConfig(w.problems)
+	if err != nil {
+		fmt.Fprintf(out, "Config has changed but couldn't be reloaded, still using the previous config:\n  %s\n", strings.TrimSpace(err.Error()))
+		return
+	}
+	w.problems = problems
+	fmt.Fprintf(out, "Reloaded config, %d services and %d profiles.\n", len(sm.Services), len(sm.Profiles))
+}
+
+// loads the config into a copy of sm, which replaces the current services and profiles if its valid
+func (sm *ServiceManager) reloadConfig(knownProblems map[string]bool) (map[string]bool, error) {
+	includeDirs, err := loadIncludes(path.Join(sm.Config.ConfigDir, "config.json"), sm.Config.ConfigDir)
+	if err != nil {
+		return nil, err
+	}
+	overrideFiles, err := findOverrideFiles(sm.Config.WorkspaceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *sm
+	next.Config.IncludeDirs = includeDirs
+	next.Config.OverrideFiles = overrideFiles
+
+	found, err := next.findConfigProblems()
+	if err != nil {
+		return nil, err
+	}
+	problems := map[string]bool{}
+	newProblems := []string{}
+	for _, p := range found {
+		problems[p.String()] = true
+		if !knownProblems[p.String()] {
+			newProblems = append(newProblems, p.String())
+		}
+	}
+	if len(newProblems) > 0 {
+		return nil, fmt.Errorf("found %d new problem(s):\n  %s", len(newProblems), strings.Join(newProblems, "\n  "))
+	}
+
+	if err := next.loadDefinitions(); err != nil {
+		return nil, err
+	}
+	if next.Commands.Overlay != "" {
+		// the overlay's args were added when sm2 started, so only its changes to services are applied again
+		next.Commands.ExtraArgs = map[string][]string{}
+		if err := next.applyOverlays(next.Commands.Overlay); err != nil {
+			return nil, err
+		}
+	}
+	// as is the profile from -profile-url, which isn't in the config
+	if next.Commands.ProfileUrl != "" {
+		if err := next.loadProfileUrl(next.Commands.ProfileUrl); err != nil {
+			return nil, err
+		}
+	}
+
+	sm.Config.IncludeDirs = includeDirs
+	sm.Config.OverrideFiles = overrideFiles
+	sm.Services = next.Services
+	sm.Profiles = next.Profiles
+	return problems, nil
+}
@@ -0,0 +1,99 @@
+package servicemanager
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestReloadIfChanged(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-reload*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	servicesFile := path.Join(configDir, "services.json")
+	writeServices := func(content string, age time.Duration) {
+		os.WriteFile(servicesFile, []byte(content), 0644)
+		modified := time.Now().Add(-age)
+		os.Chtimes(servicesFile, modified, modified)
+	}
+
+	auth := `"AUTH": {"name": "auth", "defaultPort": 8500, "binary": {"artifact": "auth", "groupId": "uk.gov.hmrc", "cmd": ["./auth/bin/auth"]}}`
+	writeServices(`{`+auth+`}`, time.Hour)
+	os.WriteFile(path.Join(configDir, "profiles.json"), []byte(`{"ALL": ["AUTH"]}`), 0644)
+
+	sm := ServiceManager{Config: ServiceManagerConfig{ConfigDir: configDir, WorkspaceDir: configDir}}
+	AssertNotErr(t, sm.loadDefinitions())
+	watcher := sm.newConfigWatcher()
+	out := bytes.Buffer{}
+
+	// nothing changed
+	sm.reloadIfChanged(watcher, &out)
+	if out.Len() != 0 {
+		t.Errorf("expected nothing to happen, got %s", out.String())
+	}
+
+	// a new service is picked up
+	users := `"USERS": {"name": "users", "defaultPort": 8600, "binary": {"artifact": "users", "groupId": "uk.gov.hmrc", "cmd": ["./users/bin/users"]}}`
+	writeServices(`{`+auth+`,`+users+`}`, 30*time.Minute)
+	sm.reloadIfChanged(watcher, &out)
+	if _, ok := sm.Services["USERS"]; !ok {
+		t.Errorf("expected USERS to be loaded, got %s", out.String())
+	}
+
+	// a typo is rejected and the previous config kept
+	out.Reset()
+	writeServices(`{`+auth+`,`+users+`, "PAY": {"name": "pay", "defaultPrt": 8700}}`, 20*time.Minute)
+	sm.reloadIfChanged(watcher, &out)
+	if _, ok := sm.Services["PAY"]; ok {
+		t.Errorf("expected the invalid config not to be loaded")
+	}
+	if !strings.Contains(out.String(), "PAY.defaultPrt: unknown field") {
+		t.Errorf("expected the problem to be reported, got %s", out.String())
+	}
+
+	// as is config that doesn't load at all
+	out.Reset()
+	writeServices(`{`, 10*time.Minute)
+	sm.reloadIfChanged(watcher, &out)
+	if len(sm.Services) != 2+len(infraTypes) || !strings.Contains(out.String(), "still using the previous config") {
+		t.Errorf("expected the previous config to be kept, got %s", out.String())
+	}
+}
+
+func TestReloadKeepsTheProfileFromAUrl(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"TEAM": ["AUTH"]}`))
+	}))
+	defer svr.Close()
+
+	configDir := t.TempDir()
+	auth := `"AUTH": {"name": "auth", "defaultPort": 8500, "binary": {"artifact": "auth", "groupId": "uk.gov.hmrc", "cmd": ["./auth/bin/auth"]}}`
+	AssertNotErr(t, os.WriteFile(path.Join(configDir, "services.json"), []byte(`{`+auth+`}`), 0644))
+	AssertNotErr(t, os.WriteFile(path.Join(configDir, "profiles.json"), []byte(`{"ALL": ["AUTH"]}`), 0644))
+
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{ConfigDir: configDir, WorkspaceDir: configDir, TimeoutShort: 4 * time.Second},
+	}
+	sm.Commands.ProfileUrl = svr.URL + "/team.json"
+	AssertNotErr(t, sm.loadDefinitions())
+	AssertNotErr(t, sm.loadProfileUrl(sm.Commands.ProfileUrl))
+
+	AssertNotErr(t, os.WriteFile(path.Join(configDir, "profiles.json"), []byte(`{"ALL": ["AUTH"], "NEW": ["AUTH"]}`), 0644))
+	_, err := sm.reloadConfig(map[string]bool{})
+	AssertNotErr(t, err)
+	if _, ok := sm.Profiles["NEW"]; !ok {
+		t.Errorf("expected the new profile to be loaded, got %v", sm.Profiles)
+	}
+	if _, ok := sm.Profiles["TEAM"]; !ok {
+		t.Errorf("expected the profile from the url to be kept, got %v", sm.Profiles)
+	}
+}
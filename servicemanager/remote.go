@@ -0,0 +1,330 @@
+package servicemanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// With -remote the cli sends commands to an sm2 daemon running somewhere else, e.g. in a devcontainer or
+// a cloud workstation, so the services run there while you keep using sm2 on your laptop:
+//
+//	sm2 -remote ssh://me@devbox -start MY_PROFILE
+//	sm2 -remote http://localhost:8999 -s
+//
+// The daemon has to be serving its api (sm2 -daemon -api). Over ssh, the token and port are read from the
+// daemon's api.json (in ~/.sm2, or the workspace in the url's path, e.g. ssh://me@devbox/home/me/work), and
+// the api is reached through a unix socket forwarded by ssh. Over http, e.g. a port forwarded by the
+// devcontainer, the token is taken from $SM2_API_TOKEN, which the daemon uses too when it's set.
+//
+// -start, -stop, -restart, -stop-all, -status and -logs are run remotely, anything else runs locally as usual.
+// remote can be set as a default (sm2 -config-set remote=ssh://me@devbox), and -remote= runs them locally again.
+
+const remoteTunnelTimeout = 10 * time.Second
+
+type remoteDaemon struct {
+	client *http.Client
+	url    string // the api, e.g. http://localhost:8999/api
+	token  string
+	close  func()
+}
+
+// true if the command is one that -remote runs on the daemon
+func (sm *ServiceManager) IsRemoteCommand() bool {
+	c := sm.Commands
+	return c.Remote != "" && (c.Start || c.Stop || c.Restart || c.StopAll || c.Status || c.StatusShort || c.Logs != "")
+}
+
+func (sm *ServiceManager) RunRemote(out io.Writer) error {
+	remote, err := connectRemote(sm.Commands.Remote)
+	if err != nil {
+		return err
+	}
+	defer remote.close()
+
+	switch {
+	case (sm.Commands.Status || sm.Commands.StatusShort) && sm.Commands.Watch:
+		// the daemon only checks the health of services that have changed
+		for {
+			fmt.Fprint(out, "\033[H\033[2J")
+			if err := remote.printStatus(sm, out); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "\nEvery %s on %s, press Ctrl-C to stop\n", watchInterval, sm.Commands.Remote)
+			time.Sleep(watchInterval)
+		}
+	case sm.Commands.Status || sm.Commands.StatusShort:
+		return remote.printStatus(sm, out)
+	case sm.Commands.Logs != "":
+		logs, err := remote.get("/services/" + url.PathEscape(sm.Commands.Logs) + "/logs")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(logs)
+		return err
+	case sm.Commands.StopAll:
+		services, err := remote.services()
+		if err != nil {
+			return err
+		}
+		for _, s := range services {
+			if err := remote.change("stop", ServiceAndVersion{service: s.Service}, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	action := "start"
+	if sm.Commands.Stop {
+		action = "stop"
+	} else if sm.Commands.Restart {
+		action = "restart"
+	}
+	services, err := remote.expand(sm.Commands.ExtraServices, sm.Commands.Release)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, sv := range services {
+		if err := remote.change(action, sv, out); err != nil {
+			fmt.Fprintln(out, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services failed to %s on %s", failed, len(services), action, sm.Commands.Remote)
+	}
+	return nil
+}
+
+func connectRemote(remote string) (*remoteDaemon, error) {
+	u, err := url.Parse(remote)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%s isn't a remote sm2 can use, it should be like ssh://me@devbox or http://localhost:8999", remote)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &remoteDaemon{
+			client: &http.Client{Timeout: 30 * time.Minute},
+			url:    strings.TrimSuffix(strings.TrimSuffix(remote, "/"), "/api") + "/api",
+			token:  os.Getenv("SM2_API_TOKEN"),
+			close:  func() {},
+		}, nil
+	case "ssh":
+		return connectSsh(u)
+	}
+	return nil, fmt.Errorf("%s isn't a remote sm2 can use, only ssh://, http:// and https:// are supported", remote)
+}
+
+// reads the daemon's api.json over ssh, then forwards a local unix socket to the api's port
+func connectSsh(u *url.URL) (*remoteDaemon, error) {
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+	sshArgs := func(args ...string) []string {
+		if u.Port() != "" {
+			args = append(args, "-p", u.Port())
+		}
+		return append(args, dest)
+	}
+
+	stateFile := path.Join(remoteWorkspace(u.Path), "install", apiStateFile)
+	// ssh runs the command with the remote user's shell
+	output, err := exec.Command("ssh", append(sshArgs(), "cat", shellQuote(stateFile))...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s on %s, is the daemon running with -api? %s", stateFile, dest, err)
+	}
+	state := struct {
+		Token string `json:"token"`
+		Url   string `json:"url"`
+	}{}
+	if err := json.Unmarshal(output, &state); err != nil {
+		return nil, fmt.Errorf("%s on %s isn't valid: %s", stateFile, dest, err)
+	}
+	api, err := url.Parse(state.Url)
+	if err != nil || state.Url == "" {
+		return nil, fmt.Errorf("the daemon on %s isn't serving the rest api, start it with sm2 -daemon -api", dest)
+	}
+
+	dir, err := os.MkdirTemp("", "sm2-remote-")
+	if err != nil {
+		return nil, err
+	}
+	socket := path.Join(dir, "api.sock")
+	tunnel := exec.Command("ssh", sshArgs("-N", "-o", "ExitOnForwardFailure=yes", "-L", socket+":127.0.0.1:"+api.Port())...)
+	tunnel.Stderr = os.Stderr
+	if err := tunnel.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- tunnel.Wait() }()
+	closeTunnel := func() {
+		tunnel.Process.Kill()
+		<-exited
+		os.RemoveAll(dir)
+	}
+
+	deadline := time.Now().Add(remoteTunnelTimeout)
+	for !Exists(socket) {
+		select {
+		case err := <-exited:
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("ssh to %s stopped before the api was forwarded: %v", dest, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			closeTunnel()
+			return nil, fmt.Errorf("timed out forwarding the api from %s", dest)
+		}
+	}
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}
+	return &remoteDaemon{
+		// the api only answers requests for localhost
+		client: &http.Client{Timeout: 30 * time.Minute, Transport: &http.Transport{DialContext: dial}},
+		url:    "http://localhost/api",
+		token:  state.Token,
+		close:  closeTunnel,
+	}, nil
+}
+
+// the workspace in an ssh url's path, relative to the remote home dir unless it's absolute.
+// ssh://devbox/~/work and ssh://devbox/work are both ~/work.
+func remoteWorkspace(urlPath string) string {
+	switch {
+	case urlPath == "" || urlPath == "/":
+		return ".sm2"
+	case strings.HasPrefix(urlPath, "/~/"):
+		return strings.TrimPrefix(urlPath, "/~/")
+	case strings.Count(urlPath, "/") == 1:
+		return strings.TrimPrefix(urlPath, "/")
+	}
+	return urlPath
+}
+
+func (r *remoteDaemon) request(method string, endpoint string) ([]byte, error) {
+	req, err := http.NewRequest(method, r.url+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s", apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s %s failed with status %s", method, r.url+endpoint, resp.Status)
+	}
+	return body, nil
+}
+
+func (r *remoteDaemon) get(endpoint string) ([]byte, error) {
+	return r.request(http.MethodGet, endpoint)
+}
+
+func (r *remoteDaemon) services() ([]apiService, error) {
+	body, err := r.get("/services")
+	if err != nil {
+		return nil, err
+	}
+	services := []apiService{}
+	return services, json.Unmarshal(body, &services)
+}
+
+// expands profiles using the daemon's config, since there may not be any config here
+func (r *remoteDaemon) expand(names []string, release string) ([]ServiceAndVersion, error) {
+	services := []ServiceAndVersion{}
+	for i, name := range names {
+		if body, err := r.get("/profiles/" + url.PathEscape(name)); err == nil {
+			profile := struct {
+				Services []string `json:"services"`
+			}{}
+			if err := json.Unmarshal(body, &profile); err != nil {
+				return nil, err
+			}
+			for _, id := range profile.Services {
+				services = append(services, ServiceAndVersion{service: id})
+			}
+			continue
+		}
+		sv := parseServiceAndVersion(name)
+		if i == 0 && release != "" {
+			sv.version = release
+		}
+		services = append(services, sv)
+	}
+	return services, nil
+}
+
+func (r *remoteDaemon) change(action string, sv ServiceAndVersion, out io.Writer) error {
+	endpoint := "/services/" + url.PathEscape(sv.service) + "/" + action
+	if sv.version != "" {
+		endpoint += "?version=" + url.QueryEscape(sv.version)
+	}
+	body, err := r.request(http.MethodPost, endpoint)
+	if err != nil {
+		return fmt.Errorf("unable to %s %s: %s", action, sv.service, err)
+	}
+	service := apiService{}
+	if err := json.Unmarshal(body, &service); err != nil {
+		return err
+	}
+	if service.Running {
+		fmt.Fprintf(out, "%s %s is running on port %d\n", service.Service, service.Version, service.Port)
+	} else {
+		fmt.Fprintf(out, "%s is stopped\n", service.Service)
+	}
+	return nil
+}
+
+func (r *remoteDaemon) printStatus(sm *ServiceManager, out io.Writer) error {
+	services, err := r.services()
+	if err != nil {
+		return err
+	}
+	statuses := []serviceStatus{}
+	for _, s := range services {
+		statuses = append(statuses, serviceStatus{pid: s.Pid, port: s.Port, service: s.Service, version: s.Version, health: health(s.Health), bindAddress: s.BindAddress})
+	}
+
+	if sm.Commands.Format != "" {
+		return printTemplate(statuses, sm.Commands.Format, out)
+	}
+	termWidth := 0
+	if sm.Platform.GetTerminalSize != nil {
+		termWidth, _ = sm.Platform.GetTerminalSize()
+	}
+	if sm.Commands.FormatPlain || termWidth < 80 {
+		printPlainText(statuses, out)
+		return nil
+	}
+	printTable(statuses, termWidth, getLongestServiceName(statuses), out)
+	return nil
+}
@@ -0,0 +1,127 @@
+package servicemanager
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func fakeRemote(t *testing.T) *ServiceManager {
+	tmp := t.TempDir()
+	logDir := path.Join(tmp, "AUTH", "logs")
+	AssertNotErr(t, os.MkdirAll(logDir, 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(logDir, "stdout.log"), []byte("one\ntwo\n"), 0644))
+
+	daemon := ServiceManager{
+		Client:   &http.Client{Timeout: 100 * time.Millisecond},
+		Config:   ServiceManagerConfig{TmpDir: tmp},
+		Services: Services{"AUTH": {Id: "AUTH", DefaultPort: 1}, "EMAIL": {Id: "EMAIL", DefaultPort: 2}},
+		Profiles: map[string][]string{"AUTH_ALL": {"AUTH", "EMAIL"}},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+				return []ledger.StateFile{{Service: "AUTH", Version: "1.0.0", Port: 1, Pid: 1, Started: time.Now()}}, nil
+			},
+			LoadInstallFile: func(string) (ledger.InstallFile, error) {
+				return ledger.InstallFile{Path: path.Join(tmp, "AUTH")}, nil
+			},
+		},
+		Platform: platform.Platform{
+			PidLookup: func() map[int]int { return map[int]int{1: 1} },
+			Uptime:    func() time.Time { return time.Now().Add(-time.Hour) },
+		},
+	}
+	server := httptest.NewServer(newAdminApi(&daemon, "secret").handler())
+	t.Cleanup(server.Close)
+	t.Setenv("SM2_API_TOKEN", "secret")
+
+	return &ServiceManager{Commands: cli.UserOption{Remote: server.URL, FormatPlain: true}}
+}
+
+func TestRemoteStatusAndLogs(t *testing.T) {
+	sm := fakeRemote(t)
+	sm.Commands.StatusShort = true
+	if !sm.IsRemoteCommand() {
+		t.Fatalf("expected -s to be run remotely")
+	}
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.RunRemote(&out))
+	if !strings.HasPrefix(out.String(), "AUTH\t1.0.0\t1\t1\t") {
+		t.Errorf("expected the daemon's services, got %q", out.String())
+	}
+
+	sm.Commands.StatusShort = false
+	sm.Commands.Logs = "AUTH"
+	out.Reset()
+	AssertNotErr(t, sm.RunRemote(&out))
+	if out.String() != "one\ntwo\n" {
+		t.Errorf("expected the daemon's logs, got %q", out.String())
+	}
+}
+
+func TestRemoteExpandsProfilesOnTheDaemon(t *testing.T) {
+	sm := fakeRemote(t)
+	remote, err := connectRemote(sm.Commands.Remote)
+	AssertNotErr(t, err)
+
+	services, err := remote.expand([]string{"AUTH_ALL", "PAYMENTS:1.2.3"}, "")
+	AssertNotErr(t, err)
+	expected := []ServiceAndVersion{{service: "AUTH"}, {service: "EMAIL"}, {service: "PAYMENTS", version: "1.2.3"}}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+}
+
+func TestRemoteErrors(t *testing.T) {
+	sm := fakeRemote(t)
+	sm.Commands.Start = true
+	sm.Commands.ExtraServices = []string{"PAYMENTS"}
+	out := bytes.Buffer{}
+	if err := sm.RunRemote(&out); err == nil || !strings.Contains(out.String(), "PAYMENTS is not a service") {
+		t.Errorf("expected the daemon's error, got %v %q", err, out.String())
+	}
+
+	t.Setenv("SM2_API_TOKEN", "wrong")
+	sm.Commands.Start, sm.Commands.Status = false, true
+	if err := sm.RunRemote(&out); err == nil || !strings.Contains(err.Error(), "a valid token is needed") {
+		t.Errorf("expected the token to be refused, got %v", err)
+	}
+
+	if _, err := connectRemote("devbox"); err == nil {
+		t.Errorf("expected a remote without a scheme to be refused")
+	}
+}
+
+func TestRemoteWorkspace(t *testing.T) {
+	for urlPath, expected := range map[string]string{
+		"":              ".sm2",
+		"/~/work/sm2":   "work/sm2",
+		"/work":         "work",
+		"/home/me/.sm2": "/home/me/.sm2",
+	} {
+		if workspace := remoteWorkspace(urlPath); workspace != expected {
+			t.Errorf("expected %q to be %s, got %s", urlPath, expected, workspace)
+		}
+	}
+}
+
+func TestOnlySomeCommandsAreRemote(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{Remote: "ssh://devbox", List: true}}
+	if sm.IsRemoteCommand() {
+		t.Errorf("expected -list to run locally")
+	}
+	sm.Commands = cli.UserOption{Start: true}
+	if sm.IsRemoteCommand() {
+		t.Errorf("expected nothing to be remote without -remote")
+	}
+}
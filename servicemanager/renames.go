@@ -0,0 +1,55 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+)
+
+// Services can be renamed or retired in services.json without breaking everyone's scripts, e.g.
+//
+//	"OLD_NAME": { "renamedTo": "NEW_NAME" }                     starts NEW_NAME instead, with a warning
+//	"OLD_NAME": { "renamedTo": "NEW_NAME", "deprecated": true } refuses to start, pointing at NEW_NAME
+//	"OLD_NAME": { "deprecated": true }                          refuses to start
+//
+// Renames can be chained, so a service that's been renamed twice still ends up at the latest name.
+
+// follows any renames, returning the id of the service that should actually be used
+func (sm *ServiceManager) resolveRename(id string) string {
+	seen := map[string]bool{}
+	for {
+		service, ok := sm.Services[id]
+		if !ok || service.RenamedTo == "" || service.Deprecated || seen[id] {
+			return id
+		}
+		seen[id] = true
+
+		if _, ok := sm.Services[service.RenamedTo]; !ok {
+			return id
+		}
+		id = service.RenamedTo
+	}
+}
+
+// like resolveRename but warns when the service has been renamed
+func (sm *ServiceManager) renamedService(id string) string {
+	resolved := sm.resolveRename(id)
+	if resolved != id {
+		fmt.Fprintf(os.Stderr, "Warning: %s has been renamed to %s, using %s instead.\n", id, resolved, resolved)
+	}
+	return resolved
+}
+
+// why a service can't be started because its been renamed or deprecated, or nil if it can be
+func retiredServiceError(id string, service Service) error {
+	if service.Deprecated && service.RenamedTo != "" {
+		return fmt.Errorf("%s is deprecated, use %s instead", id, service.RenamedTo)
+	}
+	if service.Deprecated {
+		return fmt.Errorf("%s is deprecated and can no longer be started", id)
+	}
+	if service.RenamedTo != "" {
+		// only happens if the rename couldn't be followed
+		return fmt.Errorf("%s has been renamed to %s, which isn't a service that can be started", id, service.RenamedTo)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package servicemanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+)
+
+func TestRenamedServicesAreMapped(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{
+			"OLDEST":  {RenamedTo: "OLD"},
+			"OLD":     {RenamedTo: "NEW"},
+			"NEW":     {},
+			"RETIRED": {RenamedTo: "NEW", Deprecated: true},
+			"MISSING": {RenamedTo: "NOPE"},
+			"LOOP_A":  {RenamedTo: "LOOP_B"},
+			"LOOP_B":  {RenamedTo: "LOOP_A"},
+		},
+		Profiles: Profiles{"LEGACY": {"OLD", "RETIRED"}},
+		Commands: cli.UserOption{ExtraServices: []string{"OLDEST", "LEGACY"}},
+	}
+
+	tests := map[string]string{
+		"OLDEST":  "NEW",
+		"OLD":     "NEW",
+		"NEW":     "NEW",
+		"RETIRED": "RETIRED",
+		"MISSING": "MISSING",
+		"UNKNOWN": "UNKNOWN",
+	}
+	for id, expected := range tests {
+		if resolved := sm.resolveRename(id); resolved != expected {
+			t.Errorf("expected %s to resolve to %s, got %s", id, expected, resolved)
+		}
+	}
+
+	if resolved := sm.resolveRename("LOOP_A"); resolved != "LOOP_A" && resolved != "LOOP_B" {
+		t.Errorf("expected a rename loop to stop, got %s", resolved)
+	}
+
+	expected := []ServiceAndVersion{{"NEW", "", ""}, {"NEW", "", ""}, {"RETIRED", "", ""}}
+	if services := sm.requestedServicesAndProfiles(); !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected renames to be followed in profiles too, got %v", services)
+	}
+}
+
+func TestRetiredServiceError(t *testing.T) {
+	tests := []struct {
+		service  Service
+		expected string
+	}{
+		{Service{}, ""},
+		{Service{RenamedTo: "NEW", Deprecated: true}, "OLD is deprecated, use NEW instead"},
+		{Service{Deprecated: true}, "OLD is deprecated and can no longer be started"},
+		{Service{RenamedTo: "NOPE"}, "OLD has been renamed to NOPE"},
+	}
+
+	for _, test := range tests {
+		err := retiredServiceError("OLD", test.service)
+		if test.expected == "" && err != nil {
+			t.Errorf("expected no error for %+v, got %s", test.service, err)
+		} else if test.expected != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expected)) {
+			t.Errorf("expected %q for %+v, got %v", test.expected, test.service, err)
+		}
+	}
+}
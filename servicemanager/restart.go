@@ -5,7 +5,7 @@ import (
 )
 
 // restarts a service using the previous configuration
-func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
+func (sm *ServiceManager) Restart(sv ServiceAndVersion) (err error) {
 
 	// verify its a real service
 	service, ok := sm.Services[sv.service]
@@ -14,6 +14,11 @@ func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
 	}
 
 	installDir, _ := sm.findInstallDirOfService(sv.service)
+	defer func() {
+		if err == nil {
+			err = sm.register(sv.service)
+		}
+	}()
 
 	// read state file
 	state, err := sm.Ledger.LoadStateFile(installDir)
@@ -21,6 +26,84 @@ func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
 		return err
 	}
 
+	// stubs carry on being stubs
+	if state.Version == STUB {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startStub(service, installDir, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
+	// containers are started again from the same image
+	if state.Runtime == DockerRuntime {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startContainer(service, installDir, state.Artifact, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
+	// databases etc run from the binary on the path
+	if state.Runtime == NativeRuntime {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startNative(service, installDir, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
+	// and pact stubs get the latest pacts
+	if service.Type == PactType {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startPact(service, installDir, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
+	// stubs are seeded with their mappings again
+	if service.Type == WiremockType {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startWiremock(service, installDir, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
+	// tunnels aren't installed, they're just reopened
+	if service.Type == TunnelType {
+		if err := sm.StopService(sv.service); err != nil {
+			return err
+		}
+		fmt.Printf("Restarting %s...\n", sv.service)
+		newstate, err := sm.startTunnel(service, installDir, state.Port, state.HealthcheckUrl)
+		if err != nil {
+			return err
+		}
+		return sm.saveState(installDir, newstate)
+	}
+
 	// read install file
 	install, err := sm.Ledger.LoadInstallFile(installDir)
 	if err != nil {
@@ -28,7 +111,7 @@ func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
 	}
 
 	// check its ok
-	if !verifyInstall(install, state.Service, state.Version, false) {
+	if !verifyInstall(install, state.Service, state.Version, "", false) {
 		return fmt.Errorf("%s %s is not installed", sv.service, sv.version)
 	}
 
@@ -39,12 +122,19 @@ func (sm *ServiceManager) Restart(sv ServiceAndVersion) error {
 	}
 
 	// start a new instance
+	// services started before bind addresses were recorded use the current setting
+	bindAddress := state.BindAddress
+	if bindAddress == "" {
+		bindAddress = sm.bindAddress(service)
+	}
 	fmt.Printf("Restarting %s...\n", sv.service)
-	newstate, err := run(service, install, state.Args, state.Port)
+	newstate, err := run(service, install, state.Args, state.Port, bindAddress, sm.lookupSecret)
 	if err != nil {
 		return err
 	}
+	// the debugger arg is in the args, so it's listening on the same port again
+	newstate.DebugPort = state.DebugPort
 
 	// save the new pid
-	return sm.Ledger.SaveStateFile(installDir, newstate)
+	return sm.saveState(installDir, newstate)
 }
@@ -1,56 +1,101 @@
 package servicemanager
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"sm2/ledger"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	defaultProxyPort = 3000
+	rootServicePort  = 9017 // anything that doesn't match a service goes here
+)
+
 func (sm *ServiceManager) StartProxy() {
 
-	rootServicePort := 9017
-	proxyPort := 3000
+	proxyPort := defaultProxyPort
 
 	if sm.Commands.Port > 0 {
 		proxyPort = sm.Commands.Port
 	}
 
-	var routes map[string]string
-
+	services := sm.Services
 	requestedServices := sm.requestedServicesAndProfiles()
 	if len(requestedServices) > 0 {
-		definedServices := map[string]Service{}
+		services = map[string]Service{}
 		for _, v := range requestedServices {
 			if s, ok := sm.Services[v.service]; ok {
-				definedServices[v.service] = s
+				services[v.service] = s
 			}
 		}
-		routes = buildRoutingTable(definedServices)
-	} else {
-		routes = buildRoutingTable(sm.Services)
+	}
+	routes := buildRoutingTable(services)
+	router := newProxyRouter(services, sm.runningServicePorts)
+	cors, err := parseCorsOrigins(sm.Commands.Cors)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("ReverseProxy: Loaded %d frontend routes\n", len(routes))
-	log.Println("(only services with 'frontend: true' in services.json are addressable)")
+	log.Printf("ReverseProxy: Loaded %d routes\n", len(routes))
+	log.Printf("(services are also available by name, e.g. http://%s:%d)\n", serviceHostname("EXAMPLE_SERVICE"), proxyPort)
+	if cors.enabled() {
+		log.Printf("ReverseProxy: adding cors headers for %s\n", sm.Commands.Cors)
+	}
 
 	state := ledger.ProxyState{Started: time.Now(), Pid: os.Getpid(), ProxyPaths: routes}
 	sm.Ledger.SaveProxyState(sm.Config.TmpDir, state)
 
-	director := func(req *http.Request) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", proxyPort),
+		Handler: sm.proxyHandler(router, cors),
+	}
 
-		pathPrefix := "/" + strings.SplitN(req.URL.Path, "/", 3)[1]
+	if sm.Commands.Https {
+		hosts := []string{}
+		for id, s := range services {
+			hosts = append(append(hosts, serviceHostname(id)), s.ProxyHosts...)
+		}
+		cert, err := sm.proxyCertificate(hosts, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		// http/2 has no Upgrade header, so stick to http/1.1 where websockets are proxied as they are over http
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 
-		if proxyTo, ok := routes[pathPrefix]; ok {
+		log.Printf("ReverseProxy: listening on https port %d...", proxyPort)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+
+	log.Printf("ReverseProxy: listening on port %d...", proxyPort)
+	log.Fatal(server.ListenAndServe())
+}
+
+// Proxies each request to the service it's routed to. Websocket upgrades are passed through and the
+// connection is then copied both ways, and event streams (and anything else without a content length)
+// are flushed as they arrive rather than buffered.
+func (sm *ServiceManager) proxyHandler(router *proxyRouter, cors corsPolicy) http.Handler {
+	director := func(req *http.Request) {
+
+		if proxyTo, ok := router.target(req.Host, req.URL.Path); ok {
 			if sm.Commands.Verbose {
-				log.Print(fmt.Sprintf("%s\t%s  ->  %s\n", req.Method, req.URL.Path, proxyTo))
+				log.Print(fmt.Sprintf("%s\t%s%s  ->  %s\n", req.Method, req.Host, req.URL.Path, proxyTo))
 			}
 			req.Header.Add("X-Forwarded-Host", req.Host)
 			req.Header.Add("X-Origin-Host", proxyTo)
+			if sm.Commands.Https {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			}
 			req.URL.Scheme = "http"
 			req.URL.Host = proxyTo
 		} else {
@@ -62,27 +107,134 @@ func (sm *ServiceManager) StartProxy() {
 		}
 	}
 
-	proxy := &httputil.ReverseProxy{Director: director}
+	proxy := &httputil.ReverseProxy{
+		Director:       director,
+		ModifyResponse: cors.modifyResponse,
+		ErrorHandler:   proxyError,
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/", proxy)
+	mux.Handle("/", cors.handler(proxy))
+	return mux
+}
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", proxyPort),
-		Handler: mux,
+// browsers closing event streams and websockets is normal, anything else means the service is down or broken
+func proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, context.Canceled) || req.Context().Err() != nil {
+		return
 	}
-
-	log.Printf("ReverseProxy: listening on port %d...", proxyPort)
-	log.Fatal(server.ListenAndServe())
+	log.Printf("ReverseProxy: %s %s%s failed: %s", req.Method, req.Host, req.URL.Path, err)
+	w.WriteHeader(http.StatusBadGateway)
 }
 
 func buildRoutingTable(services map[string]Service) map[string]string {
 	routes := map[string]string{}
 	for _, v := range services {
-		for _, path := range v.ProxyPaths {
+		for _, path := range append(append([]string{}, v.ProxyPaths...), v.ProxyHosts...) {
 			routes[path] = fmt.Sprintf("localhost:%d", v.DefaultPort)
 			log.Printf("Setup: routing %s to %s on port %s\n", path, v.Id, fmt.Sprint(v.DefaultPort))
 		}
 	}
 	return routes
 }
+
+// Works out which service a request is for, by its hostname (proxyHosts) or the longest matching path
+// prefix (proxyPaths). Requests go to the port the service is actually running on, so services started
+// after the proxy, or on a dynamic port, are still found.
+type proxyRouter struct {
+	paths        map[string]string // path prefix -> service id
+	hosts        map[string]string // host name -> service id
+	defaultPorts map[string]int
+	runningPorts func() map[string]int
+
+	lock    sync.Mutex
+	ports   map[string]int
+	checked time.Time
+}
+
+// how long the ports of running services are cached for
+const proxyPortsTTL = 2 * time.Second
+
+func newProxyRouter(services map[string]Service, runningPorts func() map[string]int) *proxyRouter {
+	r := &proxyRouter{
+		paths:        map[string]string{},
+		hosts:        map[string]string{},
+		defaultPorts: map[string]int{},
+		runningPorts: runningPorts,
+	}
+	for id, s := range services {
+		for _, p := range s.ProxyPaths {
+			r.paths[strings.TrimSuffix(p, "/")] = id
+		}
+		if _, taken := r.hosts[serviceHostname(id)]; !taken {
+			r.hosts[serviceHostname(id)] = id
+		}
+		r.defaultPorts[id] = s.DefaultPort
+	}
+	// hosts set in the config win over the generated ones
+	for id, s := range services {
+		for _, h := range s.ProxyHosts {
+			r.hosts[strings.ToLower(h)] = id
+		}
+	}
+	return r
+}
+
+// Every service can be reached through the proxy as its id in lower case, with dashes rather than
+// underscores, under .localhost (e.g. AUTH_FRONTEND is auth-frontend.localhost). Browsers, curl and
+// systemd-resolved already resolve anything.localhost to the local machine.
+func serviceHostname(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, "_", "-")) + ".localhost"
+}
+
+// returns the host:port to send a request to, or false if no service matches
+func (r *proxyRouter) target(host string, reqPath string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	id, ok := r.hosts[strings.ToLower(host)]
+	if !ok {
+		longest := -1
+		for prefix, service := range r.paths {
+			matches := reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/")
+			if matches && len(prefix) > longest {
+				id, longest = service, len(prefix)
+			}
+		}
+		if longest < 0 {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("localhost:%d", r.port(id)), true
+}
+
+func (r *proxyRouter) port(id string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if time.Since(r.checked) > proxyPortsTTL {
+		r.ports = r.runningPorts()
+		r.checked = time.Now()
+	}
+	if port, ok := r.ports[id]; ok && port > 0 {
+		return port
+	}
+	return r.defaultPorts[id]
+}
+
+// the ports of the services that were started by sm2, from their state files
+func (sm *ServiceManager) runningServicePorts() map[string]int {
+	ports := map[string]int{}
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		return ports
+	}
+	pids := sm.Platform.PidLookup()
+	for _, state := range states {
+		if _, running := pids[state.Pid]; running {
+			ports[state.Service] = state.Port
+		}
+	}
+	return ports
+}
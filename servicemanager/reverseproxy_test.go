@@ -1,7 +1,17 @@
 package servicemanager
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	. "sm2/testing"
 )
 
 func Test_buildRoutingTable(t *testing.T) {
@@ -14,3 +24,109 @@ func Test_buildRoutingTable(t *testing.T) {
 		t.Errorf("Routes /path2 did not have expected value. Expected value was %s actual value was %s", "localhost:8080", v)
 	}
 }
+
+func TestProxyRouterTarget(t *testing.T) {
+	services := map[string]Service{
+		"FRONTEND": {Id: "FRONTEND", DefaultPort: 9000, ProxyPaths: []string{"/account"}, ProxyHosts: []string{"account.localhost"}},
+		"SETTINGS": {Id: "SETTINGS", DefaultPort: 9001, ProxyPaths: []string{"/account/settings/"}},
+	}
+	checks := 0
+	router := newProxyRouter(services, func() map[string]int {
+		checks++
+		return map[string]int{"SETTINGS": 41234}
+	})
+
+	tests := []struct {
+		host     string
+		path     string
+		expected string
+	}{
+		{"localhost:3000", "/account", "localhost:9000"},
+		{"localhost:3000", "/account/home", "localhost:9000"},
+		{"localhost:3000", "/account/settings/email", "localhost:41234"}, // longest prefix, on the port it's running on
+		{"Account.localhost:3000", "/anything", "localhost:9000"},
+		{"settings.localhost", "/", "localhost:41234"},
+		{"localhost:3000", "/accounts", ""},
+		{"localhost:3000", "/", ""},
+	}
+	for _, test := range tests {
+		target, ok := router.target(test.host, test.path)
+		if target != test.expected || ok != (test.expected != "") {
+			t.Errorf("%s%s: expected %q got %q", test.host, test.path, test.expected, target)
+		}
+	}
+
+	if checks != 1 {
+		t.Errorf("expected the running ports to be cached, they were looked up %d times", checks)
+	}
+}
+
+func TestProxyStreaming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/app/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: hello\n\n")
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+		case "/app/socket":
+			if req.Header.Get("Upgrade") != "websocket" {
+				http.Error(w, "expected an upgrade", http.StatusBadRequest)
+				return
+			}
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			rw.Flush()
+			io.Copy(conn, rw) // echo
+		}
+	}))
+	defer backend.Close()
+	backendPort := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	services := map[string]Service{"APP": {Id: "APP", ProxyPaths: []string{"/app"}}}
+	router := newProxyRouter(services, func() map[string]int { return map[string]int{"APP": backendPort} })
+	sm := ServiceManager{}
+	proxy := httptest.NewServer(sm.proxyHandler(router, corsPolicy{}))
+	defer proxy.Close()
+
+	// the first event arrives while the stream is still open
+	resp, err := http.Get(proxy.URL + "/app/events")
+	AssertNotErr(t, err)
+	events := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		events <- line
+	}()
+	select {
+	case line := <-events:
+		if line != "data: hello\n" {
+			t.Errorf("unexpected event %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the event to be streamed rather than buffered")
+	}
+	resp.Body.Close()
+
+	// websockets are upgraded and then copied both ways
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	AssertNotErr(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(conn, "GET /app/socket HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	AssertNotErr(t, err)
+	if !strings.Contains(status, "101") {
+		t.Fatalf("expected the connection to be upgraded, got %q", status)
+	}
+	// skip the rest of the headers
+	for line := ""; line != "\r\n"; {
+		line, err = reader.ReadString('\n')
+		AssertNotErr(t, err)
+	}
+	fmt.Fprint(conn, "ping\n")
+	if echo, _ := reader.ReadString('\n'); echo != "ping\n" {
+		t.Errorf("expected the message to be echoed through the proxy, got %q", echo)
+	}
+}
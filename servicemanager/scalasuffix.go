@@ -0,0 +1,42 @@
+package servicemanager
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Artifacts and service names can end in a Scala version, e.g. auth_2.13 or AUTH_3, which is swapped for the
+// one to run or stripped off. By default the suffixes recognised are _3 and _2.xx, but for a newer Scala (or
+// an artifact whose name happens to end like one) they can be set with "scalaSuffixes" in config.json, or the
+// scalaSuffixes setting, e.g. 3,2.*, where * is any number. The scalaVersions that are tried for _%% artifacts
+// are always recognised as well, so running one of them by name works.
+
+const defaultScalaSuffixes = `2\.\d{2}|3`
+
+// the suffix of an artifact (or _%% for any), and a service name with an optional suffix and version e.g. AUTH_2.13:1.0.0
+var scalaSuffix, serviceAndVersionRegex = compileScalaSuffixes(defaultScalaSuffixes)
+
+// the alternatives that make up the scala suffix regexes, from the configured suffixes and versions
+func scalaSuffixAlternatives(suffixes []string, versions []string) string {
+	if len(suffixes) == 0 && len(versions) == 0 {
+		return defaultScalaSuffixes
+	}
+	alternatives := []string{}
+	if len(suffixes) == 0 {
+		alternatives = append(alternatives, defaultScalaSuffixes)
+	}
+	for _, s := range append(suffixes, versions...) {
+		alternatives = append(alternatives, strings.ReplaceAll(regexp.QuoteMeta(s), `\*`, `\d+`))
+	}
+	return strings.Join(alternatives, "|")
+}
+
+func compileScalaSuffixes(alternatives string) (*regexp.Regexp, *regexp.Regexp) {
+	return regexp.MustCompile(`_(` + alternatives + `|%%)$`),
+		regexp.MustCompile(`(.*?)(_(` + alternatives + `))?(:(.*))?$`)
+}
+
+// sets the suffixes recognised from the config, they've already been validated when it was loaded
+func configureScalaSuffixes(suffixes []string, versions []string) {
+	scalaSuffix, serviceAndVersionRegex = compileScalaSuffixes(scalaSuffixAlternatives(suffixes, versions))
+}
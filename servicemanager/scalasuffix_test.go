@@ -0,0 +1,60 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestConfigureScalaSuffixes(t *testing.T) {
+	defer configureScalaSuffixes(nil, nil)
+
+	// a newer scala that's being tried is recognised along with the defaults
+	configureScalaSuffixes(nil, []string{"4", "3"})
+	if sv := parseServiceAndVersion("AUTH_4:1.0.0"); sv.service != "AUTH" || sv.scalaVersion != "4" || sv.version != "1.0.0" {
+		t.Errorf("expected AUTH_4 to be the scala 4 AUTH, got %#v", sv)
+	}
+	if sv := parseServiceAndVersion("AUTH_2.12"); sv.service != "AUTH" || sv.scalaVersion != "2.12" {
+		t.Errorf("expected the default suffixes to still be recognised, got %#v", sv)
+	}
+
+	// setting the suffixes replaces the defaults
+	configureScalaSuffixes([]string{"2.*"}, nil)
+	if sv := parseServiceAndVersion("ROUTE_3"); sv.service != "ROUTE_3" || sv.scalaVersion != "" {
+		t.Errorf("expected ROUTE_3 not to be a scala version, got %#v", sv)
+	}
+	if sv := parseServiceAndVersion("AUTH_2.9"); sv.service != "AUTH" || sv.scalaVersion != "2.9" {
+		t.Errorf("expected 2.* to recognise 2.9, got %#v", sv)
+	}
+	if !matchesArtifact("auth_%%", "auth_2.13") || matchesArtifact("route_%%", "route_3") {
+		t.Errorf("expected the artifacts to be matched by the configured suffixes")
+	}
+
+	configureScalaSuffixes(nil, nil)
+	if sv := parseServiceAndVersion("ROUTE_3"); sv.service != "ROUTE" || sv.scalaVersion != "3" {
+		t.Errorf("expected the defaults to be restored, got %#v", sv)
+	}
+}
+
+func TestLoadScalaSuffixes(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "config.json")
+
+	if suffixes, err := loadScalaSuffixes(configFile); err != nil || suffixes != nil {
+		t.Errorf("expected no suffixes without a config.json, got %v %v", suffixes, err)
+	}
+
+	AssertNotErr(t, os.WriteFile(configFile, []byte(`{"scalaSuffixes": ["3", "2.*"]}`), 0644))
+	suffixes, err := loadScalaSuffixes(configFile)
+	AssertNotErr(t, err)
+	if strings.Join(suffixes, ",") != "3,2.*" {
+		t.Errorf("expected 3,2.* got %v", suffixes)
+	}
+
+	AssertNotErr(t, os.WriteFile(configFile, []byte(`{"scalaSuffixes": ["2.x"]}`), 0644))
+	if _, err := loadScalaSuffixes(configFile); err == nil {
+		t.Errorf("expected an invalid suffix to be an error")
+	}
+}
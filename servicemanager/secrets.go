@@ -0,0 +1,210 @@
+package servicemanager
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Args can reference secrets rather than containing them, e.g.
+//
+//	"cmd": ["./my-service/bin/my-service", "-Dapi.key=${secret:MY_API_KEY}"]
+//
+// Secrets are looked up when the service is launched, first in the OS keychain and then in an encrypted
+// file in the workspace. Only the placeholder is ever written to the state file, so they don't end up
+// in plaintext in the config, the workspace or shell history.
+
+const (
+	secretsFile           = "secrets.enc"
+	secretsPassphraseEnv  = "SM2_SECRETS_PASSPHRASE"
+	secretsKdfIterations  = 100000
+	secretsSaltSize       = 16
+	secretsEncryptionSize = 32 // AES-256
+)
+
+var secretPlaceholder = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_.-]+)\}`)
+
+type secretLookup func(key string) (string, error)
+
+// returns a copy of args with any secrets filled in, each secret is only looked up once
+func resolveSecrets(args []string, lookup secretLookup) ([]string, error) {
+	resolved := make([]string, len(args))
+	found := map[string]string{}
+
+	for i, arg := range args {
+		var lookupErr error
+		resolved[i] = secretPlaceholder.ReplaceAllStringFunc(arg, func(p string) string {
+			key := secretPlaceholder.FindStringSubmatch(p)[1]
+			if value, ok := found[key]; ok {
+				return value
+			}
+			value, err := lookup(key)
+			if err != nil && lookupErr == nil {
+				lookupErr = err
+			}
+			found[key] = value
+			return value
+		})
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+	}
+	return resolved, nil
+}
+
+func (sm *ServiceManager) lookupSecret(key string) (string, error) {
+	if sm.Platform.SecretLookup != nil {
+		if value, err := sm.Platform.SecretLookup(key); err == nil {
+			return value, nil
+		}
+	}
+
+	file := path.Join(sm.Config.WorkspaceDir, secretsFile)
+	if !Exists(file) {
+		return "", fmt.Errorf("secret %s was not found in the keychain and there is no %s", key, file)
+	}
+
+	secrets, err := readSecretsFile(file, os.Getenv(secretsPassphraseEnv))
+	if err != nil {
+		return "", err
+	}
+	if value, ok := secrets[key]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("secret %s was not found in the keychain or %s", key, file)
+}
+
+// `sm2 -set-secret KEY` reads the value from stdin and stores it in the encrypted secrets file
+func (sm *ServiceManager) SetSecret(key string) error {
+	if !secretPlaceholder.MatchString("${secret:" + key + "}") {
+		return fmt.Errorf("%s is not a valid secret name, only letters, numbers, '.', '_' and '-' can be used", key)
+	}
+
+	passphrase := os.Getenv(secretsPassphraseEnv)
+	if passphrase == "" {
+		return fmt.Errorf("set %s to the passphrase used to encrypt the secrets file", secretsPassphraseEnv)
+	}
+
+	file := path.Join(sm.Config.WorkspaceDir, secretsFile)
+	secrets := map[string]string{}
+	if Exists(file) {
+		var err error
+		if secrets, err = readSecretsFile(file, passphrase); err != nil {
+			return err
+		}
+	}
+
+	if nonInteractive && stdinIsTerminal() {
+		return fmt.Errorf("with -machine the value for %s has to be piped in", key)
+	}
+	if isInteractive() {
+		fmt.Printf("Value for %s: ", key)
+	}
+	// a value without a trailing newline (e.g. from printf) is fine, so only an empty value is an error
+	value, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return fmt.Errorf("no value given for %s", key)
+	}
+	secrets[key] = value
+
+	if err := writeSecretsFile(file, passphrase, secrets); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s to %s\n", key, file)
+	return nil
+}
+
+// The secrets file is a json object encrypted with AES-GCM, laid out as salt, nonce then ciphertext.
+// The key is derived from the passphrase using PBKDF2.
+func readSecretsFile(file string, passphrase string) (map[string]string, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("set %s to read the secrets in %s", secretsPassphraseEnv, file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < secretsSaltSize {
+		return nil, fmt.Errorf("%s is not a valid secrets file", file)
+	}
+	gcm, err := secretsCipher(passphrase, data[:secretsSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < secretsSaltSize+gcm.NonceSize() {
+		return nil, fmt.Errorf("%s is not a valid secrets file", file)
+	}
+
+	nonce := data[secretsSaltSize : secretsSaltSize+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, data[secretsSaltSize+gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s, check %s is correct", file, secretsPassphraseEnv)
+	}
+
+	secrets := map[string]string{}
+	err = json.Unmarshal(plaintext, &secrets)
+	return secrets, err
+}
+
+func writeSecretsFile(file string, passphrase string, secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, secretsSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := secretsCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, nil)...)
+	return os.WriteFile(file, data, 0600)
+}
+
+func secretsCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2Sha256([]byte(passphrase), salt, secretsKdfIterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256, only ever needs the first block since that's the size of an AES-256 key
+func pbkdf2Sha256(password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key[:secretsEncryptionSize]
+}
@@ -0,0 +1,95 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	lookups := 0
+	lookup := func(key string) (string, error) {
+		lookups++
+		if key == "API_KEY" {
+			return "s3cret", nil
+		}
+		return "", fmt.Errorf("%s not found", key)
+	}
+
+	args := []string{"-Dapi.key=${secret:API_KEY}", "-Dother=${secret:API_KEY}-${port}", "-Dplain=true"}
+	resolved, err := resolveSecrets(args, lookup)
+	AssertNotErr(t, err)
+
+	expected := []string{"-Dapi.key=s3cret", "-Dother=s3cret-${port}", "-Dplain=true"}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("expected %v, got %v", expected, resolved)
+	}
+	if args[0] != "-Dapi.key=${secret:API_KEY}" {
+		t.Errorf("expected the original args to be left alone, got %v", args)
+	}
+	if lookups != 1 {
+		t.Errorf("expected each secret to be looked up once, was looked up %d times", lookups)
+	}
+
+	if _, err := resolveSecrets([]string{"-Dkey=${secret:MISSING}"}, lookup); err == nil {
+		t.Errorf("expected an error for a missing secret")
+	}
+}
+
+func TestSecretsFile(t *testing.T) {
+	workspace, err := os.MkdirTemp(os.TempDir(), "test-secrets*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(workspace)
+
+	file := path.Join(workspace, secretsFile)
+	AssertNotErr(t, writeSecretsFile(file, "passphrase", map[string]string{"API_KEY": "s3cret"}))
+
+	secrets, err := readSecretsFile(file, "passphrase")
+	AssertNotErr(t, err)
+	if secrets["API_KEY"] != "s3cret" {
+		t.Errorf("expected to read back the secret, got %v", secrets)
+	}
+
+	if _, err := readSecretsFile(file, "wrong"); err == nil {
+		t.Errorf("expected an error with the wrong passphrase")
+	}
+
+	os.Setenv(secretsPassphraseEnv, "passphrase")
+	defer os.Unsetenv(secretsPassphraseEnv)
+
+	keychain := func(key string) (string, error) {
+		if key == "FROM_KEYCHAIN" {
+			return "keychain-value", nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{WorkspaceDir: workspace},
+		Platform: platform.Platform{SecretLookup: keychain},
+	}
+
+	for key, expected := range map[string]string{"FROM_KEYCHAIN": "keychain-value", "API_KEY": "s3cret"} {
+		value, err := sm.lookupSecret(key)
+		AssertNotErr(t, err)
+		if value != expected {
+			t.Errorf("expected %s to be %s, got %s", key, expected, value)
+		}
+	}
+
+	if _, err := sm.lookupSecret("MISSING"); err == nil {
+		t.Errorf("expected an error for a secret that doesn't exist")
+	}
+}
+
+func TestPbkdf2Sha256(t *testing.T) {
+	// test vector from RFC 7914 section 11
+	key := pbkdf2Sha256([]byte("passwd"), []byte("salt"), 1)
+	if fmt.Sprintf("%x", key) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Errorf("unexpected key %x", key)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"sm2/cli"
@@ -21,30 +22,74 @@ type ServiceManager struct {
 	Config   ServiceManagerConfig
 	Commands cli.UserOption
 	progress ProgressRenderer
+	tracer   *Tracer
 	Platform platform.Platform
 	Ledger   ledger.Ledger
+
+	api           *adminApi              // served by the daemon with -api
+	configWatcher *configWatcher         // used by the daemon to reload the config when it changes
+	events        *eventWriter           // the json events written with -machine, see machine.go
+	interrupts    *interrupter           // cancels what's in progress on ctrl-c, see interrupt.go
+	latest        *latestCache           // the latest versions looked up for a batch of services, see latest.go
+	metadata      *metadataCache         // the latest versions found by recent invocations, see metadatacache.go
+	portPlan      map[string]plannedPort // ports worked out before starting a batch of services, see planPorts
+	proxy         *proxyResolver         // picks the proxy for artifactory requests, see configureProxy
+	refresher     *metadataRefresher     // looks up the latest versions in the background while the daemon runs
+	statuses      *statusCache           // the last health check of each service, used by the daemon and -watch
+	warmAttempts  map[string]time.Time   // when the daemon last tried to start each service it keeps running
+
+	windowsPorts        map[int]bool // the ports in use on windows, when sm2 is in wsl, see windowsPortInUse
+	windowsPortsChecked time.Time
 }
 
 type ServiceManagerConfig struct {
+	WorkspaceDir       string
 	TmpDir             string
 	VpnTestHostname    string
 	ArtifactoryRepoUrl string
 	ArtifactoryPingUrl string
+	BindAddress        string // the address services listen on, unless they set their own
 	ConfigDir          string
 	TimeoutShort       time.Duration
+	ScalaVersions      []string        // the order to try scala versions in, for _%% artifacts
+	ScalaSuffixes      []string        // the scala versions artifacts can end in, see scalasuffix.go
+	PortRanges         []cli.PortRange // the ports services may use, empty allows any
+	PortCollision      string          // fail, shift or prompt when a service's port is taken
+	ServiceRegistry    string          // consul:// or etcd:// url that started services are registered with
+	TracingEndpoint    string
+	IncludeDirs        []string
+	OverrideFiles      []string
 }
 
 type Service struct {
 	Id          string
-	Name        string        `json:"name"`
-	DefaultPort int           `json:"defaultPort"`
-	Template    string        `json:"template"`
-	Frontend    bool          `json:"frontend"`
-	Source      Source        `json:"sources"`
-	Binary      ServiceBinary `json:"binary"`
-	Location    string        `json:"location"`
-	Healthcheck Healthcheck   `json:"healthcheck"`
-	ProxyPaths  []string      `json:"proxyPaths"`
+	Name        string            `json:"name"`
+	DefaultPort int               `json:"defaultPort"`
+	Template    string            `json:"template"`
+	Extends     string            `json:"extends"`
+	Params      map[string]string `json:"params"`
+	RenamedTo   string            `json:"renamedTo"`
+	Deprecated  bool              `json:"deprecated"`
+	Platforms   Platforms         `json:"platforms"`
+	Frontend    bool              `json:"frontend"`
+	Source      Source            `json:"sources"`
+	Binary      ServiceBinary     `json:"binary"`
+	Location    string            `json:"location"`
+	Healthcheck Healthcheck       `json:"healthcheck"`
+	ProxyPaths  []string          `json:"proxyPaths"`
+	ProxyHosts  []string          `json:"proxyHosts"`
+	BindAddress string            `json:"bindAddress"`
+	Type        string            `json:"type"` // empty for a normal service, or "tunnel", "wiremock" etc
+	Tunnel      Tunnel            `json:"tunnel"`
+	Wiremock    Wiremock          `json:"wiremock"`
+	Pact        Pact              `json:"pact"`
+	Stub        StubResponses     `json:"stub"`    // canned responses for when it's started with -stub
+	Runtime     string            `json:"runtime"` // empty for the jvm, or "docker"
+	Docker      Docker            `json:"docker"`
+	DebugPort   int               `json:"debugPort"` // for -jdwp, defaults to the port + 10000
+	Output      Output            `json:"output"`
+	LandingPath string            `json:"landingPath"` // where -open goes, e.g. its swagger ui, defaults to the first proxyPath or /
+	OpenApiPath string            `json:"openApiPath"` // where -api-index finds its openapi spec, if it's not one of the usual places
 }
 
 type ServiceBinary struct {
@@ -52,6 +97,7 @@ type ServiceBinary struct {
 	GroupId           string   `json:"groupId"`
 	DestinationSubdir string   `json:"destinationSubdir"`
 	Cmd               []string `json:"cmd"`
+	Repo              string   `json:"repo"` // artifactory repository url, when it's not the one in config.json
 }
 
 type Source struct {
@@ -62,6 +108,7 @@ type Source struct {
 type Healthcheck struct {
 	Url      string `json:"url"`
 	Response string `json:"response"`
+	Family   string `json:"family"` // ipv4 or ipv6 to only probe that loopback address, rather than localhost
 }
 
 const DEFAULT_SHORT_TIMEOUT = 20
@@ -74,13 +121,12 @@ func (sm ServiceManager) PrintVerbose(s string, args ...interface{}) {
 	}
 }
 
-func (sm *ServiceManager) NewShortContext() context.Context {
+func (sm *ServiceManager) NewShortContext() (context.Context, context.CancelFunc) {
 	ttl := sm.Config.TimeoutShort
 	if ttl == 0 {
 		ttl = DEFAULT_SHORT_TIMEOUT * time.Second
 	}
-	ctx, _ := context.WithTimeout(context.Background(), ttl)
-	return ctx
+	return context.WithTimeout(sm.context(), ttl)
 }
 
 // based on config, find the directory a service is installed into.
@@ -89,14 +135,33 @@ func (sm *ServiceManager) NewShortContext() context.Context {
 //	rather it guesses where it is...
 func (sm *ServiceManager) findInstallDirOfService(serviceName string) (string, error) {
 	if service, ok := sm.Services[serviceName]; ok {
+		if service.Type == TunnelType && service.Binary.DestinationSubdir == "" {
+			return path.Join(sm.Config.TmpDir, strings.ToLower(serviceName)+"-tunnel"), nil
+		}
+		if sm.runsInDocker(service) && service.Binary.DestinationSubdir == "" {
+			return path.Join(sm.Config.TmpDir, strings.ToLower(serviceName)+"-docker"), nil
+		}
+		if service.Type == PactType && service.Binary.DestinationSubdir == "" {
+			return path.Join(sm.Config.TmpDir, strings.ToLower(serviceName)+"-pact"), nil
+		}
+		if service.Type == WiremockType && service.Binary.DestinationSubdir == "" {
+			return path.Join(sm.Config.TmpDir, strings.ToLower(serviceName)+"-wiremock"), nil
+		}
+		if service.Runtime == NativeRuntime && service.Binary.DestinationSubdir == "" {
+			return path.Join(sm.Config.TmpDir, strings.ToLower(serviceName)+"-native"), nil
+		}
 		return path.Join(sm.Config.TmpDir, service.Binary.DestinationSubdir), nil
 	}
 	return "", fmt.Errorf("unknown service: %s", serviceName)
 }
 
 func (sm *ServiceManager) LoadConfig() error {
-	workspacePath, envIsSet := os.LookupEnv("WORKSPACE")
+	// $WORKSPACE or a named workspace chosen with --use-workspace
+	workspacePath, envIsSet := cli.Workspace()
+	return sm.loadConfig(workspacePath, envIsSet)
+}
 
+func (sm *ServiceManager) loadConfig(workspacePath string, envIsSet bool) error {
 	// use the default workspace path if one isn't set
 	if !envIsSet {
 		defaultWorkspacePath, err := createDefaultWorkspace()
@@ -114,7 +179,7 @@ func (sm *ServiceManager) LoadConfig() error {
 	// check service-manager-config is present
 	configPath := path.Join(workspacePath, "service-manager-config")
 	if sm.Commands.Config != "" {
-		configPath = sm.Commands.Config
+		configPath = sm.wsl().LinuxPath(sm.Commands.Config)
 	}
 
 	if stat, err := os.Stat(configPath); err != nil || !stat.IsDir() {
@@ -134,19 +199,54 @@ func (sm *ServiceManager) LoadConfig() error {
 		}
 	}
 
-	// load repo details from config.json
+	// load repo details from config.json. A config.json that isn't valid fails here the same as it does
+	// for everything else that's read from it, rather than quietly using the default urls
 	configJsonFileName := path.Join(configPath, "config.json")
 	repoConfig, err := loadRepoConfig(configJsonFileName)
 	if err != nil {
-		repoConfig = DefaultArtifactoryUrls
+		return fmt.Errorf("Failed to load %s\n  %s\n", configJsonFileName, err)
+	}
+
+	includeDirs, err := loadIncludes(configJsonFileName, configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s\n  %s\n", configJsonFileName, err)
+	}
+
+	scalaVersions, err := loadScalaVersions(configJsonFileName)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s\n  %s\n", configJsonFileName, err)
+	}
+
+	scalaSuffixes, err := loadScalaSuffixes(configJsonFileName)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s\n  %s\n", configJsonFileName, err)
+	}
+
+	overrideFiles, err := findOverrideFiles(workspacePath)
+	if err != nil {
+		return fmt.Errorf("Failed to load overrides\n  %s\n", err)
 	}
 
 	sm.Config = ServiceManagerConfig{
 		ArtifactoryRepoUrl: repoConfig.RepoUrl,
 		ArtifactoryPingUrl: repoConfig.PingUrl,
+		WorkspaceDir:       workspacePath,
 		TmpDir:             path.Join(workspacePath, "install"),
 		ConfigDir:          configPath,
 		TimeoutShort:       DEFAULT_SHORT_TIMEOUT * time.Second,
+		ScalaVersions:      scalaVersions,
+		ScalaSuffixes:      scalaSuffixes,
+		TracingEndpoint:    tracingEndpoint(),
+		IncludeDirs:        includeDirs,
+		OverrideFiles:      overrideFiles,
+	}
+
+	sm.applySettings()
+	configureScalaSuffixes(sm.Config.ScalaSuffixes, sm.Config.ScalaVersions)
+
+	// export spans to an opentelemetry collector if one is configured, or keep them for -timings
+	if (sm.Config.TracingEndpoint != "" || sm.Commands.Timings) && sm.tracer == nil {
+		sm.tracer = NewTracer(sm.Config.TracingEndpoint)
 	}
 
 	// allow for short timout (vpn check etc) to be overriden in case of network weirdness
@@ -156,20 +256,33 @@ func (sm *ServiceManager) LoadConfig() error {
 		}
 	}
 
+	sm.configureProxy()
+	sm.configureAws()
+	sm.configureThrottling()
+	sm.metadata = sm.newMetadataCache()
+
 	// @speed consider lazy loading these rather than loading on startup
-	serviceFilePath := path.Join(configPath, "services.json")
-	services, err := loadServicesFromFile(serviceFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to load %s\n  %s\n", serviceFilePath, err)
+	if err := sm.loadDefinitions(); err != nil {
+		return err
 	}
-	sm.Services = *services
 
-	profileFilePath := path.Join(configPath, "profiles.json")
-	profiles, err := loadProfilesFromFile(profileFilePath)
-	if err != nil {
-		return fmt.Errorf("Failed to load %s\n %s\n", profileFilePath, err)
+	if sm.Commands.Overlay != "" {
+		if err := sm.applyOverlays(sm.Commands.Overlay); err != nil {
+			return fmt.Errorf("Failed to apply overlay\n  %s\n", err)
+		}
+	}
+
+	if sm.Commands.ProfileUrl != "" {
+		if err := sm.loadProfileUrl(sm.Commands.ProfileUrl); err != nil {
+			return fmt.Errorf("Failed to load the profile from %s\n  %s\n", sm.Commands.ProfileUrl, err)
+		}
+	}
+
+	if !sm.Commands.AutoComplete && !sm.Commands.LintConfig && sm.portPolicyEnabled() {
+		if problems := append(portClashes(sm.Services), portRangeProblems(sm.Services, sm.Config.PortRanges)...); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: found %d port problem(s) in %s, run `sm2 --lint-config` for details.\n", len(problems), configPath)
+		}
 	}
-	sm.Profiles = *profiles
 
 	// ensure install dir exists
 	err = os.MkdirAll(sm.Config.TmpDir, 0755)
@@ -180,6 +293,36 @@ func (sm *ServiceManager) LoadConfig() error {
 	return nil
 }
 
+// loads the service and profile definitions, along with any templates, includes and overrides
+func (sm *ServiceManager) loadDefinitions() error {
+	serviceFilePath, serviceIncludes, err := sm.configSources("services")
+	if err != nil {
+		return fmt.Errorf("Failed to load services\n  %s\n", err)
+	}
+	templates, err := sm.loadTemplates()
+	if err != nil {
+		return fmt.Errorf("Failed to load templates\n  %s\n", err)
+	}
+	services, err := loadServicesFromFile(serviceFilePath, templates, append(serviceIncludes, sm.Config.OverrideFiles...)...)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s\n  %s\n", serviceFilePath, err)
+	}
+
+	profileFilePath, profileIncludes, err := sm.configSources("profiles")
+	if err != nil {
+		return fmt.Errorf("Failed to load profiles\n  %s\n", err)
+	}
+	profiles, err := loadProfilesFromFile(profileFilePath, profileIncludes...)
+	if err != nil {
+		return fmt.Errorf("Failed to load %s\n %s\n", profileFilePath, err)
+	}
+
+	setupInfra(*services)
+	sm.Services = *services
+	sm.Profiles = *profiles
+	return nil
+}
+
 func createDefaultWorkspace() (string, error) {
 
 	homeDir, err := os.UserHomeDir()
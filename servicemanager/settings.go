@@ -0,0 +1,64 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sm2/cli"
+)
+
+// applies workspace settings from the user's defaults file (~/.sm2/config) over config.json.
+// the values have already been validated when the file was loaded.
+func (sm *ServiceManager) applySettings() {
+	if url, ok := sm.Commands.Settings["artifactoryUrl"]; ok {
+		sm.Config.ArtifactoryRepoUrl = strings.TrimSuffix(url, "/")
+	}
+	if url, ok := sm.Commands.Settings["artifactoryPingUrl"]; ok {
+		sm.Config.ArtifactoryPingUrl = url
+	}
+	if policy, ok := sm.Commands.Settings["portCollision"]; ok {
+		sm.Config.PortCollision = policy
+	}
+	if ranges, ok := sm.Commands.Settings["portRanges"]; ok {
+		sm.Config.PortRanges, _ = cli.ParsePortRanges(ranges)
+	}
+	if address, ok := sm.Commands.Settings["bindAddress"]; ok {
+		sm.Config.BindAddress = address
+	}
+	if versions, ok := sm.Commands.Settings["scalaVersions"]; ok {
+		sm.Config.ScalaVersions = strings.Split(versions, ",")
+	}
+	if suffixes, ok := sm.Commands.Settings["scalaSuffixes"]; ok {
+		sm.Config.ScalaSuffixes = strings.Split(suffixes, ",")
+	}
+	if registry, ok := sm.Commands.Settings["serviceRegistry"]; ok {
+		sm.Config.ServiceRegistry = registry
+	}
+	if timeout, ok := sm.Commands.Settings["timeout"]; ok {
+		if seconds, err := strconv.Atoi(timeout); err == nil {
+			sm.Config.TimeoutShort = time.Duration(seconds) * time.Second
+		}
+	}
+}
+
+// handles --config-set KEY=VALUE
+func (sm *ServiceManager) setDefault(keyValue string) error {
+	parts := strings.SplitN(keyValue, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected KEY=VALUE, e.g. --config-set timeout=30")
+	}
+
+	file := cli.DefaultsFile()
+	if err := cli.SetDefault(file, parts[0], parts[1]); err != nil {
+		return err
+	}
+
+	if parts[1] == "" {
+		fmt.Printf("Removed %s from %s\n", parts[0], file)
+	} else {
+		fmt.Printf("Set %s to %s in %s\n", parts[0], parts[1], file)
+	}
+	return nil
+}
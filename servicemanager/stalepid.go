@@ -0,0 +1,54 @@
+package servicemanager
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+// Once a service has died (most often when the machine's rebooted) the pid in its state file can be given
+// to something else, which stopping the service would then kill. So before a pid's stopped it's checked it
+// still belongs to the service: the process mustn't have started after sm2 started the service, and a
+// service run from a release must still have its name in its args.
+
+// the process is started a little before the state file records it, but never after
+const pidStartSlack = 5 * time.Second
+
+// an error saying why the pid in the service's state file isn't the service any more, or nil when it is
+// (or it can't tell)
+func (sm *ServiceManager) checkServicePid(serviceName string, pid int) error {
+	installDir, err := sm.findInstallDirOfService(serviceName)
+	if err != nil {
+		return nil
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil || state.Pid != pid {
+		return nil
+	}
+	return checkProcess(state, sm.Platform.ProcessInfo)
+}
+
+func checkProcess(state ledger.StateFile, lookup func(int) (platform.ProcessInfo, error)) error {
+	// containers are stopped by name, whatever their pid
+	if lookup == nil || state.Runtime != "" {
+		return nil
+	}
+	info, err := lookup(state.Pid)
+	if err != nil {
+		return err
+	}
+	if !state.Started.IsZero() && info.Started.After(state.Started.Add(pidStartSlack)) {
+		return fmt.Errorf("pid %d was started at %s, after %s was (%s), so it's been reused",
+			state.Pid, info.Started.Local().Format("2006-01-02 15:04:05"), state.Service, state.Started.Local().Format("2006-01-02 15:04:05"))
+	}
+	marker := "-Dservice.manager.serviceName=" + state.Service
+	for _, arg := range state.Args {
+		if arg == marker && !strings.Contains(info.Args, marker) {
+			return fmt.Errorf("pid %d isn't %s, it's %s", state.Pid, state.Service, info.Args)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package servicemanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestCheckProcess(t *testing.T) {
+	started := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	state := ledger.StateFile{
+		Service: "AUTH",
+		Pid:     1234,
+		Started: started,
+		Args:    []string{"-Dplay.http.secret.key=x", "-Dservice.manager.serviceName=AUTH"},
+	}
+	running := func(info platform.ProcessInfo) func(int) (platform.ProcessInfo, error) {
+		return func(int) (platform.ProcessInfo, error) { return info, nil }
+	}
+
+	if err := checkProcess(state, running(platform.ProcessInfo{Started: started.Add(-time.Second), Args: "java -Dservice.manager.serviceName=AUTH -cp ..."})); err != nil {
+		t.Errorf("expected the service's own process to be stopped, got %s", err)
+	}
+	if err := checkProcess(state, running(platform.ProcessInfo{Started: started.Add(time.Hour), Args: "java -Dservice.manager.serviceName=AUTH -cp ..."})); err == nil {
+		t.Errorf("expected a process started after the service to be left alone")
+	}
+	if err := checkProcess(state, running(platform.ProcessInfo{Started: started, Args: "/usr/lib/firefox/firefox"})); err == nil {
+		t.Errorf("expected a process that isn't the service to be left alone")
+	}
+	if err := checkProcess(state, func(int) (platform.ProcessInfo, error) {
+		return platform.ProcessInfo{}, fmt.Errorf("pid 1234 isn't running")
+	}); err == nil {
+		t.Errorf("expected a pid that isn't running to be an error")
+	}
+
+	// services that aren't run from a release don't have their name in their args
+	tunnel := ledger.StateFile{Service: "DB_TUNNEL", Pid: 1234, Started: started}
+	if err := checkProcess(tunnel, running(platform.ProcessInfo{Started: started, Args: "ssh -N -L 5432:db:5432 bastion"})); err != nil {
+		t.Errorf("expected the tunnel to be stopped, got %s", err)
+	}
+	container := ledger.StateFile{Service: "REDIS", Pid: 1234, Started: started, Runtime: DockerRuntime}
+	if err := checkProcess(container, running(platform.ProcessInfo{Started: started.Add(time.Hour)})); err != nil {
+		t.Errorf("expected containers not to be checked, got %s", err)
+	}
+}
@@ -19,6 +19,9 @@ func (sm *ServiceManager) StartFromSource(serviceName string) error {
 	if !ok {
 		return fmt.Errorf("%s is not a valid service", serviceName)
 	}
+	if err := retiredServiceError(serviceName, service); err != nil {
+		return err
+	}
 
 	// TODO: check its not already running
 
@@ -38,12 +41,14 @@ func (sm *ServiceManager) StartFromSource(serviceName string) error {
 	// sbt run the service, redirect output to logs
 
 	sm.progress.update(serviceName, 100, "Starting...")
+	// the port it's given is claimed until it's started, see workspacelock.go
+	defer sm.releasePort(serviceName)
 	state, err := sm.sbtBuildAndRun(installFile.Path, service)
 	if err != nil {
 		return err
 	}
 
-	err = sm.Ledger.SaveStateFile(installDir, state)
+	err = sm.saveState(installDir, state)
 	sm.pauseTillHealthy(state.HealthcheckUrl)
 	return err
 }
@@ -77,28 +82,46 @@ func (sm *ServiceManager) installFromGit(installDir string, gitUrl string, servi
 
 func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service) (ledger.StateFile, error) {
 	state := ledger.StateFile{}
-	port := sm.findPort(service)
+	port, err := sm.assignPort(service)
+	if err != nil {
+		return state, err
+	}
 
-	sbtStartCmds := "start " + fmt.Sprintf("start -Dhttp.port=%d ", port) + strings.Join(sm.generateArgs(service, "src", srcDir, append(service.Binary.Cmd[1:], service.Source.ExtraParams...)), " ")
+	bindAddress := sm.bindAddress(service)
+	// the args are read by sbt, so ones with spaces (e.g. the workspace's path) are quoted for it
+	startArgs := []string{fmt.Sprintf("-Dhttp.port=%d", port), fmt.Sprintf("-Dhttp.address=%s", bindAddress)}
+	for _, arg := range sm.generateArgs(service, "src", srcDir, append(service.Binary.Cmd[1:], service.Source.ExtraParams...)) {
+		startArgs = append(startArgs, sbtQuote(arg))
+	}
+	sbtStartCmds := "start " + strings.Join(startArgs, " ")
 	args := []string{"-mem", "2048", sbtStartCmds}
 
-	cmd := exec.Command("sbt", args...)
+	launchArgs, err := resolveSecrets(fillPortArgs(args, port), sm.lookupSecret)
+	if err != nil {
+		return state, err
+	}
+
+	cmd := exec.Command("sbt", launchArgs...)
 	cmd.Dir = srcDir
 
-	logFile, err := os.Create(path.Join(srcDir, "logs", "stdout.log"))
+	clearExitStatus(srcDir)
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, srcDir))
 	if err != nil {
-		return state, fmt.Errorf("unable to create stdout.log %s", err)
+		return state, fmt.Errorf("unable to create log files %s", err)
 	}
+	defer closeOutputs(stdout, stderr)
 
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
 
 	err = cmd.Start()
 	if err != nil {
 		return state, err
 	}
+	go recordExitStatus(cmd, srcDir)
 
-	healthcheckUrl := findHealthcheckUrl(service, state.Port)
+	healthcheckUrl := sm.serviceHealthcheckUrl(service, port)
 	state = ledger.StateFile{
 		Service:        service.Id,
 		Artifact:       service.Binary.Artifact,
@@ -107,8 +130,11 @@ func (sm ServiceManager) sbtBuildAndRun(srcDir string, service Service) (ledger.
 		Started:        time.Now(),
 		Pid:            cmd.Process.Pid,
 		Port:           port,
+		BindAddress:    bindAddress,
 		Args:           args,
 		HealthcheckUrl: healthcheckUrl,
+		Cmd:            cmd.Path,
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
 	}
 	return state, nil
 }
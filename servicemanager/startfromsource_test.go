@@ -0,0 +1,42 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestSbtBuildAndRunStartsOnce(t *testing.T) {
+	// an sbt that writes out the commands it was given, one per line
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor a in \"$@\"; do printf '[%s]\\n' \"$a\"; done > \"$PWD/sbt-args.txt\"\n"
+	AssertNotErr(t, os.WriteFile(path.Join(bin, "sbt"), []byte(script), 0755))
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	srcDir := t.TempDir()
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: t.TempDir()}}
+	service := Service{Id: "FOO", DefaultPort: 9000, Binary: ServiceBinary{Cmd: []string{"./foo/bin/foo", "-Dmsg=hello world"}}}
+	state, err := sm.sbtBuildAndRun(srcDir, service)
+	AssertNotErr(t, err)
+
+	argsFile := path.Join(srcDir, "sbt-args.txt")
+	for i := 0; i < 100; i++ {
+		if data, err := os.ReadFile(argsFile); err == nil && strings.Count(string(data), "\n") == 3 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	data, err := os.ReadFile(argsFile)
+	AssertNotErr(t, err)
+	args := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(args) != 3 || args[0] != "[-mem]" || !strings.HasPrefix(args[2], "[start -Dhttp.port=9000 -Dhttp.address=127.0.0.1 \"-Dmsg=hello world\" ") {
+		t.Errorf("expected sbt to be asked to start the service once, got\n%s", data)
+	}
+	if len(state.Args) != 3 || "["+state.Args[2]+"]" != args[2] {
+		t.Errorf("expected the command sbt was given to be recorded, got %v", state.Args)
+	}
+}
@@ -1,6 +1,8 @@
 package servicemanager
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -13,25 +15,154 @@ import (
 	"sm2/ledger"
 )
 
+// returned by StartService when the service is already running
+var ErrAlreadyRunning = errors.New("Already running")
+
 // startService attempts to start a version of a service, if the version is not specified
 // service manager will get the latest vesion from artifactory.
-func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) error {
+func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) (err error) {
 
 	offline := sm.Commands.Offline
 
+	span := sm.tracer.StartSpan("start "+serviceAndVersion.service, nil, "sm2.service", serviceAndVersion.service)
+	defer func() { span.End(err) }()
+
 	// look-up the service
 	service, ok := sm.Services[serviceAndVersion.service]
 	if !ok {
 		return fmt.Errorf("%s is not a valid service", serviceAndVersion.service)
 	}
+	if err := retiredServiceError(serviceAndVersion.service, service); err != nil {
+		return err
+	}
 
 	// check if its already running and exit if it is
 	// TODO: check PID too
-	port := sm.findPort(service)
-	healthcheckUrl := findHealthcheckUrl(service, port)
-	if sm.CheckHealth(healthcheckUrl) {
+	if sm.isAlreadyRunning(service) {
 		sm.progress.update(serviceAndVersion.service, 100, "Already running")
-		return fmt.Errorf("Already running")
+		return ErrAlreadyRunning
+	}
+	// deferred first so it runs last, once the state file has its final port
+	defer func() {
+		if err == nil {
+			err = sm.register(service.Id)
+		}
+	}()
+	port, err := sm.assignPort(service)
+	if err != nil {
+		sm.progress.update(serviceAndVersion.service, 0, "Failed")
+		return err
+	}
+	// once it's started the port's in its state file, see workspacelock.go
+	defer sm.releasePort(service.Id)
+	healthcheckUrl := sm.serviceHealthcheckUrl(service, port)
+
+	// captured services run on a port of their own, behind a proxy on the port everything else calls
+	if sm.isCaptured(service) {
+		capturePort := port
+		if port, err = freePort(); err != nil {
+			sm.progress.update(serviceAndVersion.service, 0, "Failed")
+			return err
+		}
+		healthcheckUrl = sm.serviceHealthcheckUrl(service, port)
+		defer func() {
+			if err == nil {
+				err = sm.startCapture(service, capturePort, port)
+			}
+		}()
+	}
+
+	// how it's started depends on what sort of service it is, the ones from artifactory being installed first
+	installDir, _ := sm.findInstallDirOfService(service.Id)
+	starting := "Starting..."
+	var start func() (ledger.StateFile, error)
+	switch {
+	case sm.isStubbed(service.Id):
+		starting = "Stubbing..."
+		start = func() (ledger.StateFile, error) { return sm.startStub(service, installDir, port, healthcheckUrl) }
+
+	case service.Type == TunnelType:
+		starting = "Connecting..."
+		start = func() (ledger.StateFile, error) { return sm.startTunnel(service, installDir, port, healthcheckUrl) }
+
+	case service.Type == PactType:
+		start = func() (ledger.StateFile, error) { return sm.startPact(service, installDir, port, healthcheckUrl) }
+
+	case service.Type == WiremockType:
+		start = func() (ledger.StateFile, error) { return sm.startWiremock(service, installDir, port, healthcheckUrl) }
+
+	case service.Runtime == NativeRuntime && isInfra(service):
+		start = func() (ledger.StateFile, error) { return sm.startNative(service, installDir, port, healthcheckUrl) }
+
+	case sm.runsInDocker(service):
+		image := dockerImage(service, serviceAndVersion.version)
+		span.SetAttr("sm2.version", imageTag(image))
+		if !offline {
+			sm.progress.update(serviceAndVersion.service, 0, "Pulling")
+			if err := pullImage(image, sm.Commands.Clean); err != nil {
+				sm.progress.update(serviceAndVersion.service, 0, "Failed")
+				return err
+			}
+		}
+		start = func() (ledger.StateFile, error) {
+			return sm.startContainer(service, installDir, image, port, healthcheckUrl)
+		}
+
+	default:
+		installFile, versionToInstall, err := sm.installVersion(service, serviceAndVersion, installDir, span)
+		if err != nil {
+			return err
+		}
+
+		// clean and recreate log dirs...
+		if _, err := initLogDir(installFile.Path); err != nil {
+			sm.progress.update(serviceAndVersion.service, 0, "Failed")
+			return err
+		}
+
+		args := sm.generateArgs(service, versionToInstall, installFile.Path, service.Binary.Cmd[1:])
+		debug := 0
+		if sm.Commands.Jdwp {
+			if debug = debugPort(service, port); debug > 0 {
+				args = append(args, jdwpArg(debug))
+			}
+		}
+		start = func() (ledger.StateFile, error) {
+			runSpan := sm.tracer.StartSpan("run", span)
+			state, err := run(service, installFile, args, port, sm.bindAddress(service), sm.lookupSecret)
+			runSpan.End(err)
+			state.HealthcheckUrl = healthcheckUrl
+			state.DebugPort = debug
+			return state, err
+		}
+	}
+
+	// start the service...
+	sm.progress.update(serviceAndVersion.service, 100, starting)
+	state, err := start()
+	if err != nil {
+		sm.progress.update(serviceAndVersion.service, 0, "Failed")
+		return err
+	}
+	// and finally, we record out success
+	err = sm.saveState(installDir, state)
+	sm.pauseTillHealthy(healthcheckUrl)
+	return err
+}
+
+// checks the vpn, works out which version of a service to run and installs it, unless it already is
+func (sm *ServiceManager) installVersion(service Service, serviceAndVersion ServiceAndVersion, installDir string, span *Span) (ledger.InstallFile, string, error) {
+	offline := sm.Commands.Offline
+
+	// builds from --build-and-run aren't in artifactory, so there's nothing to check
+	if installFile, err := sm.Ledger.LoadInstallFile(installDir); err == nil && isLocalBuild(installFile, serviceAndVersion.version) {
+		return installFile, installFile.Version, nil
+	}
+
+	// nor is there when the exact version asked for is already installed
+	if installFile, ok := sm.findIndexedInstall(service, serviceAndVersion, installDir); ok {
+		span.SetAttr("sm2.version", installFile.Version)
+		return installFile, installFile.Version, nil
 	}
 
 	// check if we're on the VPN (if required)
@@ -39,21 +170,25 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 		vpnOk, _ := checkVpn(sm.Client, sm.Config)
 		if !offline && !vpnOk {
 			sm.progress.update(serviceAndVersion.service, 0, "No VPN")
-			return fmt.Errorf("Check VPN connection, couldn't reach artifactory.")
+			return ledger.InstallFile{}, "", fmt.Errorf("Check VPN connection, couldn't reach artifactory.")
 		}
 	}
 
-	// work out what we will install, where...
-	installDir, _ := sm.findInstallDirOfService(serviceAndVersion.service)
+	// work out what we will install...
+	resolveSpan := sm.tracer.StartSpan("resolve version", span)
 	group, artifact, versionToInstall, err := whatVersionToRun(service, serviceAndVersion, offline, sm.GetLatestVersions)
+	resolveSpan.SetAttr("sm2.version", versionToInstall)
+	resolveSpan.End(err)
 	if err != nil {
 		sm.progress.update(serviceAndVersion.service, 0, "Failed")
-		return err
+		return ledger.InstallFile{}, "", err
 	}
+	span.SetAttr("sm2.version", versionToInstall)
+	downloadUrl := artifactUrl(sm.repoUrl(service.Binary), group, artifact, versionToInstall)
 	isInstalled := false
 	installFile, err := sm.Ledger.LoadInstallFile(installDir)
 	if err == nil {
-		isInstalled = verifyInstall(installFile, service.Id, versionToInstall, offline)
+		isInstalled = verifyInstall(installFile, service.Id, versionToInstall, downloadUrl, offline)
 	}
 
 	// and if required, install it...
@@ -62,38 +197,42 @@ func (sm *ServiceManager) StartService(serviceAndVersion ServiceAndVersion) erro
 		// if we're offline and its not installed, there's not much we can do!
 		if offline {
 			sm.progress.update(serviceAndVersion.service, 0, "Failed")
-			return fmt.Errorf("Not available offline")
+			return ledger.InstallFile{}, "", fmt.Errorf("Not available offline")
 		}
 
-		sm.progress.update(serviceAndVersion.service, 0, "Install")
+		// waited for if sm2's interrupted, see interrupt.go
+		installed, err := sm.interrupts.installing()
+		if err != nil {
+			return ledger.InstallFile{}, "", err
+		}
+		defer installed()
 
-		var err error
-		installFile, err = sm.installService(installDir, service.Id, group, artifact, versionToInstall)
+		// another sm2 may be installing it into the same dir, see installlock.go
+		unlock, waited, err := sm.lockInstall(installDir, func() {
+			sm.progress.update(serviceAndVersion.service, 0, "Waiting")
+		})
 		if err != nil {
-			return err
+			return ledger.InstallFile{}, "", err
 		}
-	}
+		defer unlock()
 
-	// clean and recreate log dirs...
-	_, err = initLogDir(installFile.Path)
-	if err != nil {
-		sm.progress.update(serviceAndVersion.service, 0, "Failed")
-		return err
-	}
+		// and if it has, it's used rather than downloaded again, even with -clean
+		if existing, err := sm.Ledger.LoadInstallFile(installDir); err == nil && (waited || !sm.Commands.Clean) && verifyInstall(existing, service.Id, versionToInstall, downloadUrl, offline) {
+			installFile = existing
+		} else {
+			sm.progress.update(serviceAndVersion.service, 0, "Install")
 
-	// start the service...
-	args := sm.generateArgs(service, versionToInstall, installFile.Path, service.Binary.Cmd[1:])
-	sm.progress.update(serviceAndVersion.service, 100, "Starting...")
-	state, err := run(service, installFile, args, port)
-	if err != nil {
-		sm.progress.update(serviceAndVersion.service, 0, "Failed")
-		return err
+			installFile, err = sm.installService(installDir, service.Id, downloadUrl, artifact, versionToInstall, span)
+			if err != nil {
+				return ledger.InstallFile{}, "", err
+			}
+		}
 	}
-	state.HealthcheckUrl = healthcheckUrl
-	// and finally, we record out success
-	err = sm.Ledger.SaveStateFile(installDir, state)
-	sm.pauseTillHealthy(healthcheckUrl)
-	return err
+	if !offline {
+		sm.indexInstall(installDir, group, artifact, versionToInstall)
+	}
+
+	return installFile, versionToInstall, nil
 }
 
 func (sm *ServiceManager) pauseTillHealthy(healthcheckUrl string) {
@@ -106,36 +245,55 @@ func (sm *ServiceManager) pauseTillHealthy(healthcheckUrl string) {
 	}
 }
 
-func (sm *ServiceManager) installService(installDir string, serviceId string, group string, artifact string, version string) (ledger.InstallFile, error) {
+func artifactUrl(repoUrl string, group string, artifact string, version string) string {
+	groupPath := strings.ReplaceAll(group, ".", "/")
+	filename := fmt.Sprintf("%s-%s.tgz", url.PathEscape(artifact), url.PathEscape(version))
+	return repoUrl + path.Join("/", groupPath, url.PathEscape(artifact), url.PathEscape(version), filename)
+}
+
+func (sm *ServiceManager) installService(installDir string, serviceId string, downloadUrl string, artifact string, version string, parent *Span) (installFile ledger.InstallFile, err error) {
 
-	var installFile ledger.InstallFile
+	span := sm.tracer.StartSpan("install", parent, "sm2.artifact", artifact, "sm2.version", version)
+	defer func() { span.End(err) }()
 
-	err := removeExistingVersions(installDir)
+	err = removeExistingVersions(installDir)
 	if err != nil {
 		return installFile, err
 	}
 
 	sm.progress.update(serviceId, 0.0, "Init")
 
-	groupPath := strings.ReplaceAll(group, ".", "/")
-	filename := fmt.Sprintf("%s-%s.tgz", url.PathEscape(artifact), url.PathEscape(version))
-	downloadUrl := sm.Config.ArtifactoryRepoUrl + path.Join("/", groupPath, url.PathEscape(artifact), url.PathEscape(version), filename)
-
 	progressWriter := ProgressWriter{
 		service:  serviceId,
 		renderer: &sm.progress,
 	}
 
-	serviceDir, err := sm.downloadAndDecompress(downloadUrl, installDir, &progressWriter)
+	serviceDir, err := sm.downloadAndDecompress(downloadUrl, installDir, runCmdOf(sm.Services[serviceId]), &progressWriter, span)
 	if err != nil {
+		// don't leave a half extracted install behind, the next start downloads it again
+		if cleanupErr := removeExistingVersions(installDir); cleanupErr != nil {
+			sm.PrintVerbose("unable to clean up %s: %s\n", installDir, cleanupErr)
+		}
+		if sm.context().Err() != nil {
+			return installFile, ErrInterrupted
+		}
 		return installFile, fmt.Errorf("failed %s", err)
 	}
 
+	// links its jars to the same ones other services use, see dedupe.go
+	if sm.dedupeOnInstall() {
+		if _, _, err := dedupeDir(sm.dedupeStore(), serviceDir); err != nil {
+			sm.PrintVerbose("unable to dedupe %s: %s\n", serviceId, err)
+		}
+		pruneDedupeStore(sm.dedupeStore())
+	}
+
 	installFile = ledger.InstallFile{
 		Service:  serviceId,
 		Artifact: artifact,
 		Version:  version,
 		Path:     serviceDir,
+		Url:      downloadUrl,
 		Created:  time.Now(),
 	}
 
@@ -143,50 +301,71 @@ func (sm *ServiceManager) installService(installDir string, serviceId string, gr
 	return installFile, err
 }
 
+// the script in the service's bin dir that runs it, see findServiceDir
+func runCmdOf(service Service) string {
+	if len(service.Binary.Cmd) == 0 {
+		return ""
+	}
+	return path.Base(service.Binary.Cmd[0])
+}
+
 // Given a service (config) some args and an installFile (code) run the service.
-func run(service Service, installFile ledger.InstallFile, args []string, port int) (ledger.StateFile, error) {
+// Any secrets in the args are filled in using lookup, but only the placeholders are kept in the state file.
+func run(service Service, installFile ledger.InstallFile, args []string, port int, bindAddress string, lookup secretLookup) (ledger.StateFile, error) {
 
 	serviceDir := installFile.Path
 	version := installFile.Version
 
 	// @TODO: check if pid is already running
 	removeRunningPid(serviceDir)
+	clearExitStatus(serviceDir)
 
-	logFile, err := os.Create(path.Join(serviceDir, "logs", "stdout.log"))
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, serviceDir))
 	if err != nil {
 		return ledger.StateFile{}, err
 	}
+	defer closeOutputs(stdout, stderr)
 
-	// patch the port number onto the arg list
-	args = append(args, fmt.Sprintf("-Dhttp.port=%d", port))
+	// patch the port number and address onto the arg list
+	args = append(args, fmt.Sprintf("-Dhttp.port=%d", port), "-Dhttp.address="+bindAddress)
+
+	launchArgs, err := resolveSecrets(fillPortArgs(args, port), lookup)
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
 
 	// this is a bit of a hack to get the old config working with the new installation
 	_, runCmd := path.Split(service.Binary.Cmd[0])
-	cmd := exec.Command(path.Join(serviceDir, "bin", runCmd), args...)
+	cmd := exec.Command(path.Join(serviceDir, "bin", runCmd), launchArgs...)
 	cmd.Dir = serviceDir
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
 
 	err = cmd.Start()
 	if err != nil {
 		return ledger.StateFile{}, err
 	}
+	go recordExitStatus(cmd, serviceDir)
 
 	state := ledger.StateFile{
-		Service:  service.Id,
-		Artifact: service.Binary.Artifact,
-		Version:  version,
-		Path:     serviceDir,
-		Started:  time.Now(),
-		Pid:      cmd.Process.Pid,
-		Port:     port,
-		Args:     args,
+		Service:     service.Id,
+		Artifact:    service.Binary.Artifact,
+		Version:     version,
+		Path:        serviceDir,
+		Started:     time.Now(),
+		Pid:         cmd.Process.Pid,
+		Port:        port,
+		BindAddress: bindAddress,
+		Args:        args,
+		Cmd:         cmd.Path,
+		Env:         filterEnv(cmd.Env, whyFailedEnvVars),
 	}
 
 	return state, nil
 }
 
-func verifyInstall(installFile ledger.InstallFile, service string, version string, offline bool) bool {
+func verifyInstall(installFile ledger.InstallFile, service string, version string, url string, offline bool) bool {
 
 	// verify its the right one
 	if installFile.Service != service {
@@ -199,6 +378,12 @@ func verifyInstall(installFile ledger.InstallFile, service string, version strin
 		return false
 	}
 
+	// same version from a different artifact or repo (e.g. a fork set in the overrides) also means a reinstall.
+	// installs from before the url was recorded are assumed to be right
+	if url != "" && installFile.Url != "" && installFile.Url != url && !offline {
+		return false
+	}
+
 	// verify its actually where it says it is
 	if _, err := os.Stat(installFile.Path); os.IsNotExist(err) {
 		return false
@@ -222,7 +407,7 @@ func defaultHealthcheckUrl(port int) string {
 
 func findHealthcheckUrl(service Service, port int) string {
 	if service.Healthcheck.Url != "" {
-		return strings.Replace(service.Healthcheck.Url, "${port}", fmt.Sprint(port), 1)
+		return fillPort(service.Healthcheck.Url, port)
 	}
 	return defaultHealthcheckUrl(port)
 }
@@ -309,14 +494,21 @@ func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, wg *s
 	for task := range tasks {
 
 		var err error
-		if sm.Commands.FromSource {
+		if sm.context().Err() != nil {
+			// sm2's been interrupted, so the rest aren't started
+			err = ErrInterrupted
+		} else if sm.Commands.FromSource {
 			err = sm.StartFromSource(task.service)
 		} else {
 			err = sm.StartService(task)
 		}
 
-		if err != nil {
-			if err.Error() != "Already running" {
+		sm.emitStarted(task, err)
+		if err == ErrInterrupted {
+			sm.progress.update(task.service, 100, "Interrupted")
+			sm.progress.error(task.service, err)
+		} else if err != nil {
+			if err != ErrAlreadyRunning {
 				sm.progress.update(task.service, 100, "Failed")
 			}
 			sm.progress.error(task.service, err)
@@ -334,11 +526,24 @@ func (sm *ServiceManager) startServiceWorker(tasks chan ServiceAndVersion, wg *s
 // A wait group is used to keep the app waiting for everything to finish downloading.
 func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) {
 
+	sm.tracer.StartRoot("sm2 start", "sm2.services", fmt.Sprint(len(services)))
+	began := time.Now()
+
+	// sort out any port collisions first, while it's still possible to prompt
+	var prompt *bufio.Reader
+	if isInteractive() {
+		prompt = bufio.NewReader(os.Stdin)
+	}
+	sm.planPorts(services, prompt, os.Stdout)
+
+	// look up the latest versions together, rather than as each service is started
+	defer sm.resolveLatestVersions(services)()
+
 	// fire up the progress bar renderer
 	sm.progress.noProgress = sm.Commands.NoProgress
 	sm.progress.getTerminalSize = sm.Platform.GetTerminalSize
-	go sm.progress.renderLoop()
 	sm.progress.init(services)
+	go sm.progress.renderLoop()
 	taskQueue := make(chan ServiceAndVersion, len(services))
 
 	if len(services) == 1 {
@@ -372,9 +577,17 @@ func (sm *ServiceManager) asyncStart(services []ServiceAndVersion) {
 	}
 
 	wg.Wait()
-	// @hack @hack waits a ms in the hope the renderloop finishes.
-	// this could be way better, wait groups, or force a final paint or something??
-	time.Sleep(time.Millisecond)
+	sm.progress.flush()
+
+	if sm.Commands.Timings {
+		timings := timingsFromSpans(sm.tracer.completed())
+		wait := timingsWait
+		if sm.Commands.Wait > 0 {
+			wait = time.Duration(sm.Commands.Wait) * time.Second
+		}
+		sm.awaitHealthyTimings(timings, wait)
+		printTimings(os.Stdout, timings, time.Since(began))
+	}
 
 	if sm.Commands.Wait > 0 {
 		fmt.Printf("Waiting %d secs for all services to start.", sm.Commands.Wait)
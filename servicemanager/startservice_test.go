@@ -1,11 +1,19 @@
 package servicemanager
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"time"
 
+	"sm2/ledger"
 	. "sm2/testing"
 )
 
@@ -54,6 +62,40 @@ func TestRemoveExistingVersion(t *testing.T) {
 	AssertDirExists(t, installDir)
 }
 
+func TestInstallServiceCleansUpAFailedDownload(t *testing.T) {
+	archive := bytes.Buffer{}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "foo-1.0.1/lib/foo.jar", Mode: 0644, Size: 100})
+	tw.Write(make([]byte, 100))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "foo-1.0.1/lib/bar.jar", Mode: 0644, Size: 100000})
+	tw.Write(make([]byte, 100000))
+	tw.Close()
+	gz.Close()
+
+	// the download's cut off part way through
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes()[:archive.Len()-100])
+	}))
+	defer svr.Close()
+
+	installDir := path.Join(t.TempDir(), "foo")
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		progress: ProgressRenderer{noProgress: true},
+	}
+	if _, err := sm.installService(installDir, "FOO", svr.URL, "foo_2.12", "1.0.1", nil); err == nil {
+		t.Fatalf("expected the install to fail")
+	}
+
+	AssertDirExists(t, installDir)
+	files, err := os.ReadDir(installDir)
+	AssertNotErr(t, err)
+	if len(files) != 0 {
+		t.Errorf("expected the partial install to be removed, found %v", files)
+	}
+}
+
 func TestRemoveRunningPid(t *testing.T) {
 	baseDir, err := ioutil.TempDir(os.TempDir(), "test-removeRunningPid*")
 	AssertNotErr(t, err)
@@ -175,6 +217,38 @@ func TestWhatVersionToRun(t *testing.T) {
 
 }
 
+func TestVerifyInstall(t *testing.T) {
+	installDir, err := ioutil.TempDir(os.TempDir(), "test-verifyInstall*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(installDir)
+
+	url := artifactUrl("https://artifactory/releases", "org.foo", "foo_2.13", "1.0.0")
+	if url != "https://artifactory/releases/org/foo/foo_2.13/1.0.0/foo_2.13-1.0.0.tgz" {
+		t.Errorf("wrong artifact url: %s", url)
+	}
+
+	install := ledger.InstallFile{Service: "FOO", Artifact: "foo_2.13", Version: "1.0.0", Path: installDir, Url: url}
+	fork := artifactUrl("https://artifactory/releases", "org.fork", "foo_2.13", "1.0.0")
+
+	if !verifyInstall(install, "FOO", "1.0.0", url, false) {
+		t.Error("expected the same version and artifact to be installed")
+	}
+	if verifyInstall(install, "FOO", "1.0.1", url, false) {
+		t.Error("expected a different version to need installing")
+	}
+	if verifyInstall(install, "FOO", "1.0.0", fork, false) {
+		t.Error("expected the same version from a different artifact to need installing")
+	}
+	if !verifyInstall(install, "FOO", "1.0.0", fork, true) {
+		t.Error("expected whatever is installed to be used offline")
+	}
+
+	install.Url = ""
+	if !verifyInstall(install, "FOO", "1.0.0", fork, false) {
+		t.Error("expected an install without a url to be used")
+	}
+}
+
 func TestFindHealthcheckUrl(t *testing.T) {
 	customCheck := Service{
 		Id:          "FOO",
@@ -197,3 +271,59 @@ func TestFindHealthcheckUrl(t *testing.T) {
 	}
 
 }
+
+func TestRunFromAPathWithSpacesAndAccents(t *testing.T) {
+	serviceDir := path.Join(t.TempDir(), "Zoë Smith", "workspace", "zoë's service-1.0.0")
+	AssertNotErr(t, os.MkdirAll(path.Join(serviceDir, "bin"), 0755))
+	// writes its args out, one per line, then exits
+	script := "#!/bin/sh\nfor a in \"$@\"; do printf '[%s]\\n' \"$a\"; done > \"$(dirname \"$0\")/../args.txt\"\n"
+	AssertNotErr(t, os.WriteFile(path.Join(serviceDir, "bin", "zoë service"), []byte(script), 0755))
+
+	service := Service{Id: "ZOE", Binary: ServiceBinary{Cmd: []string{"./zoë's service/bin/zoë service", "-Dmsg=hello world"}}}
+	installFile := ledger.InstallFile{Service: "ZOE", Version: "1.0.0", Path: serviceDir}
+	state, err := run(service, installFile, []string{"-Dmsg=hello world", "-Duser.home=" + path.Dir(serviceDir)}, 9000, "127.0.0.1", nil)
+	AssertNotErr(t, err)
+
+	argsFile := path.Join(serviceDir, "args.txt")
+	for i := 0; i < 100; i++ {
+		if data, err := os.ReadFile(argsFile); err == nil && strings.Count(string(data), "\n") == 4 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	data, err := os.ReadFile(argsFile)
+	AssertNotErr(t, err)
+	expected := "[-Dmsg=hello world]\n[-Duser.home=" + path.Dir(serviceDir) + "]\n[-Dhttp.port=9000]\n[-Dhttp.address=127.0.0.1]\n"
+	if string(data) != expected {
+		t.Errorf("expected the args to reach the service as they were, got\n%s", data)
+	}
+	if _, err := os.Stat(path.Join(serviceDir, "logs", "stdout.log")); err != nil {
+		t.Errorf("expected the service's logs to be written in its dir, got %s", err)
+	}
+	if state.Cmd != path.Join(serviceDir, "bin", "zoë service") {
+		t.Errorf("unexpected cmd %s", state.Cmd)
+	}
+}
+
+func TestRunOnlyKeepsTheEnvVarsWhyFailedShows(t *testing.T) {
+	t.Setenv("JAVA_HOME", "/opt/java")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	serviceDir := path.Join(t.TempDir(), "foo-1.0.0")
+	AssertNotErr(t, os.MkdirAll(path.Join(serviceDir, "bin"), 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(serviceDir, "bin", "foo"), []byte("#!/bin/sh\n"), 0755))
+
+	service := Service{Id: "FOO", Binary: ServiceBinary{Cmd: []string{"./foo/bin/foo"}}}
+	installFile := ledger.InstallFile{Service: "FOO", Version: "1.0.0", Path: serviceDir}
+	state, err := run(service, installFile, nil, 9000, "127.0.0.1", nil)
+	AssertNotErr(t, err)
+
+	// it's saved in the state file, so tokens and keys in the environment would end up on disk
+	for _, e := range state.Env {
+		if strings.HasPrefix(e, "AWS_SECRET_ACCESS_KEY=") {
+			t.Errorf("expected the rest of the environment not to be kept, got %v", state.Env)
+		}
+	}
+	if !strings.Contains(strings.Join(state.Env, "\n"), "JAVA_HOME=/opt/java") {
+		t.Errorf("expected JAVA_HOME to be kept, got %v", state.Env)
+	}
+}
@@ -0,0 +1,79 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestCorruptStateFilesAreRecoveredFromTheirBackup(t *testing.T) {
+	installDir := t.TempDir()
+	l := ledger.NewLedger()
+	stateFile := path.Join(installDir, ".state")
+
+	AssertNotErr(t, l.SaveStateFile(installDir, ledger.StateFile{Service: "AUTH", Pid: 1001}))
+	AssertNotErr(t, l.SaveStateFile(installDir, ledger.StateFile{Service: "AUTH", Pid: 1002}))
+	if state, err := l.LoadStateFile(installDir); err != nil || state.Pid != 1002 {
+		t.Errorf("expected the latest state, got %v %v", state, err)
+	}
+
+	// cut off part way through, as if the disk filled up
+	data, err := os.ReadFile(stateFile)
+	AssertNotErr(t, err)
+	AssertNotErr(t, os.WriteFile(stateFile, data[:len(data)/2], 0644))
+	if state, err := l.LoadStateFile(installDir); err != nil || state.Pid != 1001 {
+		t.Errorf("expected the backup to be recovered, got %v %v", state, err)
+	}
+	// and it's been put back
+	if _, err := l.LoadStateFile(installDir); err != nil {
+		t.Errorf("expected the recovered state file to be intact, got %s", err)
+	}
+
+	// a change that still parses is caught by the checksum
+	data, _ = os.ReadFile(stateFile)
+	tampered := strings.Replace(string(data), `"Pid":1001`, `"Pid":1009`, 1)
+	if tampered == string(data) {
+		t.Fatalf("expected the pid in %s", data)
+	}
+	AssertNotErr(t, os.Remove(path.Join(installDir, ".state.bak")))
+	AssertNotErr(t, os.WriteFile(stateFile, []byte(tampered), 0644))
+	if _, err := l.LoadStateFile(installDir); err == nil {
+		t.Errorf("expected a state file that doesn't match its checksum, without a backup, to be an error")
+	}
+
+	AssertNotErr(t, l.ClearStateFile(installDir))
+	if _, err := os.Stat(path.Join(installDir, ".state.bak")); !os.IsNotExist(err) {
+		t.Errorf("expected the backup to be cleared along with the state file")
+	}
+}
+
+func TestStateFilesWithoutAChecksumAreRead(t *testing.T) {
+	installDir := t.TempDir()
+	AssertNotErr(t, os.WriteFile(path.Join(installDir, ".state"), []byte(`{"Service": "AUTH", "Pid": 1001}`), 0644))
+	if state, err := ledger.NewLedger().LoadStateFile(installDir); err != nil || state.Service != "AUTH" || state.Pid != 1001 {
+		t.Errorf("expected the old state file to be read, got %v %v", state, err)
+	}
+}
+
+func TestStateFilesAreSavedInUtc(t *testing.T) {
+	installDir := t.TempDir()
+	l := ledger.NewLedger()
+	started := time.Date(2026, 3, 29, 2, 30, 0, 0, time.FixedZone("BST", 60*60))
+
+	AssertNotErr(t, l.SaveStateFile(installDir, ledger.StateFile{Service: "AUTH", Pid: 1001, Started: started}))
+	data, err := os.ReadFile(path.Join(installDir, ".state"))
+	AssertNotErr(t, err)
+	if !strings.Contains(string(data), `"Started":"2026-03-29T01:30:00Z"`) {
+		t.Errorf("expected the start time to be saved in utc, got %s", data)
+	}
+	state, err := l.LoadStateFile(installDir)
+	AssertNotErr(t, err)
+	if !state.Started.Equal(started) {
+		t.Errorf("expected %s, got %s", started, state.Started)
+	}
+}
@@ -1,6 +1,7 @@
 package servicemanager
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +10,8 @@ import (
 	"sm2/ledger"
 	"sort"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -22,20 +25,36 @@ const (
 	GRACE_SOURCE  float64 = 60
 )
 
+const (
+	// how many health checks -status makes at the same time
+	statusWorkers = 16
+	// how long -status waits for each one
+	statusProbeTimeout = 2 * time.Second
+)
+
 type serviceStatus struct {
-	pid     int
-	port    int
-	service string
-	version string
-	health  health
+	pid         int
+	port        int
+	service     string
+	version     string
+	health      health
+	bindAddress string
+	portPid     int // what's listening on its port when it isn't the service, see portowner.go
 }
 
 func (sm *ServiceManager) PrintStatus() {
-	statuses := []serviceStatus{sm.CheckMongo()}
-	statuses = append(statuses, sm.findStatuses()...)
+	statuses := withMongo(sm.findStatuses(), sm.CheckMongo)
 	unmanaged := []serviceStatus{}
 	proxyState := sm.Ledger.LoadProxyState(sm.Config.TmpDir)
 
+	if sm.Commands.Format != "" {
+		if err := printTemplate(statuses, sm.Commands.Format, os.Stdout); err != nil {
+			fmt.Printf("Invalid --format template: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	termWidth, _ := sm.Platform.GetTerminalSize()
 	if sm.Commands.FormatPlain || termWidth < 80 {
 		printPlainText(statuses, os.Stdout)
@@ -50,6 +69,8 @@ func (sm *ServiceManager) PrintStatus() {
 		longestServiceName := getLongestServiceName(append(statuses, unmanaged...))
 		printTable(statuses, termWidth, longestServiceName, os.Stdout)
 		printHelpIfRequired(statuses, sm.Commands.DelaySeconds)
+		printExposedServices(statuses, os.Stdout)
+		printPortImpostors(statuses, os.Stdout)
 
 		if len(unmanaged) > 0 {
 			fmt.Print("\n\033[34mAlso, the following processes are running which occupy ports of services\n")
@@ -66,6 +87,16 @@ func (sm *ServiceManager) PrintStatus() {
 	}
 }
 
+// mongo is shown even if sm2 didn't start it, unless sm2 is running the built in MONGO service
+func withMongo(statuses []serviceStatus, checkMongo func() serviceStatus) []serviceStatus {
+	for _, status := range statuses {
+		if status.service == infraTypes["mongo"].id {
+			return statuses
+		}
+	}
+	return append([]serviceStatus{checkMongo()}, statuses...)
+}
+
 func (sm *ServiceManager) findUnmanagedServices(knownStatuses []serviceStatus) []serviceStatus {
 	statuses := []serviceStatus{}
 
@@ -100,6 +131,34 @@ func (sm *ServiceManager) findUnmanagedServices(knownStatuses []serviceStatus) [
 
 func (sm *ServiceManager) findStatuses() []serviceStatus {
 
+	// find all the state files in the base dir...
+	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
+	if err != nil {
+		fmt.Printf("Unable to read state files in %s: %s\n", sm.Config.TmpDir, err)
+		return []serviceStatus{}
+	}
+	return sm.findStatusesOf(states)
+}
+
+// the status of one service, loading just its own state file rather than every one in the workspace
+func (sm *ServiceManager) findStatus(serviceName string) (serviceStatus, bool) {
+	installDir, err := sm.findInstallDirOfService(serviceName)
+	if err != nil {
+		return serviceStatus{}, false
+	}
+	state, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil || state.Service != serviceName {
+		return serviceStatus{}, false
+	}
+	statuses := sm.findStatusesOf([]ledger.StateFile{state})
+	if len(statuses) == 0 {
+		return serviceStatus{}, false
+	}
+	return statuses[0], true
+}
+
+func (sm *ServiceManager) findStatusesOf(states []ledger.StateFile) []serviceStatus {
+
 	statuses := []serviceStatus{}
 
 	// get how long system has been up so we can exclude services that we stopped due to reboot
@@ -108,22 +167,18 @@ func (sm *ServiceManager) findStatuses() []serviceStatus {
 	// get a set of all pids
 	pids := sm.Platform.PidLookup()
 
-	// find all the state files in the base dir...
-	states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir)
-	if err != nil {
-		fmt.Printf("Unable to read state files in %s: %s\n", sm.Config.TmpDir, err)
-		return statuses
-	}
+	// the services that are running, which are health checked all at once after
+	running := []ledger.StateFile{}
+	urls := []string{}
 
 	// for each state file
 	for _, state := range states {
 
-		status := serviceStatus{
-			pid:     state.Pid,
-			port:    state.Port,
-			service: state.Service,
-			version: state.Version,
-			health:  BOOT,
+		// the service may have forked and exited, see forked.go
+		if _, ok := pids[state.Pid]; !ok && !state.Started.Before(bootTime) {
+			if pid, started, found := sm.findForkedPid(state, pids); found {
+				state = sm.adoptForkedPid(state, pid, started)
+			}
 		}
 
 		if _, ok := pids[state.Pid]; ok {
@@ -131,35 +186,54 @@ func (sm *ServiceManager) findStatuses() []serviceStatus {
 			if url == "" {
 				url = defaultHealthcheckUrl(state.Port)
 			}
-			if sm.CheckHealth(url) {
-				status.health = PASS
-			} else {
-				// if boot grace period has passed, it fails
-				grace := GRACE_RELEASE
-				if status.version == SOURCE {
-					println("grace from source")
-					grace = GRACE_SOURCE
-				}
-				if time.Since(state.Started).Seconds() > grace {
-					status.health = FAIL
+			running = append(running, state)
+			urls = append(urls, url)
+			continue
+		}
+
+		// service is not running...
+
+		// ignore services that were started before the os started
+		if state.Started.Before(bootTime) {
+			// clean up state file
+			installDir, err := sm.findInstallDirOfService(state.Service)
+			if err == nil {
+				err = sm.clearState(installDir)
+				if err != nil {
+					fmt.Printf("Error clearing %s state file: %s", state.Service, err)
 				}
 			}
+			continue
+		}
+		statuses = append(statuses, newServiceStatus(state, FAIL))
+	}
+
+	healthy := sm.checkRunning(running, urls)
+	passed := []ledger.StateFile{}
+	for i, state := range running {
+		if healthy[i] {
+			passed = append(passed, state)
+		}
+	}
+	impostors := sm.findPortImpostors(passed)
+
+	for i, state := range running {
+		status := newServiceStatus(state, BOOT)
+		if pid, ok := impostors[state.Service]; ok {
+			status.health = FAIL
+			status.portPid = pid
+		} else if healthy[i] {
+			status.health = PASS
 		} else {
-			// service is not running...
-
-			// ignore services that were started before the os started
-			if state.Started.Before(bootTime) {
-				// clean up state file
-				installDir, err := sm.findInstallDirOfService(state.Service)
-				if err == nil {
-					err = sm.Ledger.ClearStateFile(installDir)
-					if err != nil {
-						fmt.Printf("Error clearing %s state file: %s", state.Service, err)
-					}
-				}
-				continue
+			// if boot grace period has passed, it fails
+			grace := GRACE_RELEASE
+			if status.version == SOURCE {
+				println("grace from source")
+				grace = GRACE_SOURCE
+			}
+			if time.Since(state.Started).Seconds() > grace {
+				status.health = FAIL
 			}
-			status.health = FAIL
 		}
 		statuses = append(statuses, status)
 	}
@@ -171,6 +245,17 @@ func (sm *ServiceManager) findStatuses() []serviceStatus {
 	return statuses
 }
 
+func newServiceStatus(state ledger.StateFile, health health) serviceStatus {
+	return serviceStatus{
+		pid:         state.Pid,
+		port:        state.Port,
+		service:     state.Service,
+		version:     state.Version,
+		health:      health,
+		bindAddress: state.BindAddress,
+	}
+}
+
 func (sm *ServiceManager) cleanupFailedServices() {
 	statuses := sm.findStatuses()
 
@@ -180,7 +265,7 @@ func (sm *ServiceManager) cleanupFailedServices() {
 			// clean up state file
 			installDir, err := sm.findInstallDirOfService(status.service)
 			if err == nil {
-				err = sm.Ledger.ClearStateFile(installDir)
+				err = sm.clearState(installDir)
 				if err != nil {
 					fmt.Printf("Error clearing %s state file: %s\n", status.service, err)
 				} else {
@@ -197,6 +282,40 @@ func printPlainText(statuses []serviceStatus, out io.Writer) {
 	}
 }
 
+// the fields available to --format templates
+type statusTemplateData struct {
+	Name    string
+	Version string
+	Pid     int
+	Port    int
+	Status  string
+	Address string
+}
+
+// prints each status using a user supplied go template, a newline is added after each one
+func printTemplate(statuses []serviceStatus, format string, out io.Writer) error {
+	tmpl, err := template.New("status").Parse(format)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		data := statusTemplateData{
+			Name:    status.service,
+			Version: status.version,
+			Pid:     status.pid,
+			Port:    status.port,
+			Status:  string(status.health),
+			Address: status.bindAddress,
+		}
+		if err := tmpl.Execute(out, data); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
 func printProxyPlainText(proxyState ledger.ProxyState, out *os.File) {
 	fmt.Fprintf(out, "Reverse Proxy Running with PID %d", proxyState.Pid)
 	for path, port := range proxyState.ProxyPaths {
@@ -214,7 +333,7 @@ const (
 
 func getLongestServiceName(statuses []serviceStatus) int {
 	var serviceNames []string
-	for _, s := range append(statuses) {
+	for _, s := range statuses {
 		serviceNames = append(serviceNames, s.service)
 	}
 	return getLongestString(serviceNames)
@@ -357,11 +476,77 @@ func printHelpIfRequired(statuses []serviceStatus, delay int) {
 
 // returns true if the service ping endpoint responds
 func (sm *ServiceManager) CheckHealth(url string) bool {
-	ctx := sm.NewShortContext()
+	healthy, _ := sm.checkHealthTimed(url)
+	return healthy
+}
+
+// same as CheckHealth but also returns how long the healthcheck took to respond
+func (sm *ServiceManager) checkHealthTimed(url string) (bool, time.Duration) {
+	start := time.Now()
+	healthy := sm.probeHealth(url)
+	return healthy, time.Since(start)
+}
+
+// checks many services at once on a few workers, for -status with lots of services running. The services
+// are local, so a service that hasn't answered within statusProbeTimeout isn't going to.
+func (sm *ServiceManager) checkHealthOfAll(urls []string) []bool {
+	healthy := make([]bool, len(urls))
+	tasks := make(chan int, len(urls))
+	for i := range urls {
+		tasks <- i
+	}
+	close(tasks)
+
+	workers := statusWorkers
+	if len(urls) < workers {
+		workers = len(urls)
+	}
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				ctx, cancel := sm.newStatusContext()
+				healthy[i] = sm.probeHealthContext(ctx, urls[i])
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	return healthy
+}
+
+// the short timeout, or statusProbeTimeout if that's shorter
+func (sm *ServiceManager) newStatusContext() (context.Context, context.CancelFunc) {
+	if sm.Config.TimeoutShort > 0 && sm.Config.TimeoutShort < statusProbeTimeout {
+		return sm.NewShortContext()
+	}
+	return context.WithTimeout(context.Background(), statusProbeTimeout)
+}
+
+func (sm *ServiceManager) probeHealth(url string) bool {
+	ctx, cancel := sm.NewShortContext()
+	defer cancel()
+	return sm.probeHealthContext(ctx, url)
+}
+
+func (sm *ServiceManager) probeHealthContext(ctx context.Context, url string) bool {
+	if strings.HasPrefix(url, "tcp://") {
+		return probeTcp(ctx, url)
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false
+	}
 
 	resp, err := sm.Client.Do(req)
-	return err == nil && resp.StatusCode == 200
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == 200
 }
 
 // v.basic mongo check that just sees if the port is open
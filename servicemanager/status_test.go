@@ -127,13 +127,13 @@ func TestFindStatusesSortsResultsAlphabetically(t *testing.T) {
 func TestStatusWrapsServiceNames(t *testing.T) {
 	sb := bytes.NewBufferString("")
 	statuses := []serviceStatus{
-		serviceStatus{0, 1, "SHORT_ID", "1.2.3", "PASS"},
-		serviceStatus{123, 10801, "THE_SERVICE_IS_35_CHARS_DO_NOT_WRAP", "42.999.1", "PASS"},
-		serviceStatus{2, 3, "SERVICE_IS_38_CHARS_STILL_CROP_IT_OKAY", "1.5", "PASS"},
-		serviceStatus{3, 4, "SERVICE_IS_39_CHARS_SO_WRAP_OVERFLOW_OK", "2.8", "PASS"},
-		serviceStatus{4, 5, "SERVICE_IS_54_CHARS_SO_DEFINITELY_WRAP_THE_OVERFLOW_OK", "3.1", "PASS"},
-		serviceStatus{5, 6, "SERVICE_IS_73_CHARS_SO_DEFINITELY_CROP_THE_SECOND_LINE_SO_NO_3RD_OVERFLOW", "3.2", "PASS"},
-		serviceStatus{6, 7, "SERVICE_IS_74_CHARS_SO_DEFINITELY_WRAP_THE_3RD_LINE_SO_WE_CAN_SEE_OVERFLOW", "3.3", "PASS"},
+		serviceStatus{0, 1, "SHORT_ID", "1.2.3", "PASS", "", 0},
+		serviceStatus{123, 10801, "THE_SERVICE_IS_35_CHARS_DO_NOT_WRAP", "42.999.1", "PASS", "", 0},
+		serviceStatus{2, 3, "SERVICE_IS_38_CHARS_STILL_CROP_IT_OKAY", "1.5", "PASS", "", 0},
+		serviceStatus{3, 4, "SERVICE_IS_39_CHARS_SO_WRAP_OVERFLOW_OK", "2.8", "PASS", "", 0},
+		serviceStatus{4, 5, "SERVICE_IS_54_CHARS_SO_DEFINITELY_WRAP_THE_OVERFLOW_OK", "3.1", "PASS", "", 0},
+		serviceStatus{5, 6, "SERVICE_IS_73_CHARS_SO_DEFINITELY_CROP_THE_SECOND_LINE_SO_NO_3RD_OVERFLOW", "3.2", "PASS", "", 0},
+		serviceStatus{6, 7, "SERVICE_IS_74_CHARS_SO_DEFINITELY_WRAP_THE_3RD_LINE_SO_WE_CAN_SEE_OVERFLOW", "3.3", "PASS", "", 0},
 	}
 	expectedOutput :=
 		`+---------------------------------------+-----------+---------+-------+--------+
@@ -177,8 +177,8 @@ func TestStatusWrapsServiceNames(t *testing.T) {
 func TestStatusExpandsServiceName(t *testing.T) {
 	sb := bytes.NewBufferString("")
 	statuses := []serviceStatus{
-		serviceStatus{0, 1, "SHORT_ID", "1.2.3", "PASS"},
-		serviceStatus{6, 7, "SERVICE_IS_VERY_LONG_LIKE_REALLY_REALLY_LONG_BUT_WERE_OK", "3.3", "PASS"},
+		serviceStatus{0, 1, "SHORT_ID", "1.2.3", "PASS", "", 0},
+		serviceStatus{6, 7, "SERVICE_IS_VERY_LONG_LIKE_REALLY_REALLY_LONG_BUT_WERE_OK", "3.3", "PASS", "", 0},
 	}
 	expectedOutput := `+----------------------------------------------------------+-----------+---------+-------+--------+
 | Name                                                     | Version   | PID     | Port  | Status |
@@ -217,7 +217,12 @@ func TestBSTUptimeBug(t *testing.T) {
 	var state ledger.StateFile
 	json.Unmarshal([]byte(jsonState), &state)
 
-	bootTime, err := time.ParseInLocation("2006-01-02 15:04:05", uptimeStr, time.Local)
+	// uptime -s prints the boot time in the machine's timezone, which was london's summer time
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	bootTime, err := time.ParseInLocation("2006-01-02 15:04:05", uptimeStr, london)
 	if err != nil {
 		t.Error(err)
 	}
@@ -381,9 +386,9 @@ func TestVerifyIsRunning(t *testing.T) {
 	}
 
 	statuses := []serviceStatus{
-		{0, 0, "FOO", "1.0.0", PASS},
-		{0, 0, "BAZ", "1.0.0", PASS},
-		{0, 0, "BAR", "1.0.0", PASS},
+		{0, 0, "FOO", "1.0.0", PASS, "", 0},
+		{0, 0, "BAZ", "1.0.0", PASS, "", 0},
+		{0, 0, "BAR", "1.0.0", PASS, "", 0},
 	}
 
 	output := bytes.NewBufferString("")
@@ -484,3 +489,83 @@ func TestStatusExpandsProxyPaths(t *testing.T) {
 		}
 	}
 }
+
+func TestPrintTemplate(t *testing.T) {
+	sb := bytes.NewBufferString("")
+	statuses := []serviceStatus{
+		{123, 8080, "FOO", "1.2.3", PASS, "", 0},
+		{456, 9090, "BAR", "0.1.0", BOOT, "", 0},
+	}
+
+	err := printTemplate(statuses, "{{.Name}} {{.Port}} {{.Version}} {{.Pid}} {{.Status}}", sb)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "FOO 8080 1.2.3 123 PASS\nBAR 9090 0.1.0 456 BOOT\n"
+	if sb.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
+
+func TestPrintTemplateInvalid(t *testing.T) {
+	sb := bytes.NewBufferString("")
+	if err := printTemplate([]serviceStatus{}, "{{.Name", sb); err == nil {
+		t.Errorf("expected an invalid template to error")
+	}
+	if err := printTemplate([]serviceStatus{{service: "FOO"}}, "{{.Nope}}", sb); err == nil {
+		t.Errorf("expected an unknown field to error")
+	}
+}
+
+func TestFindStatusesChecksHealthConcurrently(t *testing.T) {
+	hung := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hung" {
+			<-hung
+		}
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer svr.Close()
+	defer close(hung)
+
+	pids := map[int]int{}
+	states := []ledger.StateFile{}
+	for i := 0; i < 40; i++ {
+		pids[1000+i] = 1000 + i
+		states = append(states, ledger.StateFile{Service: fmt.Sprintf("SERVICE_%02d", i), Pid: 1000 + i, Started: time.Now().Add(-time.Hour), HealthcheckUrl: svr.URL + "/ping"})
+	}
+	states[7].HealthcheckUrl = svr.URL + "/hung"
+
+	sm := ServiceManager{
+		Client: &http.Client{},
+		Config: ServiceManagerConfig{TimeoutShort: 20 * time.Second},
+		Platform: platform.Platform{
+			Uptime:    mockUptime,
+			PidLookup: func() map[int]int { return pids },
+		},
+		Ledger: ledger.Ledger{
+			FindAllStateFiles: func(_ string) ([]ledger.StateFile, error) {
+				return states, nil
+			},
+		},
+	}
+
+	start := time.Now()
+	statuses := sm.findStatuses()
+	if took := time.Since(start); took > statusProbeTimeout+time.Second {
+		t.Errorf("expected the health checks to be made at the same time, took %s", took)
+	}
+	if len(statuses) != 40 {
+		t.Fatalf("expected 40 statuses, got %d", len(statuses))
+	}
+	for i, status := range statuses {
+		expected := PASS
+		if i == 7 {
+			expected = FAIL
+		}
+		if status.service != states[i].Service || status.health != expected {
+			t.Errorf("expected %s to be %s, got %s %s", states[i].Service, expected, status.service, status.health)
+		}
+	}
+}
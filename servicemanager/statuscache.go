@@ -0,0 +1,116 @@
+package servicemanager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sm2/ledger"
+)
+
+// The daemon, and sm2 -s -watch, find the status of every service over and over. Rather than health checking
+// all of them each time, the last result for each service is kept, and a service is only checked again when
+// its process has changed (it's been restarted, or moved port), it's still booting, it's been started or
+// stopped through the api, or its last check is older than statusCacheTtl. The daemon's own health checks
+// keep the results fresh.
+
+const (
+	statusCacheTtl = DEFAULT_DAEMON_INTERVAL
+	watchInterval  = time.Second
+)
+
+type cachedHealth struct {
+	pid     int
+	port    int
+	started time.Time
+	url     string
+	healthy bool
+	checked time.Time
+}
+
+type statusCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cachedHealth // by service
+}
+
+func newStatusCache(ttl time.Duration) *statusCache {
+	return &statusCache{ttl: ttl, now: time.Now, entries: map[string]cachedHealth{}}
+}
+
+// the last health check of the service, if it's the same process on the same port and the result is recent
+func (c *statusCache) lookup(state ledger.StateFile, url string) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[state.Service]
+	if !ok || entry.pid != state.Pid || entry.port != state.Port || !entry.started.Equal(state.Started) || entry.url != url {
+		return false, false
+	}
+	if c.now().Sub(entry.checked) > c.ttl {
+		return false, false
+	}
+	// booting services are checked every time, so they're shown as up as soon as they are
+	if !entry.healthy && c.now().Sub(state.Started).Seconds() < GRACE_SOURCE {
+		return false, false
+	}
+	return entry.healthy, true
+}
+
+func (c *statusCache) record(state ledger.StateFile, url string, healthy bool) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[state.Service] = cachedHealth{pid: state.Pid, port: state.Port, started: state.Started, url: url, healthy: healthy, checked: c.now()}
+}
+
+// for services that have been started or stopped, so they're checked again
+func (c *statusCache) forget(service string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, service)
+}
+
+// health checks the running services, other than those with a recent result in the cache
+func (sm *ServiceManager) checkRunning(running []ledger.StateFile, urls []string) []bool {
+	healthy := make([]bool, len(running))
+	unchecked := []int{}
+	for i, state := range running {
+		if result, ok := sm.statuses.lookup(state, urls[i]); ok {
+			healthy[i] = result
+		} else {
+			unchecked = append(unchecked, i)
+		}
+	}
+
+	toCheck := []string{}
+	for _, i := range unchecked {
+		toCheck = append(toCheck, urls[i])
+	}
+	for j, result := range sm.checkHealthOfAll(toCheck) {
+		i := unchecked[j]
+		healthy[i] = result
+		sm.statuses.record(running[i], urls[i], result)
+	}
+	return healthy
+}
+
+// redraws the status every watchInterval until it's interrupted
+func (sm *ServiceManager) WatchStatus() {
+	sm.statuses = newStatusCache(statusCacheTtl)
+	for {
+		// clears the screen, and moves to the top of it
+		fmt.Print("\033[H\033[2J")
+		sm.PrintStatus()
+		fmt.Printf("\nEvery %s, press Ctrl-C to stop\n", watchInterval)
+		time.Sleep(watchInterval)
+	}
+}
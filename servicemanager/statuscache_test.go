@@ -0,0 +1,91 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+)
+
+func TestStatusCacheOnlyChecksServicesThatHaveChanged(t *testing.T) {
+	probes := int32(0)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	defer svr.Close()
+
+	started := time.Now().Add(-time.Hour)
+	states := []ledger.StateFile{
+		{Service: "AUTH", Pid: 1001, Port: 8500, Started: started, HealthcheckUrl: svr.URL + "/auth"},
+		{Service: "EMAIL", Pid: 1002, Port: 8300, Started: started, HealthcheckUrl: svr.URL + "/email"},
+	}
+	pids := map[int]int{1001: 1001, 1002: 1002, 1003: 1003}
+	now := time.Now()
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		Platform: platform.Platform{Uptime: mockUptime, PidLookup: func() map[int]int { return pids }},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return states, nil
+		}},
+		statuses: newStatusCache(10 * time.Second),
+	}
+	sm.statuses.now = func() time.Time { return now }
+	checks := func() int {
+		before := atomic.LoadInt32(&probes)
+		for _, status := range sm.findStatuses() {
+			if status.health != PASS {
+				t.Errorf("expected %s to be healthy, got %s", status.service, status.health)
+			}
+		}
+		return int(atomic.LoadInt32(&probes) - before)
+	}
+
+	if n := checks(); n != 2 {
+		t.Errorf("expected both services to be checked the first time, got %d", n)
+	}
+	if n := checks(); n != 0 {
+		t.Errorf("expected nothing to be checked again, got %d", n)
+	}
+
+	// restarted
+	states[1].Pid = 1003
+	if n := checks(); n != 1 {
+		t.Errorf("expected only the restarted service to be checked, got %d", n)
+	}
+
+	// started or stopped through the api
+	sm.statuses.forget("AUTH")
+	if n := checks(); n != 1 {
+		t.Errorf("expected only the forgotten service to be checked, got %d", n)
+	}
+
+	now = now.Add(11 * time.Second)
+	if n := checks(); n != 2 {
+		t.Errorf("expected both services to be checked once their results are old, got %d", n)
+	}
+}
+
+func TestStatusCacheChecksBootingServicesEveryTime(t *testing.T) {
+	cache := newStatusCache(time.Minute)
+	booting := ledger.StateFile{Service: "AUTH", Pid: 1001, Port: 8500, Started: time.Now()}
+	cache.record(booting, "http://localhost:8500/ping/ping", false)
+	if _, ok := cache.lookup(booting, "http://localhost:8500/ping/ping"); ok {
+		t.Errorf("expected a booting service to be checked again")
+	}
+
+	cache.record(booting, "http://localhost:8500/ping/ping", true)
+	if healthy, ok := cache.lookup(booting, "http://localhost:8500/ping/ping"); !ok || !healthy {
+		t.Errorf("expected the service to be healthy without checking it again")
+	}
+
+	// a nil cache checks everything
+	var none *statusCache
+	none.record(booting, "http://localhost:8500/ping/ping", true)
+	if _, ok := none.lookup(booting, "http://localhost:8500/ping/ping"); ok {
+		t.Errorf("expected nothing to be cached")
+	}
+}
@@ -7,10 +7,13 @@ import (
 
 func (sm *ServiceManager) StopService(serviceName string) error {
 
-	// @improve just load the state file instead and kill the listed pid?
-	statuses := sm.findStatuses()
+	if status, ok := sm.findStatus(serviceName); ok {
+		sm.stop(status)
+		return nil
+	}
 
-	for _, status := range statuses {
+	// it may have been started from a different install dir, e.g. before the config changed
+	for _, status := range sm.findStatuses() {
 		if status.service == serviceName {
 			sm.stop(status)
 			return nil
@@ -24,6 +27,7 @@ func (sm *ServiceManager) StopService(serviceName string) error {
 	}
 
 	fmt.Printf("Unable to find service %s\n", serviceName)
+	sm.emit(machineEvent{Event: "not-running", Service: serviceName})
 	return nil
 }
 
@@ -54,16 +58,36 @@ func (sm *ServiceManager) stop(status serviceStatus) {
 			fmt.Printf("Unable to find pid for service started from source %s.\n", serviceName)
 			return
 		}
+	} else if err := sm.checkServicePid(serviceName, status.pid); err != nil {
+		// the service has died and something else has its pid, see stalepid.go
+		fmt.Printf("Not stopping %s, %s\n", serviceName, err)
+	} else if status.version == TUNNEL {
+		fmt.Printf("Stopping %-40s(tunnel, pid %-7d).\n", serviceName, status.pid)
+		stopTunnel(status.pid)
+	} else if status.version == NATIVE {
+		fmt.Printf("Stopping %-40s(pid %-7d).\n", serviceName, status.pid)
+		stopNative(status.pid)
+	} else if sm.isContainer(serviceName) {
+		fmt.Printf("Stopping %-40s(container %s).\n", serviceName, containerName(serviceName))
+		stopContainer(serviceName, status.pid)
 	} else {
 		// run from release, kill the pid in the .state file
 		fmt.Printf("Stopping %-40s(pid %-7d).\n", serviceName, status.pid)
 		stopPid(status.pid)
 	}
 
-	// clean up service.state
+	if err := sm.deregister(serviceName); err != nil {
+		fmt.Printf("Unable to deregister %s: %s\n", serviceName, err)
+	}
+
+	// clean up service.state, and the proxy capturing its traffic if there is one
 	if installDir, err := sm.findInstallDirOfService(serviceName); err == nil { // ok
-		sm.Ledger.ClearStateFile(installDir)
+		if state, err := sm.Ledger.LoadStateFile(installDir); err == nil && state.CapturePid > 0 {
+			stopPid(state.CapturePid)
+		}
+		sm.clearState(installDir)
 	}
+	sm.emit(machineEvent{Event: "stopped", Service: serviceName, Version: status.version, Pid: status.pid})
 
 }
 
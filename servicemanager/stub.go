@@ -0,0 +1,210 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services you don't want to run can be started as stubs instead, so the services that call them get a
+// response rather than connection refused, e.g.
+//
+//	sm2 -start MY_PROFILE -stub PAYMENTS,EMAIL
+//
+// A stub listens on the service's port, answers its health check, and serves any canned responses
+// the service's config has, keyed by path with an optional method, and a trailing * to match a prefix:
+//
+//	"stub": {
+//	  "GET /payments/status": {"status": 200, "body": "{\"enabled\": true}", "headers": {"Content-Type": "application/json"}},
+//	  "/email/*":             {"status": 202}
+//	}
+//
+// Anything else gets a 404. The stub is sm2 itself, run in the background with -serve-stub.
+
+const STUB = "stub" // the version shown in status
+
+// keyed by path, optionally with a method before it
+type StubResponses map[string]StubResponse
+
+type StubResponse struct {
+	Status  int               `json:"status"` // defaults to 200
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+}
+
+// written to the service's install dir for the stub process to serve
+type stubConfig struct {
+	Service   string        `json:"service"`
+	Address   string        `json:"address"`
+	Responses StubResponses `json:"responses"`
+}
+
+const stubConfigFile = "stub.json"
+
+// the services named by -stub
+func (sm *ServiceManager) isStubbed(serviceName string) bool {
+	for _, name := range strings.Split(sm.Commands.Stub, ",") {
+		if strings.TrimSpace(name) == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+func (sm *ServiceManager) startStub(service Service, installDir string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return ledger.StateFile{}, err
+	}
+	clearExitStatus(installDir)
+
+	bindAddress := sm.bindAddress(service)
+	config := stubConfig{
+		Service:   service.Id,
+		Address:   net.JoinHostPort(bindAddress, fmt.Sprint(port)),
+		Responses: stubResponses(service, healthcheckUrl),
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	configFile := path.Join(installDir, stubConfigFile)
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		return ledger.StateFile{}, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	args := []string{"-serve-stub", configFile}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+	// otherwise ctrl-c while sm2 is waiting for services to start would stop the stub too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Version:        STUB,
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    bindAddress,
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            exe,
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
+	}, nil
+}
+
+// the service's canned responses, plus one for its health check unless it has its own
+func stubResponses(service Service, healthcheckUrl string) StubResponses {
+	responses := StubResponses{}
+	for key, response := range service.Stub {
+		responses[key] = response
+	}
+
+	if u, err := url.Parse(healthcheckUrl); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		healthPath := u.Path
+		if healthPath == "" {
+			healthPath = "/"
+		}
+		if _, ok := responses[healthPath]; !ok {
+			body := service.Healthcheck.Response
+			if body == "" {
+				body = "pong"
+			}
+			responses[healthPath] = StubResponse{Status: 200, Body: body}
+		}
+	}
+	return responses
+}
+
+// runs the stub server described by the file until it's stopped, used by -serve-stub
+func ServeStub(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	config := stubConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s is not a valid stub config: %s", file, err)
+	}
+
+	fmt.Printf("%s stub listening on %s\n", config.Service, config.Address)
+	return http.ListenAndServe(config.Address, stubHandler(config, os.Stdout))
+}
+
+func stubHandler(config stubConfig, log io.Writer) http.Handler {
+	// longest first, so the most specific prefix wins
+	keys := []string{}
+	for key := range config.Responses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, key := range keys {
+			if stubMatches(key, req) {
+				response := config.Responses[key]
+				status := response.Status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				for name, value := range response.Headers {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(status)
+				io.WriteString(w, response.Body)
+				fmt.Fprintf(log, "%s %s %s -> %d\n", time.Now().UTC().Format(time.RFC3339), req.Method, req.URL.Path, status)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("%s is stubbed by sm2, with no response for %s %s", config.Service, req.Method, req.URL.Path), http.StatusNotFound)
+		fmt.Fprintf(log, "%s %s %s -> 404\n", time.Now().UTC().Format(time.RFC3339), req.Method, req.URL.Path)
+	})
+}
+
+// keys are a path, or a method and a path, ending in * to match anything starting with it
+func stubMatches(key string, req *http.Request) bool {
+	pattern := key
+	if method, rest, found := strings.Cut(key, " "); found {
+		if !strings.EqualFold(method, req.Method) {
+			return false
+		}
+		pattern = strings.TrimSpace(rest)
+	}
+	if prefix, isPrefix := strings.CutSuffix(pattern, "*"); isPrefix {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	}
+	return req.URL.Path == pattern
+}
@@ -0,0 +1,77 @@
+package servicemanager
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sm2/cli"
+)
+
+func TestIsStubbed(t *testing.T) {
+	sm := ServiceManager{Commands: cli.UserOption{Stub: "PAYMENTS, EMAIL"}}
+	if !sm.isStubbed("PAYMENTS") || !sm.isStubbed("EMAIL") || sm.isStubbed("AUTH") {
+		t.Errorf("expected only the services named by -stub to be stubbed")
+	}
+}
+
+func TestStubHandler(t *testing.T) {
+	service := Service{
+		Id: "PAYMENTS",
+		Stub: StubResponses{
+			"GET /payments/status": {Body: `{"enabled": true}`, Headers: map[string]string{"Content-Type": "application/json"}},
+			"/payments/*":          {Status: 202},
+			"/payments/refunds/*":  {Status: 409, Body: "no refunds"},
+		},
+	}
+	config := stubConfig{Service: "PAYMENTS", Responses: stubResponses(service, "http://localhost:9000/ping/ping")}
+	log := bytes.Buffer{}
+	server := httptest.NewServer(stubHandler(config, &log))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/ping/ping", 200, "pong"},
+		{"GET", "/payments/status", 200, `{"enabled": true}`},
+		{"POST", "/payments/status", 202, ""},
+		{"POST", "/payments/refunds/123", 409, "no refunds"},
+		{"GET", "/orders", 404, "PAYMENTS is stubbed by sm2, with no response for GET /orders\n"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status || string(body) != test.body {
+			t.Errorf("%s %s: expected %d %q got %d %q", test.method, test.path, test.status, test.body, resp.StatusCode, body)
+		}
+	}
+
+	if !strings.Contains(log.String(), "GET /orders -> 404") {
+		t.Errorf("expected requests to be logged, got %s", log.String())
+	}
+}
+
+func TestStubResponses(t *testing.T) {
+	service := Service{Healthcheck: Healthcheck{Response: "OK"}, Stub: StubResponses{"/health": {Status: 503}}}
+
+	if responses := stubResponses(service, "http://localhost:9000/ready"); responses["/ready"].Body != "OK" {
+		t.Errorf("expected the healthcheck to answer with the expected response, got %+v", responses)
+	}
+	if responses := stubResponses(service, "http://localhost:9000/health"); responses["/health"].Status != 503 {
+		t.Errorf("expected the service's own healthcheck response to be kept, got %+v", responses)
+	}
+	if responses := stubResponses(service, tcpHealthcheckUrl(9000)); len(responses) != 1 {
+		t.Errorf("expected nothing to be added for a tcp healthcheck, got %+v", responses)
+	}
+}
@@ -0,0 +1,105 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Prints a systemd user unit that starts a service with sm2, so it keeps running across reboots
+// without a terminal open, e.g.
+//
+//	sm2 -export-systemd PAYMENTS > ~/.config/systemd/user/sm2-payments.service
+//	systemctl --user daemon-reload
+//	systemctl --user enable --now sm2-payments
+//
+// sm2 exits once the service has started, so the unit is a oneshot that stays active, and the service
+// stays in the unit's cgroup, where systemd can find it when the unit is stopped.
+
+// how long to wait for the service to be healthy before systemd counts the start as failed
+const systemdWaitSeconds = 300
+
+func (sm *ServiceManager) ExportSystemd(serviceName string, out io.Writer) error {
+	sv := parseServiceAndVersion(serviceName)
+	sv.service = sm.renamedService(sv.service)
+	service, ok := sm.Services[sv.service]
+	if !ok {
+		return fmt.Errorf("%s is not a service", sv.service)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	start := []string{exe, "-start", serviceName, "-noprogress", "-wait", fmt.Sprint(systemdWaitSeconds)}
+	if sm.Commands.Config != "" {
+		start = append(start, "-config", sm.Commands.Config)
+	}
+	stop := []string{exe, "-stop", sv.service}
+	if sm.Commands.Config != "" {
+		stop = append(stop, "-config", sm.Commands.Config)
+	}
+
+	description := sv.service
+	if service.Name != "" {
+		description += " (" + service.Name + ")"
+	}
+
+	unit := unitName(sv.service)
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "# %s.service, generated by sm2 -export-systemd %s\n", unit, serviceName)
+	fmt.Fprintf(&b, "# save it as ~/.config/systemd/user/%s.service then run:\n", unit)
+	b.WriteString("#   systemctl --user daemon-reload\n")
+	fmt.Fprintf(&b, "#   systemctl --user enable --now %s\n", unit)
+	b.WriteString("# and `loginctl enable-linger $USER` to keep it running while you're logged out\n")
+	b.WriteString("\n[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s, started by sm2\n", systemdEscape(description))
+	b.WriteString("Wants=network-online.target\nAfter=network-online.target\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=oneshot\nRemainAfterExit=yes\n")
+	// systemd doesn't have the shell's environment, so sm2 needs telling where things are
+	fmt.Fprintf(&b, "Environment=%s\n", systemdQuote("WORKSPACE="+sm.Config.WorkspaceDir))
+	for _, name := range []string{"PATH", "JAVA_HOME"} {
+		if value, ok := os.LookupEnv(name); ok {
+			fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(name+"="+value))
+		}
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", systemdCommand(start))
+	fmt.Fprintf(&b, "ExecStop=%s\n", systemdCommand(stop))
+	fmt.Fprintf(&b, "TimeoutStartSec=%d\n", systemdWaitSeconds+30)
+	b.WriteString("\n[Install]\nWantedBy=default.target\n")
+
+	_, err = io.WriteString(out, b.String())
+	return err
+}
+
+func unitName(serviceName string) string {
+	return "sm2-" + strings.ToLower(strings.ReplaceAll(serviceName, "_", "-"))
+}
+
+// % starts a specifier in unit files
+func systemdEscape(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+func systemdQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + systemdEscape(value) + `"`
+}
+
+func systemdCommand(args []string) string {
+	quoted := []string{}
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			arg = systemdQuote(arg)
+		} else {
+			arg = systemdEscape(arg)
+		}
+		// $ would otherwise be expanded as an environment variable
+		quoted = append(quoted, strings.ReplaceAll(arg, "$", "$$"))
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,47 @@
+package servicemanager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestExportSystemd(t *testing.T) {
+	sm := ServiceManager{
+		Services: Services{"PAYMENTS_API": {Id: "PAYMENTS_API", Name: "payments 100% api"}},
+		Config:   ServiceManagerConfig{WorkspaceDir: "/home/me/my workspace"},
+	}
+	t.Setenv("JAVA_HOME", "/opt/java")
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportSystemd("PAYMENTS_API:1.2.3", &out))
+	unit := out.String()
+
+	for _, expected := range []string{
+		"# save it as ~/.config/systemd/user/sm2-payments-api.service",
+		"Description=PAYMENTS_API (payments 100%% api), started by sm2\n",
+		"Type=oneshot\nRemainAfterExit=yes\n",
+		"Environment=\"WORKSPACE=/home/me/my workspace\"\n",
+		"Environment=\"JAVA_HOME=/opt/java\"\n",
+		" -start PAYMENTS_API:1.2.3 -noprogress -wait 300\n",
+		" -stop PAYMENTS_API\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, expected) {
+			t.Errorf("expected the unit to contain %q, got\n%s", expected, unit)
+		}
+	}
+
+	if err := sm.ExportSystemd("UNKNOWN", &out); err == nil {
+		t.Errorf("expected an unknown service to be rejected")
+	}
+}
+
+func TestSystemdCommand(t *testing.T) {
+	command := systemdCommand([]string{"/opt/my tools/sm2", "-config", "/cfg/$HOME", "-r", "50%"})
+	if command != `"/opt/my tools/sm2" -config /cfg/$$HOME -r 50%%` {
+		t.Errorf("unexpected command %s", command)
+	}
+}
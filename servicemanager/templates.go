@@ -0,0 +1,141 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Services can be based on a template from templates.json in the config dir, so the hundreds of
+// nearly identical entries don't need to repeat everything, e.g.
+//
+//	templates.json:
+//	"standard-play-service": {
+//	  "binary": { "groupId": "uk.gov.hmrc", "artifact": "${artifact}_%%", "cmd": ["./${artifact}/bin/${artifact}", "-J-Xmx256m"] }
+//	}
+//
+//	services.json:
+//	"MY_SERVICE": { "extends": "standard-play-service", "name": "My Service", "defaultPort": 9000, "params": { "artifact": "my-service" } }
+//
+// ${id} and the service's params are replaced in the template, anything else (like ${port}) is left alone.
+// The service's own fields are then merged over the template, the same way overrides are.
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+type Templates map[string]map[string]interface{}
+
+func parseTemplates(data []byte) (Templates, error) {
+	decoded, err := decodeJsonObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := Templates{}
+	for name, t := range decoded {
+		template, ok := t.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("template %s should be an object", name)
+		}
+		templates[name] = template
+	}
+	return templates, nil
+}
+
+// expands templates and vars then merges in any platform specific values, before services.json is decoded
+func expandServices(data []byte, templates Templates, platforms []string) ([]byte, error) {
+	services, err := decodeJsonObject(data)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := takeVars(services)
+	if err != nil {
+		return nil, err
+	}
+	if err := expandTemplates(services, templates); err != nil {
+		return nil, err
+	}
+	if err := expandVars(services, vars); err != nil {
+		return nil, err
+	}
+	applyPlatforms(services, platforms)
+	return json.Marshal(services)
+}
+
+// replaces every service that extends a template with the template merged with the service's own fields
+func expandTemplates(services map[string]interface{}, templates Templates) error {
+	for id, s := range services {
+		service, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, ok := service["extends"].(string)
+		if !ok || name == "" {
+			continue
+		}
+
+		template, ok := templates[name]
+		if !ok {
+			return fmt.Errorf("%s extends %s, which is not in templates.json", id, name)
+		}
+
+		params := map[string]string{"id": id}
+		if p, ok := service["params"].(map[string]interface{}); ok {
+			for k, v := range p {
+				params[k] = fmt.Sprint(v)
+			}
+		}
+
+		// each service gets its own copy of the template so they can't affect each other
+		expanded := fillPlaceholders(copyJson(template), params).(map[string]interface{})
+		services[id] = deepMerge(expanded, service)
+	}
+	return nil
+}
+
+func fillPlaceholders(value interface{}, params map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return placeholder.ReplaceAllStringFunc(v, func(p string) string {
+			if param, ok := params[p[2:len(p)-1]]; ok {
+				return param
+			}
+			return p
+		})
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = fillPlaceholders(item, params)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fillPlaceholders(item, params)
+		}
+	}
+	return value
+}
+
+func copyJson(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = copyJson(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyJson(item)
+		}
+		return c
+	}
+	return value
+}
+
+// the templates from templates.json and any included config, if there are any
+func (sm *ServiceManager) loadTemplates() (Templates, error) {
+	data, err := sm.readOptionalConfig("templates")
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplates(data)
+}
@@ -0,0 +1,79 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestLoadServicesWithTemplates(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-templates*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	os.WriteFile(path.Join(configDir, "templates.json"), []byte(`{
+		"play": {
+			"defaultPort": 9000,
+			"binary": {"groupId": "uk.gov.hmrc", "artifact": "${artifact}_%%", "destinationSubdir": "${id}", "cmd": ["./${artifact}/bin/${artifact}", "-Dhttp.port=${port}"]}
+		}
+	}`), 0644)
+	os.WriteFile(path.Join(configDir, "services.json"), []byte(`{
+		"AUTH": {"extends": "play", "name": "auth", "params": {"artifact": "auth"}},
+		"PAY":  {"extends": "play", "name": "pay", "defaultPort": 8600, "params": {"artifact": "pay"}, "binary": {"groupId": "uk.gov.pay"}},
+		"PLAIN": {"name": "plain", "defaultPort": 1234}
+	}`), 0644)
+
+	sm := ServiceManager{Config: ServiceManagerConfig{ConfigDir: configDir}}
+	templates, err := sm.loadTemplates()
+	AssertNotErr(t, err)
+
+	services, err := loadServicesFromFile(path.Join(configDir, "services.json"), templates)
+	AssertNotErr(t, err)
+
+	auth := (*services)["AUTH"]
+	if auth.DefaultPort != 9000 || auth.Binary.Artifact != "auth_%%" || auth.Binary.GroupId != "uk.gov.hmrc" || auth.Binary.DestinationSubdir != "AUTH" {
+		t.Errorf("expected AUTH to be filled in from the template, got %+v", auth)
+	}
+	if !reflect.DeepEqual(auth.Binary.Cmd, []string{"./auth/bin/auth", "-Dhttp.port=${port}"}) {
+		t.Errorf("expected params to be replaced and ${port} left alone, got %v", auth.Binary.Cmd)
+	}
+
+	pay := (*services)["PAY"]
+	if pay.DefaultPort != 8600 || pay.Binary.GroupId != "uk.gov.pay" || pay.Binary.Artifact != "pay_%%" {
+		t.Errorf("expected PAY's own fields to win over the template, got %+v", pay)
+	}
+
+	if plain := (*services)["PLAIN"]; plain.DefaultPort != 1234 || plain.Binary.Artifact != "" {
+		t.Errorf("expected services without extends to be unchanged, got %+v", plain)
+	}
+}
+
+func TestUnknownTemplate(t *testing.T) {
+	services := map[string]interface{}{
+		"AUTH": map[string]interface{}{"extends": "missing"},
+	}
+	err := expandTemplates(services, Templates{})
+	if err == nil || !strings.Contains(err.Error(), "AUTH extends missing") {
+		t.Errorf("expected an error for an unknown template, got %v", err)
+	}
+}
+
+func TestValidateServiceExtendingTemplate(t *testing.T) {
+	problems := validateConfig("services.json", []byte(`{
+		"AUTH": {"extends": "play", "binary": {"artifact": "auth"}},
+		"PAY": {"binary": {"artifact": "pay"}}
+	}`), reflect.TypeOf(Services{}))
+
+	for _, p := range problems {
+		if strings.HasPrefix(p.path, "AUTH") {
+			t.Errorf("expected no required fields for a service with extends, got %s", p)
+		}
+	}
+	if len(problems) != 2 {
+		t.Errorf("expected PAY to be missing groupId and cmd, got %v", problems)
+	}
+}
@@ -0,0 +1,142 @@
+package servicemanager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Artifactory rate limits heavy users, answering 429 Too Many Requests with a Retry-After header saying how
+// long to back off for. Rather than failing every service that's downloading, requests that are throttled
+// wait that long (up to maxRetryAfter, or doubling from a second when it doesn't say) and try again, a few
+// times. While a host's throttling sm2, the other requests to it wait as well rather than adding to it.
+
+const (
+	throttleRetries      = 5
+	maxRetryAfter        = 60 * time.Second
+	defaultRetryAfter    = time.Second
+	throttledDescription = "429 Too Many Requests"
+)
+
+type throttledTransport struct {
+	next  http.RoundTripper
+	sleep func(req *http.Request, d time.Duration) error
+	now   func() time.Time
+
+	lock  sync.Mutex
+	until map[string]time.Time // when each host said to try again
+}
+
+// wraps the client's transport, outside of any other (e.g. aws) so each retry is signed afresh
+func (sm *ServiceManager) configureThrottling() {
+	if sm.Client == nil {
+		return
+	}
+	next := sm.Client.Transport
+	if throttled, ok := next.(*throttledTransport); ok {
+		// the config's been reloaded
+		next = throttled.next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	sm.Client.Transport = newThrottledTransport(next)
+}
+
+func newThrottledTransport(next http.RoundTripper) *throttledTransport {
+	return &throttledTransport{
+		next:  next,
+		sleep: sleepOrCancel,
+		now:   time.Now,
+		until: map[string]time.Time{},
+	}
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// only requests that can be sent again are retried, which is all of sm2's gets
+	retryable := req.Body == nil || req.GetBody != nil
+	backoff := defaultRetryAfter
+	waited := time.Duration(0)
+
+	for attempt := 0; ; attempt++ {
+		if wait := t.waitFor(req.URL.Host); wait > 0 {
+			if err := t.sleep(req, wait); err != nil {
+				return nil, err
+			}
+			waited += wait
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || !retryable {
+			return resp, err
+		}
+		resp.Body.Close()
+		if attempt == throttleRetries {
+			return nil, fmt.Errorf("%s is still rate limiting sm2 (%s) after %d retries over %s, try again with fewer -workers",
+				req.URL.Host, throttledDescription, throttleRetries, waited.Round(time.Second))
+		}
+
+		retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), t.now())
+		if !ok {
+			retryAfter = backoff
+			backoff *= 2
+		}
+		if retryAfter > maxRetryAfter {
+			retryAfter = maxRetryAfter
+		}
+		t.backOff(req.URL.Host, retryAfter)
+	}
+}
+
+// how long until the host said to try again
+func (t *throttledTransport) waitFor(host string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.until[host].Sub(t.now())
+}
+
+func (t *throttledTransport) backOff(host string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if until := t.now().Add(d); until.After(t.until[host]) {
+		t.until[host] = until
+	}
+}
+
+// Retry-After is either a number of seconds or an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func sleepOrCancel(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
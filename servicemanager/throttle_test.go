@@ -0,0 +1,105 @@
+package servicemanager
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestThrottledRequestsAreRetried(t *testing.T) {
+	requests := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			// far longer than it's worth waiting
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer svr.Close()
+
+	now := time.Now()
+	waits := []time.Duration{}
+	transport := newThrottledTransport(http.DefaultTransport)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(_ *http.Request, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(svr.URL)
+	AssertNotErr(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "ok" {
+		t.Errorf("expected it to succeed once it wasn't throttled, got %d %s", resp.StatusCode, body)
+	}
+	expected := []time.Duration{3 * time.Second, maxRetryAfter, defaultRetryAfter}
+	if len(waits) != len(expected) || waits[0] != expected[0] || waits[1] != expected[1] || waits[2] != expected[2] {
+		t.Errorf("expected to wait %v, waited %v", expected, waits)
+	}
+}
+
+func TestPersistentThrottlingIsAnError(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer svr.Close()
+
+	transport := newThrottledTransport(http.DefaultTransport)
+	transport.sleep = func(*http.Request, time.Duration) error { return nil }
+	_, err := (&http.Client{Transport: transport}).Get(svr.URL)
+	if err == nil || !strings.Contains(err.Error(), "still rate limiting sm2 (429 Too Many Requests) after 5 retries") {
+		t.Errorf("expected a clear error once it gives up, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"0":                             0,
+		"Fri, 16 Oct 2026 12:00:30 GMT": 30 * time.Second,
+		"Fri, 16 Oct 2026 11:00:00 GMT": 0,
+	} {
+		if d, ok := parseRetryAfter(value, now); !ok || d != expected {
+			t.Errorf("expected %s to be %s, got %s %t", value, expected, d, ok)
+		}
+	}
+	for _, value := range []string{"", "soon", "-5"} {
+		if _, ok := parseRetryAfter(value, now); ok {
+			t.Errorf("expected %q not to be a valid Retry-After", value)
+		}
+	}
+}
+
+func TestConfigureThrottlingOnReload(t *testing.T) {
+	sm := ServiceManager{Client: &http.Client{}}
+	for i := 0; i < 2; i++ {
+		sm.configureAws()
+		sm.configureThrottling()
+	}
+	throttled, ok := sm.Client.Transport.(*throttledTransport)
+	if !ok {
+		t.Fatalf("expected the throttling to be outermost, got %T", sm.Client.Transport)
+	}
+	if aws, ok := throttled.next.(*awsTransport); !ok || aws.next != http.DefaultTransport {
+		t.Errorf("expected each transport to be wrapped once, got %T", throttled.next)
+	}
+}
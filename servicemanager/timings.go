@@ -0,0 +1,188 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sm2 -start PROFILE -timings prints how long each service spent in each phase of starting, so a slow start
+// can be pinned on artifactory, unpacking or the services themselves. The phases come from the same spans
+// that are exported as traces (see tracing.go), which are kept in memory without a collector when -timings
+// is given. Once everything's started it waits (for -wait secs, or timingsWait) to see when each service
+// first passes its health check.
+
+const (
+	timingsWait         = 5 * time.Minute
+	timingsPollInterval = 500 * time.Millisecond
+)
+
+// the columns of the report, and the spans they're taken from
+var timingPhases = []struct {
+	name string
+	span string
+}{
+	{"metadata", "resolve version"},
+	{"download", "download"},
+	{"extract", "extract"},
+	{"start", "run"},
+}
+
+type serviceTimings struct {
+	service string
+	phases  map[string]time.Duration
+	started time.Time // when sm2 started starting it
+	running time.Time // when its process was started
+	healthy time.Duration
+	err     error
+}
+
+// works out each service's phases from the spans that have ended so far
+func timingsFromSpans(spans []*Span) []*serviceTimings {
+	byId := map[string]*Span{}
+	for _, span := range spans {
+		byId[span.spanId] = span
+	}
+
+	// the "start SERVICE" span each span is part of
+	serviceSpan := func(span *Span) *Span {
+		for span != nil {
+			if _, ok := span.attrs["sm2.service"]; ok {
+				return span
+			}
+			span = byId[span.parentId]
+		}
+		return nil
+	}
+
+	byService := map[string]*serviceTimings{}
+	for _, span := range spans {
+		root := serviceSpan(span)
+		if root == nil {
+			continue
+		}
+		service := root.attrs["sm2.service"]
+		timings, ok := byService[service]
+		if !ok {
+			timings = &serviceTimings{service: service, phases: map[string]time.Duration{}, started: root.start, err: root.err}
+			byService[service] = timings
+		}
+		for _, phase := range timingPhases {
+			if span.name == phase.span {
+				timings.phases[phase.name] += span.end.Sub(span.start)
+				if phase.name == "start" && span.err == nil {
+					timings.running = span.end
+				}
+			}
+		}
+	}
+
+	result := []*serviceTimings{}
+	for _, timings := range byService {
+		result = append(result, timings)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].service < result[j].service
+	})
+	return result
+}
+
+// polls the services that were started until they're healthy, have failed, or it's waited long enough
+func (sm *ServiceManager) awaitHealthyTimings(timings []*serviceTimings, wait time.Duration) {
+	waiting := map[string]*serviceTimings{}
+	for _, t := range timings {
+		if !t.running.IsZero() {
+			waiting[t.service] = t
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+
+	fmt.Printf("Waiting for %d services to be healthy...\n", len(waiting))
+	deadline := time.Now().Add(wait)
+	for len(waiting) > 0 && time.Now().Before(deadline) {
+		for _, status := range sm.findStatuses() {
+			t, ok := waiting[status.service]
+			if !ok {
+				continue
+			}
+			if status.health == PASS {
+				t.healthy = time.Since(t.running)
+				delete(waiting, status.service)
+			} else if status.health == FAIL {
+				t.err = fmt.Errorf("%s failed its health check", status.service)
+				delete(waiting, status.service)
+			}
+		}
+		if len(waiting) > 0 {
+			time.Sleep(timingsPollInterval)
+		}
+	}
+}
+
+// prints a row per service, then the time spent in each phase across all of them
+func printTimings(out io.Writer, timings []*serviceTimings, elapsed time.Duration) {
+	serviceLen := 14
+	for _, t := range timings {
+		if len(t.service) > serviceLen {
+			serviceLen = len(t.service)
+		}
+	}
+
+	fmt.Fprintf(out, "\n %s", pad("SERVICE", serviceLen))
+	for _, phase := range timingPhases {
+		fmt.Fprintf(out, " %9s", strings.ToUpper(phase.name))
+	}
+	fmt.Fprintf(out, " %9s %9s\n", "HEALTHY", "TOTAL")
+
+	totals := map[string]time.Duration{}
+	longest := map[string]time.Duration{}
+	slowest := map[string]string{}
+	healthy := 0
+	var healthyTotal time.Duration
+	for _, t := range timings {
+		fmt.Fprintf(out, " %s", pad(t.service, serviceLen))
+		for _, phase := range timingPhases {
+			d, ok := t.phases[phase.name]
+			fmt.Fprintf(out, " %9s", formatTiming(d, ok))
+			if d > longest[phase.name] {
+				longest[phase.name] = d
+				slowest[phase.name] = t.service
+			}
+			totals[phase.name] += d
+		}
+
+		switch {
+		case t.err == ErrAlreadyRunning:
+			fmt.Fprintf(out, " %9s %9s\n", "running", "-")
+		case t.err != nil || t.running.IsZero():
+			fmt.Fprintf(out, " %9s %9s\n", "failed", "-")
+		case t.healthy == 0:
+			fmt.Fprintf(out, " %9s %9s\n", "timed out", "-")
+		default:
+			healthy++
+			healthyTotal += t.healthy
+			fmt.Fprintf(out, " %9s %9s\n", formatTiming(t.healthy, true), formatTiming(t.running.Add(t.healthy).Sub(t.started), true))
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d of %d services healthy after %s. Time spent across all services:\n", healthy, len(timings), formatTiming(elapsed, true))
+	for _, phase := range timingPhases {
+		if totals[phase.name] > 0 {
+			fmt.Fprintf(out, "  %-9s %9s (slowest %s)\n", phase.name, formatTiming(totals[phase.name], true), slowest[phase.name])
+		}
+	}
+	if healthy > 0 {
+		fmt.Fprintf(out, "  %-9s %9s\n", "healthy", formatTiming(healthyTotal, true))
+	}
+}
+
+func formatTiming(d time.Duration, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
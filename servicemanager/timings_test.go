@@ -0,0 +1,97 @@
+package servicemanager
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/ledger"
+	"sm2/platform"
+	. "sm2/testing"
+)
+
+func TestTimingsFromSpans(t *testing.T) {
+	tracer := NewTracer("")
+	tracer.StartRoot("sm2 start")
+
+	auth := tracer.StartSpan("start AUTH", nil, "sm2.service", "AUTH")
+	tracer.StartSpan("resolve version", auth).End(nil)
+	install := tracer.StartSpan("install", auth)
+	tracer.StartSpan("download", install).End(nil)
+	tracer.StartSpan("extract", install).End(nil)
+	install.End(nil)
+	tracer.StartSpan("run", auth).End(nil)
+	auth.End(nil)
+
+	email := tracer.StartSpan("start EMAIL", nil, "sm2.service", "EMAIL")
+	email.End(ErrAlreadyRunning)
+
+	broken := tracer.StartSpan("start BROKEN", nil, "sm2.service", "BROKEN")
+	tracer.StartSpan("resolve version", broken).End(fmt.Errorf("not found"))
+	broken.End(fmt.Errorf("not found"))
+
+	timings := timingsFromSpans(tracer.completed())
+	if len(timings) != 3 || timings[0].service != "AUTH" || timings[1].service != "BROKEN" || timings[2].service != "EMAIL" {
+		t.Fatalf("expected a timing for each service, got %v", timings)
+	}
+	for _, phase := range timingPhases {
+		if _, ok := timings[0].phases[phase.name]; !ok {
+			t.Errorf("expected AUTH to have a %s time", phase.name)
+		}
+	}
+	if timings[0].running.IsZero() {
+		t.Error("expected AUTH to be running")
+	}
+	if _, ok := timings[1].phases["download"]; ok || !timings[1].running.IsZero() {
+		t.Errorf("expected BROKEN to have only got as far as the metadata, got %+v", timings[1])
+	}
+	if timings[2].err != ErrAlreadyRunning {
+		t.Errorf("expected EMAIL to be already running, got %v", timings[2].err)
+	}
+
+	// nothing's exported without a collector
+	AssertNotErr(t, tracer.Flush())
+}
+
+func TestTimingsWaitsForServicesToBeHealthy(t *testing.T) {
+	ready := time.Now().Add(time.Second)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if time.Now().Before(ready) {
+			w.WriteHeader(503)
+		}
+	}))
+	defer svr.Close()
+
+	running := time.Now()
+	states := []ledger.StateFile{{Service: "AUTH", Pid: 1001, Port: 8500, Started: running, HealthcheckUrl: svr.URL}}
+	sm := ServiceManager{
+		Client:   &http.Client{},
+		Platform: platform.Platform{Uptime: mockUptime, PidLookup: func() map[int]int { return map[int]int{1001: 1001} }},
+		Ledger: ledger.Ledger{FindAllStateFiles: func(string) ([]ledger.StateFile, error) {
+			return states, nil
+		}},
+	}
+
+	timings := []*serviceTimings{
+		{service: "AUTH", started: running.Add(-3 * time.Second), running: running, phases: map[string]time.Duration{"metadata": time.Second, "download": 2 * time.Second}},
+		{service: "BROKEN", started: running, phases: map[string]time.Duration{}, err: fmt.Errorf("not found")},
+	}
+	sm.awaitHealthyTimings(timings, 10*time.Second)
+
+	if timings[0].healthy < time.Second || timings[0].healthy > 3*time.Second {
+		t.Errorf("expected AUTH to be healthy after about a second, got %s", timings[0].healthy)
+	}
+
+	out := bytes.Buffer{}
+	printTimings(&out, timings, 5*time.Second)
+	report := out.String()
+	for _, expected := range []string{"METADATA", "AUTH", "1.0s", "2.0s", "BROKEN", "failed", "1 of 2 services healthy after 5.0s", "download       2.0s (slowest AUTH)"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q, got:\n%s", expected, report)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package servicemanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sm2/version"
+)
+
+// A very small OpenTelemetry tracer. Spans are collected in memory for the duration of the
+// command and exported in one batch to a collector using OTLP/HTTP (json encoded).
+// sm2 has no external dependencies, so rather than pulling in the otel sdk we implement
+// just enough of the protocol to be useful. A nil *Tracer (and nil *Span) is a no-op, so
+// callers don't need to check if tracing is enabled.
+type Tracer struct {
+	endpoint string
+	client   *http.Client
+	traceId  string
+	root     *Span
+	lock     sync.Mutex
+	spans    []*Span
+}
+
+type Span struct {
+	tracer   *Tracer
+	spanId   string
+	parentId string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+// finds the collector endpoint using the standard otel environment variables,
+// returns an empty string if tracing is not configured
+func tracingEndpoint() string {
+	if endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); ok && endpoint != "" {
+		return endpoint
+	}
+	if endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); ok && endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+func NewTracer(endpoint string) *Tracer {
+	return &Tracer{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		traceId:  randomHex(16),
+	}
+}
+
+// starts a new span. if parent is nil the span is attached to the tracer's root span (if there is one).
+// attrs are supplied as key, value pairs.
+func (t *Tracer) StartSpan(name string, parent *Span, attrs ...string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{
+		tracer: t,
+		spanId: randomHex(8),
+		name:   name,
+		start:  time.Now(),
+		attrs:  map[string]string{},
+	}
+
+	if parent == nil {
+		parent = t.root
+	}
+	if parent != nil {
+		span.parentId = parent.spanId
+	}
+
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.attrs[attrs[i]] = attrs[i+1]
+	}
+	return span
+}
+
+// starts a span that all subsequent parentless spans will be attached to
+func (t *Tracer) StartRoot(name string, attrs ...string) *Span {
+	if t == nil {
+		return nil
+	}
+	t.root = t.StartSpan(name, nil, attrs...)
+	return t.root
+}
+
+func (s *Span) SetAttr(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.attrs[key] = value
+}
+
+// ends the span, a non-nil err marks the span as failed
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.end = time.Now()
+	s.err = err
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+// the spans that have ended so far
+func (t *Tracer) completed() []*Span {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]*Span{}, t.spans...)
+}
+
+// sends all the completed spans to the collector
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	if t.root != nil && t.root.end.IsZero() {
+		t.root.End(nil)
+	}
+
+	t.lock.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.lock.Unlock()
+
+	// without a collector they were only kept for -timings
+	if len(spans) == 0 || t.endpoint == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("trace collector %s responded with %s", t.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// structs for marshalling spans into the OTLP json format
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+func (t *Tracer) encode(spans []*Span) otlpExport {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "sm2"
+	scope.Scope.Version = version.Version
+
+	for _, s := range spans {
+		encoded := otlpSpan{
+			TraceId:           t.traceId,
+			SpanId:            s.spanId,
+			ParentSpanId:      s.parentId,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: fmt.Sprint(s.start.UnixNano()),
+			EndTimeUnixNano:   fmt.Sprint(s.end.UnixNano()),
+			Attributes:        []otlpAttribute{},
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		for k, v := range s.attrs {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{k, otlpValue{v}})
+		}
+		if s.err != nil {
+			encoded.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		scope.Spans = append(scope.Spans, encoded)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpAttribute{
+		{"service.name", otlpValue{"sm2"}},
+		{"service.version", otlpValue{version.Version}},
+	}
+	if host, err := os.Hostname(); err == nil {
+		resource.Resource.Attributes = append(resource.Resource.Attributes, otlpAttribute{"host.name", otlpValue{host}})
+	}
+
+	return otlpExport{ResourceSpans: []otlpResourceSpans{resource}}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
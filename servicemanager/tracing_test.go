@@ -0,0 +1,66 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestNilTracerIsNoop(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartSpan("foo", nil)
+	span.SetAttr("foo", "bar")
+	span.End(fmt.Errorf("ignored"))
+	AssertNotErr(t, tracer.Flush())
+}
+
+func TestTracerExportsSpansToCollector(t *testing.T) {
+	var export otlpExport
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&export)
+	}))
+	defer svr.Close()
+
+	tracer := NewTracer(svr.URL + "/v1/traces")
+	root := tracer.StartRoot("sm2 start")
+	span := tracer.StartSpan("start FOO", nil, "sm2.service", "FOO")
+	child := tracer.StartSpan("download", span)
+	child.End(fmt.Errorf("boom"))
+	span.End(nil)
+
+	AssertNotErr(t, tracer.Flush())
+
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans to be exported, got %d", len(spans))
+	}
+
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		if s.TraceId != tracer.traceId {
+			t.Errorf("span %s has trace id %s, expected %s", s.Name, s.TraceId, tracer.traceId)
+		}
+		byName[s.Name] = s
+	}
+
+	if byName["start FOO"].ParentSpanId != root.spanId {
+		t.Errorf("start FOO should be a child of the root span")
+	}
+	if byName["download"].ParentSpanId != span.spanId {
+		t.Errorf("download should be a child of start FOO")
+	}
+	if byName["download"].Status.Code != otlpStatusError || byName["download"].Status.Message != "boom" {
+		t.Errorf("download span should have an error status, got %v", byName["download"].Status)
+	}
+	if byName["sm2 start"].ParentSpanId != "" {
+		t.Errorf("root span should not have a parent")
+	}
+}
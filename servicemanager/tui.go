@@ -0,0 +1,523 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"sm2/platform"
+)
+
+// `sm2 ui` (or -ui) is a full screen view of the workspace for people who live in the terminal but are tired of
+// typing the same long commands: the running services with their health and uptime, kept up to date, which can
+// be started, stopped and restarted with a key. / searches every service in the config, so ones that aren't
+// running can be found and started, p picks a profile to start and l tails the selected service's log in a pane
+// underneath.
+//
+// The actions run sm2 itself (e.g. sm2 -start AUTH -noprogress) in the background, so they do exactly what they
+// would if they'd been typed in, and only the last line they print is shown. They're given the options that
+// choose the config (-config, -overlay, -profile-url etc) that sm2 ui was, so they act on what's shown.
+
+const (
+	tuiRefresh  = 2 * time.Second
+	tuiLogLines = 200
+	tuiHelp     = "↑/↓ select  s start  x stop  r restart  l logs  / search  p profiles  q quit"
+)
+
+type tuiMode int
+
+const (
+	tuiServices tuiMode = iota
+	tuiSearch
+	tuiProfiles
+)
+
+// a service in the list, which isn't running when its health is ""
+type tuiRow struct {
+	service string
+	version string
+	pid     int
+	port    int
+	health  health
+	started time.Time
+}
+
+type tui struct {
+	running  []tuiRow
+	services []string // every service in the config, for searching
+	profiles []string
+	rows     []tuiRow // the ones being shown
+	selected int
+	profile  int
+	mode     tuiMode
+	filter   string
+	showLogs bool
+	logs     []string
+	message  string
+	width    int
+	height   int
+	now      func() time.Time
+}
+
+func (sm *ServiceManager) RunTui() error {
+	if !stdinIsTerminal() {
+		return fmt.Errorf("sm2 ui needs a terminal")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	restore, err := platform.RawTerminal()
+	if err != nil {
+		return fmt.Errorf("unable to read keys from the terminal: %s", err)
+	}
+	defer restore()
+	// uses the alternate screen, so whatever was in the terminal is back afterwards, and hides the cursor
+	fmt.Print("\033[?1049h\033[?25l")
+	defer fmt.Print("\033[?25h\033[?1049l")
+
+	sm.statuses = newStatusCache(statusCacheTtl)
+	t := &tui{now: time.Now}
+	sm.refreshTui(t)
+
+	keys := readKeys(os.Stdin)
+	results := make(chan string, 1)
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+
+	for {
+		t.width, t.height = 80, 25
+		if sm.Platform.GetTerminalSize != nil {
+			// some terminals (e.g. over a serial console) don't say how big they are
+			if width, height := sm.Platform.GetTerminalSize(); width > 0 && height > 0 {
+				t.width, t.height = width, height
+			}
+		}
+		fmt.Print(t.render())
+
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			action, quit := t.handleKey(key)
+			if quit {
+				return nil
+			}
+			if action != nil {
+				t.message = "sm2 " + strings.Join(action, " ") + "..."
+				go func() { results <- sm.runSm2(exe, action) }()
+			}
+			if t.showLogs {
+				t.logs = sm.tailLogs(t.selectedService())
+			}
+		case result := <-results:
+			t.message = result
+			sm.refreshTui(t)
+		case <-ticker.C:
+			sm.refreshTui(t)
+		}
+	}
+}
+
+// reloads the services' statuses, and the log being shown
+func (sm *ServiceManager) refreshTui(t *tui) {
+	running := []tuiRow{}
+	if states, err := sm.Ledger.FindAllStateFiles(sm.Config.TmpDir); err == nil {
+		started := map[string]time.Time{}
+		for _, state := range states {
+			started[state.Service] = state.Started
+		}
+		for _, s := range sm.findStatusesOf(states) {
+			running = append(running, tuiRow{service: s.service, version: s.version, pid: s.pid, port: s.port, health: s.health, started: started[s.service]})
+		}
+	}
+
+	services := []string{}
+	for id := range sm.Services {
+		services = append(services, id)
+	}
+	sort.Strings(services)
+	profiles := []string{}
+	for name := range sm.Profiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	t.update(running, services, profiles)
+	if t.showLogs {
+		t.logs = sm.tailLogs(t.selectedService())
+	}
+}
+
+// runs sm2 with the args, returning the last thing it printed
+func (sm *ServiceManager) runSm2(exe string, args []string) string {
+	cmd := exec.Command(exe, append(sm.tuiConfigArgs(), args...)...)
+	cmd.Env = append(os.Environ(), "WORKSPACE="+sm.Config.WorkspaceDir)
+	out, err := cmd.CombinedOutput()
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if err != nil && last == "" {
+		last = err.Error()
+	}
+	if last == "" {
+		last = "done"
+	}
+	return "sm2 " + strings.Join(args, " ") + ": " + last
+}
+
+// the options sm2 ui was started with that change which config, services and profiles there are, or how
+// they're started
+func (sm *ServiceManager) tuiConfigArgs() []string {
+	args := []string{}
+	options := []struct {
+		flag  string
+		value string
+	}{
+		{"-config", sm.Commands.Config},
+		{"-overlay", sm.Commands.Overlay},
+		{"-profile-url", sm.Commands.ProfileUrl},
+	}
+	for _, option := range options {
+		if option.value != "" {
+			args = append(args, option.flag, option.value)
+		}
+	}
+	flags := []struct {
+		flag string
+		set  bool
+	}{
+		{"-docker", sm.Commands.Docker},
+		{"-dynamic-ports", sm.Commands.DynamicPorts},
+		{"-offline", sm.Commands.Offline},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			args = append(args, flag.flag)
+		}
+	}
+	return args
+}
+
+func (sm *ServiceManager) tailLogs(service string) []string {
+	if service == "" {
+		return nil
+	}
+	file, err := sm.findLogFile(service)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	lines, err := tailFile(file, tuiLogLines)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return lines
+}
+
+// reads keys from the terminal, turning escape sequences into up, down etc
+func readKeys(r io.Reader) chan string {
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				keys <- key
+			}
+		}
+	}()
+	return keys
+}
+
+var escapeKeys = map[string]string{"\033[A": "up", "\033[B": "down", "\033OA": "up", "\033OB": "down"}
+
+func parseKeys(data []byte) []string {
+	keys := []string{}
+	s := string(data)
+	for len(s) > 0 {
+		if strings.HasPrefix(s, "\033") {
+			if len(s) >= 3 {
+				if key, ok := escapeKeys[s[:3]]; ok {
+					keys = append(keys, key)
+					s = s[3:]
+					continue
+				}
+				if s[1] == '[' || s[1] == 'O' {
+					// some other key, e.g. the left arrow or delete, which is ignored. Its sequence ends
+					// with a letter or ~
+					end := 2
+					for end < len(s)-1 && (s[end] < '@' || s[end] > '~') {
+						end++
+					}
+					s = s[end+1:]
+					continue
+				}
+			}
+			keys = append(keys, "esc")
+			s = s[1:]
+			continue
+		}
+		r := []rune(s)[0]
+		switch r {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 127, 8:
+			keys = append(keys, "backspace")
+		case 3:
+			keys = append(keys, "ctrl-c")
+		default:
+			keys = append(keys, string(r))
+		}
+		s = s[len(string(r)):]
+	}
+	return keys
+}
+
+// works out which services are shown: the running ones, or every service matching the search
+func (t *tui) update(running []tuiRow, services []string, profiles []string) {
+	selected := t.selectedService()
+	t.running, t.services, t.profiles = running, services, profiles
+
+	t.rows = running
+	if t.filter != "" {
+		byService := map[string]tuiRow{}
+		for _, row := range running {
+			byService[row.service] = row
+		}
+		t.rows = []tuiRow{}
+		filter := strings.ToUpper(t.filter)
+		for _, service := range services {
+			if !strings.Contains(service, filter) {
+				continue
+			}
+			row, ok := byService[service]
+			if !ok {
+				row = tuiRow{service: service}
+			}
+			t.rows = append(t.rows, row)
+		}
+	}
+
+	// stays on the same service when the list changes
+	t.selected = 0
+	for i, row := range t.rows {
+		if row.service == selected {
+			t.selected = i
+		}
+	}
+}
+
+func (t *tui) selectedService() string {
+	if t.selected < len(t.rows) {
+		return t.rows[t.selected].service
+	}
+	return ""
+}
+
+// what pressing a key does, returning the sm2 args to run, if any, and whether to quit
+func (t *tui) handleKey(key string) ([]string, bool) {
+	if key == "ctrl-c" {
+		return nil, true
+	}
+
+	switch t.mode {
+	case tuiSearch:
+		switch key {
+		case "enter":
+			t.mode = tuiServices
+		case "esc":
+			t.filter = ""
+			t.mode = tuiServices
+		case "backspace":
+			if len(t.filter) > 0 {
+				t.filter = t.filter[:len(t.filter)-1]
+			}
+		case "up", "down":
+			t.move(key)
+		default:
+			if len(key) == 1 && key[0] > ' ' {
+				t.filter += key
+			}
+		}
+		t.update(t.running, t.services, t.profiles)
+		return nil, false
+
+	case tuiProfiles:
+		switch key {
+		case "up", "k":
+			if t.profile > 0 {
+				t.profile--
+			}
+		case "down", "j":
+			if t.profile < len(t.profiles)-1 {
+				t.profile++
+			}
+		case "enter":
+			t.mode = tuiServices
+			if t.profile < len(t.profiles) {
+				return []string{"-start", t.profiles[t.profile], "-noprogress"}, false
+			}
+		case "esc", "q", "p":
+			t.mode = tuiServices
+		}
+		return nil, false
+	}
+
+	service := t.selectedService()
+	switch key {
+	case "q":
+		return nil, true
+	case "up", "k", "down", "j":
+		t.move(key)
+	case "/":
+		t.mode = tuiSearch
+	case "esc":
+		t.filter = ""
+		t.update(t.running, t.services, t.profiles)
+	case "p":
+		t.mode = tuiProfiles
+	case "l":
+		t.showLogs = !t.showLogs
+	case "s":
+		if service != "" {
+			return []string{"-start", service, "-noprogress"}, false
+		}
+	case "x":
+		if service != "" {
+			return []string{"-stop", service}, false
+		}
+	case "r":
+		if service != "" {
+			return []string{"-restart", service, "-noprogress"}, false
+		}
+	}
+	return nil, false
+}
+
+func (t *tui) move(key string) {
+	if (key == "up" || key == "k") && t.selected > 0 {
+		t.selected--
+	} else if (key == "down" || key == "j") && t.selected < len(t.rows)-1 {
+		t.selected++
+	}
+}
+
+// the whole screen, from the top left
+func (t *tui) render() string {
+	lines := []string{}
+	switch {
+	case t.mode == tuiSearch:
+		lines = append(lines, t.truncate("Search: "+t.filter+"█"))
+	case t.filter != "":
+		lines = append(lines, t.truncate(fmt.Sprintf("sm2 ui - services matching %q (esc to clear)", t.filter)))
+	default:
+		lines = append(lines, t.truncate(fmt.Sprintf("sm2 ui - %d running", len(t.running))))
+	}
+
+	// the title, message and help take 3 lines, and the log pane the bottom half of what's left
+	body := t.height - 3
+	logHeight := 0
+	if t.showLogs {
+		logHeight = body / 2
+		body -= logHeight
+	}
+
+	if t.mode == tuiProfiles {
+		lines = append(lines, t.truncate("Start which profile? (enter to start, esc to go back)"))
+		lines = append(lines, t.window(len(t.profiles), t.profile, body-1, func(i int, selected bool) string {
+			return t.highlight(fmt.Sprintf("  %s", t.profiles[i]), selected)
+		})...)
+	} else {
+		lines = append(lines, t.truncate(fmt.Sprintf("  %-36s %-10s %-8s %-6s %-6s %s", "NAME", "VERSION", "PID", "PORT", "STATUS", "UPTIME")))
+		if len(t.rows) == 0 && t.filter == "" {
+			lines = append(lines, t.truncate("  Nothing's running, press / to find a service to start or p to start a profile"))
+		}
+		lines = append(lines, t.window(len(t.rows), t.selected, body-1, func(i int, selected bool) string {
+			return t.highlight(t.formatRow(t.rows[i]), selected)
+		})...)
+	}
+	for len(lines) < body+1 {
+		lines = append(lines, "")
+	}
+
+	if t.showLogs {
+		lines = append(lines, t.truncate(fmt.Sprintf("── %s ", t.selectedService())+strings.Repeat("─", t.width)))
+		logs := t.logs
+		if len(logs) > logHeight-1 {
+			logs = logs[len(logs)-(logHeight-1):]
+		}
+		for _, l := range logs {
+			lines = append(lines, t.truncate(l))
+		}
+		for i := len(logs); i < logHeight-1; i++ {
+			lines = append(lines, "")
+		}
+	}
+
+	lines = append(lines, t.truncate(t.message), t.truncate(tuiHelp))
+
+	// clears the screen, then draws it
+	return "\033[H\033[2J" + strings.Join(lines, "\r\n")
+}
+
+// the lines of a list that fit in height, scrolled so the selected one's visible
+func (t *tui) window(count int, selected int, height int, line func(int, bool) string) []string {
+	start := 0
+	if height > 0 && selected >= height {
+		start = selected - height + 1
+	}
+	lines := []string{}
+	for i := start; i < count && len(lines) < height; i++ {
+		lines = append(lines, line(i, i == selected))
+	}
+	return lines
+}
+
+func (t *tui) formatRow(row tuiRow) string {
+	if row.health == "" {
+		return t.truncate(fmt.Sprintf("  %-36s %-10s", row.service, "-"))
+	}
+	uptime := ""
+	if !row.started.IsZero() {
+		uptime = formatUptime(t.now().Sub(row.started))
+	}
+	colour := map[health]string{PASS: "\033[32m", FAIL: "\033[31m", BOOT: "\033[34m"}[row.health]
+	line := t.truncate(fmt.Sprintf("  %-36s %-10s %-8d %-6d %-6s %s", row.service, row.version, row.pid, row.port, row.health, uptime))
+	return strings.Replace(line, " "+string(row.health)+" ", " "+colour+string(row.health)+"\033[0m ", 1)
+}
+
+func (t *tui) highlight(line string, selected bool) string {
+	if !selected {
+		return line
+	}
+	// reverse video, with the marker in place of the indent
+	return "\033[7m>" + strings.TrimPrefix(line, " ") + "\033[0m"
+}
+
+func (t *tui) truncate(line string) string {
+	if t.width > 0 && len([]rune(line)) > t.width {
+		return string([]rune(line)[:t.width])
+	}
+	return line
+}
+
+// e.g. 45s, 12m, 2h03m, 3d04h
+func formatUptime(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%02dh", int(d.Hours())/24, int(d.Hours())%24)
+}
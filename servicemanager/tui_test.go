@@ -0,0 +1,133 @@
+package servicemanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("j\033[A\033[B\033[Dx\033[3~\r\177\003\033é"))
+	expected := []string{"j", "up", "down", "x", "enter", "backspace", "ctrl-c", "esc", "é"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got %q", expected, keys)
+	}
+}
+
+func newTestTui() *tui {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	t := &tui{width: 100, height: 20, now: func() time.Time { return now }}
+	t.update(
+		[]tuiRow{
+			{service: "AUTH", version: "1.2.3", pid: 100, port: 8500, health: PASS, started: now.Add(-2*time.Hour - 3*time.Minute)},
+			{service: "CATALOGUE_FRONTEND", version: "0.499.0", pid: 200, port: 9050, health: BOOT, started: now.Add(-45 * time.Second)},
+		},
+		[]string{"AUTH", "AUTH_LOGIN_STUB", "CATALOGUE_FRONTEND", "USER_DETAILS"},
+		[]string{"ALL_FRONTENDS", "AUTH_ALL"},
+	)
+	return t
+}
+
+func TestTuiActions(t *testing.T) {
+	ui := newTestTui()
+
+	if action, _ := ui.handleKey("s"); !reflect.DeepEqual(action, []string{"-start", "AUTH", "-noprogress"}) {
+		t.Errorf("expected s to start the selected service, got %v", action)
+	}
+	ui.handleKey("down")
+	if action, _ := ui.handleKey("x"); !reflect.DeepEqual(action, []string{"-stop", "CATALOGUE_FRONTEND"}) {
+		t.Errorf("expected x to stop the selected service, got %v", action)
+	}
+	ui.handleKey("down")
+	if action, _ := ui.handleKey("r"); !reflect.DeepEqual(action, []string{"-restart", "CATALOGUE_FRONTEND", "-noprogress"}) {
+		t.Errorf("expected the selection to stop at the last service, got %v", action)
+	}
+
+	// searches every service, not only the running ones
+	for _, key := range []string{"/", "u", "s", "e", "r", "enter"} {
+		ui.handleKey(key)
+	}
+	if len(ui.rows) != 1 || ui.selectedService() != "USER_DETAILS" {
+		t.Errorf("expected to find USER_DETAILS, got %v", ui.rows)
+	}
+	if action, _ := ui.handleKey("s"); !reflect.DeepEqual(action, []string{"-start", "USER_DETAILS", "-noprogress"}) {
+		t.Errorf("expected a service that isn't running to be started, got %v", action)
+	}
+	ui.handleKey("esc")
+	if len(ui.rows) != 2 {
+		t.Errorf("expected esc to go back to the running services, got %v", ui.rows)
+	}
+
+	ui.handleKey("p")
+	ui.handleKey("down")
+	if action, _ := ui.handleKey("enter"); !reflect.DeepEqual(action, []string{"-start", "AUTH_ALL", "-noprogress"}) {
+		t.Errorf("expected the profile to be started, got %v", action)
+	}
+
+	if _, quit := ui.handleKey("q"); !quit {
+		t.Errorf("expected q to quit")
+	}
+	ui.handleKey("/")
+	if _, quit := ui.handleKey("q"); quit || ui.filter != "q" {
+		t.Errorf("expected q to be searched for while searching")
+	}
+	if _, quit := ui.handleKey("ctrl-c"); !quit {
+		t.Errorf("expected ctrl-c to always quit")
+	}
+}
+
+func TestTuiRender(t *testing.T) {
+	ui := newTestTui()
+	ui.showLogs = true
+	ui.logs = []string{"starting AUTH", "listening on 8500"}
+	ui.message = "sm2 -start AUTH -noprogress: done"
+
+	screen := ui.render()
+	lines := strings.Split(screen, "\r\n")
+	if len(lines) != ui.height {
+		t.Errorf("expected the screen to be %d lines, got %d", ui.height, len(lines))
+	}
+	for _, expected := range []string{"2 running", "\033[7m> AUTH", "2h03m", "45s", "── AUTH", "listening on 8500", "done", "q quit"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("expected %q on the screen, got\n%s", expected, screen)
+		}
+	}
+
+	ui.width = 20
+	for _, line := range strings.Split(ui.render(), "\r\n") {
+		line = strings.NewReplacer("\033[H\033[2J", "", "\033[7m", "", "\033[0m", "", "\033[32m", "", "\033[34m", "").Replace(line)
+		if len([]rune(line)) > 20 {
+			t.Errorf("expected lines to be cut to the width of the terminal, got %q", line)
+		}
+	}
+}
+
+func TestFormatUptime(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		45 * time.Second:            "45s",
+		12 * time.Minute:            "12m",
+		2*time.Hour + 3*time.Minute: "2h03m",
+		76 * time.Hour:              "3d04h",
+	} {
+		if actual := formatUptime(d); actual != expected {
+			t.Errorf("expected %s to be %s, got %s", d, expected, actual)
+		}
+	}
+}
+
+func TestTuiActionsUseTheSameConfig(t *testing.T) {
+	sm := ServiceManager{}
+	if args := sm.tuiConfigArgs(); len(args) != 0 {
+		t.Errorf("expected nothing to be passed on by default, got %v", args)
+	}
+
+	sm.Commands.Config = "/home/me/my config"
+	sm.Commands.Overlay = "stub-mode"
+	sm.Commands.ProfileUrl = "https://example.com/team.json"
+	sm.Commands.DynamicPorts = true
+	expected := []string{"-config", "/home/me/my config", "-overlay", "stub-mode", "-profile-url", "https://example.com/team.json", "-dynamic-ports"}
+	if args := sm.tuiConfigArgs(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected the options choosing the config to be passed on, got %v", args)
+	}
+}
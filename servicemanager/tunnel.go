@@ -0,0 +1,154 @@
+package servicemanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// A service with "type": "tunnel" isn't downloaded or run locally. Starting it opens an ssh port-forward
+// from its port to a remote environment instead, e.g. to use a shared QA backend alongside local services:
+//
+//	"QA_AUTH": {
+//	  "type": "tunnel",
+//	  "defaultPort": 8500,
+//	  "tunnel": {"host": "me@qa-bastion", "remoteHost": "auth.qa.internal", "remotePort": 443}
+//	}
+//
+// ssh is run in a loop, so a dropped connection is reopened a few seconds later. The tunnel appears in
+// status, stop and restart like any other service, it's healthy while the forwarded port accepts connections.
+
+const (
+	TunnelType = "tunnel"
+	TUNNEL     = "tunnel" // the version shown in status
+
+	tunnelReconnectSeconds = 5
+)
+
+type Tunnel struct {
+	Host       string   `json:"host"`       // the ssh destination, e.g. user@bastion, or a host from ~/.ssh/config
+	RemoteHost string   `json:"remoteHost"` // as seen from the ssh host, defaults to localhost
+	RemotePort int      `json:"remotePort"` // defaults to the service's port
+	Args       []string `json:"args"`       // any extra ssh options, e.g. ["-i", "~/.ssh/qa"]
+}
+
+// keeps ssh running until it's stopped, $@ is the ssh command and %d the seconds to wait before reconnecting
+const tunnelLoop = `while true; do
+  echo "$(date) connecting: $*"
+  "$@"
+  echo "$(date) ssh exited with status $?, reconnecting in %ds"
+  sleep %d
+done`
+
+func (sm *ServiceManager) startTunnel(service Service, installDir string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return ledger.StateFile{}, err
+	}
+	clearExitStatus(installDir)
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	bindAddress := sm.bindAddress(service)
+	args := tunnelArgs(service, bindAddress, port)
+	loop := fmt.Sprintf(tunnelLoop, tunnelReconnectSeconds, tunnelReconnectSeconds)
+	cmd := exec.Command("sh", append([]string{"-c", loop, "sm2-tunnel-" + service.Id, "ssh"}, args...)...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+	// ssh runs in the loop's process group, so stopping the group stops both
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Version:        TUNNEL,
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    bindAddress,
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            "ssh",
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
+	}, nil
+}
+
+func tunnelArgs(service Service, bindAddress string, port int) []string {
+	remoteHost := service.Tunnel.RemoteHost
+	if remoteHost == "" {
+		remoteHost = "localhost"
+	}
+	remotePort := service.Tunnel.RemotePort
+	if remotePort == 0 {
+		remotePort = service.DefaultPort
+	}
+
+	args := []string{
+		"-N",
+		// there's no terminal to ask for passwords on, so keys or an agent are needed
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		// notice a dead connection within 45s, rather than waiting for tcp to give up
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-L", net.JoinHostPort(bindAddress, fmt.Sprint(port)) + ":" + net.JoinHostPort(remoteHost, fmt.Sprint(remotePort)),
+	}
+	args = append(args, service.Tunnel.Args...)
+	return append(args, service.Tunnel.Host)
+}
+
+// tunnels don't have a /ping/ping of their own, so unless a healthcheck is configured they're checked
+// by connecting to the forwarded port
+func tcpHealthcheckUrl(port int) string {
+	return fmt.Sprintf("tcp://localhost:%d", port)
+}
+
+func probeTcp(ctx context.Context, url string) bool {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", strings.TrimPrefix(url, "tcp://"))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// stops the reconnect loop and the ssh process it started
+func stopTunnel(pid int) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		fmt.Printf("Unable to stop tunnel pid %d, %s.\n", pid, err)
+	}
+}
+
+// mistakes in tunnel config that would otherwise only show up once ssh fails
+func lintTunnel(id string, s Service) []string {
+	problems := []string{}
+	if s.Type != "" && s.Type != TunnelType && s.Type != WiremockType && s.Type != PactType && !isInfra(s) {
+		return append(problems, fmt.Sprintf("%s has an unknown type %s", id, s.Type))
+	}
+	if s.Type == TunnelType {
+		if s.Tunnel.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s is a tunnel but has no tunnel.host", id))
+		}
+		if s.DefaultPort == 0 {
+			problems = append(problems, fmt.Sprintf("%s is a tunnel but has no defaultPort to forward", id))
+		}
+	}
+	return problems
+}
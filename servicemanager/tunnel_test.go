@@ -0,0 +1,86 @@
+package servicemanager
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "sm2/testing"
+)
+
+func TestTunnelArgs(t *testing.T) {
+	service := Service{Id: "QA_AUTH", Type: TunnelType, DefaultPort: 8500, Tunnel: Tunnel{Host: "me@bastion", RemoteHost: "auth.qa", Args: []string{"-i", "qa.key"}}}
+
+	args := tunnelArgs(service, "127.0.0.1", 41000)
+	if args[len(args)-1] != "me@bastion" || !reflect.DeepEqual(args[len(args)-3:len(args)-1], []string{"-i", "qa.key"}) {
+		t.Errorf("expected the extra args and then the host last, got %v", args)
+	}
+	if !strings.Contains(strings.Join(args, " "), "-L 127.0.0.1:41000:auth.qa:8500") {
+		t.Errorf("expected the local port to be forwarded to the remote default port, got %v", args)
+	}
+
+	sm := ServiceManager{}
+	if url := sm.serviceHealthcheckUrl(service, 8500); url != "tcp://localhost:8500" {
+		t.Errorf("expected a tcp healthcheck, got %s", url)
+	}
+
+	problems := lintTunnel("QA_AUTH", Service{Type: TunnelType})
+	expected := []string{"QA_AUTH is a tunnel but has no tunnel.host", "QA_AUTH is a tunnel but has no defaultPort to forward"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected %v got %v", expected, problems)
+	}
+}
+
+func TestProbeTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	AssertNotErr(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !probeTcp(ctx, tcpHealthcheckUrl(port)) {
+		t.Errorf("expected the open port to be healthy")
+	}
+	listener.Close()
+	if probeTcp(ctx, tcpHealthcheckUrl(port)) {
+		t.Errorf("expected the closed port to be unhealthy")
+	}
+}
+
+func TestStartTunnel(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-tunnel*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	// a fake ssh that drops straight away, so the loop has something to reconnect
+	bin := path.Join(dir, "bin")
+	AssertNotErr(t, os.MkdirAll(bin, 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(bin, "ssh"), []byte("#!/bin/sh\necho fake ssh $*\nexit 255\n"), 0755))
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: dir}}
+	service := Service{Id: "QA_AUTH", Type: TunnelType, DefaultPort: 8500, Tunnel: Tunnel{Host: "bastion"}}
+	installDir := path.Join(dir, "qa_auth-tunnel")
+
+	state, err := sm.startTunnel(service, installDir, 8500, tcpHealthcheckUrl(8500))
+	AssertNotErr(t, err)
+	defer stopTunnel(state.Pid)
+	if state.Version != TUNNEL || state.BindAddress != DefaultBindAddress {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	logs := ""
+	for i := 0; i < 20 && !strings.Contains(logs, "reconnecting in 5s"); i++ {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := os.ReadFile(service.Output.stdoutFile(service.Output.logDir(service.Id, installDir)))
+		logs = string(data)
+	}
+	if !strings.Contains(logs, "fake ssh -N") || !strings.Contains(logs, "ssh exited with status 255, reconnecting in 5s") {
+		t.Errorf("expected the dropped connection to be logged, got %s", logs)
+	}
+}
@@ -3,21 +3,71 @@ package servicemanager
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sm2/cli"
 	"sm2/version"
 	"strings"
 )
 
-func update(workspaceInstallPath string) error {
+// sm2 -update installs the latest release from a channel: stable (the default) or beta, which includes
+// pre-releases. The channel comes from the updateChannel setting, or can be given once, e.g. sm2 -update beta.
+//
+// Releases come from github, or from the mirror in the updateUrl setting, which has the same layout
+// as github's downloads, along with a file per channel naming its latest version:
+//
+//	https://mirror.example.com/sm2/latest-stable.txt           1.2.0
+//	https://mirror.example.com/sm2/v1.2.0/sm2-1.2.0-linux-intel.zip
+//	https://mirror.example.com/sm2/v1.2.0/sm2-1.2.0-linux-intel.zip.sha256
+//
+// The zip is only installed if its checksum matches the .sha256 published with it, and the new binary
+// runs. It's swapped in with a rename, so sm2 is never left half written.
+
+const (
+	releasesUrl        = "https://github.com/hmrc/sm2/releases"
+	releasesApiUrl     = "https://api.github.com/repos/hmrc/sm2/releases"
+	stableChannel      = "stable"
+	betaChannel        = "beta"
+	defaultDownloadUrl = releasesUrl + "/download"
+)
+
+type updater struct {
+	client  *http.Client
+	channel string
+	mirror  string // the updateUrl setting, github if it's empty
+	goos    string
+	goarch  string
+}
+
+func (sm *ServiceManager) update(channel string) error {
+	if channel == "" {
+		channel = sm.Commands.Settings["updateChannel"]
+	}
+	if channel == "" {
+		channel = stableChannel
+	}
+	if channel != stableChannel && channel != betaChannel {
+		return fmt.Errorf("%s is not a release channel, use %s", channel, strings.Join(cli.UpdateChannels, " or "))
+	}
+
+	u := updater{
+		client:  &http.Client{Timeout: sm.Client.Timeout},
+		channel: channel,
+		mirror:  strings.TrimSuffix(sm.Commands.Settings["updateUrl"], "/"),
+		goos:    runtime.GOOS,
+		goarch:  runtime.GOARCH,
+	}
+
 	currentVersion := version.Version
-	latestVersion, err := getLatestVersion()
+	latestVersion, err := u.latestVersion()
 	if err != nil {
 		return err
 	}
@@ -34,139 +84,242 @@ func update(workspaceInstallPath string) error {
 	}
 
 	fmt.Printf("Current Version: %s\n", currentVersion)
-	fmt.Printf("Latest Version:  %s\n", latestVersion)
+	fmt.Printf("Latest Version:  %s (%s)\n", latestVersion, channel)
 	fmt.Printf("OS:  %s\n", runtime.GOOS)
 	fmt.Printf("CPU: %s\n", runtime.GOARCH)
 	fmt.Printf("Current Install Location: %s\n", installLocation)
 
-	err = downloadAndInstall(latestVersion, workspaceInstallPath, installLocation)
+	binary, err := u.download(latestVersion)
 	if err != nil {
 		return err
 	}
+	if err := install(binary, installLocation, sm.Config.TmpDir); err != nil {
+		return err
+	}
 
+	fmt.Printf("Successfully installed v%s!\n", latestVersion)
 	return nil
 }
 
-func getLatestVersion() (string, error) {
+func (u updater) latestVersion() (string, error) {
+	switch {
+	case u.mirror != "":
+		body, err := u.get(fmt.Sprintf("%s/latest-%s.txt", u.mirror, u.channel))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(strings.TrimSpace(string(body)), "v"), nil
+	case u.channel == betaChannel:
+		return u.latestGithubRelease()
+	default:
+		return u.latestGithubStableRelease()
+	}
+}
+
+// github redirects releases/latest to the latest release that isn't a pre-release
+func (u updater) latestGithubStableRelease() (string, error) {
 	// create a custom client that doesn't follow redirects
 	client := &http.Client{
+		Timeout: u.client.Timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	// hit releases/latest which will redirect us
-	resp, err := client.Get("https://github.com/hmrc/sm2/releases/latest")
+	resp, err := client.Get(releasesUrl + "/latest")
 	if err != nil {
 		return "", err
 	}
-
 	defer resp.Body.Close()
 
 	// extract the redirect location, should look like https://github.com/hmrc/sm2/releases/tag/v0.0.0
 	location := resp.Header.Get("location")
+	if location == "" {
+		return "", fmt.Errorf("unable to find the latest release, github returned %s", resp.Status)
+	}
 
 	// split the url and extract the version from the tag
 	parts := strings.Split(location, "/")
 	tag := parts[len(parts)-1]
-	version := strings.TrimPrefix(tag, "v")
-
-	return version, nil
+	return strings.TrimPrefix(tag, "v"), nil
 }
 
-func getInstallLocation() (string, error) {
-	output, err := os.Executable()
-
+// the newest release, including pre-releases
+func (u updater) latestGithubRelease() (string, error) {
+	body, err := u.get(releasesApiUrl + "?per_page=20")
 	if err != nil {
-		fmt.Println("Unable to determine the location of the sm2 binary currently installed.")
 		return "", err
 	}
-	return filepath.EvalSymlinks(output)
+	releases := []struct {
+		TagName string `json:"tag_name"`
+		Draft   bool   `json:"draft"`
+	}{}
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return "", fmt.Errorf("unable to read the list of releases: %s", err)
+	}
+	for _, release := range releases {
+		if !release.Draft {
+			return strings.TrimPrefix(release.TagName, "v"), nil
+		}
+	}
+	return "", fmt.Errorf("there are no releases")
 }
 
-func downloadAndInstall(versionToInstall string, workspaceInstallPath string, installLocation string) error {
-
-	// we'll download and inflate the zip into $WORKSPACE/install
-	downloadLocation := workspaceInstallPath + "/sm2"
-
+// the name of the release zip for this os and cpu
+func (u updater) artifact(versionToInstall string) (string, error) {
 	// convert `darwin` to `apple` for download url
 	var os string
-	switch runtime.GOOS {
+	switch u.goos {
 	case "darwin":
 		os = "apple"
 	case "linux":
 		os = "linux"
 	default:
-		log.Fatalf("unsupported OS: %s", runtime.GOOS)
+		return "", fmt.Errorf("unsupported OS: %s", u.goos)
 	}
 
 	// convert `amd64` to `intel` for download url
 	var arch string
-	switch runtime.GOARCH {
+	switch u.goarch {
 	case "amd64":
 		arch = "intel"
 	case "arm64":
 		arch = "arm64"
 	default:
-		log.Fatalf("unsupported CPU architecture %s", runtime.GOARCH)
+		return "", fmt.Errorf("unsupported CPU architecture %s", u.goarch)
 	}
 
-	downloadUrl := fmt.Sprintf("https://github.com/hmrc/sm2/releases/download/v%s/sm2-%s-%s-%s.zip", versionToInstall, versionToInstall, os, arch)
+	return fmt.Sprintf("sm2-%s-%s-%s.zip", versionToInstall, os, arch), nil
+}
 
-	fmt.Printf("Downloading %s...\n", downloadUrl)
+// downloads the release and checks its checksum, returning the sm2 binary in it
+func (u updater) download(versionToInstall string) ([]byte, error) {
+	artifact, err := u.artifact(versionToInstall)
+	if err != nil {
+		return nil, err
+	}
+	base := defaultDownloadUrl
+	if u.mirror != "" {
+		base = u.mirror
+	}
+	downloadUrl := fmt.Sprintf("%s/v%s/%s", base, versionToInstall, artifact)
 
-	resp, err := http.Get(downloadUrl)
+	fmt.Printf("Downloading %s...\n", downloadUrl)
+	body, err := u.get(downloadUrl)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	checksum, err := u.get(downloadUrl + ".sha256")
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("unable to verify %s, there's no checksum for it: %s", artifact, err)
+	}
+	// either just the hash, or the hash and file name like sha256sum writes
+	expected := strings.Fields(string(checksum))
+	actual := sha256.Sum256(body)
+	if len(expected) == 0 || !strings.EqualFold(expected[0], hex.EncodeToString(actual[:])) {
+		return nil, fmt.Errorf("the checksum of %s doesn't match the one published with it, it hasn't been installed", artifact)
 	}
 
 	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	for _, zipFile := range zipReader.File {
 		if zipFile.Name == "sm2" {
 			rc, err := zipFile.Open()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return nil, fmt.Errorf("%s doesn't contain sm2", artifact)
+}
 
-			fileData, err := ioutil.ReadAll(rc)
-			if err != nil {
-				return err
-			}
+func (u updater) get(url string) ([]byte, error) {
+	resp, err := u.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
 
-			fmt.Printf("Unzipping into %s...\n", downloadLocation)
+func getInstallLocation() (string, error) {
+	output, err := os.Executable()
 
-			err = ioutil.WriteFile(downloadLocation, fileData, 0755)
-			if err != nil {
-				return err
-			}
-		}
+	if err != nil {
+		fmt.Println("Unable to determine the location of the sm2 binary currently installed.")
+		return "", err
 	}
+	return filepath.EvalSymlinks(output)
+}
 
-	fmt.Printf("Moving new sm2 binary from %s to %s...\n", downloadLocation, installLocation)
+// replaces the binary at installLocation, by writing the new one alongside it and renaming it over
+// the top, using sudo if the directory isn't writable
+func install(binary []byte, installLocation string, workspaceInstallPath string) error {
+	dir := filepath.Dir(installLocation)
+	tmp, err := os.CreateTemp(dir, ".sm2-update-*")
+	if err != nil {
+		return installWithSudo(binary, installLocation, workspaceInstallPath)
+	}
+	defer os.Remove(tmp.Name())
 
-	// attempt to move without sudo first
-	err = exec.Command("mv", downloadLocation, installLocation).Run()
+	if err := writeBinary(tmp, binary); err != nil {
+		return err
+	}
+	if err := checkBinary(tmp.Name()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Moving new sm2 binary to %s...\n", installLocation)
+	return os.Rename(tmp.Name(), installLocation)
+}
+
+func installWithSudo(binary []byte, installLocation string, workspaceInstallPath string) error {
+	tmp, err := os.CreateTemp(workspaceInstallPath, "sm2-update-*")
 	if err != nil {
-		fmt.Printf("Moving the new sm2 binary to %s requires `sudo` - you may be prompted for your password...\n", installLocation)
-		// failed so attempting with sudo
-		err = exec.Command("sudo", "mv", downloadLocation, installLocation).Run()
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeBinary(tmp, binary); err != nil {
+		return err
+	}
+	if err := checkBinary(tmp.Name()); err != nil {
+		return err
 	}
 
-	fmt.Printf("Successfully installed v%s!\n", versionToInstall)
+	// moved next to the old binary first, so replacing it is a rename rather than a copy
+	fmt.Printf("Moving the new sm2 binary to %s requires `sudo` - you may be prompted for your password...\n", installLocation)
+	staged := filepath.Join(filepath.Dir(installLocation), ".sm2-update")
+	if err := sudo("mv", tmp.Name(), staged).Run(); err != nil {
+		return err
+	}
+	return sudo("mv", staged, installLocation).Run()
+}
 
+func writeBinary(f *os.File, binary []byte) error {
+	if _, err := f.Write(binary); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0755); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// makes sure the download runs before it replaces the binary that does
+func checkBinary(file string) error {
+	if out, err := exec.Command(file, "-version").CombinedOutput(); err != nil {
+		return fmt.Errorf("the new sm2 binary doesn't run, it hasn't been installed: %s %s", err, strings.TrimSpace(string(out)))
+	}
 	return nil
 }
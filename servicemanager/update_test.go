@@ -0,0 +1,125 @@
+package servicemanager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func zipOf(t *testing.T, name string, content string) []byte {
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+	f, err := w.Create(name)
+	AssertNotErr(t, err)
+	f.Write([]byte(content))
+	AssertNotErr(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestUpdateFromMirror(t *testing.T) {
+	release := zipOf(t, "sm2", "new sm2")
+	sum := sha256.Sum256(release)
+	checksum := hex.EncodeToString(sum[:]) + "  sm2-1.3.0-beta1-linux-arm64.zip\n"
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sm2/latest-beta.txt":
+			w.Write([]byte("v1.3.0-beta1\n"))
+		case "/sm2/v1.3.0-beta1/sm2-1.3.0-beta1-linux-arm64.zip":
+			w.Write(release)
+		case "/sm2/v1.3.0-beta1/sm2-1.3.0-beta1-linux-arm64.zip.sha256":
+			w.Write([]byte(checksum))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	u := updater{client: mirror.Client(), channel: betaChannel, mirror: mirror.URL + "/sm2", goos: "linux", goarch: "arm64"}
+	latest, err := u.latestVersion()
+	AssertNotErr(t, err)
+	if latest != "1.3.0-beta1" {
+		t.Errorf("expected the latest beta, got %s", latest)
+	}
+
+	binary, err := u.download(latest)
+	AssertNotErr(t, err)
+	if string(binary) != "new sm2" {
+		t.Errorf("expected the binary from the zip, got %s", binary)
+	}
+
+	checksum = strings.Repeat("0", 64)
+	if _, err := u.download(latest); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a bad checksum to stop the update, got %v", err)
+	}
+
+	u.goarch = "amd64"
+	if _, err := u.download(latest); err == nil {
+		t.Errorf("expected a missing release to fail")
+	}
+
+	u.channel = stableChannel
+	if _, err := u.latestVersion(); err == nil {
+		t.Errorf("expected an error when the mirror doesn't have the channel")
+	}
+}
+
+func TestUpdateNeedsAChecksum(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".zip") {
+			w.Write(zipOf(t, "sm2", "new sm2"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mirror.Close()
+
+	u := updater{client: mirror.Client(), channel: stableChannel, mirror: mirror.URL, goos: "darwin", goarch: "amd64"}
+	if _, err := u.download("1.2.0"); err == nil || !strings.Contains(err.Error(), "no checksum") {
+		t.Errorf("expected a release without a checksum not to be installed, got %v", err)
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	artifact, err := updater{goos: "darwin", goarch: "amd64"}.artifact("1.2.0")
+	AssertNotErr(t, err)
+	if artifact != "sm2-1.2.0-apple-intel.zip" {
+		t.Errorf("unexpected artifact %s", artifact)
+	}
+	if _, err := (updater{goos: "windows", goarch: "amd64"}).artifact("1.2.0"); err == nil {
+		t.Errorf("expected windows to be unsupported")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	installLocation := path.Join(dir, "sm2")
+	AssertNotErr(t, os.WriteFile(installLocation, []byte("#!/bin/sh\necho old\n"), 0755))
+
+	if err := install([]byte("#!/bin/sh\nexit 1\n"), installLocation, dir); err == nil {
+		t.Errorf("expected a binary that doesn't run not to be installed")
+	}
+	if data, _ := os.ReadFile(installLocation); string(data) != "#!/bin/sh\necho old\n" {
+		t.Errorf("expected the old binary to be kept, got %s", data)
+	}
+
+	AssertNotErr(t, install([]byte("#!/bin/sh\necho new\n"), installLocation, dir))
+	if data, _ := os.ReadFile(installLocation); string(data) != "#!/bin/sh\necho new\n" {
+		t.Errorf("expected the new binary to be installed, got %s", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected the temp file to be gone, got %d files", len(entries))
+	}
+	if info, _ := os.Stat(installLocation); info.Mode().Perm() != 0755 {
+		t.Errorf("expected the new binary to be executable, got %s", info.Mode())
+	}
+}
@@ -2,9 +2,16 @@ package servicemanager
 
 import (
 	"fmt"
+	"os"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
 )
 
+const configPinFile = "sm2-pin" // kept in .git so its never committed
+const unpinConfig = "none"
+
 /* Attempts to git pull the service-manager-config in $WORKSPACE
    Fails if not on main, bound to the --update-config cmd
 */
@@ -15,6 +22,12 @@ func updateConfig(configRepo string) error {
 		return fmt.Errorf("%s does not appear to be a .git repository", configRepo)
 	}
 
+	// leave pinned config alone, thats the point of pinning it
+	if pin := readConfigPin(configRepo); pin != "" {
+		fmt.Printf("Config is pinned to %s, not updating. Use -pin-config %s to unpin it.\n", pin, unpinConfig)
+		return nil
+	}
+
 	// get the branch and ensure we're on main
 	branch, err := gitCurrentBranch(configRepo)
 	if err != nil {
@@ -26,10 +39,15 @@ func updateConfig(configRepo string) error {
 		return fmt.Errorf("Unable to update config!\nExpected main branch to be checked out, instead found `%s`.\nTo fix this, please run git checkout -b main in %s", branch, configRepo)
 	}
 
+	before, err := gitHead(configRepo)
+	if err != nil {
+		return err
+	}
+
 	// pull the changes
 	fmt.Printf("Config repo located at: %s\n", configRepo)
 	fmt.Print("Pulling down the latest config... ")
-	err = gitPull(configRepo)
+	err = gitFetchLatest(configRepo, "main")
 	if err != nil {
 		fmt.Printf("Update failed!\n")
 		return err
@@ -41,5 +59,143 @@ func updateConfig(configRepo string) error {
 		return err
 	}
 	fmt.Printf("Done!\n\nLatest commit [%s]\n\n", latestCommit)
+
+	printConfigChanges(configRepo, before)
 	return nil
 }
+
+// Brings a branch up to date with origin. Shallow clones are kept shallow by only fetching the latest commit,
+// which can't be merged (the history isn't there) so the working copy is moved to it instead.
+func gitFetchLatest(configRepo string, branch string) error {
+	shallow := gitIsShallow(configRepo)
+	if err := gitFetchRef(configRepo, branch, shallow); err != nil {
+		return err
+	}
+	if shallow {
+		// --keep refuses to throw away uncommitted changes
+		return gitRun(configRepo, "reset", "--quiet", "--keep", "FETCH_HEAD")
+	}
+	return gitRun(configRepo, "merge", "--quiet", "--ff-only", "FETCH_HEAD")
+}
+
+// Checks out a specific branch, tag or commit of the config and stops --update-config from moving it,
+// so everyone (or CI) can run against exactly the same service definitions. Bound to --pin-config.
+func pinConfig(configRepo string, ref string) error {
+
+	if !Exists(path.Join(configRepo, ".git")) {
+		return fmt.Errorf("%s does not appear to be a .git repository", configRepo)
+	}
+
+	if ref == unpinConfig {
+		if err := gitRun(configRepo, "checkout", "--quiet", "main"); err != nil {
+			return err
+		}
+		os.Remove(path.Join(configRepo, ".git", configPinFile))
+		fmt.Println("Config is no longer pinned.")
+		return updateConfig(configRepo)
+	}
+
+	before, err := gitHead(configRepo)
+	if err != nil {
+		return err
+	}
+
+	// the ref may only exist locally, in which case theres nothing to fetch
+	target := ref
+	if err := gitFetchRef(configRepo, ref, gitIsShallow(configRepo)); err == nil {
+		target = "FETCH_HEAD"
+	}
+
+	if err := gitRun(configRepo, "checkout", "--quiet", "--detach", target); err != nil {
+		return fmt.Errorf("Unable to pin config to %s\n%s", ref, err)
+	}
+
+	if err := os.WriteFile(path.Join(configRepo, ".git", configPinFile), []byte(ref+"\n"), 0644); err != nil {
+		return err
+	}
+
+	commit, err := gitLastCommit(configRepo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Config pinned to %s [%s]\n\n", ref, commit)
+
+	printConfigChanges(configRepo, before)
+	return nil
+}
+
+// returns the ref the config is pinned to, or "" if it isn't
+func readConfigPin(configRepo string) string {
+	pin, err := os.ReadFile(path.Join(configRepo, ".git", configPinFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(pin))
+}
+
+// prints the services and profiles that were added, removed or changed since a given commit
+func printConfigChanges(configRepo string, since string) {
+	for _, name := range []string{"services", "profiles"} {
+		file, err := findConfigFile(configRepo, name)
+		if err != nil {
+			continue
+		}
+
+		current, err := readJsonObject(file)
+		if err != nil {
+			fmt.Printf("Unable to compare %s: %s\n", path.Base(file), err)
+			continue
+		}
+
+		// if the file didn't exist before everything in it is new
+		previous := map[string]interface{}{}
+		if data, err := gitShowFile(configRepo, since, path.Base(file)); err == nil {
+			if data, err = configToJson(file, data); err == nil {
+				if obj, err := decodeJsonObject(data); err == nil {
+					previous = obj
+				}
+			}
+		}
+
+		added, removed, changed := compareConfig(previous, current)
+		if len(added)+len(removed)+len(changed) == 0 {
+			fmt.Printf("No changes to %s\n", name)
+			continue
+		}
+
+		fmt.Printf("Changes to %s:\n", name)
+		printConfigChange("added", added)
+		printConfigChange("removed", removed)
+		printConfigChange("changed", changed)
+	}
+}
+
+func printConfigChange(change string, ids []string) {
+	if len(ids) > 0 {
+		fmt.Printf("  %d %s: %s\n", len(ids), change, strings.Join(ids, ", "))
+	}
+}
+
+// compares the entries in two versions of services.json or profiles.json
+func compareConfig(previous map[string]interface{}, current map[string]interface{}) ([]string, []string, []string) {
+	added, removed, changed := []string{}, []string{}, []string{}
+
+	for id, entry := range current {
+		if old, ok := previous[id]; !ok {
+			added = append(added, id)
+		} else if !reflect.DeepEqual(old, entry) {
+			changed = append(changed, id)
+		}
+	}
+
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
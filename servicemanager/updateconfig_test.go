@@ -0,0 +1,43 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestCompareConfig(t *testing.T) {
+	previous, _ := decodeJsonObject([]byte(`{"FOO": {"defaultPort": 8000}, "BAR": {"defaultPort": 9000}, "OLD": {}}`))
+	current, _ := decodeJsonObject([]byte(`{"FOO": {"defaultPort": 8000}, "BAR": {"defaultPort": 9001}, "NEW": {}, "ALSO_NEW": {}}`))
+
+	added, removed, changed := compareConfig(previous, current)
+
+	if !reflect.DeepEqual(added, []string{"ALSO_NEW", "NEW"}) {
+		t.Errorf("unexpected added services %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"OLD"}) {
+		t.Errorf("unexpected removed services %v", removed)
+	}
+	if !reflect.DeepEqual(changed, []string{"BAR"}) {
+		t.Errorf("unexpected changed services %v", changed)
+	}
+}
+
+func TestReadConfigPin(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-pin*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(path.Join(dir, ".git"), 0755)
+	if pin := readConfigPin(dir); pin != "" {
+		t.Errorf("expected config not to be pinned, got %s", pin)
+	}
+
+	os.WriteFile(path.Join(dir, ".git", configPinFile), []byte("v1.2.3\n"), 0644)
+	if pin := readConfigPin(dir); pin != "v1.2.3" {
+		t.Errorf("expected config to be pinned to v1.2.3, got %s", pin)
+	}
+}
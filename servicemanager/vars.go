@@ -0,0 +1,126 @@
+package servicemanager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// services.json can have a top level vars section for values that are shared by lots of services, e.g.
+//
+//	"vars": { "hmrcGroup": "uk.gov.hmrc", "playMemory": "-J-Xmx256m", "stubsHost": "localhost:9999" },
+//	"MY_SERVICE": {
+//	  "binary": { "groupId": "${vars.hmrcGroup}", "cmd": ["./my-service/bin/my-service", "${vars.playMemory}"] }
+//	}
+//
+// A reference that's the whole value is replaced with the var as it is, so vars can be numbers or
+// lists too. Vars can be set in included config and overrides like anything else, but can't refer to
+// other vars. They're expanded after templates, so templates can use them as well.
+
+const varsKey = "vars"
+
+type Vars map[string]interface{}
+
+var varReference = regexp.MustCompile(`\$\{vars\.([A-Za-z0-9_.-]+)\}`)
+
+// removes the vars section from services.json, so it isn't mistaken for a service
+func takeVars(services map[string]interface{}) (Vars, error) {
+	vars := Vars{}
+	if v, ok := services[varsKey]; ok && v != nil {
+		if vars, ok = v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("vars should be an object of names and values")
+		}
+	}
+	delete(services, varsKey)
+	return vars, nil
+}
+
+// fills in every reference to a var
+func expandVars(services map[string]interface{}, vars Vars) error {
+	ids := []string{}
+	for id := range services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		expanded, err := fillVars(services[id], vars, id)
+		if err != nil {
+			return err
+		}
+		services[id] = expanded
+	}
+	return nil
+}
+
+func fillVars(value interface{}, vars Vars, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if whole := varReference.FindStringSubmatch(v); whole != nil && whole[0] == v {
+			found, ok := vars[whole[1]]
+			if !ok {
+				return nil, undefinedVarError(path, whole[1])
+			}
+			return copyJson(found), nil
+		}
+
+		var err error
+		filled := varReference.ReplaceAllStringFunc(v, func(ref string) string {
+			name := varReference.FindStringSubmatch(ref)[1]
+			found, ok := vars[name]
+			switch {
+			case !ok:
+				err = undefinedVarError(path, name)
+			case isJsonContainer(found):
+				err = fmt.Errorf("%s: ${vars.%s} is a list or object, so it can only be used as the whole value", path, name)
+			}
+			return fmt.Sprint(found)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return filled, nil
+
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			filled, err := fillVars(v[k], vars, joinPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			v[k] = filled
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			filled, err := fillVars(item, vars, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = filled
+		}
+	}
+	return value, nil
+}
+
+func isJsonContainer(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+func undefinedVarError(path string, name string) error {
+	return fmt.Errorf("%s: ${vars.%s} is not defined in vars", path, name)
+}
+
+// true if a string is just a reference to a var, so its type can't be checked until it's expanded
+func isVarReference(value string) bool {
+	return strings.HasPrefix(value, "${vars.") && varReference.FindString(value) == value
+}
@@ -0,0 +1,70 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestLoadServicesWithVars(t *testing.T) {
+	configDir, err := os.MkdirTemp(os.TempDir(), "test-vars*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(configDir)
+
+	os.WriteFile(path.Join(configDir, "services.json"), []byte(`{
+		"vars": {"group": "uk.gov.hmrc", "memory": "-J-Xmx256m", "port": 9000, "stubArgs": ["-Dstub=true"]},
+		"AUTH": {"name": "auth", "defaultPort": "${vars.port}", "binary": {"groupId": "${vars.group}", "artifact": "auth_%%", "cmd": ["./auth/bin/auth", "${vars.memory}", "-Dgroup=${vars.group}"]}},
+		"STUB": {"name": "stub", "defaultPort": 1234, "binary": {"cmd": "${vars.stubArgs}"}}
+	}`), 0644)
+	overrides := path.Join(configDir, "overrides.json")
+	os.WriteFile(overrides, []byte(`{"vars": {"memory": "-J-Xmx1g"}}`), 0644)
+
+	services, err := loadServicesFromFile(path.Join(configDir, "services.json"), nil, overrides)
+	AssertNotErr(t, err)
+
+	if _, ok := (*services)["vars"]; ok {
+		t.Errorf("expected vars not to be loaded as a service")
+	}
+
+	auth := (*services)["AUTH"]
+	if auth.DefaultPort != 9000 || auth.Binary.GroupId != "uk.gov.hmrc" {
+		t.Errorf("expected AUTH to be filled in from vars, got %+v", auth)
+	}
+	if !reflect.DeepEqual(auth.Binary.Cmd, []string{"./auth/bin/auth", "-J-Xmx1g", "-Dgroup=uk.gov.hmrc"}) {
+		t.Errorf("expected vars (including overridden ones) in args, got %v", auth.Binary.Cmd)
+	}
+
+	if stub := (*services)["STUB"]; !reflect.DeepEqual(stub.Binary.Cmd, []string{"-Dstub=true"}) {
+		t.Errorf("expected a list var to replace the whole value, got %v", stub.Binary.Cmd)
+	}
+}
+
+func TestUndefinedVars(t *testing.T) {
+	cases := map[string]string{
+		`{"AUTH": {"binary": {"groupId": "${vars.missing}"}}}`:                                    "AUTH.binary.groupId: ${vars.missing} is not defined",
+		`{"vars": {"a": "1"}, "AUTH": {"binary": {"cmd": ["./run", "-Dx=${vars.b}"]}}}`:           "AUTH.binary.cmd[1]: ${vars.b} is not defined",
+		`{"vars": {"args": ["-Dx"]}, "AUTH": {"binary": {"cmd": ["./run", "-Dx=${vars.args}"]}}}`: "can only be used as the whole value",
+		`{"vars": ["not", "an", "object"], "AUTH": {}}`:                                           "vars should be an object",
+	}
+
+	for data, expected := range cases {
+		_, err := expandServices([]byte(data), nil, nil)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing %q for %s, got %v", expected, data, err)
+		}
+	}
+}
+
+func TestValidateVars(t *testing.T) {
+	data := []byte(`{
+  "vars": {"port": 9000, "group": "uk.gov.hmrc"},
+  "AUTH": {"name": "auth", "defaultPort": "${vars.port}", "binary": {"groupId": "${vars.group}", "artifact": "auth", "cmd": ["./run"]}}
+}`)
+	if problems := validateConfig("services.json", data, reflect.TypeOf(Services{})); len(problems) > 0 {
+		t.Errorf("expected vars and references to them to be valid, got %v", problems)
+	}
+}
@@ -0,0 +1,170 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Writes VS Code tasks to start, stop and check the given services and profiles, and launch configs to
+// attach a debugger to each of their services, e.g.
+//
+//	sm2 -export-vscode CORE_STACK
+//
+// updates .vscode/tasks.json and .vscode/launch.json in the current directory. Entries sm2 wrote before are
+// replaced, anything else in the files is kept. The debugger configs use the ports services listen on
+// when they're started with -jdwp, which is what the "with debugger" tasks do.
+
+const vscodePrefix = "sm2: "
+
+func (sm *ServiceManager) ExportVscode(dir string, out io.Writer) error {
+	names, err := sm.ideExportNames("-export-vscode")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tasks := vscodeTasks(names)
+	if err := mergeVscodeFile(path.Join(dir, "tasks.json"), "tasks", "label", tasks, "2.0.0"); err != nil {
+		return err
+	}
+	configs := sm.vscodeAttachConfigs()
+	if err := mergeVscodeFile(path.Join(dir, "launch.json"), "configurations", "name", configs, "0.2.0"); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Wrote %d task(s) to %s and %d debugger config(s) to %s\n", len(tasks), path.Join(dir, "tasks.json"), len(configs), path.Join(dir, "launch.json"))
+	return nil
+}
+
+// an sm2 command for an ide to run
+type ideTask struct {
+	label string
+	args  []string
+}
+
+// a start (with and without the debugger) and stop task for each service or profile, and one for status
+func ideTasks(names []string) []ideTask {
+	tasks := []ideTask{}
+	for _, name := range names {
+		tasks = append(tasks,
+			ideTask{"start " + name, []string{"-start", name, "-noprogress"}},
+			ideTask{"start " + name + " with debugger", []string{"-start", name, "-noprogress", "-jdwp"}},
+			ideTask{"stop " + name, []string{"-stop", name}},
+		)
+	}
+	return append(tasks, ideTask{"status", []string{"-s"}})
+}
+
+// the services and profiles named on the command line, which have to exist
+func (sm *ServiceManager) ideExportNames(flag string) ([]string, error) {
+	names := sm.Commands.ExtraServices
+	if len(names) == 0 {
+		return nil, fmt.Errorf("name the services or profiles to export, e.g. sm2 %s CORE_STACK", flag)
+	}
+	for _, name := range names {
+		if _, isProfile := sm.Profiles[name]; !isProfile {
+			if _, isService := sm.Services[sm.renamedService(parseServiceAndVersion(name).service)]; !isService {
+				return nil, fmt.Errorf("%s is not a service or a profile", name)
+			}
+		}
+	}
+	return names, nil
+}
+
+func vscodeTasks(names []string) []map[string]interface{} {
+	tasks := []map[string]interface{}{}
+	for _, task := range ideTasks(names) {
+		tasks = append(tasks, map[string]interface{}{
+			"label":          vscodePrefix + task.label,
+			"type":           "shell",
+			"command":        "sm2",
+			"args":           task.args,
+			"problemMatcher": []string{},
+		})
+	}
+	return tasks
+}
+
+type debugTarget struct {
+	service string
+	port    int
+}
+
+// the debug port of each jvm service in the given services and profiles
+func (sm *ServiceManager) debugTargets() []debugTarget {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, sv := range sm.requestedServicesAndProfiles() {
+		service, ok := sm.Services[sv.service]
+		if !ok || seen[sv.service] || service.Type == TunnelType || service.Type == WiremockType || service.Type == PactType || isInfra(service) || sm.runsInDocker(service) {
+			continue
+		}
+		seen[sv.service] = true
+		ids = append(ids, sv.service)
+	}
+	sort.Strings(ids)
+
+	targets := []debugTarget{}
+	for _, id := range ids {
+		if port := sm.findDebugPort(sm.Services[id]); port > 0 {
+			targets = append(targets, debugTarget{id, port})
+		}
+	}
+	return targets
+}
+
+func (sm *ServiceManager) vscodeAttachConfigs() []map[string]interface{} {
+	configs := []map[string]interface{}{}
+	for _, target := range sm.debugTargets() {
+		configs = append(configs, map[string]interface{}{
+			"type":     "java",
+			"name":     vscodePrefix + "attach to " + target.service,
+			"request":  "attach",
+			"hostName": "localhost",
+			"port":     target.port,
+		})
+	}
+	return configs
+}
+
+// replaces the entries sm2 wrote last time, keeping everything else
+func mergeVscodeFile(file string, listKey string, nameKey string, entries []map[string]interface{}, version string) error {
+	doc := map[string]interface{}{"version": version}
+	if data, err := os.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			// vs code allows comments, which would be lost
+			return fmt.Errorf("%s isn't plain json (it may have comments), so it can't be updated: %s", file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	merged := []interface{}{}
+	if existing, ok := doc[listKey].([]interface{}); ok {
+		for _, entry := range existing {
+			if m, ok := entry.(map[string]interface{}); ok {
+				if name, _ := m[nameKey].(string); strings.HasPrefix(name, vscodePrefix) {
+					continue
+				}
+			}
+			merged = append(merged, entry)
+		}
+	}
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	doc[listKey] = merged
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,81 @@
+package servicemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"sm2/cli"
+	"sm2/ledger"
+	. "sm2/testing"
+)
+
+func TestExportVscode(t *testing.T) {
+	dir := path.Join(t.TempDir(), ".vscode")
+	AssertNotErr(t, os.MkdirAll(dir, 0755))
+	// the user's own entries, and an old one sm2 wrote
+	AssertNotErr(t, os.WriteFile(path.Join(dir, "tasks.json"), []byte(`{"version": "2.0.0", "tasks": [{"label": "build"}, {"label": "sm2: start OLD"}]}`), 0644))
+
+	sm := ServiceManager{
+		Services: Services{
+			"AUTH":     {Id: "AUTH", DefaultPort: 8500},
+			"PAYMENTS": {Id: "PAYMENTS", DefaultPort: 9050, DebugPort: 5005},
+			"QA_API":   {Id: "QA_API", DefaultPort: 9100, Type: TunnelType},
+		},
+		Profiles: Profiles{"CORE": {"AUTH", "PAYMENTS", "QA_API"}},
+		Commands: cli.UserOption{ExtraServices: []string{"CORE"}},
+		Ledger: ledger.Ledger{LoadStateFile: func(string) (ledger.StateFile, error) {
+			return ledger.StateFile{}, fmt.Errorf("not running")
+		}},
+	}
+
+	out := bytes.Buffer{}
+	AssertNotErr(t, sm.ExportVscode(dir, &out))
+
+	tasks := struct {
+		Tasks []struct {
+			Label string   `json:"label"`
+			Args  []string `json:"args"`
+		} `json:"tasks"`
+	}{}
+	data, err := os.ReadFile(path.Join(dir, "tasks.json"))
+	AssertNotErr(t, err)
+	AssertNotErr(t, json.Unmarshal(data, &tasks))
+	labels := []string{}
+	for _, task := range tasks.Tasks {
+		labels = append(labels, task.Label)
+	}
+	expected := []string{"build", "sm2: start CORE", "sm2: start CORE with debugger", "sm2: stop CORE", "sm2: status"}
+	if fmt.Sprint(labels) != fmt.Sprint(expected) {
+		t.Errorf("expected %v got %v", expected, labels)
+	}
+	if fmt.Sprint(tasks.Tasks[2].Args) != "[-start CORE -noprogress -jdwp]" {
+		t.Errorf("expected the debug task to use -jdwp, got %v", tasks.Tasks[2].Args)
+	}
+
+	launch := struct {
+		Version        string `json:"version"`
+		Configurations []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"configurations"`
+	}{}
+	data, err = os.ReadFile(path.Join(dir, "launch.json"))
+	AssertNotErr(t, err)
+	AssertNotErr(t, json.Unmarshal(data, &launch))
+	if launch.Version != "0.2.0" || len(launch.Configurations) != 2 {
+		t.Fatalf("expected a config for each jvm service, got %+v", launch)
+	}
+	if launch.Configurations[0].Name != "sm2: attach to AUTH" || launch.Configurations[0].Port != 18500 || launch.Configurations[1].Port != 5005 {
+		t.Errorf("unexpected configs %+v", launch.Configurations)
+	}
+
+	// files with comments are left alone
+	AssertNotErr(t, os.WriteFile(path.Join(dir, "launch.json"), []byte("// my configs\n{}"), 0644))
+	if err := sm.ExportVscode(dir, &out); err == nil {
+		t.Errorf("expected a file with comments not to be updated")
+	}
+}
@@ -0,0 +1,153 @@
+package servicemanager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const exitStatusFileName = "exit_status"
+const whyFailedLogLines = 20
+
+// environment variables that typically affect how a service starts, shown by --why-failed. They're all that's
+// kept in the state file, the rest of the environment can have tokens and keys in it
+var whyFailedEnvVars = []string{"JAVA_HOME", "JAVA_OPTS", "JDK_JAVA_OPTIONS", "_JAVA_OPTIONS", "PATH"}
+
+// waits for a started process to exit and records how it exited in the service's log dir.
+// this only works while sm2 is still running (e.g. with --wait or --delay-seconds) since
+// the process is orphaned once sm2 exits, but it catches most services that fail on startup.
+func recordExitStatus(cmd *exec.Cmd, serviceDir string) {
+	err := cmd.Wait()
+	if cmd.ProcessState == nil {
+		return
+	}
+
+	status := cmd.ProcessState.String()
+	if err != nil && !strings.Contains(err.Error(), status) {
+		status = fmt.Sprintf("%s (%s)", status, err)
+	}
+	os.WriteFile(path.Join(serviceDir, "logs", exitStatusFileName), []byte(status+"\n"), 0644)
+}
+
+// removes the exit status of a previous run, so its not mistaken for the current one
+func clearExitStatus(serviceDir string) {
+	os.Remove(path.Join(serviceDir, "logs", exitStatusFileName))
+}
+
+// a one-screen post-mortem of why a service failed to start
+func (sm *ServiceManager) showWhyFailed(serviceName string) {
+
+	if _, ok := sm.Services[serviceName]; !ok {
+		fmt.Printf("Service %s is not in config!\n", serviceName)
+		return
+	}
+
+	installDir, _ := sm.findInstallDirOfService(serviceName)
+	installFile, err := sm.Ledger.LoadInstallFile(installDir)
+	if err != nil {
+		fmt.Printf("%s has not been installed, so it was never started.\n", serviceName)
+		return
+	}
+
+	stateFile, err := sm.Ledger.LoadStateFile(installDir)
+	if err != nil {
+		fmt.Printf("%s %s is installed but has no .state file, it was either never started or has been stopped/pruned.\n", serviceName, installFile.Version)
+		return
+	}
+
+	fmt.Printf("Service:    %s\n", stateFile.Service)
+	fmt.Printf("Version:    %s (%s)\n", stateFile.Version, installFile.Artifact)
+	fmt.Printf("Started:    %s\n", stateFile.Started.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("Port:       %d\n", stateFile.Port)
+
+	// how it was started
+	fmt.Printf("Command:    %s\n", formatCommand(stateFile.Cmd, stateFile.Args))
+	fmt.Printf("Directory:  %s\n", stateFile.Path)
+
+	// state files saved by older versions of sm2 have the whole environment
+	fmt.Println("Environment:")
+	for _, e := range filterEnv(stateFile.Env, whyFailedEnvVars) {
+		fmt.Printf("  %s\n", e)
+	}
+
+	// how it ended
+	pids := sm.Platform.PidLookup()
+	if _, running := pids[stateFile.Pid]; running {
+		fmt.Printf("Exit:       still running as pid %d\n", stateFile.Pid)
+	} else if status, err := os.ReadFile(path.Join(stateFile.Path, "logs", exitStatusFileName)); err == nil {
+		fmt.Printf("Exit:       %s", status)
+	} else {
+		fmt.Printf("Exit:       pid %d is no longer running (exit code was not captured, start with --wait to record it)\n", stateFile.Pid)
+	}
+
+	// what it said
+	logFile := sm.Services[serviceName].Output.stdoutFile(sm.Services[serviceName].Output.logDir(serviceName, stateFile.Path))
+	if logFile == "" {
+		fmt.Printf("\nNo logs to show, stdout is configured to go to %s\n", sm.Services[serviceName].Output.Stdout)
+		return
+	}
+	lines, err := tailFile(logFile, whyFailedLogLines)
+	if err != nil {
+		fmt.Printf("Unable to read %s: %s\n", logFile, err)
+		return
+	}
+	fmt.Printf("\nLast %d lines of %s:\n", len(lines), logFile)
+	for _, l := range lines {
+		fmt.Printf("  %s\n", l)
+	}
+}
+
+// formats a command and its args so they can be copied and pasted into a shell
+func formatCommand(cmd string, args []string) string {
+	// the placeholder for one that wasn't recorded isn't something to run, so isn't quoted
+	parts := []string{"(unknown, service was started by an older version of sm2)"}
+	if cmd != "" {
+		parts[0] = shellQuote(cmd)
+	}
+	for _, a := range args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+// returns only the environment variables in the allow list
+func filterEnv(env []string, allowed []string) []string {
+	filtered := []string{}
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		for _, a := range allowed {
+			if name == a {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// returns the last n lines of a file
+func tailFile(file string, n int) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return tail(f, n)
+}
+
+func tail(r io.Reader, n int) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
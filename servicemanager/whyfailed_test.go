@@ -0,0 +1,48 @@
+package servicemanager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestTailReturnsLastLines(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	res, err := tail(strings.NewReader(strings.Join(lines, "\n")), 3)
+	AssertNotErr(t, err)
+
+	if !reflect.DeepEqual(res, []string{"line 47", "line 48", "line 49"}) {
+		t.Errorf("unexpected tail: %v", res)
+	}
+
+	res, err = tail(strings.NewReader("only\n"), 3)
+	AssertNotErr(t, err)
+	if !reflect.DeepEqual(res, []string{"only"}) {
+		t.Errorf("unexpected tail: %v", res)
+	}
+}
+
+func TestFormatCommandQuotesArgs(t *testing.T) {
+	cmd := formatCommand("/tmp/foo/bin/foo", []string{"-Dfoo=bar", "-Dmsg=hello world"})
+	if cmd != `/tmp/foo/bin/foo -Dfoo=bar '-Dmsg=hello world'` {
+		t.Errorf("unexpected command: %s", cmd)
+	}
+	if cmd := formatCommand("", []string{"-Dfoo=bar"}); cmd != "(unknown, service was started by an older version of sm2) -Dfoo=bar" {
+		t.Errorf("expected a command that wasn't recorded not to be quoted, got %s", cmd)
+	}
+}
+
+func TestFilterEnv(t *testing.T) {
+	env := []string{"JAVA_HOME=/opt/java", "SECRET=shh", "PATH=/bin", "JAVA_HOMEX=nope"}
+	res := filterEnv(env, whyFailedEnvVars)
+	if !reflect.DeepEqual(res, []string{"JAVA_HOME=/opt/java", "PATH=/bin"}) {
+		t.Errorf("unexpected env: %v", res)
+	}
+}
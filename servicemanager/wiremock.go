@@ -0,0 +1,193 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"time"
+
+	"sm2/ledger"
+)
+
+// Services with "type": "wiremock" are WireMock stubs, to fill the gaps in a profile with services that
+// aren't worth running locally, or can't be, e.g.
+//
+//	"PAYMENTS_STUB": {"type": "wiremock", "defaultPort": 9050, "wiremock": {"mappings": "stubs/payments"}}
+//
+// The mappings dir is relative to service-manager-config, and is either a dir of mapping json files, or a
+// WireMock root dir with mappings and __files in it. It's copied into the service's install dir each time
+// the stub starts, so a restart picks up changes to it, and anything recorded or added through the admin
+// api is gone once it's stopped.
+//
+// The standalone jar is downloaded from maven central the first time it's needed (or from wiremock.jar,
+// e.g. a mirror in artifactory) and run with java. The stub is healthy once its admin api answers.
+
+const (
+	WiremockType           = "wiremock"
+	defaultWiremockVersion = "3.9.1"
+	wiremockJarUrl         = "https://repo1.maven.org/maven2/org/wiremock/wiremock-standalone/%s/wiremock-standalone-%s.jar"
+	// shared by all the stubs, in the workspace's install dir
+	wiremockCacheDir = ".wiremock"
+)
+
+type Wiremock struct {
+	Mappings string `json:"mappings"` // relative to service-manager-config
+	Version  string `json:"version"`  // of wiremock-standalone, defaults to 3.9.1
+	Jar      string `json:"jar"`      // a url to download the jar from, instead of maven central
+}
+
+func wiremockVersion(service Service) string {
+	if service.Wiremock.Version != "" {
+		return service.Wiremock.Version
+	}
+	return defaultWiremockVersion
+}
+
+func wiremockHealthcheckUrl(port int) string {
+	return fmt.Sprintf("http://localhost:%d/__admin/mappings", port)
+}
+
+// the path of the jar for the stub, downloading it if it's not already been
+func (sm *ServiceManager) wiremockJar(service Service) (string, error) {
+	version := wiremockVersion(service)
+	url := service.Wiremock.Jar
+	if url == "" {
+		url = fmt.Sprintf(wiremockJarUrl, version, version)
+	}
+	jar := path.Join(sm.Config.TmpDir, wiremockCacheDir, path.Base(url))
+	if Exists(jar) {
+		return jar, nil
+	}
+	if sm.Commands.Offline {
+		return "", fmt.Errorf("%s needs %s, which isn't available offline", service.Id, path.Base(url))
+	}
+
+	if err := os.MkdirAll(path.Dir(jar), 0755); err != nil {
+		return "", err
+	}
+	resp, err := sm.Client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http GET %s failed with status %s, expected 200", url, resp.Status)
+	}
+
+	// written alongside and renamed, as other stubs starting at the same time may want it too
+	tmp, err := os.CreateTemp(path.Dir(jar), "download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return jar, os.Rename(tmp.Name(), jar)
+}
+
+// copies the stub's mappings into rootDir, replacing what was there
+func seedWiremock(mappingsDir string, rootDir string) error {
+	if info, err := os.Stat(mappingsDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("the wiremock mappings %s aren't a directory", mappingsDir)
+	}
+	if err := os.RemoveAll(rootDir); err != nil {
+		return err
+	}
+
+	// a dir of mappings, rather than a root dir with mappings in it
+	target := rootDir
+	if !Exists(path.Join(mappingsDir, "mappings")) {
+		target = path.Join(rootDir, "mappings")
+	}
+	if err := os.MkdirAll(path.Join(rootDir, "__files"), 0755); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(mappingsDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(mappingsDir, file)
+		if err != nil {
+			return err
+		}
+		dest := path.Join(target, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
+	})
+}
+
+func (sm *ServiceManager) startWiremock(service Service, installDir string, port int, healthcheckUrl string) (ledger.StateFile, error) {
+	jar, err := sm.wiremockJar(service)
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+
+	rootDir := path.Join(installDir, "root")
+	if err := seedWiremock(path.Join(sm.Config.ConfigDir, service.Wiremock.Mappings), rootDir); err != nil {
+		return ledger.StateFile{}, err
+	}
+	clearExitStatus(installDir)
+
+	stdout, stderr, err := service.Output.open(service.Id, service.Output.logDir(service.Id, installDir))
+	if err != nil {
+		return ledger.StateFile{}, err
+	}
+	defer closeOutputs(stdout, stderr)
+
+	bindAddress := sm.bindAddress(service)
+	args := []string{"-jar", jar, "--port", fmt.Sprint(port), "--bind-address", bindAddress, "--root-dir", rootDir, "--disable-banner"}
+	cmd := exec.Command(javaPath(), args...)
+	cmd.Dir = installDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = os.Environ()
+
+	if err := cmd.Start(); err != nil {
+		return ledger.StateFile{}, err
+	}
+	go recordExitStatus(cmd, installDir)
+
+	return ledger.StateFile{
+		Service:        service.Id,
+		Artifact:       "wiremock-standalone",
+		Version:        wiremockVersion(service),
+		Path:           installDir,
+		Started:        time.Now(),
+		Pid:            cmd.Process.Pid,
+		Port:           port,
+		BindAddress:    bindAddress,
+		Args:           args,
+		HealthcheckUrl: healthcheckUrl,
+		Cmd:            cmd.Path,
+		Env:            filterEnv(cmd.Env, whyFailedEnvVars),
+	}, nil
+}
+
+// mistakes in wiremock config that would otherwise only show up when the stub starts
+func lintWiremock(id string, s Service) []string {
+	problems := []string{}
+	if s.Type == WiremockType && s.Wiremock.Mappings == "" {
+		problems = append(problems, fmt.Sprintf("%s is a wiremock stub but has no wiremock.mappings", id))
+	}
+	if s.Type == WiremockType && s.DefaultPort == 0 {
+		problems = append(problems, fmt.Sprintf("%s is a wiremock stub but has no defaultPort", id))
+	}
+	return problems
+}
@@ -0,0 +1,89 @@
+package servicemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestSeedWiremockFromMappings(t *testing.T) {
+	mappings := t.TempDir()
+	rootDir := path.Join(t.TempDir(), "root")
+	AssertNotErr(t, os.WriteFile(path.Join(mappings, "status.json"), []byte(`{"request": {"url": "/status"}}`), 0644))
+	AssertNotErr(t, os.MkdirAll(path.Join(rootDir, "mappings"), 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(rootDir, "mappings", "recorded.json"), []byte(`{}`), 0644))
+
+	AssertNotErr(t, seedWiremock(mappings, rootDir))
+	if !Exists(path.Join(rootDir, "mappings", "status.json")) || !Exists(path.Join(rootDir, "__files")) {
+		t.Errorf("expected the mappings to be copied into the root dir")
+	}
+	if Exists(path.Join(rootDir, "mappings", "recorded.json")) {
+		t.Errorf("expected each start to be seeded from scratch")
+	}
+}
+
+func TestSeedWiremockFromRootDir(t *testing.T) {
+	src := t.TempDir()
+	rootDir := path.Join(t.TempDir(), "root")
+	AssertNotErr(t, os.MkdirAll(path.Join(src, "mappings", "payments"), 0755))
+	AssertNotErr(t, os.MkdirAll(path.Join(src, "__files"), 0755))
+	AssertNotErr(t, os.WriteFile(path.Join(src, "mappings", "payments", "status.json"), []byte(`{}`), 0644))
+	AssertNotErr(t, os.WriteFile(path.Join(src, "__files", "status.json"), []byte(`{"enabled": true}`), 0644))
+
+	AssertNotErr(t, seedWiremock(src, rootDir))
+	if !Exists(path.Join(rootDir, "mappings", "payments", "status.json")) || !Exists(path.Join(rootDir, "__files", "status.json")) {
+		t.Errorf("expected the root dir to be copied as it is")
+	}
+
+	if err := seedWiremock(path.Join(src, "missing"), rootDir); err == nil {
+		t.Errorf("expected missing mappings to be an error")
+	}
+}
+
+func TestWiremockJarIsDownloadedOnce(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("jar"))
+	}))
+	defer server.Close()
+
+	sm := ServiceManager{Client: server.Client(), Config: ServiceManagerConfig{TmpDir: t.TempDir()}}
+	service := Service{Id: "PAYMENTS_STUB", Wiremock: Wiremock{Jar: server.URL + "/wiremock-standalone-3.9.1.jar"}}
+	for i := 0; i < 2; i++ {
+		jar, err := sm.wiremockJar(service)
+		AssertNotErr(t, err)
+		if jar != path.Join(sm.Config.TmpDir, wiremockCacheDir, "wiremock-standalone-3.9.1.jar") {
+			t.Errorf("unexpected jar %s", jar)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("expected the jar to be downloaded once, got %d", downloads)
+	}
+
+	sm.Commands = cli.UserOption{Offline: true}
+	if _, err := sm.wiremockJar(Service{Id: "PAYMENTS_STUB", Wiremock: Wiremock{Version: "3.0.0"}}); err == nil {
+		t.Errorf("expected a jar that hasn't been downloaded not to be available offline")
+	}
+}
+
+func TestWiremockHealthcheck(t *testing.T) {
+	sm := ServiceManager{}
+	if url := sm.serviceHealthcheckUrl(Service{Type: WiremockType}, 9050); url != "http://localhost:9050/__admin/mappings" {
+		t.Errorf("expected the admin api to be checked, got %s", url)
+	}
+}
+
+func TestLintWiremock(t *testing.T) {
+	if problems := append(lintTunnel("PAYMENTS_STUB", Service{Type: WiremockType}), lintWiremock("PAYMENTS_STUB", Service{Type: WiremockType})...); len(problems) != 2 {
+		t.Errorf("expected the missing mappings and port, got %v", problems)
+	}
+	if problems := lintWiremock("PAYMENTS_STUB", Service{Type: WiremockType, DefaultPort: 9050, Wiremock: Wiremock{Mappings: "stubs/payments"}}); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
@@ -0,0 +1,174 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"sm2/ledger"
+)
+
+// sm2s run at the same time in the same workspace (two terminals, or a CI job alongside) share its state, so
+// changes to it are made holding the workspace lock, install/.workspace.lock. If another sm2 holds it for longer
+// than the workspaceLockTimeout setting (60 secs by default) it gives up rather than waiting forever.
+//
+// Ports are the main thing they race on: a port chosen for a service isn't in its state file, or listened on,
+// until it's been installed and started, so each port that's handed out is also claimed in install/.ports
+// until then, and released once the service has started (or failed to). Another sm2 won't give a claimed
+// port to a different service. Installs are locked by install dir, see installlock.go.
+
+const (
+	workspaceLockFile     = ".workspace.lock"
+	portClaimsFile        = ".ports"
+	defaultWorkspaceLock  = 60 * time.Second
+	workspaceLockInterval = 50 * time.Millisecond
+	portClaimTtl          = 10 * time.Minute // long enough to install and start any service, if a claim isn't released
+)
+
+type portClaim struct {
+	Port    int
+	Service string
+	Pid     int // the sm2 that claimed it
+	Claimed time.Time
+}
+
+func (sm *ServiceManager) workspaceLockTimeout() time.Duration {
+	if secs, err := strconv.Atoi(sm.Commands.Settings["workspaceLockTimeout"]); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultWorkspaceLock
+}
+
+// runs f holding the workspace lock, waiting for another sm2 to release it first
+func (sm *ServiceManager) withWorkspaceLock(f func() error) error {
+	if err := os.MkdirAll(sm.Config.TmpDir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path.Join(sm.Config.TmpDir, workspaceLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	timeout := sm.workspaceLockTimeout()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("another sm2 has been using the workspace for more than %s, try again once it's finished", timeout)
+		}
+		select {
+		case <-sm.context().Done():
+			return ErrInterrupted
+		case <-time.After(workspaceLockInterval):
+		}
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return f()
+}
+
+// claims the port for the service, unless another sm2 has claimed it for a different one. Claims that
+// have expired, or whose sm2 has exited, are dropped
+func (sm *ServiceManager) claimPort(service string, port int) error {
+	return sm.withWorkspaceLock(func() error {
+		claims := loadPortClaims(sm.Config.TmpDir)
+		live := []portClaim{}
+		for _, claim := range claims {
+			if time.Since(claim.Claimed) > portClaimTtl || !pidAlive(claim.Pid) {
+				continue
+			}
+			if claim.Port == port && claim.Service != service && claim.Pid != os.Getpid() {
+				return fmt.Errorf("port %d is being used by %s, which another sm2 (pid %d) is starting", port, claim.Service, claim.Pid)
+			}
+			// a service only has one port claimed at a time
+			if claim.Service == service {
+				continue
+			}
+			live = append(live, claim)
+		}
+		live = append(live, portClaim{Port: port, Service: service, Pid: os.Getpid(), Claimed: time.Now()})
+		return savePortClaims(sm.Config.TmpDir, live)
+	})
+}
+
+// releases the service's port claim, now its port is in its state file or it's failed to start
+func (sm *ServiceManager) releasePort(service string) {
+	sm.withWorkspaceLock(func() error {
+		claims := loadPortClaims(sm.Config.TmpDir)
+		live := []portClaim{}
+		for _, claim := range claims {
+			if claim.Service != service || claim.Pid != os.Getpid() {
+				live = append(live, claim)
+			}
+		}
+		if len(live) == len(claims) {
+			return nil
+		}
+		return savePortClaims(sm.Config.TmpDir, live)
+	})
+}
+
+// saves a service's state file holding the workspace lock
+func (sm *ServiceManager) saveState(installDir string, state ledger.StateFile) error {
+	return sm.withWorkspaceLock(func() error {
+		return sm.Ledger.SaveStateFile(installDir, state)
+	})
+}
+
+// removes a service's state file holding the workspace lock
+func (sm *ServiceManager) clearState(installDir string) error {
+	return sm.withWorkspaceLock(func() error {
+		return sm.Ledger.ClearStateFile(installDir)
+	})
+}
+
+// changes a service's state file holding the workspace lock, so it's not changed by another sm2 in between
+// reading and saving it. Nothing is saved if update fails
+func (sm *ServiceManager) updateState(installDir string, update func(state *ledger.StateFile) error) error {
+	return sm.withWorkspaceLock(func() error {
+		state, err := sm.Ledger.LoadStateFile(installDir)
+		if err != nil {
+			return err
+		}
+		if err := update(&state); err != nil {
+			return err
+		}
+		return sm.Ledger.SaveStateFile(installDir, state)
+	})
+}
+
+func loadPortClaims(tmpDir string) []portClaim {
+	claims := []portClaim{}
+	if data, err := os.ReadFile(path.Join(tmpDir, portClaimsFile)); err == nil {
+		json.Unmarshal(data, &claims)
+	}
+	return claims
+}
+
+func savePortClaims(tmpDir string, claims []portClaim) error {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(tmpDir, portClaimsFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(tmpDir, portClaimsFile))
+}
+
+func pidAlive(pid int) bool {
+	// EPERM is a process that belongs to someone else
+	err := syscall.Kill(pid, 0)
+	return pid > 0 && (err == nil || err == syscall.EPERM)
+}
@@ -0,0 +1,66 @@
+package servicemanager
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	. "sm2/testing"
+)
+
+func TestClaimPort(t *testing.T) {
+	sm := ServiceManager{Config: ServiceManagerConfig{TmpDir: t.TempDir()}}
+
+	// claimed by another sm2 that's still running, one that's exited and one that's expired
+	other := os.Getppid()
+	AssertNotErr(t, savePortClaims(sm.Config.TmpDir, []portClaim{
+		{Port: 9000, Service: "BAR", Pid: other, Claimed: time.Now()},
+		{Port: 9001, Service: "BAZ", Pid: 1 << 30, Claimed: time.Now()},
+		{Port: 9002, Service: "QUX", Pid: other, Claimed: time.Now().Add(-portClaimTtl - time.Minute)},
+	}))
+
+	if err := sm.claimPort("FOO", 9000); err == nil {
+		t.Errorf("expected a port another sm2 is starting BAR on not to be given to FOO")
+	}
+	AssertNotErr(t, sm.claimPort("BAR", 9000))
+	AssertNotErr(t, sm.claimPort("FOO", 9001))
+	AssertNotErr(t, sm.claimPort("FOO", 9002))
+
+	claims := loadPortClaims(sm.Config.TmpDir)
+	if len(claims) != 2 || claims[0].Service != "BAR" || claims[1].Service != "FOO" || claims[1].Port != 9002 {
+		t.Errorf("expected BAR's claim and FOO's latest one to be kept, got %v", claims)
+	}
+
+	// once FOO has started its port's free for others
+	sm.releasePort("FOO")
+	if claims := loadPortClaims(sm.Config.TmpDir); len(claims) != 1 || claims[0].Service != "BAR" {
+		t.Errorf("expected FOO's claim to be released, got %v", claims)
+	}
+}
+
+func TestWorkspaceLockTimesOut(t *testing.T) {
+	sm := ServiceManager{
+		Config:   ServiceManagerConfig{TmpDir: t.TempDir()},
+		Commands: cli.UserOption{Settings: map[string]string{"workspaceLockTimeout": "0"}},
+	}
+
+	// another sm2 holding the lock
+	file, err := os.OpenFile(path.Join(sm.Config.TmpDir, workspaceLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	AssertNotErr(t, err)
+	defer file.Close()
+	AssertNotErr(t, syscall.Flock(int(file.Fd()), syscall.LOCK_EX))
+
+	ran := false
+	if err := sm.withWorkspaceLock(func() error { ran = true; return nil }); err == nil || ran {
+		t.Errorf("expected to give up waiting for the workspace")
+	}
+
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	AssertNotErr(t, sm.withWorkspaceLock(func() error { ran = true; return nil }))
+	if !ran {
+		t.Errorf("expected to run once the workspace was free")
+	}
+}
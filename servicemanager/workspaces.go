@@ -0,0 +1,54 @@
+package servicemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"sm2/cli"
+)
+
+// handles --use-workspace NAME, or NAME=/path/to/workspace to add one that isn't in ~/.sm2/workspaces
+func UseWorkspace(arg string, out io.Writer) error {
+	name, dir, _ := strings.Cut(arg, "=")
+	dir, err := cli.UseWorkspace(cli.WorkspacesFile(), name, dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Now using the %s workspace (%s)\n", name, dir)
+	if env, isSet := os.LookupEnv("WORKSPACE"); isSet {
+		fmt.Fprintf(out, "Warning: WORKSPACE is set to %s, which takes precedence. Unset it to use named workspaces.\n", env)
+	} else if !Exists(path.Join(dir, "service-manager-config")) {
+		fmt.Fprintln(out, "It doesn't have any config yet, run `sm2 --init` to set it up.")
+	}
+	return nil
+}
+
+// handles --workspaces, listing the named workspaces with the current one marked
+func PrintWorkspaces(out io.Writer) error {
+	workspaces, err := cli.LoadWorkspaces(cli.WorkspacesFile())
+	if err != nil {
+		return err
+	}
+	current, _ := cli.Workspace()
+
+	for _, name := range workspaces.Names() {
+		dir := workspaces.Paths[name]
+		if name == cli.DefaultWorkspaceName {
+			dir = path.Dir(cli.WorkspacesFile())
+		}
+		marker := " "
+		if dir == current {
+			marker = "*"
+		}
+		fmt.Fprintf(out, "%s %-20s %s\n", marker, name, dir)
+	}
+
+	if env, isSet := os.LookupEnv("WORKSPACE"); isSet {
+		fmt.Fprintf(out, "\nWORKSPACE is set to %s, which takes precedence over the named workspaces.\n", env)
+	}
+	return nil
+}
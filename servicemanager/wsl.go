@@ -0,0 +1,73 @@
+package servicemanager
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"sm2/platform"
+)
+
+// sm2 runs on the linux side of the windows subsystem for linux. Paths copied from windows, e.g.
+// WORKSPACE=C:\Users\me\sm2, are translated to the ones linux sees, a windows jdk in JAVA_HOME is passed
+// over for the linux one, and with wsl 2's default nat networking the ports in use on windows are checked
+// too, since windows only forwards localhost:PORT to a service in wsl when nothing on windows has the port.
+
+// how long the ports in use on windows are trusted for, as asking windows is slow
+const windowsPortsTtl = 10 * time.Second
+
+func (sm *ServiceManager) wsl() platform.Wsl {
+	if sm.Platform.Wsl == nil {
+		return platform.Wsl{}
+	}
+	return sm.Platform.Wsl()
+}
+
+// true if something on windows is listening on the port, when windows' ports are separate from linux's
+func (sm *ServiceManager) windowsPortInUse(port int) bool {
+	wsl := sm.wsl()
+	if !wsl.SeparateNetwork() {
+		return false
+	}
+	if time.Since(sm.windowsPortsChecked) > windowsPortsTtl {
+		ports, err := wsl.WindowsListeners()
+		if err != nil {
+			sm.PrintVerbose("unable to check the ports in use on windows: %s\n", err)
+			ports = map[int]bool{}
+		}
+		sm.windowsPorts, sm.windowsPortsChecked = ports, time.Now()
+	}
+	return sm.windowsPorts[port]
+}
+
+// a windows jdk can be seen from wsl but not run as linux java
+func windowsJavaHome(javaHome string) bool {
+	wsl := platform.DetectWsl()
+	return wsl.IsWsl() && (platform.IsWindowsPath(javaHome) || wsl.OnWindowsDrive(javaHome))
+}
+
+func checkWsl(config ServiceManagerConfig, wsl platform.Wsl) {
+	if !wsl.IsWsl() {
+		return
+	}
+	network := ""
+	if wsl.Version == 2 {
+		network = fmt.Sprintf(", %s networking", wsl.Networking)
+		if wsl.HostIp != "" {
+			network += ", windows is " + wsl.HostIp
+		}
+	}
+	fmt.Printf("WSL:\t\t OK (%s, WSL %d%s)\n", wsl.Distro, wsl.Version, network)
+
+	if wsl.OnWindowsDrive(config.WorkspaceDir) {
+		fmt.Printf("\t\t WARN: the workspace is on a windows drive, which is much slower to install to and run from than\n\t\t the linux filesystem, e.g. ~/.sm2\n")
+	}
+	if javaHome := os.Getenv("JAVA_HOME"); javaHome != "" && windowsJavaHome(javaHome) {
+		fmt.Printf("\t\t WARN: JAVA_HOME is a windows jdk (%s), so the java on the path is used instead\n", javaHome)
+	}
+	if wsl.SeparateNetwork() {
+		if _, err := wsl.WindowsListeners(); err != nil {
+			fmt.Printf("\t\t WARN: unable to check the ports in use on windows, so clashes with them won't be found: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package servicemanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sm2/cli"
+	"sm2/platform"
+)
+
+func TestPortInUseOnWindows(t *testing.T) {
+	sm := ServiceManager{
+		Config:              ServiceManagerConfig{PortRanges: []cli.PortRange{{From: 18000, To: 18999}}},
+		Platform:            platform.Platform{Wsl: func() platform.Wsl { return platform.Wsl{Version: 2, Networking: "nat"} }},
+		windowsPorts:        map[int]bool{18050: true},
+		windowsPortsChecked: time.Now(),
+	}
+	if problem := sm.portProblem(18050, map[int]string{}); !strings.Contains(problem, "in use on windows") {
+		t.Errorf("expected the port to be in use on windows, got %q", problem)
+	}
+	if problem := sm.portProblem(18051, map[int]string{}); problem != "" {
+		t.Errorf("expected the port to be free, got %q", problem)
+	}
+
+	sm.Platform.Wsl = func() platform.Wsl { return platform.Wsl{Version: 2, Networking: "mirrored"} }
+	if sm.windowsPortInUse(18050) {
+		t.Errorf("expected windows' ports to be checked as linux's with mirrored networking")
+	}
+}
@@ -0,0 +1,520 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A minimal YAML parser, enough for services.yaml and profiles.yaml without adding a dependency.
+// It supports the parts of YAML that are useful in config files:
+//
+//	block maps and lists, flow [lists] and {maps}, quoted and plain scalars,
+//	comments, anchors (&name), aliases (*name) and merge keys (<<: *name)
+//
+// Block scalars (| and >), tags, multi-line plain/quoted scalars and multiple documents are not supported.
+// The parsed document is converted to json so it can be loaded exactly like the json config.
+// Top level keys starting with a . are left out, so they can hold shared settings for anchors.
+
+type yamlError struct {
+	line int
+	msg  string
+}
+
+func (e *yamlError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string // without indentation or comments
+}
+
+type yamlParser struct {
+	lines   []yamlLine
+	pos     int
+	anchors map[string]interface{}
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+func isYamlFile(file string) bool {
+	ext := path.Ext(file)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func yamlToJson(data []byte) ([]byte, error) {
+	doc, err := parseYaml(data)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := doc.(map[string]interface{}); ok {
+		for k := range m {
+			if strings.HasPrefix(k, ".") {
+				delete(m, k)
+			}
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func parseYaml(data []byte) (interface{}, error) {
+	p := &yamlParser{anchors: map[string]interface{}{}}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripYamlComment(strings.TrimRight(raw, "\r")), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, &yamlError{i + 1, "tabs can't be used for indentation"}
+		}
+		if trimmed == "---" || trimmed == "..." {
+			if len(p.lines) > 0 {
+				return nil, &yamlError{i + 1, "multiple documents are not supported"}
+			}
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, len(text) - len(trimmed), trimmed})
+	}
+
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	doc, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorAt(p.lines[p.pos], "unexpected content, check the indentation")
+	}
+	return doc, nil
+}
+
+func (p *yamlParser) errorAt(line yamlLine, msg string) error {
+	return &yamlError{line.num, msg}
+}
+
+// parses whatever starts on the current line
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+
+	if strings.HasPrefix(line.text, "&") {
+		parts := strings.SplitN(line.text, " ", 2)
+		anchor := parts[0][1:]
+		var value interface{}
+		var err error
+		if len(parts) == 1 {
+			p.pos++
+			value, err = p.parseNested(indent)
+		} else {
+			// carry on parsing the rest of the line as if the anchor wasn't there
+			rest := strings.TrimLeft(parts[1], " ")
+			p.lines[p.pos] = yamlLine{line.num, indent + len(line.text) - len(rest), rest}
+			value, err = p.parseBlock(p.lines[p.pos].indent)
+		}
+		p.anchors[anchor] = value
+		return value, err
+	}
+
+	if isYamlListItem(line.text) {
+		return p.parseList(indent)
+	}
+	if _, _, ok := splitYamlKey(line.text); ok {
+		return p.parseMap(indent)
+	}
+
+	p.pos++
+	return p.parseInline(line.text, line)
+}
+
+// parses a value on the lines following a key or list item, or null if there isn't one
+func (p *yamlParser) parseNested(parentIndent int) (interface{}, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > parentIndent {
+		return p.parseBlock(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseList(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYamlListItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		var item interface{}
+		var err error
+		if rest == "" {
+			p.pos++
+			item, err = p.parseNested(indent)
+		} else {
+			// the item starts on the same line as the -, parse it as if it were indented on its own line
+			p.lines[p.pos] = yamlLine{line.num, indent + len(line.text) - len(rest), rest}
+			item, err = p.parseBlock(p.lines[p.pos].indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	type merge struct {
+		line  yamlLine
+		value interface{}
+	}
+
+	m := map[string]interface{}{}
+	merges := []merge{}
+
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isYamlListItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		key, rest, ok := splitYamlKey(line.text)
+		if !ok {
+			return nil, p.errorAt(line, "expected key: value")
+		}
+		p.pos++
+
+		var value interface{}
+		var err error
+		if rest == "" || (strings.HasPrefix(rest, "&") && !strings.Contains(rest, " ")) {
+			// the value is on the following lines, lists are allowed at the same indent as the key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYamlListItem(p.lines[p.pos].text) {
+				value, err = p.parseList(indent)
+			} else {
+				value, err = p.parseNested(indent)
+			}
+			if rest != "" {
+				p.anchors[rest[1:]] = value
+			}
+		} else {
+			value, err = p.parseInline(rest, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "<<" {
+			merges = append(merges, merge{line, value})
+			continue
+		}
+		if _, duplicate := m[key]; duplicate {
+			return nil, p.errorAt(line, fmt.Sprintf("%s is defined more than once", key))
+		}
+		m[key] = value
+	}
+
+	// keys set explicitly win over merged ones, and earlier merges win over later ones
+	for _, mg := range merges {
+		sources := []interface{}{mg.value}
+		if list, ok := mg.value.([]interface{}); ok {
+			sources = list
+		}
+		for _, source := range sources {
+			sourceMap, ok := source.(map[string]interface{})
+			if !ok {
+				return nil, p.errorAt(mg.line, "<< can only merge maps")
+			}
+			for k, v := range sourceMap {
+				if _, set := m[k]; !set {
+					m[k] = v
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// parses a value that's on the same line as its key or list item
+func (p *yamlParser) parseInline(text string, line yamlLine) (interface{}, error) {
+	switch text[0] {
+	case '&':
+		parts := strings.SplitN(text, " ", 2)
+		var value interface{}
+		var err error
+		if len(parts) == 2 {
+			value, err = p.parseInline(strings.TrimLeft(parts[1], " "), line)
+		}
+		p.anchors[parts[0][1:]] = value
+		return value, err
+
+	case '*':
+		value, ok := p.anchors[text[1:]]
+		if !ok {
+			return nil, p.errorAt(line, fmt.Sprintf("unknown alias %s", text))
+		}
+		return value, nil
+
+	case '|', '>':
+		return nil, p.errorAt(line, "block scalars (| and >) are not supported, use a quoted string")
+
+	case '!':
+		return nil, p.errorAt(line, "tags are not supported")
+
+	case '[', '{':
+		// flow collections can span several lines
+		for !yamlFlowClosed(text) && p.pos < len(p.lines) {
+			text += " " + p.lines[p.pos].text
+			p.pos++
+		}
+		f := &yamlFlow{text: text, parser: p, line: line}
+		value, err := f.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.i < len(f.text) {
+			return nil, p.errorAt(line, fmt.Sprintf("unexpected %q after %s", f.text[f.i:], f.text[:f.i]))
+		}
+		return value, nil
+	}
+
+	return parseYamlScalar(text, line)
+}
+
+func parseYamlScalar(text string, line yamlLine) (interface{}, error) {
+	switch text[0] {
+	case '"':
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, &yamlError{line.num, fmt.Sprintf("invalid quoted string %s", text)}
+		}
+		return s, nil
+	case '\'':
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, &yamlError{line.num, fmt.Sprintf("invalid quoted string %s", text)}
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+
+	switch text {
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+
+	if yamlNumber.MatchString(text) {
+		return json.Number(strings.TrimPrefix(text, "+")), nil
+	}
+	return text, nil
+}
+
+// parses [lists] and {maps} written on one line
+type yamlFlow struct {
+	text   string
+	i      int
+	parser *yamlParser
+	line   yamlLine
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.i < len(f.text) && f.text[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) errorf(format string, a ...interface{}) error {
+	return &yamlError{f.line.num, fmt.Sprintf(format, a...)}
+}
+
+func (f *yamlFlow) parseValue() (interface{}, error) {
+	f.skipSpace()
+	if f.i >= len(f.text) {
+		return nil, f.errorf("unexpected end of %s", f.text)
+	}
+
+	switch f.text[f.i] {
+	case '[':
+		f.i++
+		list := []interface{}{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.text) && f.text[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			item, err := f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+
+	case '{':
+		f.i++
+		m := map[string]interface{}{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.text) && f.text[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			key, err := f.parseScalar(true)
+			if err != nil {
+				return nil, err
+			}
+			f.skipSpace()
+			if f.i >= len(f.text) || f.text[f.i] != ':' {
+				return nil, f.errorf("expected : after %v in %s", key, f.text)
+			}
+			f.i++
+			value, err := f.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return f.parseScalar(false)
+}
+
+// consumes a , between items, or checks we're at the closing bracket
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	if f.i < len(f.text) && f.text[f.i] == ',' {
+		f.i++
+		return nil
+	}
+	if f.i < len(f.text) && f.text[f.i] == closing {
+		return nil
+	}
+	return f.errorf("expected , or %c in %s", closing, f.text)
+}
+
+func (f *yamlFlow) parseScalar(isKey bool) (interface{}, error) {
+	start := f.i
+	if f.text[f.i] == '"' || f.text[f.i] == '\'' {
+		end := yamlQuoteEnd(f.text, f.i)
+		if end < 0 {
+			return nil, f.errorf("unterminated string in %s", f.text)
+		}
+		f.i = end + 1
+	} else {
+		for f.i < len(f.text) && !strings.ContainsRune(",[]{}", rune(f.text[f.i])) {
+			if isKey && f.text[f.i] == ':' {
+				break
+			}
+			f.i++
+		}
+	}
+
+	token := strings.TrimSpace(f.text[start:f.i])
+	if token == "" {
+		return nil, f.errorf("missing value in %s", f.text)
+	}
+	if strings.HasPrefix(token, "*") || strings.HasPrefix(token, "&") {
+		return f.parser.parseInline(token, f.line)
+	}
+	return parseYamlScalar(token, f.line)
+}
+
+func isYamlListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splits `key: value` into its parts
+func splitYamlKey(text string) (string, string, bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := yamlQuoteEnd(text, 0)
+		if end < 0 {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[end+1:], " ")
+		if !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+			return "", "", false
+		}
+		key, err := parseYamlScalar(text[:end+1], yamlLine{})
+		if err != nil {
+			return "", "", false
+		}
+		return key.(string), strings.TrimLeft(rest[1:], " "), true
+	}
+
+	if strings.ContainsRune("[{&*", rune(text[0])) {
+		return "", "", false
+	}
+
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	if i := strings.Index(text, ": "); i > 0 {
+		return strings.TrimSpace(text[:i]), strings.TrimLeft(text[i+2:], " "), true
+	}
+	return "", "", false
+}
+
+// returns the index of the quote that closes the string starting at start, or -1
+func yamlQuoteEnd(text string, start int) int {
+	quote := text[start]
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// removes a trailing # comment, ignoring #s inside quoted strings
+func stripYamlComment(line string) string {
+	last := byte(0) // the last non space character
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case (c == '"' || c == '\'') && strings.IndexByte("\x00:-[{,", last) >= 0:
+			end := yamlQuoteEnd(line, i)
+			if end < 0 {
+				return line
+			}
+			i = end
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+		if c != ' ' && c != '\t' {
+			last = line[i]
+		}
+	}
+	return line
+}
+
+// checks the brackets in a flow collection are balanced
+func yamlFlowClosed(text string) bool {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			end := yamlQuoteEnd(text, i)
+			if end < 0 {
+				return false
+			}
+			i = end
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
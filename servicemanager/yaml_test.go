@@ -0,0 +1,132 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	. "sm2/testing"
+)
+
+func TestParseYamlConfig(t *testing.T) {
+	yaml := `
+# shared defaults
+defaults: &play
+  frontend: false
+  binary:
+    groupId: uk.gov.hmrc   # inline comment
+    cmd: ["./bin/run", "-Dhttp.port=8080"]
+
+FOO:
+  <<: *play
+  name: "Foo # not a comment"
+  defaultPort: 8000
+  frontend: true
+  healthcheck: {url: "http://localhost:8000/ping", response: pong}
+BAR:
+  name: 'it''s bar'
+  defaultPort: 9000
+  binary:
+    cmd:
+    - ./bin/bar
+    - -Dfoo=bar
+  nothing: ~
+`
+	doc, err := parseYaml([]byte(yaml))
+	AssertNotErr(t, err)
+
+	foo := doc.(map[string]interface{})["FOO"].(map[string]interface{})
+	if foo["name"] != "Foo # not a comment" {
+		t.Errorf("expected # in a quoted string to be kept, got %v", foo["name"])
+	}
+	if foo["frontend"] != true {
+		t.Errorf("expected explicit keys to win over merged ones, got %v", foo["frontend"])
+	}
+	if foo["defaultPort"] != json.Number("8000") {
+		t.Errorf("expected port to be a number, got %#v", foo["defaultPort"])
+	}
+	binary := foo["binary"].(map[string]interface{})
+	if binary["groupId"] != "uk.gov.hmrc" || !reflect.DeepEqual(binary["cmd"], []interface{}{"./bin/run", "-Dhttp.port=8080"}) {
+		t.Errorf("expected binary to be merged from the anchor, got %v", binary)
+	}
+	if foo["healthcheck"].(map[string]interface{})["response"] != "pong" {
+		t.Errorf("expected flow map to be parsed, got %v", foo["healthcheck"])
+	}
+
+	bar := doc.(map[string]interface{})["BAR"].(map[string]interface{})
+	if bar["name"] != "it's bar" {
+		t.Errorf("expected single quotes to be unescaped, got %v", bar["name"])
+	}
+	if !reflect.DeepEqual(bar["binary"].(map[string]interface{})["cmd"], []interface{}{"./bin/bar", "-Dfoo=bar"}) {
+		t.Errorf("expected block list to be parsed, got %v", bar["binary"])
+	}
+	if v, ok := bar["nothing"]; !ok || v != nil {
+		t.Errorf("expected ~ to be null, got %v", v)
+	}
+}
+
+func TestParseYamlListOfMaps(t *testing.T) {
+	doc, err := parseYaml([]byte("- name: a\n  port: 1\n- name: b\n-\n  - nested\n"))
+	AssertNotErr(t, err)
+
+	expected := []interface{}{
+		map[string]interface{}{"name": "a", "port": json.Number("1")},
+		map[string]interface{}{"name": "b"},
+		[]interface{}{"nested"},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("expected %v, got %v", expected, doc)
+	}
+}
+
+func TestParseYamlErrorsHaveLineNumbers(t *testing.T) {
+	tests := map[string]int{
+		"FOO:\n  name: a\n  name: b\n":   3, // duplicate key
+		"FOO:\n  cmd: |\n    run\n":      2, // block scalar
+		"FOO:\n  name: *missing\n":       2, // unknown alias
+		"FOO:\n  name: a\n    oops: b\n": 3, // bad indentation
+		"FOO:\n  cmd: [a, b\nBAR: c\n":   2, // unclosed flow list
+		"FOO: a\n---\nBAR: b\n":          2, // multiple documents
+	}
+
+	for yaml, line := range tests {
+		_, err := parseYaml([]byte(yaml))
+		yamlErr, ok := err.(*yamlError)
+		if !ok {
+			t.Errorf("expected a yaml error for %q, got %v", yaml, err)
+			continue
+		}
+		if yamlErr.line != line {
+			t.Errorf("expected error on line %d for %q, got %s", line, yaml, yamlErr)
+		}
+	}
+}
+
+func TestLoadServicesFromYaml(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "test-yaml*")
+	AssertNotErr(t, err)
+	defer os.RemoveAll(dir)
+
+	os.WriteFile(path.Join(dir, "services.yaml"), []byte(".shared: &shared\n  name: shared\nFOO:\n  name: foo\n  defaultPort: 8000\n  binary:\n    artifact: foo_%%\n    groupId: uk.gov.hmrc\n    cmd: [./foo/bin/foo]\n"), 0644)
+
+	file, err := findConfigFile(dir, "services")
+	AssertNotErr(t, err)
+
+	services, err := loadServicesFromFile(file, nil)
+	AssertNotErr(t, err)
+
+	foo := (*services)["FOO"]
+	if foo.Id != "FOO" || foo.DefaultPort != 8000 || foo.Binary.Artifact != "foo_%%" {
+		t.Errorf("expected FOO to be loaded from yaml, got %+v", foo)
+	}
+	if _, ok := (*services)[".shared"]; ok {
+		t.Errorf("expected top level keys starting with . to be ignored")
+	}
+
+	os.WriteFile(path.Join(dir, "services.json"), []byte("{}"), 0644)
+	if _, err := findConfigFile(dir, "services"); err == nil {
+		t.Errorf("expected an error when both services.json and services.yaml exist")
+	}
+}
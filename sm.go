@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"sm2/cli"
@@ -16,10 +14,16 @@ import (
 
 func main() {
 
-	cmds, err := cli.Parse(os.Args[1:])
+	defaults, err := cli.LoadDefaults(cli.DefaultsFile())
+	if err != nil {
+		fmt.Printf("Unable to read your defaults in %s: %s\n", cli.DefaultsFile(), err)
+		os.Exit(servicemanager.ExitConfig)
+	}
+
+	cmds, err := cli.ParseWithDefaults(os.Args[1:], defaults)
 	if err != nil {
 		fmt.Printf("Invalid option: %s\n", err)
-		os.Exit(1)
+		os.Exit(servicemanager.ExitUsage)
 	}
 
 	client := &http.Client{
@@ -34,22 +38,53 @@ func main() {
 		Ledger:   ledger.NewLedger(),
 	}
 
+	if cmds.Machine {
+		// events go to stdout, with everything else on stderr out of their way
+		serviceManager.StartMachineMode(os.Stdout)
+		os.Stdout = os.Stderr
+	}
+
+	// these run before there's any config to load
+	if cmds.Init || cmds.ImportV1 != "" || cmds.UseWorkspace != "" || cmds.Workspaces || cmds.ServeStub != "" || cmds.ServeCapture != "" {
+		if cmds.Init {
+			err = serviceManager.Init(cmds.ExtraServices)
+		} else if cmds.ImportV1 != "" {
+			err = servicemanager.ImportV1(cmds.ImportV1, os.Stdout, os.Stderr)
+		} else if cmds.UseWorkspace != "" {
+			err = servicemanager.UseWorkspace(cmds.UseWorkspace, os.Stdout)
+		} else if cmds.ServeStub != "" {
+			err = servicemanager.ServeStub(cmds.ServeStub)
+		} else if cmds.ServeCapture != "" {
+			err = servicemanager.ServeCapture(cmds.ServeCapture)
+		} else {
+			err = servicemanager.PrintWorkspaces(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the services are run by a daemon somewhere else, which has the config
+	if serviceManager.IsRemoteCommand() {
+		if err := serviceManager.RunRemote(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err = serviceManager.LoadConfig()
 	if err != nil {
 		fmt.Print(err)
-		os.Exit(1)
+		os.Exit(servicemanager.ExitConfig)
+	}
+	// --ci-start handles being interrupted itself, so it can stop the services it started
+	if !cmds.CiStart {
+		serviceManager.HandleInterrupts()
 	}
-	SetupCloseHandler(serviceManager)
 
 	serviceManager.Run()
-
-}
-func SetupCloseHandler(sm servicemanager.ServiceManager) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		sm.Ledger.ClearProxyState(sm.Config.TmpDir)
-		os.Exit(0)
-	}()
+	serviceManager.ExitIfInterrupted()
 }